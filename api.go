@@ -15,12 +15,16 @@ package webrisk
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/base64"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
@@ -33,6 +37,8 @@ const (
 	threatTypeString            = "threat_type"
 	versionTokenString          = "version_token"
 	supportedCompressionsString = "constraints.supported_compressions"
+	maxDiffEntriesString        = "constraints.max_diff_entries"
+	maxDatabaseEntriesString    = "constraints.max_database_entries"
 	hashPrefixString            = "hash_prefix"
 	threatTypesString           = "threat_types"
 	userAgentString             = "Webrisk-Client/0.2.1"
@@ -41,7 +47,7 @@ const (
 // The api interface specifies wrappers around the Web Risk API.
 type api interface {
 	ListUpdate(ctx context.Context, threatType pb.ThreatType, versionToken []byte,
-		compressionTypes []pb.CompressionType) (*pb.ComputeThreatListDiffResponse, error)
+		constraints *pb.ComputeThreatListDiffRequest_Constraints) (*pb.ComputeThreatListDiffResponse, error)
 	HashLookup(ctx context.Context, hashPrefix []byte,
 		threatTypes []pb.ThreatType) (*pb.SearchHashesResponse, error)
 }
@@ -54,9 +60,10 @@ type netAPI struct {
 
 // newNetAPI creates a new netAPI object pointed at the provided root URL.
 // For every request, it will use the provided API key.
-// If a proxy URL is given, it will be used in place of the default $HTTP_PROXY.
+// If transport is nil, http.DefaultTransport is used, which honors the
+// $HTTP_PROXY environment variable.
 // If the protocol is not specified in root, then this defaults to using HTTPS.
-func newNetAPI(root string, key string, proxy string) (*netAPI, error) {
+func newNetAPI(root string, key string, transport http.RoundTripper) (*netAPI, error) {
 	if !strings.Contains(root, "://") {
 		root = "https://" + root
 	}
@@ -65,20 +72,54 @@ func newNetAPI(root string, key string, proxy string) (*netAPI, error) {
 		return nil, err
 	}
 
-	httpClient := &http.Client{}
+	httpClient := &http.Client{Transport: transport}
 
-	if proxy != "" {
-		proxyURL, err := url.Parse(proxy)
+	q := u.Query()
+	q.Set("key", key)
+	u.RawQuery = q.Encode()
+	return &netAPI{url: u, client: httpClient}, nil
+}
+
+// newAPITransport returns the transport of the connections to the Web Risk
+// API, which updates and lookups share, configured by conf. If a proxy URL
+// is given, it will be used in place of the default $HTTP_PROXY.
+func newAPITransport(conf *Config) (*http.Transport, error) {
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: conf.KeepAlive,
+		}).DialContext,
+		ForceAttemptHTTP2: !conf.DisableHTTP2,
+		MaxIdleConns:      conf.MaxIdleConns,
+		// Every connection is to the same host, so the default of 2 idle
+		// connections per host would close most of them after a burst.
+		MaxIdleConnsPerHost:   conf.MaxIdleConns,
+		IdleConnTimeout:       conf.IdleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	if conf.DisableHTTP2 {
+		// A non-nil empty map keeps HTTP/2 from being negotiated.
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	if conf.ProxyURL != "" {
+		proxyURL, err := url.Parse(conf.ProxyURL)
 		if err != nil {
 			return nil, err
 		}
-		httpClient = &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+		t.Proxy = http.ProxyURL(proxyURL)
 	}
+	return t, nil
+}
 
-	q := u.Query()
-	q.Set("key", key)
-	u.RawQuery = q.Encode()
-	return &netAPI{url: u, client: httpClient}, nil
+// newAPI returns the client of the Web Risk API configured by conf.
+func newAPI(conf *Config) (api, error) {
+	t, err := newAPITransport(conf)
+	if err != nil {
+		return nil, err
+	}
+	return newNetAPI(conf.ServerURL, conf.APIKey, t)
 }
 
 // doRequests performs a GET to requestPath. It automatically unmarshals the
@@ -90,12 +131,17 @@ func (a *netAPI) doRequest(ctx context.Context, urlString string, resp proto.Mes
 	}
 	httpReq.Header.Add("Content-Type", "application/json")
 	httpReq.Header.Add("User-Agent", userAgentString)
+	setTraceHeaders(ctx, httpReq.Header)
+	setRequestIDHeader(ctx, httpReq.Header)
 	httpReq = httpReq.WithContext(ctx)
 	httpResp, err := a.client.Do(httpReq)
 	if err != nil {
 		return err
 	}
 	defer httpResp.Body.Close()
+	if httpResp.StatusCode == http.StatusTooManyRequests {
+		return ErrQuotaExhausted
+	}
 	if httpResp.StatusCode != 200 {
 		return fmt.Errorf("webrisk: unexpected server response code: %d", httpResp.StatusCode)
 	}
@@ -108,18 +154,24 @@ func (a *netAPI) doRequest(ctx context.Context, urlString string, resp proto.Mes
 
 // ListUpdate issues a ComputeThreatListDiff API call and returns the response.
 func (a *netAPI) ListUpdate(ctx context.Context, threatType pb.ThreatType, versionToken []byte,
-	compressionTypes []pb.CompressionType) (*pb.ComputeThreatListDiffResponse, error) {
+	constraints *pb.ComputeThreatListDiffRequest_Constraints) (*pb.ComputeThreatListDiffResponse, error) {
 	resp := new(pb.ComputeThreatListDiffResponse)
 	u := *a.url // Make a copy of URL
 	// Add fields from ComputeThreatListDiffRequest to URL request
 	q := u.Query()
-	q.Set(threatTypeString, threatType.String())
+	q.Set(threatTypeString, ThreatType(threatType).String())
 	if len(versionToken) != 0 {
 		q.Set(versionTokenString, base64.StdEncoding.EncodeToString(versionToken))
 	}
-	for _, compressionType := range compressionTypes {
+	for _, compressionType := range constraints.GetSupportedCompressions() {
 		q.Add(supportedCompressionsString, compressionType.String())
 	}
+	if n := constraints.GetMaxDiffEntries(); n > 0 {
+		q.Set(maxDiffEntriesString, strconv.Itoa(int(n)))
+	}
+	if n := constraints.GetMaxDatabaseEntries(); n > 0 {
+		q.Set(maxDatabaseEntriesString, strconv.Itoa(int(n)))
+	}
 	u.RawQuery = q.Encode()
 	u.Path = fetchUpdatePath
 	return resp, a.doRequest(ctx, u.String(), resp)
@@ -134,7 +186,7 @@ func (a *netAPI) HashLookup(ctx context.Context, hashPrefix []byte,
 	q := u.Query()
 	q.Set(hashPrefixString, base64.StdEncoding.EncodeToString(hashPrefix))
 	for _, threatType := range threatTypes {
-		q.Add(threatTypesString, threatType.String())
+		q.Add(threatTypesString, ThreatType(threatType).String())
 	}
 	u.RawQuery = q.Encode()
 	u.Path = findHashPath
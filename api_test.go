@@ -16,10 +16,12 @@ package webrisk
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
@@ -28,14 +30,14 @@ import (
 
 type mockAPI struct {
 	listUpdate func(ctx context.Context, threatType pb.ThreatType, versionToken []byte,
-		compressionTypes []pb.CompressionType) (*pb.ComputeThreatListDiffResponse, error)
+		constraints *pb.ComputeThreatListDiffRequest_Constraints) (*pb.ComputeThreatListDiffResponse, error)
 	hashLookup func(ctx context.Context, hashPrefix []byte,
 		threatTypes []pb.ThreatType) (*pb.SearchHashesResponse, error)
 }
 
 func (m *mockAPI) ListUpdate(ctx context.Context, threatType pb.ThreatType, versionToken []byte,
-	compressionTypes []pb.CompressionType) (*pb.ComputeThreatListDiffResponse, error) {
-	return m.listUpdate(ctx, threatType, versionToken, compressionTypes)
+	constraints *pb.ComputeThreatListDiffRequest_Constraints) (*pb.ComputeThreatListDiffResponse, error) {
+	return m.listUpdate(ctx, threatType, versionToken, constraints)
 }
 
 func (m *mockAPI) HashLookup(ctx context.Context, hashPrefix []byte,
@@ -46,6 +48,7 @@ func (m *mockAPI) HashLookup(ctx context.Context, hashPrefix []byte,
 func TestNetAPI(t *testing.T) {
 	var gotReqThreatType, wantReqThreatType pb.ThreatType
 	var gotReqCompressionTypes, wantReqCompressionTypes []pb.CompressionType
+	var gotReqMaxDatabaseEntries, wantReqMaxDatabaseEntries string
 	var gotReqHashPrefix, wantReqHashPrefix []byte
 	var gotReqThreatTypes, wantReqThreatTypes []pb.ThreatType
 	var gotResp, wantResp proto.Message
@@ -67,6 +70,11 @@ func TestNetAPI(t *testing.T) {
 					gotReqCompressionTypes = append(gotReqCompressionTypes,
 						pb.CompressionType(pb.CompressionType_value[comp]))
 				}
+			} else if key == "constraints.max_database_entries" {
+				if len(value) == 0 {
+					t.Fatalf("missing value for key: %v", key)
+				}
+				gotReqMaxDatabaseEntries = value[0]
 			} else if key == "hash_prefix" {
 				if len(value) == 0 {
 					t.Fatalf("missing value for key: %v", key)
@@ -96,7 +104,7 @@ func TestNetAPI(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	api, err := newNetAPI(ts.URL, "fizzbuzz", "")
+	api, err := newNetAPI(ts.URL, "fizzbuzz", nil)
 	if err != nil {
 		t.Errorf("unexpected newNetAPI error: %v", err)
 	}
@@ -104,6 +112,7 @@ func TestNetAPI(t *testing.T) {
 	// Test that ListUpdate marshal/unmarshal works.
 	wantReqThreatType = pb.ThreatType_MALWARE
 	wantReqCompressionTypes = []pb.CompressionType{0, 1, 2}
+	wantReqMaxDatabaseEntries = "4096"
 
 	wantResp = &pb.ComputeThreatListDiffResponse{
 		ResponseType: 1,
@@ -113,7 +122,10 @@ func TestNetAPI(t *testing.T) {
 		},
 	}
 	resp1, err := api.ListUpdate(context.Background(), wantReqThreatType, []byte{},
-		wantReqCompressionTypes)
+		&pb.ComputeThreatListDiffRequest_Constraints{
+			SupportedCompressions: wantReqCompressionTypes,
+			MaxDatabaseEntries:    4096,
+		})
 	gotResp = resp1
 	if err != nil {
 		t.Errorf("unexpected ListUpdate error: %v", err)
@@ -126,6 +138,10 @@ func TestNetAPI(t *testing.T) {
 		t.Errorf("mismatching ListUpdate requests for compression types:\ngot  %+v\nwant %+v",
 			gotReqCompressionTypes, wantReqCompressionTypes)
 	}
+	if gotReqMaxDatabaseEntries != wantReqMaxDatabaseEntries {
+		t.Errorf("mismatching ListUpdate requests for max database entries: got %q, want %q",
+			gotReqMaxDatabaseEntries, wantReqMaxDatabaseEntries)
+	}
 	if !proto.Equal(gotResp, wantResp) {
 		t.Errorf("mismatching ListUpdate responses:\ngot  %+v\nwant %+v", gotResp, wantResp)
 	}
@@ -182,3 +198,61 @@ func TestNetAPI(t *testing.T) {
 		t.Errorf("unexpected HashLookup success, wanted malformed JSON error")
 	}
 }
+
+func TestNetAPIQuota(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+	api, err := newNetAPI(ts.URL, "fizzbuzz", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := api.HashLookup(context.Background(), []byte("abcd"), nil); !errors.Is(err, ErrQuotaExhausted) {
+		t.Errorf("HashLookup error = %v, want %v", err, ErrQuotaExhausted)
+	}
+}
+
+func TestNewAPITransport(t *testing.T) {
+	vectors := []struct {
+		conf      Config
+		idle      int
+		timeout   time.Duration
+		http2     bool
+		proxyHost string
+		fail      bool
+	}{
+		{conf: Config{}, idle: DefaultMaxIdleConns, timeout: DefaultIdleConnTimeout, http2: true},
+		{conf: Config{MaxIdleConns: 8, IdleConnTimeout: 5 * time.Second}, idle: 8, timeout: 5 * time.Second, http2: true},
+		{conf: Config{DisableHTTP2: true}, idle: DefaultMaxIdleConns, timeout: DefaultIdleConnTimeout, http2: false},
+		{conf: Config{ProxyURL: "http://proxy.example:3128"}, idle: DefaultMaxIdleConns, timeout: DefaultIdleConnTimeout, http2: true, proxyHost: "proxy.example:3128"},
+		{conf: Config{ProxyURL: "http://proxy.example:port"}, fail: true},
+	}
+	for i, v := range vectors {
+		conf := v.conf
+		conf.setDefaults()
+		tr, err := newAPITransport(&conf)
+		if (err != nil) != v.fail {
+			t.Errorf("test %d, newAPITransport error = %v, want failure %v", i, err, v.fail)
+			continue
+		}
+		if v.fail {
+			continue
+		}
+		if tr.MaxIdleConns != v.idle || tr.MaxIdleConnsPerHost != v.idle {
+			t.Errorf("test %d, idle connections = %d, %d per host, want %d", i, tr.MaxIdleConns, tr.MaxIdleConnsPerHost, v.idle)
+		}
+		if tr.IdleConnTimeout != v.timeout {
+			t.Errorf("test %d, IdleConnTimeout = %v, want %v", i, tr.IdleConnTimeout, v.timeout)
+		}
+		if http2 := tr.ForceAttemptHTTP2 && tr.TLSNextProto == nil; http2 != v.http2 {
+			t.Errorf("test %d, HTTP/2 = %v, want %v", i, http2, v.http2)
+		}
+		if v.proxyHost != "" {
+			req, _ := http.NewRequest("GET", "https://webrisk.googleapis.com/", nil)
+			if u, err := tr.Proxy(req); err != nil || u == nil || u.Host != v.proxyHost {
+				t.Errorf("test %d, proxy = %v, %v, want %s", i, u, err, v.proxyHost)
+			}
+		}
+	}
+}
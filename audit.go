@@ -0,0 +1,156 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAuditLogSize is the default size in bytes at which an AuditLog
+	// is rotated.
+	DefaultAuditLogSize = 10 << 20
+
+	// DefaultAuditLogFiles is the default number of rotated files that an
+	// AuditLog keeps in addition to the current one.
+	DefaultAuditLogFiles = 5
+)
+
+// AuditRecord describes a single update received for a threat list. Together,
+// the records of a database allow reconstructing which version of every
+// threat list was in effect at any point in time.
+type AuditRecord struct {
+	Time         time.Time  `json:"time"`
+	ThreatType   ThreatType `json:"threatType"`
+	ResponseType string     `json:"responseType"` // Either "RESET" or "DIFF"
+	OldVersion   []byte     `json:"oldVersion"`   // Version token before the update
+	NewVersion   []byte     `json:"newVersion"`   // Version token sent by the API
+	Added        int        `json:"added"`        // Number of entries added
+	Removed      int        `json:"removed"`      // Number of entries removed
+	Entries      int        `json:"entries"`      // Number of entries after the update
+	Checksum     []byte     `json:"checksum"`     // SHA256 of the list expected by the API
+	ChecksumOK   bool       `json:"checksumOK"`   // False if the updated list did not match Checksum
+	Error        string     `json:"error,omitempty"`
+}
+
+// AuditLog writes AuditRecords to a file as JSON, one record per line.
+// When the file grows past its maximum size, it is renamed with a ".1"
+// suffix, previously rotated files are shifted up by one, and the oldest is
+// removed. Its Record method may be used as Config.Audit.
+type AuditLog struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenAuditLog opens the audit log at path for appending. If maxSize or
+// maxFiles are zero, DefaultAuditLogSize and DefaultAuditLogFiles are used.
+func OpenAuditLog(path string, maxSize int64, maxFiles int) (*AuditLog, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultAuditLogSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultAuditLogFiles
+	}
+	a := &AuditLog{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AuditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.f, a.size = f, fi.Size()
+	return nil
+}
+
+// Record appends r to the log, rotating it first if needed.
+func (a *AuditLog) Record(r AuditRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = a.Write(append(line, '\n'))
+	return err
+}
+
+// Write appends p to the log, rotating it first if needed. This way, the
+// same log can hold other records than AuditRecords, such as the detections
+// of wrserver, one line per Write.
+func (a *AuditLog) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return 0, errors.New("webrisk: audit log is closed")
+	}
+	if a.size > 0 && a.size+int64(len(p)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := a.f.Write(p)
+	a.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the log files by one and starts a new log.
+//
+// This assumes that the a.mu lock is already held.
+func (a *AuditLog) rotate() error {
+	if err := a.f.Close(); err != nil {
+		return err
+	}
+	a.f = nil
+	name := func(i int) string { return fmt.Sprintf("%s.%d", a.path, i) }
+	os.Remove(name(a.maxFiles))
+	for i := a.maxFiles - 1; i > 0; i-- {
+		if err := os.Rename(name(i), name(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(a.path, name(1)); err != nil {
+		return err
+	}
+	return a.open()
+}
+
+// Close closes the log file.
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f == nil {
+		return nil
+	}
+	err := a.f.Close()
+	a.f = nil
+	return err
+}
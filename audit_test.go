@@ -0,0 +1,170 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+)
+
+func TestAuditLog(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer func() {
+		for _, p := range []string{path, path + ".1", path + ".2", path + ".3"} {
+			os.Remove(p)
+		}
+	}()
+
+	// Every record is larger than half the maximum size, so that every
+	// record after the first rotates the log.
+	al, err := OpenAuditLog(path, 200, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := al.Record(AuditRecord{ThreatType: ThreatTypeMalware, Added: i}); err != nil {
+			t.Fatalf("unexpected record error: %v", err)
+		}
+	}
+	if err := al.Close(); err != nil {
+		t.Errorf("unexpected close error: %v", err)
+	}
+	if err := al.Record(AuditRecord{}); err == nil {
+		t.Errorf("unexpected success recording to a closed log")
+	}
+
+	vectors := []struct {
+		path  string
+		added int
+	}{
+		{path: path, added: 3},
+		{path: path + ".1", added: 2},
+		{path: path + ".2", added: 1},
+	}
+	for i, v := range vectors {
+		f, err := os.Open(v.path)
+		if err != nil {
+			t.Errorf("test %d, unexpected error: %v", i, err)
+			continue
+		}
+		var rs []AuditRecord
+		for s := bufio.NewScanner(f); s.Scan(); {
+			var r AuditRecord
+			if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+				t.Errorf("test %d, invalid record %q: %v", i, s.Bytes(), err)
+			}
+			rs = append(rs, r)
+		}
+		f.Close()
+		if len(rs) != 1 || rs[0].Added != v.added || rs[0].ThreatType != ThreatTypeMalware {
+			t.Errorf("test %d, records = %+v, want one with %d added", i, rs, v.added)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("oldest log was not removed: %v", err)
+	}
+}
+
+func TestDatabaseAudit(t *testing.T) {
+	now := time.Unix(1451436338, 951473000)
+	var rs []AuditRecord
+	config := &Config{
+		ThreatLists:  []ThreatType{ThreatTypeMalware},
+		UpdatePeriod: DefaultUpdatePeriod,
+		now:          func() time.Time { return now },
+		Audit: func(r AuditRecord) error {
+			rs = append(rs, r)
+			return nil
+		},
+	}
+	db := new(database)
+	db.Init(config, log.New(ioutil.Discard, "", 0))
+
+	good := hashPrefixes{"aaaa"}.SHA256()
+	bogus := make([]byte, 32)
+	resps := []*pb.ComputeThreatListDiffResponse{{
+		ResponseType:    pb.ComputeThreatListDiffResponse_RESET,
+		Additions:       &pb.ThreatEntryAdditions{RawHashes: []*pb.RawHashes{{PrefixSize: 4, RawHashes: []byte("aaaa")}}},
+		NewVersionToken: []byte("v1"),
+		Checksum:        &pb.ComputeThreatListDiffResponse_Checksum{Sha256: good},
+	}, {
+		ResponseType:    pb.ComputeThreatListDiffResponse_DIFF,
+		Additions:       &pb.ThreatEntryAdditions{RawHashes: []*pb.RawHashes{{PrefixSize: 4, RawHashes: []byte("bbbb")}}},
+		NewVersionToken: []byte("v2"),
+		Checksum:        &pb.ComputeThreatListDiffResponse_Checksum{Sha256: bogus},
+	}, {
+		ResponseType:    pb.ComputeThreatListDiffResponse_DIFF,
+		Removals:        &pb.ThreatEntryRemovals{RawIndices: &pb.RawIndices{Indices: []int32{0}}},
+		NewVersionToken: []byte("v3"),
+		Checksum:        &pb.ComputeThreatListDiffResponse_Checksum{Sha256: good},
+	}}
+	api := &mockAPI{
+		listUpdate: func(context.Context, pb.ThreatType, []byte, *pb.ComputeThreatListDiffRequest_Constraints) (*pb.ComputeThreatListDiffResponse, error) {
+			resp := resps[0]
+			resps = resps[1:]
+			return resp, nil
+		},
+	}
+	if _, ok := db.Update(context.Background(), api); !ok {
+		t.Fatalf("unexpected update failure")
+	}
+	if _, ok := db.Update(context.Background(), api); ok {
+		t.Fatalf("unexpected update success with a bogus checksum")
+	}
+	if _, ok := db.Update(context.Background(), api); ok {
+		t.Fatalf("unexpected update success with a diff of a reset list")
+	}
+
+	want := []AuditRecord{{
+		Time:         now,
+		ThreatType:   ThreatTypeMalware,
+		ResponseType: "RESET",
+		NewVersion:   []byte("v1"),
+		Added:        1,
+		Entries:      1,
+		Checksum:     good,
+		ChecksumOK:   true,
+	}, {
+		Time:         now,
+		ThreatType:   ThreatTypeMalware,
+		ResponseType: "DIFF",
+		OldVersion:   []byte("v1"),
+		NewVersion:   []byte("v2"),
+		Added:        1,
+		Checksum:     bogus,
+		Error:        errChecksumMismatch.Error(),
+	}, {
+		// The list was reset after the mismatch, so the diff is not
+		// applied, nor checked against its checksum.
+		Time:         now,
+		ThreatType:   ThreatTypeMalware,
+		ResponseType: "DIFF",
+		NewVersion:   []byte("v3"),
+		Checksum:     good,
+		ChecksumOK:   true,
+		Error:        "webrisk: partial update received for non-existent key",
+	}}
+	if !reflect.DeepEqual(rs, want) {
+		t.Errorf("audit records mismatch:\ngot  %+v\nwant %+v", rs, want)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+)
+
+// searchBatcher coalesces the hash searches of concurrent lookups that are
+// made within a short window. The Web Risk API searches a single hash prefix
+// per call, so the searches for the same hash prefix are made as one call,
+// for all the threat types that they are about, and each search gets the
+// part of the response for its own threat types. Unlike flightGroup, which
+// only shares the calls already in flight, it holds back each call for the
+// window, so that the lookups of a burst of requests share it.
+type searchBatcher struct {
+	window time.Duration
+	search func(ctx context.Context, req *pb.SearchHashesRequest) (*pb.SearchHashesResponse, error)
+
+	mu      sync.Mutex
+	pending map[string]*searchBatch // By hash prefix
+}
+
+// searchBatch is a call for a hash prefix that searches are added to until
+// it is made. Its results are valid once done is closed.
+type searchBatch struct {
+	threatTypes map[pb.ThreatType]bool
+	trace       TraceContext // Of the first search, if any
+	requestID   string       // Of the first search, if any
+	done        chan struct{}
+	resp        *pb.SearchHashesResponse
+	err         error
+}
+
+// newSearchBatcher returns a batcher that makes its calls with search.
+func newSearchBatcher(window time.Duration, search func(context.Context, *pb.SearchHashesRequest) (*pb.SearchHashesResponse, error)) *searchBatcher {
+	return &searchBatcher{window: window, search: search, pending: make(map[string]*searchBatch)}
+}
+
+// add adds req, of a lookup made with ctx, to the pending call for its hash
+// prefix, or starts one that is made after the window.
+func (b *searchBatcher) add(ctx context.Context, req *pb.SearchHashesRequest) *searchBatch {
+	key := string(req.HashPrefix)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	batch, ok := b.pending[key]
+	if !ok {
+		batch = &searchBatch{threatTypes: make(map[pb.ThreatType]bool), done: make(chan struct{})}
+		batch.trace, _ = ctx.Value(traceContextKey{}).(TraceContext)
+		batch.requestID = RequestIDFromContext(ctx)
+		b.pending[key] = batch
+		time.AfterFunc(b.window, func() { b.fire(req.HashPrefix, batch) })
+	}
+	for _, tt := range req.ThreatTypes {
+		batch.threatTypes[tt] = true
+	}
+	return batch
+}
+
+// fire makes the call of batch, for the hash prefix, once no more searches
+// can be added to it.
+func (b *searchBatcher) fire(prefix []byte, batch *searchBatch) {
+	b.mu.Lock()
+	delete(b.pending, string(prefix))
+	b.mu.Unlock()
+	req := &pb.SearchHashesRequest{HashPrefix: prefix}
+	for tt := range batch.threatTypes {
+		req.ThreatTypes = append(req.ThreatTypes, tt)
+	}
+	ctx := WithRequestID(WithTraceContext(context.Background(), batch.trace), batch.requestID)
+	batch.resp, batch.err = b.search(ctx, req)
+	close(batch.done)
+}
+
+// wait returns the part of the response of the call that is about the
+// threat types tts. Waiting stops early if ctx is done.
+func (batch *searchBatch) wait(ctx context.Context, tts []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+	select {
+	case <-batch.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if batch.err != nil {
+		return nil, batch.err
+	}
+	want := make(map[pb.ThreatType]bool, len(tts))
+	for _, tt := range tts {
+		want[tt] = true
+	}
+	resp := &pb.SearchHashesResponse{NegativeExpireTime: batch.resp.NegativeExpireTime}
+	for _, threat := range batch.resp.GetThreats() {
+		var kept []pb.ThreatType
+		for _, tt := range threat.ThreatTypes {
+			if want[tt] {
+				kept = append(kept, tt)
+			}
+		}
+		if len(kept) > 0 {
+			resp.Threats = append(resp.Threats, &pb.SearchHashesResponse_ThreatHash{
+				ThreatTypes: kept,
+				Hash:        threat.Hash,
+				ExpireTime:  threat.ExpireTime,
+			})
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+	timepb "google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+)
+
+func TestSearchBatcher(t *testing.T) {
+	expire := timepb.New(time.Unix(1700000000, 0))
+	var mu sync.Mutex
+	calls := make(map[string][]pb.ThreatType)
+	b := newSearchBatcher(20*time.Millisecond, func(_ context.Context, req *pb.SearchHashesRequest) (*pb.SearchHashesResponse, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		tts := append([]pb.ThreatType(nil), req.ThreatTypes...)
+		sort.Slice(tts, func(i, j int) bool { return tts[i] < tts[j] })
+		calls[string(req.HashPrefix)] = tts
+		return &pb.SearchHashesResponse{
+			Threats: []*pb.SearchHashesResponse_ThreatHash{{
+				ThreatTypes: []pb.ThreatType{pb.ThreatType_MALWARE, pb.ThreatType_SOCIAL_ENGINEERING},
+				Hash:        []byte(string(req.HashPrefix) + "full"),
+				ExpireTime:  expire,
+			}, {
+				ThreatTypes: []pb.ThreatType{pb.ThreatType_UNWANTED_SOFTWARE},
+				Hash:        []byte(string(req.HashPrefix) + "other"),
+				ExpireTime:  expire,
+			}},
+			NegativeExpireTime: expire,
+		}, nil
+	})
+
+	ctx := context.Background()
+	malware := []pb.ThreatType{pb.ThreatType_MALWARE}
+	social := []pb.ThreatType{pb.ThreatType_SOCIAL_ENGINEERING}
+	b1 := b.add(ctx, &pb.SearchHashesRequest{HashPrefix: []byte("aaaa"), ThreatTypes: malware})
+	b2 := b.add(ctx, &pb.SearchHashesRequest{HashPrefix: []byte("aaaa"), ThreatTypes: social})
+	b3 := b.add(ctx, &pb.SearchHashesRequest{HashPrefix: []byte("bbbb"), ThreatTypes: malware})
+	if b1 != b2 || b1 == b3 {
+		t.Fatalf("searches were not batched by hash prefix")
+	}
+
+	vectors := []struct {
+		batch *searchBatch
+		tts   []pb.ThreatType
+		want  *pb.SearchHashesResponse
+	}{{
+		batch: b1,
+		tts:   malware,
+		want: &pb.SearchHashesResponse{
+			Threats: []*pb.SearchHashesResponse_ThreatHash{{
+				ThreatTypes: malware, Hash: []byte("aaaafull"), ExpireTime: expire,
+			}},
+			NegativeExpireTime: expire,
+		},
+	}, {
+		batch: b2,
+		tts:   social,
+		want: &pb.SearchHashesResponse{
+			Threats: []*pb.SearchHashesResponse_ThreatHash{{
+				ThreatTypes: social, Hash: []byte("aaaafull"), ExpireTime: expire,
+			}},
+			NegativeExpireTime: expire,
+		},
+	}, {
+		batch: b3,
+		tts:   malware,
+		want: &pb.SearchHashesResponse{
+			Threats: []*pb.SearchHashesResponse_ThreatHash{{
+				ThreatTypes: malware, Hash: []byte("bbbbfull"), ExpireTime: expire,
+			}},
+			NegativeExpireTime: expire,
+		},
+	}}
+	for i, v := range vectors {
+		resp, err := v.batch.wait(ctx, v.tts)
+		if err != nil {
+			t.Fatalf("test %d, unexpected error: %v", i, err)
+		}
+		if !proto.Equal(resp, v.want) {
+			t.Errorf("test %d, response = %v, want %v", i, resp, v.want)
+		}
+	}
+	wantCalls := map[string][]pb.ThreatType{
+		"aaaa": {pb.ThreatType_MALWARE, pb.ThreatType_SOCIAL_ENGINEERING},
+		"bbbb": malware,
+	}
+	if !cmp.Equal(calls, wantCalls) {
+		t.Errorf("calls = %v, want %v", calls, wantCalls)
+	}
+
+	// A search after the call is made starts a new one.
+	if b4 := b.add(ctx, &pb.SearchHashesRequest{HashPrefix: []byte("aaaa"), ThreatTypes: malware}); b4 == b1 {
+		t.Errorf("search was added to a call already made")
+	}
+}
+
+func TestSearchBatchWindow(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	evil := hashFromPattern("evil.com/")
+	phs := hashPrefixes{evil[:minHashPrefixLength]}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: time.Now(),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	expire := timepb.New(time.Now().Add(time.Hour))
+	var apiCalls int64
+	wr, err := NewUpdateClient(Config{
+		DBPath:            path,
+		ThreatLists:       []ThreatType{ThreatTypeMalware},
+		SearchBatchWindow: 50 * time.Millisecond,
+		api: &mockAPI{
+			hashLookup: func(context.Context, []byte, []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+				atomic.AddInt64(&apiCalls, 1)
+				return &pb.SearchHashesResponse{
+					Threats: []*pb.SearchHashesResponse_ThreatHash{{
+						ThreatTypes: []pb.ThreatType{pb.ThreatType_MALWARE},
+						Hash:        []byte(evil),
+						ExpireTime:  expire,
+					}},
+					NegativeExpireTime: expire,
+				}, nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			threats, err := wr.LookupURLs([]string{"http://evil.com/"})
+			if err != nil {
+				t.Errorf("lookup %d, unexpected error: %v", i, err)
+				return
+			}
+			if len(threats[0]) != 1 || threats[0][0].ThreatType != ThreatTypeMalware {
+				t.Errorf("lookup %d, threats = %v, want MALWARE", i, threats[0])
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := atomic.LoadInt64(&apiCalls); n != 1 {
+		t.Errorf("%d API calls, want 1", n)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by lookups that need the Web Risk API while the
+// circuit breaker is open. See Config.CircuitBreakerThreshold.
+var ErrCircuitOpen = errors.New("webrisk: circuit breaker is open")
+
+// breaker is a circuit breaker for the hash searches made to the API. After
+// threshold consecutive failures, it opens for the cooldown period, during
+// which searches fail right away instead of piling up on an API that is
+// down. The first search after the cooldown is let through, and closes the
+// breaker again if it succeeds.
+type breaker struct {
+	threshold int // Zero if the breaker is disabled
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu        sync.Mutex
+	failures  int       // Consecutive failures
+	openUntil time.Time // Zero if closed
+}
+
+// allow reports whether a search may be made.
+func (b *breaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.openUntil.IsZero() || !b.now().Before(b.openUntil)
+}
+
+// record records the outcome of a search. It reports whether a failure
+// opened the breaker.
+func (b *breaker) record(err error) bool {
+	if b.threshold <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return false
+	}
+	b.failures++
+	if b.failures < b.threshold {
+		return false
+	}
+	// Either the threshold was just reached, or the search let through
+	// after the cooldown failed as well.
+	wasOpen := !b.openUntil.IsZero()
+	b.openUntil = b.now().Add(b.cooldown)
+	return !wasOpen
+}
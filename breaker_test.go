@@ -0,0 +1,65 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(1451436338, 0)
+	b := breaker{threshold: 2, cooldown: time.Minute, now: func() time.Time { return now }}
+	errAPI := errors.New("unavailable")
+
+	vectors := []struct {
+		advance time.Duration // Before the search
+		allow   bool
+		err     error // Outcome of the search, if allowed
+		opened  bool
+	}{
+		{allow: true, err: errAPI},
+		{allow: true, err: nil}, // A success resets the count.
+		{allow: true, err: errAPI},
+		{allow: true, err: errAPI, opened: true},
+		{allow: false},
+		{advance: 59 * time.Second, allow: false},
+		{advance: time.Second, allow: true, err: errAPI}, // Still open.
+		{allow: false},
+		{advance: time.Minute, allow: true, err: nil},
+		{allow: true, err: errAPI},
+		{allow: true, err: errAPI, opened: true},
+	}
+	for i, v := range vectors {
+		now = now.Add(v.advance)
+		if got := b.allow(); got != v.allow {
+			t.Errorf("test %d, allow() = %v, want %v", i, got, v.allow)
+		}
+		if !v.allow {
+			continue
+		}
+		if got := b.record(v.err); got != v.opened {
+			t.Errorf("test %d, record(%v) = %v, want %v", i, v.err, got, v.opened)
+		}
+	}
+
+	// A zero threshold disables the breaker.
+	b = breaker{}
+	for i := 0; i < 10; i++ {
+		if b.record(errAPI) || !b.allow() {
+			t.Fatalf("disabled breaker opened")
+		}
+	}
+}
@@ -14,14 +14,98 @@
 package webrisk
 
 import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	pb "github.com/google/webrisk/internal/webrisk_proto"
 )
 
+// A Cache stores the results of hash searches made to the Web Risk API, so
+// that repeated lookups of the same URLs do not contact the API again while
+// the results are valid. Entries are keyed by raw hash: full hashes hold the
+// threats found for them, and hash prefixes hold the time until which no
+// other threats exist under them.
+//
+// Implementations must be safe for concurrent use. See Config.Cache.
+//
+// Entries are updated by getting them, and setting a changed copy, without
+// any lock, so an update made concurrently by another client for the same
+// hash may be lost. It then costs an API call, not a wrong result.
+type Cache interface {
+	// Get returns the entry for hash, and whether there is one. It may
+	// return entries that have expired. The caller must not modify the
+	// returned entry, including its Threats map, since it may be shared.
+	Get(hash string) (CacheEntry, bool)
+
+	// Set replaces the entry for hash with e. The entry may be discarded
+	// once its TTL has passed, as reported by e.Expire. The cache takes
+	// ownership of e, which the caller must not modify afterwards.
+	Set(hash string, e CacheEntry)
+
+	// Purge removes entries that have expired.
+	Purge()
+}
+
+// clearableCache is implemented by caches that can remove all of their
+// entries without affecting entries that other instances store in the same
+// backend.
+type clearableCache interface {
+	Clear() error
+}
+
+// CacheEntry is a cached search result.
+type CacheEntry struct {
+	// Threats maps each threat type that a full hash matches to the time
+	// that the match expires.
+	Threats map[ThreatType]time.Time
+
+	// NegativeExpire is the time until which a hash prefix is known to
+	// match no threats other than those cached for its full hashes.
+	NegativeExpire time.Time
+}
+
+// clone returns a copy of e that does not share its Threats map.
+func (e CacheEntry) clone() CacheEntry {
+	if e.Threats != nil {
+		threats := make(map[ThreatType]time.Time, len(e.Threats))
+		for tt, exp := range e.Threats {
+			threats[tt] = exp
+		}
+		e.Threats = threats
+	}
+	return e
+}
+
+// Expire returns the time after which the entry holds no valid results.
+func (e CacheEntry) Expire() time.Time {
+	t := e.NegativeExpire
+	for _, pttl := range e.Threats {
+		if pttl.After(t) {
+			t = pttl
+		}
+	}
+	return t
+}
+
 type cacheResult int
 
+// Approximate memory costs of a single cache entry, including map and LRU
+// list overhead. A positive entry holds a full hash and a small map of
+// ThreatTypes to TTLs. A negative entry holds a partial hash and a TTL.
+const (
+	positiveCacheEntryBytes = 416
+	negativeCacheEntryBytes = 160
+)
+
 const (
 	// positiveCacheHit indicates that the given hash matched an entry in the cache.
 	// The caller must consider the match a threat and not contact the server.
@@ -45,9 +129,16 @@ const (
 // network calls for recently requested items. Since the global blocklist is
 // constantly changing, the Web Risk API defines TTLs for how long entries
 // can stay alive in the cache.
+//
+// Entries are kept in memory unless an external Cache is provided.
 type cache struct {
 	sync.RWMutex
 
+	// external, if set, stores the entries instead of pttls and nttls.
+	// The lock is not held while it is accessed, so that slow caches do
+	// not serialize lookups.
+	external Cache
+
 	// pttls maps full hashes and a ThreatType to a positive time-to-live.
 	// For a given full hash, the known threats are all ThreatTypes that
 	// map to valid TTLs (i.e. in the future).
@@ -60,20 +151,273 @@ type cache struct {
 	nttls map[hashPrefix]time.Time
 
 	// The minimum amount of time to cache positive and negative responses
-	// from the server
-	pminTTL time.Duration
-	nminTTL time.Duration
+	// from the server, and the overrides of those for specific threat types
+	pminTTL  time.Duration
+	nminTTL  time.Duration
+	pminTTLs map[ThreatType]time.Duration
+	nminTTLs map[ThreatType]time.Duration
+
+	// ttlPolicy determines how the minimum TTLs above are applied.
+	ttlPolicy TTLPolicy
+
+	// disabled makes the cache hold nothing, so that every lookup misses.
+	disabled bool
+
+	// jitter is the largest random amount of time by which expirations are
+	// made earlier, and randn the source of randomness used for it, which
+	// defaults to rand.Int63n.
+	jitter time.Duration
+	randn  func(n int64) int64
+
+	// maxBytes is the approximate memory limit for the cache, and
+	// maxEntries the limit on the number of entries. If the cache grows
+	// beyond either, the least recently used entries are evicted. Zero
+	// means no limit.
+	maxBytes   int64
+	maxEntries int
+
+	// lru orders the entries from most to least recently used, and lruElems
+	// indexes it. Entries that were not added through set, which only
+	// happens in tests, are not tracked.
+	lru       *list.List
+	lruElems  map[lruKey]*list.Element
+	evictions int64 // Number of live entries evicted
+
+	// Outcomes of lookups. These are updated atomically, since lookups in
+	// an external cache do not take the lock.
+	positiveHits atomic.Int64
+	negativeHits atomic.Int64
+	misses       atomic.Int64
+	expired      atomic.Int64 // Misses due to an expired entry
 
 	now func() time.Time
 }
 
+// lruKey identifies an entry in the LRU list.
+type lruKey struct {
+	hash     hashPrefix
+	negative bool // Whether the entry is in nttls rather than pttls
+}
+
+// touch marks the entry k as the most recently used.
+//
+// This assumes that the lock is already held.
+func (c *cache) touch(k lruKey) {
+	if c.lru == nil {
+		c.lru = list.New()
+		c.lruElems = make(map[lruKey]*list.Element)
+	}
+	if e, ok := c.lruElems[k]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.lruElems[k] = c.lru.PushFront(k)
+}
+
+// remove deletes the entry k from the cache.
+//
+// This assumes that the lock is already held.
+func (c *cache) remove(k lruKey) {
+	if k.negative {
+		delete(c.nttls, k.hash)
+	} else {
+		delete(c.pttls, k.hash)
+	}
+	if e, ok := c.lruElems[k]; ok {
+		c.lru.Remove(e)
+		delete(c.lruElems, k)
+	}
+}
+
+// lock acquires the lock if the entries are kept in memory, and returns the
+// function that releases it.
+func (c *cache) lock() (unlock func()) {
+	if c.external != nil {
+		return func() {}
+	}
+	c.Lock()
+	return c.Unlock
+}
+
+// get returns the entry for hash.
+//
+// This assumes that the lock acquired by c.lock is held.
+func (c *cache) get(hash hashPrefix) CacheEntry {
+	if c.external != nil {
+		e, _ := c.external.Get(string(hash))
+		return e
+	}
+	return CacheEntry{Threats: c.pttls[hash], NegativeExpire: c.nttls[hash]}
+}
+
+// set replaces the entry for hash.
+//
+// This assumes that the lock acquired by c.lock is held.
+func (c *cache) set(hash hashPrefix, e CacheEntry) {
+	if c.external != nil {
+		c.external.Set(string(hash), e)
+		return
+	}
+	if c.pttls == nil {
+		c.pttls = make(map[hashPrefix]map[ThreatType]time.Time)
+		c.nttls = make(map[hashPrefix]time.Time)
+	}
+	if len(e.Threats) > 0 {
+		c.pttls[hash] = e.Threats
+		c.touch(lruKey{hash: hash})
+	}
+	if !e.NegativeExpire.IsZero() {
+		c.nttls[hash] = e.NegativeExpire
+		c.touch(lruKey{hash: hash, negative: true})
+	}
+}
+
+// used records a cache hit on the entry k.
+//
+// This assumes that the lock acquired by c.lock is held.
+func (c *cache) used(k lruKey) {
+	if c.external == nil {
+		if _, ok := c.lruElems[k]; ok {
+			c.touch(k)
+		}
+	}
+}
+
+// MemoryUsage reports the approximate number of bytes used by the cache.
+// It is zero for external caches, which are not held in memory.
+func (c *cache) MemoryUsage() int64 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.memoryUsage()
+}
+
+// memoryUsage is MemoryUsage, but assumes the lock is already held.
+func (c *cache) memoryUsage() int64 {
+	return int64(len(c.pttls))*positiveCacheEntryBytes + int64(len(c.nttls))*negativeCacheEntryBytes
+}
+
+// SetMemoryLimit sets the approximate memory limit for the cache and evicts
+// entries if the cache is already over the new limit. A limit of zero or
+// less disables the limit.
+func (c *cache) SetMemoryLimit(n int64) {
+	c.Lock()
+	defer c.Unlock()
+	c.maxBytes = n
+	c.evict()
+}
+
+// Evictions reports the number of entries that were evicted before they
+// expired to stay within the limits of the cache.
+func (c *cache) Evictions() int64 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.evictions
+}
+
+// cacheStats records statistics regarding the lookups in the cache.
+type cacheStats struct {
+	PositiveHits    int64
+	NegativeHits    int64
+	Misses          int64
+	Expired         int64
+	PositiveEntries int
+	NegativeEntries int
+}
+
+// Stats reports the outcomes of the lookups so far, and the number of
+// entries currently held in memory. The entries of an external cache are not
+// counted.
+func (c *cache) Stats() cacheStats {
+	c.RLock()
+	defer c.RUnlock()
+	return cacheStats{
+		PositiveHits:    c.positiveHits.Load(),
+		NegativeHits:    c.negativeHits.Load(),
+		Misses:          c.misses.Load(),
+		Expired:         c.expired.Load(),
+		PositiveEntries: len(c.pttls),
+		NegativeEntries: len(c.nttls),
+	}
+}
+
+// overLimit reports whether the cache holds more entries than allowed.
+//
+// This assumes that the lock is already held.
+func (c *cache) overLimit() bool {
+	return (c.maxBytes > 0 && c.memoryUsage() > c.maxBytes) ||
+		(c.maxEntries > 0 && len(c.pttls)+len(c.nttls) > c.maxEntries)
+}
+
+// evict removes the least recently used entries until the cache is within
+// its limits. Entries that are not tracked in the LRU list are removed in
+// arbitrary order once all tracked ones are gone.
+//
+// This assumes that the lock is already held.
+func (c *cache) evict() {
+	for c.overLimit() {
+		if c.lru != nil && c.lru.Len() > 0 {
+			c.remove(c.lru.Back().Value.(lruKey))
+			c.evictions++
+			continue
+		}
+		for partialHash := range c.nttls {
+			c.remove(lruKey{hash: partialHash, negative: true})
+			break
+		}
+		if len(c.nttls) == 0 {
+			for fullHash := range c.pttls {
+				c.remove(lruKey{hash: fullHash})
+				break
+			}
+		}
+		c.evictions++
+	}
+}
+
 func (c *cache) makeExpireTime(base time.Time, duration time.Duration) time.Time {
+	return c.addJitter(c.minExpireTime(base, duration))
+}
+
+// addJitter moves expire earlier by a random amount of up to the jitter of
+// the cache, so that entries added at the same time do not all expire at
+// once. Entries are never kept longer than the server allows, and at most
+// half of the remaining lifetime of an entry is taken off.
+func (c *cache) addJitter(expire time.Time) time.Time {
+	if c.jitter <= 0 {
+		return expire
+	}
+	max := c.jitter
+	if half := expire.Sub(c.now()) / 2; half < max {
+		max = half
+	}
+	if max <= 0 {
+		return expire
+	}
+	randn := rand.Int63n
+	if c.randn != nil {
+		randn = c.randn
+	}
+	return expire.Add(-time.Duration(randn(int64(max))))
+}
+
+// minExpireTime combines the expiration time base given by the server with
+// the configured TTL duration according to the TTL policy of the cache.
+func (c *cache) minExpireTime(base time.Time, duration time.Duration) time.Time {
 	if duration.Nanoseconds() == 0 {
 		return base
 	}
 
 	min := c.now().Add(duration)
 
+	switch c.ttlPolicy {
+	case TTLOverride:
+		return min
+	case TTLMin:
+		if min.Before(base) {
+			return min
+		}
+		return base
+	}
 	if min.After(base) {
 		return min
 	} else {
@@ -81,13 +425,92 @@ func (c *cache) makeExpireTime(base time.Time, duration time.Duration) time.Time
 	}
 }
 
+// TTLPolicy determines how the minimum TTLs, PMinTTL and NMinTTL as well as
+// their per threat type overrides, are combined with the cache durations
+// given by the Web Risk API. Threat types without a configured TTL always
+// use the duration given by the API.
+type TTLPolicy int
+
+const (
+	// TTLMax caches results for the longer of the two durations, so that
+	// the configured TTLs are a floor. This is the default.
+	TTLMax TTLPolicy = iota
+
+	// TTLMin caches results for the shorter of the two durations, so that
+	// the configured TTLs are a ceiling. This makes delisted URLs stop
+	// being reported sooner, at the cost of more API calls.
+	TTLMin
+
+	// TTLOverride ignores the durations given by the API and caches
+	// results for the configured TTLs.
+	TTLOverride
+)
+
+var ttlPolicyNames = map[TTLPolicy]string{
+	TTLMax:      "max",
+	TTLMin:      "min",
+	TTLOverride: "override",
+}
+
+func (p TTLPolicy) String() string {
+	if name, ok := ttlPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("TTLPolicy(%d)", int(p))
+}
+
+// ParseTTLPolicy parses the name of a TTLPolicy: "max", "min", or
+// "override". An empty string is parsed as TTLMax.
+func ParseTTLPolicy(s string) (TTLPolicy, error) {
+	if s == "" {
+		return TTLMax, nil
+	}
+	for p, name := range ttlPolicyNames {
+		if s == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("webrisk: unknown TTL policy: %q", s)
+}
+
+// positiveMinTTL returns the minimum time to cache a positive response for
+// the threat type tt.
+func (c *cache) positiveMinTTL(tt ThreatType) time.Duration {
+	if d, ok := c.pminTTLs[tt]; ok {
+		return d
+	}
+	return c.pminTTL
+}
+
+// negativeMinTTL returns the minimum time to cache a negative response for a
+// search of the threat types tts. Since the response covers all of them, the
+// shortest of their minimum TTLs is used.
+func (c *cache) negativeMinTTL(tts []pb.ThreatType) time.Duration {
+	if len(tts) == 0 {
+		return c.nminTTL
+	}
+	var min time.Duration
+	for i, tt := range tts {
+		d, ok := c.nminTTLs[ThreatType(tt)]
+		if !ok {
+			d = c.nminTTL
+		}
+		if i == 0 || d < min {
+			min = d
+		}
+	}
+	return min
+}
+
 // Update updates the cache according to the request that was made to the server
 // and the response given back.
 func (c *cache) Update(req *pb.SearchHashesRequest, resp *pb.SearchHashesResponse) error {
-	c.Lock()
-	defer c.Unlock()
+	if c.disabled {
+		return nil
+	}
+	defer c.lock()()
 
-	if c.pttls == nil {
+	if c.external == nil && c.pttls == nil {
 		c.pttls = make(map[hashPrefix]map[ThreatType]time.Time)
 		c.nttls = make(map[hashPrefix]time.Time)
 	}
@@ -98,19 +521,27 @@ func (c *cache) Update(req *pb.SearchHashesRequest, resp *pb.SearchHashesRespons
 		if !fullHash.IsFull() {
 			continue
 		}
-		if c.pttls[fullHash] == nil {
-			c.pttls[fullHash] = make(map[ThreatType]time.Time)
+		// The entry may be shared with the external cache, so a copy is
+		// changed.
+		e := c.get(fullHash).clone()
+		if e.Threats == nil {
+			e.Threats = make(map[ThreatType]time.Time)
 		}
 		for _, tt := range threat.ThreatTypes {
-			c.pttls[fullHash][ThreatType(tt)] = c.makeExpireTime(threat.ExpireTime.AsTime(), c.pminTTL)
+			e.Threats[ThreatType(tt)] = c.makeExpireTime(threat.ExpireTime.AsTime(), c.positiveMinTTL(ThreatType(tt)))
 		}
+		c.set(fullHash, e)
 	}
 
 	// Insert negative TTLs for partial hashes.
 	if resp.GetNegativeExpireTime() != nil {
-		nttl := c.makeExpireTime(resp.GetNegativeExpireTime().AsTime(), c.nminTTL)
 		partialHash := hashPrefix(req.HashPrefix)
-		c.nttls[partialHash] = nttl
+		e := c.get(partialHash)
+		e.NegativeExpire = c.makeExpireTime(resp.GetNegativeExpireTime().AsTime(), c.negativeMinTTL(req.ThreatTypes))
+		c.set(partialHash, e)
+	}
+	if c.external == nil {
+		c.evict()
 	}
 	return nil
 }
@@ -118,48 +549,140 @@ func (c *cache) Update(req *pb.SearchHashesRequest, resp *pb.SearchHashesRespons
 // Lookup looks up a full hash and returns a set of ThreatTypes and the
 // validity of the result.
 func (c *cache) Lookup(hash hashPrefix) (map[ThreatType]bool, cacheResult) {
+	threats, result, _ := c.lookup(hash, "")
+	return threats, result
+}
+
+// lookup is like Lookup, but also returns when the result expires, which is
+// the zero time on a cache miss. Negative results are only looked up under
+// partial, the hash prefix of hash that the database matched and that the
+// API was asked about, unless it is empty.
+func (c *cache) lookup(hash, partial hashPrefix) (map[ThreatType]bool, cacheResult, time.Time) {
 	if !hash.IsFull() {
-		return nil, cacheError
+		return nil, cacheError, time.Time{}
+	}
+	if c.disabled {
+		c.misses.Add(1)
+		return nil, cacheMiss, time.Time{}
+	}
+	threats, result, expire, expired := c.lookupLocked(hash, partial)
+	switch result {
+	case positiveCacheHit:
+		c.positiveHits.Add(1)
+	case negativeCacheHit:
+		c.negativeHits.Add(1)
+	case cacheMiss:
+		c.misses.Add(1)
+		if expired {
+			c.expired.Add(1)
+		}
 	}
+	return threats, result, expire
+}
 
-	c.Lock()
-	defer c.Unlock()
+// lookupLocked implements lookup while holding the lock. It also reports
+// whether a miss is due to an entry that has expired.
+func (c *cache) lookupLocked(hash, partial hashPrefix) (map[ThreatType]bool, cacheResult, time.Time, bool) {
+	defer c.lock()()
 	now := c.now()
 
 	// Check all entries to see if there *is* a threat.
 	threats := make(map[ThreatType]bool)
-	threatTTLs := c.pttls[hash]
-	for td, pttl := range threatTTLs {
+	var expire time.Time
+	for td, pttl := range c.get(hash).Threats {
 		if pttl.After(now) {
 			threats[td] = true
+			if expire.IsZero() || pttl.Before(expire) {
+				expire = pttl
+			}
 		} else {
 			// The PTTL has expired, we should ask the server what's going on.
-			return nil, cacheMiss
+			return nil, cacheMiss, time.Time{}, true
 		}
 	}
 	if len(threats) > 0 {
 		// So long as there are valid threats, we report them. The positive TTL
 		// takes precedence over the negative TTL at the partial hash level.
-		return threats, positiveCacheHit
+		c.used(lruKey{hash: hash})
+		return threats, positiveCacheHit, expire, false
 	}
 
-	// Check the negative TTLs to see if there are *no* threats.
-	for i := minHashPrefixLength; i <= maxHashPrefixLength; i++ {
-		if nttl, ok := c.nttls[hash[:i]]; ok {
-			if nttl.After(now) {
-				return nil, negativeCacheHit
-			}
+	// Check the negative TTLs to see if there are *no* threats. Each prefix
+	// is a round trip to an external cache, so only the one that the API was
+	// asked about is checked if known.
+	lo, hi := minHashPrefixLength, maxHashPrefixLength
+	if partial != "" {
+		lo, hi = len(partial), len(partial)
+	}
+	expired := false
+	for i := lo; i <= hi; i++ {
+		nttl := c.get(hash[:i]).NegativeExpire
+		if nttl.After(now) {
+			c.used(lruKey{hash: hash[:i], negative: true})
+			return nil, negativeCacheHit, nttl, false
 		}
+		expired = expired || !nttl.IsZero()
 	}
 
 	// The cache has no information; it is a *possible* threat.
-	return nil, cacheMiss
+	return nil, cacheMiss, time.Time{}, expired
+}
+
+// inspect returns what the cache holds for a full hash: the threats cached
+// for it, including expired ones, and the shortest hash prefix of it with a
+// negative entry, preferring one that is still valid. Unlike Lookup, it
+// neither marks the entries as used nor counts towards the statistics.
+func (c *cache) inspect(hash hashPrefix) (threats map[ThreatType]time.Time, negPrefix hashPrefix, negExpire time.Time) {
+	defer c.lock()()
+	now := c.now()
+	for td, pttl := range c.get(hash).Threats {
+		if threats == nil {
+			threats = make(map[ThreatType]time.Time)
+		}
+		threats[td] = pttl
+	}
+	for i := minHashPrefixLength; i <= maxHashPrefixLength && i <= len(hash); i++ {
+		nttl := c.get(hash[:i]).NegativeExpire
+		if nttl.IsZero() {
+			continue
+		}
+		if negPrefix == "" || (nttl.After(now) && !negExpire.After(now)) {
+			negPrefix, negExpire = hash[:i], nttl
+		}
+	}
+	return threats, negPrefix, negExpire
 }
 
 // Purge purges all expired entries from the cache.
 func (c *cache) Purge() {
+	if c.external != nil {
+		c.external.Purge()
+		return
+	}
 	c.Lock()
 	defer c.Unlock()
+	c.purge()
+}
+
+// Clear removes all entries from the cache. An external cache can only be
+// cleared if it implements Clear.
+func (c *cache) Clear() error {
+	if c.external != nil {
+		cc, ok := c.external.(clearableCache)
+		if !ok {
+			return errors.New("webrisk: the cache cannot be cleared")
+		}
+		return cc.Clear()
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.pttls, c.nttls = nil, nil
+	c.lru, c.lruElems = nil, nil
+	return nil
+}
+
+// purge is Purge, but assumes the lock is already held.
+func (c *cache) purge() {
 	now := c.now()
 
 	// Nuke all threat entries based on their positive TTL.
@@ -181,14 +704,110 @@ func (c *cache) Purge() {
 			}
 		}
 		if len(threatTTLs) == 0 {
-			delete(c.pttls, fullHash)
+			c.remove(lruKey{hash: fullHash})
 		}
 	}
 
 	// Nuke all partial hashes based on their negative TTL.
 	for partialHash, nttl := range c.nttls {
 		if now.After(nttl) {
-			delete(c.nttls, partialHash)
+			c.remove(lruKey{hash: partialHash, negative: true})
+		}
+	}
+}
+
+// cacheFormat is the persisted form of the cache. As written to a Store, it
+// is the gzip compressed gob encoding of cacheFormat.
+type cacheFormat struct {
+	Positive map[hashPrefix]map[ThreatType]time.Time
+	Negative map[hashPrefix]time.Time
+}
+
+// Save writes the entries of the in-memory cache that have not expired to
+// store. It does nothing for external caches.
+func (c *cache) Save(ctx context.Context, store Store) error {
+	if c.external != nil {
+		return nil
+	}
+	cf := cacheFormat{
+		Positive: make(map[hashPrefix]map[ThreatType]time.Time),
+		Negative: make(map[hashPrefix]time.Time),
+	}
+	c.RLock()
+	now := c.now()
+	for fullHash, threatTTLs := range c.pttls {
+		live := make(map[ThreatType]time.Time)
+		for td, pttl := range threatTTLs {
+			if pttl.After(now) {
+				live[td] = pttl
+			}
+		}
+		if len(live) > 0 {
+			cf.Positive[fullHash] = live
+		}
+	}
+	for partialHash, nttl := range c.nttls {
+		if nttl.After(now) {
+			cf.Negative[partialHash] = nttl
+		}
+	}
+	c.RUnlock()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := gob.NewEncoder(gz).Encode(cf); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return store.Save(ctx, buf.Bytes())
+}
+
+// Load adds the entries saved to store by Save that have not expired since
+// to the in-memory cache, and reports how many were added. It does nothing
+// for external caches.
+func (c *cache) Load(ctx context.Context, store Store) (n int, err error) {
+	if c.external != nil {
+		return 0, nil
+	}
+	data, err := store.Load(ctx)
+	if err != nil {
+		return 0, err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	var cf cacheFormat
+	if err := gob.NewDecoder(gz).Decode(&cf); err != nil {
+		return 0, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	now := c.now()
+	for fullHash, threatTTLs := range cf.Positive {
+		if !fullHash.IsFull() {
+			continue
+		}
+		// Lookups treat an entry with any expired threat as a miss, so
+		// such entries are not worth restoring.
+		valid := true
+		for _, pttl := range threatTTLs {
+			valid = valid && pttl.After(now)
+		}
+		if valid && len(threatTTLs) > 0 {
+			c.set(fullHash, CacheEntry{Threats: threatTTLs})
+			n++
+		}
+	}
+	for partialHash, nttl := range cf.Negative {
+		if nttl.After(now) && len(partialHash) >= minHashPrefixLength {
+			c.set(partialHash, CacheEntry{NegativeExpire: nttl})
+			n++
 		}
 	}
+	c.evict()
+	return n, nil
 }
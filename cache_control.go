@@ -0,0 +1,115 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import "time"
+
+// CacheControl tells clients how long they may keep the verdict of a lookup
+// in their own short-lived caches. See UpdateClient.CacheControl.
+type CacheControl struct {
+	// Expire is when the verdict expires, after which it must not be used:
+	// for an unsafe URL, the earliest expiration of its cached matches, and
+	// for a safe one, the earliest expiration of the cached results or the
+	// database age that show it safe. It is the time of the lookup if the
+	// verdict may not be cached at all, such as with Config.DisableCache.
+	Expire time.Time
+
+	// RevalidateAfter is when the threat lists are next updated, or Expire
+	// if that is earlier. After it, the verdict may still be used until
+	// Expire, but should be looked up again, such as in the background.
+	RevalidateAfter time.Time
+}
+
+// CacheControl returns how long the verdict that a lookup of url just gave,
+// with the given threats, may be cached by clients. It relies on the same
+// database and cache contents as the lookup, so it must be called right
+// after it. Like InspectCache, it does not contact the API, nor change the
+// contents or statistics of the cache.
+func (wr *UpdateClient) CacheControl(url string, threats []URLThreat) (CacheControl, error) {
+	chs, err := wr.InspectCache(url)
+	if err != nil {
+		return CacheControl{}, err
+	}
+	now := wr.config.now()
+	unsafe := make(map[string]bool)
+	for _, t := range threats {
+		unsafe[t.Pattern] = true
+	}
+	var cc CacheControl
+	earliest := func(t time.Time) {
+		if t.Before(now) {
+			t = now
+		}
+		if cc.Expire.IsZero() || t.Before(cc.Expire) {
+			cc.Expire = t
+		}
+	}
+	dbExpire := wr.databaseExpire(now)
+	for _, ch := range chs {
+		if len(ch.DatabaseThreats) == 0 {
+			earliest(dbExpire)
+			continue
+		}
+		var cached time.Time
+		if unsafe[ch.Pattern] {
+			for _, t := range ch.Threats {
+				if t.After(now) && (cached.IsZero() || t.Before(cached)) {
+					cached = t
+				}
+			}
+		} else if ch.NegativeExpire.After(now) {
+			cached = ch.NegativeExpire
+		}
+		switch {
+		case !cached.IsZero():
+			earliest(cached)
+		case unsafe[ch.Pattern] && wr.config.ReadOnly:
+			// Matches of a read-only database are reported as they are.
+			earliest(dbExpire)
+		default:
+			earliest(now)
+		}
+	}
+	if cc.Expire.IsZero() {
+		// Only URLs that are not looked up, such as those of passed
+		// through schemes, have no hashes.
+		cc.Expire = dbExpire
+	}
+	cc.RevalidateAfter = cc.Expire
+	if next := wr.nextUpdate.Load(); next != 0 {
+		if t := time.Unix(0, next); t.Before(cc.RevalidateAfter) {
+			cc.RevalidateAfter = t
+		}
+	}
+	if cc.RevalidateAfter.Before(now) {
+		cc.RevalidateAfter = now
+	}
+	return cc, nil
+}
+
+// databaseExpire returns when the database stops showing URLs safe on its
+// own: when it goes stale, or, for a read-only database that never does,
+// when it is next reloaded.
+func (wr *UpdateClient) databaseExpire(now time.Time) time.Time {
+	if wr.db.Status() != nil {
+		return now
+	}
+	if wr.config.ReadOnly && wr.config.MaxDatabaseAge <= 0 {
+		if next := wr.nextUpdate.Load(); next != 0 {
+			return time.Unix(0, next)
+		}
+		return now.Add(wr.config.ReloadPeriod)
+	}
+	return wr.db.LastUpdate().Add(wr.db.maxAge())
+}
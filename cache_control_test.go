@@ -0,0 +1,112 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+	"github.com/google/webrisk/webrisktest"
+	timepb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestCacheControl(t *testing.T) {
+	start := time.Date(2023, 5, 24, 10, 0, 0, 0, time.UTC)
+	clock := webrisktest.NewClock(start)
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	evil := hashFromPattern("evil.com/")
+	phs := hashPrefixes{evil[:minHashPrefixLength], hashFromPattern("b.com/")[:minHashPrefixLength]}
+	phs.Sort()
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: start,
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	wr, err := NewUpdateClient(Config{
+		DBPath:         path,
+		ThreatLists:    []ThreatType{ThreatTypeMalware},
+		UpdatePeriod:   30 * time.Minute,
+		MaxDatabaseAge: 2 * time.Hour,
+		Clock:          clock,
+		api: &mockAPI{
+			hashLookup: func(context.Context, []byte, []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+				return &pb.SearchHashesResponse{
+					Threats: []*pb.SearchHashesResponse_ThreatHash{{
+						ThreatTypes: []pb.ThreatType{pb.ThreatType_MALWARE},
+						Hash:        []byte(evil),
+						ExpireTime:  timepb.New(start.Add(10 * time.Minute)),
+					}},
+					NegativeExpireTime: timepb.New(start.Add(5 * time.Minute)),
+				}, nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := clock.BlockUntil(ctx, 1); err != nil {
+		t.Fatalf("update not scheduled: %v", err)
+	}
+
+	vectors := []struct {
+		url        string
+		expire     time.Duration // From start
+		revalidate time.Duration
+	}{
+		// Safe according to the database alone, until it goes stale.
+		{url: "http://safe.com/", expire: 2 * time.Hour, revalidate: 30 * time.Minute},
+		// Unsafe until the cached match expires.
+		{url: "http://evil.com/", expire: 10 * time.Minute, revalidate: 10 * time.Minute},
+		// Safe until the negative cache entry expires.
+		{url: "http://b.com/", expire: 5 * time.Minute, revalidate: 5 * time.Minute},
+	}
+	for i, v := range vectors {
+		threats, err := wr.LookupURLs([]string{v.url})
+		if err != nil {
+			t.Fatalf("test %d, unexpected lookup error: %v", i, err)
+		}
+		cc, err := wr.CacheControl(v.url, threats[0])
+		if err != nil {
+			t.Fatalf("test %d, unexpected error: %v", i, err)
+		}
+		if want := start.Add(v.expire); !cc.Expire.Equal(want) {
+			t.Errorf("test %d, Expire = %v, want %v", i, cc.Expire, want)
+		}
+		if want := start.Add(v.revalidate); !cc.RevalidateAfter.Equal(want) {
+			t.Errorf("test %d, RevalidateAfter = %v, want %v", i, cc.RevalidateAfter, want)
+		}
+	}
+
+	// Expired cache entries give no guidance beyond the time of the lookup.
+	clock.Advance(20 * time.Minute)
+	cc, err := wr.CacheControl("http://b.com/", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := start.Add(20 * time.Minute); !cc.Expire.Equal(want) || !cc.RevalidateAfter.Equal(want) {
+		t.Errorf("CacheControl = %+v, want to expire at %v", cc, want)
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMemcachedKeyPrefix is the default prefix of the keys that a
+// MemcachedCache stores entries under.
+const DefaultMemcachedKeyPrefix = "webrisk:"
+
+// memcachedMaxRelative is the longest expiration time that memcached
+// interprets as relative to the current time. Longer ones are taken to be
+// absolute Unix times.
+const memcachedMaxRelative = 30 * 24 * time.Hour
+
+// MemcachedCache is a Cache that stores entries in one or more memcached
+// servers, so that several instances share the results of their hash
+// searches. Keys are distributed over the servers by their hash. Entries
+// expire in memcached when their TTL passes.
+//
+// As with RedisCache, errors are treated as cache misses and are counted by
+// Errors.
+type MemcachedCache struct {
+	// Addrs are the host:port addresses of the memcached servers.
+	Addrs []string
+
+	// KeyPrefix is prepended to every key. If empty,
+	// DefaultMemcachedKeyPrefix is used.
+	KeyPrefix string
+
+	// Timeout bounds each command, including connecting. If zero,
+	// DefaultRequestTimeout is used.
+	Timeout time.Duration
+
+	once   sync.Once
+	pools  []connPool // Idle connections, one pool for each of Addrs
+	errors int64
+	now    func() time.Time
+}
+
+// NewMemcachedCache returns a MemcachedCache for a comma-separated list of
+// host:port server addresses. The port defaults to 11211.
+func NewMemcachedCache(addrs string) (*MemcachedCache, error) {
+	mc := &MemcachedCache{}
+	for _, addr := range strings.Split(addrs, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		if !strings.Contains(addr, ":") {
+			addr += ":11211"
+		}
+		mc.Addrs = append(mc.Addrs, addr)
+	}
+	if len(mc.Addrs) == 0 {
+		return nil, errors.New("webrisk: no memcached servers")
+	}
+	return mc, nil
+}
+
+// Errors reports the number of operations that failed.
+func (mc *MemcachedCache) Errors() int64 {
+	return atomic.LoadInt64(&mc.errors)
+}
+
+func (mc *MemcachedCache) key(hash string) string {
+	prefix := mc.KeyPrefix
+	if prefix == "" {
+		prefix = DefaultMemcachedKeyPrefix
+	}
+	return prefix + hex.EncodeToString([]byte(hash))
+}
+
+// Get fetches the entry for hash.
+func (mc *MemcachedCache) Get(hash string) (CacheEntry, bool) {
+	var e CacheEntry
+	key := mc.key(hash)
+	b, err := mc.do(key, func(c *poolConn) ([]byte, error) {
+		if _, err := fmt.Fprintf(c, "get %s\r\n", key); err != nil {
+			return nil, err
+		}
+		return memcachedReadValue(c)
+	})
+	if err != nil {
+		atomic.AddInt64(&mc.errors, 1)
+		return e, false
+	}
+	if b == nil {
+		return e, false
+	}
+	if err := json.Unmarshal(b, &e); err != nil {
+		atomic.AddInt64(&mc.errors, 1)
+		return e, false
+	}
+	return e, true
+}
+
+// Set stores the entry for hash with a TTL that ends when the entry expires.
+// Since memcached expiration times have a resolution of one second, the TTL
+// is rounded up. Entries that have already expired are not stored.
+func (mc *MemcachedCache) Set(hash string, e CacheEntry) {
+	now := time.Now
+	if mc.now != nil {
+		now = mc.now
+	}
+	ttl := e.Expire().Sub(now())
+	if ttl <= 0 {
+		return
+	}
+	exptime := int64((ttl + time.Second - 1) / time.Second)
+	if ttl > memcachedMaxRelative {
+		exptime = e.Expire().Unix() + 1
+	}
+	b, err := json.Marshal(e)
+	if err == nil {
+		key := mc.key(hash)
+		_, err = mc.do(key, func(c *poolConn) ([]byte, error) {
+			if _, err := fmt.Fprintf(c, "set %s 0 %d %d\r\n%s\r\n", key, exptime, len(b), b); err != nil {
+				return nil, err
+			}
+			return nil, memcachedExpect(c, "STORED")
+		})
+	}
+	if err != nil {
+		atomic.AddInt64(&mc.errors, 1)
+	}
+}
+
+// Purge does nothing, since memcached removes expired entries by itself.
+func (mc *MemcachedCache) Purge() {}
+
+func (mc *MemcachedCache) timeout() time.Duration {
+	if mc.Timeout > 0 {
+		return mc.Timeout
+	}
+	return DefaultRequestTimeout
+}
+
+// do runs f on a pooled connection to the server responsible for key.
+func (mc *MemcachedCache) do(key string, f func(*poolConn) ([]byte, error)) ([]byte, error) {
+	if len(mc.Addrs) == 0 {
+		return nil, errors.New("webrisk: no memcached servers")
+	}
+	mc.once.Do(func() { mc.pools = make([]connPool, len(mc.Addrs)) })
+	i := int(crc32.ChecksumIEEE([]byte(key)) % uint32(len(mc.Addrs)))
+	conn := mc.pools[i].get()
+	if conn == nil {
+		var err error
+		if conn, err = dialConn(mc.Addrs[i], nil, mc.timeout()); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.SetDeadline(time.Now().Add(mc.timeout())); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	b, err := f(conn)
+	if err != nil {
+		// The connection is in an unknown state.
+		conn.Close()
+		return nil, err
+	}
+	mc.pools[i].put(conn)
+	return b, nil
+}
+
+// memcachedLine reads a single line of a reply.
+func memcachedLine(c *poolConn) (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", errors.New("webrisk: malformed memcached reply")
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+// memcachedExpect reads a reply and checks that it is want.
+func memcachedExpect(c *poolConn, want string) error {
+	line, err := memcachedLine(c)
+	if err != nil {
+		return err
+	}
+	if line != want {
+		return fmt.Errorf("webrisk: memcached error: %s", line)
+	}
+	return nil
+}
+
+// memcachedReadValue reads the reply to a get command for a single key. It
+// returns nil if the key was not found.
+func memcachedReadValue(c *poolConn) ([]byte, error) {
+	line, err := memcachedLine(c)
+	if err != nil {
+		return nil, err
+	}
+	if line == "END" {
+		return nil, nil
+	}
+	// VALUE <key> <flags> <bytes>
+	fields := strings.Fields(line)
+	if len(fields) != 4 || fields[0] != "VALUE" {
+		return nil, fmt.Errorf("webrisk: memcached error: %s", line)
+	}
+	n, err := strconv.Atoi(fields[3])
+	if err != nil || n < 0 {
+		return nil, errors.New("webrisk: malformed memcached reply")
+	}
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(c.r, buf); err != nil {
+		return nil, err
+	}
+	if err := memcachedExpect(c, "END"); err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
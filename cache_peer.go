@@ -0,0 +1,356 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PeerCachePath is the path under which a PeerCache serves its entries to
+// its peers.
+const PeerCachePath = "/_webrisk/peer/cache"
+
+// DefaultPeerTimeout is the default amount of time a request to a peer may
+// take before it is treated as a cache miss.
+const DefaultPeerTimeout = time.Second
+
+// DefaultPeerMaxEntries is the default number of entries that a PeerCache
+// keeps locally.
+const DefaultPeerMaxEntries = 1 << 20
+
+// PeerCache is a Cache shared between several instances that talk to each
+// other directly, without an external cache server. Each hash is owned by
+// one of the peers, chosen by rendezvous hashing. Entries are kept locally,
+// and also sent to the owner of their hash, which local misses are then
+// fetched from. This way, a hash search made by one instance is not repeated
+// by the others for as long as its result is valid.
+//
+// The peers serve each other through ServeHTTP, which must be reachable at
+// PeerCachePath under each of their base URLs. Errors talking to peers are
+// treated as cache misses and are counted by Errors.
+type PeerCache struct {
+	// Self is the base URL of this instance, as it appears in the list of
+	// peers.
+	Self string
+
+	// Secret, if set, is required from peers as a bearer token, and sent
+	// to them. Without it, anyone who can reach ServeHTTP can alter the
+	// results of lookups.
+	Secret string
+
+	// Timeout bounds each request to a peer. If zero, DefaultPeerTimeout
+	// is used.
+	Timeout time.Duration
+
+	// Client is used to talk to peers. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// MaxEntries limits the number of entries kept locally, including
+	// those sent by peers. When it is reached, expired entries are removed,
+	// and then arbitrary ones. If zero, DefaultPeerMaxEntries is used.
+	MaxEntries int
+
+	peers atomic.Pointer[[]string]
+
+	mu      sync.Mutex
+	entries map[string]CacheEntry
+
+	errors int64
+	now    func() time.Time
+}
+
+// NewPeerCache returns a PeerCache for the instance at the base URL self,
+// which shares entries with the given peers. The list of peers may include
+// self.
+func NewPeerCache(self string, peers []string) *PeerCache {
+	pc := &PeerCache{Self: strings.TrimSuffix(self, "/")}
+	pc.SetPeers(peers)
+	return pc
+}
+
+// SetPeers replaces the base URLs of the peers, such as when instances are
+// added or removed.
+func (pc *PeerCache) SetPeers(peers []string) {
+	ps := make([]string, 0, len(peers))
+	for _, p := range peers {
+		if p = strings.TrimSuffix(strings.TrimSpace(p), "/"); p != "" {
+			ps = append(ps, p)
+		}
+	}
+	pc.peers.Store(&ps)
+}
+
+// Peers returns the base URLs of the peers.
+func (pc *PeerCache) Peers() []string {
+	if ps := pc.peers.Load(); ps != nil {
+		return append([]string(nil), (*ps)...)
+	}
+	return nil
+}
+
+// Errors reports the number of requests to peers that failed.
+func (pc *PeerCache) Errors() int64 {
+	return atomic.LoadInt64(&pc.errors)
+}
+
+// owner returns the base URL of the peer that owns hash, or "" if it is
+// owned by this instance.
+func (pc *PeerCache) owner(hash string) string {
+	var owner string
+	var max uint64
+	if ps := pc.peers.Load(); ps != nil {
+		for _, p := range *ps {
+			h := fnv.New64a()
+			io.WriteString(h, p)
+			io.WriteString(h, hash)
+			if score := h.Sum64(); owner == "" || score > max {
+				owner, max = p, score
+			}
+		}
+	}
+	if owner == pc.Self {
+		return ""
+	}
+	return owner
+}
+
+func (pc *PeerCache) timeNow() time.Time {
+	if pc.now != nil {
+		return pc.now()
+	}
+	return time.Now()
+}
+
+// getLocal returns a copy of the local entry for hash, which the caller may
+// keep while the entry is replaced.
+func (pc *PeerCache) getLocal(hash string) (CacheEntry, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	e, ok := pc.entries[hash]
+	return e.clone(), ok
+}
+
+// setLocal stores a copy of e, so that the caller may keep using e.
+func (pc *PeerCache) setLocal(hash string, e CacheEntry) {
+	if !e.Expire().After(pc.timeNow()) {
+		return
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.entries == nil {
+		pc.entries = make(map[string]CacheEntry)
+	}
+	if _, ok := pc.entries[hash]; !ok {
+		pc.makeRoom()
+	}
+	pc.entries[hash] = e.clone()
+}
+
+// makeRoom removes entries if there are as many as allowed. Expired entries
+// are removed first, and then arbitrary ones, to leave room for an eighth
+// of the limit, so that this is not done on every new entry.
+//
+// This assumes that the lock is already held.
+func (pc *PeerCache) makeRoom() {
+	max := pc.MaxEntries
+	if max <= 0 {
+		max = DefaultPeerMaxEntries
+	}
+	if len(pc.entries) < max {
+		return
+	}
+	now := pc.timeNow()
+	for hash, e := range pc.entries {
+		if !e.Expire().After(now) {
+			delete(pc.entries, hash)
+		}
+	}
+	for hash := range pc.entries {
+		if len(pc.entries) <= max-max/8-1 {
+			break
+		}
+		delete(pc.entries, hash)
+	}
+}
+
+// Get returns the local entry for hash, or fetches it from the peer that
+// owns hash.
+func (pc *PeerCache) Get(hash string) (CacheEntry, bool) {
+	if e, ok := pc.getLocal(hash); ok {
+		return e, true
+	}
+	owner := pc.owner(hash)
+	if owner == "" {
+		return CacheEntry{}, false
+	}
+	e, ok, err := pc.fetch(owner, hash)
+	if err != nil {
+		atomic.AddInt64(&pc.errors, 1)
+		return CacheEntry{}, false
+	}
+	if ok {
+		pc.setLocal(hash, e)
+	}
+	return e, ok
+}
+
+// Set stores the entry for hash locally, and sends it to the peer that owns
+// hash in the background. Entries that have already expired are not stored.
+func (pc *PeerCache) Set(hash string, e CacheEntry) {
+	if !e.Expire().After(pc.timeNow()) {
+		return
+	}
+	pc.setLocal(hash, e)
+	if owner := pc.owner(hash); owner != "" {
+		// The entry is encoded while the caller may go on using it.
+		e := e.clone()
+		go func() {
+			if err := pc.send(owner, hash, e); err != nil {
+				atomic.AddInt64(&pc.errors, 1)
+			}
+		}()
+	}
+}
+
+// Purge removes the local entries that have expired.
+func (pc *PeerCache) Purge() {
+	now := pc.timeNow()
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	for hash, e := range pc.entries {
+		if !e.Expire().After(now) {
+			delete(pc.entries, hash)
+		}
+	}
+}
+
+// Clear removes all local entries. Entries held by peers are left alone.
+func (pc *PeerCache) Clear() error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.entries = nil
+	return nil
+}
+
+// ServeHTTP serves the local entries to peers, and accepts the entries that
+// they send for the hashes owned by this instance. The hash is given in hex
+// by the "h" query parameter.
+func (pc *PeerCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if pc.Secret != "" {
+		auth := r.Header.Get("Authorization")
+		if got := strings.TrimPrefix(auth, "Bearer "); got == auth || subtle.ConstantTimeCompare([]byte(got), []byte(pc.Secret)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	hash, err := hex.DecodeString(r.URL.Query().Get("h"))
+	if err != nil || len(hash) < minHashPrefixLength || len(hash) > maxHashPrefixLength {
+		http.Error(w, "invalid hash", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		e, ok := pc.getLocal(string(hash))
+		if !ok || !e.Expire().After(pc.timeNow()) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(e)
+	case "PUT":
+		var e CacheEntry
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&e); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pc.setLocal(string(hash), e)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "invalid method", http.StatusMethodNotAllowed)
+	}
+}
+
+// do sends a request for hash to the peer at base.
+func (pc *PeerCache) do(method, base, hash string, body []byte) (*http.Response, error) {
+	timeout := pc.Timeout
+	if timeout <= 0 {
+		timeout = DefaultPeerTimeout
+	}
+	client := pc.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	u := base + PeerCachePath + "?h=" + url.QueryEscape(hex.EncodeToString([]byte(hash)))
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if pc.Secret != "" {
+		req.Header.Set("Authorization", "Bearer "+pc.Secret)
+	}
+	// The timeout also covers reading the body, which is small.
+	c := *client
+	c.Timeout = timeout
+	return c.Do(req)
+}
+
+// fetch gets the entry for hash from the peer at base.
+func (pc *PeerCache) fetch(base, hash string) (CacheEntry, bool, error) {
+	var e CacheEntry
+	resp, err := pc.do("GET", base, hash, nil)
+	if err != nil {
+		return e, false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return e, false, nil
+	default:
+		return e, false, fmt.Errorf("webrisk: peer %s: %s", base, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+		return e, false, err
+	}
+	return e, true, nil
+}
+
+// send stores the entry for hash with the peer at base.
+func (pc *PeerCache) send(base, hash string, e CacheEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := pc.do("PUT", base, hash, b)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("webrisk: peer %s: %s", base, resp.Status)
+	}
+	return nil
+}
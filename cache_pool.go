@@ -0,0 +1,78 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxIdleConns is the number of idle connections kept to a cache server.
+const maxIdleConns = 8
+
+// poolConn is a buffered connection to a cache server.
+type poolConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// dialConn connects to addr, over TLS if tlsConf is set.
+func dialConn(addr string, tlsConf *tls.Config, timeout time.Duration) (*poolConn, error) {
+	d := &net.Dialer{Timeout: timeout}
+	var c net.Conn
+	var err error
+	if tlsConf != nil {
+		c, err = tls.DialWithDialer(d, "tcp", addr, tlsConf)
+	} else {
+		c, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &poolConn{Conn: c, r: bufio.NewReader(c)}, nil
+}
+
+// connPool keeps idle connections to a cache server. The zero value is an
+// empty pool.
+type connPool struct {
+	once sync.Once
+	idle chan *poolConn
+}
+
+func (p *connPool) conns() chan *poolConn {
+	p.once.Do(func() { p.idle = make(chan *poolConn, maxIdleConns) })
+	return p.idle
+}
+
+// get returns an idle connection, or nil if there is none.
+func (p *connPool) get() *poolConn {
+	select {
+	case c := <-p.conns():
+		return c
+	default:
+		return nil
+	}
+}
+
+// put returns a connection to the pool, or closes it if the pool is full.
+func (p *connPool) put(c *poolConn) {
+	select {
+	case p.conns() <- c:
+	default:
+		c.Close()
+	}
+}
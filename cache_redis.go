@@ -0,0 +1,336 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRedisKeyPrefix is the default prefix of the keys that a RedisCache
+// stores entries under.
+const DefaultRedisKeyPrefix = "webrisk:"
+
+// RedisCache is a Cache that stores entries in a Redis server, so that
+// several instances behind a load balancer share the results of their hash
+// searches. Entries expire in Redis when their TTL passes.
+//
+// Errors talking to Redis are treated as cache misses, so that an unavailable
+// Redis server only results in more API calls. The number of errors is
+// reported by Errors.
+type RedisCache struct {
+	// Addr is the host:port address of the Redis server.
+	Addr string
+
+	// Password and Username, if set, are used to authenticate. Username
+	// requires Redis 6 or later.
+	Username string
+	Password string
+
+	// DB is the number of the database to select.
+	DB int
+
+	// TLS, if set, is used to connect to the server over TLS.
+	TLS *tls.Config
+
+	// KeyPrefix is prepended to every key. If empty, DefaultRedisKeyPrefix
+	// is used.
+	KeyPrefix string
+
+	// Timeout bounds each command, including connecting. If zero,
+	// DefaultRequestTimeout is used.
+	Timeout time.Duration
+
+	pool   connPool
+	errors int64
+	now    func() time.Time
+}
+
+// NewRedisCache returns a RedisCache for a URL of the form
+// "redis://[[username]:password@]host[:port][/db]". The "rediss" scheme
+// connects over TLS.
+func NewRedisCache(rawurl string) (*RedisCache, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	rc := &RedisCache{Addr: u.Host}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		rc.TLS = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, errors.New("webrisk: invalid Redis URL scheme: " + u.Scheme)
+	}
+	if u.Port() == "" {
+		rc.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		rc.Username = u.User.Username()
+		rc.Password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if rc.DB, err = strconv.Atoi(db); err != nil {
+			return nil, errors.New("webrisk: invalid Redis database: " + db)
+		}
+	}
+	return rc, nil
+}
+
+// Errors reports the number of operations that failed.
+func (rc *RedisCache) Errors() int64 {
+	return atomic.LoadInt64(&rc.errors)
+}
+
+func (rc *RedisCache) prefix() string {
+	if rc.KeyPrefix == "" {
+		return DefaultRedisKeyPrefix
+	}
+	return rc.KeyPrefix
+}
+
+func (rc *RedisCache) key(hash string) string {
+	return rc.prefix() + hex.EncodeToString([]byte(hash))
+}
+
+// Get fetches the entry for hash.
+func (rc *RedisCache) Get(hash string) (CacheEntry, bool) {
+	var e CacheEntry
+	reply, err := rc.do("GET", rc.key(hash))
+	if err != nil {
+		atomic.AddInt64(&rc.errors, 1)
+		return e, false
+	}
+	b, ok := reply.([]byte)
+	if !ok {
+		return e, false
+	}
+	if err := json.Unmarshal(b, &e); err != nil {
+		atomic.AddInt64(&rc.errors, 1)
+		return e, false
+	}
+	return e, true
+}
+
+// Set stores the entry for hash with a TTL that ends when the entry expires.
+// Entries that have already expired are not stored.
+func (rc *RedisCache) Set(hash string, e CacheEntry) {
+	now := time.Now
+	if rc.now != nil {
+		now = rc.now
+	}
+	ttl := e.Expire().Sub(now()).Milliseconds()
+	if ttl <= 0 {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err == nil {
+		_, err = rc.do("SET", rc.key(hash), string(b), "PX", strconv.FormatInt(ttl, 10))
+	}
+	if err != nil {
+		atomic.AddInt64(&rc.errors, 1)
+	}
+}
+
+// Purge does nothing, since Redis removes expired entries by itself.
+func (rc *RedisCache) Purge() {}
+
+// Clear deletes all entries stored under the key prefix. Keys that merely
+// start with the prefix, such as those stored under a longer prefix by
+// another instance, are left alone.
+func (rc *RedisCache) Clear() error {
+	prefix := rc.prefix()
+	pattern := redisGlobEscaper.Replace(prefix) + "*"
+	cursor := "0"
+	for {
+		reply, err := rc.do("SCAN", cursor, "MATCH", pattern, "COUNT", "1000")
+		if err != nil {
+			return err
+		}
+		page, ok := reply.([]interface{})
+		if !ok || len(page) != 2 {
+			return errors.New("webrisk: malformed Redis reply")
+		}
+		next, ok1 := page[0].([]byte)
+		keys, ok2 := page[1].([]interface{})
+		if !ok1 || !ok2 {
+			return errors.New("webrisk: malformed Redis reply")
+		}
+		del := []string{"DEL"}
+		for _, k := range keys {
+			key, ok := k.([]byte)
+			if !ok || !strings.HasPrefix(string(key), prefix) {
+				continue
+			}
+			if _, err := hex.DecodeString(string(key[len(prefix):])); err == nil {
+				del = append(del, string(key))
+			}
+		}
+		if len(del) > 1 {
+			if _, err := rc.do(del...); err != nil {
+				return err
+			}
+		}
+		if cursor = string(next); cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// redisGlobEscaper escapes the characters that are special in the patterns
+// of the Redis SCAN command.
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+func (rc *RedisCache) timeout() time.Duration {
+	if rc.Timeout > 0 {
+		return rc.Timeout
+	}
+	return DefaultRequestTimeout
+}
+
+// do runs a single command on a pooled connection.
+func (rc *RedisCache) do(args ...string) (interface{}, error) {
+	conn, err := rc.conn()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := redisDo(conn, rc.timeout(), args...)
+	if err != nil {
+		var rerr redisError
+		if !errors.As(err, &rerr) {
+			// The connection is in an unknown state.
+			conn.Close()
+			return nil, err
+		}
+	}
+	rc.pool.put(conn)
+	return reply, err
+}
+
+// conn returns an idle connection, or dials and sets up a new one.
+func (rc *RedisCache) conn() (*poolConn, error) {
+	if conn := rc.pool.get(); conn != nil {
+		return conn, nil
+	}
+	conn, err := dialConn(rc.Addr, rc.TLS, rc.timeout())
+	if err != nil {
+		return nil, err
+	}
+	var setup [][]string
+	switch {
+	case rc.Username != "":
+		setup = append(setup, []string{"AUTH", rc.Username, rc.Password})
+	case rc.Password != "":
+		setup = append(setup, []string{"AUTH", rc.Password})
+	}
+	if rc.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(rc.DB)})
+	}
+	for _, args := range setup {
+		if _, err := redisDo(conn, rc.timeout(), args...); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("webrisk: Redis %s failed: %v", args[0], err)
+		}
+	}
+	return conn, nil
+}
+
+// redisError is an error reply sent by the server.
+type redisError string
+
+func (e redisError) Error() string { return "webrisk: Redis error: " + string(e) }
+
+// redisDo sends a command in the Redis serialization protocol and reads its
+// reply. Bulk strings are returned as
+// []byte, nil bulk strings as nil, integers as int64, simple strings as
+// string, and arrays as []interface{}. Error replies are returned as a
+// redisError.
+func redisDo(c *poolConn, timeout time.Duration, args ...string) (interface{}, error) {
+	if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c, b.String()); err != nil {
+		return nil, err
+	}
+	return redisReadReply(c)
+}
+
+func redisReadReply(c *poolConn) (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("webrisk: malformed Redis reply")
+	}
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, errors.New("webrisk: malformed Redis reply")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, errors.New("webrisk: malformed Redis reply")
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		var elems []interface{}
+		for i := 0; i < n; i++ {
+			elem, err := redisReadReply(c)
+			if err != nil {
+				var rerr redisError
+				if !errors.As(err, &rerr) {
+					return nil, err
+				}
+				elem = rerr
+			}
+			elems = append(elems, elem)
+		}
+		return elems, nil
+	}
+	return nil, fmt.Errorf("webrisk: unsupported Redis reply type %q", kind)
+}
@@ -0,0 +1,25 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package clients holds the OpenAPI definition of the endpoints of wrserver,
+// in openapi.json, and the reference clients generated from it: a Python
+// module in python/ and a TypeScript module in typescript/. Neither needs
+// more than the standard library of its language.
+//
+// After changing the definition, regenerate the clients with:
+//
+//	$ go generate ./clients
+package clients
+
+//go:generate go run ./internal/gen -spec openapi.json -python python/wrserver_client.py -typescript typescript/wrserver_client.ts
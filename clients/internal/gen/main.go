@@ -0,0 +1,314 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command gen generates the reference clients of wrserver from its OpenAPI
+// definition.
+//
+// It only understands the subset of OpenAPI 3 that the definition uses:
+// operations with query parameters, JSON or plain text request bodies, and
+// JSON or binary responses, whose objects are all named schemas of simple
+// types. It is run by go generate in the clients directory:
+//
+//	$ go generate ./clients
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	mimeJSON   = "application/json"
+	mimeText   = "text/plain"
+	schemaRefs = "#/components/schemas/"
+)
+
+// spec is the part of an OpenAPI definition that clients are generated from.
+type spec struct {
+	Info struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	} `json:"info"`
+	Paths      ordered[map[string]*operation] `json:"paths"`
+	Components struct {
+		Schemas ordered[*schema] `json:"schemas"`
+	} `json:"components"`
+}
+
+type operation struct {
+	OperationID string      `json:"operationId"`
+	Summary     string      `json:"summary"`
+	Parameters  []parameter `json:"parameters"`
+	RequestBody *struct {
+		Content map[string]mediaType `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Content map[string]mediaType `json:"content"`
+	} `json:"responses"`
+	Security []map[string][]string `json:"security"`
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description"`
+	Required    bool    `json:"required"`
+	Schema      *schema `json:"schema"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+type schema struct {
+	Ref                  string           `json:"$ref"`
+	Type                 string           `json:"type"`
+	Format               string           `json:"format"`
+	Description          string           `json:"description"`
+	Enum                 []string         `json:"enum"`
+	Items                *schema          `json:"items"`
+	Properties           ordered[*schema] `json:"properties"`
+	Required             []string         `json:"required"`
+	AdditionalProperties json.RawMessage  `json:"additionalProperties"`
+}
+
+// ordered is a JSON object whose members are kept in order.
+type ordered[T any] []member[T]
+
+type member[T any] struct {
+	Name  string
+	Value T
+}
+
+func (o *ordered[T]) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return fmt.Errorf("not a JSON object: %.20s", data)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		m := member[T]{Name: tok.(string)}
+		if err := dec.Decode(&m.Value); err != nil {
+			return fmt.Errorf("%s: %v", m.Name, err)
+		}
+		*o = append(*o, m)
+	}
+	return nil
+}
+
+// api is what the clients are generated from.
+type api struct {
+	Title, Description string
+	Schemas            []object
+	Ops                []op
+}
+
+// object is a named schema of JSON objects.
+type object struct {
+	Name, Description string
+	Fields            []field
+}
+
+type field struct {
+	Name, Description string
+	Type              *schema
+	Required          bool
+}
+
+// op is an operation.
+type op struct {
+	Name, Summary, Method, Path string
+	Params                      []field // Query parameters, required ones first
+	BodyType                    string  // Content type of the request body, if any
+	Body                        *schema
+	Result                      *schema // JSON result, if any
+	Binary                      bool    // Whether the result is raw bytes
+	Auth                        bool    // Whether the admin token is required
+}
+
+// refName returns the name of the schema that s refers to, if any.
+func refName(s *schema) string {
+	return strings.TrimPrefix(s.Ref, schemaRefs)
+}
+
+// newAPI checks that s only uses what the generators understand, and
+// returns what they need of it.
+func newAPI(s *spec) (*api, error) {
+	a := &api{Title: s.Info.Title, Description: s.Info.Description}
+	names := make(map[string]bool)
+	for _, m := range s.Components.Schemas {
+		names[m.Name] = true
+	}
+	var check func(where string, s *schema) error
+	check = func(where string, s *schema) error {
+		switch {
+		case s.Ref != "":
+			if !strings.HasPrefix(s.Ref, schemaRefs) || !names[refName(s)] {
+				return fmt.Errorf("%s: unknown schema %s", where, s.Ref)
+			}
+		case s.Type == "array":
+			if s.Items == nil {
+				return fmt.Errorf("%s: array without items", where)
+			}
+			return check(where, s.Items)
+		case s.Type == "object":
+			if len(s.Properties) > 0 {
+				return fmt.Errorf("%s: inline object, use a named schema", where)
+			}
+		case s.Type == "string", s.Type == "integer", s.Type == "number", s.Type == "boolean":
+		default:
+			return fmt.Errorf("%s: unsupported type %q", where, s.Type)
+		}
+		return nil
+	}
+
+	for _, m := range s.Components.Schemas {
+		if m.Value.Type != "object" {
+			return nil, fmt.Errorf("schema %s: not an object", m.Name)
+		}
+		o := object{Name: m.Name, Description: m.Value.Description}
+		required := make(map[string]bool)
+		for _, name := range m.Value.Required {
+			required[name] = true
+		}
+		for _, p := range m.Value.Properties {
+			if err := check(m.Name+"."+p.Name, p.Value); err != nil {
+				return nil, err
+			}
+			o.Fields = append(o.Fields, field{Name: p.Name, Description: p.Value.Description, Type: p.Value, Required: required[p.Name]})
+		}
+		a.Schemas = append(a.Schemas, o)
+	}
+
+	for _, path := range s.Paths {
+		methods := make([]string, 0, len(path.Value))
+		for method := range path.Value {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			o := path.Value[method]
+			if o.OperationID == "" {
+				return nil, fmt.Errorf("%s %s: no operationId", method, path.Name)
+			}
+			x := op{Name: o.OperationID, Summary: o.Summary, Method: strings.ToUpper(method), Path: path.Name, Auth: len(o.Security) > 0}
+			for _, p := range o.Parameters {
+				if p.In != "query" || p.Schema == nil || p.Schema.Type != "string" {
+					return nil, fmt.Errorf("%s: parameter %s is not a string in the query", x.Name, p.Name)
+				}
+				x.Params = append(x.Params, field{Name: p.Name, Description: p.Description, Type: p.Schema, Required: p.Required})
+			}
+			sort.SliceStable(x.Params, func(i, j int) bool { return x.Params[i].Required && !x.Params[j].Required })
+			if o.RequestBody != nil {
+				for _, mt := range []string{mimeJSON, mimeText} {
+					if c, ok := o.RequestBody.Content[mt]; ok {
+						x.BodyType, x.Body = mt, c.Schema
+						break
+					}
+				}
+				if x.Body == nil {
+					return nil, fmt.Errorf("%s: unsupported request body", x.Name)
+				}
+				if err := check(x.Name+" body", x.Body); err != nil {
+					return nil, err
+				}
+			}
+			resp, ok := o.Responses["200"]
+			if !ok {
+				return nil, fmt.Errorf("%s: no 200 response", x.Name)
+			}
+			if c, ok := resp.Content[mimeJSON]; ok {
+				if err := check(x.Name+" result", c.Schema); err != nil {
+					return nil, err
+				}
+				x.Result = c.Schema
+			} else {
+				x.Binary = len(resp.Content) > 0
+			}
+			a.Ops = append(a.Ops, x)
+		}
+	}
+	return a, nil
+}
+
+// readAPI reads the OpenAPI definition at path.
+func readAPI(path string) (*api, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s spec
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return newAPI(&s)
+}
+
+// wrap breaks text into lines of at most width columns, each starting with
+// prefix.
+func wrap(text, prefix string, width int) []string {
+	var lines []string
+	line := prefix
+	for _, word := range strings.Fields(text) {
+		if line != prefix && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = prefix
+		}
+		if line != prefix {
+			line += " "
+		}
+		line += word
+	}
+	if line != prefix {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// header is the first line of the generated files, which tools recognize as
+// generated code.
+func header(comment string) string {
+	return comment + " Code generated by clients/internal/gen from openapi.json. DO NOT EDIT.\n"
+}
+
+func main() {
+	specFlag := flag.String("spec", "openapi.json", "path to the OpenAPI definition")
+	pythonFlag := flag.String("python", "", "path to write the Python client to")
+	tsFlag := flag.String("typescript", "", "path to write the TypeScript client to")
+	flag.Parse()
+	log.SetFlags(0)
+	log.SetPrefix("gen: ")
+
+	a, err := readAPI(*specFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for path, gen := range map[string]func(*api) []byte{*pythonFlag: python, *tsFlag: typescript} {
+		if path == "" {
+			continue
+		}
+		if err := os.WriteFile(path, gen(a), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGeneratedClients(t *testing.T) {
+	a, err := readAPI("../../openapi.json")
+	if err != nil {
+		t.Fatalf("readAPI: %v", err)
+	}
+	for path, gen := range map[string]func(*api) []byte{
+		"../../python/wrserver_client.py":     python,
+		"../../typescript/wrserver_client.ts": typescript,
+	} {
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(got) != string(gen(a)) {
+			t.Errorf("%s is out of date, run go generate ./clients", path)
+		}
+	}
+}
+
+func TestNewAPI(t *testing.T) {
+	const op = `"operationId": "lookup", "responses": {"200": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Result"}}}}}`
+	const result = `"Result": {"type": "object", "properties": {"safe": {"type": "boolean"}}}`
+	vectors := []struct {
+		spec string
+		err  string
+	}{
+		{spec: `{"paths": {"/lookup": {"get": {` + op + `}}}, "components": {"schemas": {` + result + `}}}`},
+		{
+			spec: `{"paths": {"/lookup": {"get": {` + op + `}}}}`,
+			err:  "unknown schema",
+		},
+		{
+			spec: `{"paths": {"/lookup": {"get": {"responses": {}}}}}`,
+			err:  "no operationId",
+		},
+		{
+			spec: `{"paths": {"/lookup": {"get": {"operationId": "lookup", "responses": {}}}}}`,
+			err:  "no 200 response",
+		},
+		{
+			spec: `{"paths": {"/lookup/{id}": {"get": {"operationId": "lookup", "parameters": [{"name": "id", "in": "path", "schema": {"type": "string"}}]}}}}`,
+			err:  "not a string in the query",
+		},
+		{
+			spec: `{"paths": {"/lookup": {"post": {"operationId": "lookup", "requestBody": {"content": {"text/html": {"schema": {"type": "string"}}}}}}}}`,
+			err:  "unsupported request body",
+		},
+		{
+			spec: `{"components": {"schemas": {"Result": {"type": "object", "properties": {"threat": {"type": "object", "properties": {"safe": {"type": "boolean"}}}}}}}}`,
+			err:  "inline object",
+		},
+		{
+			spec: `{"components": {"schemas": {"Result": {"type": "string"}}}}`,
+			err:  "not an object",
+		},
+	}
+	for i, v := range vectors {
+		var s spec
+		if err := json.Unmarshal([]byte(v.spec), &s); err != nil {
+			t.Fatalf("test %d, unexpected error: %v", i, err)
+		}
+		_, err := newAPI(&s)
+		if (v.err == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), v.err)) {
+			t.Errorf("test %d, newAPI error = %v, want %q", i, err, v.err)
+		}
+	}
+}
+
+func TestOrdered(t *testing.T) {
+	var o ordered[int]
+	if err := json.Unmarshal([]byte(`{"b": 1, "a": 2, "c": 3}`), &o); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := ordered[int]{{"b", 1}, {"a", 2}, {"c", 3}}
+	if !reflect.DeepEqual(o, want) {
+		t.Errorf("ordered = %v, want %v", o, want)
+	}
+	if err := json.Unmarshal([]byte(`[1]`), &o); err == nil {
+		t.Errorf("unexpected success for an array")
+	}
+}
+
+func TestWrap(t *testing.T) {
+	vectors := []struct {
+		text  string
+		width int
+		want  []string
+	}{
+		{text: "", width: 10},
+		{text: "one two three", width: 20, want: []string{"# one two three"}},
+		{text: "one two three", width: 10, want: []string{"# one two", "# three"}},
+		{text: "averyveryverylongword two", width: 10, want: []string{"# averyveryverylongword", "# two"}},
+	}
+	for i, v := range vectors {
+		if got := wrap(v.text, "# ", v.width); !reflect.DeepEqual(got, v.want) {
+			t.Errorf("test %d, wrap = %q, want %q", i, got, v.want)
+		}
+	}
+}
+
+func TestSnake(t *testing.T) {
+	for in, want := range map[string]string{"lookup": "lookup", "searchUris": "search_uris", "exportDatabase": "export_database"} {
+		if got := snake(in); got != want {
+			t.Errorf("snake(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// snake converts a camel case name to snake case.
+func snake(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// pyType returns the Python type of values of s. References to other
+// schemas are quoted when forward, so that they may be used before they
+// are defined.
+func pyType(s *schema, forward bool) string {
+	switch {
+	case s.Ref != "":
+		if forward {
+			return fmt.Sprintf("%q", refName(s))
+		}
+		return refName(s)
+	case len(s.Enum) > 0:
+		return fmt.Sprintf("Literal[%s]", quoteAll(s.Enum))
+	case s.Type == "array":
+		return "List[" + pyType(s.Items, forward) + "]"
+	case s.Type == "object":
+		return "Dict[str, Any]"
+	case s.Type == "string" && s.Format == "binary":
+		return "bytes"
+	case s.Type == "integer":
+		return "int"
+	case s.Type == "number":
+		return "float"
+	case s.Type == "boolean":
+		return "bool"
+	}
+	return "str"
+}
+
+// quoteAll returns the values as a comma-separated list of quoted strings.
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+const pythonRuntime = `import json
+import urllib.error
+import urllib.parse
+import urllib.request
+from typing import Any, Dict, List, Literal, Optional, TypedDict
+`
+
+const pythonClient = `
+
+class WrserverError(Exception):
+    """An error answered by wrserver, with its problem details."""
+
+    def __init__(self, status: int, problem: Problem):
+        super().__init__(problem.get("detail") or problem.get("title") or "HTTP %d" % status)
+        self.status = status
+        self.problem = problem
+        self.code = problem.get("code", "")
+        self.request_id = problem.get("requestId", "")
+
+
+class Client:
+    """Calls the endpoints of the wrserver at base_url.
+
+    The base URL of a tenant ends with /t/<name>. The admin endpoints
+    require the admin token of the server.
+    """
+
+    def __init__(self, base_url: str = "http://localhost:8080", token: Optional[str] = None, timeout: float = 30.0):
+        self.base_url = base_url.rstrip("/")
+        self.token = token
+        self.timeout = timeout
+
+    def _call(self, method: str, path: str, query: Optional[Dict[str, Optional[str]]] = None,
+              body: Any = None, content_type: Optional[str] = None, auth: bool = False) -> Any:
+        url = self.base_url + path
+        params = {k: v for k, v in (query or {}).items() if v is not None}
+        if params:
+            url += "?" + urllib.parse.urlencode(params)
+        headers = {}
+        data = None
+        if content_type == "application/json":
+            data = json.dumps(body).encode()
+        elif content_type is not None:
+            data = body.encode() if isinstance(body, str) else body
+        if content_type is not None:
+            headers["Content-Type"] = content_type
+        if auth:
+            if not self.token:
+                raise ValueError("an admin token is required")
+            headers["Authorization"] = "Bearer " + self.token
+        req = urllib.request.Request(url, data=data, headers=headers, method=method)
+        try:
+            with urllib.request.urlopen(req, timeout=self.timeout) as resp:
+                payload = resp.read()
+                if resp.headers.get_content_type() == "application/json":
+                    return json.loads(payload)
+                return payload
+        except urllib.error.HTTPError as e:
+            try:
+                problem = json.loads(e.read())
+            except ValueError:
+                problem = {"title": e.reason, "status": e.code}
+            raise WrserverError(e.code, problem) from None
+`
+
+// python returns the source of the Python client of a.
+func python(a *api) []byte {
+	var b strings.Builder
+	b.WriteString(header("#"))
+	fmt.Fprintf(&b, "\"\"\"Client of %s.\n\n", a.Title)
+	for _, line := range wrap(a.Description, "", 79) {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("\nRequires Python 3.8 or later, and no other package.\n\"\"\"\n\n")
+	b.WriteString(pythonRuntime)
+
+	for _, o := range a.Schemas {
+		fmt.Fprintf(&b, "\n\nclass %s(TypedDict, total=False):\n", o.Name)
+		if o.Description != "" {
+			fmt.Fprintf(&b, "    \"\"\"%s\"\"\"\n\n", o.Description)
+		}
+		for _, f := range o.Fields {
+			for _, line := range wrap(f.Description, "    # ", 79) {
+				b.WriteString(line + "\n")
+			}
+			fmt.Fprintf(&b, "    %s: %s\n", f.Name, pyType(f.Type, true))
+		}
+	}
+	b.WriteString(pythonClient)
+
+	for _, x := range a.Ops {
+		args := []string{"self"}
+		query := []string{}
+		for _, p := range x.Params {
+			if p.Required {
+				args = append(args, fmt.Sprintf("%s: %s", p.Name, pyType(p.Type, false)))
+			} else {
+				args = append(args, fmt.Sprintf("%s: Optional[%s] = None", p.Name, pyType(p.Type, false)))
+			}
+			query = append(query, fmt.Sprintf("%q: %s", p.Name, p.Name))
+		}
+		if x.Body != nil {
+			args = append(args, "body: "+pyType(x.Body, false))
+		}
+		result := "None"
+		switch {
+		case x.Result != nil:
+			result = pyType(x.Result, false)
+		case x.Binary:
+			result = "bytes"
+		}
+		fmt.Fprintf(&b, "\n    def %s(%s) -> %s:\n", snake(x.Name), strings.Join(args, ", "), result)
+		fmt.Fprintf(&b, "        \"\"\"%s", x.Summary)
+		documented := false
+		for _, p := range x.Params {
+			if p.Description == "" {
+				continue
+			}
+			if !documented {
+				b.WriteString("\n\n        Args:\n")
+				documented = true
+			}
+			lines := wrap(p.Description, "", 79-14-len(p.Name))
+			fmt.Fprintf(&b, "            %s: %s\n", p.Name, strings.Join(lines, "\n                "))
+		}
+		if documented {
+			b.WriteString("        \"\"\"\n")
+		} else {
+			b.WriteString("\"\"\"\n")
+		}
+		call := []string{fmt.Sprintf("%q", x.Method), fmt.Sprintf("%q", x.Path)}
+		if len(query) > 0 {
+			call = append(call, "query={"+strings.Join(query, ", ")+"}")
+		}
+		if x.Body != nil {
+			call = append(call, "body=body", fmt.Sprintf("content_type=%q", x.BodyType))
+		}
+		if x.Auth {
+			call = append(call, "auth=True")
+		}
+		ret := "return "
+		if result == "None" {
+			ret = ""
+		}
+		fmt.Fprintf(&b, "        %sself._call(%s)\n", ret, strings.Join(call, ", "))
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,234 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tsType returns the TypeScript type of values of s.
+func tsType(s *schema) string {
+	switch {
+	case s.Ref != "":
+		return refName(s)
+	case len(s.Enum) > 0:
+		return strings.Join(strings.Split(quoteAll(s.Enum), ", "), " | ")
+	case s.Type == "array":
+		if t := tsType(s.Items); !strings.Contains(t, " ") {
+			return t + "[]"
+		}
+		return "(" + tsType(s.Items) + ")[]"
+	case s.Type == "object":
+		return "Record<string, unknown>"
+	case s.Type == "string" && s.Format == "binary":
+		return "Uint8Array"
+	case s.Type == "integer", s.Type == "number":
+		return "number"
+	case s.Type == "boolean":
+		return "boolean"
+	}
+	return "string"
+}
+
+// tsDoc writes text as a JSDoc comment indented by indent, followed by the
+// descriptions of the given parameters, if any.
+func tsDoc(b *strings.Builder, text, indent string, params ...field) {
+	if text == "" {
+		return
+	}
+	lines := wrap(text, "", 80-len(indent)-7)
+	for _, p := range params {
+		if p.Description != "" {
+			for i, line := range wrap("@param "+p.Name+" "+p.Description, "", 80-len(indent)-9) {
+				if i > 0 {
+					line = "  " + line
+				}
+				lines = append(lines, line)
+			}
+		}
+	}
+	if len(lines) == 1 {
+		fmt.Fprintf(b, "%s/** %s */\n", indent, lines[0])
+		return
+	}
+	fmt.Fprintf(b, "%s/**\n", indent)
+	for _, line := range lines {
+		fmt.Fprintf(b, "%s * %s\n", indent, line)
+	}
+	fmt.Fprintf(b, "%s */\n", indent)
+}
+
+const tsClient = `
+/** An error answered by wrserver, with its problem details. */
+export class WrserverError extends Error {
+  constructor(
+    readonly status: number,
+    readonly problem: Problem,
+  ) {
+    super(problem.detail || problem.title || ` + "`HTTP ${status}`" + `);
+    this.name = "WrserverError";
+  }
+
+  /** What failed, such as backend_unavailable. */
+  get code(): string {
+    return this.problem.code;
+  }
+}
+
+export interface ClientOptions {
+  /** Admin token of the server, for the admin endpoints. */
+  token?: string;
+  /** Implementation of fetch to use instead of the global one. */
+  fetch?: typeof fetch;
+}
+
+interface Call {
+  query?: Record<string, string | undefined>;
+  body?: string;
+  contentType?: string;
+  auth?: boolean;
+}
+
+/**
+ * Calls the endpoints of the wrserver at baseUrl. The base URL of a tenant
+ * ends with /t/<name>. The admin endpoints require the admin token of the
+ * server.
+ */
+export class WrserverClient {
+  private readonly baseUrl: string;
+  private readonly token?: string;
+  private readonly fetch: typeof fetch;
+
+  constructor(baseUrl = "http://localhost:8080", options: ClientOptions = {}) {
+    this.baseUrl = baseUrl.replace(/\/+$/, "");
+    this.token = options.token;
+    this.fetch = options.fetch ?? globalThis.fetch.bind(globalThis);
+  }
+
+  private async call(method: string, path: string, call: Call = {}): Promise<Response> {
+    const query = new URLSearchParams();
+    for (const [name, value] of Object.entries(call.query ?? {})) {
+      if (value !== undefined) {
+        query.set(name, value);
+      }
+    }
+    const url = this.baseUrl + path + (query.toString() ? "?" + query.toString() : "");
+    const headers: Record<string, string> = {};
+    if (call.contentType) {
+      headers["Content-Type"] = call.contentType;
+    }
+    if (call.auth) {
+      if (!this.token) {
+        throw new Error("an admin token is required");
+      }
+      headers["Authorization"] = "Bearer " + this.token;
+    }
+    const resp = await this.fetch(url, { method, headers, body: call.body });
+    if (!resp.ok) {
+      let problem: Problem;
+      try {
+        problem = (await resp.json()) as Problem;
+      } catch {
+        problem = { type: "about:blank", title: resp.statusText, status: resp.status, code: "" };
+      }
+      throw new WrserverError(resp.status, problem);
+    }
+    return resp;
+  }
+`
+
+// typescript returns the source of the TypeScript client of a.
+func typescript(a *api) []byte {
+	var b strings.Builder
+	b.WriteString(header("//"))
+	b.WriteString("\n/**\n")
+	fmt.Fprintf(&b, " * Client of %s.\n *\n", a.Title)
+	for _, line := range wrap(a.Description, " * ", 80) {
+		b.WriteString(line + "\n")
+	}
+	b.WriteString(" *\n * Requires the fetch API, as in browsers, Deno and Node.js 18 or later.\n */\n")
+
+	for _, o := range a.Schemas {
+		b.WriteString("\n")
+		tsDoc(&b, o.Description, "")
+		fmt.Fprintf(&b, "export interface %s {\n", o.Name)
+		for _, f := range o.Fields {
+			tsDoc(&b, f.Description, "  ")
+			optional := "?"
+			if f.Required {
+				optional = ""
+			}
+			fmt.Fprintf(&b, "  %s%s: %s;\n", f.Name, optional, tsType(f.Type))
+		}
+		b.WriteString("}\n")
+	}
+	b.WriteString(tsClient)
+
+	for _, x := range a.Ops {
+		var args, query []string
+		for _, p := range x.Params {
+			optional := "?"
+			if p.Required {
+				optional = ""
+			}
+			args = append(args, fmt.Sprintf("%s%s: %s", p.Name, optional, tsType(p.Type)))
+			query = append(query, p.Name)
+		}
+		var call []string
+		if len(query) > 0 {
+			call = append(call, "query: { "+strings.Join(query, ", ")+" }")
+		}
+		if x.Body != nil {
+			args = append(args, "body: "+tsType(x.Body))
+			if x.BodyType == mimeJSON {
+				call = append(call, "body: JSON.stringify(body)")
+			} else {
+				call = append(call, "body")
+			}
+			call = append(call, fmt.Sprintf("contentType: %q", x.BodyType))
+		}
+		if x.Auth {
+			call = append(call, "auth: true")
+		}
+		result := "void"
+		switch {
+		case x.Result != nil:
+			result = tsType(x.Result)
+		case x.Binary:
+			result = "Uint8Array"
+		}
+		b.WriteString("\n")
+		tsDoc(&b, x.Summary, "  ", x.Params...)
+		fmt.Fprintf(&b, "  async %s(%s): Promise<%s> {\n", x.Name, strings.Join(args, ", "), result)
+		callArgs := fmt.Sprintf("%q, %q", x.Method, x.Path)
+		if len(call) > 0 {
+			callArgs += ", { " + strings.Join(call, ", ") + " }"
+		}
+		switch result {
+		case "void":
+			fmt.Fprintf(&b, "    await this.call(%s);\n", callArgs)
+		case "Uint8Array":
+			fmt.Fprintf(&b, "    const resp = await this.call(%s);\n", callArgs)
+			b.WriteString("    return new Uint8Array(await resp.arrayBuffer());\n")
+		default:
+			fmt.Fprintf(&b, "    const resp = await this.call(%s);\n", callArgs)
+			fmt.Fprintf(&b, "    return (await resp.json()) as %s;\n", result)
+		}
+		b.WriteString("  }\n")
+	}
+	b.WriteString("}\n")
+	return []byte(b.String())
+}
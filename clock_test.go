@@ -0,0 +1,115 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+	"github.com/google/webrisk/webrisktest"
+	timepb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestClock(t *testing.T) {
+	clock := webrisktest.NewClock(time.Date(2023, 5, 24, 10, 0, 0, 0, time.UTC))
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	evil := hashFromPattern("evil.com/")
+	phs := hashPrefixes{evil[:minHashPrefixLength]}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: clock.Now(),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	errAPI := errors.New("unavailable")
+	updates := make(chan struct{}, 10)
+	var searches int32
+	wr, err := NewUpdateClient(Config{
+		DBPath:         path,
+		ThreatLists:    []ThreatType{ThreatTypeMalware},
+		UpdatePeriod:   30 * time.Minute,
+		MaxDatabaseAge: 2 * time.Hour,
+		Clock:          clock,
+		api: &mockAPI{
+			listUpdate: func(context.Context, pb.ThreatType, []byte, *pb.ComputeThreatListDiffRequest_Constraints) (*pb.ComputeThreatListDiffResponse, error) {
+				updates <- struct{}{}
+				return nil, errAPI
+			},
+			hashLookup: func(context.Context, []byte, []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+				atomic.AddInt32(&searches, 1)
+				expire := timepb.New(clock.Now().Add(10 * time.Minute))
+				return &pb.SearchHashesResponse{
+					Threats: []*pb.SearchHashesResponse_ThreatHash{{
+						ThreatTypes: []pb.ThreatType{pb.ThreatType_MALWARE},
+						Hash:        []byte(evil),
+						ExpireTime:  expire,
+					}},
+					NegativeExpireTime: expire,
+				}, nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := clock.BlockUntil(ctx, 1); err != nil {
+		t.Fatalf("update not scheduled: %v", err)
+	}
+
+	// Cached results expire with the clock.
+	lookup := func(want int32) {
+		t.Helper()
+		threats, err := wr.LookupURLs([]string{"http://evil.com/"})
+		if err != nil || len(threats[0]) != 1 {
+			t.Fatalf("LookupURLs = (%v, %v), want a threat", threats, err)
+		}
+		if n := atomic.LoadInt32(&searches); n != want {
+			t.Errorf("%d hash searches, want %d", n, want)
+		}
+	}
+	lookup(1)
+	lookup(1)
+	clock.Advance(11 * time.Minute)
+	lookup(2)
+
+	// The update is made on schedule.
+	clock.Advance(20 * time.Minute)
+	select {
+	case <-updates:
+	case <-ctx.Done():
+		t.Fatalf("update not made on schedule")
+	}
+	if _, err := wr.Status(); err != nil {
+		t.Errorf("Status error = %v, want nil", err)
+	}
+
+	// The database goes stale once it is older than MaxDatabaseAge.
+	clock.Advance(2 * time.Hour)
+	if _, err := wr.Status(); !errors.Is(err, ErrStale) {
+		t.Errorf("Status error = %v, want %v", err, ErrStale)
+	}
+}
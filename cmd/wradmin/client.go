@@ -0,0 +1,380 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+// Paths of the wrserver endpoints, relative to the base URL of a tenant.
+const (
+	statusPath = "/status"
+	updatePath = "/admin/db/update"
+	verifyPath = "/admin/db/verify"
+	exportPath = "/admin/db/export"
+	purgePath  = "/admin/cache/purge"
+	statsPath  = "/admin/stats/reset"
+	eventsPath = "/admin/events"
+)
+
+// adminClient calls the endpoints of a wrserver.
+type adminClient struct {
+	base   string // Base URL, including the tenant prefix if any
+	token  string
+	client *http.Client
+}
+
+// newAdminClient returns an adminClient for the wrserver at the base URL,
+// which authenticates with token. If tenant is not empty, the endpoints of
+// that tenant are called.
+func newAdminClient(base, token, tenant string) (*adminClient, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("not an http or https URL: %q", base)
+	}
+	base = strings.TrimSuffix(base, "/")
+	if tenant != "" {
+		base += "/t/" + url.PathEscape(tenant)
+	}
+	return &adminClient{base: base, token: token, client: http.DefaultClient}, nil
+}
+
+// do calls the endpoint at path, and returns its response if it succeeded.
+// The caller must close the body of the response.
+func (c *adminClient) do(ctx context.Context, method, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("wrserver: %s: check -token, and that the wrserver has an -adminToken", resp.Status)
+		}
+		// Errors are reported as application/problem+json, with a detail.
+		var p struct {
+			Detail string `json:"detail"`
+		}
+		if json.Unmarshal(body, &p) == nil && p.Detail != "" {
+			return nil, fmt.Errorf("wrserver: %s: %s", resp.Status, p.Detail)
+		}
+		return nil, fmt.Errorf("wrserver: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// call calls the endpoint at path, and decodes its JSON response into v. If
+// raw is not nil, the response is copied to it as is.
+func (c *adminClient) call(ctx context.Context, method, path string, v any, raw io.Writer) error {
+	resp, err := c.do(ctx, method, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if raw != nil {
+		if _, err := fmt.Fprintln(raw, strings.TrimSpace(string(body))); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(body, v)
+}
+
+// rawWriter returns w if the responses of the wrserver are printed as they
+// are, and nil otherwise.
+func rawWriter(w io.Writer, raw bool) io.Writer {
+	if raw {
+		return w
+	}
+	return nil
+}
+
+// status prints the status of the wrserver. It fails if the database is
+// not healthy.
+func (c *adminClient) status(ctx context.Context, w io.Writer, raw bool) error {
+	var st struct {
+		Stats webrisk.Stats
+		Error string
+		Build *webrisk.BuildInfo // Not reported by older versions
+	}
+	if err := c.call(ctx, "GET", statusPath, &st, rawWriter(w, raw)); err != nil {
+		return err
+	}
+	if !raw {
+		writeStatus(w, st.Stats, st.Error, st.Build)
+	}
+	if st.Error != "" {
+		return fmt.Errorf("database is not healthy: %s", st.Error)
+	}
+	return nil
+}
+
+// writeStatus prints a summary of the stats, error and build, if known, of a
+// wrserver.
+func writeStatus(w io.Writer, s webrisk.Stats, errStr string, build *webrisk.BuildInfo) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	health := "healthy"
+	if errStr != "" {
+		health = "error: " + errStr
+	}
+	fmt.Fprintf(tw, "Status:\t%s\n", health)
+	if build != nil {
+		fmt.Fprintf(tw, "Version:\t%s\n", build)
+	}
+	if s.DatabaseUpdateLag > 0 {
+		fmt.Fprintf(tw, "Update lag:\t%v\n", s.DatabaseUpdateLag.Round(time.Second))
+	}
+	fmt.Fprintf(tw, "Queries:\t%d by database, %d by cache, %d by API, %d failed\n",
+		s.QueriesByDatabase, s.QueriesByCache, s.QueriesByAPI, s.QueriesFail)
+	fmt.Fprintf(tw, "Cache hits:\t%d positive, %d negative, %d misses (%d expired)\n",
+		s.CachePositiveHits, s.CacheNegativeHits, s.CacheMisses, s.CacheExpired)
+	fmt.Fprintf(tw, "Cache entries:\t%d positive, %d negative, %d evicted, %d refreshed\n",
+		s.CachePositiveEntries, s.CacheNegativeEntries, s.CacheEvictions, s.CacheRefreshes)
+	fmt.Fprintf(tw, "Memory:\t%s database, %s cache\n", formatBytes(s.DatabaseMemory), formatBytes(s.CacheMemory))
+	tw.Flush()
+	if len(s.Lists) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+	tds := make([]webrisk.ThreatType, 0, len(s.Lists))
+	for td := range s.Lists {
+		tds = append(tds, td)
+	}
+	sort.Slice(tds, func(i, j int) bool { return tds[i] < tds[j] })
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "LIST\tENTRIES\tLAST UPDATE\tTYPE\tADDED\tREMOVED\tCHECKSUM")
+	for _, td := range tds {
+		ls := s.Lists[td]
+		last, checksum := "never", "-"
+		if !ls.LastUpdate.IsZero() {
+			last = ls.LastUpdate.UTC().Format(time.RFC3339)
+			checksum = "ok"
+			if !ls.ChecksumOK {
+				checksum = "mismatch"
+			}
+		}
+		if ls.ChecksumFailures > 0 {
+			checksum += fmt.Sprintf(" (%d failures)", ls.ChecksumFailures)
+		}
+		fmt.Fprintf(tw, "%v\t%d\t%s\t%s\t%d\t%d\t%s\n",
+			td, ls.Entries, last, ls.LastUpdateType, ls.LastAdded, ls.LastRemoved, checksum)
+	}
+	tw.Flush()
+}
+
+// formatBytes formats n bytes with a binary unit.
+func formatBytes(n int64) string {
+	const unit = 1 << 10
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// update updates the database of the wrserver right away.
+func (c *adminClient) update(ctx context.Context, w io.Writer, raw bool) error {
+	var out struct {
+		Updated bool   `json:"updated"`
+		Error   string `json:"error"`
+	}
+	if err := c.call(ctx, "POST", updatePath, &out, rawWriter(w, raw)); err != nil {
+		return err
+	}
+	if !out.Updated {
+		return fmt.Errorf("update failed: %s", out.Error)
+	}
+	if !raw {
+		fmt.Fprintln(w, "Database updated")
+	}
+	return nil
+}
+
+// verify checks the threat lists of the wrserver against the Web Risk API.
+// It fails if any of them is out of sync.
+func (c *adminClient) verify(ctx context.Context, w io.Writer, raw bool) error {
+	var out struct {
+		InSync bool `json:"inSync"`
+		Lists  []struct {
+			ThreatType webrisk.ThreatType `json:"threatType"`
+			InSync     bool               `json:"inSync"`
+			Reset      bool               `json:"reset"`
+			Added      int                `json:"added"`
+			Removed    int                `json:"removed"`
+			Error      string             `json:"error"`
+		} `json:"lists"`
+	}
+	if err := c.call(ctx, "POST", verifyPath, &out, rawWriter(w, raw)); err != nil {
+		return err
+	}
+	if !raw {
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		for _, l := range out.Lists {
+			var s string
+			switch {
+			case l.Error != "":
+				s = "error: " + l.Error
+			case l.InSync:
+				s = "in sync"
+			case l.Reset:
+				s = "out of sync, unknown version"
+			default:
+				s = fmt.Sprintf("out of sync, %d to add, %d to remove", l.Added, l.Removed)
+			}
+			fmt.Fprintf(tw, "%v\t%s\n", l.ThreatType, s)
+		}
+		tw.Flush()
+	}
+	if !out.InSync {
+		return errors.New("threat lists are not in sync")
+	}
+	return nil
+}
+
+// purge removes all entries from the cache of the wrserver.
+func (c *adminClient) purge(ctx context.Context, w io.Writer, raw bool) error {
+	var out struct {
+		Purged bool `json:"purged"`
+	}
+	if err := c.call(ctx, "POST", purgePath, &out, rawWriter(w, raw)); err != nil {
+		return err
+	}
+	if !raw {
+		fmt.Fprintln(w, "Cache purged")
+	}
+	return nil
+}
+
+// resetStats zeroes the counters of the wrserver.
+func (c *adminClient) resetStats(ctx context.Context, w io.Writer, raw bool) error {
+	var out struct {
+		Reset bool `json:"reset"`
+	}
+	if err := c.call(ctx, "POST", statsPath, &out, rawWriter(w, raw)); err != nil {
+		return err
+	}
+	if !raw {
+		fmt.Fprintln(w, "Stats reset")
+	}
+	return nil
+}
+
+// events prints the unsafe URLs found by the wrserver as they happen, until
+// ctx is done.
+func (c *adminClient) events(ctx context.Context, w io.Writer, raw bool) error {
+	resp, err := c.do(ctx, "GET", eventsPath)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		if raw {
+			if _, err := fmt.Fprintln(w, sc.Text()); err != nil {
+				return err
+			}
+			continue
+		}
+		var d struct {
+			Time        time.Time            `json:"time"`
+			Endpoint    string               `json:"endpoint"`
+			URL         string               `json:"url"`
+			ThreatTypes []webrisk.ThreatType `json:"threatTypes"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &d); err != nil {
+			return err
+		}
+		tts := make([]string, len(d.ThreatTypes))
+		for i, tt := range d.ThreatTypes {
+			tts[i] = tt.String()
+		}
+		if _, err := fmt.Fprintf(w, "%s %s %s %s\n", d.Time.UTC().Format(time.RFC3339),
+			d.Endpoint, d.URL, strings.Join(tts, ",")); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return nil // Interrupted
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return errors.New("event stream closed by the wrserver")
+}
+
+// export saves the database of the wrserver to the file at path, or writes
+// it to stdout if path is "-". The file is only replaced once the whole
+// database was received.
+func (c *adminClient) export(ctx context.Context, path string, stdout, stderr io.Writer) error {
+	resp, err := c.do(ctx, "GET", exportPath)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if path == "-" {
+		_, err := io.Copy(stdout, resp.Body)
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	n, err := io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "Exported %d bytes to %s\n", n, path)
+	return nil
+}
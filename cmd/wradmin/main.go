@@ -0,0 +1,157 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command wradmin is a tool for operating a running wrserver.
+//
+// The tool wraps the /status endpoint and the authenticated /admin endpoints
+// of a wrserver, so that operators do not have to craft curl invocations by
+// hand. The admin token is taken from -token, or from the ADMINTOKEN
+// environment variable, as for wrserver itself. With -tenant, the commands
+// apply to a single tenant of the server.
+//
+// Commands:
+//
+//	status        show the health, query and cache counts, and threat lists
+//	update        update the database from the Web Risk API right away
+//	verify        check the threat lists against the Web Risk API
+//	purge         remove all entries from the cache
+//	reset-stats   zero the query and cache counters and latency histograms
+//	events        print unsafe URLs as the server finds them, until interrupted
+//	export FILE   save the database to FILE, or to STDOUT if FILE is "-"
+//
+// To build the tool:
+//
+//	$ go build -o wradmin ./cmd/wradmin
+//
+// Example usage:
+//
+//	$ wradmin -server http://wrserver:8080 status
+//	Status:    healthy
+//	Version:   v1.2.3 commit 4f8c2b1 built 2023-06-01T12:00:00Z go1.19.10
+//	Queries:   1042 by database, 87 by cache, 12 by API, 0 failed
+//	...
+//
+//	$ ADMINTOKEN=secret wradmin -server http://wrserver:8080 update
+//	Database updated
+//
+//	$ wradmin -server http://wrserver:8080 -tenant acme export acme.db
+//	Exported 1843211 bytes to acme.db
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var (
+	serverFlag = flag.String("server", "http://localhost:8080", "base URL of the wrserver")
+	tokenFlag  = flag.String("token", os.Getenv("ADMINTOKEN"), "admin token of the wrserver, given by its -adminToken")
+	tenantFlag = flag.String("tenant", "", "name of the tenant to operate on, if the wrserver serves -tenants")
+	jsonFlag   = flag.Bool("json", false, "print the responses of the wrserver as JSON, instead of a summary")
+)
+
+const usage = `wradmin: command-line tool to operate a running wrserver.
+
+Commands:
+  status        show the health, query and cache counts, and threat lists
+  update        update the database from the Web Risk API right away
+  verify        check the threat lists against the Web Risk API
+  purge         remove all entries from the cache
+  reset-stats   zero the query and cache counters and latency histograms
+  events        print unsafe URLs as the server finds them, until interrupted
+  export FILE   save the database to FILE, or to STDOUT if FILE is "-"
+
+Exit codes:
+  0  if the command succeeded.
+  1  if the command failed, or the database is not healthy or in sync.
+  2  if the flags or command were invalid.
+
+Usage: %s [flags] command [args]
+
+`
+
+const (
+	codeOK = iota
+	codeFailed
+	codeUsage
+)
+
+func main() {
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	if err := flag.CommandLine.Parse(os.Args[1:]); err == flag.ErrHelp {
+		os.Exit(codeOK)
+	} else if err != nil {
+		os.Exit(codeUsage)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, flag.Args(), os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run runs the command given by args against the wrserver, and returns the
+// exit code.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "No command specified")
+		return codeUsage
+	}
+	c, err := newAdminClient(*serverFlag, *tokenFlag, *tenantFlag)
+	if err != nil {
+		fmt.Fprintln(stderr, "Invalid -server: ", err)
+		return codeUsage
+	}
+	cmd, args := args[0], args[1:]
+	wantArgs := 0
+	if cmd == "export" {
+		wantArgs = 1
+	}
+	if len(args) != wantArgs {
+		fmt.Fprintf(stderr, "Wrong number of arguments for %s\n", cmd)
+		return codeUsage
+	}
+	switch cmd {
+	case "status":
+		err = c.status(ctx, stdout, *jsonFlag)
+	case "update":
+		err = c.update(ctx, stdout, *jsonFlag)
+	case "verify":
+		err = c.verify(ctx, stdout, *jsonFlag)
+	case "purge":
+		err = c.purge(ctx, stdout, *jsonFlag)
+	case "reset-stats":
+		err = c.resetStats(ctx, stdout, *jsonFlag)
+	case "events":
+		err = c.events(ctx, stdout, *jsonFlag)
+	case "export":
+		err = c.export(ctx, args[0], stdout, stderr)
+	default:
+		fmt.Fprintln(stderr, "Unknown command:", cmd)
+		return codeUsage
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "Error:", err)
+		return codeFailed
+	}
+	return codeOK
+}
@@ -0,0 +1,142 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeServer returns a server that answers like the endpoints of the
+// tenant acme of a wrserver with the admin token "secret".
+func newFakeServer() *httptest.Server {
+	mux := http.NewServeMux()
+	admin := func(path, method, body string) {
+		mux.HandleFunc("/t/acme"+path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if r.Method != method {
+				w.Header().Set("Content-Type", "application/problem+json")
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"type":"about:blank","title":"Bad Request","status":400,"detail":"invalid method","code":"invalid_method"}`))
+				return
+			}
+			w.Write([]byte(body))
+		})
+	}
+	mux.HandleFunc("/t/acme/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Stats":{"QueriesByDatabase":10,"QueriesByAPI":2,"DatabaseMemory":3145728,` +
+			`"Lists":{"MALWARE":{"Entries":42,"LastUpdate":"2023-05-24T10:00:00Z","LastUpdateType":"DIFF",` +
+			`"LastAdded":3,"LastRemoved":1,"ChecksumOK":true}}},"Error":"",` +
+			`"Build":{"version":"v1.2.3","commit":"abc123","goVersion":"go1.19"}}`))
+	})
+	admin(updatePath, "POST", `{"updated":true}`)
+	admin(verifyPath, "POST", `{"inSync":false,"lists":[{"threatType":"MALWARE","inSync":false,"added":12,"removed":3}]}`)
+	admin(purgePath, "POST", `{"purged":true}`)
+	admin(statsPath, "POST", `{"reset":true}`)
+	admin(exportPath, "GET", "database")
+	admin(eventsPath, "GET", `{"time":"2023-05-24T10:00:00Z","endpoint":"search","url":"http://evil.com/","threatTypes":["MALWARE"]}`+"\n")
+	return httptest.NewServer(mux)
+}
+
+func TestRun(t *testing.T) {
+	srv := newFakeServer()
+	defer srv.Close()
+	dir := t.TempDir()
+	exported := filepath.Join(dir, "webrisk.db")
+
+	vectors := []struct {
+		token  string
+		json   bool
+		args   []string
+		code   int
+		output []string // Substrings expected in STDOUT
+		err    string   // Substring expected in STDERR
+	}{
+		{token: "secret", args: []string{"status"}, code: codeOK, output: []string{
+			"Status:         healthy",
+			"Version:        v1.2.3 commit abc123 go1.19",
+			"Queries:        10 by database, 0 by cache, 2 by API, 0 failed",
+			"Memory:         3.0 MiB database, 0 B cache",
+			"MALWARE  42       2023-05-24T10:00:00Z  DIFF  3      1        ok",
+		}},
+		{token: "secret", args: []string{"update"}, code: codeOK, output: []string{"Database updated\n"}},
+		{token: "secret", json: true, args: []string{"update"}, code: codeOK, output: []string{`{"updated":true}` + "\n"}},
+		{token: "secret", args: []string{"verify"}, code: codeFailed,
+			output: []string{"MALWARE  out of sync, 12 to add, 3 to remove\n"}, err: "not in sync"},
+		{token: "secret", args: []string{"purge"}, code: codeOK, output: []string{"Cache purged\n"}},
+		{token: "secret", args: []string{"reset-stats"}, code: codeOK, output: []string{"Stats reset\n"}},
+		{token: "secret", args: []string{"export", exported}, code: codeOK, err: "Exported 8 bytes"},
+		{token: "secret", args: []string{"export", "-"}, code: codeOK, output: []string{"database"}},
+		{token: "secret", args: []string{"events"}, code: codeFailed,
+			output: []string{"2023-05-24T10:00:00Z search http://evil.com/ MALWARE\n"}, err: "stream closed"},
+		{token: "wrong", args: []string{"purge"}, code: codeFailed, err: "401 Unauthorized"},
+		{token: "secret", args: []string{"export"}, code: codeUsage, err: "Wrong number of arguments"},
+		{token: "secret", args: []string{"reboot"}, code: codeUsage, err: "Unknown command"},
+		{token: "secret", code: codeUsage, err: "No command"},
+	}
+	for i, v := range vectors {
+		*serverFlag, *tokenFlag, *tenantFlag, *jsonFlag = srv.URL, v.token, "acme", v.json
+		var stdout, stderr bytes.Buffer
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		code := run(ctx, v.args, &stdout, &stderr)
+		cancel()
+		if code != v.code {
+			t.Errorf("test %d, exit code = %d, want %d (stderr %q)", i, code, v.code, stderr.String())
+		}
+		for _, want := range v.output {
+			if !strings.Contains(stdout.String(), want) {
+				t.Errorf("test %d, output = %q, want it to contain %q", i, stdout.String(), want)
+			}
+		}
+		if !strings.Contains(stderr.String(), v.err) {
+			t.Errorf("test %d, stderr = %q, want it to contain %q", i, stderr.String(), v.err)
+		}
+	}
+
+	b, err := os.ReadFile(exported)
+	if err != nil || string(b) != "database" {
+		t.Errorf("exported %q, %v, want %q", b, err, "database")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("export left %d files, want 1", len(entries))
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	vectors := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{3 << 20, "3.0 MiB"},
+		{5 << 30, "5.0 GiB"},
+	}
+	for i, v := range vectors {
+		if got := formatBytes(v.n); got != v.want {
+			t.Errorf("test %d, formatBytes(%d) = %q, want %q", i, v.n, got, v.want)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command wrbench is a tool for benchmarking lookups and generating load.
+//
+// The tool looks up a workload of URLs, several at a time with -concurrency,
+// and reports the throughput, the latency percentiles, how the hashes of the
+// URLs were looked up (in the database, the cache, or with the API), and the
+// number of calls made to the API. Runs with the same flags look up the same URLs in
+// the same order, so that results can be compared across builds to catch
+// performance regressions before a release.
+//
+// By default, the workload is synthetic: -n lookups of URLs picked at random
+// with -seed, a fraction -hitRate of which are unsafe, and a fraction
+// -nearRate of which share a hash prefix with an unsafe URL, so that they
+// must be confirmed with the API. The URLs are looked up with the library,
+// against a fake Web Risk API served by the tool itself, which lists the
+// unsafe URLs and answers after -apiLatency. No API key is needed, and the
+// verdicts are checked.
+//
+// With -input, the URLs read from a file are replayed instead, against the
+// Web Risk API with -apikey. With -wrserver, the URLs are looked up with a
+// running wrserver, which also makes the tool a load generator.
+//
+// To build the tool:
+//
+//	$ go build -o wrbench ./cmd/wrbench
+//
+// Example usage:
+//
+//	$ wrbench -n 20000 -concurrency 8
+//	Lookups:       20000 in 2.493s (8023.5/s), 0 failed
+//	Verdicts:      213 unsafe, 0 unexpected
+//	Latency:       p50 11µs, p90 17µs, p99 51.365ms, max 60.153ms
+//	Hash queries:  39580 by database, 46 by cache, 374 by API, 0 failed
+//	API calls:     374 hash searches, 1 list updates
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/google/webrisk"
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var (
+	nFlag           = flag.Int("n", 10000, "number of lookups")
+	urlsFlag        = flag.Int("urls", 1000, "number of distinct URLs of each kind in a synthetic workload")
+	hitRateFlag     = flag.Float64("hitRate", 0.01, "fraction of the lookups of a synthetic workload that are for unsafe URLs")
+	nearRateFlag    = flag.Float64("nearRate", 0.01, "fraction of the lookups of a synthetic workload that are for safe URLs with an unsafe hash prefix")
+	seedFlag        = flag.Int64("seed", 1, "seed of the random choices of a synthetic workload")
+	inputFlag       = flag.String("input", "", "path to a file of URLs to replay, one per line, instead of a synthetic workload")
+	concurrencyFlag = flag.Int("concurrency", 1, "number of lookups made at a time")
+	apiLatencyFlag  = flag.Duration("apiLatency", 50*time.Millisecond, "latency of the fake Web Risk API")
+	apiKeyFlag      = flag.String("apikey", "", "Web Risk API key to replay -input against the API, instead of a fake one")
+	serverURLFlag   = flag.String("server", webrisk.DefaultServerURL, "Web Risk API server address, with -apikey")
+	threatTypesFlag = flag.String("threatTypes", "ALL", "threat types to check against, with -apikey")
+	databaseFlag    = flag.String("db", "", "path to the Web Risk database, with -apikey")
+	wrserverFlag    = flag.String("wrserver", "", "base URL of a running wrserver to look up URLs with, instead of the library")
+	jsonFlag        = flag.Bool("json", false, "print the report as JSON")
+)
+
+const usage = `wrbench: benchmark and load-generation tool for Web Risk lookups.
+
+Tool looks up a synthetic workload, or replays the URLs of the -input file,
+with the library or a running -wrserver, and reports throughput, latency
+percentiles, and API calls.
+
+Exit codes:
+  0  if all lookups succeeded with the expected verdicts.
+  1  if a lookup failed, or gave an unexpected verdict.
+  2  if the flags were invalid, or the client could not be set up.
+
+Usage: %s -n=100000 -concurrency=8
+       %s -wrserver=http://wrserver:8080 -input=urls.txt
+
+`
+
+const (
+	codeOK = iota
+	codeFailed
+	codeConfig
+)
+
+func main() {
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, usage, os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	if err := flag.CommandLine.Parse(os.Args[1:]); err == flag.ErrHelp {
+		os.Exit(codeOK)
+	} else if err != nil {
+		os.Exit(codeConfig)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run runs the benchmark given by the flags until ctx is done, and returns
+// the exit code.
+func run(ctx context.Context, stdout, stderr io.Writer) int {
+	if *nFlag < 1 || *urlsFlag < 1 || *concurrencyFlag < 1 {
+		fmt.Fprintln(stderr, "Invalid -n, -urls, or -concurrency")
+		return codeConfig
+	}
+	if *hitRateFlag < 0 || *nearRateFlag < 0 || *hitRateFlag+*nearRateFlag > 1 {
+		fmt.Fprintln(stderr, "Invalid -hitRate or -nearRate")
+		return codeConfig
+	}
+	var w *workload
+	if *inputFlag != "" {
+		f, err := os.Open(*inputFlag)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid -input: ", err)
+			return codeConfig
+		}
+		w, err = replayWorkload(f, *nFlag)
+		f.Close()
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid -input: ", err)
+			return codeConfig
+		}
+	} else {
+		w = syntheticWorkload(*nFlag, *urlsFlag, *hitRateFlag, *nearRateFlag, *seedFlag)
+	}
+
+	var t target
+	var fake *fakeAPI
+	switch {
+	case *wrserverFlag != "":
+		st, err := newServerTarget(*wrserverFlag)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid -wrserver: ", err)
+			return codeConfig
+		}
+		t = st
+	case *apiKeyFlag != "":
+		wr, err := newClient(ctx, webrisk.Config{
+			APIKey:        *apiKeyFlag,
+			ServerURL:     *serverURLFlag,
+			DBPath:        *databaseFlag,
+			ThreatListArg: *threatTypesFlag,
+		})
+		if err != nil {
+			fmt.Fprintln(stderr, "Unable to initialize Web Risk client: ", err)
+			return codeConfig
+		}
+		defer wr.Close()
+		t = libraryTarget{wr}
+	default:
+		fake = newFakeAPI(w, *apiLatencyFlag)
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			fmt.Fprintln(stderr, "Unable to serve the fake Web Risk API: ", err)
+			return codeConfig
+		}
+		defer ln.Close()
+		go http.Serve(ln, fake)
+		wr, err := newClient(ctx, webrisk.Config{
+			APIKey:      "fake",
+			ServerURL:   "http://" + ln.Addr().String(),
+			ThreatLists: []webrisk.ThreatType{webrisk.ThreatTypeMalware},
+		})
+		if err != nil {
+			fmt.Fprintln(stderr, "Unable to initialize Web Risk client: ", err)
+			return codeConfig
+		}
+		defer wr.Close()
+		t = libraryTarget{wr}
+	}
+
+	r, err := bench(ctx, t, w, *concurrencyFlag)
+	if err != nil {
+		fmt.Fprintln(stderr, "Benchmark failed:", err)
+		return codeFailed
+	}
+	if fake != nil {
+		updates := atomic.LoadInt64(&fake.updates)
+		r.APICalls.HashSearches = atomic.LoadInt64(&fake.searches)
+		r.APICalls.ListUpdates = &updates
+	}
+	if *jsonFlag {
+		err = json.NewEncoder(stdout).Encode(r)
+	} else {
+		err = r.write(stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "Unable to write output:", err)
+		return codeFailed
+	}
+	if r.Failed > 0 || r.Unexpected > 0 {
+		return codeFailed
+	}
+	return codeOK
+}
+
+// newClient returns a client with the configuration, once its database is
+// ready to serve lookups.
+func newClient(ctx context.Context, conf webrisk.Config) (*webrisk.UpdateClient, error) {
+	conf.Logger = io.Discard
+	wr, err := webrisk.NewUpdateClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	if err := wr.WaitUntilReady(ctx); err != nil {
+		wr.Close()
+		return nil, err
+	}
+	return wr, nil
+}
+
+// target looks up URLs, and counts how it satisfied them.
+type target interface {
+	lookup(ctx context.Context, url string) (unsafe bool, err error)
+	stats(ctx context.Context) (webrisk.Stats, error)
+}
+
+// libraryTarget looks up URLs with a client of the Web Risk API.
+type libraryTarget struct {
+	wr *webrisk.UpdateClient
+}
+
+func (lt libraryTarget) lookup(ctx context.Context, url string) (bool, error) {
+	threats, err := lt.wr.LookupURLsContext(ctx, []string{url})
+	if err != nil {
+		return false, err
+	}
+	return len(threats[0]) > 0, nil
+}
+
+func (lt libraryTarget) stats(context.Context) (webrisk.Stats, error) {
+	stats, _ := lt.wr.Status()
+	return stats, nil
+}
+
+// serverTarget looks up URLs with the /v1/uris:search endpoint of a
+// wrserver.
+type serverTarget struct {
+	base   string
+	client *http.Client
+}
+
+// newServerTarget returns a serverTarget for the wrserver at the base URL.
+func newServerTarget(base string) (serverTarget, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return serverTarget{}, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return serverTarget{}, fmt.Errorf("not an http or https URL: %q", base)
+	}
+	// Keep enough idle connections for every concurrent lookup, so that
+	// the benchmark measures lookups rather than connection setup.
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.MaxIdleConnsPerHost = 1024
+	return serverTarget{base: strings.TrimSuffix(base, "/"), client: &http.Client{Transport: tr}}, nil
+}
+
+func (st serverTarget) lookup(ctx context.Context, uri string) (bool, error) {
+	body, err := protojson.Marshal(&pb.SearchUrisRequest{Uri: uri})
+	if err != nil {
+		return false, err
+	}
+	var resp pb.SearchUrisResponse
+	if err := st.call(ctx, "POST", "/v1/uris:search", body, func(b []byte) error {
+		return protojson.Unmarshal(b, &resp)
+	}); err != nil {
+		return false, err
+	}
+	return len(resp.GetThreat().GetThreatTypes()) > 0, nil
+}
+
+func (st serverTarget) stats(ctx context.Context) (webrisk.Stats, error) {
+	var status struct{ Stats webrisk.Stats }
+	err := st.call(ctx, "GET", "/status", nil, func(b []byte) error {
+		return json.Unmarshal(b, &status)
+	})
+	return status.Stats, err
+}
+
+// call sends a request to the wrserver, and decodes its response with
+// decode.
+func (st serverTarget) call(ctx context.Context, method, path string, body []byte, decode func([]byte) error) error {
+	req, err := http.NewRequestWithContext(ctx, method, st.base+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := st.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("wrserver: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return decode(b)
+}
+
+// bench looks up the requests of w with t, concurrency at a time, and
+// reports the results. Once ctx is done, no more lookups are started.
+func bench(ctx context.Context, t target, w *workload, concurrency int) (*report, error) {
+	before, err := t.stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	latencies := make([]time.Duration, len(w.requests))
+	var (
+		mu                               sync.Mutex
+		done, failed, unsafe, unexpected int
+		firstErr                         error
+	)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				req := w.requests[j]
+				t0 := time.Now()
+				got, err := t.lookup(ctx, req.url)
+				latencies[j] = time.Since(t0)
+				mu.Lock()
+				done++
+				switch {
+				case err != nil:
+					failed++
+					if firstErr == nil {
+						firstErr = err
+					}
+				case got:
+					unsafe++
+				}
+				if err == nil && req.kind != kindUnknown && got != (req.kind == kindHit) {
+					unexpected++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for j := range w.requests {
+		select {
+		case jobs <- j:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	after, err := t.stats(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	r := newReport(latencies[:done], elapsed)
+	r.Failed, r.Unsafe, r.Unexpected = failed, unsafe, unexpected
+	if firstErr != nil {
+		r.FirstError = firstErr.Error()
+	}
+	r.Queries = queries{
+		Database: after.QueriesByDatabase - before.QueriesByDatabase,
+		Cache:    after.QueriesByCache - before.QueriesByCache,
+		API:      after.QueriesByAPI - before.QueriesByAPI,
+		Failed:   after.QueriesFail - before.QueriesFail,
+	}
+	r.APICalls.HashSearches = r.Queries.API
+	return r, nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyntheticWorkload(t *testing.T) {
+	w1 := syntheticWorkload(1000, 10, 0.2, 0.3, 42)
+	w2 := syntheticWorkload(1000, 10, 0.2, 0.3, 42)
+	if !reflect.DeepEqual(w1, w2) {
+		t.Errorf("workloads with the same seed differ")
+	}
+	var counts [3]int
+	for _, r := range w1.requests {
+		counts[r.kind]++
+	}
+	// The mix is random, so only check that it is roughly as requested.
+	for k, want := range []int{500, 300, 200} {
+		if d := counts[k] - want; d < -60 || d > 60 {
+			t.Errorf("%d requests of kind %d, want about %d", counts[k], k, want)
+		}
+	}
+	if w3 := syntheticWorkload(1000, 10, 0.2, 0.3, 43); reflect.DeepEqual(w1.requests, w3.requests) {
+		t.Errorf("workloads with different seeds are the same")
+	}
+}
+
+func TestReplayWorkload(t *testing.T) {
+	w, err := replayWorkload(strings.NewReader("# Top URLs\nhttp://a.com/\n\nhttp://b.com/\n"), 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []request{
+		{"http://a.com/", kindUnknown},
+		{"http://b.com/", kindUnknown},
+		{"http://a.com/", kindUnknown},
+	}
+	if !reflect.DeepEqual(w.requests, want) {
+		t.Errorf("requests = %v, want %v", w.requests, want)
+	}
+	if _, err := replayWorkload(strings.NewReader("\n"), 3); err == nil {
+		t.Errorf("unexpected success without URLs")
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var ds []time.Duration
+	for i := 1; i <= 100; i++ {
+		ds = append(ds, time.Duration(i))
+	}
+	vectors := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, 1},
+		{0.5, 50},
+		{0.99, 99},
+		{1, 100},
+	}
+	for i, v := range vectors {
+		if got := percentile(ds, v.p); got != v.want {
+			t.Errorf("test %d, percentile(%v) = %v, want %v", i, v.p, got, v.want)
+		}
+	}
+	if got := percentile(nil, 0.5); got != 0 {
+		t.Errorf("percentile of nothing = %v, want 0", got)
+	}
+}
+
+func TestRun(t *testing.T) {
+	*nFlag, *urlsFlag, *concurrencyFlag = 500, 20, 4
+	*hitRateFlag, *nearRateFlag, *apiLatencyFlag = 0.1, 0.1, 0
+	*jsonFlag = true
+	var stdout, stderr bytes.Buffer
+	if code := run(context.Background(), &stdout, &stderr); code != codeOK {
+		t.Fatalf("exit code = %d, want %d (stderr %q)", code, codeOK, stderr.String())
+	}
+	var r report
+	if err := json.Unmarshal(stdout.Bytes(), &r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.Lookups != 500 || r.Failed != 0 || r.Unexpected != 0 || r.Unsafe == 0 {
+		t.Errorf("report = %+v, want 500 lookups with expected verdicts", r)
+	}
+	// Every distinct unsafe and near URL is searched for at most once, as
+	// the results are cached.
+	if r.APICalls.HashSearches == 0 || r.APICalls.HashSearches > 40 {
+		t.Errorf("%d hash searches, want between 1 and 40", r.APICalls.HashSearches)
+	}
+	if r.APICalls.ListUpdates == nil || *r.APICalls.ListUpdates != 1 {
+		t.Errorf("list updates = %v, want 1", r.APICalls.ListUpdates)
+	}
+
+	*hitRateFlag = 0.95
+	if code := run(context.Background(), &stdout, &stderr); code != codeConfig {
+		t.Errorf("exit code with an invalid mix = %d, want %d", code, codeConfig)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// report is the outcome of a benchmark.
+type report struct {
+	Lookups    int     `json:"lookups"`
+	Seconds    float64 `json:"seconds"`
+	Rate       float64 `json:"lookupsPerSecond"`
+	Failed     int     `json:"failed"`
+	FirstError string  `json:"firstError,omitempty"`
+	Unsafe     int     `json:"unsafe"`
+	Unexpected int     `json:"unexpectedVerdicts"`
+	Latency    latency `json:"latencySeconds"`
+	Queries    queries `json:"hashQueries"`
+	APICalls   struct {
+		HashSearches int64  `json:"hashSearches"`
+		ListUpdates  *int64 `json:"listUpdates,omitempty"` // Only known for the fake API
+	} `json:"apiCalls"`
+}
+
+// latency are percentiles of the latency of lookups, in seconds.
+type latency struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// queries are the number of hashes looked up, by how they were satisfied.
+// A URL is looked up by several hashes.
+type queries struct {
+	Database int64 `json:"database"`
+	Cache    int64 `json:"cache"`
+	API      int64 `json:"api"`
+	Failed   int64 `json:"failed"`
+}
+
+// newReport returns the report of the lookups that took latencies, over
+// elapsed time. The latencies are sorted in place.
+func newReport(latencies []time.Duration, elapsed time.Duration) *report {
+	r := &report{Lookups: len(latencies), Seconds: elapsed.Seconds()}
+	if elapsed > 0 {
+		r.Rate = float64(len(latencies)) / elapsed.Seconds()
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	r.Latency = latency{
+		P50: percentile(latencies, 0.50).Seconds(),
+		P90: percentile(latencies, 0.90).Seconds(),
+		P99: percentile(latencies, 0.99).Seconds(),
+		Max: percentile(latencies, 1).Seconds(),
+	}
+	return r
+}
+
+// percentile returns the smallest of the sorted durations that at least a
+// fraction p of them are not greater than, or 0 if there are none.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// write writes the report as text.
+func (r *report) write(w io.Writer) error {
+	d := func(s float64) time.Duration {
+		return time.Duration(s * float64(time.Second)).Round(time.Microsecond)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Lookups:\t%d in %v (%.1f/s), %d failed\n", r.Lookups, d(r.Seconds).Round(time.Millisecond), r.Rate, r.Failed)
+	if r.FirstError != "" {
+		fmt.Fprintf(tw, "First error:\t%s\n", r.FirstError)
+	}
+	fmt.Fprintf(tw, "Verdicts:\t%d unsafe, %d unexpected\n", r.Unsafe, r.Unexpected)
+	fmt.Fprintf(tw, "Latency:\tp50 %v, p90 %v, p99 %v, max %v\n",
+		d(r.Latency.P50), d(r.Latency.P90), d(r.Latency.P99), d(r.Latency.Max))
+	fmt.Fprintf(tw, "Hash queries:\t%d by database, %d by cache, %d by API, %d failed\n",
+		r.Queries.Database, r.Queries.Cache, r.Queries.API, r.Queries.Failed)
+	fmt.Fprintf(tw, "API calls:\t%d hash searches", r.APICalls.HashSearches)
+	if r.APICalls.ListUpdates != nil {
+		fmt.Fprintf(tw, ", %d list updates", *r.APICalls.ListUpdates)
+	}
+	fmt.Fprintln(tw)
+	return tw.Flush()
+}
@@ -0,0 +1,224 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	timepb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Kinds of URLs in a synthetic workload.
+const (
+	kindMiss    = iota // Not in the threat list at all
+	kindNear           // Shares a hash prefix with the threat list, but is safe
+	kindHit            // In the threat list
+	kindUnknown        // Replayed from a file, so the verdict is not known
+)
+
+// request is a URL to look up, along with what kind of URL it is.
+type request struct {
+	url  string
+	kind int
+}
+
+// workload is a reproducible sequence of URLs to look up.
+type workload struct {
+	requests []request
+
+	// hits and nears are the host names of the URLs of a synthetic
+	// workload that are in the threat list, and that only share a prefix
+	// with it.
+	hits, nears []string
+}
+
+// syntheticWorkload returns n requests for URLs picked at random, with the
+// given seed, among urls distinct URLs of each kind. A fraction hitRate of
+// the requests are for unsafe URLs, and a fraction nearRate of them are for
+// safe URLs whose hash prefix is in the threat list, which makes them a
+// database hit that must be confirmed with the API. The other requests are
+// for URLs that the database alone shows are safe.
+func syntheticWorkload(n, urls int, hitRate, nearRate float64, seed int64) *workload {
+	w := &workload{}
+	for i := 0; i < urls; i++ {
+		w.hits = append(w.hits, fmt.Sprintf("hit-%d.bench.test", i))
+		w.nears = append(w.nears, fmt.Sprintf("near-%d.bench.test", i))
+	}
+	rng := rand.New(rand.NewSource(seed))
+	for i := 0; i < n; i++ {
+		k, x := kindMiss, rng.Float64()
+		switch {
+		case x < hitRate:
+			k = kindHit
+		case x < hitRate+nearRate:
+			k = kindNear
+		}
+		host := [...]string{"miss", "near", "hit"}[k]
+		w.requests = append(w.requests, request{
+			url:  fmt.Sprintf("http://%s-%d.bench.test/", host, rng.Intn(urls)),
+			kind: k,
+		})
+	}
+	return w
+}
+
+// replayWorkload returns n requests for the URLs read from r, one per line,
+// in order, starting over at its end as often as needed.
+func replayWorkload(r io.Reader, n int) (*workload, error) {
+	var urls []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		if u := strings.TrimSpace(sc.Text()); u != "" && !strings.HasPrefix(u, "#") {
+			urls = append(urls, u)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no URLs to replay")
+	}
+	w := &workload{}
+	for i := 0; i < n; i++ {
+		w.requests = append(w.requests, request{url: urls[i%len(urls)], kind: kindUnknown})
+	}
+	return w, nil
+}
+
+// fakeAPI serves the Web Risk API for the MALWARE threat list of a
+// synthetic workload, and counts the calls made to it. Its latency, if
+// positive, is added to every call.
+type fakeAPI struct {
+	prefixes [][]byte            // Sorted 4-byte hash prefixes in the list
+	threats  map[string][][]byte // Full hashes of the unsafe URLs, by prefix
+	latency  time.Duration
+	ttl      time.Duration // How long the results of hash searches are valid
+
+	updates  int64 // Number of threat list updates served
+	searches int64 // Number of hash searches served
+}
+
+// newFakeAPI returns a fakeAPI whose threat list holds the hits and nears
+// of w.
+func newFakeAPI(w *workload, latency time.Duration) *fakeAPI {
+	f := &fakeAPI{threats: make(map[string][][]byte), latency: latency, ttl: time.Hour}
+	add := func(host string, unsafe bool) {
+		h := sha256.Sum256([]byte(host + "/"))
+		f.prefixes = append(f.prefixes, h[:4])
+		if unsafe {
+			f.threats[string(h[:4])] = append(f.threats[string(h[:4])], h[:])
+		}
+	}
+	for _, host := range w.hits {
+		add(host, true)
+	}
+	for _, host := range w.nears {
+		add(host, false)
+	}
+	sort.Slice(f.prefixes, func(i, j int) bool { return bytes.Compare(f.prefixes[i], f.prefixes[j]) < 0 })
+	// Drop duplicates, as the API never lists a prefix twice.
+	out := f.prefixes[:0]
+	for i, p := range f.prefixes {
+		if i == 0 || !bytes.Equal(p, f.prefixes[i-1]) {
+			out = append(out, p)
+		}
+	}
+	f.prefixes = out
+	return f
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if f.latency > 0 {
+		time.Sleep(f.latency)
+	}
+	var resp proto.Message
+	switch r.URL.Path {
+	case "/v1/threatLists:computeDiff":
+		atomic.AddInt64(&f.updates, 1)
+		resp = f.listUpdate(r.URL.Query().Get("threat_type"))
+	case "/v1/hashes:search":
+		atomic.AddInt64(&f.searches, 1)
+		prefix, err := base64.StdEncoding.DecodeString(r.URL.Query().Get("hash_prefix"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp = f.hashLookup(prefix)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	b, err := protojson.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// listUpdate returns the full threat list, which is only populated for
+// MALWARE.
+func (f *fakeAPI) listUpdate(threatType string) *pb.ComputeThreatListDiffResponse {
+	var prefixes [][]byte
+	if threatType == pb.ThreatType_MALWARE.String() {
+		prefixes = f.prefixes
+	}
+	raw := bytes.Join(prefixes, nil)
+	sum := sha256.Sum256(raw)
+	resp := &pb.ComputeThreatListDiffResponse{
+		ResponseType:        pb.ComputeThreatListDiffResponse_RESET,
+		NewVersionToken:     []byte("bench"),
+		Checksum:            &pb.ComputeThreatListDiffResponse_Checksum{Sha256: sum[:]},
+		RecommendedNextDiff: timepb.New(time.Now().Add(24 * time.Hour)),
+	}
+	if len(raw) > 0 {
+		resp.Additions = &pb.ThreatEntryAdditions{
+			RawHashes: []*pb.RawHashes{{PrefixSize: 4, RawHashes: raw}},
+		}
+	}
+	return resp
+}
+
+// hashLookup returns the full hashes of the unsafe URLs with the prefix.
+func (f *fakeAPI) hashLookup(prefix []byte) *pb.SearchHashesResponse {
+	resp := &pb.SearchHashesResponse{NegativeExpireTime: timepb.New(time.Now().Add(f.ttl))}
+	if len(prefix) < 4 {
+		return resp
+	}
+	for _, h := range f.threats[string(prefix[:4])] {
+		if bytes.HasPrefix(h, prefix) {
+			resp.Threats = append(resp.Threats, &pb.SearchHashesResponse_ThreatHash{
+				ThreatTypes: []pb.ThreatType{pb.ThreatType_MALWARE},
+				Hash:        h,
+				ExpireTime:  timepb.New(time.Now().Add(f.ttl)),
+			})
+		}
+	}
+	return resp
+}
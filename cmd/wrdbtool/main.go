@@ -0,0 +1,301 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Command wrdbtool is a tool for inspecting Web Risk database files offline.
+//
+// The database files are those written by wrserver and wrlookup with the -db
+// flag. Any location accepted by -db, including gs:// and s3:// locations,
+// may be used.
+//
+// To build the tool:
+//
+//	$ go get github.com/google/webrisk/cmd/wrdbtool
+//
+// Example usage:
+//
+//	$ wrdbtool stats webrisk.db
+//	Last update: 2023-05-24 10:00:00 +0000 UTC
+//	MALWARE: 4096 entries (4090 x 4 bytes, 6 longer), version 0a1b2c...
+//
+//	$ wrdbtool verify -apikey $APIKEY webrisk.db
+//	MALWARE: in sync (+12 -3 behind)
+//
+//	$ wrdbtool diff a.db b.db
+//	MALWARE: 2 only in a.db, 0 only in b.db
+//	  - 0a1b2c3d
+//	  - 4e5f6a7b
+//
+//	$ wrdbtool extract -list MALWARE webrisk.db
+//	0a1b2c3d
+//	...
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+const usage = `wrdbtool: command-line tool to inspect Web Risk databases.
+
+Commands:
+  stats DB         print a summary of every threat list in DB
+  verify DB        verify every threat list in DB against the Web Risk API
+  diff DB1 DB2     print the hash prefixes that differ between DB1 and DB2
+  extract DB       print the hash prefixes of a threat list in DB, in hex
+
+DB may be a file path, or a gs://bucket/object or s3://bucket/key location.
+
+Usage: %s command [flags] DB...
+
+`
+
+const (
+	codeOK = iota
+	codeDiffers
+	codeFailed
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(codeFailed)
+	}
+	code, err := run(os.Stdout, flag.Arg(0), flag.Args()[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "wrdbtool:", err)
+		os.Exit(codeFailed)
+	}
+	os.Exit(code)
+}
+
+// run runs the command cmd with args, writing its output to w. It returns
+// the exit code of the command, or an error if it could not be run.
+func run(w io.Writer, cmd string, args []string) (int, error) {
+	switch cmd {
+	case "stats":
+		return runStats(w, args)
+	case "verify":
+		return runVerify(w, args)
+	case "diff":
+		return runDiff(w, args)
+	case "extract":
+		return runExtract(w, args)
+	default:
+		return 0, fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+// readDatabase reads the database at location.
+func readDatabase(location string) (*webrisk.DatabaseSnapshot, error) {
+	store, err := webrisk.OpenStore(location)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	snap, err := webrisk.ReadDatabase(ctx, store)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", location, err)
+	}
+	return snap, nil
+}
+
+// parseArgs parses the flags of a command and checks that it was given
+// exactly n databases.
+func parseArgs(fs *flag.FlagSet, args []string, n int) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != n {
+		return fmt.Errorf("%s: expected %d database arguments, got %d", fs.Name(), n, fs.NArg())
+	}
+	return nil
+}
+
+func runStats(w io.Writer, args []string) (int, error) {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	if err := parseArgs(fs, args, 1); err != nil {
+		return 0, err
+	}
+	snap, err := readDatabase(fs.Arg(0))
+	if err != nil {
+		return 0, err
+	}
+	fmt.Fprintln(w, "Last update:", snap.Time.UTC())
+	for _, td := range snap.ThreatTypes() {
+		ls := snap.Lists[td]
+		var short int
+		for _, p := range ls.Prefixes {
+			if len(p) == 4 {
+				short++
+			}
+		}
+		fmt.Fprintf(w, "%v: %d entries (%d x 4 bytes, %d longer), version %x\n",
+			td, len(ls.Prefixes), short, len(ls.Prefixes)-short, ls.VersionToken)
+	}
+	return codeOK, nil
+}
+
+func runVerify(w io.Writer, args []string) (int, error) {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	apiKey := fs.String("apikey", os.Getenv("APIKEY"), "specify your Web Risk API key")
+	serverURL := fs.String("server", webrisk.DefaultServerURL, "Web Risk API server address.")
+	proxy := fs.String("proxy", "", "proxy to use to connect to the HTTP server")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return 0, err
+	}
+	if *apiKey == "" {
+		return 0, fmt.Errorf("verify: no -apikey specified")
+	}
+	snap, err := readDatabase(fs.Arg(0))
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	vs, err := webrisk.VerifyDatabase(ctx, webrisk.Config{
+		APIKey:    *apiKey,
+		ServerURL: *serverURL,
+		ProxyURL:  *proxy,
+	}, snap)
+	if err != nil {
+		return 0, err
+	}
+	code := codeOK
+	for _, v := range vs {
+		switch {
+		case v.Err != nil:
+			fmt.Fprintf(w, "%v: error: %v\n", v.ThreatType, v.Err)
+			code = codeFailed
+		case v.Reset:
+			fmt.Fprintf(w, "%v: unknown version, the API sent the full list\n", v.ThreatType)
+			code = codeDiffers
+		case !v.InSync:
+			fmt.Fprintf(w, "%v: OUT OF SYNC (+%d -%d behind)\n", v.ThreatType, v.Added, v.Removed)
+			code = codeDiffers
+		default:
+			fmt.Fprintf(w, "%v: in sync (+%d -%d behind)\n", v.ThreatType, v.Added, v.Removed)
+		}
+	}
+	return code, nil
+}
+
+func runDiff(w io.Writer, args []string) (int, error) {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	quiet := fs.Bool("q", false, "only print the number of differences")
+	if err := parseArgs(fs, args, 2); err != nil {
+		return 0, err
+	}
+	a, err := readDatabase(fs.Arg(0))
+	if err != nil {
+		return 0, err
+	}
+	b, err := readDatabase(fs.Arg(1))
+	if err != nil {
+		return 0, err
+	}
+
+	code := codeOK
+	tds := a.ThreatTypes()
+	for _, td := range b.ThreatTypes() {
+		if a.Lists[td] == nil {
+			tds = append(tds, td)
+		}
+	}
+	for _, td := range tds {
+		la, lb := a.Lists[td], b.Lists[td]
+		switch {
+		case la == nil:
+			fmt.Fprintf(w, "%v: only in %s\n", td, fs.Arg(1))
+			code = codeDiffers
+			continue
+		case lb == nil:
+			fmt.Fprintf(w, "%v: only in %s\n", td, fs.Arg(0))
+			code = codeDiffers
+			continue
+		}
+		onlyA, onlyB := diffPrefixes(la.Prefixes, lb.Prefixes)
+		if !bytes.Equal(la.VersionToken, lb.VersionToken) {
+			fmt.Fprintf(w, "%v: versions differ: %x, %x\n", td, la.VersionToken, lb.VersionToken)
+		}
+		if len(onlyA) == 0 && len(onlyB) == 0 {
+			fmt.Fprintf(w, "%v: identical\n", td)
+			continue
+		}
+		code = codeDiffers
+		fmt.Fprintf(w, "%v: %d only in %s, %d only in %s\n", td, len(onlyA), fs.Arg(0), len(onlyB), fs.Arg(1))
+		if *quiet {
+			continue
+		}
+		for _, p := range onlyA {
+			fmt.Fprintf(w, "  - %x\n", p)
+		}
+		for _, p := range onlyB {
+			fmt.Fprintf(w, "  + %x\n", p)
+		}
+	}
+	return code, nil
+}
+
+// diffPrefixes returns the prefixes only in a and only in b. Both must be
+// sorted.
+func diffPrefixes(a, b []string) (onlyA, onlyB []string) {
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0] < b[0]:
+			onlyA, a = append(onlyA, a[0]), a[1:]
+		case a[0] > b[0]:
+			onlyB, b = append(onlyB, b[0]), b[1:]
+		default:
+			a, b = a[1:], b[1:]
+		}
+	}
+	return append(onlyA, a...), append(onlyB, b...)
+}
+
+func runExtract(w io.Writer, args []string) (int, error) {
+	fs := flag.NewFlagSet("extract", flag.ContinueOnError)
+	list := fs.String("list", "", "threat list to extract, such as MALWARE")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return 0, err
+	}
+	td, err := webrisk.ParseThreatType(*list)
+	if err != nil {
+		return 0, fmt.Errorf("extract: invalid -list: %q", *list)
+	}
+	snap, err := readDatabase(fs.Arg(0))
+	if err != nil {
+		return 0, err
+	}
+	ls := snap.Lists[td]
+	if ls == nil {
+		return 0, fmt.Errorf("extract: %v not in %s", td, fs.Arg(0))
+	}
+	for _, p := range ls.Prefixes {
+		fmt.Fprintln(w, hex.EncodeToString([]byte(p)))
+	}
+	return codeOK, nil
+}
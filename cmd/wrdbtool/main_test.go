@@ -0,0 +1,162 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/webrisk"
+)
+
+// newFakeAPI returns a server that answers like the Web Risk API, with a
+// MALWARE list made of prefixes. The full list is sent to clients without a
+// version, and an empty diff to the others.
+func newFakeAPI(prefixes ...string) *httptest.Server {
+	sort.Strings(prefixes)
+	raw := strings.Join(prefixes, "")
+	sum := sha256.Sum256([]byte(raw))
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case !strings.HasSuffix(r.URL.Path, "threatLists:computeDiff"):
+			fmt.Fprint(w, `{}`)
+		case r.URL.Query().Get("version_token") == "":
+			fmt.Fprintf(w, `{"responseType":"RESET","additions":{"rawHashes":[{"prefixSize":4,"rawHashes":%q}]},"newVersionToken":"dG9rZW4=","recommendedNextDiff":"2100-01-01T00:00:00Z","checksum":{"sha256":%q}}`,
+				base64.StdEncoding.EncodeToString([]byte(raw)), base64.StdEncoding.EncodeToString(sum[:]))
+		default:
+			fmt.Fprintf(w, `{"responseType":"DIFF","newVersionToken":"dG9rZW4=","recommendedNextDiff":"2100-01-01T00:00:00Z","checksum":{"sha256":%q}}`,
+				base64.StdEncoding.EncodeToString(sum[:]))
+		}
+	}))
+}
+
+// exportDatabase writes the database of an UpdateClient synced with the fake
+// API to a file in dir, and returns its path.
+func exportDatabase(t *testing.T, dir, name string, prefixes ...string) string {
+	srv := newFakeAPI(prefixes...)
+	defer srv.Close()
+	wr, err := webrisk.NewUpdateClient(webrisk.Config{
+		APIKey:      "key",
+		ServerURL:   srv.URL,
+		ThreatLists: []webrisk.ThreatType{webrisk.ThreatTypeMalware},
+		Logger:      io.Discard,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+	if err := wr.WaitUntilReady(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = wr.ExportDatabase(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("unexpected export error: %v", err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	a := exportDatabase(t, dir, "a.db", "aaaa", "bbbb")
+	b := exportDatabase(t, dir, "b.db", "bbbb", "cccc")
+	inSync, outOfSync := newFakeAPI("aaaa", "bbbb"), newFakeAPI("bbbb", "cccc")
+	defer inSync.Close()
+	defer outOfSync.Close()
+
+	vectors := []struct {
+		cmd    string
+		args   []string
+		code   int
+		err    bool
+		output string // Expected output, or a line of it for stats
+	}{
+		{cmd: "stats", args: []string{a}, output: "MALWARE: 2 entries (2 x 4 bytes, 0 longer), version 746f6b656e\n"},
+		{cmd: "stats", args: []string{a, b}, err: true},
+		{cmd: "stats", args: []string{filepath.Join(dir, "missing.db")}, err: true},
+		{cmd: "stats", args: []string{"-bogus", a}, err: true},
+
+		{cmd: "verify", args: []string{"-apikey", "key", "-server", inSync.URL, a}, output: "MALWARE: in sync (+0 -0 behind)\n"},
+		{cmd: "verify", args: []string{"-apikey", "key", "-server", outOfSync.URL, a}, code: codeDiffers, output: "MALWARE: OUT OF SYNC (+0 -0 behind)\n"},
+		{cmd: "verify", args: []string{"-apikey", "", "-server", inSync.URL, a}, err: true},
+
+		{cmd: "diff", args: []string{a, a}, output: "MALWARE: identical\n"},
+		{cmd: "diff", args: []string{a, b}, code: codeDiffers,
+			output: fmt.Sprintf("MALWARE: 1 only in %s, 1 only in %s\n  - 61616161\n  + 63636363\n", a, b)},
+		{cmd: "diff", args: []string{"-q", a, b}, code: codeDiffers,
+			output: fmt.Sprintf("MALWARE: 1 only in %s, 1 only in %s\n", a, b)},
+		{cmd: "diff", args: []string{a}, err: true},
+
+		{cmd: "extract", args: []string{"-list", "MALWARE", a}, output: "61616161\n62626262\n"},
+		{cmd: "extract", args: []string{"-list", "BOGUS", a}, err: true},
+		{cmd: "extract", args: []string{"-list", "SOCIAL_ENGINEERING", a}, err: true},
+
+		{cmd: "bogus", args: []string{a}, err: true},
+	}
+	for i, v := range vectors {
+		var stdout bytes.Buffer
+		code, err := run(&stdout, v.cmd, v.args)
+		if (err != nil) != v.err {
+			t.Errorf("test %d, %s %q: unexpected error: %v", i, v.cmd, v.args, err)
+			continue
+		}
+		if code != v.code {
+			t.Errorf("test %d, %s %q: exit code = %d, want %d", i, v.cmd, v.args, code, v.code)
+		}
+		got := stdout.String()
+		if v.cmd == "stats" && v.output != "" {
+			if !strings.HasPrefix(got, "Last update: ") || !strings.HasSuffix(got, "\n"+v.output) {
+				t.Errorf("test %d, %s %q: output = %q, want a last update and %q", i, v.cmd, v.args, got, v.output)
+			}
+		} else if got != v.output {
+			t.Errorf("test %d, %s %q: output = %q, want %q", i, v.cmd, v.args, got, v.output)
+		}
+	}
+}
+
+func TestDiffPrefixes(t *testing.T) {
+	vectors := []struct {
+		a, b         []string
+		onlyA, onlyB []string
+	}{
+		{},
+		{a: []string{"aaaa"}, onlyA: []string{"aaaa"}},
+		{b: []string{"aaaa"}, onlyB: []string{"aaaa"}},
+		{a: []string{"aaaa", "bbbb"}, b: []string{"aaaa", "bbbb"}},
+		{a: []string{"aaaa", "cccc", "dddd"}, b: []string{"bbbb", "cccc", "eeee"},
+			onlyA: []string{"aaaa", "dddd"}, onlyB: []string{"bbbb", "eeee"}},
+	}
+	for i, v := range vectors {
+		onlyA, onlyB := diffPrefixes(v.a, v.b)
+		if fmt.Sprint(onlyA) != fmt.Sprint(v.onlyA) || fmt.Sprint(onlyB) != fmt.Sprint(v.onlyB) {
+			t.Errorf("test %d, diffPrefixes = (%q, %q), want (%q, %q)", i, onlyA, onlyB, v.onlyA, v.onlyB)
+		}
+	}
+}
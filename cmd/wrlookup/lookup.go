@@ -0,0 +1,122 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/webrisk"
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// sourceServer is the source of the verdicts given by a wrserver.
+const sourceServer = "wrserver"
+
+// lookuper looks up a single URL, and reports the source of the verdict.
+type lookuper interface {
+	lookup(ctx context.Context, url string) ([]webrisk.URLThreat, string, error)
+}
+
+// clientLookuper looks up URLs with a local client of the Web Risk API.
+type clientLookuper struct {
+	wr *webrisk.UpdateClient
+}
+
+func (cl clientLookuper) lookup(ctx context.Context, url string) ([]webrisk.URLThreat, string, error) {
+	threats, sources, err := cl.wr.LookupURLsSources(ctx, []string{url})
+	if err != nil {
+		return nil, "", err
+	}
+	return threats[0], sources[0].String(), nil
+}
+
+// hashLookuper looks up hex-encoded hashes of URL expressions, or prefixes of
+// them, with a local client of the Web Risk API.
+type hashLookuper struct {
+	wr *webrisk.UpdateClient
+}
+
+func (hl hashLookuper) lookup(ctx context.Context, hash string) ([]webrisk.URLThreat, string, error) {
+	h, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid hash %q: %v", hash, err)
+	}
+	threats, sources, err := hl.wr.LookupHashes(ctx, [][]byte{h})
+	if err != nil {
+		return nil, "", err
+	}
+	return threats[0], sources[0].String(), nil
+}
+
+// serverLookuper looks up URLs with the /v1/uris:search endpoint of a
+// wrserver, which gives the threat types of a URL but not the patterns that
+// matched.
+type serverLookuper struct {
+	base   string
+	client *http.Client
+}
+
+// newServerLookuper returns a serverLookuper for the wrserver at the base
+// URL.
+func newServerLookuper(base string) (serverLookuper, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return serverLookuper{}, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return serverLookuper{}, fmt.Errorf("not an http or https URL: %q", base)
+	}
+	return serverLookuper{base: strings.TrimSuffix(base, "/"), client: http.DefaultClient}, nil
+}
+
+func (sl serverLookuper) lookup(ctx context.Context, uri string) ([]webrisk.URLThreat, string, error) {
+	body, err := protojson.Marshal(&pb.SearchUrisRequest{Uri: uri})
+	if err != nil {
+		return nil, "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", sl.base+"/v1/uris:search", bytes.NewReader(body))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := sl.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("wrserver: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	pbResp := new(pb.SearchUrisResponse)
+	if err := protojson.Unmarshal(body, pbResp); err != nil {
+		return nil, "", err
+	}
+	var threats []webrisk.URLThreat
+	for _, tt := range pbResp.GetThreat().GetThreatTypes() {
+		threats = append(threats, webrisk.URLThreat{ThreatType: webrisk.ThreatType(tt)})
+	}
+	return threats, sourceServer, nil
+}
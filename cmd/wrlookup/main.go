@@ -13,9 +13,42 @@
 // limitations under the License.
 // Command wrlookup is a tool for looking up URLs via the command-line.
 //
-// The tool reads one URL per line from STDIN and checks every URL against
-// the Web Risk API. The "Safe" or "Unsafe" verdict is printed to STDOUT.
-// If an error occurred, debug information may be printed to STDERR.
+// The tool reads one URL per line from STDIN, or from the file given by
+// -input, and checks every URL against the Web Risk API. The "Safe" or
+// "Unsafe" verdict is printed to STDOUT. If an error occurred, debug
+// information may be printed to STDERR.
+//
+// With -format=json, a JSON object is printed per line and URL instead,
+// with its threat types, the source of the verdict (database, cache, or
+// api), and the time the lookup took. With -format=csv, the same fields are
+// printed as CSV records, after a header.
+//
+// Large lists of URLs can be looked up several at a time with -concurrency,
+// and at a bounded rate with -qps, to stay under quota. The results are
+// printed in the order of the input either way.
+//
+// With -watch, the tool runs as a daemon that feeds on a log pipeline or
+// another long-running producer: it waits for its database to be ready
+// before looking up URLs, keeps it up to date for as long as it runs, and
+// follows the -input file as it grows, like tail -f, instead of stopping at
+// its end. On SIGINT or SIGTERM, the tool stops reading, and exits once the
+// URLs already read are looked up.
+//
+// With -wrserver, URLs are looked up with a running wrserver instead, which
+// needs no API key. The source of its verdicts is "wrserver".
+//
+// With -local, URLs are looked up with the -db database, and the -cache file
+// if any, such as those of a wrserver, without ever calling the API, which
+// needs no API key either. A URL whose hash prefix is listed in the database,
+// but that the cache does not know about, cannot be confirmed then, so it is
+// reported as unsafe with the "database" source: such verdicts are only
+// leads for offline triage.
+//
+// With -hashes, every line of the input is a hex-encoded SHA256 hash of a URL
+// expression, or a prefix of at least 4 bytes of one, rather than a URL, such
+// as the hashes extracted from the logs of other security tools. The hash
+// takes the place of the URL in the output. The pattern of an unsafe
+// verdict is the full hash that matched, or the hash itself with -local.
 //
 // To build the tool:
 //
@@ -28,29 +61,50 @@
 //	Safe URL: https://google.com
 //	http://bad1url.org
 //	Unsafe URL: [{bad1url.org {MALWARE ANY_PLATFORM URL}}]
+//
+//	$ wrlookup -apikey $APIKEY -input urls.txt -format json
+//	{"url":"https://google.com","threatTypes":[],"source":"database","latencySeconds":0.000012}
+//	{"url":"http://bad1url.org","threatTypes":["MALWARE"],"source":"api","latencySeconds":0.084}
+//
+//	$ wrlookup -local -db /var/lib/wrserver/webrisk.db -hashes -input hashes.txt
 package main
 
 import (
-	"bufio"
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/google/webrisk"
 )
 
 var (
 	apiKeyFlag      = flag.String("apikey", "", "specify your Web Risk API key")
-	databaseFlag    = flag.String("db", "", "path to the Web Risk database. By default persistent storage is disabled (not recommended).")
+	databaseFlag    = flag.String("db", "", "path to the Web Risk database, or a gs://bucket/object or s3://bucket/key location. By default persistent storage is disabled (not recommended).")
 	serverURLFlag   = flag.String("server", webrisk.DefaultServerURL, "Web Risk API server address.")
 	proxyFlag       = flag.String("proxy", "", "proxy to use to connect to the HTTP server")
-	threatTypesFlag = flag.String("threatTypes", "ALL", "threat types to check against")
+	threatTypesFlag = flag.String("threatTypes", "ALL", "comma-separated threat types to check against, or ALL, each optionally followed by =TYPE to report it as another threat type (e.g. SOCIAL_ENGINEERING_EXTENDED_COVERAGE=SOCIAL_ENGINEERING)")
+	inputFlag       = flag.String("input", "", "path to a file of URLs to look up, one per line, instead of STDIN")
+	formatFlag      = flag.String("format", "text", "output format: text, json for a JSON object per line, or csv")
+	concurrencyFlag = flag.Int("concurrency", 1, "number of URLs to look up at a time")
+	qpsFlag         = flag.Float64("qps", 0, "maximum number of lookups to start per second, if positive")
+	progressFlag    = flag.Duration("progress", 0, "how often to report the number of URLs looked up so far to STDERR, if positive (e.g. 10s)")
+	watchFlag       = flag.Bool("watch", false, "run until interrupted, waiting for the database to be ready and following the -input file as it grows")
+	wrserverFlag    = flag.String("wrserver", "", "base URL of a running wrserver, such as http://wrserver:8080, to look up URLs with instead of the Web Risk API")
+	localFlag       = flag.Bool("local", false, "look up URLs with the -db database and the -cache file only, never calling the Web Risk API, so unconfirmed partial hash matches are reported as unsafe")
+	cacheFlag       = flag.String("cache", "", "path to a cache file to restore the results of earlier hash searches from, such as the one of a wrserver")
+	hashesFlag      = flag.Bool("hashes", false, "read hex-encoded SHA256 hashes of URL expressions, or prefixes of at least 4 bytes of them, instead of URLs")
 )
 
 const usage = `wrlookup: command-line tool to lookup URLs with Web Risk.
 
-Tool reads one URL per line from STDIN and checks every URL against the
-Web Risk API. The Safe or Unsafe verdict is printed to STDOUT. If an error
+Tool reads one URL per line from STDIN, or from the -input file, and checks
+every URL against the Web Risk API. The Safe or Unsafe verdict is printed to
+STDOUT, or a JSON object or CSV record per URL with -format. If an error
 occurred, debug information may be printed to STDERR.
 
 Exit codes (bitwise OR of following codes):
@@ -58,8 +112,11 @@ Exit codes (bitwise OR of following codes):
   1  if at least one URL is not safe.
   2  if at least one URL lookup failed.
   4  if the input was invalid.
+  8  if the flags were invalid, or the client could not be set up.
 
 Usage: %s -apikey=$APIKEY
+       %s -wrserver=http://wrserver:8080
+       %s -local -db=webrisk.db
 
 `
 
@@ -68,50 +125,144 @@ const (
 	codeUnsafe
 	codeFailed
 	codeInvalid
+	codeConfig
 )
 
 func main() {
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		fmt.Fprintf(os.Stderr, usage, os.Args[0], os.Args[0], os.Args[0])
 		flag.PrintDefaults()
 	}
-	flag.Parse()
-	if *apiKeyFlag == "" {
-		fmt.Fprintln(os.Stderr, "No -apikey specified")
-		os.Exit(codeInvalid)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err == flag.ErrHelp {
+		os.Exit(codeSafe)
+	} else if err != nil {
+		os.Exit(codeConfig)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run looks up the URLs read from stdin, or from -input, until ctx is done,
+// and returns the exit code.
+func run(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) int {
+	out, err := newResultWriter(*formatFlag, stdout, stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, "Invalid -format: ", err)
+		return codeConfig
+	}
+	if *concurrencyFlag < 1 || *qpsFlag < 0 {
+		fmt.Fprintln(stderr, "Invalid -concurrency or -qps")
+		return codeConfig
+	}
+	in := stdin
+	if *inputFlag != "" && *inputFlag != "-" {
+		f, err := os.Open(*inputFlag)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid -input: ", err)
+			return codeInvalid
+		}
+		defer f.Close()
+		in = f
+		if *watchFlag {
+			in = &follower{ctx: ctx, r: f, poll: followPoll}
+		}
 	}
-	sb, err := webrisk.NewUpdateClient(webrisk.Config{
+	var l lookuper
+	if *wrserverFlag != "" {
+		if *localFlag || *hashesFlag {
+			fmt.Fprintln(stderr, "-local and -hashes cannot be used with -wrserver")
+			return codeConfig
+		}
+		if l, err = newServerLookuper(*wrserverFlag); err != nil {
+			fmt.Fprintln(stderr, "Invalid -wrserver: ", err)
+			return codeConfig
+		}
+	} else {
+		wr := newClient(stderr)
+		if wr == nil {
+			return codeConfig
+		}
+		defer wr.Close()
+		l = clientLookuper{wr}
+		if *hashesFlag {
+			l = hashLookuper{wr}
+		}
+		if *watchFlag {
+			if err := wr.WaitUntilReady(ctx); err != nil {
+				fmt.Fprintln(stderr, "Database not ready:", err)
+				return codeFailed
+			}
+		}
+	}
+
+	return scan(ctx, in, l, out, scanOptions{
+		concurrency: *concurrencyFlag,
+		qps:         *qpsFlag,
+		progress:    *progressFlag,
+	}, stderr)
+}
+
+// newClient returns a client of the Web Risk API, as set up by the flags. If
+// the flags are invalid, it writes why to stderr and returns nil.
+func newClient(stderr io.Writer) *webrisk.UpdateClient {
+	if *localFlag && *databaseFlag == "" {
+		fmt.Fprintln(stderr, "No -db specified for -local")
+		return nil
+	}
+	if *apiKeyFlag == "" && !*localFlag {
+		fmt.Fprintln(stderr, "No -apikey specified")
+		return nil
+	}
+	var store webrisk.Store
+	if *databaseFlag != "" {
+		var err error
+		if store, err = webrisk.OpenStore(*databaseFlag); err != nil {
+			fmt.Fprintln(stderr, "Invalid -db: ", err)
+			return nil
+		}
+	}
+	wr, err := webrisk.NewUpdateClient(webrisk.Config{
 		APIKey:        *apiKeyFlag,
-		DBPath:        *databaseFlag,
-		Logger:        os.Stderr,
+		Store:         store,
+		Logger:        stderr,
 		ServerURL:     *serverURLFlag,
 		ProxyURL:      *proxyFlag,
 		ThreatListArg: *threatTypesFlag,
+		ReadOnly:      *localFlag,
+		CachePath:     *cacheFlag,
 	})
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Unable to initialize Web Risk client: ", err)
-		os.Exit(codeInvalid)
+		fmt.Fprintln(stderr, "Unable to initialize Web Risk client: ", err)
+		return nil
 	}
+	return wr
+}
 
-	scanner := bufio.NewScanner(os.Stdin)
-	code := codeSafe
-	for scanner.Scan() {
-		url := scanner.Text()
-		threats, err := sb.LookupURLs([]string{url})
-		if err != nil {
-			fmt.Fprintln(os.Stdout, "Unknown URL:", url)
-			fmt.Fprintln(os.Stderr, "Lookup error:", err)
-			code |= codeFailed
-		} else if len(threats[0]) == 0 {
-			fmt.Fprintln(os.Stdout, "Safe URL:", url)
-		} else {
-			fmt.Fprintln(os.Stdout, "Unsafe URL:", threats[0])
-			code |= codeUnsafe
+// followPoll is how often a followed -input file is checked for more URLs.
+const followPoll = 250 * time.Millisecond
+
+// follower reads r like tail -f: at its end, it waits for more to be written
+// instead of returning io.EOF, until ctx is done. It does not notice if r is
+// truncated or replaced, such as by log rotation.
+type follower struct {
+	ctx  context.Context
+	r    io.Reader
+	poll time.Duration
+}
+
+func (f *follower) Read(p []byte) (int, error) {
+	for {
+		n, err := f.r.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		select {
+		case <-f.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(f.poll):
 		}
 	}
-	if scanner.Err() != nil {
-		fmt.Fprintln(os.Stderr, "Unable to read input:", scanner.Err())
-		code |= codeInvalid
-	}
-	os.Exit(code)
 }
@@ -0,0 +1,399 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+func TestResultWriter(t *testing.T) {
+	results := []result{
+		newResult("http://safe.com/", nil, "database", 250*time.Millisecond, nil),
+		newResult("http://evil.com/", []webrisk.URLThreat{
+			{Pattern: "evil.com/", ThreatType: webrisk.ThreatTypeMalware},
+			{Pattern: "evil.com/a/", ThreatType: webrisk.ThreatTypeMalware},
+			{Pattern: "evil.com/", ThreatType: webrisk.ThreatTypeSocialEngineering},
+		}, "api", time.Second, nil),
+		newResult("http://a, b/", nil, "", 0, errors.New("lookup failed")),
+	}
+	vectors := []struct {
+		format      string
+		output, err string
+	}{{
+		format: "text",
+		output: "Safe URL: http://safe.com/\n" +
+			"Unsafe URL: [MALWARE MALWARE SOCIAL_ENGINEERING]\n" +
+			"Unknown URL: http://a, b/\n",
+		err: "Lookup error: lookup failed\n",
+	}, {
+		format: "json",
+		output: `{"url":"http://safe.com/","threatTypes":[],"source":"database","latencySeconds":0.25}` + "\n" +
+			`{"url":"http://evil.com/","threatTypes":["MALWARE","SOCIAL_ENGINEERING"],"source":"api","latencySeconds":1}` + "\n" +
+			`{"url":"http://a, b/","threatTypes":null,"latencySeconds":0,"error":"lookup failed"}` + "\n",
+	}, {
+		format: "csv",
+		output: "url,threatTypes,source,latencySeconds,error\n" +
+			"http://safe.com/,,database,0.25,\n" +
+			"http://evil.com/,MALWARE;SOCIAL_ENGINEERING,api,1,\n" +
+			"\"http://a, b/\",,,0,lookup failed\n",
+	}}
+	for i, v := range vectors {
+		var out, errOut bytes.Buffer
+		w, err := newResultWriter(v.format, &out, &errOut)
+		if err != nil {
+			t.Fatalf("test %d, unexpected error: %v", i, err)
+		}
+		for _, r := range results {
+			if err := w.write(r); err != nil {
+				t.Errorf("test %d, unexpected write error: %v", i, err)
+			}
+		}
+		if err := w.flush(); err != nil {
+			t.Errorf("test %d, unexpected flush error: %v", i, err)
+		}
+		if out.String() != v.output {
+			t.Errorf("test %d, output = %q, want %q", i, out.String(), v.output)
+		}
+		if errOut.String() != v.err {
+			t.Errorf("test %d, errors = %q, want %q", i, errOut.String(), v.err)
+		}
+	}
+
+	if _, err := newResultWriter("xml", nil, nil); err == nil {
+		t.Errorf("unexpected success for an unknown format")
+	}
+}
+
+// newFakeAPI returns a server that answers like the Web Risk API, with empty
+// threat lists and no matches.
+func newFakeAPI() *httptest.Server {
+	empty := sha256.Sum256(nil)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "threatLists:computeDiff"):
+			fmt.Fprintf(w, `{"responseType":"RESET","newVersionToken":"dG9rZW4=","recommendedNextDiff":"2100-01-01T00:00:00Z","checksum":{"sha256":%q}}`,
+				base64.StdEncoding.EncodeToString(empty[:]))
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+}
+
+func TestRun(t *testing.T) {
+	srv := newFakeAPI()
+	defer srv.Close()
+
+	input, err := os.CreateTemp("", "wrlookup")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(input.Name())
+	input.WriteString("http://example.com/\n")
+	input.Close()
+
+	vectors := []struct {
+		apiKey, format, input string
+		stdin                 string
+		code                  int
+	}{
+		{apiKey: "key", stdin: "http://example.com/\n\nhttp://example.org/a\n", code: codeSafe},
+		{apiKey: "key", input: input.Name(), code: codeSafe},
+		{apiKey: "key", stdin: "http://example.com/\nhttp://[::1/\n", code: codeFailed},
+		{apiKey: "key", input: input.Name() + ".missing", code: codeInvalid},
+		{apiKey: "", code: codeConfig},
+		{apiKey: "key", format: "xml", code: codeConfig},
+	}
+	defer func(apiKey, server, format, input string) {
+		*apiKeyFlag, *serverURLFlag, *formatFlag, *inputFlag = apiKey, server, format, input
+	}(*apiKeyFlag, *serverURLFlag, *formatFlag, *inputFlag)
+	for i, v := range vectors {
+		*apiKeyFlag, *serverURLFlag, *formatFlag, *inputFlag = v.apiKey, srv.URL, v.format, v.input
+		var stdout, stderr bytes.Buffer
+		if code := run(context.Background(), strings.NewReader(v.stdin), &stdout, &stderr); code != v.code {
+			t.Errorf("test %d, run() = %d, want %d; stderr:\n%s", i, code, v.code, stderr.String())
+		}
+	}
+}
+
+func TestLocalHashes(t *testing.T) {
+	srv := newFakeAPI()
+	defer srv.Close()
+
+	// Export the empty database of the fake API for -local lookups.
+	db, err := os.CreateTemp("", "wrlookup")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(db.Name())
+	wr, err := webrisk.NewUpdateClient(webrisk.Config{APIKey: "key", ServerURL: srv.URL, Logger: io.Discard})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := wr.WaitUntilReady(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = wr.ExportDatabase(db)
+	wr.Close()
+	db.Close()
+	if err != nil {
+		t.Fatalf("unexpected export error: %v", err)
+	}
+
+	hash := sha256.Sum256([]byte("example.com/"))
+	vectors := []struct {
+		local, hashes bool
+		db, wrserver  string
+		stdin         string
+		code          int
+		output        string
+	}{
+		{local: true, db: db.Name(), stdin: "http://example.com/\n", code: codeSafe, output: "Safe URL: http://example.com/\n"},
+		{local: true, hashes: true, db: db.Name(), stdin: fmt.Sprintf("%x\n%x\n", hash, hash[:4]), code: codeSafe,
+			output: fmt.Sprintf("Safe URL: %x\nSafe URL: %x\n", hash, hash[:4])},
+		{local: true, hashes: true, db: db.Name(), stdin: "example.com\n", code: codeFailed},
+		{local: true, hashes: true, db: db.Name(), stdin: "0102\n", code: codeFailed},
+		{local: true, code: codeConfig},
+		{hashes: true, wrserver: srv.URL, code: codeConfig},
+	}
+	defer func(apiKey, server, db, wrserver string) {
+		*apiKeyFlag, *serverURLFlag, *databaseFlag, *wrserverFlag = apiKey, server, db, wrserver
+		*localFlag, *hashesFlag = false, false
+	}(*apiKeyFlag, *serverURLFlag, *databaseFlag, *wrserverFlag)
+	for i, v := range vectors {
+		// No API key is needed, and the API must never be called.
+		*apiKeyFlag, *serverURLFlag, *formatFlag, *inputFlag = "", "http://127.0.0.1:1", "", ""
+		*localFlag, *hashesFlag, *databaseFlag, *wrserverFlag = v.local, v.hashes, v.db, v.wrserver
+		var stdout, stderr bytes.Buffer
+		if code := run(context.Background(), strings.NewReader(v.stdin), &stdout, &stderr); code != v.code {
+			t.Errorf("test %d, run() = %d, want %d; stderr:\n%s", i, code, v.code, stderr.String())
+		}
+		if v.output != "" && stdout.String() != v.output {
+			t.Errorf("test %d, output = %q, want %q", i, stdout.String(), v.output)
+		}
+	}
+}
+
+func TestServerLookuper(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/uris:search" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), "evil.com"):
+			fmt.Fprint(w, `{"threat":{"threatTypes":["MALWARE","SOCIAL_ENGINEERING"]}}`)
+		case strings.Contains(string(body), "fail.com"):
+			http.Error(w, "lookup failed", http.StatusInternalServerError)
+		default:
+			fmt.Fprint(w, `{"threat":{}}`)
+		}
+	}))
+	defer srv.Close()
+
+	defer func(wrserver, format, input string) {
+		*wrserverFlag, *formatFlag, *inputFlag = wrserver, format, input
+	}(*wrserverFlag, *formatFlag, *inputFlag)
+	*wrserverFlag, *formatFlag, *inputFlag = srv.URL+"/", "csv", ""
+
+	vectors := []struct {
+		stdin, output string
+		code          int
+	}{{
+		stdin:  "http://safe.com/\n",
+		output: "url,threatTypes,source,latencySeconds,error\nhttp://safe.com/,,wrserver,0,\n",
+		code:   codeSafe,
+	}, {
+		stdin:  "http://safe.com/\nhttp://evil.com/\n",
+		output: "url,threatTypes,source,latencySeconds,error\nhttp://safe.com/,,wrserver,0,\nhttp://evil.com/,MALWARE;SOCIAL_ENGINEERING,wrserver,0,\n",
+		code:   codeUnsafe,
+	}, {
+		stdin:  "http://evil.com/\nhttp://fail.com/\n",
+		output: "url,threatTypes,source,latencySeconds,error\nhttp://evil.com/,MALWARE;SOCIAL_ENGINEERING,wrserver,0,\nhttp://fail.com/,,,0,wrserver: 500 Internal Server Error: lookup failed\n",
+		code:   codeUnsafe | codeFailed,
+	}}
+	latency := regexp.MustCompile(`,[0-9.e-]+,`)
+	for i, v := range vectors {
+		var stdout, stderr bytes.Buffer
+		if code := run(context.Background(), strings.NewReader(v.stdin), &stdout, &stderr); code != v.code {
+			t.Errorf("test %d, run() = %d, want %d; stderr:\n%s", i, code, v.code, stderr.String())
+		}
+		// Latencies vary, so they are replaced by zero.
+		if output := latency.ReplaceAllString(stdout.String(), ",0,"); output != v.output {
+			t.Errorf("test %d, output = %q, want %q", i, output, v.output)
+		}
+	}
+
+	*wrserverFlag = "wrserver:8080"
+	if code := run(context.Background(), strings.NewReader(""), io.Discard, io.Discard); code != codeConfig {
+		t.Errorf("run() = %d with an invalid -wrserver, want %d", code, codeConfig)
+	}
+}
+
+// lookupFunc is a lookuper that calls itself.
+type lookupFunc func(ctx context.Context, url string) ([]webrisk.URLThreat, string, error)
+
+func (f lookupFunc) lookup(ctx context.Context, url string) ([]webrisk.URLThreat, string, error) {
+	return f(ctx, url)
+}
+
+func TestScan(t *testing.T) {
+	var mu sync.Mutex
+	var inFlight, maxInFlight int
+	l := lookupFunc(func(ctx context.Context, url string) ([]webrisk.URLThreat, string, error) {
+		mu.Lock()
+		if inFlight++; inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		// Later URLs finish first, to check that the input order is kept.
+		i, _ := strconv.Atoi(strings.TrimPrefix(url, "http://"))
+		time.Sleep(time.Duration(20-i) * time.Millisecond)
+		switch {
+		case i == 3:
+			return []webrisk.URLThreat{{Pattern: url, ThreatType: webrisk.ThreatTypeMalware}}, "api", nil
+		case i == 7:
+			return nil, "", errors.New("lookup failed")
+		}
+		return nil, "database", nil
+	})
+	var in strings.Builder
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&in, "http://%d\n", i)
+	}
+
+	vectors := []struct {
+		opts        scanOptions
+		minDuration time.Duration
+		maxInFlight int
+	}{
+		{opts: scanOptions{}, maxInFlight: 1},
+		{opts: scanOptions{concurrency: 4}, maxInFlight: 4},
+		{opts: scanOptions{concurrency: 4, qps: 100, progress: 50 * time.Millisecond}, minDuration: 190 * time.Millisecond, maxInFlight: 4},
+	}
+	for i, v := range vectors {
+		maxInFlight = 0
+		var stdout, stderr bytes.Buffer
+		w, _ := newResultWriter("json", &stdout, &stderr)
+		start := time.Now()
+		if code := scan(context.Background(), strings.NewReader(in.String()), l, w, v.opts, &stderr); code != codeUnsafe|codeFailed {
+			t.Errorf("test %d, scan() = %d, want %d", i, code, codeUnsafe|codeFailed)
+		}
+		if d := time.Since(start); d < v.minDuration {
+			t.Errorf("test %d, scan took %v, want at least %v", i, d, v.minDuration)
+		}
+		if maxInFlight > v.maxInFlight {
+			t.Errorf("test %d, %d lookups at a time, want at most %d", i, maxInFlight, v.maxInFlight)
+		}
+		var urls []string
+		dec := json.NewDecoder(&stdout)
+		for {
+			var r result
+			if err := dec.Decode(&r); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("test %d, unexpected error: %v", i, err)
+			}
+			urls = append(urls, r.URL)
+		}
+		if got, want := strings.Join(urls, "\n")+"\n", in.String(); got != want {
+			t.Errorf("test %d, output URLs = %q, want %q", i, got, want)
+		}
+		if progress := strings.Contains(stderr.String(), "Looked up 20 URLs"); progress != (v.opts.progress > 0) {
+			t.Errorf("test %d, progress reported = %v, want %v; stderr:\n%s", i, progress, v.opts.progress > 0, stderr.String())
+		}
+	}
+}
+
+// chanWriter is a resultWriter that sends the results to a channel.
+type chanWriter chan result
+
+func (cw chanWriter) write(r result) error {
+	cw <- r
+	return nil
+}
+
+func (cw chanWriter) flush() error { return nil }
+
+func TestWatch(t *testing.T) {
+	f, err := os.CreateTemp("", "wrlookup")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	l := lookupFunc(func(ctx context.Context, url string) ([]webrisk.URLThreat, string, error) {
+		return nil, "database", nil
+	})
+	r, err := os.Open(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	in := &follower{ctx: ctx, r: r, poll: time.Millisecond}
+	results := make(chanWriter)
+	code := make(chan int)
+	go func() {
+		code <- scan(ctx, in, l, results, scanOptions{}, io.Discard)
+	}()
+
+	// Verdicts are written as soon as URLs are, including after the end of
+	// the file has been reached.
+	for _, url := range []string{"http://a.com/", "http://b.com/", "http://c.com/"} {
+		fmt.Fprintln(f, url)
+		select {
+		case r := <-results:
+			if r.URL != url {
+				t.Errorf("result for %q, want %q", r.URL, url)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no result for %q", url)
+		}
+	}
+
+	cancel()
+	select {
+	case c := <-code:
+		if c != codeSafe {
+			t.Errorf("scan() = %d, want %d", c, codeSafe)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("scan did not stop")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+// result is the verdict for a single URL.
+type result struct {
+	URL         string               `json:"url"`
+	ThreatTypes []webrisk.ThreatType `json:"threatTypes"`
+	Source      string               `json:"source,omitempty"`
+	Latency     float64              `json:"latencySeconds"`
+	Error       string               `json:"error,omitempty"`
+
+	threats []webrisk.URLThreat
+}
+
+// newResult returns the result of looking up url, given what the lookup
+// returned and how long it took.
+func newResult(url string, threats []webrisk.URLThreat, source string, latency time.Duration, err error) result {
+	r := result{URL: url, Latency: latency.Seconds(), threats: threats}
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.Source = source
+	r.ThreatTypes = []webrisk.ThreatType{}
+	seen := make(map[webrisk.ThreatType]bool)
+	for _, t := range threats {
+		if !seen[t.ThreatType] {
+			seen[t.ThreatType] = true
+			r.ThreatTypes = append(r.ThreatTypes, t.ThreatType)
+		}
+	}
+	return r
+}
+
+// resultWriter writes results in one of the output formats.
+type resultWriter interface {
+	write(r result) error
+	flush() error
+}
+
+// newResultWriter returns a resultWriter for the named format, which writes
+// verdicts to w and errors in the text format to errw.
+func newResultWriter(format string, w, errw io.Writer) (resultWriter, error) {
+	switch format {
+	case "", "text":
+		return &textWriter{w: w, errw: errw}, nil
+	case "json":
+		return &jsonWriter{enc: json.NewEncoder(w)}, nil
+	case "csv":
+		cw := csv.NewWriter(w)
+		err := cw.Write([]string{"url", "threatTypes", "source", "latencySeconds", "error"})
+		return &csvWriter{w: cw}, err
+	}
+	return nil, fmt.Errorf("unknown format: %q", format)
+}
+
+// textWriter writes a human readable line per URL.
+type textWriter struct {
+	w, errw io.Writer
+}
+
+func (tw *textWriter) write(r result) error {
+	var err error
+	switch {
+	case r.Error != "":
+		_, err = fmt.Fprintln(tw.w, "Unknown URL:", r.URL)
+		fmt.Fprintln(tw.errw, "Lookup error:", r.Error)
+	case len(r.threats) == 0:
+		_, err = fmt.Fprintln(tw.w, "Safe URL:", r.URL)
+	default:
+		_, err = fmt.Fprintln(tw.w, "Unsafe URL:", r.threats)
+	}
+	return err
+}
+
+func (tw *textWriter) flush() error { return nil }
+
+// jsonWriter writes a JSON object per line and URL.
+type jsonWriter struct {
+	enc *json.Encoder
+}
+
+func (jw *jsonWriter) write(r result) error { return jw.enc.Encode(r) }
+
+func (jw *jsonWriter) flush() error { return nil }
+
+// csvWriter writes a CSV record per URL, after a header. The threat types
+// of a URL are separated by ';'.
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (cw *csvWriter) write(r result) error {
+	tts := make([]string, len(r.ThreatTypes))
+	for i, tt := range r.ThreatTypes {
+		tts[i] = tt.String()
+	}
+	if err := cw.w.Write([]string{
+		r.URL,
+		strings.Join(tts, ";"),
+		r.Source,
+		strconv.FormatFloat(r.Latency, 'f', -1, 64),
+		r.Error,
+	}); err != nil {
+		return err
+	}
+	// Flush every record, so that they can be read as they are looked up.
+	return cw.flush()
+}
+
+func (cw *csvWriter) flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
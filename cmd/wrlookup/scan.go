@@ -0,0 +1,237 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// scanOptions control how a scan looks up URLs.
+type scanOptions struct {
+	// concurrency is the number of lookups made at a time. If less than
+	// one, URLs are looked up one at a time.
+	concurrency int
+
+	// qps, if positive, is the maximum number of lookups started per
+	// second.
+	qps float64
+
+	// progress, if positive, is how often the number of URLs looked up so
+	// far is reported.
+	progress time.Duration
+}
+
+// job is a URL to look up, along with its position in the input.
+type job struct {
+	i   int
+	url string
+}
+
+// indexedResult is a result, along with the position of its URL in the
+// input.
+type indexedResult struct {
+	i int
+	r result
+}
+
+// scanCounts are the number of URLs that a scan looked up so far.
+type scanCounts struct {
+	done, unsafe, failed int64
+}
+
+// scan looks up every URL read from in with l, one per line, and writes the
+// results to out in the same order. Progress reports and errors are written
+// to stderr. It returns the exit code for the results.
+//
+// Once ctx is done, scan stops reading in, but the lookups that already
+// started are finished and written.
+func scan(ctx context.Context, in io.Reader, l lookuper, out resultWriter, opts scanOptions, stderr io.Writer) int {
+	if opts.concurrency < 1 {
+		opts.concurrency = 1
+	}
+	// Everything is aborted if the output cannot be written.
+	readCtx, stopReading := context.WithCancel(ctx)
+	defer stopReading()
+	lookupCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+
+	// Lines are read separately, so that reading can be stopped while
+	// waiting for input that may never come.
+	lines := make(chan string)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-readCtx.Done():
+				return
+			}
+		}
+		readErr <- scanner.Err()
+	}()
+
+	// The window bounds the number of results that are waiting for the
+	// lookups of earlier URLs to finish, so that a slow lookup does not
+	// make the others pile up.
+	window := make(chan struct{}, 4*opts.concurrency)
+	jobs := make(chan job)
+	go func() {
+		defer close(jobs)
+		var tick <-chan time.Time
+		if opts.qps > 0 {
+			t := time.NewTicker(time.Duration(float64(time.Second) / opts.qps))
+			defer t.Stop()
+			tick = t.C
+		}
+		for i := 0; ; {
+			var url string
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					return
+				}
+				if url = strings.TrimSpace(line); url == "" {
+					continue
+				}
+			case <-readCtx.Done():
+				return
+			}
+			select {
+			case window <- struct{}{}:
+			case <-readCtx.Done():
+				return
+			}
+			if tick != nil {
+				select {
+				case <-tick:
+				case <-readCtx.Done():
+					return
+				}
+			}
+			jobs <- job{i, url}
+			i++
+		}
+	}()
+
+	results := make(chan indexedResult)
+	var wg sync.WaitGroup
+	for w := 0; w < opts.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				start := time.Now()
+				threats, source, err := l.lookup(lookupCtx, j.url)
+				results <- indexedResult{j.i, newResult(j.url, threats, source, time.Since(start), err)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var counts scanCounts
+	start := time.Now()
+	stopProgress := func() {}
+	if opts.progress > 0 {
+		t := time.NewTicker(opts.progress)
+		stop, stopped := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(stopped)
+			for {
+				select {
+				case <-t.C:
+					counts.report(stderr, time.Since(start))
+				case <-stop:
+					return
+				}
+			}
+		}()
+		stopProgress = func() {
+			t.Stop()
+			close(stop)
+			<-stopped
+		}
+	}
+
+	code := codeSafe
+	pending := make(map[int]result)
+	next := 0
+	var writeErr error
+	for ir := range results {
+		if writeErr != nil {
+			continue // Drain the lookups that are still running.
+		}
+		pending[ir.i] = ir.r
+		for r, ok := pending[next]; ok; r, ok = pending[next] {
+			delete(pending, next)
+			next++
+			<-window
+			switch {
+			case r.Error != "":
+				code |= codeFailed
+				atomic.AddInt64(&counts.failed, 1)
+			case len(r.threats) > 0:
+				code |= codeUnsafe
+				atomic.AddInt64(&counts.unsafe, 1)
+			}
+			atomic.AddInt64(&counts.done, 1)
+			if writeErr = out.write(r); writeErr != nil {
+				fmt.Fprintln(stderr, "Unable to write output:", writeErr)
+				code |= codeFailed
+				stopReading()
+				abort()
+				break
+			}
+		}
+	}
+	stopProgress()
+	if writeErr != nil {
+		return code
+	}
+	select {
+	case err := <-readErr:
+		if err != nil {
+			fmt.Fprintln(stderr, "Unable to read input:", err)
+			code |= codeInvalid
+		}
+	default:
+	}
+	if err := out.flush(); err != nil {
+		fmt.Fprintln(stderr, "Unable to write output:", err)
+		code |= codeFailed
+	}
+	if opts.progress > 0 {
+		counts.report(stderr, time.Since(start))
+	}
+	return code
+}
+
+// report writes the counts, and the rate of lookups over elapsed, to w.
+func (c *scanCounts) report(w io.Writer, elapsed time.Duration) {
+	done := atomic.LoadInt64(&c.done)
+	fmt.Fprintf(w, "Looked up %d URLs in %v (%.1f/s): %d unsafe, %d failed\n",
+		done, elapsed.Round(time.Millisecond), float64(done)/elapsed.Seconds(),
+		atomic.LoadInt64(&c.unsafe), atomic.LoadInt64(&c.failed))
+}
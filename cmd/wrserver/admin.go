@@ -0,0 +1,397 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+const (
+	adminVerifyPath     = "/admin/db/verify"
+	adminPrewarmPath    = "/admin/cache/prewarm"
+	adminLookupPath     = "/admin/cache/lookup"
+	adminPurgePath      = "/admin/cache/purge"
+	adminStatsResetPath = "/admin/stats/reset"
+	adminUpdatePath     = "/admin/db/update"
+	adminExportPath     = "/admin/db/export"
+	adminPrefixesPath   = "/admin/db/prefixes"
+	adminEventsPath     = "/admin/events"
+)
+
+// requireAdmin wraps h so that it is only served to requests that carry
+// token as a bearer token in the Authorization header.
+func requireAdmin(token string, h http.HandlerFunc) http.HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		auth := req.Header.Get("Authorization")
+		got := strings.TrimPrefix(auth, "Bearer ")
+		if token == "" || got == auth || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			resp.Header().Set("WWW-Authenticate", "Bearer")
+			problemError(resp, "unauthorized", http.StatusUnauthorized, codeUnauthorized)
+			return
+		}
+		h(resp, req)
+	}
+}
+
+// listVerification is the JSON form of webrisk.ListVerification.
+type listVerification struct {
+	ThreatType webrisk.ThreatType `json:"threatType"`
+	InSync     bool               `json:"inSync"`
+	Reset      bool               `json:"reset"`
+	Added      int                `json:"added"`
+	Removed    int                `json:"removed"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// serveVerify checks the local threat lists against the Web Risk API
+// and reports which of them are out of sync.
+func serveVerify(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient) {
+	if req.Method != "POST" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	vs, err := wr.VerifyDatabase(req.Context())
+	if err != nil {
+		backendError(resp, err)
+		return
+	}
+	out := struct {
+		InSync bool               `json:"inSync"`
+		Lists  []listVerification `json:"lists"`
+	}{InSync: true, Lists: []listVerification{}}
+	for _, v := range vs {
+		lv := listVerification{
+			ThreatType: v.ThreatType,
+			InSync:     v.InSync,
+			Reset:      v.Reset,
+			Added:      v.Added,
+			Removed:    v.Removed,
+		}
+		if v.Err != nil {
+			lv.Error = v.Err.Error()
+		}
+		out.InSync = out.InSync && v.InSync
+		out.Lists = append(out.Lists, lv)
+	}
+	buf, err := json.Marshal(out)
+	if err != nil {
+		problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
+		return
+	}
+	resp.Header().Set("Content-Type", mimeJSON)
+	resp.Write(buf)
+}
+
+// readURLList reads a list of URLs, one per line. Blank lines and lines
+// starting with "#" are ignored.
+func readURLList(r io.Reader) ([]string, error) {
+	var urls []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, sc.Err()
+}
+
+// servePrewarm looks up the URLs listed in the request body, one per line,
+// so that their results are cached.
+func servePrewarm(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient) {
+	if req.Method != "POST" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	urls, err := readURLList(req.Body)
+	if err != nil {
+		problemError(resp, err.Error(), http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	n, err := wr.WarmCache(req.Context(), urls)
+	out := struct {
+		URLs  int    `json:"urls"`
+		Error string `json:"error,omitempty"`
+	}{URLs: n}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	buf, err := json.Marshal(out)
+	if err != nil {
+		problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
+		return
+	}
+	resp.Header().Set("Content-Type", mimeJSON)
+	resp.Write(buf)
+}
+
+// cachedThreat is a threat type cached for a full hash.
+type cachedThreat struct {
+	ThreatType webrisk.ThreatType `json:"threatType"`
+	Expires    time.Time          `json:"expires"`
+	TTL        float64            `json:"ttlSeconds"` // Negative once expired
+}
+
+// cachedHash is the JSON form of webrisk.CachedHash.
+type cachedHash struct {
+	Pattern         string               `json:"pattern"`
+	Hash            string               `json:"hash"`
+	DatabaseThreats []webrisk.ThreatType `json:"databaseThreats"`
+	Result          string               `json:"result"`
+	Threats         []cachedThreat       `json:"threats,omitempty"`
+	NegativePrefix  string               `json:"negativePrefix,omitempty"`
+	NegativeExpires *time.Time           `json:"negativeExpires,omitempty"`
+	NegativeTTL     float64              `json:"negativeTtlSeconds,omitempty"`
+	TTLPolicy       string               `json:"ttlPolicy"`
+}
+
+// newCachedHash converts ch to its JSON form, and sums up how it would be
+// used by a lookup at now as its result: "database" if the database alone
+// shows it is safe, "positive" or "negative" for a valid cache entry,
+// "expired" if the cache entry has expired, or "miss".
+func newCachedHash(ch webrisk.CachedHash, now time.Time) cachedHash {
+	out := cachedHash{
+		Pattern:         ch.Pattern,
+		Hash:            hex.EncodeToString(ch.Hash),
+		DatabaseThreats: ch.DatabaseThreats,
+		Result:          "miss",
+		TTLPolicy:       ch.TTLPolicy.String(),
+	}
+	if out.DatabaseThreats == nil {
+		out.DatabaseThreats = []webrisk.ThreatType{}
+	}
+	expired := false
+	for tt, exp := range ch.Threats {
+		ttl := exp.Sub(now)
+		expired = expired || ttl <= 0
+		out.Threats = append(out.Threats, cachedThreat{ThreatType: tt, Expires: exp, TTL: ttl.Seconds()})
+	}
+	sort.Slice(out.Threats, func(i, j int) bool { return out.Threats[i].ThreatType < out.Threats[j].ThreatType })
+	if ch.NegativePrefix != nil {
+		exp := ch.NegativeExpire
+		out.NegativePrefix = hex.EncodeToString(ch.NegativePrefix)
+		out.NegativeExpires = &exp
+		out.NegativeTTL = exp.Sub(now).Seconds()
+	}
+	switch {
+	case len(ch.DatabaseThreats) == 0:
+		out.Result = "database"
+	case len(ch.Threats) > 0 && !expired:
+		out.Result = "positive"
+	case len(ch.Threats) > 0:
+		out.Result = "expired"
+	case ch.NegativePrefix != nil && ch.NegativeExpire.After(now):
+		out.Result = "negative"
+	case ch.NegativePrefix != nil:
+		out.Result = "expired"
+	}
+	return out
+}
+
+// serveCacheLookup reports what the database and cache hold for each of the
+// hashes of the URL given by the "url" query parameter.
+func serveCacheLookup(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient) {
+	if req.Method != "GET" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	url := req.URL.Query().Get("url")
+	if url == "" {
+		problemError(resp, "missing url", http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	chs, err := wr.InspectCache(url)
+	if err != nil {
+		problemError(resp, err.Error(), http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	out := struct {
+		URL    string       `json:"url"`
+		Hashes []cachedHash `json:"hashes"`
+	}{URL: url, Hashes: []cachedHash{}}
+	now := time.Now()
+	for _, ch := range chs {
+		out.Hashes = append(out.Hashes, newCachedHash(ch, now))
+	}
+	buf, err := json.Marshal(out)
+	if err != nil {
+		problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
+		return
+	}
+	resp.Header().Set("Content-Type", mimeJSON)
+	resp.Write(buf)
+}
+
+// servePurge removes all entries from the cache of wr.
+func servePurge(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient) {
+	if req.Method != "POST" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	if err := wr.ClearCache(); err != nil {
+		backendError(resp, err)
+		return
+	}
+	resp.Header().Set("Content-Type", mimeJSON)
+	resp.Write([]byte(`{"purged":true}`))
+}
+
+// serveStatsReset zeroes the counters of wr and the latency histograms of
+// its requests.
+func serveStatsReset(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient, lat *latencies) {
+	if req.Method != "POST" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	lat.reset()
+	if err := wr.ResetStats(); err != nil {
+		backendError(resp, err)
+		return
+	}
+	resp.Header().Set("Content-Type", mimeJSON)
+	resp.Write([]byte(`{"reset":true}`))
+}
+
+// serveUpdate updates the database of wr right away, and reports its status
+// afterwards.
+func serveUpdate(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient) {
+	if req.Method != "POST" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	out := struct {
+		Updated bool   `json:"updated"`
+		Error   string `json:"error,omitempty"`
+	}{Updated: true}
+	if err := wr.UpdateDatabase(req.Context()); err != nil {
+		out.Updated = false
+		out.Error = err.Error()
+	}
+	buf, err := json.Marshal(out)
+	if err != nil {
+		problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
+		return
+	}
+	resp.Header().Set("Content-Type", mimeJSON)
+	resp.Write(buf)
+}
+
+// serveExport sends the database of wr in the format of a -db file.
+func serveExport(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient) {
+	if req.Method != "GET" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	var buf bytes.Buffer
+	if err := wr.ExportDatabase(&buf); err != nil {
+		backendError(resp, err)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/octet-stream")
+	resp.Header().Set("Content-Disposition", `attachment; filename="webrisk.db"`)
+	resp.Write(buf.Bytes())
+}
+
+// prefixSize is the length of the hash prefixes served by servePrefixes.
+const prefixSize = 4
+
+// shortPrefixes returns the distinct first prefixSize bytes of prefixes,
+// which must be sorted, in ascending order.
+func shortPrefixes(prefixes []string) []string {
+	var out []string
+	for _, p := range prefixes {
+		if len(p) > prefixSize {
+			p = p[:prefixSize]
+		}
+		if n := len(out); n == 0 || out[n-1] != p {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// servePrefixes sends the 4-byte hash prefixes of the threat list given by
+// the "list" query parameter, for network appliances that match prefixes
+// themselves. Longer prefixes are cut to 4 bytes. With format=binary, the
+// default, the prefixes are concatenated; with format=hex, they are hex
+// encoded, one per line. The ETag changes whenever the list does.
+func servePrefixes(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient) {
+	if req.Method != "GET" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	q := req.URL.Query()
+	tt, err := webrisk.ParseThreatType(q.Get("list"))
+	if err != nil {
+		problemError(resp, err.Error(), http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	format := q.Get("format")
+	switch format {
+	case "":
+		format = "binary"
+	case "binary", "hex":
+	default:
+		problemError(resp, "invalid format: "+format, http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	snap, err := wr.SnapshotDatabase()
+	if err != nil {
+		backendError(resp, err)
+		return
+	}
+	ls, ok := snap.Lists[tt]
+	if !ok {
+		problemError(resp, "threat list not in the database: "+tt.String(), http.StatusNotFound, codeNotFound)
+		return
+	}
+	prefixes := shortPrefixes(ls.Prefixes)
+	h := resp.Header()
+	h.Set("ETag", `"`+hex.EncodeToString(ls.SHA256)+"-"+format+`"`)
+	h.Set("Last-Modified", snap.Time.UTC().Format(http.TimeFormat))
+	h.Set("X-Webrisk-Prefixes", strconv.Itoa(len(prefixes)))
+	if etagMatches(req.Header.Get("If-None-Match"), h.Get("ETag")) {
+		resp.WriteHeader(http.StatusNotModified)
+		return
+	}
+	var buf bytes.Buffer
+	if format == "hex" {
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		buf.Grow(len(prefixes) * (2*prefixSize + 1))
+		for _, p := range prefixes {
+			buf.WriteString(hex.EncodeToString([]byte(p)))
+			buf.WriteByte('\n')
+		}
+	} else {
+		h.Set("Content-Type", "application/octet-stream")
+		buf.Grow(len(prefixes) * prefixSize)
+		for _, p := range prefixes {
+			buf.WriteString(p)
+		}
+	}
+	resp.Write(buf.Bytes())
+}
@@ -0,0 +1,321 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+const (
+	asyncPath = "/async"
+
+	// signatureHeader is the header that carries the signature of the
+	// verdicts posted to callbacks.
+	signatureHeader = "X-Webrisk-Signature"
+
+	// asyncQueueSize is the number of submitted URLs waiting to be looked
+	// up before more are turned away.
+	asyncQueueSize = 1024
+
+	// asyncWorkers is the number of URLs that are looked up at once.
+	asyncWorkers = 16
+
+	// asyncMaxDeliveries is the number of verdicts being posted at once,
+	// including those waiting to be retried, after which lookups wait.
+	asyncMaxDeliveries = 1024
+
+	// asyncLookupTimeout bounds the lookup of a submitted URL.
+	asyncLookupTimeout = 30 * time.Second
+
+	// asyncMaxRequestSize is the maximum size of a submission.
+	asyncMaxRequestSize = 64 << 10
+)
+
+// asyncRetryDelays are the delays before each retry of a verdict that could
+// not be posted to its callback.
+var asyncRetryDelays = []time.Duration{time.Second, 10 * time.Second, time.Minute}
+
+// asyncSubmission is the JSON object posted to the async endpoint.
+type asyncSubmission struct {
+	ID          string `json:"id"` // Optional, generated if empty
+	URI         string `json:"uri"`
+	CallbackURI string `json:"callbackUri"`
+}
+
+// asyncVerdict is the JSON object posted to the callback of a submission.
+type asyncVerdict struct {
+	ID          string               `json:"id"`
+	URI         string               `json:"uri"`
+	Time        time.Time            `json:"time"`
+	Blocked     bool                 `json:"blocked"` // Unsafe, or its scheme is rejected
+	ThreatTypes []webrisk.ThreatType `json:"threatTypes"`
+	Source      string               `json:"source,omitempty"`
+	Error       string               `json:"error,omitempty"`
+}
+
+// asyncJob is a submission waiting to be looked up.
+type asyncJob struct {
+	sub    asyncSubmission
+	req    *http.Request // Request that submitted it, for its client address
+	wr     *webrisk.UpdateClient
+	events *eventHub
+	lat    *latencies
+}
+
+// asyncScanner looks up the URLs submitted to the async endpoints in the
+// background, and posts their verdicts to callbacks, signed with a secret,
+// for clients such as mail scanners that queue their work and cannot wait
+// for lookups.
+type asyncScanner struct {
+	secret []byte
+	hosts  map[string]bool // Hosts that callbacks may be posted to, or nil for any
+	client *http.Client
+
+	mu         sync.RWMutex
+	closed     bool
+	jobs       chan asyncJob
+	deliveries chan struct{} // Semaphore of the verdicts being posted
+	stop       chan struct{} // Closed to stop retrying
+	wg         sync.WaitGroup
+}
+
+// async is the scanner of the async endpoints, or nil if they are disabled.
+var async *asyncScanner
+
+// newAsyncScanner returns a scanner that signs verdicts with secret, and
+// only posts them to the callback hosts listed in hosts, separated by
+// commas, or to any public address if hosts is empty. Redirects of the
+// callbacks are not followed.
+func newAsyncScanner(secret, hosts string) *asyncScanner {
+	s := &asyncScanner{
+		secret:     []byte(secret),
+		jobs:       make(chan asyncJob, asyncQueueSize),
+		deliveries: make(chan struct{}, asyncMaxDeliveries),
+		stop:       make(chan struct{}),
+	}
+	for _, host := range strings.Split(hosts, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			if s.hosts == nil {
+				s.hosts = make(map[string]bool)
+			}
+			s.hosts[host] = true
+		}
+	}
+	// Anyone may submit a callback, so unless the hosts are listed, they
+	// must not be able to have wrserver post to the network it runs in.
+	s.client = newPublicClient(s.hosts != nil)
+	s.client.Timeout = sinkTimeout
+	for i := 0; i < asyncWorkers; i++ {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for job := range s.jobs {
+				s.scan(job)
+			}
+		}()
+	}
+	return s
+}
+
+// serve queues the URL of the submission in the request body, and answers
+// with a 202 response holding its ID, which its verdict will carry.
+func (s *asyncScanner) serve(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient, events *eventHub, lat *latencies) {
+	if req.Method != "POST" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	var sub asyncSubmission
+	if err := json.NewDecoder(io.LimitReader(req.Body, asyncMaxRequestSize)).Decode(&sub); err != nil {
+		problemError(resp, err.Error(), http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	if sub.URI == "" {
+		problemError(resp, "missing uri", http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	if err := s.checkCallback(sub.CallbackURI); err != nil {
+		problemError(resp, err.Error(), http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	if sub.ID == "" {
+		sub.ID = uuid4()
+	}
+	s.mu.RLock()
+	queued := false
+	if !s.closed {
+		select {
+		case s.jobs <- asyncJob{sub: sub, req: req, wr: wr, events: events, lat: lat}:
+			queued = true
+		default:
+		}
+	}
+	s.mu.RUnlock()
+	if !queued {
+		resp.Header().Set("Retry-After", "1")
+		problemError(resp, "too many pending submissions", http.StatusServiceUnavailable, codeOverloaded)
+		return
+	}
+	buf, err := json.Marshal(struct {
+		ID string `json:"id"`
+	}{sub.ID})
+	if err != nil {
+		problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
+		return
+	}
+	resp.Header().Set("Content-Type", mimeJSON)
+	resp.WriteHeader(http.StatusAccepted)
+	resp.Write(buf)
+}
+
+// checkCallback returns an error if verdicts may not be posted to rawURL.
+func (s *asyncScanner) checkCallback(rawURL string) error {
+	if rawURL == "" {
+		return errors.New("missing callbackUri")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callbackUri: %q", rawURL)
+	}
+	if s.hosts != nil && !s.hosts[strings.ToLower(u.Hostname())] {
+		return fmt.Errorf("callbackUri host not allowed: %q", u.Hostname())
+	}
+	return nil
+}
+
+// scan looks up the URL of job, and posts its verdict in the background.
+func (s *asyncScanner) scan(job asyncJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), asyncLookupTimeout)
+	defer cancel()
+	timer, ctx := job.lat.start("async", job.req.WithContext(ctx))
+	timer.url = job.sub.URI
+	v := asyncVerdict{ID: job.sub.ID, URI: job.sub.URI, ThreatTypes: []webrisk.ThreatType{}}
+	threats, sources, err := job.wr.LookupURLsSources(ctx, []string{job.sub.URI})
+	switch {
+	case errors.Is(err, webrisk.ErrSchemeRejected):
+		v.Blocked, v.Error = true, err.Error()
+	case err != nil:
+		timer.source = "error"
+		v.Error = err.Error()
+	default:
+		timer.source, timer.threats = sources[0].String(), threats[0]
+		v.Source = sources[0].String()
+		if d, ok := newDetection("async", clientAddr(job.req), job.sub.URI, threats[0], sources[0]); ok {
+			job.events.publish(d)
+			v.Blocked, v.ThreatTypes = true, d.ThreatTypes
+		}
+		job.events.zone.addThreats(threats[0])
+	}
+	timer.done()
+	v.Time = time.Now().UTC()
+
+	s.deliveries <- struct{}{}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() { <-s.deliveries }()
+		s.deliver(job.sub.CallbackURI, v)
+	}()
+}
+
+// deliver posts v to callback, and retries after asyncRetryDelays until it
+// succeeds or the scanner is closed.
+func (s *asyncScanner) deliver(callback string, v asyncVerdict) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Async verdict %s: %v", v.ID, err)
+		return
+	}
+	for i := 0; ; i++ {
+		if err = s.post(callback, body); err == nil {
+			return
+		}
+		if i == len(asyncRetryDelays) {
+			break
+		}
+		select {
+		case <-time.After(asyncRetryDelays[i]):
+		case <-s.stop:
+			log.Printf("Async verdict %s for %s dropped on shutdown: %v", v.ID, callback, err)
+			return
+		}
+	}
+	log.Printf("Async verdict %s for %s dropped: %v", v.ID, callback, err)
+}
+
+// post posts body to callback, signed.
+func (s *asyncScanner) post(callback string, body []byte) error {
+	req, err := http.NewRequest("POST", callback, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mimeJSON)
+	req.Header.Set(signatureHeader, signVerdict(s.secret, time.Now(), body))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("callback answered %s", resp.Status)
+	}
+	return nil
+}
+
+// signVerdict returns the signature of a verdict posted at t with body:
+// t=<Unix time>,v1=<hex HMAC-SHA256 of "<Unix time>.<body>" keyed with
+// secret>. Callbacks should check it, and that t is recent so that verdicts
+// cannot be replayed.
+func signVerdict(secret []byte, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// close stops accepting submissions, looks up those that are queued and
+// posts their verdicts, but stops retrying the verdicts that failed.
+func (s *asyncScanner) close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.jobs)
+	close(s.stop)
+	s.mu.Unlock()
+	s.wg.Wait()
+}
@@ -0,0 +1,179 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+const (
+	adminChangesPath = "/admin/db/changes"
+	mimeEventStream  = "text/event-stream"
+)
+
+var (
+	// changePollPeriod is how often the database is checked for changes
+	// while there are subscribers to them.
+	changePollPeriod = time.Second
+
+	// changeKeepAlive is how long a change stream may stay idle before a
+	// comment is sent, so that proxies do not close it.
+	changeKeepAlive = 30 * time.Second
+)
+
+// listChange is the state of a threat list sent to change subscribers, and
+// what changed since the previous state if it is an update.
+type listChange struct {
+	ThreatType   webrisk.ThreatType `json:"threatType"`
+	Time         time.Time          `json:"time"`
+	VersionToken []byte             `json:"versionToken"`
+	SHA256       string             `json:"sha256"`
+	Entries      int                `json:"entries"`
+	Added        int                `json:"added"`
+	Removed      int                `json:"removed"`
+	Additions    []string           `json:"additions,omitempty"` // Hex hash prefixes, with prefixes=true
+	Removals     []string           `json:"removals,omitempty"`
+}
+
+// newListChange returns the state of ls as of t, with the differences from
+// old if not nil, including the hash prefixes themselves if withPrefixes.
+func newListChange(tt webrisk.ThreatType, t time.Time, old, ls *webrisk.ListSnapshot, withPrefixes bool) listChange {
+	c := listChange{
+		ThreatType:   tt,
+		Time:         t,
+		VersionToken: ls.VersionToken,
+		SHA256:       hex.EncodeToString(ls.SHA256),
+		Entries:      len(ls.Prefixes),
+	}
+	if old == nil {
+		return c
+	}
+	removals, additions := sb4Diff(old.Prefixes, ls.Prefixes)
+	c.Added, c.Removed = len(additions), len(removals)
+	if withPrefixes {
+		for _, p := range additions {
+			c.Additions = append(c.Additions, hex.EncodeToString([]byte(p)))
+		}
+		for _, i := range removals {
+			c.Removals = append(c.Removals, hex.EncodeToString([]byte(old.Prefixes[i])))
+		}
+	}
+	return c
+}
+
+// writeSSE writes an event of the given type, with v as JSON data, to w in
+// the server-sent events format.
+func writeSSE(w io.Writer, event string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+// serveChanges streams the changes of the threat lists as server-sent
+// events, until the client goes away or done is closed. It first sends a
+// "list" event with the current state of every threat list, and then a
+// "change" event whenever one is updated, with the number of hash prefixes
+// added and removed, and the prefixes themselves with prefixes=true. Since
+// every stream starts with the state of every list, a client that reconnects
+// can tell from the checksums which lists changed in the meantime.
+func serveChanges(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient, done <-chan struct{}) {
+	if req.Method != "GET" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	withPrefixes := false
+	if v := req.URL.Query().Get("prefixes"); v != "" {
+		var err error
+		if withPrefixes, err = strconv.ParseBool(v); err != nil {
+			problemError(resp, "invalid prefixes: "+v, http.StatusBadRequest, codeInvalidFormat)
+			return
+		}
+	}
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		problemError(resp, "streaming not supported", http.StatusInternalServerError, codeInternal)
+		return
+	}
+	snap, err := wr.SnapshotDatabase()
+	if err != nil {
+		backendError(resp, err)
+		return
+	}
+	h := resp.Header()
+	h.Set("Content-Type", mimeEventStream)
+	h.Set("Cache-Control", "no-cache")
+	resp.WriteHeader(http.StatusOK)
+	for _, tt := range snap.ThreatTypes() {
+		c := newListChange(tt, snap.Time, nil, snap.Lists[tt], false)
+		if err := writeSSE(resp, "list", c); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	poll := time.NewTicker(changePollPeriod)
+	defer poll.Stop()
+	idle := time.NewTimer(changeKeepAlive)
+	defer idle.Stop()
+	for {
+		select {
+		case <-poll.C:
+			cur, err := wr.SnapshotDatabase()
+			if err != nil || cur == snap {
+				continue
+			}
+			sent := false
+			for _, tt := range cur.ThreatTypes() {
+				old, ls := snap.Lists[tt], cur.Lists[tt]
+				if old == nil {
+					old = &webrisk.ListSnapshot{}
+				} else if bytes.Equal(old.SHA256, ls.SHA256) {
+					continue
+				}
+				c := newListChange(tt, cur.Time, old, ls, withPrefixes)
+				if err := writeSSE(resp, "change", c); err != nil {
+					return
+				}
+				sent = true
+			}
+			snap = cur
+			if sent {
+				flusher.Flush()
+				idle.Reset(changeKeepAlive)
+			}
+		case <-idle.C:
+			if _, err := io.WriteString(resp, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+			idle.Reset(changeKeepAlive)
+		case <-req.Context().Done():
+			return
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/google/webrisk"
+)
+
+const (
+	checkPath = "/check"
+	probePath = "/probe"
+)
+
+// originalURLHeader is the header that reverse proxies pass the URL to check
+// in, if it is not given by the url query parameter.
+const originalURLHeader = "X-Original-URL"
+
+// Headers that the probe endpoint annotates its responses with. The lookup
+// endpoints also tell clients how long they may cache their verdicts with
+// the expires and revalidate headers.
+const (
+	threatsHeader    = "X-Webrisk-Threats"
+	sourceHeader     = "X-Webrisk-Source"
+	expiresHeader    = "X-Webrisk-Expires"
+	revalidateHeader = "X-Webrisk-Revalidate-After"
+)
+
+// checkedURL returns the URL to check, given by the url query parameter or
+// the X-Original-URL header.
+func checkedURL(req *http.Request) string {
+	if rawURL := req.URL.Query().Get("url"); rawURL != "" {
+		return rawURL
+	}
+	return req.Header.Get(originalURLHeader)
+}
+
+// serveCheck answers whether the URL given by the url query parameter, or
+// the X-Original-URL header, is safe, with a 204 response if it is and a 403
+// response listing its threat types if not, as expected by the auth_request
+// module of nginx and the HTTP checks of HAProxy.
+func serveCheck(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient, events *eventHub, lat *latencies) {
+	timer, ctx := lat.start("check", req)
+	defer timer.done()
+	if req.Method != "GET" && req.Method != "HEAD" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	rawURL := checkedURL(req)
+	if rawURL == "" {
+		problemError(resp, "missing url", http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	timer.url = rawURL
+	threats, sources, err := wr.LookupURLsSources(ctx, []string{rawURL})
+	if errors.Is(err, webrisk.ErrSchemeRejected) {
+		problemError(resp, err.Error(), http.StatusForbidden, codeSchemeRejected)
+		return
+	}
+	if err != nil {
+		timer.source = "error"
+		backendError(resp, err, rawURL)
+		return
+	}
+	timer.source, timer.threats = sources[0].String(), threats[0]
+	if unshortenLookups {
+		rawURL, threats[0], sources[0] = followRedirects(ctx, wr, rawURL, threats[0], sources[0])
+	}
+	resp.Header().Set("Cache-Control", "no-store")
+	setCacheControl(resp.Header(), wr, rawURL, threats[0])
+	d, unsafe := newDetection("check", clientAddr(req), rawURL, threats[0], sources[0])
+	if !unsafe {
+		resp.WriteHeader(http.StatusNoContent)
+		return
+	}
+	events.publish(d)
+	events.zone.addThreats(threats[0])
+	resp.Header().Set(threatTypesHeader, joinThreatTypes(d.ThreatTypes))
+	resp.WriteHeader(http.StatusForbidden)
+}
+
+// serveProbe looks up the URL given like for serveCheck, and always answers
+// with a 200 response annotated with the verdict, for policy engines that
+// act on headers when wrserver is chained behind another proxy:
+// X-Webrisk-Threats lists the threat types of the URL, or is "none",
+// X-Webrisk-Source is where the verdict came from, or "rejected" if the
+// scheme of the URL is rejected, and X-Webrisk-Expires and
+// X-Webrisk-Revalidate-After are when the verdict expires and should be
+// looked up again.
+func serveProbe(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient, events *eventHub, lat *latencies) {
+	timer, ctx := lat.start("probe", req)
+	defer timer.done()
+	if req.Method != "GET" && req.Method != "HEAD" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	rawURL := checkedURL(req)
+	if rawURL == "" {
+		problemError(resp, "missing url", http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	timer.url = rawURL
+	threats, sources, err := wr.LookupURLsSources(ctx, []string{rawURL})
+	if errors.Is(err, webrisk.ErrSchemeRejected) {
+		resp.Header().Set(threatsHeader, "none")
+		resp.Header().Set(sourceHeader, "rejected")
+		resp.Header().Set("Cache-Control", "no-store")
+		resp.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		timer.source = "error"
+		backendError(resp, err, rawURL)
+		return
+	}
+	timer.source, timer.threats = sources[0].String(), threats[0]
+	if unshortenLookups {
+		rawURL, threats[0], sources[0] = followRedirects(ctx, wr, rawURL, threats[0], sources[0])
+	}
+	h := resp.Header()
+	h.Set("Cache-Control", "no-store")
+	h.Set(threatsHeader, "none")
+	h.Set(sourceHeader, sources[0].String())
+	if d, ok := newDetection("probe", clientAddr(req), rawURL, threats[0], sources[0]); ok {
+		events.publish(d)
+		events.zone.addThreats(threats[0])
+		h.Set(threatsHeader, joinThreatTypes(d.ThreatTypes))
+	}
+	setCacheControl(h, wr, rawURL, threats[0])
+	resp.WriteHeader(http.StatusOK)
+}
+
+// setCacheControl sets the expires and revalidate headers of h for the
+// verdict that a lookup of rawURL just gave, with threats, and returns them.
+// Neither is set if they cannot be told.
+func setCacheControl(h http.Header, wr *webrisk.UpdateClient, rawURL string, threats []webrisk.URLThreat) (webrisk.CacheControl, bool) {
+	cc, err := wr.CacheControl(rawURL, threats)
+	if err != nil {
+		return cc, false
+	}
+	h.Set(expiresHeader, cc.Expire.UTC().Format(http.TimeFormat))
+	h.Set(revalidateHeader, cc.RevalidateAfter.UTC().Format(http.TimeFormat))
+	return cc, true
+}
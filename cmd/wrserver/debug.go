@@ -0,0 +1,98 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/webrisk"
+)
+
+const debugExpressionsPath = "/debug/expressions"
+
+// expression is the JSON form of webrisk.Expression.
+type expression struct {
+	Expression      string               `json:"expression"`
+	Hash            string               `json:"hash"`
+	HashPrefix      string               `json:"hashPrefix"`
+	DatabasePrefix  string               `json:"databasePrefix,omitempty"`
+	DatabaseThreats []webrisk.ThreatType `json:"databaseThreats"`
+	Threats         []webrisk.ThreatType `json:"threats"`
+	Matched         bool                 `json:"matched"`
+}
+
+// urlExplanation is the JSON form of webrisk.URLExplanation.
+type urlExplanation struct {
+	URL          string       `json:"url"`
+	CanonicalURL string       `json:"canonicalUrl,omitempty"`
+	Scheme       string       `json:"scheme"`
+	Expressions  []expression `json:"expressions"`
+}
+
+// newURLExplanation converts ex to its JSON form.
+func newURLExplanation(ex *webrisk.URLExplanation) urlExplanation {
+	out := urlExplanation{
+		URL:          ex.URL,
+		CanonicalURL: ex.CanonicalURL,
+		Scheme:       ex.Scheme.String(),
+		Expressions:  []expression{},
+	}
+	for _, e := range ex.Expressions {
+		x := expression{
+			Expression:      e.Pattern,
+			Hash:            hex.EncodeToString(e.Hash),
+			HashPrefix:      hex.EncodeToString(e.HashPrefix),
+			DatabasePrefix:  hex.EncodeToString(e.DatabasePrefix),
+			DatabaseThreats: e.DatabaseThreats,
+			Threats:         e.Threats,
+			Matched:         len(e.Threats) > 0,
+		}
+		if x.DatabaseThreats == nil {
+			x.DatabaseThreats = []webrisk.ThreatType{}
+		}
+		if x.Threats == nil {
+			x.Threats = []webrisk.ThreatType{}
+		}
+		out.Expressions = append(out.Expressions, x)
+	}
+	return out
+}
+
+// serveExpressions reports every expression and hash generated for the URL
+// given by the "url" query parameter, and which of them matched.
+func serveExpressions(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient) {
+	if req.Method != "GET" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	url := req.URL.Query().Get("url")
+	if url == "" {
+		problemError(resp, "missing url", http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	ex, err := wr.ExplainURL(req.Context(), url)
+	if err != nil {
+		backendError(resp, err, url)
+		return
+	}
+	buf, err := json.Marshal(newURLExplanation(ex))
+	if err != nil {
+		problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
+		return
+	}
+	resp.Header().Set("Content-Type", mimeJSON)
+	resp.Write(buf)
+}
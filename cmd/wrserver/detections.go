@@ -0,0 +1,403 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+const (
+	// detectionBuffer is the number of detections buffered for each
+	// destination of the detection log. Detections are dropped, and the
+	// drops logged, for destinations that fall further behind.
+	detectionBuffer = 1024
+
+	// sinkTimeout is how long a webhook or syslog server has to accept a
+	// detection.
+	sinkTimeout = 10 * time.Second
+
+	// syslogPriority is the priority of the syslog messages for detections,
+	// with the local0 facility and the warning severity.
+	syslogPriority = 16*8 + 4
+)
+
+// detectionSink is a destination of the detection log.
+type detectionSink interface {
+	write(d detection) error
+	close() error
+}
+
+// detectionLog records every detection to a set of destinations, such as for
+// incident response or compliance. Each destination is written to in the
+// background, so that a slow one holds up neither lookups nor the others.
+type detectionLog struct {
+	mu     sync.RWMutex
+	closed bool
+	queues []*detectionQueue
+	wg     sync.WaitGroup
+}
+
+// detectionQueue holds the detections yet to be written to a destination.
+type detectionQueue struct {
+	name    string // Name of the destination, for logging
+	sink    detectionSink
+	ch      chan detection
+	dropped int64 // Accessed atomically
+}
+
+// newDetectionLog returns a log that records detections to the destinations
+// in dests, separated by commas, or nil if there are none. A destination is
+// either a file path, a syslog://host[:port], syslog+tcp://host[:port], or
+// syslog+unix:///path URL of a syslog server, or an http:// or https:// URL
+// of a webhook. The messages sent to a syslog server hold the detections as
+// JSON objects, unless its URL has a format=cef or format=leef query.
+func newDetectionLog(dests string) (*detectionLog, error) {
+	l := new(detectionLog)
+	for _, dest := range strings.Split(dests, ",") {
+		if dest = strings.TrimSpace(dest); dest == "" {
+			continue
+		}
+		name, sink, err := openDetectionSink(dest)
+		if err != nil {
+			for _, q := range l.queues {
+				q.sink.close()
+			}
+			return nil, err
+		}
+		l.queues = append(l.queues, &detectionQueue{name: name, sink: sink, ch: make(chan detection, detectionBuffer)})
+	}
+	if len(l.queues) == 0 {
+		return nil, nil
+	}
+	for _, q := range l.queues {
+		l.wg.Add(1)
+		go func(q *detectionQueue) {
+			defer l.wg.Done()
+			for d := range q.ch {
+				if err := q.sink.write(d); err != nil {
+					log.Printf("Detection log %s: %v", q.name, err)
+				}
+			}
+		}(q)
+	}
+	return l, nil
+}
+
+// openDetectionSink returns the name and the sink of the destination dest.
+func openDetectionSink(dest string) (string, detectionSink, error) {
+	if !strings.Contains(dest, "://") {
+		al, err := webrisk.OpenAuditLog(dest, 0, 0)
+		if err != nil {
+			return "", nil, err
+		}
+		return dest, &fileSink{al}, nil
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		return "", nil, err
+	}
+	name := u.Scheme + "://" + u.Host + u.Path
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return "", nil, fmt.Errorf("missing webhook host: %q", dest)
+		}
+		return name, &webhookSink{url: dest, client: &http.Client{Timeout: sinkTimeout}}, nil
+	case "syslog", "syslog+tcp":
+		if u.Hostname() == "" {
+			return "", nil, fmt.Errorf("missing syslog host: %q", dest)
+		}
+		network, addr := "udp", u.Host
+		if u.Scheme == "syslog+tcp" {
+			network = "tcp"
+		}
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "514")
+		}
+		format, err := parseSyslogFormat(u.Query().Get("format"))
+		if err != nil {
+			return "", nil, err
+		}
+		return name, newSyslogSink(network, addr, format), nil
+	case "syslog+unix":
+		if u.Path == "" {
+			return "", nil, fmt.Errorf("missing syslog socket: %q", dest)
+		}
+		format, err := parseSyslogFormat(u.Query().Get("format"))
+		if err != nil {
+			return "", nil, err
+		}
+		return name, newSyslogSink("unixgram", u.Path, format), nil
+	}
+	return "", nil, fmt.Errorf("invalid detection log: %q", dest)
+}
+
+// record queues d for every destination. It never blocks: d is dropped for
+// the destinations that are too far behind.
+func (l *detectionLog) record(d detection) {
+	if l == nil {
+		return
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return
+	}
+	for _, q := range l.queues {
+		select {
+		case q.ch <- d:
+		default:
+			if n := atomic.AddInt64(&q.dropped, 1); n == 1 || n%1000 == 0 {
+				log.Printf("Detection log %s is falling behind: %d detections dropped", q.name, n)
+			}
+		}
+	}
+}
+
+// close writes the queued detections and closes the destinations.
+func (l *detectionLog) close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.closed = true
+	for _, q := range l.queues {
+		close(q.ch)
+	}
+	l.mu.Unlock()
+	l.wg.Wait()
+	for _, q := range l.queues {
+		if err := q.sink.close(); err != nil {
+			log.Printf("Detection log %s: %v", q.name, err)
+		}
+	}
+}
+
+// fileSink writes detections to a rotated file, as a JSON object per line.
+type fileSink struct {
+	al *webrisk.AuditLog
+}
+
+func (s *fileSink) write(d detection) error {
+	line, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	_, err = s.al.Write(append(line, '\n'))
+	return err
+}
+
+func (s *fileSink) close() error { return s.al.Close() }
+
+// webhookSink posts each detection to a URL as a JSON object.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *webhookSink) write(d detection) error {
+	return postJSON(s.client, s.url, d)
+}
+
+func (s *webhookSink) close() error { return nil }
+
+// syslogFormat is the format of the content of the syslog messages for
+// detections.
+type syslogFormat int
+
+const (
+	syslogJSON syslogFormat = iota // The detection as a JSON object
+	syslogCEF                      // ArcSight Common Event Format
+	syslogLEEF                     // QRadar Log Event Extended Format 2.0
+)
+
+var syslogFormatNames = map[string]syslogFormat{
+	"json": syslogJSON,
+	"cef":  syslogCEF,
+	"leef": syslogLEEF,
+}
+
+// parseSyslogFormat returns the format named s, or syslogJSON if s is empty.
+func parseSyslogFormat(s string) (syslogFormat, error) {
+	if s == "" {
+		return syslogJSON, nil
+	}
+	f, ok := syslogFormatNames[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("invalid syslog format: %q", s)
+	}
+	return f, nil
+}
+
+// Escapers of the values of the header and of the extension of CEF records.
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, "|", `\|`, "\n", " ", "\r", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, "=", `\=`, "\n", `\n`, "\r", `\r`)
+	leefEscaper         = strings.NewReplacer("^", "%5E", "\n", " ", "\r", " ")
+)
+
+// cefSeverity is the severity of the CEF and LEEF records for detections, on
+// a scale from 0 to 10.
+const cefSeverity = 8
+
+// cef returns d as a CEF record, whose custom strings are its threat types,
+// the source of the verdict, the endpoint that found it, and its tenant.
+func cef(d detection) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|Google|wrserver|%s|detection|Unsafe URL|%d|", cefHeaderEscaper.Replace(webrisk.Version()), cefSeverity)
+	fmt.Fprintf(&b, "rt=%d", d.Time.UnixMilli())
+	if d.Client != "" {
+		fmt.Fprintf(&b, " src=%s", cefExtensionEscaper.Replace(d.Client))
+	}
+	fmt.Fprintf(&b, " request=%s", cefExtensionEscaper.Replace(d.URL))
+	fmt.Fprintf(&b, " cs1Label=threatTypes cs1=%s", cefExtensionEscaper.Replace(joinThreatTypes(d.ThreatTypes)))
+	fmt.Fprintf(&b, " cs2Label=source cs2=%s", cefExtensionEscaper.Replace(d.Source))
+	fmt.Fprintf(&b, " cs3Label=endpoint cs3=%s", cefExtensionEscaper.Replace(d.Endpoint))
+	if d.Tenant != "" {
+		fmt.Fprintf(&b, " cs4Label=tenant cs4=%s", cefExtensionEscaper.Replace(d.Tenant))
+	}
+	return []byte(b.String())
+}
+
+// leef returns d as a LEEF 2.0 record, with attributes separated by carets,
+// which are percent-encoded in the values. Its time is in milliseconds since
+// the epoch.
+func leef(d detection) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "LEEF:2.0|Google|wrserver|%s|detection|^|", strings.ReplaceAll(webrisk.Version(), "|", " "))
+	fmt.Fprintf(&b, "devTime=%d^sev=%d", d.Time.UnixMilli(), cefSeverity)
+	if d.Client != "" {
+		fmt.Fprintf(&b, "^src=%s", leefEscaper.Replace(d.Client))
+	}
+	fmt.Fprintf(&b, "^url=%s^cat=%s^source=%s^endpoint=%s", leefEscaper.Replace(d.URL),
+		leefEscaper.Replace(joinThreatTypes(d.ThreatTypes)), leefEscaper.Replace(d.Source), leefEscaper.Replace(d.Endpoint))
+	if d.Tenant != "" {
+		fmt.Fprintf(&b, "^tenant=%s", leefEscaper.Replace(d.Tenant))
+	}
+	return []byte(b.String())
+}
+
+// syslogSink sends each detection to a syslog server as an RFC 5424 message,
+// whose content is the detection as a JSON object, or as a CEF or LEEF
+// record. Messages are framed by octet counting over TCP, as in RFC 6587.
+type syslogSink struct {
+	network, addr string
+	hostname      string
+	content       syslogFormat
+	conn          net.Conn // Nil until the first message, or after an error
+}
+
+func newSyslogSink(network, addr string, content syslogFormat) *syslogSink {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &syslogSink{network: network, addr: addr, hostname: hostname, content: content}
+}
+
+// format returns the RFC 5424 message for d.
+func (s *syslogSink) format(d detection) ([]byte, error) {
+	var msg []byte
+	switch s.content {
+	case syslogCEF:
+		msg = cef(d)
+	case syslogLEEF:
+		msg = leef(d)
+	default:
+		var err error
+		if msg, err = json.Marshal(d); err != nil {
+			return nil, err
+		}
+	}
+	header := fmt.Sprintf("<%d>1 %s %s wrserver %d detection - ",
+		syslogPriority, d.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"), s.hostname, os.Getpid())
+	return append([]byte(header), msg...), nil
+}
+
+func (s *syslogSink) write(d detection) error {
+	msg, err := s.format(d)
+	if err != nil {
+		return err
+	}
+	if s.network == "tcp" {
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+	if err = s.send(msg); err != nil {
+		// Dial again once, such as after the server restarted.
+		s.close()
+		if err = s.send(msg); err != nil {
+			s.close()
+		}
+	}
+	return err
+}
+
+// send writes msg to the server, dialing it first if needed.
+func (s *syslogSink) send(msg []byte) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.addr, sinkTimeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(sinkTimeout))
+	_, err := s.conn.Write(msg)
+	return err
+}
+
+func (s *syslogSink) close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// postJSON posts v to a webhook at rawurl as a JSON object.
+func postJSON(client *http.Client, rawurl string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(rawurl, mimeJSON, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook failed: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+const (
+	// eventBuffer is the number of events buffered for each subscriber.
+	// Events are dropped for subscribers that fall further behind.
+	eventBuffer = 256
+
+	mimeNDJSON = "application/x-ndjson"
+)
+
+// detection is an event for a URL that was found to be unsafe.
+type detection struct {
+	Time        time.Time            `json:"time"`
+	Tenant      string               `json:"tenant,omitempty"`
+	Endpoint    string               `json:"endpoint"`
+	Client      string               `json:"client,omitempty"`
+	URL         string               `json:"url"`
+	ThreatTypes []webrisk.ThreatType `json:"threatTypes"`
+	Source      string               `json:"source"`
+}
+
+// newDetection returns the detection of url by the named endpoint for the
+// client with the given address, or false if threats is empty. The source
+// is what gave the verdict.
+func newDetection(endpoint, client, url string, threats []webrisk.URLThreat, source webrisk.LookupSource) (detection, bool) {
+	if len(threats) == 0 {
+		return detection{}, false
+	}
+	d := detection{Time: time.Now(), Endpoint: endpoint, Client: client, URL: url, Source: source.String()}
+	seen := make(map[webrisk.ThreatType]bool)
+	for _, t := range threats {
+		if !seen[t.ThreatType] {
+			seen[t.ThreatType] = true
+			d.ThreatTypes = append(d.ThreatTypes, t.ThreatType)
+		}
+	}
+	return d, true
+}
+
+// clientAddr returns the IP address of the client that made req.
+func clientAddr(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// eventHub passes detections on to the clients that are tailing them, and to
+// the detection log, if any. Publishing never blocks, so a slow client cannot
+// hold up lookups.
+type eventHub struct {
+	tenant string          // Name of the tenant whose detections these are
+	log    *detectionLog   // Nil if detections are not logged
+	zone   *rpzZone        // Nil if no response policy zone is served
+	intel  *indicatorStore // Nil if detections are not exported as STIX
+
+	mu   sync.Mutex
+	subs map[chan detection]bool
+
+	done      chan struct{} // Closed when the server shuts down
+	closeOnce sync.Once
+}
+
+// newEventHub returns the hub of the detections of the named tenant, or of
+// the default client if tenant is empty, which are also recorded to log if it
+// is not nil.
+func newEventHub(tenant string, log *detectionLog) *eventHub {
+	return &eventHub{tenant: tenant, log: log, subs: make(map[chan detection]bool), done: make(chan struct{})}
+}
+
+// close ends the streams of all subscribers, so that they do not hold up a
+// graceful shutdown of the server.
+func (h *eventHub) close() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+// subscribe returns a channel that receives detections from now on, and a
+// function that must be called to stop receiving them.
+func (h *eventHub) subscribe() (<-chan detection, func()) {
+	ch := make(chan detection, eventBuffer)
+	h.mu.Lock()
+	h.subs[ch] = true
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+	}
+}
+
+// publish records d to the detection log, publishes it to the event streams,
+// and sends it to every subscriber that has room for it.
+func (h *eventHub) publish(d detection) {
+	d.Tenant = h.tenant
+	h.log.record(d)
+	streams.detection(d)
+	h.intel.record(d)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- d:
+		default:
+		}
+	}
+}
+
+// serveEvents streams detections as they happen, as a JSON object per line,
+// until the client goes away or the server shuts down.
+func serveEvents(resp http.ResponseWriter, req *http.Request, events *eventHub) {
+	if req.Method != "GET" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	flusher, ok := resp.(http.Flusher)
+	if !ok {
+		problemError(resp, "streaming not supported", http.StatusInternalServerError, codeInternal)
+		return
+	}
+	ch, unsubscribe := events.subscribe()
+	defer unsubscribe()
+	resp.Header().Set("Content-Type", mimeNDJSON)
+	resp.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	enc := json.NewEncoder(resp)
+	for {
+		select {
+		case d := <-ch:
+			if err := enc.Encode(d); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		case <-events.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,508 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/google/webrisk"
+)
+
+// extAuthzPath is the path prefix of the endpoint for the HTTP authorization
+// service of Envoy's ext_authz filter, which is sent the path of the
+// requests to check after it.
+const extAuthzPath = "/extauthz/"
+
+// threatTypesHeader lists the threat types of the URLs that are denied.
+const threatTypesHeader = "X-Webrisk-Threat-Types"
+
+// extAuthzURL returns the URL of the request that Envoy asks to authorize
+// with req: its host is the Host header, its scheme the X-Forwarded-Proto
+// header, if Envoy passes it on, and its path and query follow the path
+// prefix.
+func extAuthzURL(req *http.Request) *url.URL {
+	u := &url.URL{Scheme: "http", Host: req.Host, RawQuery: req.URL.RawQuery}
+	if proto := strings.ToLower(req.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+		u.Scheme = proto
+	}
+	u.Path = "/" + strings.TrimPrefix(req.URL.Path, extAuthzPath)
+	if req.URL.RawPath != "" {
+		u.RawPath = "/" + strings.TrimPrefix(req.URL.RawPath, extAuthzPath)
+	}
+	return u
+}
+
+// serveExtAuthz implements the HTTP authorization service of Envoy's
+// ext_authz filter. It allows the requests for safe URLs with a 200
+// response, and denies the others with the interstitial in a 403 response,
+// or with a redirect to the /r interstitial at the base URL redirect, if it
+// is not empty. Denials list the threat types of the URL.
+func serveExtAuthz(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient, events *eventHub, lat *latencies, fs http.FileSystem, redirect string) {
+	timer, ctx := lat.start("extauthz", req)
+	defer timer.done()
+	if req.Host == "" {
+		problemError(resp, "missing host", http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	u := extAuthzURL(req)
+	timer.url = u.String()
+	threats, sources, err := wr.LookupURLsSources(ctx, []string{u.String()})
+	if errors.Is(err, webrisk.ErrSchemeRejected) {
+		problemError(resp, err.Error(), http.StatusForbidden, codeSchemeRejected)
+		return
+	}
+	if err != nil {
+		timer.source = "error"
+		backendError(resp, err, u.String())
+		return
+	}
+	timer.source, timer.threats = sources[0].String(), threats[0]
+	if len(threats[0]) == 0 {
+		resp.WriteHeader(http.StatusOK)
+		return
+	}
+	d, _ := newDetection("extauthz", clientAddr(req), u.String(), threats[0], sources[0])
+	events.publish(d)
+	events.zone.addThreats(threats[0])
+
+	resp.Header().Set(threatTypesHeader, joinThreatTypes(d.ThreatTypes))
+	resp.Header().Set("Cache-Control", "no-store")
+	if redirect != "" {
+		http.Redirect(resp, req, strings.TrimSuffix(redirect, "/")+redirectPath+"?url="+url.QueryEscape(u.String()), http.StatusFound)
+		return
+	}
+	resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+	resp.WriteHeader(http.StatusForbidden)
+	writeInterstitial(resp, fs, threats[0], u, false)
+}
+
+// joinThreatTypes returns the names of tts, separated by commas.
+func joinThreatTypes(tts []webrisk.ThreatType) string {
+	names := make([]string, len(tts))
+	for i, tt := range tts {
+		names[i] = tt.String()
+	}
+	return strings.Join(names, ",")
+}
+
+const (
+	// extAuthzCheckPath is the path of the Check method of the gRPC
+	// authorization service of Envoy's ext_authz filter.
+	extAuthzCheckPath = "/envoy.service.auth.v3.Authorization/Check"
+
+	// tenantMetadata is the gRPC metadata that names the tenant whose
+	// client checks the requests, if any.
+	tenantMetadata = "X-Webrisk-Tenant"
+
+	// grpcMaxMessage is the size of the largest CheckRequest that is
+	// accepted.
+	grpcMaxMessage = 4 << 20
+)
+
+// The gRPC status codes used by the authorization service.
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcNotFound          = 5
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcUnavailable       = 14
+)
+
+var (
+	errGRPCCompressed = errors.New("compressed messages are not supported")
+	errGRPCTooLarge   = errors.New("message too large")
+)
+
+// extAuthzService checks the requests of a client for the gRPC
+// authorization service.
+type extAuthzService struct {
+	wr       *webrisk.UpdateClient
+	events   *eventHub
+	lat      *latencies
+	fs       http.FileSystem
+	redirect string
+}
+
+// extAuthzServer implements the gRPC authorization service of Envoy's
+// ext_authz filter, over HTTP/2. The requests are checked by the client of
+// the tenant named by the X-Webrisk-Tenant metadata, if any, and get the
+// same verdicts as from the HTTP authorization service.
+type extAuthzServer struct {
+	mu       sync.Mutex
+	services map[string]*extAuthzService // By tenant name
+}
+
+// extAuthzGRPC is the gRPC authorization service, or nil if it is not
+// served.
+var extAuthzGRPC *extAuthzServer
+
+func newExtAuthzServer() *extAuthzServer {
+	return &extAuthzServer{services: make(map[string]*extAuthzService)}
+}
+
+// handle checks the requests for tenant with wr. Denied requests are
+// redirected to the /r interstitial at the base URL redirect, if it is not
+// empty.
+func (s *extAuthzServer) handle(tenant string, wr *webrisk.UpdateClient, events *eventHub, lat *latencies, fs http.FileSystem, redirect string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.services[tenant] = &extAuthzService{wr: wr, events: events, lat: lat, fs: fs, redirect: redirect}
+}
+
+// ServeHTTP serves the Check method of the service.
+func (s *extAuthzServer) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "application/grpc" && !strings.HasPrefix(ct, "application/grpc+") {
+		problemError(resp, "not a gRPC request", http.StatusUnsupportedMediaType, codeInvalidFormat)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/grpc")
+	s.mu.Lock()
+	svc := s.services[req.Header.Get(tenantMetadata)]
+	s.mu.Unlock()
+	if svc == nil {
+		writeGRPCStatus(resp, grpcNotFound, "unknown tenant")
+		return
+	}
+	msg, err := readGRPCMessage(req.Body)
+	switch {
+	case errors.Is(err, errGRPCCompressed):
+		writeGRPCStatus(resp, grpcUnimplemented, err.Error())
+		return
+	case errors.Is(err, errGRPCTooLarge):
+		writeGRPCStatus(resp, grpcResourceExhausted, err.Error())
+		return
+	case err != nil:
+		writeGRPCStatus(resp, grpcInvalidArgument, err.Error())
+		return
+	}
+	cr, err := parseCheckRequest(msg)
+	if err != nil {
+		writeGRPCStatus(resp, grpcInvalidArgument, err.Error())
+		return
+	}
+
+	ctx := req.Context()
+	if d, ok := grpcTimeout(req.Header.Get("Grpc-Timeout")); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	check, err := cr.httpRequest(ctx, req.RemoteAddr)
+	if err != nil {
+		writeGRPCStatus(resp, grpcInvalidArgument, err.Error())
+		return
+	}
+
+	// The verdict is that of the HTTP authorization service, which the
+	// check would have been sent to otherwise.
+	rec := &extAuthzResponse{header: make(http.Header)}
+	rec.header.Set(webrisk.RequestIDHeader, resp.Header().Get(webrisk.RequestIDHeader))
+	serveExtAuthz(rec, check, svc.wr, svc.events, svc.lat, svc.fs, svc.redirect)
+	var out []byte
+	switch {
+	case rec.status == http.StatusOK:
+		out = appendCheckResponse(nil, grpcOK, nil)
+	case rec.status >= 500:
+		// Envoy lets the request through or not as configured by
+		// failure_mode_allow.
+		writeGRPCStatus(resp, grpcUnavailable, strings.TrimSpace(rec.body.String()))
+		return
+	default:
+		out = appendCheckResponse(nil, grpcPermissionDenied, rec)
+	}
+	resp.Header().Set("Trailer", "Grpc-Status")
+	var prefix [5]byte
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(out)))
+	resp.Write(prefix[:])
+	resp.Write(out)
+	resp.Header().Set("Grpc-Status", "0")
+}
+
+// writeGRPCStatus ends a gRPC response without a message, with the status
+// code and message in its headers.
+func writeGRPCStatus(resp http.ResponseWriter, code int, msg string) {
+	resp.Header().Set("Grpc-Status", strconv.Itoa(code))
+	resp.Header().Set("Grpc-Message", url.PathEscape(msg))
+	resp.WriteHeader(http.StatusOK)
+}
+
+// readGRPCMessage reads the single message of a unary gRPC request from r.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, errGRPCCompressed
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > grpcMaxMessage {
+		return nil, errGRPCTooLarge
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// grpcTimeout returns the duration of the grpc-timeout header value v, such
+// as 250m for 250 milliseconds, and whether it is valid.
+func grpcTimeout(v string) (time.Duration, bool) {
+	units := map[byte]time.Duration{
+		'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+		'm': time.Millisecond, 'u': time.Microsecond, 'n': time.Nanosecond,
+	}
+	if len(v) < 2 || len(v) > 9 {
+		return 0, false
+	}
+	unit, ok := units[v[len(v)-1]]
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if !ok || err != nil || n < 0 || n > math.MaxInt64/int64(unit) {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+// checkRequest holds the fields of an envoy.service.auth.v3.CheckRequest
+// that the request to check is looked up by.
+type checkRequest struct {
+	source  string            // attributes.source.address.socket_address.address
+	method  string            // attributes.request.http.method
+	headers map[string]string // attributes.request.http.headers
+	path    string            // attributes.request.http.path, with the query
+	host    string            // attributes.request.http.host
+	scheme  string            // attributes.request.http.scheme
+}
+
+// parseCheckRequest decodes the protobuf encoding of a CheckRequest.
+func parseCheckRequest(b []byte) (*checkRequest, error) {
+	source, err := fieldAt(b, 1, 1, 1, 1, 2)
+	if err != nil {
+		return nil, err
+	}
+	h, err := fieldAt(b, 1, 4, 2)
+	if err != nil {
+		return nil, err
+	}
+	cr := &checkRequest{source: string(source), headers: make(map[string]string)}
+	err = forEachField(h, func(num protowire.Number, v []byte) error {
+		switch num {
+		case 2:
+			cr.method = string(v)
+		case 3:
+			key, err := fieldAt(v, 1)
+			if err != nil {
+				return err
+			}
+			value, err := fieldAt(v, 2)
+			if err != nil {
+				return err
+			}
+			cr.headers[string(key)] = string(value)
+		case 4:
+			cr.path = string(v)
+		case 5:
+			cr.host = string(v)
+		case 6:
+			cr.scheme = string(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// httpRequest returns the request that the HTTP authorization service would
+// be sent for cr. Its client is at remoteAddr, unless cr tells otherwise.
+func (cr *checkRequest) httpRequest(ctx context.Context, remoteAddr string) (*http.Request, error) {
+	if cr.path == "" {
+		return nil, errors.New("missing path")
+	}
+	u, err := url.ParseRequestURI(cr.path)
+	if err != nil {
+		return nil, err
+	}
+	host := cr.host
+	if host == "" {
+		host = u.Host
+	}
+	req := &http.Request{
+		Method:     cr.method,
+		URL:        &url.URL{Path: extAuthzPath + strings.TrimPrefix(u.Path, "/"), RawQuery: u.RawQuery},
+		Host:       host,
+		Header:     make(http.Header),
+		RemoteAddr: remoteAddr,
+	}
+	if u.RawPath != "" {
+		req.URL.RawPath = extAuthzPath + strings.TrimPrefix(u.RawPath, "/")
+	}
+	if req.Method == "" {
+		req.Method = http.MethodGet
+	}
+	if cr.source != "" {
+		req.RemoteAddr = cr.source
+	}
+	for k, v := range cr.headers {
+		if !strings.HasPrefix(k, ":") {
+			req.Header.Set(k, v)
+		}
+	}
+	if cr.scheme != "" {
+		req.Header.Set("X-Forwarded-Proto", cr.scheme)
+	}
+	return req.WithContext(ctx), nil
+}
+
+// extAuthzResponse records the response of the HTTP authorization service,
+// for the gRPC one.
+type extAuthzResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *extAuthzResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *extAuthzResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *extAuthzResponse) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(b)
+}
+
+// appendCheckResponse appends the protobuf encoding of an
+// envoy.service.auth.v3.CheckResponse with the gRPC status code to b. Its
+// HTTP response is an OkHttpResponse if denied is nil, and a
+// DeniedHttpResponse with the status, headers and body of denied otherwise.
+func appendCheckResponse(b []byte, code int, denied *extAuthzResponse) []byte {
+	var status []byte
+	if code != grpcOK {
+		status = protowire.AppendTag(status, 1, protowire.VarintType)
+		status = protowire.AppendVarint(status, uint64(code))
+	}
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, status)
+	if denied == nil {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		return protowire.AppendBytes(b, nil)
+	}
+
+	var httpStatus, msg []byte
+	httpStatus = protowire.AppendTag(httpStatus, 1, protowire.VarintType)
+	httpStatus = protowire.AppendVarint(httpStatus, uint64(denied.status))
+	msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+	msg = protowire.AppendBytes(msg, httpStatus)
+	keys := make([]string, 0, len(denied.header))
+	for k := range denied.header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range denied.header[k] {
+			var hv, hvo []byte
+			hv = protowire.AppendTag(hv, 1, protowire.BytesType)
+			hv = protowire.AppendString(hv, strings.ToLower(k))
+			hv = protowire.AppendTag(hv, 2, protowire.BytesType)
+			hv = protowire.AppendString(hv, v)
+			hvo = protowire.AppendTag(hvo, 1, protowire.BytesType)
+			hvo = protowire.AppendBytes(hvo, hv)
+			msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+			msg = protowire.AppendBytes(msg, hvo)
+		}
+	}
+	if denied.body.Len() > 0 {
+		msg = protowire.AppendTag(msg, 3, protowire.BytesType)
+		msg = protowire.AppendBytes(msg, denied.body.Bytes())
+	}
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// forEachField calls f with the number and contents of every
+// length-delimited field of the protobuf message b, such as strings and
+// messages, and skips the others.
+func forEachField(b []byte, f func(num protowire.Number, v []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := f(num, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldAt returns the contents of the length-delimited field of b at path,
+// one field number per level of nested messages, or nil if it is missing.
+// The last occurrence of a field wins.
+func fieldAt(b []byte, path ...protowire.Number) ([]byte, error) {
+	for _, want := range path {
+		var found []byte
+		err := forEachField(b, func(num protowire.Number, v []byte) error {
+			if num == want {
+				found = v
+			}
+			return nil
+		})
+		if err != nil || found == nil {
+			return nil, err
+		}
+		b = found
+	}
+	return b, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+// Environment variables that tell a wrserver process started by a handoff
+// which of its file descriptors are the listeners inherited from the previous
+// process, and the pipe to tell it when to drain.
+const (
+	listenerEnv     = "WRSERVER_LISTENER_FD"
+	icapListenerEnv = "WRSERVER_ICAP_LISTENER_FD"
+	readyEnv        = "WRSERVER_READY_FD"
+)
+
+// handoffTimeout bounds how long the previous process of a handoff waits for
+// the new one to load its databases before giving up.
+const handoffTimeout = 10 * time.Minute
+
+// listen returns the listener inherited from the previous process of a
+// handoff, if any, or a new one on addr. inherited reports which.
+func listen(addr string) (ln net.Listener, inherited bool, err error) {
+	return listenFrom(listenerEnv, addr)
+}
+
+// listenFrom is like listen, for the listener whose file descriptor is given
+// by the env environment variable.
+func listenFrom(env, addr string) (ln net.Listener, inherited bool, err error) {
+	fd := os.Getenv(env)
+	if fd == "" {
+		ln, err = net.Listen("tcp", addr)
+		return ln, false, err
+	}
+	os.Unsetenv(env)
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid %s: %q", env, fd)
+	}
+	f := os.NewFile(uintptr(n), "listener")
+	defer f.Close()
+	ln, err = net.FileListener(f)
+	return ln, true, err
+}
+
+// waitReady waits until the databases of the clients are loaded, so that a
+// process started by a handoff can take over the lookups.
+func waitReady(clients map[string]*webrisk.UpdateClient) error {
+	ctx, cancel := context.WithTimeout(context.Background(), handoffTimeout)
+	defer cancel()
+	for name, c := range clients {
+		if c == nil {
+			continue
+		}
+		if err := c.WaitUntilReady(ctx); err != nil {
+			if name != "" {
+				err = fmt.Errorf("tenant %s: %v", name, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyReady tells the previous process of a handoff, if any, that this
+// one serves the lookups, so that it can drain.
+func notifyReady() error {
+	fd := os.Getenv(readyEnv)
+	if fd == "" {
+		return nil
+	}
+	os.Unsetenv(readyEnv)
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %q", readyEnv, fd)
+	}
+	f := os.NewFile(uintptr(n), "ready")
+	defer f.Close()
+	_, err = f.Write([]byte("ready\n"))
+	return err
+}
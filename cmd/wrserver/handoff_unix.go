@@ -0,0 +1,177 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+// handleHandoffs makes the server hand ln, and icapLn if not nil, over to a
+// new wrserver process on SIGUSR2, to restart it without dropping lookups,
+// such as after an upgrade of the binary. The clients release their databases and save their
+// caches, and the new process, started with the same arguments, loads them
+// while this one keeps serving lookups. Once it is ready, it starts
+// accepting connections on the same listener, and this one drains by
+// receiving a SIGTERM on exit.
+func handleHandoffs(ln, icapLn net.Listener, clients map[string]*webrisk.UpdateClient, exit chan<- os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR2)
+	go func() {
+		for range ch {
+			if err := handoff(ln, icapLn, clients); err != nil {
+				log.Printf("Handoff failed: %v", err)
+				continue
+			}
+			exit <- syscall.SIGTERM
+			return
+		}
+	}()
+}
+
+// handoffCommand returns the command that starts the new process of a
+// handoff: this binary, with the same arguments.
+var handoffCommand = func() (*exec.Cmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	return exec.Command(exe, os.Args[1:]...), nil
+}
+
+// handoff starts a new process with ln and icapLn, if not nil, and waits
+// until it is ready. If the handoff fails, the clients take their databases
+// back and this process carries on as before.
+func handoff(ln, icapLn net.Listener, clients map[string]*webrisk.UpdateClient) (err error) {
+	lf, err := listenerFile(ln)
+	if err != nil {
+		return err
+	}
+	defer lf.Close()
+	var icapFile *os.File
+	if icapLn != nil {
+		if icapFile, err = listenerFile(icapLn); err != nil {
+			return err
+		}
+		defer icapFile.Close()
+	}
+	cmd, err := handoffCommand()
+	if err != nil {
+		return err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	defer w.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), handoffTimeout)
+	defer cancel()
+	var released []string
+	defer func() {
+		if err != nil {
+			err = resume(clients, released, err)
+		}
+	}()
+	for name, c := range clients {
+		if c == nil {
+			continue
+		}
+		if err := c.Release(ctx); err != nil {
+			if name != "" {
+				err = fmt.Errorf("tenant %s: %v", name, err)
+			}
+			return err
+		}
+		released = append(released, name)
+	}
+
+	// The inherited files are numbered from 3 on.
+	cmd.Env = append(os.Environ(), listenerEnv+"=3", readyEnv+"=4")
+	cmd.ExtraFiles = []*os.File{lf, w}
+	if icapFile != nil {
+		cmd.Env = append(cmd.Env, icapListenerEnv+"=5")
+		cmd.ExtraFiles = append(cmd.ExtraFiles, icapFile)
+	}
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+	log.Printf("Handing off to process %d", cmd.Process.Pid)
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	// The pipe is closed without a word if the process exits before it is
+	// ready. A process that is not ready in time is killed, so that its
+	// lock on the databases is released.
+	r.SetReadDeadline(time.Now().Add(handoffTimeout))
+	if _, err := r.Read(make([]byte, 1)); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("process exited before it was ready")
+		}
+		cmd.Process.Kill()
+		<-exited
+		return err
+	}
+	log.Printf("Process %d took over, draining", cmd.Process.Pid)
+	return nil
+}
+
+// resume makes the named clients take back the databases that they released
+// for a handoff that failed with err, and returns err along with the names
+// of the clients that could not.
+func resume(clients map[string]*webrisk.UpdateClient, names []string, err error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), handoffTimeout)
+	defer cancel()
+	for _, name := range names {
+		if rerr := clients[name].Resume(ctx); rerr != nil {
+			if name == "" {
+				name = "the default client"
+			} else {
+				name = "tenant " + name
+			}
+			err = fmt.Errorf("%v; the database of %s is no longer updated until wrserver is restarted: %v", err, name, rerr)
+		}
+	}
+	return err
+}
+
+// listenerFile returns a duplicate of the file of ln, to pass it on.
+func listenerFile(ln net.Listener) (*os.File, error) {
+	fl, ok := ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("listener %T cannot be passed on", ln)
+	}
+	return fl.File()
+}
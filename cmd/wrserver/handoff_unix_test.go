@@ -0,0 +1,156 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/google/webrisk"
+)
+
+// dupFd returns a duplicate of the file descriptor of f, as would be
+// inherited by a process started by a handoff.
+func dupFd(t *testing.T, f *os.File) string {
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return fmt.Sprint(fd)
+}
+
+func TestHandoffListener(t *testing.T) {
+	ln, inherited, err := listen("127.0.0.1:0")
+	if err != nil || inherited {
+		t.Fatalf("listen = (%v, %v), want a new listener", inherited, err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer f.Close()
+
+	// The listener of the previous process is used instead of a new one.
+	os.Setenv(listenerEnv, dupFd(t, f))
+	ln2, inherited, err := listen("127.0.0.1:0")
+	if err != nil || !inherited {
+		t.Fatalf("listen = (%v, %v), want the inherited listener", inherited, err)
+	}
+	defer ln2.Close()
+	if ln2.Addr().String() != ln.Addr().String() {
+		t.Errorf("inherited listener on %v, want %v", ln2.Addr(), ln.Addr())
+	}
+	if os.Getenv(listenerEnv) != "" {
+		t.Errorf("%s is still set", listenerEnv)
+	}
+
+	os.Setenv(listenerEnv, "x")
+	if _, _, err := listen("127.0.0.1:0"); err == nil {
+		t.Errorf("listen with an invalid %s: unexpected success", listenerEnv)
+	}
+}
+
+func TestHandoffReady(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Close()
+	os.Setenv(readyEnv, dupFd(t, w))
+	w.Close()
+	if err := notifyReady(); err != nil {
+		t.Fatalf("notifyReady = %v", err)
+	}
+	if line, err := bufio.NewReader(r).ReadString('\n'); err != nil || line != "ready\n" {
+		t.Errorf("previous process read (%q, %v), want ready", line, err)
+	}
+	if err := notifyReady(); err != nil {
+		t.Errorf("notifyReady without a previous process = %v", err)
+	}
+}
+
+func TestHandoffFailure(t *testing.T) {
+	api := newFakeAPI()
+	defer api.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+	dbPath := filepath.Join(t.TempDir(), "db")
+	wr, err := webrisk.NewUpdateClient(webrisk.Config{
+		APIKey:    "key",
+		ServerURL: api.URL,
+		DBPath:    dbPath,
+		Logger:    io.Discard,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+	if err := wr.WaitUntilReady(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	closed := newFakeClient(t, api)
+	closed.Close()
+	defer func(c func() (*exec.Cmd, error)) { handoffCommand = c }(handoffCommand)
+
+	for _, tc := range []struct {
+		name    string
+		command []string
+		clients map[string]*webrisk.UpdateClient
+	}{
+		{"exits", []string{"sh", "-c", "exit 1"}, map[string]*webrisk.UpdateClient{"": wr}},
+		{"cannot start", []string{filepath.Join(t.TempDir(), "missing")}, map[string]*webrisk.UpdateClient{"": wr}},
+		{"release fails", []string{"sh", "-c", "exit 1"}, map[string]*webrisk.UpdateClient{"": wr, "closed": closed}},
+	} {
+		handoffCommand = func() (*exec.Cmd, error) {
+			return exec.Command(tc.command[0], tc.command[1:]...), nil
+		}
+		if err := handoff(ln, nil, tc.clients); err == nil {
+			t.Errorf("%s: handoff = nil, want an error", tc.name)
+			continue
+		}
+
+		// The database is taken back and updated again.
+		if err := wr.UpdateDatabase(context.Background()); err != nil {
+			t.Errorf("%s: UpdateDatabase = %v", tc.name, err)
+		}
+		wr2, err := webrisk.NewUpdateClient(webrisk.Config{
+			APIKey:    "key",
+			ServerURL: api.URL,
+			DBPath:    dbPath,
+			Logger:    io.Discard,
+		})
+		if err == nil {
+			wr2.Close()
+		}
+		if !errors.Is(err, webrisk.ErrStoreLocked) {
+			t.Errorf("%s: NewUpdateClient = %v, want %v", tc.name, err, webrisk.ErrStoreLocked)
+		}
+	}
+}
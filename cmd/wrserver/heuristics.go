@@ -0,0 +1,136 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+	"golang.org/x/text/unicode/norm"
+)
+
+// suspiciousTemplate is the template of the interstitial shown for URLs that
+// the heuristics find suspicious, but that Web Risk has no match for.
+const suspiciousTemplate = "/suspicious.tmpl"
+
+// heuristics are checks made by the redirector for URLs that look deceptive,
+// even though Web Risk has no match for them.
+type heuristics struct {
+	// userinfo flags URLs with a user name, such as
+	// http://paypal.com@evil.example/, which hides the actual host.
+	userinfo bool
+
+	// brands are the registrable domains of protected brands, such as
+	// "paypal.com". Hosts outside of them with a label that looks like
+	// the brand are flagged.
+	brands []string
+
+	// maxSubdomains, if positive, is the number of labels that a host may
+	// have in front of its registrable domain before it is flagged.
+	maxSubdomains int
+}
+
+// confusables maps characters to the ASCII letters they are commonly
+// mistaken for.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'е': 'e', 'о': 'o', 'р': 'p', 'с': 'c', 'у': 'y', 'х': 'x',
+	'і': 'i', 'ј': 'j', 'ѕ': 's', 'ԁ': 'd', 'һ': 'h', 'ӏ': 'l', 'ԛ': 'q',
+	'ԝ': 'w', 'к': 'k', 'м': 'm', 'т': 't', 'в': 'b', 'н': 'h',
+	// Greek
+	'α': 'a', 'ο': 'o', 'ν': 'v', 'ι': 'i', 'κ': 'k', 'ρ': 'p', 'τ': 't',
+	'υ': 'u', 'χ': 'x', 'ε': 'e',
+	// Latin
+	'ı': 'i', 'ł': 'l', 'ɡ': 'g', 'ø': 'o', 'đ': 'd',
+	// Digits
+	'0': 'o', '1': 'l', '3': 'e', '5': 's',
+}
+
+// confusableSequences are sequences of ASCII letters that are commonly
+// mistaken for a single one.
+var confusableSequences = strings.NewReplacer("rn", "m", "vv", "w", "cl", "d")
+
+// skeleton reduces a hostname label to the ASCII letters it looks like, so
+// that lookalikes of a label have the same skeleton as the label itself.
+func skeleton(label string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(strings.ToLower(label)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue // Drop accents.
+		}
+		if c, ok := confusables[r]; ok {
+			r = c
+		}
+		b.WriteRune(r)
+	}
+	return confusableSequences.Replace(b.String())
+}
+
+// check returns why u looks deceptive, or "" if it does not.
+func (h *heuristics) check(u *url.URL) string {
+	if h == nil {
+		return ""
+	}
+	if h.userinfo && u.User != nil {
+		return "The address has a user name in front of the site, which can make it look like a different site."
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if uhost, err := idna.ToUnicode(host); err == nil {
+		host = uhost
+	}
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		return "" // Such as an IP address.
+	}
+	for _, brand := range h.brands {
+		if host == brand || strings.HasSuffix(host, "."+brand) {
+			continue
+		}
+		name := skeleton(strings.SplitN(brand, ".", 2)[0])
+		for _, label := range strings.Split(host, ".") {
+			for _, token := range strings.Split(label, "-") {
+				if skeleton(token) == name {
+					return fmt.Sprintf("The site looks like %s, but does not belong to it.", brand)
+				}
+			}
+		}
+	}
+	if depth := strings.Count(host, ".") - strings.Count(domain, "."); h.maxSubdomains > 0 && depth > h.maxSubdomains {
+		return fmt.Sprintf("The site is nested %d levels deep in %s, which can make it look like a different site.", depth, domain)
+	}
+	return ""
+}
+
+// newHeuristics returns the heuristics enabled by the flags, or nil if none
+// are.
+func newHeuristics(userinfo bool, brands string, maxSubdomains int) (*heuristics, error) {
+	h := &heuristics{userinfo: userinfo, maxSubdomains: maxSubdomains}
+	for _, b := range strings.Split(brands, ",") {
+		if b = strings.ToLower(strings.TrimSpace(b)); b == "" {
+			continue
+		}
+		if d, err := publicsuffix.EffectiveTLDPlusOne(b); err != nil || d != b {
+			return nil, fmt.Errorf("invalid brand domain: %q", b)
+		}
+		h.brands = append(h.brands, b)
+	}
+	if !h.userinfo && len(h.brands) == 0 && h.maxSubdomains <= 0 {
+		return nil, nil
+	}
+	return h, nil
+}
@@ -0,0 +1,404 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/webrisk"
+)
+
+const (
+	// icapPath is the path of the REQMOD service of a client, under the
+	// path prefix of its tenant, if any.
+	icapPath = "/reqmod"
+
+	// icapISTag identifies the service, so that ICAP clients know when the
+	// responses that they cached are outdated. Verdicts are never cached
+	// by ICAP clients, so it does not change.
+	icapISTag = `"wrserver"`
+
+	// icapMaxHeader is the size of the largest encapsulated HTTP header that
+	// is accepted, and icapMaxBody that of the largest body.
+	icapMaxHeader = 64 << 10
+	icapMaxBody   = 16 << 20
+)
+
+// errICAPTooLarge is returned for requests that encapsulate too much.
+var errICAPTooLarge = errors.New("encapsulated message too large")
+
+// icapService checks the requests of a client of the ICAP server.
+type icapService struct {
+	wr     *webrisk.UpdateClient
+	events *eventHub
+	lat    *latencies
+	fs     http.FileSystem
+}
+
+// icapServer is an ICAP server (RFC 3507) with a REQMOD service per client,
+// which looks up the URLs of the HTTP requests that proxies and mail
+// gateways pass on to it. Safe requests are left as they are, and unsafe
+// ones are answered with the interstitial instead, as a 403 response.
+type icapServer struct {
+	mu       sync.Mutex
+	services map[string]*icapService // By path
+	ln       net.Listener
+	conns    map[net.Conn]bool
+	closed   bool
+}
+
+// icap is the ICAP server, or nil if there is none.
+var icap *icapServer
+
+func newICAPServer() *icapServer {
+	return &icapServer{services: make(map[string]*icapService), conns: make(map[net.Conn]bool)}
+}
+
+// handle serves the REQMOD service of wr at path.
+func (s *icapServer) handle(path string, wr *webrisk.UpdateClient, events *eventHub, lat *latencies, fs http.FileSystem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.services[path] = &icapService{wr: wr, events: events, lat: lat, fs: fs}
+}
+
+// serve accepts ICAP connections on ln until the server is closed.
+func (s *icapServer) serve(ln net.Listener) error {
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go func() {
+			s.serveConn(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+	}
+}
+
+// close stops accepting connections, and closes the open ones.
+func (s *icapServer) close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.ln != nil {
+		s.ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// serveConn answers the requests of a connection until the client closes it
+// or a request cannot be parsed.
+func (s *icapServer) serveConn(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	tp := textproto.NewReader(r)
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		if line == "" {
+			continue // Stray line between requests
+		}
+		keep := s.serveRequest(conn, line, tp, w)
+		if err := w.Flush(); err != nil || !keep {
+			return
+		}
+	}
+}
+
+// serveRequest answers the request whose request line is line, and reports
+// whether the connection can be used for further requests.
+func (s *icapServer) serveRequest(conn net.Conn, line string, tp *textproto.Reader, w *bufio.Writer) bool {
+	method, uri, ok := parseICAPRequestLine(line)
+	if !ok {
+		writeICAPStatus(w, "400 Bad Request")
+		return false
+	}
+	hdr, err := tp.ReadMIMEHeader()
+	if err != nil {
+		writeICAPStatus(w, "400 Bad Request")
+		return false
+	}
+	s.mu.Lock()
+	svc := s.services[uri.Path]
+	s.mu.Unlock()
+
+	switch method {
+	case "OPTIONS":
+		if svc == nil {
+			writeICAPStatus(w, "404 ICAP Service Not Found")
+			return true
+		}
+		fmt.Fprintf(w, "ICAP/1.0 200 OK\r\nMethods: REQMOD\r\nService: wrserver %s\r\nISTag: %s\r\n", webrisk.Version(), icapISTag)
+		w.WriteString("Allow: 204\r\nPreview: 0\r\nTransfer-Preview: *\r\nOptions-TTL: 3600\r\nEncapsulated: null-body=0\r\n\r\n")
+		return true
+	case "REQMOD":
+	default:
+		writeICAPStatus(w, "405 Method Not Allowed")
+		return hdr.Get("Encapsulated") == ""
+	}
+
+	// The encapsulated request must be read, even if it is not checked, so
+	// that the connection can be used for the next request.
+	httpHdr, body, err := readICAPRequest(tp.R, hdr.Get("Encapsulated"))
+	if err != nil {
+		if errors.Is(err, errICAPTooLarge) {
+			writeICAPStatus(w, "413 Request Entity Too Large")
+		} else {
+			writeICAPStatus(w, "400 Bad Request")
+		}
+		return false
+	}
+	if svc == nil {
+		writeICAPStatus(w, "404 ICAP Service Not Found")
+		return true
+	}
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(httpHdr)))
+	if err != nil {
+		writeICAPStatus(w, "400 Bad Request")
+		return true
+	}
+	// The client of the HTTP request is given by Squid and other proxies
+	// that are configured to, and the proxy itself otherwise.
+	req.RemoteAddr = conn.RemoteAddr().String()
+	if ip := hdr.Get("X-Client-IP"); net.ParseIP(ip) != nil {
+		req.RemoteAddr = net.JoinHostPort(ip, "0")
+	}
+
+	page, err := svc.check(req)
+	if err != nil {
+		writeICAPStatus(w, "500 Server Error")
+		return true
+	}
+	if page != nil {
+		writeICAPBlock(w, page)
+		return true
+	}
+	_, preview := hdr["Preview"]
+	if preview || allows204(hdr) {
+		writeICAPStatus(w, "204 No Content")
+		return true
+	}
+	// The request is sent back as it is.
+	if body == nil {
+		fmt.Fprintf(w, "ICAP/1.0 200 OK\r\nISTag: %s\r\nEncapsulated: req-hdr=0, null-body=%d\r\n\r\n", icapISTag, len(httpHdr))
+		w.Write(httpHdr)
+		return true
+	}
+	fmt.Fprintf(w, "ICAP/1.0 200 OK\r\nISTag: %s\r\nEncapsulated: req-hdr=0, req-body=%d\r\n\r\n", icapISTag, len(httpHdr))
+	w.Write(httpHdr)
+	writeChunked(w, body)
+	return true
+}
+
+// check looks up the URL of req, and returns the interstitial to answer it
+// with, or nil if it is safe. URLs whose scheme is rejected are answered
+// with the reason.
+func (svc *icapService) check(req *http.Request) ([]byte, error) {
+	timer, ctx := svc.lat.start("icap", req)
+	defer timer.done()
+	u := icapRequestURL(req)
+	timer.url = u.String()
+	threats, sources, err := svc.wr.LookupURLsSources(ctx, []string{u.String()})
+	if errors.Is(err, webrisk.ErrSchemeRejected) {
+		return []byte(html.EscapeString(err.Error()) + "\n"), nil
+	}
+	if err != nil {
+		timer.source = "error"
+		return nil, err
+	}
+	timer.source, timer.threats = sources[0].String(), threats[0]
+	if len(threats[0]) == 0 {
+		return nil, nil
+	}
+	if d, ok := newDetection("icap", clientAddr(req), u.String(), threats[0], sources[0]); ok {
+		svc.events.publish(d)
+	}
+	svc.events.zone.addThreats(threats[0])
+	var page bytes.Buffer
+	if err := writeInterstitial(&page, svc.fs, threats[0], u, false); err != nil {
+		return nil, err
+	}
+	if page.Len() == 0 {
+		page.WriteString("This site has been blocked by Web Risk.\n")
+	}
+	return page.Bytes(), nil
+}
+
+// icapRequestURL returns the URL that an encapsulated request is for. The
+// host:port of a CONNECT request is taken as an https URL.
+func icapRequestURL(req *http.Request) *url.URL {
+	if req.Method == "CONNECT" {
+		return &url.URL{Scheme: "https", Host: req.Host, Path: "/"}
+	}
+	u := *req.URL
+	if u.Host == "" {
+		u.Scheme, u.Host = "http", req.Host
+	}
+	return &u
+}
+
+// parseICAPRequestLine returns the method and URI of an ICAP request line.
+func parseICAPRequestLine(line string) (string, *url.URL, bool) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 || fields[2] != "ICAP/1.0" {
+		return "", nil, false
+	}
+	u, err := url.Parse(fields[1])
+	if err != nil || u.Scheme != "icap" {
+		return "", nil, false
+	}
+	return fields[0], u, true
+}
+
+// allows204 reports whether the client allows a 204 response outside of a
+// preview.
+func allows204(hdr textproto.MIMEHeader) bool {
+	for _, v := range hdr["Allow"] {
+		for _, a := range strings.Split(v, ",") {
+			if strings.TrimSpace(a) == "204" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readICAPRequest reads the HTTP request encapsulated as described by the
+// Encapsulated header enc, and returns its header and its body, or nil if
+// it has none. Of a preview, only the previewed part of the body is read.
+func readICAPRequest(r *bufio.Reader, enc string) (hdr, body []byte, err error) {
+	hdrLen, hasBody := -1, false
+	for _, part := range strings.Split(enc, ",") {
+		name, offset, ok := strings.Cut(strings.TrimSpace(part), "=")
+		n, err := strconv.Atoi(offset)
+		if !ok || err != nil || n < 0 {
+			return nil, nil, fmt.Errorf("invalid Encapsulated header: %q", enc)
+		}
+		switch name {
+		case "req-hdr":
+			if n != 0 {
+				return nil, nil, fmt.Errorf("invalid Encapsulated header: %q", enc)
+			}
+		case "req-body", "null-body":
+			hdrLen, hasBody = n, name == "req-body"
+		default:
+			return nil, nil, fmt.Errorf("unexpected %s in Encapsulated header", name)
+		}
+	}
+	if hdrLen < 0 {
+		return nil, nil, fmt.Errorf("invalid Encapsulated header: %q", enc)
+	}
+	if hdrLen > icapMaxHeader {
+		return nil, nil, errICAPTooLarge
+	}
+	hdr = make([]byte, hdrLen)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, nil, err
+	}
+	if !hasBody {
+		return hdr, nil, nil
+	}
+	body = []byte{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, nil, err
+		}
+		size, _, _ := strings.Cut(strings.TrimSpace(line), ";") // Such as 0; ieof
+		n, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+		if err != nil || n < 0 {
+			return nil, nil, fmt.Errorf("invalid chunk size: %q", line)
+		}
+		if int64(len(body))+n > icapMaxBody {
+			return nil, nil, errICAPTooLarge
+		}
+		if n == 0 {
+			// The last chunk is followed by an empty line.
+			_, err := r.ReadString('\n')
+			return hdr, body, err
+		}
+		chunk := make([]byte, n+2)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, nil, err
+		}
+		body = append(body, chunk[:n]...)
+	}
+}
+
+// writeICAPStatus writes a response without an encapsulated message.
+func writeICAPStatus(w io.Writer, status string) {
+	fmt.Fprintf(w, "ICAP/1.0 %s\r\nISTag: %s\r\nEncapsulated: null-body=0\r\n\r\n", status, icapISTag)
+}
+
+// writeICAPBlock writes a response that answers the HTTP request with page,
+// the interstitial, instead of passing it on.
+func writeICAPBlock(w *bufio.Writer, page []byte) {
+	res := fmt.Sprintf("HTTP/1.1 403 Forbidden\r\nContent-Type: text/html; charset=utf-8\r\nContent-Length: %d\r\nCache-Control: no-store\r\n\r\n", len(page))
+	fmt.Fprintf(w, "ICAP/1.0 200 OK\r\nISTag: %s\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n", icapISTag, len(res))
+	w.WriteString(res)
+	writeChunked(w, page)
+}
+
+// writeChunked writes b as a chunked body.
+func writeChunked(w *bufio.Writer, b []byte) {
+	if len(b) > 0 {
+		fmt.Fprintf(w, "%x\r\n", len(b))
+		w.Write(b)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("0\r\n\r\n")
+}
@@ -0,0 +1,167 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the buckets of the
+// request latency histograms. They range from a lookup answered by the
+// database alone to one that waited for the API to time out.
+var latencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// slowRequestThreshold is the latency above which lookup requests are
+// logged, with what the lookup did. Zero disables logging.
+var slowRequestThreshold time.Duration
+
+// latencyKey identifies a histogram by the endpoint that served the request
+// and the source of its verdict.
+type latencyKey struct {
+	endpoint, source string
+}
+
+// histogram counts latencies in latencyBuckets.
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative, plus one for +Inf
+	sum    float64
+}
+
+// latencies holds the latency histograms of the lookup requests served for
+// a client.
+type latencies struct {
+	tenant string // Name of the tenant, for logging
+
+	mu sync.Mutex
+	h  map[latencyKey]*histogram
+}
+
+func newLatencies(tenant string) *latencies {
+	return &latencies{tenant: tenant, h: make(map[latencyKey]*histogram)}
+}
+
+// observe adds a request of the endpoint whose verdict came from source,
+// which took d.
+func (l *latencies) observe(endpoint, source string, d time.Duration) {
+	secs := d.Seconds()
+	i := sort.SearchFloat64s(latencyBuckets, secs)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := latencyKey{endpoint, source}
+	h, ok := l.h[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+		l.h[key] = h
+	}
+	h.counts[i]++
+	h.sum += secs
+}
+
+// reset removes all observations.
+func (l *latencies) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.h = make(map[latencyKey]*histogram)
+}
+
+// write writes the histograms to w in the Prometheus text format.
+func (l *latencies) write(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.h) == 0 {
+		return
+	}
+	keys := make([]latencyKey, 0, len(l.h))
+	for k := range l.h {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].endpoint != keys[j].endpoint {
+			return keys[i].endpoint < keys[j].endpoint
+		}
+		return keys[i].source < keys[j].source
+	})
+	const name = "webrisk_request_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Latency of lookup requests, by endpoint and verdict source.\n# TYPE %s histogram\n", name, name)
+	for _, k := range keys {
+		h := l.h[k]
+		labels := fmt.Sprintf("endpoint=%q,source=%q", k.endpoint, k.source)
+		var n uint64
+		for i, c := range h.counts {
+			n += c
+			le := "+Inf"
+			if i < len(latencyBuckets) {
+				le = strconv.FormatFloat(latencyBuckets[i], 'g', -1, 64)
+			}
+			fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", name, labels, le, n)
+		}
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, h.sum)
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, n)
+	}
+}
+
+// requestTimer measures a lookup request, logs it if it is slow, and samples
+// it into the query log.
+type requestTimer struct {
+	lat      *latencies
+	endpoint string
+	req      *http.Request
+	start    time.Time
+	trace    webrisk.LookupTrace
+
+	// source is the source of the verdict, or "error" if the lookup failed.
+	// Requests that do not get to a lookup, such as invalid ones, are not
+	// measured.
+	source string
+
+	url     string // URL looked up, for the query log
+	threats []webrisk.URLThreat
+}
+
+// start starts measuring a request of the endpoint. The lookup must be made
+// with the returned context, so that what it did is known if it is slow.
+func (l *latencies) start(endpoint string, req *http.Request) (*requestTimer, context.Context) {
+	t := &requestTimer{lat: l, endpoint: endpoint, req: req, start: time.Now()}
+	return t, webrisk.WithLookupTrace(req.Context(), &t.trace)
+}
+
+// done records the latency of the request.
+func (t *requestTimer) done() {
+	if t.source == "" {
+		return
+	}
+	d := time.Since(t.start)
+	t.lat.observe(t.endpoint, t.source, d)
+	queries.record(t.lat.tenant, t.endpoint, t.url, t.threats, t.source)
+	if slowRequestThreshold <= 0 || d < slowRequestThreshold {
+		return
+	}
+	tenant := ""
+	if t.lat.tenant != "" {
+		tenant = " for tenant " + t.lat.tenant
+	}
+	log.Printf("Slow %s request%s from %s took %v: requestID=%s source=%s hashes=%d databaseMatches=%d cacheHits=%d cacheMisses=%d apiCalls=%d apiTime=%v unconfirmed=%d",
+		t.endpoint, tenant, clientAddr(t.req), d.Round(time.Microsecond), webrisk.RequestIDFromContext(t.req.Context()), t.source, t.trace.Hashes,
+		t.trace.DatabaseMatches, t.trace.CacheHits, t.trace.CacheMisses, t.trace.APICalls, t.trace.APITime.Round(time.Microsecond), t.trace.Unconfirmed)
+}
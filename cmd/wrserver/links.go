@@ -0,0 +1,291 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+
+	"github.com/google/webrisk"
+)
+
+const (
+	linksPath = "/links"
+
+	// linksMaxPageSize is the maximum size of the pages that are scanned.
+	linksMaxPageSize = 4 << 20
+
+	// linksMaxLinks is the number of links of a page that are looked up at
+	// most.
+	linksMaxLinks = 1000
+
+	// linksFetchTimeout bounds the fetch of a page.
+	linksFetchTimeout = 10 * time.Second
+
+	// linksMaxRedirects is the number of redirects followed to fetch a page.
+	linksMaxRedirects = 5
+)
+
+// linksClient is the client that pages are fetched with.
+var linksClient = newLinksClient(false)
+
+// newLinksClient returns a client that fetches pages like newPublicClient,
+// but follows up to linksMaxRedirects redirects.
+func newLinksClient(allowPrivate bool) *http.Client {
+	c := newPublicClient(allowPrivate)
+	c.Timeout = linksFetchTimeout
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > linksMaxRedirects {
+			return fmt.Errorf("more than %d redirects", linksMaxRedirects)
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to a %s URL", req.URL.Scheme)
+		}
+		return nil
+	}
+	return c
+}
+
+// linkAttrs are the attributes that hold links, by element.
+var linkAttrs = map[atom.Atom]atom.Atom{
+	atom.A:      atom.Href,
+	atom.Area:   atom.Href,
+	atom.Link:   atom.Href,
+	atom.Img:    atom.Src,
+	atom.Script: atom.Src,
+	atom.Iframe: atom.Src,
+	atom.Frame:  atom.Src,
+	atom.Embed:  atom.Src,
+	atom.Source: atom.Src,
+	atom.Video:  atom.Src,
+	atom.Audio:  atom.Src,
+	atom.Track:  atom.Src,
+	atom.Form:   atom.Action,
+	atom.Object: atom.Data,
+}
+
+// pageLink is a link of a page.
+type pageLink struct {
+	URL     string `json:"url"`
+	Element string `json:"element"` // Element of its first occurrence
+}
+
+// extractLinks returns the http and https links of the HTML page read from
+// r, once each, resolved against base, or against the href of its base
+// element. They include the targets of meta refresh redirects.
+func extractLinks(r io.Reader, base *url.URL) ([]pageLink, error) {
+	var links []pageLink
+	seen := make(map[string]bool)
+	add := func(element, ref string) {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			return
+		}
+		u, err := base.Parse(ref)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return
+		}
+		u.Fragment, u.RawFragment = "", ""
+		if s := u.String(); !seen[s] {
+			seen[s] = true
+			links = append(links, pageLink{URL: s, Element: element})
+		}
+	}
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return links, nil
+			}
+			return nil, z.Err()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			switch {
+			case tok.DataAtom == atom.Base && base != nil:
+				for _, a := range tok.Attr {
+					if a.Key == "href" {
+						if u, err := base.Parse(a.Val); err == nil {
+							base = u
+						}
+					}
+				}
+			case tok.DataAtom == atom.Meta:
+				refresh, content := false, ""
+				for _, a := range tok.Attr {
+					switch a.Key {
+					case "http-equiv":
+						refresh = strings.EqualFold(a.Val, "refresh")
+					case "content":
+						content = a.Val
+					}
+				}
+				if _, target, ok := strings.Cut(content, "="); refresh && ok {
+					add("meta", strings.Trim(target, `'" `))
+				}
+			default:
+				attr, ok := linkAttrs[tok.DataAtom]
+				for _, a := range tok.Attr {
+					switch {
+					case ok && a.Key == attr.String():
+						add(tok.Data, a.Val)
+					case a.Key == "srcset":
+						for _, candidate := range strings.Split(a.Val, ",") {
+							if f := strings.Fields(candidate); len(f) > 0 {
+								add(tok.Data, f[0])
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// fetchPage fetches the page at rawURL, and returns its body and final URL.
+func fetchPage(ctx context.Context, rawURL string) ([]byte, *url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, nil, fmt.Errorf("invalid url: %q", rawURL)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := linksClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("fetching %s: %s", rawURL, resp.Status)
+	}
+	if mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mt != "text/html" && mt != "application/xhtml+xml" {
+		return nil, nil, fmt.Errorf("fetching %s: not an HTML page: %q", rawURL, mt)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, linksMaxPageSize))
+	return body, resp.Request.URL, err
+}
+
+// linkVerdict is the verdict of a link of a page.
+type linkVerdict struct {
+	pageLink
+	Safe        bool                 `json:"safe"`
+	ThreatTypes []webrisk.ThreatType `json:"threatTypes"`
+	Unverified  bool                 `json:"unverified,omitempty"` // See lookupResponse
+}
+
+// serveLinks looks up the links of an HTML page, for CMS publish hooks and
+// mail gateways: the page in the body of text/html requests, whose
+// relative links are resolved against the base query parameter, if any, or
+// the page at the url of a JSON object such as {"url":"https://..."}, which
+// is fetched from the public internet only.
+func serveLinks(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient, events *eventHub, lat *latencies) {
+	timer, ctx := lat.start("links", req)
+	defer timer.done()
+	if req.Method != "POST" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	var page io.Reader
+	base := &url.URL{}
+	mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch mt {
+	case mimeJSON:
+		var in struct {
+			URL string `json:"url"`
+		}
+		if err := json.NewDecoder(io.LimitReader(req.Body, 64<<10)).Decode(&in); err != nil {
+			problemError(resp, err.Error(), http.StatusBadRequest, codeInvalidFormat)
+			return
+		}
+		body, final, err := fetchPage(ctx, in.URL)
+		if err != nil {
+			problemError(resp, err.Error(), http.StatusBadGateway, codeFetchFailed)
+			return
+		}
+		page, base = strings.NewReader(string(body)), final
+	case "text/html", "application/xhtml+xml":
+		if b := req.URL.Query().Get("base"); b != "" {
+			var err error
+			if base, err = url.Parse(b); err != nil {
+				problemError(resp, "invalid base", http.StatusBadRequest, codeInvalidFormat)
+				return
+			}
+		}
+		page = io.LimitReader(req.Body, linksMaxPageSize)
+	default:
+		problemError(resp, "invalid content type", http.StatusUnsupportedMediaType, codeInvalidFormat)
+		return
+	}
+	links, err := extractLinks(page, base)
+	if err != nil {
+		problemError(resp, err.Error(), http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	if len(links) > linksMaxLinks {
+		problemError(resp, fmt.Sprintf("too many links: %d, at most %d", len(links), linksMaxLinks), http.StatusRequestEntityTooLarge, codeTooLarge)
+		return
+	}
+	out := struct {
+		Safe  bool          `json:"safe"`
+		Links []linkVerdict `json:"links"`
+	}{Safe: true, Links: []linkVerdict{}}
+	if len(links) > 0 {
+		urls := make([]string, len(links))
+		for i, l := range links {
+			urls[i] = l.URL
+		}
+		timer.url = urls[0]
+		threats, sources, err := wr.LookupURLsSources(ctx, urls)
+		if lookupFailed(err, sources) {
+			timer.source = "error"
+			backendError(resp, err, urls...)
+			return
+		}
+		timer.source, timer.threats = sources[0].String(), threats[0]
+		for i, l := range links {
+			v := linkVerdict{pageLink: l, Safe: true, ThreatTypes: []webrisk.ThreatType{}}
+			if d, ok := newDetection("links", clientAddr(req), l.URL, threats[i], sources[i]); ok {
+				events.publish(d)
+				v.Safe, v.ThreatTypes = false, d.ThreatTypes
+				out.Safe = false
+			}
+			if sources[i] == webrisk.SourceUnverified {
+				v.Safe, v.Unverified = false, true
+				out.Safe = false
+			}
+			events.zone.addThreats(threats[i])
+			out.Links = append(out.Links, v)
+		}
+	}
+	buf, err := json.Marshal(out)
+	if err != nil {
+		problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
+		return
+	}
+	resp.Header().Set("Content-Type", mimeJSON)
+	resp.Write(buf)
+}
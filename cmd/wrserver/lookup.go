@@ -0,0 +1,200 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+const (
+	lookupPath = "/lookup"
+
+	// lookupMaxAge bounds how long browsers may cache the verdicts of the
+	// lookup endpoint.
+	lookupMaxAge = 5 * time.Minute
+
+	// corsMaxAge is how long browsers may cache the answers to preflight
+	// requests, in seconds.
+	corsMaxAge = 86400
+)
+
+// corsOrigins are the origins that may call the lookup endpoint from
+// browsers, such as chrome-extension://<id>, or "*" for any. It is nil if
+// cross-origin requests are not allowed.
+var corsOrigins map[string]bool
+
+// parseCORSOrigins parses a comma-separated list of origins.
+func parseCORSOrigins(s string) (map[string]bool, error) {
+	var origins map[string]bool
+	for _, origin := range strings.Split(s, ",") {
+		if origin = strings.TrimSuffix(strings.TrimSpace(origin), "/"); origin == "" {
+			continue
+		}
+		if origin != "*" && !strings.Contains(origin, "://") {
+			return nil, errors.New("invalid origin: " + origin)
+		}
+		if origins == nil {
+			origins = make(map[string]bool)
+		}
+		origins[origin] = true
+	}
+	return origins, nil
+}
+
+// lookupResponse is the JSON object served by the lookup endpoint.
+type lookupResponse struct {
+	URL         string               `json:"url"`
+	Safe        bool                 `json:"safe"`
+	ThreatTypes []webrisk.ThreatType `json:"threatTypes"`
+
+	// Unverified is set, with Safe unset, by the endpoints that look up
+	// several URLs, for a URL whose verdict is not known although those of
+	// others are, such as one that matched the database when the API could
+	// not be reached, to tell it apart from a URL that is definitely safe.
+	Unverified bool `json:"unverified,omitempty"`
+
+	// SafeUntil, for a safe URL, and ExpireTime, for an unsafe one, are when
+	// the verdict expires, and RevalidateAfter is when it should be looked
+	// up again, for clients that cache verdicts on their own.
+	SafeUntil       *time.Time `json:"safeUntil,omitempty"`
+	ExpireTime      *time.Time `json:"expireTime,omitempty"`
+	RevalidateAfter *time.Time `json:"revalidateAfter,omitempty"`
+}
+
+// allowCORS sets the CORS headers of the response to req if its origin is
+// allowed, and returns whether it is. Requests without an origin, which do
+// not come from a browser, are always allowed.
+func allowCORS(resp http.ResponseWriter, req *http.Request) bool {
+	resp.Header().Add("Vary", "Origin")
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if !corsOrigins["*"] && !corsOrigins[origin] {
+		return false
+	}
+	resp.Header().Set("Access-Control-Allow-Origin", origin)
+	resp.Header().Set("Access-Control-Expose-Headers", "ETag")
+	return true
+}
+
+// serveLookup looks up the URL given by the url query parameter, for browser
+// extensions and web pages of the -corsOrigins. The verdict may be cached by
+// browsers until it expires, up to lookupMaxAge, and revalidated with its
+// ETag.
+func serveLookup(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient, events *eventHub, lat *latencies) {
+	if req.Method == "OPTIONS" {
+		if !allowCORS(resp, req) {
+			problemError(resp, "origin not allowed", http.StatusForbidden, codeForbidden)
+			return
+		}
+		resp.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+		if headers := req.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			resp.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		resp.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+		resp.WriteHeader(http.StatusNoContent)
+		return
+	}
+	timer, ctx := lat.start("lookup", req)
+	defer timer.done()
+	if req.Method != "GET" && req.Method != "HEAD" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	if !allowCORS(resp, req) {
+		problemError(resp, "origin not allowed", http.StatusForbidden, codeForbidden)
+		return
+	}
+	rawURL := req.URL.Query().Get("url")
+	if rawURL == "" {
+		problemError(resp, "missing url", http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	timer.url = rawURL
+	out := lookupResponse{URL: rawURL, Safe: true, ThreatTypes: []webrisk.ThreatType{}}
+	maxAge := lookupMaxAge
+	threats, sources, err := wr.LookupURLsSources(ctx, []string{rawURL})
+	switch {
+	case errors.Is(err, webrisk.ErrSchemeRejected):
+		out.Safe = false
+	case err != nil:
+		timer.source = "error"
+		backendError(resp, err, rawURL)
+		return
+	default:
+		timer.source, timer.threats = sources[0].String(), threats[0]
+		checked := rawURL
+		if unshortenLookups {
+			checked, threats[0], sources[0] = followRedirects(ctx, wr, rawURL, threats[0], sources[0])
+		}
+		if d, ok := newDetection("lookup", clientAddr(req), checked, threats[0], sources[0]); ok {
+			events.publish(d)
+			events.zone.addThreats(threats[0])
+			out.Safe, out.ThreatTypes = false, d.ThreatTypes
+		}
+		if cc, ok := setCacheControl(resp.Header(), wr, checked, threats[0]); ok {
+			// Whole seconds keep the ETag of the verdict stable.
+			expire, revalidate := cc.Expire.UTC().Truncate(time.Second), cc.RevalidateAfter.UTC().Truncate(time.Second)
+			if out.Safe {
+				out.SafeUntil = &expire
+			} else {
+				out.ExpireTime = &expire
+			}
+			out.RevalidateAfter = &revalidate
+			if d := time.Until(expire); d < maxAge {
+				maxAge = d
+			}
+		}
+	}
+	buf, err := json.Marshal(out)
+	if err != nil {
+		problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
+		return
+	}
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	sum := sha256.Sum256(buf)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	h := resp.Header()
+	h.Set("ETag", etag)
+	h.Set("Cache-Control", "private, max-age="+strconv.Itoa(int(maxAge/time.Second)))
+	if etagMatches(req.Header.Get("If-None-Match"), etag) {
+		resp.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Type", mimeJSON)
+	resp.Write(buf)
+}
+
+// etagMatches returns whether the If-None-Match header value matches etag.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -70,7 +70,113 @@
 //	/v4/threatMatches:find
 //	/v4/threatLists
 //	/status
+//	/metrics
 //	/r
+//	/lookup
+//	/qr
+//	/links
+//	/check
+//	/probe
+//	/extauthz/
+//	/async
+//	/v1/scan
+//	/debug/expressions
+//	/v4/fullHashes:find
+//	/v4/threatListUpdates:fetch
+//	/v4/threatLists
+//
+// The lookup and admin endpoints are defined with OpenAPI in
+// clients/openapi.json, from which the Python and TypeScript clients in the
+// clients directory are generated.
+//
+// Multiple tenants, each with their own API key, database, threat lists,
+// update schedule, and stats, can be served from a single wrserver by passing
+// a JSON file of tenants with -tenants. The endpoints of a tenant are served
+// under /t/<name>, such as /t/acme/status. The -apikey flag may then be
+// omitted, in which case no endpoints are served at the root.
+//
+// Errors are answered with an application/problem+json (RFC 7807) body,
+// whose code member tells clients what failed: invalid_format for malformed
+// requests, backend_unavailable when the database, the cache or the API
+// failed, quota_exceeded when the API quota is exhausted, stale_database when
+// the database is too old to answer, and a few others such as
+// invalid_method, not_found or too_large.
+//
+//	$ curl localhost:8080/v1/uris:search -d '{"uri":"http://bad1url.org"}'
+//	{"type":"about:blank","title":"Service Unavailable","status":503,"detail":"webrisk: threat list is stale","code":"stale_database","requestId":"0f9e4c47-2b6d-4d1e-9a53-6c1f0e2b8a71"}
+//
+// Each request is given an ID, taken from its X-Request-ID header if it has
+// a valid one, and sent back in the X-Request-ID header of the response and
+// the requestId of its error. The ID is sent with the calls to the Web Risk
+// API made to serve the request, and prefixes the log messages about it,
+// such as of its failures, so that a failure reported by a client can be
+// found in the logs.
+//
+// So that clients can cache verdicts on their own without guessing for how
+// long, the search, lookup, check and probe endpoints tell when the verdict
+// expires, in the X-Webrisk-Expires header, and when it should be looked up
+// again because the threat lists are updated, in the
+// X-Webrisk-Revalidate-After header. The search endpoint also gives the
+// expireTime of the matches like the Web Risk API, and the lookup endpoint
+// gives them as the safeUntil or expireTime, and revalidateAfter members.
+//
+//	$ curl -i localhost:8080/v1/uris:search -d '{"uri":"http://google.com"}'
+//	HTTP/1.1 200 OK
+//	X-Webrisk-Expires: Wed, 24 May 2023 11:00:00 GMT
+//	X-Webrisk-Revalidate-After: Wed, 24 May 2023 10:30:00 GMT
+//
+// Endpoints under /admin are only served if an -adminToken is specified, and
+// require it as a bearer token.
+//
+// Replicas of wrserver can share the results of their API lookups without an
+// external cache server by listing each other with -peers, or by discovering
+// each other through DNS with -peers=dns://host:port. Each replica must know
+// its own base URL, given by -peerSelf, and the replicas must share a
+// -peerSecret so that others cannot alter their caches. Each replica keeps at
+// most -cacheMaxEntries entries from its peers, if set.
+//
+// On SIGUSR2, wrserver restarts without dropping lookups, such as after its
+// binary was upgraded: it starts a new wrserver process with the same
+// arguments, which inherits its listener. The old process saves its caches
+// and hands its databases over, while it keeps serving lookups until the new
+// one has loaded them. It then drains like on SIGTERM. If the new process
+// fails to start, or is not ready within 10 minutes, the old one takes its
+// databases back and carries on.
+//
+// With -selftest, wrserver does not serve lookups. It waits for the initial
+// sync of its databases, looks up the Web Risk test URL of each threat list
+// that it subscribes to, such as
+// http://testsafebrowsing.appspot.com/s/malware.html, and exits with status
+// 1 if any of them is not found unsafe, as an end-to-end smoke test of a
+// deployment: its API key, network access, database and cache.
+//
+//	$ wrserver -apikey=... -selftest
+//	ok    MALWARE  http://testsafebrowsing.appspot.com/s/malware.html  found MALWARE (source api)
+//	...
+//	Self-test passed
+//
+// With -lookupDeadline, lookups that need the Web Risk API wait for it for
+// at most that long, for latency-critical uses such as mail delivery or
+// redirects. The matches of the database that the API has not confirmed by
+// then are reported as unsafe, with "unconfirmed" as the source of the
+// verdict, and the API calls finish in the background so that their results
+// are cached for the next lookups.
+//
+// With -icapAddr, wrserver also serves an ICAP (RFC 3507) REQMOD service at
+// icap://host:port/reqmod, or icap://host:port/t/<name>/reqmod for a tenant,
+// so that proxies and mail gateways that speak ICAP can check the URLs of
+// the requests that they handle. Safe requests are passed on as they are,
+// and unsafe ones are answered with the interstitial, in a 403 response.
+// Detections are attributed to the address in the X-Client-IP header, if the
+// proxy sends it. The ICAP listener is handed over on SIGUSR2 as well.
+//
+//	$ wrserver -apikey=... -icapAddr=0.0.0.0:1344
+//
+//	# squid.conf
+//	icap_enable on
+//	icap_send_client_ip on
+//	icap_service webrisk reqmod_precache icap://127.0.0.1:1344/reqmod bypass=off
+//	adaptation_access webrisk allow all
 //
 // Endpoint: /v4/threatMatches:find
 //
@@ -161,15 +267,688 @@
 //	        "QueriesByCache" : 31,
 //	        "QueriesByAPI" : 6,
 //	        "QueriesFail" : 0,
+//	        "Lists" : {
+//	            "MALWARE" : {
+//	                "Entries" : 4096,
+//	                "LastUpdate" : "2023-05-24T10:00:00Z",
+//	                "LastUpdateType" : "DIFF",
+//	                "LastAdded" : 12,
+//	                "LastRemoved" : 3,
+//	                "ChecksumOK" : true,
+//	                "ChecksumFailures" : 0
+//	            }
+//	        }
 //	    },
-//	    "Error" : ""
+//	    "Error" : "",
+//	    "Build" : {
+//	        "version" : "v1.2.3",
+//	        "commit" : "4f8c2b1d9e0a7c6b5a4f3e2d1c0b9a8f7e6d5c4b",
+//	        "buildDate" : "2023-06-01T12:00:00Z",
+//	        "goVersion" : "go1.19.10"
+//	    }
+//	}
+//
+// The Build object identifies the build of wrserver, as also printed by
+// wrserver -version.
+//
+// With -notifyWebhooks, operational events are posted to webhooks as they
+// happen, so that the on-call can be paged without a monitoring system: a
+// failed database update, and the next successful one, the database going
+// stale, the circuit breaker of -circuitBreakerThreshold opening, and the
+// Web Risk API refusing calls because the quota is exhausted.
+//
+//	{"time":"2023-05-24T10:00:00Z","type":"update_failed","message":"database update failed, retrying in 15m0s: ...","host":"wrserver-1","tenant":"acme"}
+//
+// Endpoint: /readyz
+//
+// The readiness endpoint fails with 503 Service Unavailable, along with the
+// reasons, while the database of the server or of any tenant cannot serve
+// lookups: before it is first loaded, after a failed update cleared it, and
+// once it is stale, so that a load balancer stops sending lookups to this
+// replica.
+// The database is stale once it has not been updated for -maxDatabaseAge,
+// twice the update period by default. Lookups then fail, or keep being
+// served from the stale database with -stalePolicy=serve, and the age of the
+// database is exposed as the webrisk_database_age_seconds metric.
+//
+// Example usage:
+//
+//	$ curl -i localhost:8080/readyz
+//	HTTP/1.1 503 Service Unavailable
+//	Content-Type: text/plain; charset=utf-8
+//
+//	tenant acme: webrisk: threat list is stale
+//
+// Endpoint: /metrics
+//
+// The metrics endpoint exposes the same statistics in the Prometheus text
+// format, including cache hits, misses, expirations, evictions, and entry
+// counts, for scraping by a monitoring system. It also exposes the latency
+// of the search and redirect requests as the webrisk_request_duration_seconds
+// histogram, by endpoint and by what gave the verdict: the database, the
+// cache, the API, unconfirmed, or an error. With -slowRequestThreshold, the requests that
+// take longer are logged along with what their lookup did, such as the
+// number of cache misses and API calls and the time spent waiting for the
+// API.
+//
+// With -traceContext, the W3C traceparent and tracestate headers of the
+// lookup requests are sent with the Web Risk API calls made to serve them,
+// so that these calls appear in the distributed traces of the callers.
+//
+// With -otlpEndpoint, the same metrics are also pushed every -metricsPeriod
+// to an OpenTelemetry collector over OTLP/HTTP, with the webrisk.tenant
+// resource attribute set to the name of the tenant they are about, if any.
+// With -statsd, they are sent to a StatsD server or DogStatsD agent instead,
+// along with the duration of threat list updates, and the metrics of a
+// tenant are prefixed with its name, or tagged with it for DogStatsD.
+//
+// Example usage:
+//
+//	$ curl localhost:8080/metrics
+//	# HELP webrisk_cache_lookups_total Number of cache lookups, by result.
+//	# TYPE webrisk_cache_lookups_total counter
+//	webrisk_cache_lookups_total{result="positive_hit"} 4
+//	webrisk_cache_lookups_total{result="negative_hit"} 27
+//	webrisk_cache_lookups_total{result="miss"} 6
+//	...
+//
+// Endpoint: /admin/db/verify
+//
+// The verify endpoint immediately checks every local threat list against the
+// version that the Web Risk API expects, and reports which lists are out of
+// sync, rather than waiting for the next scheduled update to notice.
+//
+// Example usage:
+//
+//	$ curl -X POST -H "Authorization: Bearer $ADMINTOKEN" localhost:8080/admin/db/verify
+//	{
+//	    "inSync": false,
+//	    "lists": [{
+//	        "threatType": "MALWARE",
+//	        "inSync": false,
+//	        "reset": false,
+//	        "added": 12,
+//	        "removed": 3
+//	    }]
+//	}
+//
+// Endpoint: /admin/cache/prewarm
+//
+// The prewarm endpoint looks up the URLs in the request body, one per line,
+// so that their results are cached before clients ask for them. The same can
+// be done on startup with the -prewarm flag.
+//
+// Example usage:
+//
+//	$ curl -X POST -H "Authorization: Bearer $ADMINTOKEN" --data-binary @top-urls.txt localhost:8080/admin/cache/prewarm
+//	{
+//	    "urls": 10000
+//	}
+//
+// Endpoint: /admin/cache/lookup
+//
+// The cache lookup endpoint shows, for each of the hashes that a URL is looked
+// up by, whether the database lists it and what the cache holds for it: a
+// positive entry with its threat types, a negative entry for one of its
+// prefixes, or nothing, along with the remaining TTLs. This explains why a
+// URL that was just delisted is still reported, for instance.
+//
+// Example usage:
+//
+//	$ curl -H "Authorization: Bearer $ADMINTOKEN" "localhost:8080/admin/cache/lookup?url=http://evil.com/"
+//	{
+//	    "url": "http://evil.com/",
+//	    "hashes": [{
+//	        "pattern": "evil.com/",
+//	        "hash": "7d9c9c1d...",
+//	        "databaseThreats": ["MALWARE"],
+//	        "result": "positive",
+//	        "threats": [{
+//	            "threatType": "MALWARE",
+//	            "expires": "2023-05-24T10:05:00Z",
+//	            "ttlSeconds": 287.5
+//	        }],
+//	        "ttlPolicy": "max"
+//	    }]
+//	}
+//
+// Endpoint: /admin/cache/purge
+//
+// The purge endpoint removes all entries from the cache, so that lookups ask
+// the Web Risk API again. For a tenant, only the cache of that tenant is
+// purged. A -memcached cache cannot be purged.
+//
+// Example usage:
+//
+//	$ curl -X POST -H "Authorization: Bearer $ADMINTOKEN" localhost:8080/t/acme/admin/cache/purge
+//	{"purged":true}
+//
+// Endpoint: /admin/stats/reset
+//
+// The stats reset endpoint zeroes the query and cache counters reported by
+// the status and metrics endpoints, and the latency histograms, such as
+// before a load test. For a tenant, only the counters of that tenant are
+// reset. With -statsPath, the counters are otherwise kept across restarts,
+// so that long-term dashboards are not zeroed by every deploy.
+//
+// Example usage:
+//
+//	$ curl -X POST -H "Authorization: Bearer $ADMINTOKEN" localhost:8080/admin/stats/reset
+//	{"reset":true}
+//
+// Endpoint: /admin/db/update
+//
+// The update endpoint updates the database from the Web Risk API right away,
+// instead of waiting for the next scheduled update, and reports whether it
+// succeeded. With -readOnly, the database is reloaded from -db instead.
+//
+// Example usage:
+//
+//	$ curl -X POST -H "Authorization: Bearer $ADMINTOKEN" localhost:8080/admin/db/update
+//	{"updated":true}
+//
+// Endpoint: /admin/db/export
+//
+// The export endpoint sends the current contents of the database in the same
+// format as the -db file, so that it can be inspected or used to seed another
+// replica.
+//
+// Example usage:
+//
+//	$ curl -H "Authorization: Bearer $ADMINTOKEN" -o webrisk.db localhost:8080/admin/db/export
+//
+// Endpoint: /admin/db/prefixes
+//
+// The prefixes endpoint sends the 4-byte hash prefixes of the threat list
+// given by the list parameter, so that network appliances which match hash
+// prefixes themselves can be fed from wrserver instead of the Web Risk API.
+// Longer prefixes are cut to 4 bytes. The prefixes are sorted and
+// concatenated, or hex encoded one per line with format=hex. The
+// X-Webrisk-Prefixes header gives their number, and the ETag only changes
+// when the list does, so that polling with If-None-Match is cheap.
+//
+// Example usage:
+//
+//	$ curl -H "Authorization: Bearer $ADMINTOKEN" "localhost:8080/admin/db/prefixes?list=MALWARE&format=hex"
+//	0000a1b2
+//	00012c3d
+//	...
+//
+// Endpoint: /admin/db/changes
+//
+// The changes endpoint streams the updates of the threat lists as
+// server-sent events, so that systems which mirror the database learn of
+// changes within a second instead of polling the export or prefixes
+// endpoints. The stream starts with a "list" event giving the version,
+// checksum and number of entries of every threat list, followed by a "change"
+// event whenever a list is updated, with the number of hash prefixes added
+// and removed. With prefixes=true, the change events also hold the added and
+// removed prefixes, hex encoded.
+//
+// Example usage:
+//
+//	$ curl -N -H "Authorization: Bearer $ADMINTOKEN" "localhost:8080/admin/db/changes?prefixes=true"
+//	event: list
+//	data: {"threatType":"MALWARE","time":"2023-05-24T10:00:00Z","versionToken":"dG9rZW4=","sha256":"5f2b...","entries":4211,"added":0,"removed":0}
+//	...
+//	event: change
+//	data: {"threatType":"MALWARE","time":"2023-05-24T10:30:00Z","versionToken":"dG9rZW4r","sha256":"a91c...","entries":4213,"added":3,"removed":1,"additions":["0a1b2c3d","5e6f7a8b","c0ffee00"],"removals":["deadbeef"]}
+//
+// Endpoint: /admin/events
+//
+// The events endpoint streams the unsafe URLs found by the search and redirect
+// endpoints as they happen, as a JSON object per line, with the address of
+// the client that asked and whether the database, the cache or the API gave
+// the verdict. Events are dropped for a client that does not keep up.
+//
+// Example usage:
+//
+//	$ curl -N -H "Authorization: Bearer $ADMINTOKEN" localhost:8080/admin/events
+//	{"time":"2023-05-24T10:00:00Z","endpoint":"search","client":"192.0.2.1","url":"http://evil.com/","threatTypes":["MALWARE"],"source":"api"}
+//
+// To keep a record of every detection for incident response or compliance,
+// whether or not anyone is streaming them, -detectionLog writes the same
+// objects to any number of destinations: a file, rotated at 10MB like the
+// -auditLog, a syslog server, as RFC 5424 messages, or a webhook, which each
+// object is posted to. Detections are only dropped, and the drops logged, if a
+// destination falls more than a thousand detections behind. For SIEMs such as
+// ArcSight, Splunk and QRadar, the syslog messages can hold CEF or LEEF 2.0
+// records instead of JSON objects, with format=cef or format=leef.
+//
+//	$ wrserver -apikey=... -detectionLog=/var/log/wrserver/detections.log,syslog+tcp://siem:601?format=cef
+//
+// To feed enrichment pipelines and real-time dashboards without polling
+// /status, -eventStreams publishes detections, and the threat list updates
+// of the database, to message brokers: a NATS subject, a Google Cloud Pub/Sub
+// topic, or a Kafka topic through a Confluent REST Proxy, since wrserver does
+// not speak the Kafka protocol itself. Each message is a JSON object whose
+// kind is "detection" or "update", with the detection or the audit record of
+// the update, and is published to the subject followed by the kind on NATS,
+// with the kind as the "kind" attribute on Pub/Sub, and with the kind as key
+// on Kafka. Pub/Sub is authenticated as the default service account of the
+// instance, or not at all with the emulator named by $PUBSUB_EMULATOR_HOST.
+//
+//	$ wrserver -apikey=... -eventStreams=nats://nats:4222/webrisk,pubsub://my-project/webrisk-events
+//
+// To see what clients look up, such as to choose the URLs to -prewarm,
+// -queryLog records a sample of every lookup, safe or not, to a file rotated
+// at 10MB, as a JSON object per line. -queryLogRate is the fraction of the
+// lookups that are recorded, 1% by default. Unlike the detection log, it
+// does not record the address of the client, and only keeps the scheme and
+// host of the URLs unless -queryLogURLs says otherwise: path drops their
+// query and fragment, full keeps them as is, and hash replaces them with
+// their hex SHA-256, or HMAC-SHA256 keyed with -queryLogKey.
+//
+//	$ wrserver -apikey=... -queryLog=/var/log/wrserver/queries.log -queryLogRate=0.1
+//	$ tail -1 /var/log/wrserver/queries.log
+//	{"time":"2023-05-24T10:00:00Z","endpoint":"search","url":"http://example.com/","source":"cache"}
+//
+// Endpoint: /admin/stix
+//
+// The stix endpoint serves the unsafe URLs found since wrserver started, up
+// to the last 10,000, as a STIX 2.1 bundle of indicators for threat
+// intelligence platforms such as MISP and OpenCTI. Each indicator has a
+// [url:value = '...'] pattern and the threat types of the URL as labels, and
+// keeps its ID across requests, so that it is updated rather than duplicated
+// when imported again. With added_after, only the indicators created or given
+// a new threat type after that time are served. With -taxii, the same
+// indicators are served as the only collection of a read-only TAXII 2.1
+// server, whose discovery endpoint is /taxii2/, which also requires the
+// -adminToken as a bearer token.
+//
+// Example usage:
+//
+//	$ curl -H "Authorization: Bearer $ADMINTOKEN" "localhost:8080/admin/stix?added_after=2023-05-24T00:00:00Z"
+//	{"type":"bundle","id":"bundle--...","objects":[{"type":"identity",...},{"type":"indicator",...,"pattern":"[url:value = 'http://evil.com/']",...}]}
+//
+// The wradmin command wraps these endpoints for operators.
+//
+// Endpoint: /debug/expressions
+//
+// The expressions endpoint explains the verdict for the URL given by the url
+// query parameter. It lists every host-suffix/path-prefix expression that the
+// URL is looked up by, with its full hash and hash prefix, the threat lists
+// of the database that hold a prefix of it, and whether it matched.
+//
+// Example usage:
+//
+//	$ curl 'localhost:8080/debug/expressions?url=http://a.b.c/1/'
+//	{
+//	    "url": "http://a.b.c/1/",
+//	    "canonicalUrl": "http://a.b.c/1/",
+//	    "scheme": "lookup",
+//	    "expressions": [{
+//	        "expression":      "a.b.c/1/",
+//	        "hash":            "...",
+//	        "hashPrefix":      "...",
+//	        "databaseThreats": [],
+//	        "threats":         [],
+//	        "matched":         false
+//	    }, ...]
+//	}
+//
+// Endpoint: /v4/fullHashes:find
+//
+// This is the fullHashes:find endpoint of the Safe Browsing v4 API, so that
+// devices and libraries that speak its hash protocol can use wrserver as
+// their backend with a single Web Risk API key. It returns the full hashes
+// that start with the hash prefixes given as threat entries, looked up in
+// the database, the cache, and the Web Risk API. Only the JSON form of the
+// messages is supported, and the client and its states are ignored. Web
+// Risk threat lists apply to every platform, so matches are reported for
+// every platform type asked for, or ANY_PLATFORM.
+//
+// Example usage:
+//
+//	$ curl \
+//	  -H "Content-Type: application/json" \
+//	  -X POST -d '{
+//	      "threatInfo": {
+//	          "threatTypes":      ["MALWARE"],
+//	          "platformTypes":    ["ANY_PLATFORM"],
+//	          "threatEntryTypes": ["URL"],
+//	          "threatEntries":    [{"hash": "WwuJdQ=="}]
+//	      }
+//	  }' \
+//	  localhost:8080/v4/fullHashes:find
+//	{
+//	    "matches": [{
+//	        "threatType":      "MALWARE",
+//	        "platformType":    "ANY_PLATFORM",
+//	        "threatEntryType": "URL",
+//	        "threat":          {"hash": "WwuJdQx48jP+4lxr4y2Sj82AWoxUVcIRDSk1PC9Rf+4="},
+//	        "cacheDuration":   "300s"
+//	    }],
+//	    "negativeCacheDuration": "300s"
+//	}
+//
+// Endpoint: /v4/threatListUpdates:fetch
+//
+// The threatListUpdates:fetch and threatLists endpoints of the Safe
+// Browsing v4 API serve the threat lists of the local database, so that
+// legacy Safe Browsing clients, such as browsers and appliances, can sync
+// from wrserver. The state of a client is the SHA-256 checksum of its list.
+// Clients at one of the last few versions of a list get a partial update
+// with the changes since, and others a full update. Updates are always raw,
+// regardless of the constraints of the client.
+//
+// Example usage:
+//
+//	$ curl \
+//	  -H "Content-Type: application/json" \
+//	  -X POST -d '{
+//	      "listUpdateRequests": [{
+//	          "threatType":      "MALWARE",
+//	          "platformType":    "ANY_PLATFORM",
+//	          "threatEntryType": "URL",
+//	          "state":           ""
+//	      }]
+//	  }' \
+//	  localhost:8080/v4/threatListUpdates:fetch
+//	{
+//	    "listUpdateResponses": [{
+//	        "threatType":      "MALWARE",
+//	        "platformType":    "ANY_PLATFORM",
+//	        "threatEntryType": "URL",
+//	        "responseType":    "FULL_UPDATE",
+//	        "additions": [{
+//	            "compressionType": "RAW",
+//	            "rawHashes": {"prefixSize": 4, "rawHashes": "..."}
+//	        }],
+//	        "newClientState": "...",
+//	        "checksum": {"sha256": "..."}
+//	    }]
+//	}
+//
+// Endpoint: /lookup
+//
+// The lookup endpoint looks up the URL given by the url query parameter, and
+// answers with a JSON object of its threat types, for browser extensions and
+// internal web pages. URLs whose scheme is rejected by -schemes are unsafe,
+// without threat types. Browsers may call it from the origins listed by
+// -corsOrigins, such as chrome-extension://<id>, which get the CORS headers
+// that they need, including for preflight requests. Verdicts may be cached
+// privately for up to 5 minutes, and less if they expire sooner, and
+// revalidated with their ETag.
+//
+// Example usage:
+//
+//	$ curl -i -H "Origin: chrome-extension://abc" localhost:8080/lookup?url=http://bad1url.org
+//	HTTP/1.1 200 OK
+//	Access-Control-Allow-Origin: chrome-extension://abc
+//	Cache-Control: private, max-age=300
+//	Content-Type: application/json
+//	Etag: "5d1a0c8f3e2b7a64"
+//
+//	{"url":"http://bad1url.org","safe":false,"threatTypes":["MALWARE"],"expireTime":"2023-05-24T10:05:00Z","revalidateAfter":"2023-05-24T10:05:00Z"}
+//
+// Endpoint: /qr
+//
+// The qr endpoint looks up the URLs that QR codes lead to, for security
+// teams that triage QR code phishing. It takes the decoded payload of a QR
+// code as the request body, or several as the payloads of a JSON object,
+// since it does not decode images: decode them first, such as with zbarimg.
+// The URLs are those of the payload itself, those embedded in it, such as in
+// MECARD, MATMSG or SMSTO payloads, and those that deep links open, given by
+// their query parameters, or by the host, path and browser_fallback_url of
+// Android intent URLs. The response lists the verdict of each URL, with the
+// index of the payload that it was found in. If the lookup of some URLs
+// fails, such as when the Web Risk API cannot be reached about those that
+// match the database, they are reported as not safe and "unverified":true,
+// while the others, such as those that match no threat list, keep their
+// verdict. The request only fails if no verdict is known.
+//
+// Example usage:
+//
+//	$ zbarimg --raw -q flyer.png | curl --data-binary @- localhost:8080/qr
+//	{"safe":false,"urls":[{"payload":0,"url":"http://bad1url.org/pay","safe":false,"threatTypes":["MALWARE"]}]}
+//
+// Endpoint: /links
+//
+// The links endpoint looks up every link of an HTML page, for CMS publish
+// hooks and mail gateways: the targets of anchors, images, scripts, frames,
+// forms and meta refresh redirects, resolved against the base element of the
+// page. It takes the page as a text/html request body, whose relative links
+// are resolved against the base query parameter, or the URL of a page to
+// fetch as a JSON object. Pages are only fetched from public addresses, with
+// at most 5 redirects, and up to 4 MiB of them is read. The response lists
+// the verdict of each link, with the element that it was first found in.
+// Like for the qr endpoint, links whose lookup failed are reported as
+// unverified.
+//
+// Example usage:
+//
+//	$ curl -H 'Content-Type: text/html' --data-binary @newsletter.html localhost:8080/links
+//	{"safe":false,"links":[{"url":"http://bad1url.org/","element":"a","safe":false,"threatTypes":["MALWARE"]}]}
+//	$ curl -H 'Content-Type: application/json' -d '{"url":"https://example.com/"}' localhost:8080/links
+//	{"safe":true,"links":[{"url":"https://www.iana.org/domains/example","element":"a","safe":true,"threatTypes":[]}]}
+//
+// Endpoint: /check
+//
+// The check endpoint answers whether the URL given by the url query
+// parameter, or by the X-Original-URL header, is safe, with a 204 response,
+// or unsafe, with a 403 response that lists its threat types in the
+// X-Webrisk-Threat-Types header. It is shaped for the auth_request module of
+// nginx, and the HTTP checks of HAProxy, so that reverse proxies can refuse
+// to redirect to unsafe links.
+//
+// Example nginx configuration:
+//
+//	location /go {
+//	    auth_request /webrisk;
+//	    auth_request_set $threats $upstream_http_x_webrisk_threat_types;
+//	    return 302 $arg_url;
+//	}
+//	location = /webrisk {
+//	    internal;
+//	    proxy_pass http://wrserver:8080/check?url=$arg_url;
 //	}
 //
+// Example usage:
+//
+//	$ curl -i localhost:8080/check?url=http://google.com
+//	HTTP/1.1 204 No Content
+//
+//	$ curl -i -H "X-Original-URL: http://bad1url.org" localhost:8080/check
+//	HTTP/1.1 403 Forbidden
+//	X-Webrisk-Threat-Types: MALWARE
+//
+// Endpoint: /probe
+//
+// The probe endpoint looks up the URL given like for the check endpoint, but
+// always answers with a 200 response, annotated with the verdict for policy
+// engines that act on headers when wrserver is chained behind another proxy:
+// X-Webrisk-Threats lists the threat types of the URL, or is "none",
+// X-Webrisk-Source is whether the database, the cache or the API gave the
+// verdict, "unconfirmed" if the API did not answer within -lookupDeadline,
+// or "rejected" if the scheme of the URL is rejected by -schemes,
+// and X-Webrisk-Expires is when the cached results that the verdict relies
+// on expire, unless it relies on the database alone.
+//
+// Example usage:
+//
+//	$ curl -I localhost:8080/probe?url=http://bad1url.org
+//	HTTP/1.1 200 OK
+//	X-Webrisk-Expires: Wed, 24 May 2023 10:05:00 GMT
+//	X-Webrisk-Source: api
+//	X-Webrisk-Threats: MALWARE
+//
+// Endpoint: /extauthz/
+//
+// The extauthz endpoint is an HTTP authorization service for the ext_authz
+// filter of Envoy, and of Istio sidecars, which is sent the path of every
+// request to check after its path prefix, with the Host header of the
+// request. The scheme is taken from the X-Forwarded-Proto header if Envoy is
+// configured to pass it on, and is http otherwise. Safe URLs are allowed with
+// a 200 response, and unsafe ones denied with the interstitial in a 403
+// response, or with a redirect to the /r interstitial of the wrserver at
+// -extAuthzRedirect, so that the requests do not reach the flagged
+// destinations. Denials list the threat types of the URL in the
+// X-Webrisk-Threat-Types header. Verdicts are cached like those of any other
+// lookup.
+//
+// Example Envoy configuration:
+//
+//	http_filters:
+//	- name: envoy.filters.http.ext_authz
+//	  typed_config:
+//	    "@type": type.googleapis.com/envoy.extensions.filters.http.ext_authz.v3.ExtAuthz
+//	    http_service:
+//	      server_uri: {uri: "wrserver:8080", cluster: wrserver, timeout: 1s}
+//	      path_prefix: /extauthz
+//	      authorization_request:
+//	        allowed_headers:
+//	          patterns: [{exact: x-forwarded-proto}]
+//
+// Example usage:
+//
+//	$ curl -i -H "Host: evil.com" localhost:8080/extauthz/download
+//	HTTP/1.1 403 Forbidden
+//	Content-Type: text/html; charset=utf-8
+//	X-Webrisk-Threat-Types: MALWARE
+//
+//	<!-- Warning interstitial page shown -->
+//	...
+//
+// With -extAuthzGRPC, the same verdicts are also given by the gRPC
+// authorization service, envoy.service.auth.v3.Authorization/Check, served
+// over HTTP/2 without TLS at -srvaddr. Allowed requests get an OK response,
+// and denied ones a PERMISSION_DENIED response, with the interstitial or the
+// redirect as the denied HTTP response. Lookups that fail get an UNAVAILABLE
+// error, so that failure_mode_allow decides what Envoy does. Tenants are
+// chosen by the x-webrisk-tenant metadata.
+//
+// Example Envoy configuration:
+//
+//	http_filters:
+//	- name: envoy.filters.http.ext_authz
+//	  typed_config:
+//	    "@type": type.googleapis.com/envoy.extensions.filters.http.ext_authz.v3.ExtAuthz
+//	    transport_api_version: V3
+//	    grpc_service:
+//	      envoy_grpc: {cluster_name: wrserver}
+//	      timeout: 1s
+//	      initial_metadata: [{key: x-webrisk-tenant, value: acme}]
+//
+// where the wrserver cluster speaks HTTP/2:
+//
+//	typed_extension_protocol_options:
+//	  envoy.extensions.upstreams.http.v3.HttpProtocolOptions:
+//	    "@type": type.googleapis.com/envoy.extensions.upstreams.http.v3.HttpProtocolOptions
+//	    explicit_http_config: {http2_protocol_options: {}}
+//
+// Endpoint: /async
+//
+// With -asyncSecret, the async endpoint accepts a JSON object with the uri to
+// look up and the callbackUri to post its verdict to, and answers right away
+// with a 202 response holding the ID of the submission, which clients may
+// also choose by passing an id. It is meant for clients such as mail
+// scanners, which queue their work and cannot wait for lookups. The verdict
+// is a JSON object with the ID, the URI, whether it should be blocked, and
+// its threat types, signed in the X-Webrisk-Signature header as
+// t=<Unix time>,v1=<hex HMAC-SHA256 of "<Unix time>.<body>" keyed with the
+// secret>. Callbacks must check the signature, and that the time is recent,
+// and answer with a 2xx response, or the verdict is posted again after 1s,
+// 10s and 1m. With -asyncCallbackHosts, verdicts are only posted to the
+// listed hosts, and otherwise only to public addresses. Redirects are not
+// followed.
+//
+// Example usage:
+//
+//	$ curl -i -d '{"uri":"http://bad1url.org","callbackUri":"https://mail.example/verdicts"}' localhost:8080/async
+//	HTTP/1.1 202 Accepted
+//	Content-Type: application/json
+//
+//	{"id":"1e3f9a2c-5b7d-4e8f-9a0b-1c2d3e4f5a6b"}
+//
+// posts to https://mail.example/verdicts:
+//
+//	{"id":"1e3f9a2c-5b7d-4e8f-9a0b-1c2d3e4f5a6b","uri":"http://bad1url.org","time":"2023-05-24T10:00:00Z","blocked":true,"threatTypes":["MALWARE"],"source":"api"}
+//
+// Endpoint: /v1/scan
+//
+// The scan endpoint looks up a list of URLs in the background, so that
+// security teams can check exported lists of links without writing client
+// code. POST the list, one URL per line, as the body, as the file part of a
+// multipart form, or as a gs:// or s3:// object given by the source field of
+// a JSON object. CSV files, by their text/csv media type or .csv name, are
+// read from their first column, or from the column given by the column
+// query parameter, skipping a header. The response holds the ID of the job,
+// whose progress is served at /v1/scan/<id>, and whose report, a CSV file of
+// the threat types of each URL, is served at /v1/scan/<id>/results. Jobs of
+// up to 100,000 URLs are kept for a day after they finish, and DELETE
+// requests cancel them and drop their results.
+//
+// Example usage:
+//
+//	$ curl -F file=@links.csv localhost:8080/v1/scan
+//	{"id":"9b2e...","status":"running","total":2000,"done":0,"unsafe":0,"errors":0,"created":"2023-05-24T10:00:00Z","results":"/v1/scan/9b2e.../results"}
+//
+//	$ curl localhost:8080/v1/scan/9b2e...
+//	{"id":"9b2e...","status":"done","total":2000,"done":2000,"unsafe":3,"errors":0,...}
+//
+//	$ curl localhost:8080/v1/scan/9b2e.../results
+//	url,threatTypes,source,error
+//	http://google.com,,database,
+//	http://bad1url.org,MALWARE,api,
+//	...
+//
+// Endpoint: /rpz
+//
+// With -rpz, the rpz endpoint serves a DNS response policy zone of that name,
+// so that resolvers such as BIND and Unbound block the same hosts for
+// clients that do not go through wrserver. It lists the hosts that Web Risk
+// matched by an expression without a path, which covers the whole host and
+// its subdomains, and those flagged by the redirector heuristics, until they
+// have not been found for -rpzExpiry. Resolvers can download it over HTTP,
+// as zone transfers are not supported.
+//
+// Example usage:
+//
+//	$ curl localhost:8080/rpz
+//	$ORIGIN rpz.webrisk.local.
+//	$TTL 300
+//	@ SOA localhost. hostmaster.localhost. 1684922400 300 300 604800 300
+//	@ NS localhost.
+//	evil.com CNAME . ; MALWARE
+//	*.evil.com CNAME . ; MALWARE
+//	paypa1.com CNAME . ; The site looks like paypal.com, but does not belong to it.
+//
 // Endpoint: /r
 //
 // The redirector endpoint allows a client to pass in a query URL.
 // If the URL is safe, the client is automatically redirected to the target.
 // If the URL is unsafe, then an interstitial warning page is shown instead.
+// URLs whose scheme is passed through by -schemes are redirected to without
+// being looked up, and those whose scheme is rejected are refused.
+//
+// Optionally, a softer "are you sure" interstitial is shown for URLs that
+// Web Risk has no match for, but that look deceptive: those with a user name
+// in front of the site with -suspiciousUserinfo, sites that look like one of
+// the -protectedBrands without belonging to it, such as paypa1.com or
+// paypal.com.evil.example, and sites nested more than -maxSubdomains levels
+// deep.
+//
+// If a URL cannot be looked up, because the database is stale or the API
+// could not confirm a match, the redirector neither redirects to it nor warns
+// of a threat that may not be there, but answers with a distinct "couldn't
+// verify this link" interstitial, in a 503 response, that lets users try
+// again.
+//
+// The -threatPolicy file sets what the redirector does with the URLs of each
+// threat type: block them with the interstitial, which is the default, warn
+// with an interstitial that links to the site, for users to continue at their
+// own risk, or allow them, by redirecting to them while still recording the
+// detection. URLs of several threat types get the strictest of their
+// actions.
+//
+// With -unshorten, the redirector follows up to that many HTTP redirects of
+// safe URLs, such as those of URL shorteners, which hide where phishing
+// links lead, and shows the interstitial of the first unsafe URL that they
+// redirect to. Redirects are followed with HEAD requests, or GET requests
+// for servers that do not support HEAD, and never to private addresses.
+// With -unshortenLookups, the search, check, probe and lookup endpoints
+// follow them too.
 //
 // Example usage:
 //
@@ -194,12 +973,17 @@ import (
 	"flag"
 	"fmt"
 	"html/template"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -207,12 +991,16 @@ import (
 	_ "github.com/google/webrisk/cmd/wrserver/statik"
 	pb "github.com/google/webrisk/internal/webrisk_proto"
 	"github.com/rakyll/statik/fs"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
 	statusPath     = "/status"
+	readyPath      = "/readyz"
 	findThreatPath = "/v1/uris:search"
 	redirectPath   = "/r"
 )
@@ -226,13 +1014,96 @@ var (
 	apiKeyFlag        = flag.String("apikey", os.Getenv("APIKEY"), "specify your Web Risk API key")
 	srvAddrFlag       = flag.String("srvaddr", "0.0.0.0:8080", "TCP network address the HTTP server should use")
 	proxyFlag         = flag.String("proxy", "", "proxy to use to connect to the HTTP server")
-	databaseFlag      = flag.String("db", "", "path to the Web Risk database.")
-	threatTypesFlag   = flag.String("threatTypes", "ALL", "threat types to check against")
+	maxIdleConnsFlag  = flag.String("maxIdleConns", os.Getenv("MAXIDLECONNS"), "number of idle connections to the Web Risk API kept open for reuse (default 100)")
+	idleTimeoutFlag   = flag.String("idleConnTimeout", os.Getenv("IDLECONNTIMEOUT"), "how long idle connections to the Web Risk API are kept open, lower than the idle timeout of any proxy in between (default 90s)")
+	keepAliveFlag     = flag.String("keepAlive", os.Getenv("KEEPALIVE"), "period of the TCP keep-alive probes of the connections to the Web Risk API, or -1s to disable them (default 30s)")
+	disableHTTP2Flag  = flag.Bool("disableHTTP2", os.Getenv("DISABLEHTTP2") == "yes", "connect to the Web Risk API over HTTP/1.1 only, for proxies that mishandle HTTP/2")
+	databaseFlag      = flag.String("db", "", "path to the Web Risk database, or a gs://bucket/object or s3://bucket/key location.")
+	writePolicyFlag   = flag.String("dbWritePolicy", os.Getenv("DBWRITEPOLICY"), "how to write a local -db file: sync (default), rename to skip fsync, or inplace to minimize writes")
+	threatTypesFlag   = flag.String("threatTypes", "ALL", "comma-separated threat types to check against, or ALL, each optionally followed by =TYPE to report it as another threat type (e.g. SOCIAL_ENGINEERING_EXTENDED_COVERAGE=SOCIAL_ENGINEERING)")
+	ignoreUnknownFlag = flag.Bool("ignoreUnknownThreatTypes", os.Getenv("IGNOREUNKNOWNTHREATTYPES") == "yes", "log and drop the unknown threat types of -threatTypes and of tenants, such as those added in newer versions, instead of refusing to start")
 	pminTTLFlag       = flag.String("pminTTL", os.Getenv("PMINTTL"), "minimum time to cache positive responses")
 	nminTTLFlag       = flag.String("nminTTL", os.Getenv("NMINTTL"), "minimum time to cache negative responses")
+	ttlPolicyFlag     = flag.String("ttlPolicy", os.Getenv("TTLPOLICY"), "how -pminTTL and -nminTTL combine with the cache durations given by the API: max (default) to extend them, min to shorten them, or override to replace them")
+	idnPolicyFlag     = flag.String("idnPolicy", os.Getenv("IDNPOLICY"), "how internationalized hostnames are canonicalized: lenient (default) to map them according to UTS #46, strict to also reject invalid ones, or punycode to convert them without mapping")
+	schemesFlag       = flag.String("schemes", os.Getenv("SCHEMES"), "what to do with the URLs of each scheme: lookup (default), reject, or pass to report no threats without looking them up (e.g. ftp=pass,*=reject)")
+	publicSuffixFlag  = flag.Bool("publicSuffixes", os.Getenv("PUBLICSUFFIXES") == "yes", "choose the host suffixes that URLs are looked up by according to the public suffix list, rather than by counting labels")
+	strictURLsFlag    = flag.Bool("strictURLs", os.Getenv("STRICTURLS") == "yes", "reject URLs that cannot be canonicalized unambiguously, instead of looking them up as well as possible")
+	ipHostThreatFlag  = flag.String("ipHostThreat", os.Getenv("IPHOSTTHREAT"), "report URLs whose host is an IP address, such as http://3279880203/, as this threat type (e.g. SOCIAL_ENGINEERING)")
+	maxHostFlag       = flag.String("maxHostComponents", os.Getenv("MAXHOSTCOMPONENTS"), "number of trailing hostname components that host suffixes are looked up from (default 7)")
+	maxPathFlag       = flag.String("maxPathComponents", os.Getenv("MAXPATHCOMPONENTS"), "number of path prefixes that are looked up (default 4)")
+	parallelMatchFlag = flag.String("parallelMatchThreshold", os.Getenv("PARALLELMATCHTHRESHOLD"), "match the expressions of URLs that are looked up by at least this many of them in parallel, stopping once they are known to be a threat of every type, if positive")
+	cacheJitterFlag   = flag.String("cacheJitter", os.Getenv("CACHEJITTER"), "expire cached lookups earlier by a random duration of up to this much, to spread out API calls (e.g. 30s)")
+	pminTTLsFlag      = flag.String("pminTTLs", os.Getenv("PMINTTLS"), "per threat type overrides of -pminTTL (e.g. MALWARE=1h,SOCIAL_ENGINEERING=5m)")
+	nminTTLsFlag      = flag.String("nminTTLs", os.Getenv("NMINTTLS"), "per threat type overrides of -nminTTL (e.g. MALWARE=10m)")
 	logAPIQueriesFlag = flag.Bool("logAPIQueries", os.Getenv("LOGAPIQUERIES") == "yes", "log queries by API")
+	memoryLimitFlag   = flag.String("memoryLimit", os.Getenv("MEMORYLIMIT"), "approximate memory limit for the database and cache (e.g. 256MB)")
+	disableCacheFlag  = flag.Bool("disableCache", os.Getenv("DISABLECACHE") == "yes", "do not cache the results of API lookups, so that no full hashes of looked up URLs are kept")
+	cacheEntriesFlag  = flag.String("cacheMaxEntries", os.Getenv("CACHEMAXENTRIES"), "maximum number of entries in the in-memory lookup cache")
+	cacheBytesFlag    = flag.String("cacheMaxBytes", os.Getenv("CACHEMAXBYTES"), "approximate memory limit for the in-memory lookup cache (e.g. 64MB)")
+	cacheRefreshFlag  = flag.String("cacheRefreshWindow", os.Getenv("CACHEREFRESHWINDOW"), "refresh cached lookups in the background when they are used within this duration of expiring (e.g. 1m)")
+	cachePathFlag     = flag.String("cachePath", os.Getenv("CACHEPATH"), "path to a file that the lookup cache is saved to periodically and on shutdown, and restored from on startup")
+	statsPathFlag     = flag.String("statsPath", os.Getenv("STATSPATH"), "path to a file that the query and cache counters are saved to periodically and on shutdown, and restored from on startup")
+	readOnlyFlag      = flag.Bool("readOnly", os.Getenv("READONLY") == "yes", "serve lookups from the -db database only, without contacting the Web Risk API")
+	readOnlyLockFlag  = flag.Bool("readOnlyIfLocked", os.Getenv("READONLYIFLOCKED") == "yes", "serve in -readOnly mode instead of exiting if another process is updating the -db database")
+	reloadPeriodFlag  = flag.String("reloadPeriod", os.Getenv("RELOADPERIOD"), "with -readOnly, how often to check the -db database for changes (default 30m)")
+	redisFlag         = flag.String("redis", os.Getenv("REDIS"), "redis://[:password@]host[:port][/db] URL of a Redis server to share the lookup cache between replicas")
+	memcachedFlag     = flag.String("memcached", os.Getenv("MEMCACHED"), "comma-separated host:port addresses of memcached servers to share the lookup cache between replicas")
+	peersFlag         = flag.String("peers", os.Getenv("PEERS"), "comma-separated base URLs of wrserver replicas to share the lookup cache with, or dns://host:port to use every address of host")
+	peerSelfFlag      = flag.String("peerSelf", os.Getenv("PEERSELF"), "base URL of this replica, as listed in or discovered through -peers")
+	peerSecretFlag    = flag.String("peerSecret", os.Getenv("PEERSECRET"), "shared secret that -peers authenticate each other with")
+	auditLogFlag      = flag.String("auditLog", os.Getenv("AUDITLOG"), "path to a file that records every threat list update, rotated at 10MB")
+	maxDBAgeFlag      = flag.String("maxDatabaseAge", os.Getenv("MAXDATABASEAGE"), "time since the last successful database update after which /readyz fails and lookups follow -stalePolicy (e.g. 2h, default twice the update period)")
+	stalePolicyFlag   = flag.String("stalePolicy", os.Getenv("STALEPOLICY"), "what lookups do once the database is stale: fail (default), or serve to keep answering from the stale database")
+	notifyFlag        = flag.String("notifyWebhooks", os.Getenv("NOTIFYWEBHOOKS"), "comma-separated webhook URLs that are posted a JSON event when a database update fails, the database goes stale, the circuit breaker opens, or the API quota is exhausted")
+	breakerFlag       = flag.String("circuitBreakerThreshold", os.Getenv("CIRCUITBREAKERTHRESHOLD"), "number of consecutive failed API calls after which lookups that need the API fail right away for -circuitBreakerCooldown, if positive")
+	batchWindowFlag   = flag.String("searchBatchWindow", os.Getenv("SEARCHBATCHWINDOW"), "hold back the API calls of lookups for this long, so that the calls of concurrent lookups for the same hash prefix are made as one (e.g. 5ms)")
+	deadlineFlag      = flag.String("lookupDeadline", os.Getenv("LOOKUPDEADLINE"), "answer lookups that wait this long for the API from the database and the cache, reporting their unconfirmed matches as unsafe with the unconfirmed source, while the API calls finish in the background (e.g. 200ms)")
+	breakerCoolFlag   = flag.String("circuitBreakerCooldown", os.Getenv("CIRCUITBREAKERCOOLDOWN"), "how long lookups that need the API fail right away once the circuit breaker opens (default 30s)")
+	detectionLogFlag  = flag.String("detectionLog", os.Getenv("DETECTIONLOG"), "comma-separated destinations that every unsafe URL found is recorded to: a file path, rotated at 10MB, a syslog://host[:port], syslog+tcp://host[:port] or syslog+unix:///dev/log syslog server, with ?format=cef or ?format=leef for CEF or LEEF records, or an http(s):// webhook URL")
+	prewarmFlag       = flag.String("prewarm", os.Getenv("PREWARM"), "path to a file of URLs, one per line, to look up on startup so that their results are cached")
+	selfTestFlag      = flag.Bool("selftest", os.Getenv("SELFTEST") == "yes", "instead of serving, wait for the initial sync, check that the Web Risk test URLs are found unsafe, and exit with status 1 if they are not")
+	adminTokenFlag    = flag.String("adminToken", os.Getenv("ADMINTOKEN"), "bearer token required for the /admin endpoints, which are disabled if empty")
+	taxiiFlag         = flag.Bool("taxii", os.Getenv("TAXII") == "yes", "serve the unsafe URLs found as a read-only TAXII 2.1 collection of STIX indicators under /taxii2/, with the -adminToken as bearer token")
+	tenantsFlag       = flag.String("tenants", os.Getenv("TENANTS"), "path to a JSON file of tenants, each served under /t/<name>/ with its own database")
+	userinfoFlag      = flag.Bool("suspiciousUserinfo", os.Getenv("SUSPICIOUSUSERINFO") == "yes", "show a softer interstitial from /r for URLs with a user name, such as http://paypal.com@evil.example/")
+	brandsFlag        = flag.String("protectedBrands", os.Getenv("PROTECTEDBRANDS"), "show a softer interstitial from /r for sites outside of these domains that look like them (e.g. paypal.com,google.com)")
+	otlpEndpointFlag  = flag.String("otlpEndpoint", os.Getenv("OTLPENDPOINT"), "URL of an OpenTelemetry collector to push metrics to over OTLP/HTTP, such as http://localhost:4318")
+	otlpHeadersFlag   = flag.String("otlpHeaders", os.Getenv("OTLPHEADERS"), "comma-separated key=value headers to send with the metrics pushed to -otlpEndpoint (e.g. Authorization=Bearer XXX)")
+	statsdFlag        = flag.String("statsd", os.Getenv("STATSD"), "statsd://host[:port] or dogstatsd://host[:port] URL of a StatsD server or DogStatsD agent to send metrics to")
+	statsdTagsFlag    = flag.String("statsdTags", os.Getenv("STATSDTAGS"), "comma-separated tags to add to the metrics sent to a DogStatsD -statsd agent (e.g. env:prod,region:eu)")
+	queryLogFlag      = flag.String("queryLog", os.Getenv("QUERYLOG"), "path to a file that a sample of the lookups, safe or not, is recorded to, rotated at 10MB")
+	queryLogRateFlag  = flag.String("queryLogRate", os.Getenv("QUERYLOGRATE"), "fraction of the lookups recorded to -queryLog, from 0 to 1 (default 0.01)")
+	queryLogURLsFlag  = flag.String("queryLogURLs", os.Getenv("QUERYLOGURLS"), "how much of the URLs is recorded to -queryLog: host (default) for scheme://host/, path to drop the query and fragment, full, or hash for their hex SHA-256")
+	eventStreamsFlag  = flag.String("eventStreams", os.Getenv("EVENTSTREAMS"), "comma-separated brokers to publish detections and threat list updates to: nats://[user:password@]host[:port]/subject, pubsub://project/topic or kafka+http(s)://host[:port]/topic of a Kafka REST Proxy")
+	queryLogKeyFlag   = flag.String("queryLogKey", os.Getenv("QUERYLOGKEY"), "with -queryLogURLs=hash, secret key to hash the URLs with HMAC-SHA256, so that they cannot be guessed by hashing likely ones")
+	traceContextFlag  = flag.Bool("traceContext", os.Getenv("TRACECONTEXT") == "yes", "send the traceparent and tracestate headers of requests with the Web Risk API calls made to serve them, so that they appear in the traces of the callers")
+	slowRequestFlag   = flag.String("slowRequestThreshold", os.Getenv("SLOWREQUESTTHRESHOLD"), "log lookup requests that take longer than this, with the cache and API activity of the lookup (e.g. 500ms)")
+	metricsPeriodFlag = flag.String("metricsPeriod", os.Getenv("METRICSPERIOD"), "how often to push metrics to -otlpEndpoint and -statsd (default 1m)")
+	versionFlag       = flag.Bool("version", false, "print the version, commit and build date of wrserver, and exit")
+	unshortenFlag     = flag.String("unshorten", os.Getenv("UNSHORTEN"), "follow up to this many HTTP redirects of the URLs that /r redirects to, such as those of URL shorteners, and warn if any of them is unsafe")
+	unshortenAllFlag  = flag.Bool("unshortenLookups", os.Getenv("UNSHORTENLOOKUPS") == "yes", "with -unshorten, also follow the redirects of the URLs looked up by /v1/uris:search, /check, /probe and /lookup")
+	maxSubdomainsFlag = flag.String("maxSubdomains", os.Getenv("MAXSUBDOMAINS"), "show a softer interstitial from /r for sites nested more than this many levels below their registrable domain, if positive")
+	rpzFlag           = flag.String("rpz", os.Getenv("RPZ"), "name of a DNS response policy zone of the hosts found to be unsafe as a whole, served at /rpz for resolvers such as BIND and Unbound (e.g. rpz.webrisk.local)")
+	rpzExpiryFlag     = flag.String("rpzExpiry", os.Getenv("RPZEXPIRY"), "how long a host stays in the -rpz zone after it was last found to be unsafe (default 24h)")
+	authzRedirectFlag = flag.String("extAuthzRedirect", os.Getenv("EXTAUTHZREDIRECT"), "base URL of wrserver as browsers reach it, such as https://wrserver.example.com, to redirect the requests that /extauthz/ denies to the /r interstitial of, instead of answering them with it")
+	authzGRPCFlag     = flag.Bool("extAuthzGRPC", os.Getenv("EXTAUTHZGRPC") == "yes", "also serve the gRPC authorization service of Envoy's ext_authz filter at -srvaddr, over HTTP/2 without TLS")
+	corsOriginsFlag   = flag.String("corsOrigins", os.Getenv("CORSORIGINS"), "comma-separated origins, such as chrome-extension://<id>, that may call /lookup from browsers, or * for any")
+	asyncSecretFlag   = flag.String("asyncSecret", os.Getenv("ASYNCSECRET"), "secret that the verdicts posted to the callbacks of the /async endpoint are signed with, which is disabled if empty")
+	asyncHostsFlag    = flag.String("asyncCallbackHosts", os.Getenv("ASYNCCALLBACKHOSTS"), "comma-separated hosts that /async verdicts may be posted to, or any public host if empty")
+	threatPolicyFlag  = flag.String("threatPolicy", os.Getenv("THREATPOLICY"), "path to a JSON file of what /r does with the URLs of each threat type: block (default) to show the interstitial, warn to also link to the site, or allow to redirect to it while recording the detection")
+	icapAddrFlag      = flag.String("icapAddr", os.Getenv("ICAPADDR"), "TCP network address to serve ICAP REQMOD requests at (e.g. 0.0.0.0:1344), for proxies and mail gateways that check requests over ICAP")
 )
 
+// redirectHeuristics are the checks of the redirector for URLs that look
+// deceptive, or nil if there are none.
+var redirectHeuristics *heuristics
+
+// unverifiedTemplate is the template of the interstitial shown for URLs that
+// could not be looked up, such as when the database is stale or the API
+// cannot be reached.
+const unverifiedTemplate = "/unverified.tmpl"
+
 var threatTemplate = map[webrisk.ThreatType]string{
 	webrisk.ThreatTypeMalware:                   "/malware.tmpl",
 	webrisk.ThreatTypeUnwantedSoftware:          "/unwanted.tmpl",
@@ -327,22 +1198,58 @@ func serveStatus(resp http.ResponseWriter, req *http.Request, sb *webrisk.Update
 	buf, err := json.Marshal(struct {
 		Stats webrisk.Stats
 		Error string
-	}{stats, errStr})
+		Build webrisk.BuildInfo
+	}{stats, errStr, webrisk.ReadBuildInfo()})
 	if err != nil {
-		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
 		return
 	}
 	resp.Header().Set("Content-Type", mimeJSON)
 	resp.Write(buf)
 }
 
+// statusReporter is the part of webrisk.UpdateClient that reports its
+// health.
+type statusReporter interface {
+	Status() (webrisk.Stats, error)
+}
+
+// serveReady reports whether the database of every client, keyed by tenant
+// name, is ready to serve lookups, for readiness probes. It fails with the
+// reasons otherwise.
+func serveReady(resp http.ResponseWriter, req *http.Request, clients map[string]statusReporter) {
+	var names []string
+	for name := range clients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var failures []string
+	for _, name := range names {
+		if _, err := clients[name].Status(); err != nil {
+			if name != "" {
+				err = fmt.Errorf("tenant %s: %v", name, err)
+			}
+			failures = append(failures, err.Error())
+		}
+	}
+	resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(failures) > 0 {
+		resp.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(resp, strings.Join(failures, "\n"))
+		return
+	}
+	fmt.Fprintln(resp, "ok")
+}
+
 // serveLookups is a light-weight implementation of the "/v4/threatMatches:find"
 // API endpoint. This allows clients to look up whether a given URL is safe.
 // Unlike the official API, it does not require an API key.
 // It supports both JSON and ProtoBuf.
-func serveLookups(resp http.ResponseWriter, req *http.Request, sb *webrisk.UpdateClient) {
+func serveLookups(resp http.ResponseWriter, req *http.Request, sb *webrisk.UpdateClient, events *eventHub, lat *latencies) {
+	timer, ctx := lat.start("search", req)
+	defer timer.done()
 	if req.Method != "POST" {
-		http.Error(resp, "invalid method", http.StatusBadRequest)
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
 		return
 	}
 
@@ -350,7 +1257,7 @@ func serveLookups(resp http.ResponseWriter, req *http.Request, sb *webrisk.Updat
 	pbReq := new(pb.SearchUrisRequest)
 	mime, err := unmarshal(req, pbReq)
 	if err != nil {
-		http.Error(resp, err.Error(), http.StatusBadRequest)
+		problemError(resp, err.Error(), http.StatusBadRequest, codeInvalidFormat)
 		return
 	}
 
@@ -359,19 +1266,30 @@ func serveLookups(resp http.ResponseWriter, req *http.Request, sb *webrisk.Updat
 
 	// Parse the request message.
 	urls := []string{pbReq.Uri}
+	timer.url = pbReq.Uri
 
 	// Lookup the URL.
-	utss, err := sb.LookupURLsContext(req.Context(), urls)
+	utss, sources, err := sb.LookupURLsSources(ctx, urls)
 	if err != nil {
-		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		timer.source = "error"
+		backendError(resp, err, urls...)
 		return
 	}
+	timer.source, timer.threats = sources[0].String(), utss[0]
+	if unshortenLookups {
+		urls[0], utss[0], sources[0] = followRedirects(ctx, sb, urls[0], utss[0], sources[0])
+	}
 
 	// Compose the response message.
 	pbResp := &pb.SearchUrisResponse{
 		Threat: &pb.SearchUrisResponse_ThreatUri{},
 	}
-	for _, uts := range utss {
+	for i, uts := range utss {
+		if d, ok := newDetection("search", clientAddr(req), urls[i], uts, sources[i]); ok {
+			events.publish(d)
+		}
+		events.zone.addThreats(uts)
+
 		// Use map to condense duplicate ThreatDescriptor entries.
 		tdm := make(map[webrisk.ThreatType]bool)
 		for _, ut := range uts {
@@ -382,10 +1300,15 @@ func serveLookups(resp http.ResponseWriter, req *http.Request, sb *webrisk.Updat
 			pbResp.Threat.ThreatTypes = append(pbResp.Threat.ThreatTypes, pb.ThreatType(td))
 		}
 	}
+	// Like the Web Risk API, give the expiration of matches in the response,
+	// and that of any verdict in the headers.
+	if cc, ok := setCacheControl(resp.Header(), sb, urls[0], utss[0]); ok && len(pbResp.Threat.ThreatTypes) > 0 {
+		pbResp.Threat.ExpireTime = timestamppb.New(cc.Expire)
+	}
 
 	// Encode the response message.
 	if err := marshal(resp, pbResp, mime); err != nil {
-		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
 		return
 	}
 }
@@ -410,73 +1333,310 @@ func parseTemplates(fs http.FileSystem, t *template.Template, paths ...string) (
 
 // serveRedirector implements a basic HTTP redirector that will filter out
 // redirect URLs that are unsafe according to the Web Risk API.
-func serveRedirector(resp http.ResponseWriter, req *http.Request, sb *webrisk.UpdateClient, fs http.FileSystem) {
+func serveRedirector(resp http.ResponseWriter, req *http.Request, sb *webrisk.UpdateClient, events *eventHub, lat *latencies, fs http.FileSystem) {
+	timer, ctx := lat.start("redirect", req)
+	defer timer.done()
 	rawURL := req.URL.Query().Get("url")
 	if rawURL == "" || req.URL.Path != "/r" {
-		http.NotFound(resp, req)
+		problemError(resp, "not found", http.StatusNotFound, codeNotFound)
 		return
 	}
 	parsedURL, err := url.Parse(rawURL)
 	if err != nil {
-		http.Error(resp, err.Error(), http.StatusInternalServerError)
+		problemError(resp, err.Error(), http.StatusBadRequest, codeInvalidFormat)
 		return
 	}
-	threats, err := sb.LookupURLsContext(req.Context(), []string{rawURL})
-	if err != nil {
-		http.Error(resp, err.Error(), http.StatusInternalServerError)
+	timer.url = rawURL
+	threats, sources, err := sb.LookupURLsSources(ctx, []string{rawURL})
+	if errors.Is(err, webrisk.ErrSchemeRejected) {
+		problemError(resp, err.Error(), http.StatusBadRequest, codeSchemeRejected)
 		return
 	}
-	if len(threats[0]) == 0 {
-		http.Redirect(resp, req, rawURL, http.StatusFound)
+	if err != nil {
+		timer.source = "error"
+		if !webrisk.ValidURL(rawURL) {
+			backendError(resp, err, rawURL)
+			return
+		}
+		// Neither redirect to the URL nor warn of a threat that may not be
+		// there, but tell users that it could not be checked.
+		if err := writeUnverified(resp, fs, parsedURL, err); err != nil {
+			problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
+		}
 		return
 	}
-
-	t := template.New("Web Risk Interstitial")
-	for _, threat := range threats[0] {
-		if tmpl, ok := threatTemplate[threat.ThreatType]; ok {
-			t, err = parseTemplates(fs, t, tmpl, "/interstitial.html")
+	timer.source, timer.threats = sources[0].String(), threats[0]
+	if hop, hopThreats, hopSource := followRedirects(ctx, sb, rawURL, threats[0], sources[0]); hop != rawURL {
+		// The URL redirects to an unsafe one, which the interstitial warns
+		// about instead.
+		rawURL, threats[0], sources[0] = hop, hopThreats, hopSource
+		if parsedURL, err = url.Parse(hop); err != nil {
+			problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
+			return
+		}
+	}
+	if len(threats[0]) == 0 {
+		if reason := redirectHeuristics.check(parsedURL); reason != "" {
+			events.zone.addHeuristic(parsedURL.Hostname(), reason)
+			t, err := parseTemplates(fs, template.New("Web Risk Interstitial"), suspiciousTemplate, "/interstitial.html")
 			if err != nil {
-				http.Error(resp, err.Error(), http.StatusInternalServerError)
+				problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
 				return
 			}
 			err = t.Execute(resp, map[string]any{
-				"Threat": threat,
+				"Reason": reason,
 				"Url":    parsedURL})
 			if err != nil {
-				http.Error(resp, err.Error(), http.StatusInternalServerError)
+				problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
 			}
 			return
 		}
+		http.Redirect(resp, req, rawURL, http.StatusFound)
+		return
 	}
-}
+	if d, ok := newDetection("redirect", clientAddr(req), rawURL, threats[0], sources[0]); ok {
+		events.publish(d)
+	}
+	events.zone.addThreats(threats[0])
+
+	action := redirectPolicy.action(threats[0])
+	if action == redirectAllow {
+		http.Redirect(resp, req, rawURL, http.StatusFound)
+		return
+	}
+	if err := writeInterstitial(resp, fs, threats[0], parsedURL, action == redirectWarn); err != nil {
+		problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
+	}
+}
+
+// writeInterstitial writes the warning page for u to w, for the first of
+// threats that has a template. If proceed is true, the page links to u, for
+// users to continue at their own risk.
+func writeInterstitial(w io.Writer, fs http.FileSystem, threats []webrisk.URLThreat, u *url.URL, proceed bool) error {
+	for _, threat := range threats {
+		if tmpl, ok := threatTemplate[threat.ThreatType]; ok {
+			t, err := parseTemplates(fs, template.New("Web Risk Interstitial"), tmpl, "/interstitial.html")
+			if err != nil {
+				return err
+			}
+			return t.Execute(w, map[string]any{
+				"Threat":  threat,
+				"Url":     u,
+				"Proceed": proceed})
+		}
+	}
+	return nil
+}
+
+// writeUnverified answers with the interstitial for u, whose lookup failed
+// with lookupErr, in a 503 response, for users to try again.
+func writeUnverified(resp http.ResponseWriter, fs http.FileSystem, u *url.URL, lookupErr error) error {
+	t, err := parseTemplates(fs, template.New("Web Risk Interstitial"), unverifiedTemplate, "/interstitial.html")
+	if err != nil {
+		return err
+	}
+	resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+	resp.Header().Set("Cache-Control", "no-store")
+	resp.WriteHeader(http.StatusServiceUnavailable)
+	return t.Execute(resp, map[string]any{
+		"Url":   u,
+		"Stale": errors.Is(lookupErr, webrisk.ErrStale),
+		"Retry": true})
+}
 
 // newServer sets up handlers and an http server for status, findThreatMatches,
 // redirect endpoint, and content for the interstitial warning page.
-func newServer(wr *webrisk.UpdateClient, fs http.FileSystem) *http.Server {
+// The endpoints of wr are served at the root, and those of each tenant under
+// tenantPrefix followed by the tenant name. Either may be empty. The peer
+// caches, if any, are served to the other replicas. The detections of all
+// clients are recorded to detections, if not nil.
+func newServer(wr *webrisk.UpdateClient, tenants map[string]*webrisk.UpdateClient, peers *peerGroup, detections *detectionLog, fs http.FileSystem) *http.Server {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc(statusPath, func(w http.ResponseWriter, r *http.Request) {
-		serveStatus(w, r, wr)
-	})
-	mux.HandleFunc(findThreatPath, func(w http.ResponseWriter, r *http.Request) {
-		serveLookups(w, r, wr)
-	})
-	mux.HandleFunc(redirectPath, func(w http.ResponseWriter, r *http.Request) {
-		serveRedirector(w, r, wr, fs)
+	var hubs []*eventHub
+	clients := make(map[string]statusReporter)
+	if wr != nil {
+		hubs = append(hubs, handleClient(mux, "", wr, newEventHub("", detections), fs))
+		clients[""] = wr
+	}
+	for name, t := range tenants {
+		hubs = append(hubs, handleClient(mux, tenantPrefix+name, t, newEventHub(name, detections), fs))
+		clients[name] = t
+	}
+	mux.HandleFunc(readyPath, func(w http.ResponseWriter, r *http.Request) {
+		serveReady(w, r, clients)
 	})
+	if peers != nil {
+		for prefix, pc := range peers.caches {
+			mux.Handle(prefix+webrisk.PeerCachePath, pc)
+		}
+	}
 	mux.Handle("/public/", http.StripPrefix("/public/", http.FileServer(fs)))
+	if extAuthzGRPC != nil {
+		mux.Handle(extAuthzCheckPath, extAuthzGRPC)
+	}
 
-	return &http.Server{
+	var handler http.Handler = mux
+	if *traceContextFlag {
+		handler = withTraceContext(mux)
+	}
+	handler = withRequestID(handler)
+	if extAuthzGRPC != nil {
+		// Envoy calls gRPC services over HTTP/2, which needs no TLS.
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+	srv := &http.Server{
 		Addr:    *srvAddrFlag,
-		Handler: mux,
+		Handler: handler,
 	}
+	srv.RegisterOnShutdown(func() {
+		for _, h := range hubs {
+			h.close()
+		}
+	})
+	return srv
+}
+
+// withTraceContext passes the W3C trace context of the requests, if any, to
+// the API calls made to serve them.
+func withTraceContext(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tc, ok := webrisk.TraceContextFromHeader(r.Header); ok {
+			r = r.WithContext(webrisk.WithTraceContext(r.Context(), tc))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// withRequestID gives each request an ID, the one in its X-Request-ID header
+// if it is valid, or a new one, which is sent back in the X-Request-ID header
+// of the response, and in its error, if any. The ID is passed to the API
+// calls made to serve the request, and prefixes their log messages.
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(webrisk.RequestIDHeader)
+		if !webrisk.ValidRequestID(id) {
+			id = uuid4()
+		}
+		w.Header().Set(webrisk.RequestIDHeader, id)
+		h.ServeHTTP(w, r.WithContext(webrisk.WithRequestID(r.Context(), id)))
+	})
+}
+
+// handleClient registers the status, metrics, findThreatMatches, redirect,
+// debug, Safe Browsing v4, response policy zone, admin and TAXII endpoints of
+// wr with mux under the given path prefix. The detections made by these
+// endpoints are published to events, which it returns.
+func handleClient(mux *http.ServeMux, prefix string, wr *webrisk.UpdateClient, events *eventHub, fs http.FileSystem) *eventHub {
+	handle := func(path string, h http.HandlerFunc) {
+		mux.Handle(prefix+path, http.StripPrefix(prefix, h))
+	}
+	lat := newLatencies(events.tenant)
+	handle(statusPath, func(w http.ResponseWriter, r *http.Request) {
+		serveStatus(w, r, wr)
+	})
+	handle(metricsPath, func(w http.ResponseWriter, r *http.Request) {
+		serveMetrics(w, r, wr, lat)
+	})
+	handle(findThreatPath, func(w http.ResponseWriter, r *http.Request) {
+		serveLookups(w, r, wr, events, lat)
+	})
+	handle(redirectPath, func(w http.ResponseWriter, r *http.Request) {
+		serveRedirector(w, r, wr, events, lat, fs)
+	})
+	handle(checkPath, func(w http.ResponseWriter, r *http.Request) {
+		serveCheck(w, r, wr, events, lat)
+	})
+	handle(probePath, func(w http.ResponseWriter, r *http.Request) {
+		serveProbe(w, r, wr, events, lat)
+	})
+	handle(lookupPath, func(w http.ResponseWriter, r *http.Request) {
+		serveLookup(w, r, wr, events, lat)
+	})
+	handle(qrPath, func(w http.ResponseWriter, r *http.Request) {
+		serveQR(w, r, wr, events, lat)
+	})
+	handle(linksPath, func(w http.ResponseWriter, r *http.Request) {
+		serveLinks(w, r, wr, events, lat)
+	})
+	handle(extAuthzPath, func(w http.ResponseWriter, r *http.Request) {
+		serveExtAuthz(w, r, wr, events, lat, fs, *authzRedirectFlag)
+	})
+	if extAuthzGRPC != nil {
+		extAuthzGRPC.handle(events.tenant, wr, events, lat, fs, *authzRedirectFlag)
+	}
+	handle(debugExpressionsPath, func(w http.ResponseWriter, r *http.Request) {
+		serveExpressions(w, r, wr)
+	})
+	handle(sb4FullHashesPath, func(w http.ResponseWriter, r *http.Request) {
+		serveSB4FullHashes(w, r, wr, lat)
+	})
+	scans := newScanJobs(prefix, wr, events)
+	handle(scanPath, scans.serve)
+	handle(scanPath+"/", scans.serve)
+	sb4 := newSB4Lists(wr)
+	handle(sb4ListUpdatesPath, sb4.serveListUpdates)
+	handle(sb4ThreatListsPath, sb4.serveThreatLists)
+	if rpzOrigin != "" {
+		events.zone = newRPZZone(rpzOrigin, rpzExpiry)
+		handle(rpzPath, events.zone.serve)
+	}
+	if icap != nil {
+		icap.handle(prefix+icapPath, wr, events, lat, fs)
+	}
+	if async != nil {
+		handle(asyncPath, func(w http.ResponseWriter, r *http.Request) {
+			async.serve(w, r, wr, events, lat)
+		})
+	}
+	if *adminTokenFlag != "" {
+		handle(adminVerifyPath, requireAdmin(*adminTokenFlag, func(w http.ResponseWriter, r *http.Request) {
+			serveVerify(w, r, wr)
+		}))
+		handle(adminPrewarmPath, requireAdmin(*adminTokenFlag, func(w http.ResponseWriter, r *http.Request) {
+			servePrewarm(w, r, wr)
+		}))
+		handle(adminLookupPath, requireAdmin(*adminTokenFlag, func(w http.ResponseWriter, r *http.Request) {
+			serveCacheLookup(w, r, wr)
+		}))
+		handle(adminPurgePath, requireAdmin(*adminTokenFlag, func(w http.ResponseWriter, r *http.Request) {
+			servePurge(w, r, wr)
+		}))
+		handle(adminStatsResetPath, requireAdmin(*adminTokenFlag, func(w http.ResponseWriter, r *http.Request) {
+			serveStatsReset(w, r, wr, lat)
+		}))
+		handle(adminUpdatePath, requireAdmin(*adminTokenFlag, func(w http.ResponseWriter, r *http.Request) {
+			serveUpdate(w, r, wr)
+		}))
+		handle(adminExportPath, requireAdmin(*adminTokenFlag, func(w http.ResponseWriter, r *http.Request) {
+			serveExport(w, r, wr)
+		}))
+		handle(adminPrefixesPath, requireAdmin(*adminTokenFlag, func(w http.ResponseWriter, r *http.Request) {
+			servePrefixes(w, r, wr)
+		}))
+		handle(adminChangesPath, requireAdmin(*adminTokenFlag, func(w http.ResponseWriter, r *http.Request) {
+			serveChanges(w, r, wr, events.done)
+		}))
+		handle(adminEventsPath, requireAdmin(*adminTokenFlag, func(w http.ResponseWriter, r *http.Request) {
+			serveEvents(w, r, events)
+		}))
+		events.intel = newIndicatorStore(events.tenant)
+		handle(adminSTIXPath, requireAdmin(*adminTokenFlag, func(w http.ResponseWriter, r *http.Request) {
+			serveSTIX(w, r, events.intel)
+		}))
+		if *taxiiFlag {
+			taxii := &taxiiServer{prefix: prefix, store: events.intel}
+			handle(taxiiPath, requireAdmin(*adminTokenFlag, taxii.serve))
+		}
+	}
+	return events
 }
 
 // runServer sets up a listener for interrupts, starts the passed HTTP server, and shuts down
 // gracefully on an interrupt signal. It returns an exit channel that can be used to trigger
 // cleanup and a server down channel that notifies the caller when the server is finished shutting
-// down.
-func runServer(srv *http.Server) (chan os.Signal, <-chan struct{}) {
+// down. The server accepts connections from ln, or listens on srv.Addr if ln is nil.
+func runServer(srv *http.Server, ln net.Listener) (chan os.Signal, <-chan struct{}) {
 	// start listening for interrupts
 	exit := make(chan os.Signal, 1)
 	down := make(chan struct{})
@@ -499,9 +1659,15 @@ func runServer(srv *http.Server) (chan os.Signal, <-chan struct{}) {
 
 	// runs our server until an exit signal is received
 	go func() {
-		fmt.Fprintln(os.Stdout, "Starting server at", srv.Addr)
+		fmt.Fprintln(os.Stdout, "Starting wrserver", webrisk.ReadBuildInfo(), "at", srv.Addr)
 		// this blocks our main thread until an interrupt signal
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if ln != nil {
+			err = srv.Serve(ln)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server error: %s", err)
 		}
 		close(down)
@@ -518,14 +1684,141 @@ func validateDuration(value string) string {
 	return value
 }
 
+// parseByteSize parses a size such as "512KB", "256MB", or "1GB" into a
+// number of bytes. A plain number is interpreted as bytes, and an empty
+// string as zero.
+func parseByteSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	if value == "" {
+		return 0, nil
+	}
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"B", 1}} {
+		if strings.HasSuffix(value, u.suffix) {
+			value, mult = strings.TrimSuffix(value, u.suffix), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return n * mult, nil
+}
+
+// parseThreatTTLs parses a comma-separated list of THREAT_TYPE=duration
+// pairs, such as "MALWARE=1h,SOCIAL_ENGINEERING=5m". An empty string is
+// parsed as nil.
+func parseThreatTTLs(s string) (map[webrisk.ThreatType]time.Duration, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	ttls := make(map[webrisk.ThreatType]time.Duration)
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid threat type TTL: %q", pair)
+		}
+		tt, err := webrisk.ParseThreatType(name)
+		if err != nil {
+			return nil, err
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid threat type TTL: %q", pair)
+		}
+		ttls[tt] = d
+	}
+	return ttls, nil
+}
+
+// parseSchemes parses per scheme actions, such as "ftp=pass,*=reject".
+func parseSchemes(s string) (map[string]webrisk.SchemeAction, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	schemes := make(map[string]webrisk.SchemeAction)
+	for _, pair := range strings.Split(s, ",") {
+		scheme, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || scheme == "" {
+			return nil, fmt.Errorf("invalid scheme action: %q", pair)
+		}
+		a, err := webrisk.ParseSchemeAction(value)
+		if err != nil {
+			return nil, err
+		}
+		schemes[strings.ToLower(scheme)] = a
+	}
+	return schemes, nil
+}
+
+// prewarm looks up urls with wr in the background, so that their results are
+// cached by the time clients ask for them. The name of the tenant, if any,
+// is used for logging.
+func prewarm(name string, wr *webrisk.UpdateClient, urls []string) {
+	if name != "" {
+		name = " for tenant " + name
+	}
+	start := time.Now()
+	n, err := wr.WarmCache(context.Background(), urls)
+	if err != nil {
+		log.Printf("Pre-warming the cache%s failed after %d URLs: %v", name, n, err)
+		return
+	}
+	log.Printf("Pre-warmed the cache%s with %d URLs in %v", name, n, time.Since(start))
+}
+
+// openStore opens the database at location. The write policy only applies
+// to local files.
+func openStore(location string, policy webrisk.WritePolicy) (webrisk.Store, error) {
+	if location == "" || strings.Contains(location, "://") {
+		return webrisk.OpenStore(location)
+	}
+	return webrisk.NewFileStore(location, policy), nil
+}
+
+// openCache returns the shared cache specified by -redis or -memcached, which
+// stores its entries under keyPrefix, or the default prefix if empty. It
+// returns nil if neither is specified.
+func openCache(redisURL, memcachedAddrs, keyPrefix string) (webrisk.Cache, error) {
+	switch {
+	case redisURL != "":
+		rc, err := webrisk.NewRedisCache(redisURL)
+		if err != nil {
+			return nil, err
+		}
+		rc.KeyPrefix = keyPrefix
+		return rc, nil
+	case memcachedAddrs != "":
+		mc, err := webrisk.NewMemcachedCache(memcachedAddrs)
+		if err != nil {
+			return nil, err
+		}
+		mc.KeyPrefix = keyPrefix
+		return mc, nil
+	}
+	return nil, nil
+}
+
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, usage, os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.Parse()
-	if *apiKeyFlag == "" {
-		fmt.Fprintln(os.Stderr, "No -apikey specified")
+	if *versionFlag {
+		fmt.Fprintln(os.Stdout, "wrserver", webrisk.ReadBuildInfo())
+		os.Exit(0)
+	}
+	if *apiKeyFlag == "" && *tenantsFlag == "" && !*readOnlyFlag {
+		fmt.Fprintln(os.Stderr, "No -apikey or -tenants specified")
+		os.Exit(1)
+	}
+	if *readOnlyFlag && *databaseFlag == "" {
+		fmt.Fprintln(os.Stderr, "No -db specified for -readOnly")
 		os.Exit(1)
 	}
 	pminTTL, err := time.ParseDuration(validateDuration(*pminTTLFlag))
@@ -538,30 +1831,468 @@ func main() {
 		fmt.Fprintln(os.Stderr, "Invalid -nminTTL")
 		os.Exit(1)
 	}
-	conf := webrisk.Config{
-		APIKey:                *apiKeyFlag,
-		ProxyURL:              *proxyFlag,
-		DBPath:                *databaseFlag,
-		ThreatListArg:         *threatTypesFlag,
-		Logger:                os.Stderr,
-		PMinTTL:               pminTTL,
-		NMinTTL:               nminTTL,
-		ShouldLogQueriesByAPI: *logAPIQueriesFlag,
+	ttlPolicy, err := webrisk.ParseTTLPolicy(*ttlPolicyFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -ttlPolicy")
+		os.Exit(1)
+	}
+	idnPolicy, err := webrisk.ParseIDNPolicy(*idnPolicyFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -idnPolicy")
+		os.Exit(1)
+	}
+	cacheJitter, err := time.ParseDuration(validateDuration(*cacheJitterFlag))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -cacheJitter")
+		os.Exit(1)
+	}
+	pminTTLs, err := parseThreatTTLs(*pminTTLsFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -pminTTLs: ", err)
+		os.Exit(1)
 	}
-	wr, err := webrisk.NewUpdateClient(conf)
+	nminTTLs, err := parseThreatTTLs(*nminTTLsFlag)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Unable to initialize Web Risk client: ", err)
+		fmt.Fprintln(os.Stderr, "Invalid -nminTTLs: ", err)
 		os.Exit(1)
 	}
+	schemes, err := parseSchemes(*schemesFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -schemes: ", err)
+		os.Exit(1)
+	}
+	var ipHostThreat webrisk.ThreatType
+	if *ipHostThreatFlag != "" {
+		if ipHostThreat, err = webrisk.ParseThreatType(*ipHostThreatFlag); err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid -ipHostThreat")
+			os.Exit(1)
+		}
+	}
+	reloadPeriod, err := time.ParseDuration(validateDuration(*reloadPeriodFlag))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -reloadPeriod")
+		os.Exit(1)
+	}
+	cacheRefreshWindow, err := time.ParseDuration(validateDuration(*cacheRefreshFlag))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -cacheRefreshWindow")
+		os.Exit(1)
+	}
+	if slowRequestThreshold, err = time.ParseDuration(validateDuration(*slowRequestFlag)); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -slowRequestThreshold")
+		os.Exit(1)
+	}
+	queryLogRate := 0.01
+	if *queryLogRateFlag != "" {
+		if queryLogRate, err = strconv.ParseFloat(*queryLogRateFlag, 64); err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid -queryLogRate: ", err)
+			os.Exit(1)
+		}
+	}
+	if queries, err = newQueryLog(*queryLogFlag, queryLogRate, *queryLogURLsFlag, *queryLogKeyFlag); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -queryLog: ", err)
+		os.Exit(1)
+	}
+	if streams, err = newEventStreams(*eventStreamsFlag); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -eventStreams: ", err)
+		os.Exit(1)
+	}
+	metricsPeriod, err := time.ParseDuration(validateDuration(*metricsPeriodFlag))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -metricsPeriod")
+		os.Exit(1)
+	}
+	recorder, err := newMetricsRecorder(*otlpEndpointFlag, *otlpHeadersFlag, *statsdFlag, *statsdTagsFlag, "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -otlpEndpoint, -otlpHeaders or -statsd: ", err)
+		os.Exit(1)
+	}
+	maxDatabaseAge, err := time.ParseDuration(validateDuration(*maxDBAgeFlag))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -maxDatabaseAge")
+		os.Exit(1)
+	}
+	stalePolicy, err := webrisk.ParseStalePolicy(*stalePolicyFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -stalePolicy")
+		os.Exit(1)
+	}
+	var breakerThreshold int
+	if *breakerFlag != "" {
+		if breakerThreshold, err = strconv.Atoi(*breakerFlag); err != nil || breakerThreshold < 0 {
+			fmt.Fprintln(os.Stderr, "Invalid -circuitBreakerThreshold")
+			os.Exit(1)
+		}
+	}
+	breakerCooldown, err := time.ParseDuration(validateDuration(*breakerCoolFlag))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -circuitBreakerCooldown")
+		os.Exit(1)
+	}
+	var maxIdleConns int
+	if *maxIdleConnsFlag != "" {
+		if maxIdleConns, err = strconv.Atoi(*maxIdleConnsFlag); err != nil || maxIdleConns < 1 {
+			fmt.Fprintln(os.Stderr, "Invalid -maxIdleConns")
+			os.Exit(1)
+		}
+	}
+	idleConnTimeout, err := time.ParseDuration(validateDuration(*idleTimeoutFlag))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -idleConnTimeout")
+		os.Exit(1)
+	}
+	keepAlive, err := time.ParseDuration(validateDuration(*keepAliveFlag))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -keepAlive")
+		os.Exit(1)
+	}
+	searchBatchWindow, err := time.ParseDuration(validateDuration(*batchWindowFlag))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -searchBatchWindow")
+		os.Exit(1)
+	}
+	lookupDeadline, err := time.ParseDuration(validateDuration(*deadlineFlag))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -lookupDeadline")
+		os.Exit(1)
+	}
+	notifications, err := newNotifier(*notifyFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -notifyWebhooks: ", err)
+		os.Exit(1)
+	}
+	memoryLimit, err := parseByteSize(*memoryLimitFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -memoryLimit")
+		os.Exit(1)
+	}
+	var cacheMaxEntries int
+	if *cacheEntriesFlag != "" {
+		if cacheMaxEntries, err = strconv.Atoi(*cacheEntriesFlag); err != nil || cacheMaxEntries < 0 {
+			fmt.Fprintln(os.Stderr, "Invalid -cacheMaxEntries")
+			os.Exit(1)
+		}
+	}
+	var maxHostComponents, maxPathComponents int
+	if *maxHostFlag != "" {
+		if maxHostComponents, err = strconv.Atoi(*maxHostFlag); err != nil || maxHostComponents < 1 {
+			fmt.Fprintln(os.Stderr, "Invalid -maxHostComponents")
+			os.Exit(1)
+		}
+	}
+	if *maxPathFlag != "" {
+		if maxPathComponents, err = strconv.Atoi(*maxPathFlag); err != nil || maxPathComponents < 1 {
+			fmt.Fprintln(os.Stderr, "Invalid -maxPathComponents")
+			os.Exit(1)
+		}
+	}
+	var parallelMatchThreshold int
+	if *parallelMatchFlag != "" {
+		if parallelMatchThreshold, err = strconv.Atoi(*parallelMatchFlag); err != nil || parallelMatchThreshold < 0 {
+			fmt.Fprintln(os.Stderr, "Invalid -parallelMatchThreshold")
+			os.Exit(1)
+		}
+	}
+	var maxSubdomains int
+	if *maxSubdomainsFlag != "" {
+		if maxSubdomains, err = strconv.Atoi(*maxSubdomainsFlag); err != nil || maxSubdomains < 0 {
+			fmt.Fprintln(os.Stderr, "Invalid -maxSubdomains")
+			os.Exit(1)
+		}
+	}
+	if *unshortenFlag != "" {
+		if unshortenHops, err = strconv.Atoi(*unshortenFlag); err != nil || unshortenHops < 0 {
+			fmt.Fprintln(os.Stderr, "Invalid -unshorten")
+			os.Exit(1)
+		}
+	}
+	unshortenLookups = *unshortenAllFlag
+	if *threatPolicyFlag != "" {
+		if redirectPolicy, err = loadThreatActions(*threatPolicyFlag); err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid -threatPolicy: ", err)
+			os.Exit(1)
+		}
+	}
+	if redirectHeuristics, err = newHeuristics(*userinfoFlag, *brandsFlag, maxSubdomains); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -protectedBrands: ", err)
+		os.Exit(1)
+	}
+	if *rpzFlag != "" {
+		if _, ok := rpzName(*rpzFlag); !ok {
+			fmt.Fprintln(os.Stderr, "Invalid -rpz")
+			os.Exit(1)
+		}
+		rpzOrigin = *rpzFlag
+	}
+	if *authzRedirectFlag != "" {
+		if u, err := url.Parse(*authzRedirectFlag); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fmt.Fprintln(os.Stderr, "Invalid -extAuthzRedirect")
+			os.Exit(1)
+		}
+	}
+	if *rpzExpiryFlag != "" {
+		if rpzExpiry, err = time.ParseDuration(*rpzExpiryFlag); err != nil || rpzExpiry <= 0 {
+			fmt.Fprintln(os.Stderr, "Invalid -rpzExpiry")
+			os.Exit(1)
+		}
+	}
+	if corsOrigins, err = parseCORSOrigins(*corsOriginsFlag); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -corsOrigins: ", err)
+		os.Exit(1)
+	}
+	cacheMaxBytes, err := parseByteSize(*cacheBytesFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -cacheMaxBytes")
+		os.Exit(1)
+	}
+	writePolicy, err := webrisk.ParseWritePolicy(*writePolicyFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -dbWritePolicy")
+		os.Exit(1)
+	}
+	var store webrisk.Store
+	if *databaseFlag != "" {
+		if store, err = openStore(*databaseFlag, writePolicy); err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid -db: ", err)
+			os.Exit(1)
+		}
+	}
+	if *redisFlag != "" && *memcachedFlag != "" {
+		fmt.Fprintln(os.Stderr, "Only one of -redis and -memcached may be specified")
+		os.Exit(1)
+	}
+	if *disableCacheFlag && (*redisFlag != "" || *memcachedFlag != "" || *cachePathFlag != "" || *prewarmFlag != "") {
+		fmt.Fprintln(os.Stderr, "-disableCache cannot be combined with -redis, -memcached, -cachePath, or -prewarm")
+		os.Exit(1)
+	}
+	cache, err := openCache(*redisFlag, *memcachedFlag, "")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid cache: ", err)
+		os.Exit(1)
+	}
+	var peers *peerGroup
+	if *peersFlag != "" {
+		if *redisFlag != "" || *memcachedFlag != "" || *disableCacheFlag || *peerSelfFlag == "" || *peerSecretFlag == "" {
+			fmt.Fprintln(os.Stderr, "-peers requires -peerSelf and -peerSecret, and cannot be combined with -redis, -memcached, or -disableCache")
+			os.Exit(1)
+		}
+		if peers, err = newPeerGroup(*peersFlag); err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid -peers: ", err)
+			os.Exit(1)
+		}
+		peers.maxEntries = cacheMaxEntries
+		cache = peers.cache("", *peerSelfFlag, *peersFlag, *peerSecretFlag)
+	}
+	var audit func(webrisk.AuditRecord) error
+	if *auditLogFlag != "" {
+		al, err := webrisk.OpenAuditLog(*auditLogFlag, 0, 0)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid -auditLog: ", err)
+			os.Exit(1)
+		}
+		defer al.Close()
+		audit = al.Record
+	}
+	audit = streams.audit("", audit)
+	detections, err := newDetectionLog(*detectionLogFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -detectionLog: ", err)
+		os.Exit(1)
+	}
+	conf := webrisk.Config{
+		APIKey:                   *apiKeyFlag,
+		ProxyURL:                 *proxyFlag,
+		MaxIdleConns:             maxIdleConns,
+		IdleConnTimeout:          idleConnTimeout,
+		KeepAlive:                keepAlive,
+		DisableHTTP2:             *disableHTTP2Flag,
+		Store:                    store,
+		ThreatListArg:            *threatTypesFlag,
+		IgnoreUnknownThreatTypes: *ignoreUnknownFlag,
+		Logger:                   os.Stderr,
+		PMinTTL:                  pminTTL,
+		NMinTTL:                  nminTTL,
+		PMinTTLs:                 pminTTLs,
+		NMinTTLs:                 nminTTLs,
+		TTLPolicy:                ttlPolicy,
+		CacheJitter:              cacheJitter,
+		IDNPolicy:                idnPolicy,
+		Schemes:                  schemes,
+		PublicSuffixes:           *publicSuffixFlag,
+		StrictURLs:               *strictURLsFlag,
+		IPHostThreat:             ipHostThreat,
+		MaxHostComponents:        maxHostComponents,
+		MaxPathComponents:        maxPathComponents,
+		ParallelMatchThreshold:   parallelMatchThreshold,
+		ShouldLogQueriesByAPI:    *logAPIQueriesFlag,
+		MemoryLimit:              memoryLimit,
+		CacheMaxEntries:          cacheMaxEntries,
+		CacheMaxBytes:            cacheMaxBytes,
+		CachePath:                *cachePathFlag,
+		StatsPath:                *statsPathFlag,
+		CacheRefreshWindow:       cacheRefreshWindow,
+		DisableCache:             *disableCacheFlag,
+		ReadOnly:                 *readOnlyFlag,
+		ReadOnlyIfLocked:         *readOnlyLockFlag,
+		ReloadPeriod:             reloadPeriod,
+		WritePolicy:              writePolicy,
+		Audit:                    audit,
+		MaxDatabaseAge:           maxDatabaseAge,
+		StalePolicy:              stalePolicy,
+		Events:                   notifications.hook(""),
+		CircuitBreakerThreshold:  breakerThreshold,
+		CircuitBreakerCooldown:   breakerCooldown,
+		SearchBatchWindow:        searchBatchWindow,
+		LookupDeadline:           lookupDeadline,
+		Cache:                    cache,
+		MetricsRecorder:          recorder,
+		MetricsPeriod:            metricsPeriod,
+	}
+	var wr *webrisk.UpdateClient
+	if *apiKeyFlag != "" || *readOnlyFlag {
+		wr, err = webrisk.NewUpdateClient(conf)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to initialize Web Risk client: ", err)
+			os.Exit(1)
+		}
+	}
+	tenants := make(map[string]*webrisk.UpdateClient)
+	tenantAuditLogs := make(map[string]*webrisk.AuditLog)
+	if *tenantsFlag != "" {
+		tcs, err := loadTenants(*tenantsFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid -tenants: ", err)
+			os.Exit(1)
+		}
+		for _, tc := range tcs {
+			tconf, al, err := tc.config(conf)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid tenant %q: %v\n", tc.Name, err)
+				os.Exit(1)
+			}
+			if al != nil {
+				tenantAuditLogs[tc.Name] = al
+			}
+			// Tenants share the cache servers, but not their entries.
+			if tconf.Cache, err = openCache(*redisFlag, *memcachedFlag, "webrisk:"+tc.Name+":"); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid cache for tenant %q: %v\n", tc.Name, err)
+				os.Exit(1)
+			}
+			if peers != nil {
+				tconf.Cache = peers.cache(tenantPrefix+tc.Name, *peerSelfFlag, *peersFlag, *peerSecretFlag)
+			}
+			tconf.Events = notifications.hook(tc.Name)
+			if recorder != nil {
+				tconf.MetricsRecorder, _ = newMetricsRecorder(*otlpEndpointFlag, *otlpHeadersFlag, *statsdFlag, *statsdTagsFlag, tc.Name)
+			}
+			if tenants[tc.Name], err = webrisk.NewUpdateClient(tconf); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to initialize Web Risk client for tenant %q: %v\n", tc.Name, err)
+				os.Exit(1)
+			}
+		}
+	}
+	if *selfTestFlag {
+		clients := map[string]*webrisk.UpdateClient{"": wr}
+		for name, t := range tenants {
+			clients[name] = t
+		}
+		os.Exit(runSelfTest(clients))
+	}
+	if *prewarmFlag != "" {
+		f, err := os.Open(*prewarmFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid -prewarm: ", err)
+			os.Exit(1)
+		}
+		urls, err := readURLList(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid -prewarm: ", err)
+			os.Exit(1)
+		}
+		clients := map[string]*webrisk.UpdateClient{"": wr}
+		for name, t := range tenants {
+			clients[name] = t
+		}
+		for name, c := range clients {
+			if c == nil || *readOnlyFlag {
+				continue
+			}
+			go prewarm(name, c, urls)
+		}
+	}
 	statikFS, err := fs.New()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Unable to initialize static files: ", err)
 		os.Exit(1)
 	}
 
-	srv := newServer(wr, statikFS)
-	exit, down := runServer(srv)
+	if *icapAddrFlag != "" {
+		icap = newICAPServer()
+	}
+	if *authzGRPCFlag {
+		extAuthzGRPC = newExtAuthzServer()
+	}
+	if *asyncSecretFlag != "" {
+		async = newAsyncScanner(*asyncSecretFlag, *asyncHostsFlag)
+	}
+	srv := newServer(wr, tenants, peers, detections, statikFS)
+	if peers != nil {
+		go peers.discover()
+	}
+	ln, inherited, err := listen(*srvAddrFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to listen: ", err)
+		os.Exit(1)
+	}
+	var icapLn net.Listener
+	if icap != nil {
+		if icapLn, _, err = listenFrom(icapListenerEnv, *icapAddrFlag); err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to listen for ICAP: ", err)
+			os.Exit(1)
+		}
+	}
+	clients := map[string]*webrisk.UpdateClient{"": wr}
+	for name, t := range tenants {
+		clients[name] = t
+	}
+	if inherited {
+		// Take over from the previous process once the databases are
+		// loaded.
+		if err := waitReady(clients); err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to take over: ", err)
+			os.Exit(1)
+		}
+	}
+	exit, down := runServer(srv, ln)
+	if icap != nil {
+		go func() {
+			if err := icap.serve(icapLn); err != nil {
+				log.Printf("ICAP server error: %v", err)
+			}
+		}()
+	}
 	signal.Notify(exit, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	handleHandoffs(ln, icapLn, clients, exit)
+	if err := notifyReady(); err != nil {
+		log.Printf("Unable to notify the previous process: %v", err)
+	}
 	<-down
+	icap.close()
+	async.close()
+	// Closing the clients saves their caches.
+	if wr != nil {
+		wr.Close()
+	}
+	for name, t := range tenants {
+		t.Close()
+		if al := tenantAuditLogs[name]; al != nil {
+			al.Close()
+		}
+	}
+	detections.close()
+	queries.close()
+	streams.close()
+	notifications.close()
 	fmt.Fprintln(os.Stdout, "wrserver exiting.")
 }
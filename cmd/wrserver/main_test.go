@@ -14,12 +14,44 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"html/template"
 	"io"
+	"log"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
+
+	"github.com/google/webrisk"
+	_ "github.com/google/webrisk/cmd/wrserver/statik"
+	"github.com/rakyll/statik/fs"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 // Provide an override hostname so that we can run the test within Docker's build step.
@@ -90,7 +122,7 @@ func TestServerShutdown(t *testing.T) {
 	}
 
 	// Start server and wait for it to be ready.
-	exit, down := runServer(testServer)
+	exit, down := runServer(testServer, nil)
 	time.Sleep(1 * time.Second)
 
 	// Open a test connection.
@@ -117,3 +149,2682 @@ func TestServerShutdown(t *testing.T) {
 		t.Errorf("Server accepted connection when it should be shut down.")
 	}
 }
+
+func TestParseByteSize(t *testing.T) {
+	vectors := []struct {
+		input  string
+		output int64
+		fail   bool
+	}{
+		{input: "", output: 0},
+		{input: "1024", output: 1024},
+		{input: "512B", output: 512},
+		{input: "64kb", output: 64 << 10},
+		{input: "256MB", output: 256 << 20},
+		{input: "2 GB", output: 2 << 30},
+		{input: "MB", fail: true},
+		{input: "-1MB", fail: true},
+		{input: "lots", fail: true},
+	}
+	for i, v := range vectors {
+		got, err := parseByteSize(v.input)
+		if (err != nil) != v.fail {
+			t.Errorf("test %d, parseByteSize(%q) error = %v, want failure %v", i, v.input, err, v.fail)
+			continue
+		}
+		if got != v.output {
+			t.Errorf("test %d, parseByteSize(%q) = %d, want %d", i, v.input, got, v.output)
+		}
+	}
+}
+
+func TestParseThreatTTLs(t *testing.T) {
+	vectors := []struct {
+		input  string
+		output map[webrisk.ThreatType]time.Duration
+		fail   bool
+	}{
+		{input: "", output: nil},
+		{input: "MALWARE=1h", output: map[webrisk.ThreatType]time.Duration{
+			webrisk.ThreatTypeMalware: time.Hour,
+		}},
+		{input: "MALWARE=1h, SOCIAL_ENGINEERING=5m", output: map[webrisk.ThreatType]time.Duration{
+			webrisk.ThreatTypeMalware:           time.Hour,
+			webrisk.ThreatTypeSocialEngineering: 5 * time.Minute,
+		}},
+		{input: "MALWARE", fail: true},
+		{input: "MALWARE=soon", fail: true},
+		{input: "MALWARE=-1m", fail: true},
+		{input: "EVIL=1h", fail: true},
+	}
+	for i, v := range vectors {
+		got, err := parseThreatTTLs(v.input)
+		if (err != nil) != v.fail {
+			t.Errorf("test %d, parseThreatTTLs(%q) error = %v, want failure %v", i, v.input, err, v.fail)
+			continue
+		}
+		if !reflect.DeepEqual(got, v.output) {
+			t.Errorf("test %d, parseThreatTTLs(%q) = %v, want %v", i, v.input, got, v.output)
+		}
+	}
+}
+
+func TestParseSchemes(t *testing.T) {
+	vectors := []struct {
+		input  string
+		output map[string]webrisk.SchemeAction
+		fail   bool
+	}{
+		{input: "", output: nil},
+		{input: "FTP=pass, *=reject, https=lookup", output: map[string]webrisk.SchemeAction{
+			"ftp":   webrisk.SchemePass,
+			"*":     webrisk.SchemeReject,
+			"https": webrisk.SchemeLookup,
+		}},
+		{input: "ftp", fail: true},
+		{input: "=pass", fail: true},
+		{input: "ftp=ignore", fail: true},
+	}
+	for i, v := range vectors {
+		got, err := parseSchemes(v.input)
+		if (err != nil) != v.fail {
+			t.Errorf("test %d, parseSchemes(%q) error = %v, want failure %v", i, v.input, err, v.fail)
+			continue
+		}
+		if !reflect.DeepEqual(got, v.output) {
+			t.Errorf("test %d, parseSchemes(%q) = %v, want %v", i, v.input, got, v.output)
+		}
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	stats := webrisk.Stats{
+		QueriesByCache:       3,
+		CachePositiveHits:    1,
+		CacheNegativeHits:    2,
+		CacheMisses:          4,
+		CacheExpired:         1,
+		CachePositiveEntries: 5,
+		CacheNegativeEntries: 6,
+		CacheMemory:          2560,
+		DatabaseUpdateLag:    1500 * time.Millisecond,
+		Lists: map[webrisk.ThreatType]webrisk.ListStats{
+			webrisk.ThreatTypeSocialEngineering: {Entries: 20},
+			webrisk.ThreatTypeMalware:           {Entries: 10},
+		},
+	}
+	var buf bytes.Buffer
+	writeMetrics(&buf, stats)
+	got := buf.String()
+	for _, want := range []string{
+		"# TYPE webrisk_queries_total counter\n",
+		`webrisk_queries_total{source="cache"} 3` + "\n",
+		`webrisk_cache_lookups_total{result="positive_hit"} 1` + "\n",
+		`webrisk_cache_lookups_total{result="negative_hit"} 2` + "\n",
+		`webrisk_cache_lookups_total{result="miss"} 4` + "\n",
+		"webrisk_cache_expired_total 1\n",
+		`webrisk_cache_entries{kind="positive"} 5` + "\n",
+		`webrisk_cache_entries{kind="negative"} 6` + "\n",
+		"webrisk_cache_memory_bytes 2560\n",
+		"webrisk_database_update_lag_seconds 1.5\n",
+		`webrisk_list_entries{threat_type="MALWARE"} 10` + "\n" +
+			`webrisk_list_entries{threat_type="SOCIAL_ENGINEERING"} 20` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("metrics missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestNewOTLPRecorder(t *testing.T) {
+	r, err := newOTLPRecorder("http://collector:4318", "Authorization=Bearer XXX, X-Scope-OrgID=acme", "acme")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.Endpoint != "http://collector:4318/v1/metrics" {
+		t.Errorf("Endpoint = %q, want the standard metrics path", r.Endpoint)
+	}
+	wantHeaders := map[string]string{"Authorization": "Bearer XXX", "X-Scope-OrgID": "acme"}
+	if !reflect.DeepEqual(r.Headers, wantHeaders) {
+		t.Errorf("Headers = %v, want %v", r.Headers, wantHeaders)
+	}
+	if r.Attributes["service.name"] != "wrserver" || r.Attributes["webrisk.tenant"] != "acme" {
+		t.Errorf("Attributes = %v, want the service and tenant names", r.Attributes)
+	}
+
+	if r, err := newOTLPRecorder("", "", ""); r != nil || err != nil {
+		t.Errorf("newOTLPRecorder() = %v, %v, want nothing without an endpoint", r, err)
+	}
+	if _, err := newOTLPRecorder("http://collector:4318", "Authorization", ""); err == nil {
+		t.Errorf("unexpected success with an invalid header")
+	}
+}
+
+func TestNewMetricsRecorder(t *testing.T) {
+	r, err := newMetricsRecorder("http://collector:4318", "", "statsd://statsd", "", "acme")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rs, ok := r.(metricsRecorders)
+	if !ok || len(rs) != 2 {
+		t.Fatalf("newMetricsRecorder() = %#v, want an OTLP and a StatsD recorder", r)
+	}
+	if sr, ok := rs[1].(*webrisk.StatsDRecorder); !ok || sr.Prefix != "webrisk.acme." {
+		t.Errorf("StatsD recorder = %#v, want one with the tenant in its prefix", rs[1])
+	}
+
+	r, err = newMetricsRecorder("", "", "dogstatsd://localhost:9125", "env:prod, region:eu", "acme")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sr, ok := r.(*webrisk.StatsDRecorder)
+	if !ok || sr.Addr != "localhost:9125" || sr.Prefix != "" || !reflect.DeepEqual(sr.Tags, []string{"env:prod", "region:eu", "tenant:acme"}) {
+		t.Errorf("newMetricsRecorder() = %#v, want a DogStatsD recorder with the tenant as a tag", r)
+	}
+
+	if r, err := newMetricsRecorder("", "", "", "", ""); r != nil || err != nil {
+		t.Errorf("newMetricsRecorder() = %v, %v, want nothing without a destination", r, err)
+	}
+	if _, err := newMetricsRecorder("", "", "udp://localhost:8125", "", ""); err == nil {
+		t.Errorf("unexpected success with an invalid StatsD URL")
+	}
+}
+
+func TestReadURLList(t *testing.T) {
+	input := "# Top URLs\nhttp://example.com/\n\n  example.org/a  \n#http://skipped.com/\n"
+	got, err := readURLList(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"http://example.com/", "example.org/a"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readURLList = %q, want %q", got, want)
+	}
+}
+
+func TestParseTenants(t *testing.T) {
+	vectors := []struct {
+		input string
+		names []string
+		fail  bool
+	}{
+		{input: `[]`},
+		{input: `[{"name": "acme", "apiKey": "k1"}, {"name": "globex-2", "apiKey": "k2", "threatTypes": "MALWARE"}]`, names: []string{"acme", "globex-2"}},
+		{input: `[{"name": "acme", "apiKey": "k1"}, {"name": "acme", "apiKey": "k2"}]`, fail: true},
+		{input: `[{"name": "acme"}]`, fail: true},
+		{input: `[{"name": "a/b", "apiKey": "k1"}]`, fail: true},
+		{input: `[{"name": "acme", "apiKey": "k1", "apikey2": "k2"}]`, fail: true},
+		{input: `{}`, fail: true},
+	}
+	for i, v := range vectors {
+		tcs, err := parseTenants(strings.NewReader(v.input))
+		if (err != nil) != v.fail {
+			t.Errorf("test %d, parseTenants error = %v, want failure %v", i, err, v.fail)
+			continue
+		}
+		var names []string
+		for _, tc := range tcs {
+			names = append(names, tc.Name)
+		}
+		if !reflect.DeepEqual(names, v.names) {
+			t.Errorf("test %d, tenant names = %v, want %v", i, names, v.names)
+		}
+	}
+}
+
+func TestTenantConfig(t *testing.T) {
+	var buf bytes.Buffer
+	base := webrisk.Config{
+		APIKey:        "root",
+		ThreatListArg: "ALL",
+		UpdatePeriod:  time.Hour,
+		MemoryLimit:   1 << 30,
+		Logger:        &buf,
+		Audit:         func(webrisk.AuditRecord) error { return nil },
+		CachePath:     "/var/cache/wrserver",
+	}
+	tc := tenantConfig{Name: "acme", APIKey: "k1", ThreatTypes: "MALWARE", UpdatePeriod: "10m"}
+	conf, al, err := tc.config(base)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conf.APIKey != "k1" || conf.ThreatListArg != "MALWARE" || conf.UpdatePeriod != 10*time.Minute || conf.MemoryLimit != 1<<30 {
+		t.Errorf("mismatching config: %+v", conf)
+	}
+	if conf.CachePath != "/var/cache/wrserver.acme" {
+		t.Errorf("CachePath = %q, want a per-tenant path", conf.CachePath)
+	}
+	if conf.Audit != nil || al != nil {
+		t.Errorf("audit log inherited by tenant without one")
+	}
+	log.New(conf.Logger, "", 0).Print("hello")
+	if got, want := buf.String(), "[acme] hello\n"; got != want {
+		t.Errorf("log output = %q, want %q", got, want)
+	}
+
+	// Tenants have their own cache TTL policy.
+	base.PMinTTL = time.Minute
+	tc.NMinTTL, tc.PMinTTLs = "5m", "MALWARE=1h"
+	if conf, _, err = tc.config(base); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantTTLs := map[webrisk.ThreatType]time.Duration{webrisk.ThreatTypeMalware: time.Hour}
+	if conf.PMinTTL != time.Minute || conf.NMinTTL != 5*time.Minute || !reflect.DeepEqual(conf.PMinTTLs, wantTTLs) || conf.NMinTTLs != nil {
+		t.Errorf("mismatching cache TTLs: %v, %v, %v, %v", conf.PMinTTL, conf.NMinTTL, conf.PMinTTLs, conf.NMinTTLs)
+	}
+	for _, bad := range []tenantConfig{{PMinTTL: "long"}, {NMinTTLs: "EVIL=1h"}} {
+		bad.Name, bad.APIKey = "acme", "k1"
+		if _, _, err := bad.config(base); err == nil {
+			t.Errorf("unexpected success with invalid TTLs: %+v", bad)
+		}
+	}
+
+	// The tenant's own audit log is returned to be closed with its client.
+	tc.AuditLog = filepath.Join(t.TempDir(), "audit.log")
+	if conf, al, err = tc.config(base); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if al == nil || conf.Audit == nil {
+		t.Fatalf("no audit log for tenant with one")
+	}
+	if err := conf.Audit(webrisk.AuditRecord{ThreatType: webrisk.ThreatTypeMalware}); err != nil {
+		t.Errorf("unexpected audit error: %v", err)
+	}
+	if err := al.Close(); err != nil {
+		t.Errorf("unexpected close error: %v", err)
+	}
+	if b, err := os.ReadFile(tc.AuditLog); err != nil || !bytes.Contains(b, []byte("MALWARE")) {
+		t.Errorf("audit log = (%q, %v), want the record", b, err)
+	}
+
+	tc.UpdatePeriod = "often"
+	if _, _, err := tc.config(base); err == nil {
+		t.Errorf("unexpected success with invalid updatePeriod")
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	vectors := []struct {
+		token  string // Configured admin token
+		header string // Authorization header sent
+		code   int    // Expected status code
+	}{
+		{token: "secret", header: "Bearer secret", code: http.StatusOK},
+		{token: "secret", header: "Bearer wrong", code: http.StatusUnauthorized},
+		{token: "secret", header: "secret", code: http.StatusUnauthorized},
+		{token: "secret", header: "", code: http.StatusUnauthorized},
+		{token: "", header: "Bearer ", code: http.StatusUnauthorized},
+	}
+	for i, v := range vectors {
+		h := requireAdmin(v.token, func(w http.ResponseWriter, r *http.Request) {})
+		req := httptest.NewRequest("POST", adminVerifyPath, nil)
+		if v.header != "" {
+			req.Header.Set("Authorization", v.header)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		if rec.Code != v.code {
+			t.Errorf("test %d, status code = %d, want %d", i, rec.Code, v.code)
+		}
+	}
+}
+
+func TestStatsReset(t *testing.T) {
+	api := newFakeAPI()
+	defer api.Close()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	lat := newLatencies("")
+
+	if _, err := wr.LookupURLs([]string{"http://safe.test/", "http://evil.test/"}); err != nil {
+		t.Fatalf("unexpected lookup error: %v", err)
+	}
+	lat.observe(findThreatPath, "database", time.Millisecond)
+
+	vectors := []struct {
+		method string
+		code   int
+		body   string
+	}{
+		{method: "GET", code: http.StatusBadRequest},
+		{method: "POST", code: http.StatusOK, body: `{"reset":true}`},
+	}
+	for i, v := range vectors {
+		rec := httptest.NewRecorder()
+		serveStatsReset(rec, httptest.NewRequest(v.method, adminStatsResetPath, nil), wr, lat)
+		if rec.Code != v.code {
+			t.Errorf("test %d, status code = %d, want %d", i, rec.Code, v.code)
+		}
+		if v.body != "" && rec.Body.String() != v.body {
+			t.Errorf("test %d, body = %q, want %q", i, rec.Body.String(), v.body)
+		}
+	}
+	if stats, _ := wr.Status(); stats.QueriesByDatabase != 0 || stats.QueriesByAPI != 0 || stats.CacheMisses != 0 {
+		t.Errorf("counters not reset: %+v", stats)
+	}
+	var buf bytes.Buffer
+	lat.write(&buf)
+	if buf.Len() != 0 {
+		t.Errorf("latencies not reset:\n%s", buf.String())
+	}
+}
+
+func TestShortPrefixes(t *testing.T) {
+	vectors := []struct {
+		in, want []string
+	}{
+		{},
+		{in: []string{"aaaa", "bbbb"}, want: []string{"aaaa", "bbbb"}},
+		{in: []string{"aaaa", "aaaab", "aaaac", "bbbbb"}, want: []string{"aaaa", "bbbb"}},
+	}
+	for i, v := range vectors {
+		if got := shortPrefixes(v.in); !reflect.DeepEqual(got, v.want) {
+			t.Errorf("test %d, shortPrefixes = %q, want %q", i, got, v.want)
+		}
+	}
+}
+
+func TestServePrefixes(t *testing.T) {
+	api := newFakeAPI()
+	defer api.Close()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	prefix := string(webrisk.HashExpression("evil.test/")[:4])
+
+	vectors := []struct {
+		method string
+		query  string
+		code   int
+		body   string
+	}{
+		{method: "POST", query: "list=MALWARE", code: http.StatusBadRequest},
+		{method: "GET", code: http.StatusBadRequest},
+		{method: "GET", query: "list=NOPE", code: http.StatusBadRequest},
+		{method: "GET", query: "list=MALWARE&format=json", code: http.StatusBadRequest},
+		{method: "GET", query: "list=MALWARE", code: http.StatusOK, body: prefix},
+		{method: "GET", query: "list=malware&format=binary", code: http.StatusOK, body: prefix},
+		{method: "GET", query: "list=MALWARE&format=hex", code: http.StatusOK, body: hex.EncodeToString([]byte(prefix)) + "\n"},
+	}
+	for i, v := range vectors {
+		rec := httptest.NewRecorder()
+		servePrefixes(rec, httptest.NewRequest(v.method, adminPrefixesPath+"?"+v.query, nil), wr)
+		if rec.Code != v.code {
+			t.Errorf("test %d, status code = %d, want %d", i, rec.Code, v.code)
+		}
+		if v.body != "" && rec.Body.String() != v.body {
+			t.Errorf("test %d, body = %q, want %q", i, rec.Body.String(), v.body)
+		}
+		if v.code == http.StatusOK && rec.Header().Get("X-Webrisk-Prefixes") != "1" {
+			t.Errorf("test %d, X-Webrisk-Prefixes = %q, want 1", i, rec.Header().Get("X-Webrisk-Prefixes"))
+		}
+	}
+
+	// The ETag stays the same until the list changes.
+	rec := httptest.NewRecorder()
+	servePrefixes(rec, httptest.NewRequest("GET", adminPrefixesPath+"?list=MALWARE", nil), wr)
+	req := httptest.NewRequest("GET", adminPrefixesPath+"?list=MALWARE", nil)
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	servePrefixes(rec, req, wr)
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("conditional request: status code = %d, body = %q, want %d and no body", rec.Code, rec.Body.String(), http.StatusNotModified)
+	}
+	req.URL.RawQuery = "list=MALWARE&format=hex"
+	rec = httptest.NewRecorder()
+	servePrefixes(rec, req, wr)
+	if rec.Code != http.StatusOK {
+		t.Errorf("conditional request in another format: status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestServeChanges(t *testing.T) {
+	defer func(d time.Duration) { changePollPeriod = d }(changePollPeriod)
+	changePollPeriod = 10 * time.Millisecond
+
+	evil := string(webrisk.HashExpression("evil.test/")[:4])
+	other := string(webrisk.HashExpression("other.test/")[:4])
+	lists := [][]string{{evil}, {evil, other}}
+	if other < evil {
+		lists[1] = []string{other, evil}
+	}
+	var updates int32
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "threatLists:computeDiff") {
+			fmt.Fprint(w, `{}`)
+			return
+		}
+		list := lists[0]
+		if atomic.AddInt32(&updates, 1) > int32(len(webrisk.DefaultThreatLists)) {
+			list = lists[1]
+		}
+		raw := strings.Join(list, "")
+		sum := sha256.Sum256([]byte(raw))
+		fmt.Fprintf(w, `{"responseType":"RESET","additions":{"rawHashes":[{"prefixSize":4,"rawHashes":%q}]},"newVersionToken":"dG9rZW4=","recommendedNextDiff":"2100-01-01T00:00:00Z","checksum":{"sha256":%q}}`,
+			base64.StdEncoding.EncodeToString([]byte(raw)), base64.StdEncoding.EncodeToString(sum[:]))
+	}))
+	defer api.Close()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveChanges(w, r, wr, done)
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	resp, err := http.Get(srv.URL + "?prefixes=true")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != mimeEventStream {
+		t.Errorf("Content-Type = %q, want %q", ct, mimeEventStream)
+	}
+	sc := bufio.NewScanner(resp.Body)
+	next := func() (string, listChange) {
+		var event string
+		var c listChange
+		for sc.Scan() && sc.Text() != "" {
+			if v := strings.TrimPrefix(sc.Text(), "event: "); v != sc.Text() {
+				event = v
+			} else if v := strings.TrimPrefix(sc.Text(), "data: "); v != sc.Text() {
+				if err := json.Unmarshal([]byte(v), &c); err != nil {
+					t.Fatalf("invalid event data %q: %v", v, err)
+				}
+			}
+		}
+		return event, c
+	}
+
+	for range webrisk.DefaultThreatLists {
+		if event, c := next(); event != "list" || c.Entries != 1 || c.Added != 0 || c.Additions != nil {
+			t.Errorf("initial event = %q %+v, want list with 1 entry", event, c)
+		}
+	}
+	if err := wr.UpdateDatabase(context.Background()); err != nil {
+		t.Fatalf("unexpected update error: %v", err)
+	}
+	want := []string{hex.EncodeToString([]byte(other))}
+	for range webrisk.DefaultThreatLists {
+		if event, c := next(); event != "change" || c.Entries != 2 || c.Added != 1 || c.Removed != 0 || !reflect.DeepEqual(c.Additions, want) {
+			t.Errorf("event after update = %q %+v, want change adding %q", event, c, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	serveChanges(rec, httptest.NewRequest("GET", adminChangesPath+"?prefixes=maybe", nil), wr, done)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid prefixes: status code = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	api := newFakeAPI()
+	defer api.Close()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+
+	vectors := []struct {
+		urls   map[webrisk.ThreatType]string
+		output string
+		err    string
+	}{{
+		urls:   map[webrisk.ThreatType]string{webrisk.ThreatTypeMalware: "http://evil.test/"},
+		output: "ok    MALWARE  http://evil.test/  found MALWARE (source api)\n",
+	}, {
+		urls: map[webrisk.ThreatType]string{
+			webrisk.ThreatTypeMalware:           "http://evil.test/",
+			webrisk.ThreatTypeSocialEngineering: "http://safe.test/",
+		},
+		output: "ok    MALWARE  http://evil.test/  found MALWARE (source cache)\n" +
+			"FAIL  SOCIAL_ENGINEERING  http://safe.test/  not found unsafe (source database)\n",
+		err: "1 of 2 test URLs not found unsafe",
+	}, {
+		urls: map[webrisk.ThreatType]string{webrisk.ThreatTypeUnspecified: "http://evil.test/"},
+		err:  "no test URLs",
+	}}
+	for i, v := range vectors {
+		var buf bytes.Buffer
+		err := selfTest(context.Background(), wr, v.urls, &buf)
+		if got := buf.String(); got != v.output {
+			t.Errorf("test %d, output = %q, want %q", i, got, v.output)
+		}
+		if (err == nil) != (v.err == "") || err != nil && !strings.Contains(err.Error(), v.err) {
+			t.Errorf("test %d, error = %v, want %q", i, err, v.err)
+		}
+	}
+}
+
+func TestServeEvents(t *testing.T) {
+	events := newEventHub("", nil)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveEvents(w, r, events)
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("Content-Type"); got != mimeNDJSON {
+		t.Errorf("Content-Type = %q, want %q", got, mimeNDJSON)
+	}
+
+	// The stream is subscribed to by the time the headers are sent.
+	if _, ok := newDetection("search", "192.0.2.1", "http://safe.com/", nil, webrisk.SourceDatabase); ok {
+		t.Errorf("unexpected detection without threats")
+	}
+	d, ok := newDetection("search", "192.0.2.1", "http://evil.com/", []webrisk.URLThreat{
+		{Pattern: "evil.com/", ThreatType: webrisk.ThreatTypeMalware},
+		{Pattern: "evil.com/a", ThreatType: webrisk.ThreatTypeMalware},
+	}, webrisk.SourceAPI)
+	if !ok {
+		t.Fatalf("missing detection")
+	}
+	d.Time = time.Unix(1451436338, 0).UTC()
+	events.publish(d)
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `{"time":"2015-12-30T00:45:38Z","endpoint":"search","client":"192.0.2.1","url":"http://evil.com/","threatTypes":["MALWARE"],"source":"api"}` + "\n"
+	if line != want {
+		t.Errorf("event = %q, want %q", line, want)
+	}
+
+	// Closing the hub ends the stream.
+	events.close()
+	if rest, err := io.ReadAll(r); err != nil || len(rest) > 0 {
+		t.Errorf("after close, read %q, %v, want end of stream", rest, err)
+	}
+}
+
+func TestNewDetectionLog(t *testing.T) {
+	vectors := []struct {
+		dests string
+		names []string
+		fail  bool
+	}{
+		{dests: "", names: nil},
+		{dests: "http://hooks.example/wr?token=x", names: []string{"http://hooks.example/wr"}},
+		{dests: "syslog://logs.example, syslog+tcp://logs.example:601", names: []string{"syslog://logs.example", "syslog+tcp://logs.example:601"}},
+		{dests: "syslog+unix:///dev/log", names: []string{"syslog+unix:///dev/log"}},
+		{dests: "syslog://logs.example?format=cef,syslog+unix:///dev/log?format=LEEF", names: []string{"syslog://logs.example", "syslog+unix:///dev/log"}},
+		{dests: "syslog://logs.example?format=xml", fail: true},
+		{dests: "ftp://logs.example", fail: true},
+		{dests: "syslog://", fail: true},
+		{dests: "https:///wr", fail: true},
+		{dests: "syslog+unix://", fail: true},
+	}
+	for i, v := range vectors {
+		l, err := newDetectionLog(v.dests)
+		if (err != nil) != v.fail {
+			t.Errorf("test %d, newDetectionLog(%q) error = %v, want failure %v", i, v.dests, err, v.fail)
+			continue
+		}
+		var names []string
+		if l != nil {
+			for _, q := range l.queues {
+				names = append(names, q.name)
+			}
+			l.close()
+		}
+		if !reflect.DeepEqual(names, v.names) {
+			t.Errorf("test %d, names = %q, want %q", i, names, v.names)
+		}
+	}
+}
+
+func TestDetectionLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "detections.log")
+	hooks := make(chan string, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		hooks <- string(body)
+	}))
+	defer hook.Close()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer pc.Close()
+
+	l, err := newDetectionLog(path + "," + hook.URL + ",syslog://" + pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events := newEventHub("acme", l)
+	d, _ := newDetection("redirect", "192.0.2.1", "http://evil.com/", []webrisk.URLThreat{
+		{Pattern: "evil.com/", ThreatType: webrisk.ThreatTypeSocialEngineering},
+	}, webrisk.SourceCache)
+	d.Time = time.Unix(1451436338, 0).UTC()
+	events.publish(d)
+	l.close()
+	l.record(d) // Ignored once closed.
+
+	want := `{"time":"2015-12-30T00:45:38Z","tenant":"acme","endpoint":"redirect","client":"192.0.2.1","url":"http://evil.com/","threatTypes":["SOCIAL_ENGINEERING"],"source":"cache"}`
+	if got, err := os.ReadFile(path); err != nil || string(got) != want+"\n" {
+		t.Errorf("file = %q, %v, want %q", got, err, want+"\n")
+	}
+	if got := <-hooks; got != want {
+		t.Errorf("webhook = %q, want %q", got, want)
+	}
+	buf := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	prefix := "<132>1 2015-12-30T00:45:38.000000Z "
+	suffix := fmt.Sprintf(" wrserver %d detection - %s", os.Getpid(), want)
+	if got := string(buf[:n]); !strings.HasPrefix(got, prefix) || !strings.HasSuffix(got, suffix) {
+		t.Errorf("syslog message = %q, want %q...%q", got, prefix, suffix)
+	}
+}
+
+func TestSyslogFormats(t *testing.T) {
+	d, _ := newDetection("search", "192.0.2.1", "http://evil.com/a=b^c|d", []webrisk.URLThreat{
+		{Pattern: "evil.com/", ThreatType: webrisk.ThreatTypeMalware},
+		{Pattern: "evil.com/", ThreatType: webrisk.ThreatTypeSocialEngineering},
+	}, webrisk.SourceAPI)
+	d.Time = time.Unix(1451436338, 0).UTC()
+	d.Tenant = "acme"
+	version := webrisk.Version()
+
+	want := "CEF:0|Google|wrserver|" + version + "|detection|Unsafe URL|8|rt=1451436338000 src=192.0.2.1 request=http://evil.com/a\\=b^c|d " +
+		"cs1Label=threatTypes cs1=MALWARE,SOCIAL_ENGINEERING cs2Label=source cs2=api cs3Label=endpoint cs3=search cs4Label=tenant cs4=acme"
+	if got := string(cef(d)); got != want {
+		t.Errorf("cef() = %q, want %q", got, want)
+	}
+	want = "LEEF:2.0|Google|wrserver|" + version + "|detection|^|devTime=1451436338000^sev=8^src=192.0.2.1^url=http://evil.com/a=b%5Ec|d" +
+		"^cat=MALWARE,SOCIAL_ENGINEERING^source=api^endpoint=search^tenant=acme"
+	if got := string(leef(d)); got != want {
+		t.Errorf("leef() = %q, want %q", got, want)
+	}
+
+	s := newSyslogSink("udp", "127.0.0.1:514", syslogCEF)
+	msg, err := s.format(d)
+	if err != nil || !strings.HasPrefix(string(msg), "<132>1 2015-12-30T00:45:38.000000Z ") || !strings.HasSuffix(string(msg), " detection - "+string(cef(d))) {
+		t.Errorf("format() = %q, %v, want a CEF message", msg, err)
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	if n, err := newNotifier(" "); n != nil || err != nil {
+		t.Errorf("newNotifier without URLs = (%v, %v), want (nil, nil)", n, err)
+	}
+	if _, err := newNotifier("syslog://logs.example"); err == nil {
+		t.Errorf("unexpected success for a non-HTTP webhook")
+	}
+	if hook := (*notifier)(nil).hook("acme"); hook != nil {
+		t.Errorf("nil notifier returned a hook")
+	}
+
+	bodies := make(chan string, 2)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- string(body)
+	}))
+	defer hook.Close()
+	n, err := newNotifier(hook.URL + "/a," + hook.URL + "/b")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	n.host = "wrserver-1"
+	n.hook("acme")(webrisk.Event{
+		Time:    time.Unix(1451436338, 0).UTC(),
+		Type:    webrisk.EventUpdateFailed,
+		Message: "database update failed",
+	})
+	n.close()
+	n.hook("acme")(webrisk.Event{Type: webrisk.EventStale}) // Ignored once closed.
+
+	want := `{"time":"2015-12-30T00:45:38Z","type":"update_failed","message":"database update failed","host":"wrserver-1","tenant":"acme"}`
+	for i := 0; i < 2; i++ {
+		if got := <-bodies; got != want {
+			t.Errorf("notification %d = %q, want %q", i, got, want)
+		}
+	}
+	if len(bodies) > 0 {
+		t.Errorf("unexpected notification after close: %q", <-bodies)
+	}
+}
+
+func TestEventStreams(t *testing.T) {
+	if s, err := newEventStreams(" , "); s != nil || err != nil {
+		t.Errorf("newEventStreams without brokers = (%v, %v), want (nil, nil)", s, err)
+	}
+	for _, dest := range []string{"nats://localhost", "nats://localhost/a b", "pubsub://project", "pubsub://project/a/b", "kafka+http:///topic", "kafka://localhost/topic"} {
+		if _, err := newEventStreams(dest); err == nil {
+			t.Errorf("newEventStreams(%q): unexpected success", dest)
+		}
+	}
+	var s *eventStreams
+	s.detection(detection{})
+	if audit := s.audit("", nil); audit != nil {
+		t.Errorf("nil event streams returned an audit function")
+	}
+
+	// Fake NATS server, which pings the publisher once connected.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer ln.Close()
+	lines := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "INFO {\"server_id\":\"test\"}\r\n")
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			switch f := strings.Fields(line); {
+			case strings.HasPrefix(line, "CONNECT "):
+				lines <- "CONNECT"
+				io.WriteString(conn, "PING\r\n")
+			case len(f) == 3 && f[0] == "PUB":
+				n, _ := strconv.Atoi(f[2])
+				payload := make([]byte, n+2)
+				if _, err := io.ReadFull(r, payload); err != nil {
+					return
+				}
+				lines <- f[1] + " " + string(payload[:n])
+			default:
+				lines <- line
+			}
+		}
+	}()
+
+	bodies := make(chan string, 10)
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- r.Method + " " + r.URL.Path + " " + r.Header.Get("Content-Type") + " " + string(body)
+	}))
+	defer broker.Close()
+	t.Setenv("PUBSUB_EMULATOR_HOST", strings.TrimPrefix(broker.URL, "http://"))
+
+	s, err = newEventStreams("nats://" + ln.Addr().String() + "/webrisk, pubsub://project/topic," + strings.Replace(broker.URL, "http", "kafka+http", 1) + "/proxy/events")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.host = "wrserver-1"
+	d := detection{Time: time.Unix(1451436338, 0).UTC(), Tenant: "acme", Endpoint: "search", URL: "http://evil.test/", ThreatTypes: []webrisk.ThreatType{webrisk.ThreatTypeMalware}, Source: "api"}
+	s.detection(d)
+	var audited []webrisk.AuditRecord
+	s.audit("acme", func(r webrisk.AuditRecord) error {
+		audited = append(audited, r)
+		return nil
+	})(webrisk.AuditRecord{Time: d.Time, ThreatType: webrisk.ThreatTypeMalware, ResponseType: "DIFF", Added: 2, Entries: 5, ChecksumOK: true})
+	if len(audited) != 1 {
+		t.Errorf("audit records passed on = %d, want 1", len(audited))
+	}
+
+	detectionJSON := `{"kind":"detection","host":"wrserver-1","tenant":"acme","detection":{"time":"2015-12-30T00:45:38Z","tenant":"acme","endpoint":"search","url":"http://evil.test/","threatTypes":["MALWARE"],"source":"api"}}`
+	updateJSON := `{"kind":"update","host":"wrserver-1","tenant":"acme","update":{"time":"2015-12-30T00:45:38Z","threatType":"MALWARE","responseType":"DIFF","oldVersion":null,"newVersion":null,"added":2,"removed":0,"entries":5,"checksum":null,"checksumOK":true}}`
+	want := map[string]bool{"CONNECT": true, "PONG": true}
+	for kind, event := range map[string]string{"detection": detectionJSON, "update": updateJSON} {
+		want["webrisk."+kind+" "+event] = true
+		want["POST /proxy/topics/events "+mimeKafkaJSON+` {"records":[{"key":"`+kind+`","value":`+event+`}]}`] = true
+		want["POST /v1/projects/project/topics/topic:publish "+mimeJSON+` {"messages":[{"data":"`+base64.StdEncoding.EncodeToString([]byte(event))+`","attributes":{"kind":"`+kind+`"}}]}`] = true
+	}
+	for i := 0; i < len(want); i++ {
+		var got string
+		select {
+		case got = <-lines:
+		case got = <-bodies:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for events")
+		}
+		if !want[got] {
+			t.Errorf("unexpected message: %q", got)
+		}
+	}
+	s.close()
+	s.detection(d) // Ignored once closed.
+	if len(bodies) > 0 {
+		t.Errorf("unexpected message after close: %q", <-bodies)
+	}
+}
+
+// fakeStatus is a statusReporter that reports err.
+type fakeStatus struct{ err error }
+
+func (f fakeStatus) Status() (webrisk.Stats, error) { return webrisk.Stats{}, f.err }
+
+func TestServeReady(t *testing.T) {
+	vectors := []struct {
+		clients map[string]statusReporter
+		code    int
+		body    string
+	}{
+		{map[string]statusReporter{"": fakeStatus{}}, http.StatusOK, "ok\n"},
+		{map[string]statusReporter{"": fakeStatus{}, "acme": fakeStatus{}}, http.StatusOK, "ok\n"},
+		{map[string]statusReporter{
+			"":     fakeStatus{errors.New("webrisk: threat list is stale")},
+			"acme": fakeStatus{},
+			"beta": fakeStatus{errors.New("unavailable")},
+		}, http.StatusServiceUnavailable, "webrisk: threat list is stale\ntenant beta: unavailable\n"},
+	}
+	for i, v := range vectors {
+		rec := httptest.NewRecorder()
+		serveReady(rec, httptest.NewRequest("GET", readyPath, nil), v.clients)
+		if rec.Code != v.code || rec.Body.String() != v.body {
+			t.Errorf("test %d, serveReady = (%d, %q), want (%d, %q)", i, rec.Code, rec.Body.String(), v.code, v.body)
+		}
+	}
+}
+
+func TestLatencies(t *testing.T) {
+	lat := newLatencies("")
+	var buf bytes.Buffer
+	lat.write(&buf)
+	if buf.Len() > 0 {
+		t.Errorf("unexpected histograms without requests: %q", buf.String())
+	}
+
+	lat.observe("search", "database", 300*time.Microsecond)
+	lat.observe("search", "database", time.Millisecond)
+	lat.observe("search", "api", 2*time.Minute)
+	// Requests that did not get to a lookup are not measured.
+	timer, _ := lat.start("redirect", httptest.NewRequest("GET", "/r", nil))
+	timer.done()
+
+	lat.write(&buf)
+	got := buf.String()
+	for _, want := range []string{
+		"# TYPE webrisk_request_duration_seconds histogram\n",
+		`webrisk_request_duration_seconds_bucket{endpoint="search",source="database",le="0.0005"} 1` + "\n",
+		`webrisk_request_duration_seconds_bucket{endpoint="search",source="database",le="0.001"} 2` + "\n",
+		`webrisk_request_duration_seconds_bucket{endpoint="search",source="database",le="+Inf"} 2` + "\n",
+		`webrisk_request_duration_seconds_sum{endpoint="search",source="database"} 0.0013` + "\n",
+		`webrisk_request_duration_seconds_count{endpoint="search",source="database"} 2` + "\n",
+		`webrisk_request_duration_seconds_bucket{endpoint="search",source="api",le="60"} 0` + "\n",
+		`webrisk_request_duration_seconds_bucket{endpoint="search",source="api",le="+Inf"} 1` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("histograms missing %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "redirect") {
+		t.Errorf("unexpected histogram of a request without lookup:\n%s", got)
+	}
+	if strings.Index(got, `source="api"`) > strings.Index(got, `source="database"`) {
+		t.Errorf("histograms are not sorted:\n%s", got)
+	}
+}
+
+func TestQueryLog(t *testing.T) {
+	const rawURL = "http://user@evil.example:8080/a/b?q=1#frag"
+	vectors := []struct {
+		rate float64
+		urls string
+		key  string
+		url  string
+		fail bool
+	}{
+		{rate: 1, urls: "", url: "http://evil.example:8080/"},
+		{rate: 1, urls: "host", url: "http://evil.example:8080/"},
+		{rate: 1, urls: "path", url: "http://evil.example:8080/a/b"},
+		{rate: 1, urls: "full", url: rawURL},
+		{rate: 1, urls: "hash", url: "a45b0e4354df2a33a1af89b779d7244308259f72960357cf58936e57d8cbc7fa"},
+		{rate: 0.5, urls: "hash", key: "secret", url: "06411420a83e24bb6c71a982a64166ed6db4e5749b10b2f1aedc9f4dc9852512"},
+		{rate: 0, fail: true},
+		{rate: 1.5, fail: true},
+		{rate: 1, urls: "domain", fail: true},
+	}
+	for i, v := range vectors {
+		q, err := newQueryLog(filepath.Join(t.TempDir(), "queries.log"), v.rate, v.urls, v.key)
+		if (err != nil) != v.fail {
+			t.Errorf("test %d, newQueryLog error = %v, want failure %v", i, err, v.fail)
+			continue
+		}
+		if q == nil {
+			continue
+		}
+		if got := q.url(rawURL); got != v.url {
+			t.Errorf("test %d, url = %q, want %q", i, got, v.url)
+		}
+		q.close()
+	}
+
+	if q, err := newQueryLog("", 1, "", ""); q != nil || err != nil {
+		t.Errorf("newQueryLog without a path = %v, %v, want nil", q, err)
+	}
+	// The query log is optional.
+	var none *queryLog
+	none.record("", "search", rawURL, nil, "database")
+	none.close()
+
+	path := filepath.Join(t.TempDir(), "queries.log")
+	q, err := newQueryLog(path, 1, "path", "")
+	if err != nil {
+		t.Fatalf("unexpected newQueryLog error: %v", err)
+	}
+	q.record("acme", "search", rawURL, []webrisk.URLThreat{
+		{Pattern: "evil.example/", ThreatType: webrisk.ThreatTypeMalware},
+		{Pattern: "evil.example/a/", ThreatType: webrisk.ThreatTypeMalware},
+	}, "api")
+	q.record("", "redirect", "http://example.com/?id=1", nil, "cache")
+	q.close()
+	q.record("", "search", rawURL, nil, "database")
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected ReadFile error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d queries, want 2:\n%s", len(lines), b)
+	}
+	for i, want := range []string{
+		`"tenant":"acme","endpoint":"search","url":"http://evil.example:8080/a/b","threatTypes":["MALWARE"],"source":"api"}`,
+		`"endpoint":"redirect","url":"http://example.com/","source":"cache"}`,
+	} {
+		if !strings.HasSuffix(lines[i], want) {
+			t.Errorf("query %d = %s, want suffix %s", i, lines[i], want)
+		}
+	}
+}
+
+func TestNewCachedHash(t *testing.T) {
+	now := time.Unix(1451436338, 0)
+	malware := []webrisk.ThreatType{webrisk.ThreatTypeMalware}
+	vectors := []struct {
+		ch     webrisk.CachedHash
+		result string
+	}{
+		{ch: webrisk.CachedHash{}, result: "database"},
+		{ch: webrisk.CachedHash{DatabaseThreats: malware}, result: "miss"},
+		{ch: webrisk.CachedHash{
+			DatabaseThreats: malware,
+			Threats:         map[webrisk.ThreatType]time.Time{webrisk.ThreatTypeMalware: now.Add(time.Minute)},
+		}, result: "positive"},
+		{ch: webrisk.CachedHash{
+			DatabaseThreats: malware,
+			Threats:         map[webrisk.ThreatType]time.Time{webrisk.ThreatTypeMalware: now.Add(-time.Minute)},
+			NegativePrefix:  []byte("aaaa"),
+			NegativeExpire:  now.Add(time.Minute),
+		}, result: "expired"},
+		{ch: webrisk.CachedHash{
+			DatabaseThreats: malware,
+			NegativePrefix:  []byte("aaaa"),
+			NegativeExpire:  now.Add(time.Minute),
+		}, result: "negative"},
+		{ch: webrisk.CachedHash{
+			DatabaseThreats: malware,
+			NegativePrefix:  []byte("aaaa"),
+			NegativeExpire:  now.Add(-time.Minute),
+		}, result: "expired"},
+	}
+	for i, v := range vectors {
+		if got := newCachedHash(v.ch, now); got.Result != v.result {
+			t.Errorf("test %d, result = %q, want %q", i, got.Result, v.result)
+		}
+	}
+
+	got := newCachedHash(webrisk.CachedHash{
+		DatabaseThreats: malware,
+		NegativePrefix:  []byte("aaaa"),
+		NegativeExpire:  now.Add(90 * time.Second),
+	}, now)
+	if got.NegativePrefix != "61616161" || got.NegativeTTL != 90 {
+		t.Errorf("negative entry = (%q, %v), want (%q, %v)", got.NegativePrefix, got.NegativeTTL, "61616161", 90)
+	}
+}
+
+func TestPeerGroup(t *testing.T) {
+	pg, err := newPeerGroup("http://10.0.0.1:8080/, http://10.0.0.2:8080")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pc := pg.cache("/t/acme", "http://10.0.0.1:8080", "http://10.0.0.1:8080/, http://10.0.0.2:8080", "secret")
+	if want := []string{"http://10.0.0.1:8080/t/acme", "http://10.0.0.2:8080/t/acme"}; !reflect.DeepEqual(pc.Peers(), want) {
+		t.Errorf("Peers() = %q, want %q", pc.Peers(), want)
+	}
+	if pc.Self != "http://10.0.0.1:8080/t/acme" || pc.Secret != "secret" || pg.caches["/t/acme"] != pc {
+		t.Errorf("mismatching peer cache: %+v", pc)
+	}
+
+	pg, err = newPeerGroup("dns://wrserver.default.svc:8080")
+	if err != nil || pg.host != "wrserver.default.svc" || pg.port != "8080" {
+		t.Errorf("newPeerGroup = (%+v, %v), want host and port", pg, err)
+	}
+	if pc := pg.cache("", "http://10.0.0.1:8080", "dns://wrserver.default.svc:8080", ""); len(pc.Peers()) != 0 {
+		t.Errorf("peers set before discovery: %q", pc.Peers())
+	}
+	if _, err := newPeerGroup("dns://wrserver"); err == nil {
+		t.Errorf("unexpected success without a port")
+	}
+}
+
+func TestNewURLExplanation(t *testing.T) {
+	malware := []webrisk.ThreatType{webrisk.ThreatTypeMalware}
+	got := newURLExplanation(&webrisk.URLExplanation{
+		URL:          "http://a.b/",
+		CanonicalURL: "http://a.b/",
+		Expressions: []webrisk.Expression{{
+			Pattern:         "a.b/",
+			Hash:            []byte("aaaaa"),
+			HashPrefix:      []byte("aaaa"),
+			DatabasePrefix:  []byte("aaaa"),
+			DatabaseThreats: malware,
+			Threats:         malware,
+		}, {
+			Pattern:    "a.b/c",
+			Hash:       []byte("bbbbb"),
+			HashPrefix: []byte("bbbb"),
+		}},
+	})
+	want := urlExplanation{
+		URL:          "http://a.b/",
+		CanonicalURL: "http://a.b/",
+		Scheme:       "lookup",
+		Expressions: []expression{{
+			Expression:      "a.b/",
+			Hash:            "6161616161",
+			HashPrefix:      "61616161",
+			DatabasePrefix:  "61616161",
+			DatabaseThreats: malware,
+			Threats:         malware,
+			Matched:         true,
+		}, {
+			Expression:      "a.b/c",
+			Hash:            "6262626262",
+			HashPrefix:      "62626262",
+			DatabaseThreats: []webrisk.ThreatType{},
+			Threats:         []webrisk.ThreatType{},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("newURLExplanation = %+v, want %+v", got, want)
+	}
+}
+
+func TestHeuristics(t *testing.T) {
+	h, err := newHeuristics(true, "paypal.com, Example.co.uk", 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	vectors := []struct {
+		url        string
+		suspicious bool
+	}{
+		{url: "https://www.paypal.com/signin", suspicious: false},
+		{url: "https://paypal.com/", suspicious: false},
+		{url: "https://paypal.com@evil.test/", suspicious: true},
+		{url: "https://paypa1.com/", suspicious: true},
+		{url: "https://xn--pypal-4ve.com/", suspicious: true}, // Cyrillic a
+		{url: "https://paypal.com.evil.test/", suspicious: true},
+		{url: "https://secure-paypal.net/", suspicious: true},
+		{url: "https://examp1e.co.uk/", suspicious: true},
+		{url: "https://www.example.co.uk/", suspicious: false},
+		{url: "https://a.b.c.evil.test/", suspicious: false},
+		{url: "https://a.b.c.d.evil.test/", suspicious: true},
+		{url: "https://a.b.c.d.evil.co.uk/", suspicious: true},
+		{url: "https://a.b.c.evil.co.uk/", suspicious: false},
+		{url: "https://192.168.0.1/", suspicious: false},
+		{url: "https://golang.org/", suspicious: false},
+	}
+	for i, v := range vectors {
+		u, err := url.Parse(v.url)
+		if err != nil {
+			t.Fatalf("test %d, unexpected error: %v", i, err)
+		}
+		if reason := h.check(u); (reason != "") != v.suspicious {
+			t.Errorf("test %d, check(%q) = %q, want suspicious %v", i, v.url, reason, v.suspicious)
+		}
+	}
+
+	if h, err := newHeuristics(false, "", 0); h != nil || err != nil {
+		t.Errorf("newHeuristics without checks = (%v, %v), want (nil, nil)", h, err)
+	}
+	if _, err := newHeuristics(false, "www.paypal.com", 0); err == nil {
+		t.Errorf("unexpected success with a brand that is not a registrable domain")
+	}
+
+	// The softer interstitial is part of the static files.
+	statikFS, err := fs.New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tmpl, err := parseTemplates(statikFS, template.New("test"), suspiciousTemplate, "/interstitial.html")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	u, _ := url.Parse("https://paypa1.com/")
+	if err := tmpl.Execute(&buf, map[string]any{"Reason": h.check(u), "Url": u}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "looks like paypal.com") {
+		t.Errorf("interstitial does not give the reason: %s", buf.String())
+	}
+}
+
+func TestSB4FullHashes(t *testing.T) {
+	full := webrisk.HashExpression("testsafebrowsing.appspot.com/s/malware.html")
+	other := webrisk.HashExpression("evil.com/")
+	threats := [][]webrisk.URLThreat{
+		{
+			{Pattern: hex.EncodeToString(full), ThreatType: webrisk.ThreatTypeMalware},
+			{Pattern: hex.EncodeToString(other), ThreatType: webrisk.ThreatTypeSocialEngineering},
+		},
+		{
+			{Pattern: hex.EncodeToString(full), ThreatType: webrisk.ThreatTypeMalware},
+			{Pattern: hex.EncodeToString(full[:4]), ThreatType: webrisk.ThreatTypeMalware},
+		},
+	}
+
+	body := `{"client": {"clientId": "test"}, "threatInfo": {"threatTypes": ["MALWARE", "POTENTIALLY_HARMFUL_APPLICATION"],
+		"platformTypes": ["WINDOWS", "LINUX"], "threatEntryTypes": ["URL"], "threatEntries": [{"hash": "WwuJdQ=="}, {"hash": "WwuJdQ=="}]}}`
+	req := httptest.NewRequest("POST", sb4FullHashesPath, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	var sbReq sb4FindFullHashesRequest
+	if err := decodeSB4(req, &sbReq); err != nil {
+		t.Fatalf("decodeSB4 = %v", err)
+	}
+	if e := sbReq.ThreatInfo.ThreatEntries; len(e) != 2 || !bytes.Equal(e[0].Hash, full[:4]) {
+		t.Fatalf("decoded threat entries %v, want prefix %x", e, full[:4])
+	}
+
+	// The other threat type is not asked for, and the hash prefix is not a
+	// full hash.
+	got := newSB4FullHashesResponse(sbReq.ThreatInfo, threats)
+	var want []sb4ThreatMatch
+	for _, platform := range []string{"WINDOWS", "LINUX"} {
+		want = append(want, sb4ThreatMatch{
+			ThreatType:      "MALWARE",
+			PlatformType:    platform,
+			ThreatEntryType: "URL",
+			Threat:          sb4ThreatEntry{Hash: full},
+			CacheDuration:   sb4CacheDuration,
+		})
+	}
+	if !reflect.DeepEqual(got.Matches, want) {
+		t.Errorf("matches = %+v, want %+v", got.Matches, want)
+	}
+
+	// Without threat or platform types, every threat matches once.
+	got = newSB4FullHashesResponse(sb4ThreatInfo{}, threats)
+	if len(got.Matches) != 2 || got.Matches[1].PlatformType != sb4AnyPlatform || got.Matches[1].ThreatType != "SOCIAL_ENGINEERING" {
+		t.Errorf("matches without types = %+v", got.Matches)
+	}
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", sb4FullHashesPath, nil),
+		httptest.NewRequest("POST", sb4FullHashesPath+"?alt=proto", strings.NewReader(body)),
+		httptest.NewRequest("POST", sb4FullHashesPath, strings.NewReader(`{"threatInfo": {"threatEntries": [{"hash": "not base64"}]}}`)),
+	} {
+		if err := decodeSB4(req, &sbReq); err == nil {
+			t.Errorf("decodeSB4(%s %s): unexpected success", req.Method, req.URL)
+		}
+	}
+}
+
+func TestSB4Diff(t *testing.T) {
+	vectors := []struct {
+		old, cur  []string
+		removals  []int32
+		additions []string
+	}{
+		{},
+		{cur: []string{"aaaa", "bbbb"}, additions: []string{"aaaa", "bbbb"}},
+		{old: []string{"aaaa", "bbbb"}, removals: []int32{0, 1}},
+		{old: []string{"aaaa", "bbbb"}, cur: []string{"aaaa", "bbbb"}},
+		{
+			old:       []string{"aaaa", "bbbb", "cccc", "dddd"},
+			cur:       []string{"aaaa", "bbbbb", "cccc", "eeee"},
+			removals:  []int32{1, 3},
+			additions: []string{"bbbbb", "eeee"},
+		},
+	}
+	for i, v := range vectors {
+		removals, additions := sb4Diff(v.old, v.cur)
+		if !reflect.DeepEqual(removals, v.removals) || !reflect.DeepEqual(additions, v.additions) {
+			t.Errorf("test %d, sb4Diff = (%v, %q), want (%v, %q)", i, removals, additions, v.removals, v.additions)
+		}
+	}
+}
+
+func TestSB4ListUpdate(t *testing.T) {
+	sum := func(prefixes ...string) []byte {
+		h := sha256.Sum256([]byte(strings.Join(prefixes, "")))
+		return h[:]
+	}
+	v1 := &webrisk.ListSnapshot{Prefixes: []string{"aaaa", "bbbb", "cccc"}, SHA256: sum("aaaa", "bbbb", "cccc")}
+	v2 := &webrisk.ListSnapshot{Prefixes: []string{"aaaa", "bbbbbb", "cccc", "dddd"}, SHA256: sum("aaaa", "bbbbbb", "cccc", "dddd")}
+	l := newSB4Lists(nil)
+	l.versions[webrisk.ThreatTypeMalware] = []*webrisk.ListSnapshot{v1, v2}
+	req := sb4ListUpdateRequest{sb4ListDescriptor: sb4ListDescriptor{ThreatType: "MALWARE"}}
+
+	// Clients without a state, or one that is not kept, get the full list.
+	for _, state := range [][]byte{nil, []byte("unknown")} {
+		req.State = state
+		got := l.update(req, webrisk.ThreatTypeMalware, v2)
+		want := sb4ListUpdateResponse{
+			sb4ListDescriptor: sb4ListDescriptor{ThreatType: "MALWARE", PlatformType: sb4AnyPlatform, ThreatEntryType: "URL"},
+			ResponseType:      "FULL_UPDATE",
+			Additions: []sb4ThreatEntrySet{
+				{CompressionType: "RAW", RawHashes: &sb4RawHashes{PrefixSize: 4, RawHashes: []byte("aaaaccccdddd")}},
+				{CompressionType: "RAW", RawHashes: &sb4RawHashes{PrefixSize: 6, RawHashes: []byte("bbbbbb")}},
+			},
+			NewClientState: v2.SHA256,
+			Checksum:       sb4Checksum{SHA256: v2.SHA256},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("update from %q = %+v, want %+v", state, got, want)
+		}
+	}
+
+	// Clients at a previous version get what changed since.
+	req.State = v1.SHA256
+	got := l.update(req, webrisk.ThreatTypeMalware, v2)
+	if got.ResponseType != "PARTIAL_UPDATE" ||
+		!reflect.DeepEqual(got.Removals, []sb4ThreatEntrySet{{CompressionType: "RAW", RawIndices: &sb4RawIndices{Indices: []int32{1}}}}) ||
+		!reflect.DeepEqual(got.Additions, sb4RawAdditions([]string{"bbbbbb", "dddd"})) {
+		t.Errorf("update from the previous version = %+v", got)
+	}
+
+	// Clients at the current version get nothing.
+	req.State = v2.SHA256
+	got = l.update(req, webrisk.ThreatTypeMalware, v2)
+	if got.ResponseType != "PARTIAL_UPDATE" || got.Additions != nil || got.Removals != nil || !bytes.Equal(got.NewClientState, v2.SHA256) {
+		t.Errorf("update from the current version = %+v", got)
+	}
+}
+
+func TestRPZZone(t *testing.T) {
+	z := newRPZZone("rpz.test.", time.Hour)
+	z.addThreats([]webrisk.URLThreat{
+		{Pattern: "evil.test/", ThreatType: webrisk.ThreatTypeMalware},
+		{Pattern: "evil.test/", ThreatType: webrisk.ThreatTypeSocialEngineering},
+		{Pattern: "example.test/bad/", ThreatType: webrisk.ThreatTypeMalware},
+		{Pattern: "192.0.2.1/", ThreatType: webrisk.ThreatTypeMalware},
+	})
+	z.addHeuristic("PayPa1.Test", "looks like paypal")
+	z.addHeuristic("xn--pypal-4ve.test", "looks like paypal")
+	z.addHeuristic("bad host.test", "invalid")
+	z.addHeuristic("[2001:db8::1]", "invalid")
+
+	var buf bytes.Buffer
+	if err := z.write(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) < 4 || lines[0] != "$ORIGIN rpz.test." || !strings.HasPrefix(lines[2], "@ SOA ") {
+		t.Fatalf("unexpected zone header: %q", lines)
+	}
+	got := lines[4:]
+	want := []string{
+		"evil.test CNAME . ; MALWARE,SOCIAL_ENGINEERING",
+		"*.evil.test CNAME . ; MALWARE,SOCIAL_ENGINEERING",
+		"paypa1.test CNAME . ; looks like paypal",
+		"xn--pypal-4ve.test CNAME . ; looks like paypal",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records = %q, want %q", got, want)
+	}
+
+	// The serial only increases when the zone changes.
+	serial := z.serial
+	z.addHeuristic("paypa1.test", "looks like paypal")
+	if z.serial != serial {
+		t.Errorf("serial changed from %d to %d without a change", serial, z.serial)
+	}
+	z.addThreats([]webrisk.URLThreat{{Pattern: "other.test/", ThreatType: webrisk.ThreatTypeMalware}})
+	if z.serial <= serial {
+		t.Errorf("serial %d did not increase from %d with a new host", z.serial, serial)
+	}
+
+	// Hosts expire once they have not been found for the expiry.
+	z.mu.Lock()
+	e := z.hosts["evil.test"]
+	e.expires = time.Now()
+	z.hosts["evil.test"] = e
+	z.mu.Unlock()
+	resp := httptest.NewRecorder()
+	z.serve(resp, httptest.NewRequest("GET", rpzPath, nil))
+	if ct := resp.Header().Get("Content-Type"); ct != "text/dns" {
+		t.Errorf("Content-Type = %q, want text/dns", ct)
+	}
+	if body := resp.Body.String(); strings.Contains(body, "evil.test") || !strings.Contains(body, "other.test CNAME .") {
+		t.Errorf("unexpected zone after expiry:\n%s", body)
+	}
+
+	// A nil zone ignores hosts.
+	var none *rpzZone
+	none.addThreats([]webrisk.URLThreat{{Pattern: "evil.test/"}})
+	none.addHeuristic("evil.test", "reason")
+}
+
+// newFakeAPI returns a server that answers like the Web Risk API, with
+// threat lists that hold the hash prefix of "evil.test/" only, which is
+// malware.
+func newFakeAPI() *httptest.Server {
+	full := webrisk.HashExpression("evil.test/")
+	sum := sha256.Sum256(full[:4])
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "threatLists:computeDiff"):
+			fmt.Fprintf(w, `{"responseType":"RESET","additions":{"rawHashes":[{"prefixSize":4,"rawHashes":%q}]},"newVersionToken":"dG9rZW4=","recommendedNextDiff":"2100-01-01T00:00:00Z","checksum":{"sha256":%q}}`,
+				base64.StdEncoding.EncodeToString(full[:4]), base64.StdEncoding.EncodeToString(sum[:]))
+		case strings.HasSuffix(r.URL.Path, "hashes:search"):
+			fmt.Fprintf(w, `{"threats":[{"threatTypes":["MALWARE"],"hash":%q,"expireTime":"2100-01-01T00:00:00Z"}],"negativeExpireTime":"2100-01-01T00:00:00Z"}`,
+				base64.StdEncoding.EncodeToString(full))
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+}
+
+// newFakeClient returns a client of the fake API, with its database loaded,
+// which rejects ftp URLs.
+func newFakeClient(t *testing.T, api *httptest.Server) *webrisk.UpdateClient {
+	wr, err := webrisk.NewUpdateClient(webrisk.Config{
+		APIKey:    "key",
+		ServerURL: api.URL,
+		Logger:    io.Discard,
+		Schemes:   map[string]webrisk.SchemeAction{"ftp": webrisk.SchemeReject},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := wr.WaitUntilReady(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return wr
+}
+
+func TestICAP(t *testing.T) {
+	api := newFakeAPI()
+	defer api.Close()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	statikFS, err := fs.New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events := newEventHub("", nil)
+	detections, unsubscribe := events.subscribe()
+	defer unsubscribe()
+
+	s := newICAPServer()
+	s.handle(icapPath, wr, events, newLatencies(""), statikFS)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	go s.serve(ln)
+	defer s.close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	tp := textproto.NewReader(bufio.NewReader(conn))
+
+	// roundTrip sends an ICAP request, and returns the status line and
+	// headers of the response, and its encapsulated HTTP header and body.
+	roundTrip := func(method, path, hdr, msg string) (string, textproto.MIMEHeader, string, string) {
+		t.Helper()
+		fmt.Fprintf(conn, "%s icap://%s%s ICAP/1.0\r\nHost: %s\r\n%s\r\n%s", method, ln.Addr(), path, ln.Addr(), hdr, msg)
+		status, err := tp.ReadLine()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		h, err := tp.ReadMIMEHeader()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var encHdr, encBody []byte
+		hdrLen, hasBody := 0, false
+		for _, part := range strings.Split(h.Get("Encapsulated"), ",") {
+			name, offset, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if strings.HasSuffix(name, "-body") {
+				hdrLen, _ = strconv.Atoi(offset)
+				hasBody = name != "null-body"
+			}
+		}
+		encHdr = make([]byte, hdrLen)
+		if _, err := io.ReadFull(tp.R, encHdr); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if hasBody {
+			if encBody, err = io.ReadAll(httputil.NewChunkedReader(tp.R)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tp.ReadLine() // After the last chunk
+		}
+		return status, h, string(encHdr), string(encBody)
+	}
+
+	status, h, _, _ := roundTrip("OPTIONS", icapPath, "Encapsulated: null-body=0\r\n", "")
+	if status != "ICAP/1.0 200 OK" || h.Get("Methods") != "REQMOD" || h.Get("Allow") != "204" {
+		t.Errorf("OPTIONS = %q %v", status, h)
+	}
+	status, _, _, _ = roundTrip("OPTIONS", "/respmod", "Encapsulated: null-body=0\r\n", "")
+	if status != "ICAP/1.0 404 ICAP Service Not Found" {
+		t.Errorf("OPTIONS of an unknown service = %q", status)
+	}
+
+	// Safe requests are left as they are.
+	req := "GET http://safe.test/ HTTP/1.1\r\nHost: safe.test\r\n\r\n"
+	status, _, _, _ = roundTrip("REQMOD", icapPath, fmt.Sprintf("Allow: 204\r\nEncapsulated: req-hdr=0, null-body=%d\r\n", len(req)), req)
+	if status != "ICAP/1.0 204 No Content" {
+		t.Errorf("REQMOD of a safe URL = %q, want 204", status)
+	}
+	req = "POST /form HTTP/1.1\r\nHost: safe.test\r\nContent-Length: 5\r\n\r\n"
+	status, _, hdr, body := roundTrip("REQMOD", icapPath, fmt.Sprintf("Encapsulated: req-hdr=0, req-body=%d\r\n", len(req)), req+"5\r\nhello\r\n0\r\n\r\n")
+	if status != "ICAP/1.0 200 OK" || hdr != req || body != "hello" {
+		t.Errorf("REQMOD of a safe URL without 204 = %q with %q and %q, want the request back", status, hdr, body)
+	}
+	status, _, _, _ = roundTrip("REQMOD", icapPath, fmt.Sprintf("Preview: 0\r\nEncapsulated: req-hdr=0, req-body=%d\r\n", len(req)), req+"0; ieof\r\n\r\n")
+	if status != "ICAP/1.0 204 No Content" {
+		t.Errorf("REQMOD of a safe URL with a preview = %q, want 204", status)
+	}
+
+	// Unsafe requests are answered with the interstitial.
+	for _, req := range []string{
+		"GET /download HTTP/1.1\r\nHost: www.evil.test\r\n\r\n",
+		"CONNECT evil.test:443 HTTP/1.1\r\nHost: evil.test:443\r\n\r\n",
+		"GET ftp://files.test/ HTTP/1.1\r\nHost: files.test\r\n\r\n",
+	} {
+		status, _, hdr, body = roundTrip("REQMOD", icapPath, fmt.Sprintf("X-Client-IP: 192.0.2.1\r\nEncapsulated: req-hdr=0, null-body=%d\r\n", len(req)), req)
+		if status != "ICAP/1.0 200 OK" || !strings.HasPrefix(hdr, "HTTP/1.1 403 Forbidden\r\n") || body == "" {
+			t.Errorf("REQMOD of %q = %q with %q and %q, want a 403 response", req, status, hdr, body)
+		}
+	}
+	for _, url := range []string{"http://www.evil.test/download", "https://evil.test:443/"} {
+		select {
+		case d := <-detections:
+			if d.Endpoint != "icap" || d.Client != "192.0.2.1" || d.URL != url {
+				t.Errorf("detection %+v, want %s from 192.0.2.1", d, url)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("no detection of %s", url)
+		}
+	}
+
+	status, _, _, _ = roundTrip("REQMOD", icapPath, "Encapsulated: req-hdr=0, null-body=5\r\n", "GET\r\n\r")
+	if status != "ICAP/1.0 400 Bad Request" {
+		t.Errorf("REQMOD of an invalid request = %q, want 400", status)
+	}
+}
+
+func TestExtAuthz(t *testing.T) {
+	api := newFakeAPI()
+	defer api.Close()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	statikFS, err := fs.New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events := newEventHub("", nil)
+	detections, unsubscribe := events.subscribe()
+	defer unsubscribe()
+
+	vectors := []struct {
+		host, path, proto string
+		redirect          string
+		code              int
+		location          string
+		url               string // Of the detection
+	}{
+		{host: "safe.test", path: "/extauthz/", code: http.StatusOK},
+		{host: "www.evil.test", path: "/extauthz/download?a=b", code: http.StatusForbidden, url: "http://www.evil.test/download?a=b"},
+		{host: "evil.test", path: "/extauthz/x%2Fy", proto: "https", redirect: "https://wrserver.test/", code: http.StatusFound,
+			location: "https://wrserver.test/r?url=https%3A%2F%2Fevil.test%2Fx%252Fy", url: "https://evil.test/x%2Fy"},
+		{host: "files.test", path: "/extauthz/", proto: "ftp", code: http.StatusOK},
+	}
+	for i, v := range vectors {
+		req := httptest.NewRequest("GET", v.path, nil)
+		req.Host = v.host
+		if v.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", v.proto)
+		}
+		resp := httptest.NewRecorder()
+		serveExtAuthz(resp, req, wr, events, newLatencies(""), statikFS, v.redirect)
+		if resp.Code != v.code || resp.Header().Get("Location") != v.location {
+			t.Errorf("test %d, response %d to %q, want %d to %q", i, resp.Code, resp.Header().Get("Location"), v.code, v.location)
+		}
+		if v.url == "" {
+			continue
+		}
+		if tts := resp.Header().Get(threatTypesHeader); tts != "MALWARE" {
+			t.Errorf("test %d, %s = %q, want MALWARE", i, threatTypesHeader, tts)
+		}
+		if v.redirect == "" && !strings.Contains(resp.Body.String(), "<html") {
+			t.Errorf("test %d, denial without the interstitial: %s", i, resp.Body.String())
+		}
+		select {
+		case d := <-detections:
+			if d.Endpoint != "extauthz" || d.URL != v.url {
+				t.Errorf("test %d, detection %+v, want %s", i, d, v.url)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("test %d, no detection of %s", i, v.url)
+		}
+	}
+}
+
+// appendCheckRequest appends the protobuf encoding of an
+// envoy.service.auth.v3.CheckRequest for a request from source to b.
+func appendCheckRequest(b []byte, source, host, path, scheme string, headers map[string]string) []byte {
+	field := func(b []byte, num protowire.Number, v []byte) []byte {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, v)
+	}
+	var h []byte
+	h = field(h, 2, []byte("GET"))
+	for k, v := range headers {
+		h = field(h, 3, field(field(nil, 1, []byte(k)), 2, []byte(v)))
+	}
+	h = field(h, 4, []byte(path))
+	h = field(h, 5, []byte(host))
+	h = field(h, 6, []byte(scheme))
+	addr := field(nil, 1, field(nil, 2, []byte(source)))
+	attrs := field(field(nil, 1, field(nil, 1, addr)), 4, field(nil, 2, h))
+	return field(b, 1, attrs)
+}
+
+func TestExtAuthzGRPC(t *testing.T) {
+	api := newFakeAPI()
+	defer api.Close()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	down := newFakeAPI()
+	wrDown := newFakeClient(t, down)
+	defer wrDown.Close()
+	down.Close()
+	statikFS, err := fs.New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events := newEventHub("", nil)
+	detections, unsubscribe := events.subscribe()
+	defer unsubscribe()
+
+	s := newExtAuthzServer()
+	s.handle("", wr, events, newLatencies(""), statikFS, "")
+	s.handle("acme", wr, events, newLatencies("acme"), statikFS, "https://wrserver.test/")
+	s.handle("down", wrDown, events, newLatencies("down"), statikFS, "")
+	mux := http.NewServeMux()
+	mux.Handle(extAuthzCheckPath, s)
+	srv := httptest.NewServer(h2c.NewHandler(mux, &http2.Server{}))
+	defer srv.Close()
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}}
+
+	vectors := []struct {
+		tenant       string
+		msg          []byte // The CheckRequest, framed with compressed set if nil
+		grpcStatus   string
+		status       int // Of the CheckResponse
+		httpStatus   int // Of the denied response
+		header, body string
+		url          string // Of the detection
+	}{
+		{msg: appendCheckRequest(nil, "192.0.2.1", "safe.test", "/", "", nil), grpcStatus: "0"},
+		{msg: appendCheckRequest(nil, "192.0.2.1", "www.evil.test", "/download?a=b", "", nil), grpcStatus: "0",
+			status: 7, httpStatus: http.StatusForbidden, header: "x-webrisk-threat-types=MALWARE", body: "<html", url: "http://www.evil.test/download?a=b"},
+		{tenant: "acme", msg: appendCheckRequest(nil, "192.0.2.1", "evil.test", "/x", "", map[string]string{"x-forwarded-proto": "https"}), grpcStatus: "0",
+			status: 7, httpStatus: http.StatusFound, header: "location=https://wrserver.test/r?url=https%3A%2F%2Fevil.test%2Fx", url: "https://evil.test/x"},
+		{msg: appendCheckRequest(nil, "", "evil.test", "/y", "https", nil), grpcStatus: "0",
+			status: 7, httpStatus: http.StatusForbidden, url: "https://evil.test/y"},
+		{msg: appendCheckRequest(nil, "192.0.2.1", "files.test", "/", "ftp", nil), grpcStatus: "0"},
+		{tenant: "down", msg: appendCheckRequest(nil, "192.0.2.1", "evil.test", "/", "", nil), grpcStatus: "14"},
+		{tenant: "bogus", msg: appendCheckRequest(nil, "192.0.2.1", "safe.test", "/", "", nil), grpcStatus: "5"},
+		{msg: appendCheckRequest(nil, "192.0.2.1", "safe.test", "", "", nil), grpcStatus: "3"},
+		{msg: []byte{0xff}, grpcStatus: "3"},
+		{grpcStatus: "12"},
+	}
+	for i, v := range vectors {
+		body := make([]byte, 5, 5+len(v.msg))
+		if v.msg == nil {
+			body[0] = 1
+		}
+		binary.BigEndian.PutUint32(body[1:], uint32(len(v.msg)))
+		req, _ := http.NewRequest("POST", srv.URL+extAuthzCheckPath, bytes.NewReader(append(body, v.msg...)))
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("Grpc-Timeout", "5S")
+		if v.tenant != "" {
+			req.Header.Set(tenantMetadata, v.tenant)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("test %d, unexpected error: %v", i, err)
+		}
+		out, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("test %d, unexpected error: %v", i, err)
+		}
+		grpcStatus := resp.Header.Get("Grpc-Status")
+		if grpcStatus == "" {
+			grpcStatus = resp.Trailer.Get("Grpc-Status")
+		}
+		if grpcStatus != v.grpcStatus {
+			t.Errorf("test %d, grpc-status = %q, want %q", i, grpcStatus, v.grpcStatus)
+			continue
+		}
+		if v.grpcStatus != "0" {
+			continue
+		}
+		if len(out) < 5 || int(binary.BigEndian.Uint32(out[1:])) != len(out)-5 {
+			t.Errorf("test %d, invalid response framing: %x", i, out)
+			continue
+		}
+		out = out[5:]
+
+		// Decode the status code of the CheckResponse, and its denied
+		// response.
+		varint := func(b []byte) int {
+			if len(b) == 0 {
+				return 0
+			}
+			_, _, n := protowire.ConsumeTag(b)
+			x, _ := protowire.ConsumeVarint(b[n:])
+			return int(x)
+		}
+		st, _ := fieldAt(out, 1)
+		denied, _ := fieldAt(out, 2)
+		ok, _ := fieldAt(out, 3)
+		if got := varint(st); got != v.status || (v.status == 0) != (ok != nil) {
+			t.Errorf("test %d, status %d (allowed %v), want %d", i, got, ok != nil, v.status)
+			continue
+		}
+		if v.status == 0 {
+			continue
+		}
+		hs, _ := fieldAt(denied, 1)
+		if got := varint(hs); got != v.httpStatus {
+			t.Errorf("test %d, denied with %d, want %d", i, got, v.httpStatus)
+		}
+		var headers []string
+		forEachField(denied, func(num protowire.Number, b []byte) error {
+			if num == 2 {
+				k, _ := fieldAt(b, 1, 1)
+				v, _ := fieldAt(b, 1, 2)
+				headers = append(headers, string(k)+"="+string(v))
+			}
+			return nil
+		})
+		if v.header != "" && !strings.Contains(strings.Join(headers, "\n"), v.header) {
+			t.Errorf("test %d, denied with headers %q, want %q", i, headers, v.header)
+		}
+		if b, _ := fieldAt(denied, 3); !strings.Contains(string(b), v.body) {
+			t.Errorf("test %d, denied with %q, want %q", i, b, v.body)
+		}
+		select {
+		case d := <-detections:
+			if d.Endpoint != "extauthz" || d.URL != v.url {
+				t.Errorf("test %d, detection %+v, want %s", i, d, v.url)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("test %d, no detection of %s", i, v.url)
+		}
+	}
+
+	// Requests that are not gRPC ones are turned away.
+	resp, err := http.Post(srv.URL+extAuthzCheckPath, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("JSON request answered with %d, want %d", resp.StatusCode, http.StatusUnsupportedMediaType)
+	}
+}
+
+func TestGRPCTimeout(t *testing.T) {
+	vectors := []struct {
+		v  string
+		d  time.Duration
+		ok bool
+	}{
+		{v: "1S", d: time.Second, ok: true},
+		{v: "250m", d: 250 * time.Millisecond, ok: true},
+		{v: "2562047H", d: 2562047 * time.Hour, ok: true},
+		{v: "99999999H"},
+		{v: "3n", d: 3, ok: true},
+		{v: ""},
+		{v: "S"},
+		{v: "1s"},
+		{v: "-1S"},
+		{v: "123456789S"},
+	}
+	for i, v := range vectors {
+		if d, ok := grpcTimeout(v.v); d != v.d || ok != v.ok {
+			t.Errorf("test %d, grpcTimeout(%q) = (%v, %v), want (%v, %v)", i, v.v, d, ok, v.d, v.ok)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	api := newFakeAPI()
+	defer api.Close()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	events := newEventHub("", nil)
+
+	vectors := []struct {
+		method, target, header string
+		code                   int
+		threatTypes            string
+	}{
+		{method: "GET", target: "/check?url=http://safe.test/", code: http.StatusNoContent},
+		{method: "HEAD", target: "/check", header: "http://safe.test/", code: http.StatusNoContent},
+		{method: "GET", target: "/check?url=" + url.QueryEscape("http://evil.test/a?b"), code: http.StatusForbidden, threatTypes: "MALWARE"},
+		{method: "GET", target: "/check", header: "https://www.evil.test/", code: http.StatusForbidden, threatTypes: "MALWARE"},
+		{method: "GET", target: "/check?url=ftp://files.test/", code: http.StatusForbidden},
+		{method: "GET", target: "/check", code: http.StatusBadRequest},
+		{method: "POST", target: "/check?url=http://safe.test/", code: http.StatusBadRequest},
+	}
+	for i, v := range vectors {
+		req := httptest.NewRequest(v.method, v.target, nil)
+		if v.header != "" {
+			req.Header.Set(originalURLHeader, v.header)
+		}
+		resp := httptest.NewRecorder()
+		serveCheck(resp, req, wr, events, newLatencies(""))
+		if resp.Code != v.code || resp.Header().Get(threatTypesHeader) != v.threatTypes {
+			t.Errorf("test %d, response %d with threat types %q, want %d with %q", i, resp.Code, resp.Header().Get(threatTypesHeader), v.code, v.threatTypes)
+		}
+	}
+}
+
+func TestSTIX(t *testing.T) {
+	s := newIndicatorStore("acme")
+	s.created = time.Unix(1451436000, 0)
+	s.record(detection{Time: time.Unix(1451436338, 0), URL: "http://evil.test/it's", ThreatTypes: []webrisk.ThreatType{webrisk.ThreatTypeMalware}})
+	s.record(detection{Time: time.Unix(1451436400, 0), URL: "http://phish.test/", ThreatTypes: []webrisk.ThreatType{webrisk.ThreatTypeSocialEngineering}})
+	s.record(detection{Time: time.Unix(1451436500, 0), URL: "http://evil.test/it's", ThreatTypes: []webrisk.ThreatType{webrisk.ThreatTypeMalware, webrisk.ThreatTypeUnwantedSoftware}})
+	s.record(detection{Time: time.Unix(1451436600, 0), URL: "http://phish.test/", ThreatTypes: []webrisk.ThreatType{webrisk.ThreatTypeSocialEngineering}})
+
+	identity := "identity--" + uuid5(stixNamespace, "wrserver (acme)")
+	evil := stixObject{
+		Type:           "indicator",
+		SpecVersion:    "2.1",
+		ID:             "indicator--" + uuid5(stixNamespace, identity+" http://evil.test/it's"),
+		CreatedByRef:   identity,
+		Created:        "2015-12-30T00:45:38.000Z",
+		Modified:       "2015-12-30T00:48:20.000Z",
+		Name:           "http://evil.test/it's",
+		Description:    "URL found unsafe by the Web Risk API: MALWARE, UNWANTED_SOFTWARE",
+		IndicatorTypes: []string{"malicious-activity"},
+		Pattern:        `[url:value = 'http://evil.test/it\'s']`,
+		PatternType:    "stix",
+		ValidFrom:      "2015-12-30T00:45:38.000Z",
+		Labels:         []string{"MALWARE", "UNWANTED_SOFTWARE"},
+		added:          time.Unix(1451436500, 0),
+	}
+	objs := s.objects(time.Unix(1451436400, 0))
+	if len(objs) != 1 || !reflect.DeepEqual(objs[0], evil) {
+		t.Errorf("objects() = %+v, want %+v", objs, evil)
+	}
+	objs = s.objects(time.Time{})
+	if len(objs) != 3 || objs[0].ID != identity || objs[0].IdentityClass != "system" || objs[1].Name != "http://phish.test/" || objs[2].ID != evil.ID {
+		t.Errorf("objects() = %+v, want the identity, then the indicators by modification time", objs)
+	}
+	if id := uuid5(stixNamespace, "www.example.com"); id != "58c5519f-91a1-58ae-9da4-96eb0ebf9d31" {
+		t.Errorf("uuid5() = %q", id)
+	}
+
+	taxii := &taxiiServer{prefix: "/t/acme", store: s}
+	objects := taxiiPath + "api/collections/" + taxiiCollectionID + "/objects/"
+	vectors := []struct {
+		method, target string
+		code           int
+		mime, body     string
+	}{
+		{method: "GET", target: adminSTIXPath + "?added_after=2015-12-30T00:46:40Z", code: http.StatusOK, mime: mimeSTIX, body: `"objects":[{"type":"indicator"`},
+		{method: "GET", target: adminSTIXPath + "?added_after=yesterday", code: http.StatusBadRequest},
+		{method: "POST", target: adminSTIXPath, code: http.StatusBadRequest},
+		{method: "GET", target: taxiiPath, code: http.StatusOK, mime: mimeTAXII, body: `"api_roots":["/t/acme/taxii2/api/"]`},
+		{method: "GET", target: taxiiPath + "api/", code: http.StatusOK, mime: mimeTAXII, body: `"versions":["` + mimeTAXII + `"]`},
+		{method: "GET", target: taxiiPath + "api/collections/", code: http.StatusOK, mime: mimeTAXII, body: `"id":"` + taxiiCollectionID + `"`},
+		{method: "GET", target: objects + "?added_after=2015-12-30T00:46:40.000Z", code: http.StatusOK, mime: mimeTAXII, body: `{"more":false,"objects":[{"type":"indicator","spec_version":"2.1","id":"` + evil.ID + `"`},
+		{method: "GET", target: objects + "?match[type]=identity", code: http.StatusOK, mime: mimeTAXII, body: `"objects":[{"type":"identity"`},
+		{method: "GET", target: objects + "?added_after=2016-01-01T00:00:00Z", code: http.StatusOK, mime: mimeTAXII, body: `{"more":false,"objects":[]}`},
+		{method: "GET", target: taxiiPath + "api/collections/unknown/objects/", code: http.StatusNotFound},
+		{method: "POST", target: objects, code: http.StatusBadRequest},
+	}
+	for i, v := range vectors {
+		req := httptest.NewRequest(v.method, v.target, nil)
+		resp := httptest.NewRecorder()
+		if strings.HasPrefix(v.target, adminSTIXPath) {
+			serveSTIX(resp, req, s)
+		} else {
+			taxii.serve(resp, req)
+		}
+		if resp.Code != v.code {
+			t.Errorf("test %d, response %d, want %d", i, resp.Code, v.code)
+			continue
+		}
+		if got := resp.Header().Get("Content-Type"); v.mime != "" && got != v.mime {
+			t.Errorf("test %d, Content-Type %q, want %q", i, got, v.mime)
+		}
+		if !strings.Contains(resp.Body.String(), v.body) {
+			t.Errorf("test %d, body %q, want it to contain %q", i, resp.Body.String(), v.body)
+		}
+	}
+	req := httptest.NewRequest("GET", objects+"?added_after=2015-12-30T00:46:40Z", nil)
+	resp := httptest.NewRecorder()
+	taxii.serve(resp, req)
+	if first, last := resp.Header().Get("X-TAXII-Date-Added-First"), resp.Header().Get("X-TAXII-Date-Added-Last"); first != "2015-12-30T00:48:20.000Z" || last != first {
+		t.Errorf("date added headers = %q, %q, want %q", first, last, "2015-12-30T00:48:20.000Z")
+	}
+}
+
+func TestAsync(t *testing.T) {
+	api := newFakeAPI()
+	defer api.Close()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	events := newEventHub("", nil)
+
+	type callback struct {
+		signature string
+		verdict   asyncVerdict
+	}
+	callbacks := make(chan callback, 10)
+	failures := 1
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v asyncVerdict
+		json.NewDecoder(r.Body).Decode(&v)
+		if v.ID == "retried" && failures > 0 {
+			failures--
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		callbacks <- callback{r.Header.Get(signatureHeader), v}
+	}))
+	defer hook.Close()
+	defer func(delays []time.Duration) { asyncRetryDelays = delays }(asyncRetryDelays)
+	asyncRetryDelays = []time.Duration{time.Millisecond}
+
+	s := newAsyncScanner("secret", "127.0.0.1, mail.example")
+	vectors := []struct {
+		body    string
+		code    int
+		verdict asyncVerdict
+	}{
+		{body: `{"id":"a","uri":"http://evil.test/x","callbackUri":"` + hook.URL + `"}`, code: http.StatusAccepted,
+			verdict: asyncVerdict{ID: "a", URI: "http://evil.test/x", Blocked: true, ThreatTypes: []webrisk.ThreatType{webrisk.ThreatTypeMalware}, Source: "api"}},
+		{body: `{"id":"b","uri":"http://safe.test/","callbackUri":"` + hook.URL + `"}`, code: http.StatusAccepted,
+			verdict: asyncVerdict{ID: "b", URI: "http://safe.test/", ThreatTypes: []webrisk.ThreatType{}, Source: "database"}},
+		{body: `{"id":"c","uri":"ftp://files.test/","callbackUri":"` + hook.URL + `"}`, code: http.StatusAccepted,
+			verdict: asyncVerdict{ID: "c", URI: "ftp://files.test/", Blocked: true, ThreatTypes: []webrisk.ThreatType{}, Error: webrisk.ErrSchemeRejected.Error()}},
+		{body: `{"id":"retried","uri":"http://safe.test/","callbackUri":"` + hook.URL + `"}`, code: http.StatusAccepted,
+			verdict: asyncVerdict{ID: "retried", URI: "http://safe.test/", ThreatTypes: []webrisk.ThreatType{}, Source: "database"}},
+		{body: `{"uri":"http://safe.test/","callbackUri":"https://attacker.example/"}`, code: http.StatusBadRequest},
+		{body: `{"uri":"http://safe.test/","callbackUri":"file:///etc/passwd"}`, code: http.StatusBadRequest},
+		{body: `{"uri":"http://safe.test/"}`, code: http.StatusBadRequest},
+		{body: `{"callbackUri":"` + hook.URL + `"}`, code: http.StatusBadRequest},
+		{body: `{`, code: http.StatusBadRequest},
+	}
+	verdicts := make(map[string]asyncVerdict)
+	for i, v := range vectors {
+		resp := httptest.NewRecorder()
+		s.serve(resp, httptest.NewRequest("POST", asyncPath, strings.NewReader(v.body)), wr, events, newLatencies(""))
+		if resp.Code != v.code {
+			t.Errorf("test %d, response %d, want %d", i, resp.Code, v.code)
+		}
+		if v.code == http.StatusAccepted {
+			if got := resp.Body.String(); got != `{"id":"`+v.verdict.ID+`"}` {
+				t.Errorf("test %d, body %q", i, got)
+			}
+			verdicts[v.verdict.ID] = v.verdict
+		}
+	}
+	for range verdicts {
+		var cb callback
+		select {
+		case cb = <-callbacks:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for verdicts")
+		}
+		body, _ := json.Marshal(cb.verdict)
+		ts, _, _ := strings.Cut(strings.TrimPrefix(cb.signature, "t="), ",")
+		sec, _ := strconv.ParseInt(ts, 10, 64)
+		if sig := signVerdict([]byte("secret"), time.Unix(sec, 0), body); cb.signature != sig {
+			t.Errorf("verdict %s, signature %q, want %q", cb.verdict.ID, cb.signature, sig)
+		}
+		if cb.verdict.Time.IsZero() {
+			t.Errorf("verdict %s has no time", cb.verdict.ID)
+		}
+		cb.verdict.Time = time.Time{}
+		if !reflect.DeepEqual(cb.verdict, verdicts[cb.verdict.ID]) {
+			t.Errorf("verdict %+v, want %+v", cb.verdict, verdicts[cb.verdict.ID])
+		}
+	}
+	s.close()
+	resp := httptest.NewRecorder()
+	s.serve(resp, httptest.NewRequest("POST", asyncPath, strings.NewReader(vectors[1].body)), wr, events, newLatencies(""))
+	if resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("response %d after close, want %d", resp.Code, http.StatusServiceUnavailable)
+	}
+
+	// Without a list of hosts, verdicts are not posted to private
+	// addresses.
+	s = newAsyncScanner("secret", "")
+	defer s.close()
+	if err := s.post(hook.URL, []byte(`{}`)); !errors.Is(err, errPrivateAddress) {
+		t.Errorf("post to a loopback callback = %v, want %v", err, errPrivateAddress)
+	}
+
+	want := "t=1451436338,v1=08b7dcf5cccb13eda510a0e8cc1157fa706c78a691f82a2030eca4cc7592a234"
+	if got := signVerdict([]byte("secret"), time.Unix(1451436338, 0), []byte(`{}`)); got != want {
+		t.Errorf("signVerdict() = %q, want %q", got, want)
+	}
+}
+
+func TestParseScanURLs(t *testing.T) {
+	vectors := []struct {
+		data   string
+		csv    bool
+		column string
+		urls   []string
+		fail   bool
+	}{
+		{data: "# Links\nhttp://a.test/\n\n  b.test  \n", urls: []string{"http://a.test/", "b.test"}},
+		{data: "url,seen\nhttp://a.test/?x=1,2\n\"http://b.test/a,b\",3\n", csv: true, urls: []string{"http://a.test/?x=1", "http://b.test/a,b"}},
+		{data: "http://a.test/,1\nhttp://b.test/,2\n", csv: true, urls: []string{"http://a.test/", "http://b.test/"}},
+		{data: "id,link\n1,http://a.test/\n2\n3,\n", csv: true, column: "1", urls: []string{"http://a.test/"}},
+		{data: "a.test\n", csv: true, column: "x", fail: true},
+		{data: "\"a.test\n", csv: true, fail: true},
+	}
+	for i, v := range vectors {
+		urls, err := parseScanURLs([]byte(v.data), v.csv, v.column)
+		if (err != nil) != v.fail {
+			t.Errorf("test %d, unexpected error: %v", i, err)
+			continue
+		}
+		if !v.fail && !reflect.DeepEqual(urls, v.urls) {
+			t.Errorf("test %d, urls = %q, want %q", i, urls, v.urls)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	api := newFakeAPI()
+	defer api.Close()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	events := newEventHub("", nil)
+	defer events.close()
+	s := newScanJobs("/t/acme", wr, events)
+
+	do := func(method, target, contentType string, body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, body)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp := httptest.NewRecorder()
+		s.serve(resp, req)
+		return resp
+	}
+	wait := func(id string) scanProgress {
+		for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+			var p scanProgress
+			resp := do("GET", scanPath+"/"+id, "", nil)
+			if err := json.Unmarshal(resp.Body.Bytes(), &p); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if p.Status != scanRunning {
+				return p
+			}
+		}
+		t.Fatalf("timed out waiting for job %s", id)
+		return scanProgress{}
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "links.csv")
+	io.WriteString(fw, "url\nhttp://safe.test/\nhttp://evil.test/x\nftp://files.test/\n")
+	mw.Close()
+	vectors := []struct {
+		contentType, body string
+		code              int
+		report            string
+	}{
+		{contentType: "text/plain", body: "http://safe.test/\nhttp://evil.test/x\n", code: http.StatusAccepted,
+			report: "url,threatTypes,source,error\nhttp://safe.test/,,database,\nhttp://evil.test/x,MALWARE,api,\n"},
+		{contentType: mw.FormDataContentType(), body: body.String(), code: http.StatusAccepted,
+			report: "url,threatTypes,source,error\nhttp://safe.test/,,database,\nhttp://evil.test/x,MALWARE,cache,\nftp://files.test/,,," + webrisk.ErrSchemeRejected.Error() + "\n"},
+		{contentType: mimeJSON, body: `{"source":"http://files.test/links.txt"}`, code: http.StatusBadRequest},
+		{contentType: "text/plain", body: "# Nothing\n", code: http.StatusBadRequest},
+	}
+	for i, v := range vectors {
+		resp := do("POST", scanPath, v.contentType, strings.NewReader(v.body))
+		if resp.Code != v.code {
+			t.Errorf("test %d, response %d, want %d: %s", i, resp.Code, v.code, resp.Body)
+			continue
+		}
+		if v.code != http.StatusAccepted {
+			continue
+		}
+		var p scanProgress
+		json.Unmarshal(resp.Body.Bytes(), &p)
+		if loc := resp.Header().Get("Location"); loc != "/t/acme/v1/scan/"+p.ID || p.Results != loc+"/results" {
+			t.Errorf("test %d, Location %q and results %q", i, loc, p.Results)
+		}
+		p = wait(p.ID)
+		lines := strings.Count(v.report, "\n") - 1
+		if p.Status != scanDone || p.Total != lines || p.Done != lines || p.Unsafe != 1 || p.Finished == nil {
+			t.Errorf("test %d, progress %+v", i, p)
+		}
+		resp = do("GET", scanPath+"/"+p.ID+"/results", "", nil)
+		if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != mimeCSV || resp.Body.String() != v.report {
+			t.Errorf("test %d, report %d %q, want %q", i, resp.Code, resp.Body, v.report)
+		}
+		if resp = do("DELETE", scanPath+"/"+p.ID, "", nil); resp.Code != http.StatusNoContent {
+			t.Errorf("test %d, DELETE response %d", i, resp.Code)
+		}
+		if resp = do("GET", scanPath+"/"+p.ID, "", nil); resp.Code != http.StatusNotFound {
+			t.Errorf("test %d, response %d after DELETE", i, resp.Code)
+		}
+	}
+	if resp := do("GET", scanPath, "", nil); resp.Code != http.StatusBadRequest {
+		t.Errorf("GET %s response %d", scanPath, resp.Code)
+	}
+}
+
+func TestSearchCacheControl(t *testing.T) {
+	api := newFakeAPI()
+	defer api.Close()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	events := newEventHub("", nil)
+
+	vectors := []struct {
+		uri        string
+		expireTime string // Of the threat, if any
+	}{
+		{uri: "http://safe.test/"},
+		{uri: "http://evil.test/", expireTime: `"expireTime":"2100-01-01T00:00:00Z"`},
+	}
+	for i, v := range vectors {
+		req := httptest.NewRequest("POST", findThreatPath, strings.NewReader(`{"uri":"`+v.uri+`"}`))
+		req.Header.Set("Content-Type", mimeJSON)
+		resp := httptest.NewRecorder()
+		serveLookups(resp, req, wr, events, newLatencies(""))
+		if resp.Code != http.StatusOK {
+			t.Fatalf("test %d, response %d: %s", i, resp.Code, resp.Body)
+		}
+		body := resp.Body.String()
+		if got := strings.Contains(body, "expireTime"); got != (v.expireTime != "") || v.expireTime != "" && !strings.Contains(body, v.expireTime) {
+			t.Errorf("test %d, body %s, want %s", i, body, v.expireTime)
+		}
+		expires, err := http.ParseTime(resp.Header().Get(expiresHeader))
+		if err != nil || !expires.After(time.Now()) {
+			t.Errorf("test %d, %s %q", i, expiresHeader, resp.Header().Get(expiresHeader))
+		}
+		if revalidate, err := http.ParseTime(resp.Header().Get(revalidateHeader)); err != nil || revalidate.After(expires) {
+			t.Errorf("test %d, %s %q", i, revalidateHeader, resp.Header().Get(revalidateHeader))
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	api := newFakeAPI()
+	defer api.Close()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	events := newEventHub("", nil)
+
+	vectors := []struct {
+		method, target, header string
+		code                   int
+		threats, source        string
+		expires                bool
+	}{
+		{method: "GET", target: "/probe?url=http://safe.test/", code: http.StatusOK, threats: "none", source: "database", expires: true},
+		{method: "HEAD", target: "/probe?url=http://evil.test/a", code: http.StatusOK, threats: "MALWARE", source: "api", expires: true},
+		{method: "GET", target: "/probe", header: "http://evil.test/b", code: http.StatusOK, threats: "MALWARE", source: "cache", expires: true},
+		{method: "GET", target: "/probe?url=ftp://files.test/", code: http.StatusOK, threats: "none", source: "rejected"},
+		{method: "GET", target: "/probe", code: http.StatusBadRequest},
+		{method: "POST", target: "/probe?url=http://safe.test/", code: http.StatusBadRequest},
+	}
+	for i, v := range vectors {
+		req := httptest.NewRequest(v.method, v.target, nil)
+		if v.header != "" {
+			req.Header.Set(originalURLHeader, v.header)
+		}
+		resp := httptest.NewRecorder()
+		serveProbe(resp, req, wr, events, newLatencies(""))
+		h := resp.Header()
+		if resp.Code != v.code || h.Get(threatsHeader) != v.threats || h.Get(sourceHeader) != v.source {
+			t.Errorf("test %d, response %d with %q from %q, want %d with %q from %q", i, resp.Code, h.Get(threatsHeader), h.Get(sourceHeader), v.code, v.threats, v.source)
+		}
+		expires, err := http.ParseTime(h.Get(expiresHeader))
+		if v.expires != (err == nil) || (err == nil && !expires.After(time.Now())) {
+			t.Errorf("test %d, %s %q", i, expiresHeader, h.Get(expiresHeader))
+		}
+		if revalidate, err := http.ParseTime(h.Get(revalidateHeader)); v.expires != (err == nil) || (err == nil && revalidate.After(expires)) {
+			t.Errorf("test %d, %s %q", i, revalidateHeader, h.Get(revalidateHeader))
+		}
+	}
+}
+
+func TestProblemErrors(t *testing.T) {
+	vectors := []struct {
+		err    error
+		urls   []string
+		status int
+		code   string
+	}{
+		{err: errors.New("webrisk: no database loaded"), status: http.StatusServiceUnavailable, code: codeBackendUnavailable},
+		{err: fmt.Errorf("lookup: %w", webrisk.ErrQuotaExhausted), status: http.StatusTooManyRequests, code: codeQuotaExceeded},
+		{err: webrisk.ErrStale, urls: []string{"http://safe.test/"}, status: http.StatusServiceUnavailable, code: codeStaleDatabase},
+		{err: webrisk.ErrCircuitOpen, status: http.StatusServiceUnavailable, code: codeBackendUnavailable},
+		{err: webrisk.ErrSchemeRejected, urls: []string{"ftp://safe.test/"}, status: http.StatusBadRequest, code: codeSchemeRejected},
+		{err: errors.New("webrisk: missing ']' in host"), urls: []string{"http://safe.test/", "http://[::1/"}, status: http.StatusBadRequest, code: codeInvalidFormat},
+	}
+	for i, v := range vectors {
+		resp := httptest.NewRecorder()
+		backendError(resp, v.err, v.urls...)
+		var p problem
+		if err := json.Unmarshal(resp.Body.Bytes(), &p); err != nil {
+			t.Fatalf("test %d, unexpected error: %v", i, err)
+		}
+		want := problem{Type: "about:blank", Title: http.StatusText(v.status), Status: v.status, Detail: v.err.Error(), Code: v.code}
+		if resp.Code != v.status || p != want {
+			t.Errorf("test %d, response %d %+v, want %d %+v", i, resp.Code, p, v.status, want)
+		}
+		if ct := resp.Header().Get("Content-Type"); ct != mimeProblem {
+			t.Errorf("test %d, Content-Type %q, want %q", i, ct, mimeProblem)
+		}
+	}
+
+	api := newFakeAPI()
+	defer api.Close()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	resp := httptest.NewRecorder()
+	serveLookup(resp, httptest.NewRequest("DELETE", lookupPath, nil), wr, newEventHub("", nil), newLatencies(""))
+	if want := `{"type":"about:blank","title":"Bad Request","status":400,"detail":"invalid method","code":"invalid_method"}` + "\n"; resp.Body.String() != want {
+		t.Errorf("body %s, want %s", resp.Body, want)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var ctxID string
+	h := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctxID = webrisk.RequestIDFromContext(r.Context())
+		problemError(w, "failed", http.StatusServiceUnavailable, codeBackendUnavailable)
+	}))
+	for i, id := range []string{"client-42", "", "not valid"} {
+		req := httptest.NewRequest("GET", lookupPath, nil)
+		if id != "" {
+			req.Header.Set(webrisk.RequestIDHeader, id)
+		}
+		resp := httptest.NewRecorder()
+		h.ServeHTTP(resp, req)
+		got := resp.Header().Get(webrisk.RequestIDHeader)
+		if id == "client-42" && got != id {
+			t.Errorf("test %d, request ID %q, want %q", i, got, id)
+		}
+		if id != "client-42" && len(got) != 36 {
+			t.Errorf("test %d, request ID %q, want a new UUID", i, got)
+		}
+		var p problem
+		if err := json.Unmarshal(resp.Body.Bytes(), &p); err != nil {
+			t.Fatalf("test %d, unexpected error: %v", i, err)
+		}
+		if ctxID != got || p.RequestID != got {
+			t.Errorf("test %d, request ID %q in context, %q in error, want %q", i, ctxID, p.RequestID, got)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	api := newFakeAPI()
+	defer api.Close()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	events := newEventHub("", nil)
+	defer func(origins map[string]bool) { corsOrigins = origins }(corsOrigins)
+	var err error
+	if corsOrigins, err = parseCORSOrigins("chrome-extension://abc, https://intranet.example/"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := parseCORSOrigins("intranet.example"); err == nil {
+		t.Errorf("unexpected success for an origin without a scheme")
+	}
+
+	// verdict returns the JSON members that tell how long the verdict for
+	// rawURL may be cached.
+	verdict := func(rawURL string) string {
+		threats, err := wr.LookupURLs([]string{rawURL})
+		if err != nil {
+			t.Fatalf("unexpected lookup error: %v", err)
+		}
+		cc, err := wr.CacheControl(rawURL, threats[0])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		name := "safeUntil"
+		if len(threats[0]) > 0 {
+			name = "expireTime"
+		}
+		return fmt.Sprintf(`,%q:%q,"revalidateAfter":%q}`, name,
+			cc.Expire.UTC().Truncate(time.Second).Format(time.RFC3339), cc.RevalidateAfter.UTC().Truncate(time.Second).Format(time.RFC3339))
+	}
+	safe := `{"url":"http://safe.test/","safe":true,"threatTypes":[]` + verdict("http://safe.test/")
+	sum := sha256.Sum256([]byte(safe))
+	safeTag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	vectors := []struct {
+		method, target, origin, ifNoneMatch string
+		code                                int
+		allowOrigin, body                   string
+	}{
+		{method: "GET", target: "/lookup?url=http://safe.test/", code: http.StatusOK, body: safe},
+		{method: "GET", target: "/lookup?url=http://safe.test/", origin: "chrome-extension://abc", code: http.StatusOK, allowOrigin: "chrome-extension://abc", body: safe},
+		{method: "GET", target: "/lookup?url=http://safe.test/", origin: "https://intranet.example", ifNoneMatch: `W/"x", ` + safeTag, code: http.StatusNotModified, allowOrigin: "https://intranet.example"},
+		{method: "GET", target: "/lookup?url=http://evil.test/", origin: "chrome-extension://abc", code: http.StatusOK, allowOrigin: "chrome-extension://abc", body: `{"url":"http://evil.test/","safe":false,"threatTypes":["MALWARE"]` + verdict("http://evil.test/")},
+		{method: "GET", target: "/lookup?url=ftp://files.test/", code: http.StatusOK, body: `{"url":"ftp://files.test/","safe":false,"threatTypes":[]}`},
+		{method: "GET", target: "/lookup?url=http://safe.test/", origin: "https://evil.test", code: http.StatusForbidden},
+		{method: "OPTIONS", target: "/lookup", origin: "chrome-extension://abc", code: http.StatusNoContent, allowOrigin: "chrome-extension://abc"},
+		{method: "OPTIONS", target: "/lookup", origin: "https://evil.test", code: http.StatusForbidden},
+		{method: "GET", target: "/lookup", code: http.StatusBadRequest},
+		{method: "POST", target: "/lookup?url=http://safe.test/", code: http.StatusBadRequest},
+	}
+	for i, v := range vectors {
+		req := httptest.NewRequest(v.method, v.target, nil)
+		if v.origin != "" {
+			req.Header.Set("Origin", v.origin)
+		}
+		if v.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", v.ifNoneMatch)
+		}
+		resp := httptest.NewRecorder()
+		serveLookup(resp, req, wr, events, newLatencies(""))
+		if resp.Code != v.code || resp.Header().Get("Access-Control-Allow-Origin") != v.allowOrigin {
+			t.Errorf("test %d, response %d for origin %q, want %d for %q", i, resp.Code, resp.Header().Get("Access-Control-Allow-Origin"), v.code, v.allowOrigin)
+		}
+		if v.body != "" && resp.Body.String() != v.body {
+			t.Errorf("test %d, body %q, want %q", i, resp.Body, v.body)
+		}
+		if v.code == http.StatusOK || v.code == http.StatusNotModified {
+			if cc := resp.Header().Get("Cache-Control"); !strings.HasPrefix(cc, "private, max-age=") || resp.Header().Get("ETag") == "" {
+				t.Errorf("test %d, Cache-Control %q and ETag %q", i, cc, resp.Header().Get("ETag"))
+			}
+		}
+	}
+}
+
+func TestUnshorten(t *testing.T) {
+	short := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusMovedPermanently)
+		case "/b":
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			http.Redirect(w, r, "http://evil.test/landing", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/ftp":
+			http.Redirect(w, r, "ftp://files.test/", http.StatusFound)
+		}
+	}))
+	defer short.Close()
+
+	if chain := unshorten(context.Background(), short.URL+"/a", 3); len(chain) != 0 {
+		t.Errorf("unshorten() = %q, want no redirects followed to a private address", chain)
+	}
+	defer func(c *http.Client, hops int, lookups bool) {
+		unshortenClient, unshortenHops, unshortenLookups = c, hops, lookups
+	}(unshortenClient, unshortenHops, unshortenLookups)
+	unshortenClient = newPublicClient(true)
+
+	vectors := []struct {
+		url   string
+		hops  int
+		chain []string
+	}{
+		{url: short.URL + "/a", hops: 1, chain: []string{short.URL + "/b"}},
+		{url: short.URL + "/a", hops: 3, chain: []string{short.URL + "/b", "http://evil.test/landing"}},
+		{url: short.URL + "/loop", hops: 3},
+		{url: short.URL + "/ftp", hops: 3},
+		{url: short.URL + "/safe", hops: 3},
+	}
+	for i, v := range vectors {
+		if chain := unshorten(context.Background(), v.url, v.hops); !reflect.DeepEqual(chain, v.chain) {
+			t.Errorf("test %d, unshorten() = %q, want %q", i, chain, v.chain)
+		}
+	}
+
+	api := newFakeAPI()
+	defer api.Close()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	statikFS, err := fs.New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events := newEventHub("", nil)
+	target := "/r?url=" + url.QueryEscape(short.URL+"/a")
+	for _, hops := range []int{0, 3} {
+		unshortenHops = hops
+		resp := httptest.NewRecorder()
+		serveRedirector(resp, httptest.NewRequest("GET", target, nil), wr, events, newLatencies(""), statikFS)
+		if hops == 0 && (resp.Code != http.StatusFound || resp.Header().Get("Location") != short.URL+"/a") {
+			t.Errorf("redirector without unshortening answered %d to %q", resp.Code, resp.Header().Get("Location"))
+		}
+		if hops > 0 && (resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), "Attackers currently on evil.test")) {
+			t.Errorf("redirector with unshortening answered %d, want the interstitial for the last hop", resp.Code)
+		}
+	}
+
+	for _, lookups := range []bool{false, true} {
+		unshortenLookups = lookups
+		resp := httptest.NewRecorder()
+		serveCheck(resp, httptest.NewRequest("GET", "/check?url="+url.QueryEscape(short.URL+"/a"), nil), wr, events, newLatencies(""))
+		if want := map[bool]int{false: http.StatusNoContent, true: http.StatusForbidden}[lookups]; resp.Code != want {
+			t.Errorf("check with unshortenLookups=%v answered %d, want %d", lookups, resp.Code, want)
+		}
+	}
+}
+
+func TestThreatPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	os.WriteFile(path, []byte(`{"MALWARE": "warn", "UNWANTED_SOFTWARE": "allow", "SOCIAL_ENGINEERING": "block"}`), 0644)
+	p, err := loadThreatActions(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := threatActions{
+		webrisk.ThreatTypeMalware:           redirectWarn,
+		webrisk.ThreatTypeUnwantedSoftware:  redirectAllow,
+		webrisk.ThreatTypeSocialEngineering: redirectBlock,
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("loadThreatActions() = %v, want %v", p, want)
+	}
+	for _, bad := range []string{`{"MALWARE": "ignore"}`, `{"VIRUS": "warn"}`, `["MALWARE"]`} {
+		os.WriteFile(path, []byte(bad), 0644)
+		if _, err := loadThreatActions(path); err == nil {
+			t.Errorf("loadThreatActions(%s) succeeded, want an error", bad)
+		}
+	}
+
+	vectors := []struct {
+		threats []webrisk.ThreatType
+		action  redirectAction
+	}{
+		{[]webrisk.ThreatType{webrisk.ThreatTypeUnwantedSoftware}, redirectAllow},
+		{[]webrisk.ThreatType{webrisk.ThreatTypeUnwantedSoftware, webrisk.ThreatTypeMalware}, redirectWarn},
+		{[]webrisk.ThreatType{webrisk.ThreatTypeMalware, webrisk.ThreatTypeSocialEngineering}, redirectBlock},
+		{[]webrisk.ThreatType{webrisk.ThreatTypeSocialEngineeringExtended}, redirectBlock},
+	}
+	for i, v := range vectors {
+		var threats []webrisk.URLThreat
+		for _, tt := range v.threats {
+			threats = append(threats, webrisk.URLThreat{ThreatType: tt})
+		}
+		if action := p.action(threats); action != v.action {
+			t.Errorf("test %d, action() = %v, want %v", i, action, v.action)
+		}
+	}
+
+	api := newFakeAPI()
+	defer api.Close()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	statikFS, err := fs.New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events := newEventHub("", nil)
+	defer func(p threatActions) { redirectPolicy = p }(redirectPolicy)
+	for _, action := range []redirectAction{redirectBlock, redirectWarn, redirectAllow} {
+		redirectPolicy = threatActions{webrisk.ThreatTypeMalware: action}
+		resp := httptest.NewRecorder()
+		serveRedirector(resp, httptest.NewRequest("GET", "/r?url=http://evil.test/", nil), wr, events, newLatencies(""), statikFS)
+		proceed := strings.Contains(resp.Body.String(), `id="proceed-link" href="http://evil.test/"`)
+		switch {
+		case action == redirectAllow && (resp.Code != http.StatusFound || resp.Header().Get("Location") != "http://evil.test/"):
+			t.Errorf("allow answered %d to %q, want a redirect", resp.Code, resp.Header().Get("Location"))
+		case action != redirectAllow && (resp.Code != http.StatusOK || proceed != (action == redirectWarn)):
+			t.Errorf("action %v answered %d, with a link to the site: %v", action, resp.Code, proceed)
+		}
+	}
+}
+
+func TestRedirectorUnverified(t *testing.T) {
+	api := newFakeAPI()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	statikFS, err := fs.New()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The database still answers for safe URLs, but the API cannot confirm
+	// the match of evil.test.
+	api.Close()
+
+	vectors := []struct {
+		url  string
+		code int
+		body string
+	}{
+		{url: "http://safe.test/", code: http.StatusFound},
+		{url: "http://evil.test/", code: http.StatusServiceUnavailable, body: "A possible threat on evil.test could not be confirmed"},
+	}
+	for i, v := range vectors {
+		resp := httptest.NewRecorder()
+		serveRedirector(resp, httptest.NewRequest("GET", "/r?url="+url.QueryEscape(v.url), nil), wr, newEventHub("", nil), newLatencies(""), statikFS)
+		if resp.Code != v.code {
+			t.Errorf("test %d, response %d, want %d", i, resp.Code, v.code)
+		}
+		if v.body != "" && (!strings.Contains(resp.Body.String(), v.body) || !strings.Contains(resp.Body.String(), `id="retry-button"`)) {
+			t.Errorf("test %d, body %s, want the interstitial of unverified links", i, resp.Body)
+		}
+	}
+}
+
+func TestExtractQRURLs(t *testing.T) {
+	vectors := []struct {
+		payload string
+		urls    []string
+	}{
+		{payload: "https://evil.test/login", urls: []string{"https://evil.test/login"}},
+		{payload: "  www.evil.test/pay.  ", urls: []string{"http://www.evil.test/pay"}},
+		{payload: "MEBKM:TITLE:Bank;URL:http://evil.test/a;;", urls: []string{"http://evil.test/a"}},
+		{payload: "MATMSG:TO:a@b.test;SUB:Invoice;BODY:Pay at https://evil.test/pay (today);;", urls: []string{"https://evil.test/pay"}},
+		{payload: "SMSTO:+15555550100:Your parcel: https://evil.test/p?id=1", urls: []string{"https://evil.test/p?id=1"}},
+		{payload: "intent://evil.test/login#Intent;scheme=https;package=com.example;S.browser_fallback_url=https%3A%2F%2Ffallback.test%2F;end",
+			urls: []string{"https://fallback.test/", "https://evil.test/login"}},
+		{payload: "bankapp://open?next=https%3A%2F%2Fevil.test%2Fx&mode=1", urls: []string{"https://evil.test/x"}},
+		{payload: "WIFI:T:WPA;S:guest;P:secret;;"},
+		{payload: "tel:+15555550100"},
+	}
+	for i, v := range vectors {
+		if urls := extractQRURLs(v.payload); !reflect.DeepEqual(urls, v.urls) {
+			t.Errorf("test %d, extractQRURLs() = %q, want %q", i, urls, v.urls)
+		}
+	}
+}
+
+func TestQR(t *testing.T) {
+	api := newFakeAPI()
+	defer api.Close()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	events := newEventHub("", nil)
+
+	vectors := []struct {
+		contentType, body string
+		code              int
+		resp              string
+	}{
+		{contentType: "text/plain", body: "https://evil.test/", code: http.StatusOK,
+			resp: `{"safe":false,"urls":[{"payload":0,"url":"https://evil.test/","safe":false,"threatTypes":["MALWARE"]}]}`},
+		{contentType: mimeJSON, body: `{"payloads":["WIFI:S:x;;","bankapp://open?u=http%3A%2F%2Fsafe.test%2F"]}`, code: http.StatusOK,
+			resp: `{"safe":true,"urls":[{"payload":1,"url":"http://safe.test/","safe":true,"threatTypes":[]}]}`},
+		{contentType: "text/plain", body: "tel:+15555550100", code: http.StatusOK, resp: `{"safe":true,"urls":[]}`},
+		{contentType: "image/png", body: "\x89PNG", code: http.StatusUnsupportedMediaType},
+		{contentType: mimeJSON, body: `{`, code: http.StatusBadRequest},
+	}
+	for i, v := range vectors {
+		req := httptest.NewRequest("POST", qrPath, strings.NewReader(v.body))
+		req.Header.Set("Content-Type", v.contentType)
+		resp := httptest.NewRecorder()
+		serveQR(resp, req, wr, events, newLatencies(""))
+		if resp.Code != v.code {
+			t.Errorf("test %d, response %d, want %d", i, resp.Code, v.code)
+		}
+		if v.resp != "" && resp.Body.String() != v.resp {
+			t.Errorf("test %d, body %s, want %s", i, resp.Body, v.resp)
+		}
+	}
+}
+
+func TestExtractLinks(t *testing.T) {
+	page := `<html><head><base href="https://cdn.test/a/"><meta http-equiv="Refresh" content="0; url='http://next.test/'"></head>
+<body><a href="b.html#top">b</a> <a href="b.html">again</a> <a href="mailto:x@y.test">mail</a>
+<img src="//img.test/x.png" srcset="x1.png 1x, /x2.png 2x"><form action="https://evil.test/login"></form>
+<a href="javascript:void(0)">js</a></body></html>`
+	links, err := extractLinks(strings.NewReader(page), &url.URL{Scheme: "https", Host: "page.test", Path: "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []pageLink{
+		{URL: "http://next.test/", Element: "meta"},
+		{URL: "https://cdn.test/a/b.html", Element: "a"},
+		{URL: "https://img.test/x.png", Element: "img"},
+		{URL: "https://cdn.test/a/x1.png", Element: "img"},
+		{URL: "https://cdn.test/x2.png", Element: "img"},
+		{URL: "https://evil.test/login", Element: "form"},
+	}
+	if !reflect.DeepEqual(links, want) {
+		t.Errorf("extractLinks() = %v, want %v", links, want)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	api := newFakeAPI()
+	defer api.Close()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	events := newEventHub("", nil)
+
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/page", http.StatusFound)
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			io.WriteString(w, `<a href="https://evil.test/">x</a><img src="/logo.png">`)
+		default:
+			w.Header().Set("Content-Type", "image/png")
+		}
+	}))
+	defer page.Close()
+
+	vectors := []struct {
+		contentType, query, body string
+		allowPrivate             bool
+		code                     int
+		resp                     string
+	}{
+		{contentType: "text/html", query: "?base=https://safe.test/", body: `<a href="/a">a</a><a href="https://evil.test/">b</a>`, code: http.StatusOK,
+			resp: `{"safe":false,"links":[{"url":"https://safe.test/a","element":"a","safe":true,"threatTypes":[]},{"url":"https://evil.test/","element":"a","safe":false,"threatTypes":["MALWARE"]}]}`},
+		{contentType: "text/html", body: `<p>No links, <a href="/relative">without a base</a></p>`, code: http.StatusOK, resp: `{"safe":true,"links":[]}`},
+		{contentType: mimeJSON, body: `{"url":"` + page.URL + `/moved"}`, allowPrivate: true, code: http.StatusOK,
+			resp: `{"safe":false,"links":[{"url":"https://evil.test/","element":"a","safe":false,"threatTypes":["MALWARE"]},{"url":"` + page.URL + `/logo.png","element":"img","safe":true,"threatTypes":[]}]}`},
+		{contentType: mimeJSON, body: `{"url":"` + page.URL + `/page"}`, code: http.StatusBadGateway},
+		{contentType: mimeJSON, body: `{"url":"` + page.URL + `/logo.png"}`, allowPrivate: true, code: http.StatusBadGateway},
+		{contentType: mimeJSON, body: `{"url":"file:///etc/passwd"}`, code: http.StatusBadGateway},
+		{contentType: "text/plain", body: "https://evil.test/", code: http.StatusUnsupportedMediaType},
+	}
+	defer func(c *http.Client) { linksClient = c }(linksClient)
+	for i, v := range vectors {
+		linksClient = newLinksClient(v.allowPrivate)
+		req := httptest.NewRequest("POST", linksPath+v.query, strings.NewReader(v.body))
+		req.Header.Set("Content-Type", v.contentType)
+		resp := httptest.NewRecorder()
+		serveLinks(resp, req, wr, events, newLatencies(""))
+		if resp.Code != v.code {
+			t.Errorf("test %d, response %d, want %d", i, resp.Code, v.code)
+		}
+		if v.resp != "" && resp.Body.String() != v.resp {
+			t.Errorf("test %d, body %s, want %s", i, resp.Body, v.resp)
+		}
+	}
+}
+
+func TestLinksUnverified(t *testing.T) {
+	api := newFakeAPI()
+	wr := newFakeClient(t, api)
+	defer wr.Close()
+	events := newEventHub("", nil)
+	// The database still answers for safe URLs, but the API cannot confirm
+	// the match of evil.test.
+	api.Close()
+
+	vectors := []struct {
+		body string
+		code int
+		resp string
+	}{
+		{body: `<a href="https://safe.test/">a</a><a href="https://evil.test/">b</a>`, code: http.StatusOK,
+			resp: `{"safe":false,"links":[{"url":"https://safe.test/","element":"a","safe":true,"threatTypes":[]},{"url":"https://evil.test/","element":"a","safe":false,"threatTypes":[],"unverified":true}]}`},
+		{body: `<a href="https://evil.test/">b</a>`, code: http.StatusServiceUnavailable},
+	}
+	for i, v := range vectors {
+		req := httptest.NewRequest("POST", linksPath, strings.NewReader(v.body))
+		req.Header.Set("Content-Type", "text/html")
+		resp := httptest.NewRecorder()
+		serveLinks(resp, req, wr, events, newLatencies(""))
+		if resp.Code != v.code {
+			t.Errorf("test %d, response %d, want %d", i, resp.Code, v.code)
+		}
+		if v.resp != "" && resp.Body.String() != v.resp {
+			t.Errorf("test %d, body %s, want %s", i, resp.Body, v.resp)
+		}
+	}
+}
+
+func TestOpenAPIPaths(t *testing.T) {
+	data, err := os.ReadFile("../../clients/openapi.json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	served := map[string]bool{
+		findThreatPath: true, lookupPath: true, linksPath: true, statusPath: true,
+		adminUpdatePath: true, adminVerifyPath: true, adminExportPath: true, adminPrefixesPath: true,
+		adminPrewarmPath: true, adminLookupPath: true, adminPurgePath: true, adminStatsResetPath: true,
+	}
+	for path := range spec.Paths {
+		if !served[path] {
+			t.Errorf("openapi.json defines %s, which wrserver does not serve", path)
+		}
+	}
+}
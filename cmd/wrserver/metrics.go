@@ -0,0 +1,239 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/google/webrisk"
+)
+
+const (
+	metricsPath = "/metrics"
+	mimeMetrics = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// metric is a single metric in the Prometheus text exposition format.
+type metric struct {
+	name, help, kind string
+	samples          []sample
+}
+
+// sample is a value of a metric, with an optional label.
+type sample struct {
+	label, labelValue string
+	value             float64
+}
+
+// serveMetrics writes the statistics of wr, and the latency histograms of
+// the requests it served, in the Prometheus text format.
+func serveMetrics(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient, lat *latencies) {
+	stats, _ := wr.Status()
+	var buf bytes.Buffer
+	writeMetrics(&buf, stats)
+	lat.write(&buf)
+	resp.Header().Set("Content-Type", mimeMetrics)
+	resp.Write(buf.Bytes())
+}
+
+// writeMetrics writes stats to w in the Prometheus text format.
+func writeMetrics(w io.Writer, stats webrisk.Stats) {
+	metrics := []metric{{
+		name: "webrisk_queries_total",
+		help: "Number of URL hash lookups, by what satisfied them.",
+		kind: "counter",
+		samples: []sample{
+			{"source", "database", float64(stats.QueriesByDatabase)},
+			{"source", "cache", float64(stats.QueriesByCache)},
+			{"source", "api", float64(stats.QueriesByAPI)},
+			{"source", "fail", float64(stats.QueriesFail)},
+			{"source", "unconfirmed", float64(stats.QueriesUnconfirmed)},
+		},
+	}, {
+		name: "webrisk_cache_lookups_total",
+		help: "Number of cache lookups, by result.",
+		kind: "counter",
+		samples: []sample{
+			{"result", "positive_hit", float64(stats.CachePositiveHits)},
+			{"result", "negative_hit", float64(stats.CacheNegativeHits)},
+			{"result", "miss", float64(stats.CacheMisses)},
+		},
+	}, {
+		name:    "webrisk_cache_expired_total",
+		help:    "Number of cache misses due to an expired entry.",
+		kind:    "counter",
+		samples: []sample{{value: float64(stats.CacheExpired)}},
+	}, {
+		name:    "webrisk_cache_evictions_total",
+		help:    "Number of cache entries evicted before they expired.",
+		kind:    "counter",
+		samples: []sample{{value: float64(stats.CacheEvictions)}},
+	}, {
+		name:    "webrisk_cache_refreshes_total",
+		help:    "Number of cached results refreshed before they expired.",
+		kind:    "counter",
+		samples: []sample{{value: float64(stats.CacheRefreshes)}},
+	}, {
+		name: "webrisk_cache_entries",
+		help: "Number of entries in the in-memory cache, by kind.",
+		kind: "gauge",
+		samples: []sample{
+			{"kind", "positive", float64(stats.CachePositiveEntries)},
+			{"kind", "negative", float64(stats.CacheNegativeEntries)},
+		},
+	}, {
+		name:    "webrisk_cache_memory_bytes",
+		help:    "Approximate number of bytes used by the cache.",
+		kind:    "gauge",
+		samples: []sample{{value: float64(stats.CacheMemory)}},
+	}, {
+		name:    "webrisk_database_memory_bytes",
+		help:    "Approximate number of bytes used by the database.",
+		kind:    "gauge",
+		samples: []sample{{value: float64(stats.DatabaseMemory)}},
+	}, {
+		name:    "webrisk_database_update_lag_seconds",
+		help:    "Time since the last missed database update.",
+		kind:    "gauge",
+		samples: []sample{{value: stats.DatabaseUpdateLag.Seconds()}},
+	}, {
+		name:    "webrisk_database_age_seconds",
+		help:    "Time since the last successful database update.",
+		kind:    "gauge",
+		samples: []sample{{value: stats.DatabaseAge.Seconds()}},
+	}}
+
+	lists := metric{
+		name: "webrisk_list_entries",
+		help: "Number of hash prefixes in each threat list.",
+		kind: "gauge",
+	}
+	for tt, ls := range stats.Lists {
+		lists.samples = append(lists.samples, sample{"threat_type", tt.String(), float64(ls.Entries)})
+	}
+	sort.Slice(lists.samples, func(i, j int) bool {
+		return lists.samples[i].labelValue < lists.samples[j].labelValue
+	})
+	if len(lists.samples) > 0 {
+		metrics = append(metrics, lists)
+	}
+
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, s := range m.samples {
+			if s.label == "" {
+				fmt.Fprintf(w, "%s %g\n", m.name, s.value)
+			} else {
+				fmt.Fprintf(w, "%s{%s=%q} %g\n", m.name, s.label, s.labelValue, s.value)
+			}
+		}
+	}
+}
+
+// newOTLPRecorder returns a recorder that pushes the metrics of a tenant, or
+// of the default client if tenant is empty, to the OTLP endpoint with the
+// headers, such as "Authorization=Bearer XXX,X-Scope-OrgID=acme". It returns
+// nil if endpoint is empty.
+func newOTLPRecorder(endpoint, headers, tenant string) (*webrisk.OTLPRecorder, error) {
+	if endpoint == "" {
+		return nil, nil
+	}
+	r, err := webrisk.NewOTLPRecorder(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	r.Headers = make(map[string]string)
+	for _, h := range strings.Split(headers, ",") {
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(h, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid header: %q", h)
+		}
+		r.Headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	r.Attributes = map[string]string{"service.name": "wrserver"}
+	if host, err := os.Hostname(); err == nil {
+		r.Attributes["host.name"] = host
+	}
+	if tenant != "" {
+		r.Attributes["webrisk.tenant"] = tenant
+	}
+	return r, nil
+}
+
+// newMetricsRecorder returns a recorder that pushes the metrics of a tenant,
+// or of the default client if tenant is empty, to the OTLP endpoint and the
+// StatsD server with the given URLs, if any, or nil if there are none.
+func newMetricsRecorder(otlpEndpoint, otlpHeaders, statsdURL, statsdTags, tenant string) (webrisk.MetricsRecorder, error) {
+	var rs metricsRecorders
+	otlp, err := newOTLPRecorder(otlpEndpoint, otlpHeaders, tenant)
+	if err != nil {
+		return nil, err
+	}
+	if otlp != nil {
+		rs = append(rs, otlp)
+	}
+	if statsdURL != "" {
+		sr, err := webrisk.NewStatsDRecorder(statsdURL)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range strings.Split(statsdTags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				sr.Tags = append(sr.Tags, tag)
+			}
+		}
+		switch {
+		case tenant == "":
+		case sr.DogStatsD:
+			sr.Tags = append(sr.Tags, "tenant:"+tenant)
+		default:
+			sr.Prefix = webrisk.DefaultStatsDPrefix + tenant + "."
+		}
+		rs = append(rs, sr)
+	}
+	switch len(rs) {
+	case 0:
+		return nil, nil
+	case 1:
+		return rs[0], nil
+	}
+	return rs, nil
+}
+
+// metricsRecorders records the statistics with every recorder in turn.
+type metricsRecorders []webrisk.MetricsRecorder
+
+func (rs metricsRecorders) RecordStats(ctx context.Context, stats webrisk.Stats) error {
+	var errs []string
+	for _, r := range rs {
+		if err := r.RecordStats(ctx, stats); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/webrisk"
+)
+
+// notificationBuffer is the number of notifications waiting to be posted
+// before more are dropped. Operational events are rare, so this is only
+// reached if the webhooks are down.
+const notificationBuffer = 64
+
+// notification is the JSON object posted to the -notifyWebhooks for an
+// operational event of a client.
+type notification struct {
+	webrisk.Event
+	Host   string `json:"host,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// notifier posts the operational events of the clients, such as failed
+// database updates, to webhooks, so that the on-call can be paged without a
+// separate monitoring integration. Notifications are posted in the
+// background, in order, so that lookups are never held up.
+type notifier struct {
+	urls   []string
+	client *http.Client
+	host   string
+
+	mu     sync.RWMutex
+	closed bool
+	ch     chan notification
+	done   chan struct{} // Closed when every notification is posted
+}
+
+// newNotifier returns a notifier that posts to the webhook URLs in urls,
+// separated by commas, or nil if there are none.
+func newNotifier(urls string) (*notifier, error) {
+	n := &notifier{client: &http.Client{Timeout: sinkTimeout}}
+	for _, rawurl := range strings.Split(urls, ",") {
+		if rawurl = strings.TrimSpace(rawurl); rawurl == "" {
+			continue
+		}
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return nil, err
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook URL: %q", rawurl)
+		}
+		n.urls = append(n.urls, rawurl)
+	}
+	if len(n.urls) == 0 {
+		return nil, nil
+	}
+	n.host, _ = os.Hostname()
+	n.ch = make(chan notification, notificationBuffer)
+	n.done = make(chan struct{})
+	go func() {
+		defer close(n.done)
+		for e := range n.ch {
+			for _, u := range n.urls {
+				if err := postJSON(n.client, u, e); err != nil {
+					log.Printf("Notifying %s of %v failed: %v", u, e.Type, err)
+				}
+			}
+		}
+	}()
+	return n, nil
+}
+
+// hook returns the function that passes the events of the named tenant, or
+// of the default client if tenant is empty, to n, for Config.Events. It
+// returns nil if n is nil.
+func (n *notifier) hook(tenant string) func(webrisk.Event) {
+	if n == nil {
+		return nil
+	}
+	return func(e webrisk.Event) {
+		n.mu.RLock()
+		defer n.mu.RUnlock()
+		if n.closed {
+			return
+		}
+		select {
+		case n.ch <- notification{Event: e, Host: n.host, Tenant: tenant}:
+		default:
+			log.Printf("Notification dropped: %v: %s", e.Type, e.Message)
+		}
+	}
+}
+
+// close posts the pending notifications.
+func (n *notifier) close() {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.ch)
+	}
+	n.mu.Unlock()
+	<-n.done
+}
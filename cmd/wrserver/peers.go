@@ -0,0 +1,106 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+// peerRefreshPeriod is how often peers discovered through DNS are looked up
+// again.
+const peerRefreshPeriod = 30 * time.Second
+
+// peerGroup is the set of peer caches of this instance, one for the root
+// client and each tenant, keyed by the path prefix they are served under.
+// All of them share the same list of peers.
+type peerGroup struct {
+	caches     map[string]*webrisk.PeerCache
+	maxEntries int // Of each cache, or the default if zero
+
+	// If the peers are discovered through DNS, host and port are looked up
+	// to find them.
+	host, port string
+}
+
+// newPeerGroup parses the -peers specification, which is either a
+// comma-separated list of base URLs, or "dns://host:port" to use every
+// address that host resolves to, such as a headless Kubernetes service.
+func newPeerGroup(spec string) (*peerGroup, error) {
+	pg := &peerGroup{caches: make(map[string]*webrisk.PeerCache)}
+	if strings.HasPrefix(spec, "dns://") {
+		var err error
+		if pg.host, pg.port, err = net.SplitHostPort(strings.TrimPrefix(spec, "dns://")); err != nil {
+			return nil, err
+		}
+	}
+	return pg, nil
+}
+
+// cache returns a new peer cache for the client served under prefix.
+func (pg *peerGroup) cache(prefix, self, spec, secret string) *webrisk.PeerCache {
+	pc := webrisk.NewPeerCache(strings.TrimSuffix(self, "/")+prefix, nil)
+	pc.Secret = secret
+	pc.MaxEntries = pg.maxEntries
+	if pg.host == "" {
+		pg.setPeers(pc, prefix, strings.Split(spec, ","))
+	}
+	pg.caches[prefix] = pc
+	return pc
+}
+
+// setPeers sets the peers of pc, served under prefix, from their base URLs.
+func (pg *peerGroup) setPeers(pc *webrisk.PeerCache, prefix string, bases []string) {
+	var peers []string
+	for _, b := range bases {
+		if b = strings.TrimSuffix(strings.TrimSpace(b), "/"); b != "" {
+			peers = append(peers, b+prefix)
+		}
+	}
+	pc.SetPeers(peers)
+}
+
+// discover looks up the peers through DNS, and then keeps doing so every
+// peerRefreshPeriod. It does nothing for a static list of peers.
+func (pg *peerGroup) discover() {
+	if pg.host == "" {
+		return
+	}
+	var last []string
+	for {
+		addrs, err := net.LookupHost(pg.host)
+		if err != nil {
+			log.Printf("Peer discovery failed: %v", err)
+		} else {
+			var bases []string
+			for _, a := range addrs {
+				bases = append(bases, "http://"+net.JoinHostPort(a, pg.port))
+			}
+			sort.Strings(bases)
+			if strings.Join(bases, ",") != strings.Join(last, ",") {
+				log.Printf("Peers: %v", bases)
+				for prefix, pc := range pg.caches {
+					pg.setPeers(pc, prefix, bases)
+				}
+				last = bases
+			}
+		}
+		time.Sleep(peerRefreshPeriod)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/google/webrisk"
+)
+
+// redirectAction is what the redirector does with the URLs of a threat type.
+// Stricter actions come first.
+type redirectAction int
+
+const (
+	redirectBlock redirectAction = iota // Show the interstitial
+	redirectWarn                        // Show the interstitial, with a link to the site
+	redirectAllow                       // Redirect, but record the detection
+)
+
+var redirectActionNames = map[string]redirectAction{
+	"block": redirectBlock,
+	"warn":  redirectWarn,
+	"allow": redirectAllow,
+}
+
+// UnmarshalText parses the name of an action, such as "warn".
+func (a *redirectAction) UnmarshalText(b []byte) error {
+	action, ok := redirectActionNames[string(b)]
+	if !ok {
+		return fmt.Errorf("unknown action: %q", b)
+	}
+	*a = action
+	return nil
+}
+
+// threatActions maps threat types to what the redirector does with their
+// URLs, as read from the file specified by -threatPolicy. Threat types that
+// it does not list are blocked.
+//
+// Example policy file:
+//
+//	{
+//	    "SOCIAL_ENGINEERING": "block",
+//	    "UNWANTED_SOFTWARE": "warn",
+//	    "SOCIAL_ENGINEERING_EXTENDED_COVERAGE": "allow"
+//	}
+type threatActions map[webrisk.ThreatType]redirectAction
+
+// redirectPolicy is the policy of the redirector, or nil if every threat
+// type is blocked.
+var redirectPolicy threatActions
+
+// action returns the strictest action for the threat types of threats.
+func (p threatActions) action(threats []webrisk.URLThreat) redirectAction {
+	action := redirectAllow
+	for _, t := range threats {
+		if a := p[t.ThreatType]; a < action {
+			action = a
+		}
+	}
+	return action
+}
+
+// loadThreatActions reads the policy file at path.
+func loadThreatActions(path string) (threatActions, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p threatActions
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/google/webrisk"
+)
+
+const mimeProblem = "application/problem+json"
+
+// The codes of the errors that endpoints respond with, for clients to tell
+// failures apart without parsing their details.
+const (
+	codeInvalidFormat      = "invalid_format"      // The request is malformed
+	codeInvalidMethod      = "invalid_method"      // The endpoint does not take the method
+	codeUnauthorized       = "unauthorized"        // The request lacks credentials
+	codeForbidden          = "forbidden"           // The request is not allowed
+	codeNotFound           = "not_found"           // There is nothing at the path
+	codeSchemeRejected     = "scheme_rejected"     // The scheme of the URL is rejected
+	codeTooLarge           = "too_large"           // The request asks for too much at once
+	codeOverloaded         = "overloaded"          // Too many requests are in progress
+	codeFetchFailed        = "fetch_failed"        // A page could not be fetched
+	codeBackendUnavailable = "backend_unavailable" // The database, the cache or the API failed
+	codeQuotaExceeded      = "quota_exceeded"      // The API quota is exhausted
+	codeStaleDatabase      = "stale_database"      // The database is too old to answer
+	codeInternal           = "internal_error"
+)
+
+// problem is an RFC 7807 problem details object, extended with the code of
+// the error and the ID of the request.
+type problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// problemError replies to the request with an application/problem+json
+// response of the given status, with the code and detail of the error. Like
+// http.Error, it does not otherwise end the request. Server errors are
+// logged with the ID of the request, for clients to report them.
+func problemError(resp http.ResponseWriter, detail string, status int, code string) {
+	h := resp.Header()
+	id := h.Get(webrisk.RequestIDHeader)
+	if status >= 500 && id != "" {
+		log.Printf("Request %s failed with %d %s: %s", id, status, code, detail)
+	}
+	buf, _ := json.Marshal(problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Code:      code,
+		RequestID: id,
+	})
+	h.Del("Content-Length")
+	h.Set("Content-Type", mimeProblem)
+	h.Set("X-Content-Type-Options", "nosniff")
+	resp.WriteHeader(status)
+	resp.Write(append(buf, '\n'))
+}
+
+// lookupFailed returns whether a lookup of several URLs that returned err,
+// with the sources of their verdicts, failed as a whole. Otherwise, the
+// verdicts of the URLs whose source is webrisk.SourceUnverified are reported
+// as unverified, and those of the others, such as the URLs that matched no
+// threat list, as usual.
+func lookupFailed(err error, sources []webrisk.LookupSource) bool {
+	if err == nil {
+		return false
+	}
+	for _, s := range sources {
+		if s != webrisk.SourceUnverified {
+			return false
+		}
+	}
+	return true
+}
+
+// backendError replies to the request with the error of a lookup of urls,
+// or of another call to the database, the cache or the API: invalid URLs are
+// the client's fault, an exhausted quota is a 429, and everything else a 503,
+// so that clients can retry elsewhere.
+func backendError(resp http.ResponseWriter, err error, urls ...string) {
+	for _, u := range urls {
+		if !webrisk.ValidURL(u) {
+			problemError(resp, err.Error(), http.StatusBadRequest, codeInvalidFormat)
+			return
+		}
+	}
+	switch {
+	case errors.Is(err, webrisk.ErrSchemeRejected):
+		problemError(resp, err.Error(), http.StatusBadRequest, codeSchemeRejected)
+	case errors.Is(err, webrisk.ErrQuotaExhausted):
+		problemError(resp, err.Error(), http.StatusTooManyRequests, codeQuotaExceeded)
+	case errors.Is(err, webrisk.ErrStale):
+		problemError(resp, err.Error(), http.StatusServiceUnavailable, codeStaleDatabase)
+	default:
+		problemError(resp, err.Error(), http.StatusServiceUnavailable, codeBackendUnavailable)
+	}
+}
@@ -45,6 +45,13 @@ interstitial.setupEvents = function() {
     window.history.back();
   });
 
+  // The "try again" button, shown when the link could not be verified.
+  if ($('retry-button')) {
+    $('retry-button').addEventListener('click', function() {
+      window.location.reload();
+    });
+  }
+
   // The "Details" button.
   $('details-button').addEventListener('click', function(event) {
     var hiddenDetails = $('details').classList.toggle('hidden');
@@ -0,0 +1,190 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/google/webrisk"
+)
+
+const (
+	qrPath = "/qr"
+
+	// qrMaxRequestSize is the maximum size of the payloads of a request.
+	qrMaxRequestSize = 64 << 10
+
+	// qrMaxURLs is the number of URLs that are looked up at most for a
+	// request.
+	qrMaxURLs = 100
+)
+
+// qrURLPattern matches the URLs embedded in the text of QR code payloads.
+var qrURLPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s"'<>;]+`)
+
+// extractQRURLs returns the http and https URLs that a QR code payload
+// leads to, once each: the payload itself, the URLs embedded in it, such as
+// in the URL field of MECARD, MEBKM, vCard and URLTO payloads or in the text
+// of MATMSG and SMSTO ones, and, for deep links of other schemes, the URLs
+// that they open, which are given by their query parameters, or by the
+// host, path and browser_fallback_url of Android intent URLs.
+func extractQRURLs(payload string) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	add := func(u string) {
+		u = strings.TrimRight(u, ".,)]}")
+		if strings.HasPrefix(strings.ToLower(u), "www.") {
+			u = "http://" + u
+		}
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	payload = strings.TrimSpace(payload)
+	for _, u := range qrURLPattern.FindAllString(payload, -1) {
+		add(u)
+	}
+	u, err := url.Parse(payload)
+	if err != nil || u.Scheme == "" || strings.EqualFold(u.Scheme, "http") || strings.EqualFold(u.Scheme, "https") {
+		return urls
+	}
+	// A deep link, such as intent://evil.example/login#Intent;scheme=https;end
+	// or app://open?url=https%3A%2F%2Fevil.example%2F.
+	if strings.EqualFold(u.Scheme, "intent") {
+		scheme := "http"
+		for _, param := range strings.Split(u.Fragment, ";") {
+			key, value, _ := strings.Cut(param, "=")
+			switch key {
+			case "scheme":
+				scheme = strings.ToLower(value)
+			case "S.browser_fallback_url":
+				if v, err := url.QueryUnescape(value); err == nil && isWebURL(v) {
+					add(v)
+				}
+			}
+		}
+		if u.Host != "" && (scheme == "http" || scheme == "https") {
+			add(scheme + "://" + u.Host + u.EscapedPath())
+		}
+	}
+	for _, values := range u.Query() {
+		for _, v := range values {
+			if isWebURL(v) {
+				add(v)
+			}
+		}
+	}
+	return urls
+}
+
+// isWebURL returns whether s is an http or https URL.
+func isWebURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (strings.EqualFold(u.Scheme, "http") || strings.EqualFold(u.Scheme, "https")) && u.Host != ""
+}
+
+// qrURL is the verdict of a URL of the payloads of QR codes.
+type qrURL struct {
+	Payload int `json:"payload"` // Index of the payload that the URL was found in
+	lookupResponse
+}
+
+// serveQR looks up the URLs that the payloads of QR codes lead to, for
+// security teams that triage QR code phishing: the text payload in the body,
+// or the payloads of a JSON object such as {"payloads":["..."]}. Images are
+// not decoded, so that QR codes must be decoded by the client, such as with
+// zbarimg.
+func serveQR(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient, events *eventHub, lat *latencies) {
+	timer, ctx := lat.start("qr", req)
+	defer timer.done()
+	if req.Method != "POST" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if strings.HasPrefix(mt, "image/") {
+		problemError(resp, "QR code images are not supported, post their decoded payloads", http.StatusUnsupportedMediaType, codeInvalidFormat)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, qrMaxRequestSize))
+	if err != nil {
+		problemError(resp, err.Error(), http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	payloads := []string{string(body)}
+	if mt == mimeJSON {
+		var in struct {
+			Payloads []string `json:"payloads"`
+		}
+		if err := json.Unmarshal(body, &in); err != nil {
+			problemError(resp, err.Error(), http.StatusBadRequest, codeInvalidFormat)
+			return
+		}
+		payloads = in.Payloads
+	}
+	var found []qrURL
+	var urls []string
+	for i, payload := range payloads {
+		for _, u := range extractQRURLs(payload) {
+			found = append(found, qrURL{Payload: i, lookupResponse: lookupResponse{URL: u, Safe: true, ThreatTypes: []webrisk.ThreatType{}}})
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) > qrMaxURLs {
+		problemError(resp, "too many URLs", http.StatusRequestEntityTooLarge, codeTooLarge)
+		return
+	}
+	if len(urls) > 0 {
+		timer.url = urls[0]
+		threats, sources, err := wr.LookupURLsSources(ctx, urls)
+		if lookupFailed(err, sources) {
+			timer.source = "error"
+			backendError(resp, err, urls...)
+			return
+		}
+		timer.source, timer.threats = sources[0].String(), threats[0]
+		for i := range found {
+			if d, ok := newDetection("qr", clientAddr(req), urls[i], threats[i], sources[i]); ok {
+				events.publish(d)
+				found[i].Safe, found[i].ThreatTypes = false, d.ThreatTypes
+			}
+			if sources[i] == webrisk.SourceUnverified {
+				found[i].Safe, found[i].Unverified = false, true
+			}
+			events.zone.addThreats(threats[i])
+		}
+	}
+	out := struct {
+		Safe bool    `json:"safe"`
+		URLs []qrURL `json:"urls"`
+	}{Safe: true, URLs: []qrURL{}}
+	for _, f := range found {
+		out.Safe = out.Safe && f.Safe
+		out.URLs = append(out.URLs, f)
+	}
+	buf, err := json.Marshal(out)
+	if err != nil {
+		problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
+		return
+	}
+	resp.Header().Set("Content-Type", mimeJSON)
+	resp.Write(buf)
+}
@@ -0,0 +1,198 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+// queryLogBuffer is the number of sampled queries waiting to be written
+// before more are dropped.
+const queryLogBuffer = 1024
+
+// queryLogURLs determines how much of the URLs looked up is kept in the
+// query log.
+type queryLogURLs int
+
+const (
+	queryLogHost queryLogURLs = iota // scheme://host/ only
+	queryLogPath                     // Without the query and fragment
+	queryLogFull                     // As looked up
+	queryLogHash                     // Hex SHA-256, or HMAC-SHA256 with a key
+)
+
+var queryLogURLNames = map[string]queryLogURLs{
+	"host": queryLogHost,
+	"path": queryLogPath,
+	"full": queryLogFull,
+	"hash": queryLogHash,
+}
+
+// queryRecord is a line of the query log.
+type queryRecord struct {
+	Time        time.Time            `json:"time"`
+	Tenant      string               `json:"tenant,omitempty"`
+	Endpoint    string               `json:"endpoint"`
+	URL         string               `json:"url"`
+	ThreatTypes []webrisk.ThreatType `json:"threatTypes,omitempty"`
+	Source      string               `json:"source"`
+}
+
+// queryLog writes a random sample of the lookups, whether or not they found
+// threats, to a rotated file, as a JSON object per line, to analyze what
+// clients look up, such as to choose the URLs to -prewarm. Unlike the
+// detection log, it does not record who asked, and URLs can be truncated or
+// hashed. Records are written in the background, and dropped if the file
+// cannot keep up.
+type queryLog struct {
+	rate float64 // Fraction of the lookups that are recorded
+	urls queryLogURLs
+	key  []byte // HMAC key for queryLogHash, if any
+
+	al      *webrisk.AuditLog
+	mu      sync.RWMutex
+	closed  bool
+	ch      chan queryRecord
+	done    chan struct{}
+	dropped int64 // Accessed atomically
+}
+
+// queries is the query log of the server, or nil if there is none.
+var queries *queryLog
+
+// newQueryLog returns a query log that writes the given fraction of lookups
+// to the file at path, with their URLs kept according to the urls mode:
+// "host" (the default), "path", "full" or "hash", keyed with key if not
+// empty. It returns nil if path is empty.
+func newQueryLog(path string, rate float64, urls, key string) (*queryLog, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if rate <= 0 || rate > 1 {
+		return nil, fmt.Errorf("invalid sampling rate: %v", rate)
+	}
+	mode := queryLogHost
+	if urls != "" {
+		var ok bool
+		if mode, ok = queryLogURLNames[urls]; !ok {
+			return nil, fmt.Errorf("invalid URL mode: %q", urls)
+		}
+	}
+	al, err := webrisk.OpenAuditLog(path, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	q := &queryLog{
+		rate: rate,
+		urls: mode,
+		key:  []byte(key),
+		al:   al,
+		ch:   make(chan queryRecord, queryLogBuffer),
+		done: make(chan struct{}),
+	}
+	go func() {
+		defer close(q.done)
+		for r := range q.ch {
+			line, err := json.Marshal(r)
+			if err == nil {
+				_, err = q.al.Write(append(line, '\n'))
+			}
+			if err != nil {
+				log.Printf("Query log: %v", err)
+			}
+		}
+	}()
+	return q, nil
+}
+
+// record samples the lookup of rawURL by the endpoint for the named tenant,
+// which found threats according to source.
+func (q *queryLog) record(tenant, endpoint, rawURL string, threats []webrisk.URLThreat, source string) {
+	if q == nil || rand.Float64() >= q.rate {
+		return
+	}
+	r := queryRecord{Time: time.Now(), Tenant: tenant, Endpoint: endpoint, URL: q.url(rawURL), Source: source}
+	seen := make(map[webrisk.ThreatType]bool)
+	for _, t := range threats {
+		if !seen[t.ThreatType] {
+			seen[t.ThreatType] = true
+			r.ThreatTypes = append(r.ThreatTypes, t.ThreatType)
+		}
+	}
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return
+	}
+	select {
+	case q.ch <- r:
+	default:
+		if n := atomic.AddInt64(&q.dropped, 1); n == 1 || n%1000 == 0 {
+			log.Printf("Query log is falling behind: %d queries dropped", n)
+		}
+	}
+}
+
+// url returns what is kept of rawURL. URLs that cannot be parsed are only
+// kept as is in the "full" and "hash" modes.
+func (q *queryLog) url(rawURL string) string {
+	switch q.urls {
+	case queryLogFull:
+		return rawURL
+	case queryLogHash:
+		if len(q.key) == 0 {
+			sum := sha256.Sum256([]byte(rawURL))
+			return hex.EncodeToString(sum[:])
+		}
+		mac := hmac.New(sha256.New, q.key)
+		mac.Write([]byte(rawURL))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	if q.urls == queryLogHost {
+		return u.Scheme + "://" + u.Host + "/"
+	}
+	u.User, u.RawQuery, u.ForceQuery, u.Fragment, u.RawFragment = nil, "", false, "", ""
+	return u.String()
+}
+
+// close writes the queued records and closes the file.
+func (q *queryLog) close() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+	q.mu.Unlock()
+	<-q.done
+	q.al.Close()
+}
@@ -0,0 +1,223 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/idna"
+
+	"github.com/google/webrisk"
+)
+
+const (
+	rpzPath = "/rpz"
+
+	// rpzMaxEntries is the number of hosts that a zone lists at most. Hosts
+	// that are found once it is full are left out until others expire.
+	rpzMaxEntries = 100000
+
+	// rpzTTL is the TTL of the records of the zone, and the refresh period
+	// of its SOA record, in seconds.
+	rpzTTL = 300
+)
+
+var (
+	// rpzOrigin is the name of the DNS response policy zones that are served
+	// at rpzPath, or empty if they are not.
+	rpzOrigin string
+
+	// rpzExpiry is how long a host stays in the zone after it was last found.
+	rpzExpiry = 24 * time.Hour
+)
+
+// rpzEntry is a host listed in a response policy zone.
+type rpzEntry struct {
+	reason   string // Threat types or heuristic that the host was found by
+	wildcard bool   // Whether its subdomains are listed as well
+	expires  time.Time
+}
+
+// rpzZone is a DNS response policy zone (RPZ) of the hosts that wrserver
+// found to be unsafe as a whole, so that resolvers such as BIND and Unbound
+// can block them for clients that do not go through wrserver. It lists the
+// hosts of the Web Risk matches for an expression without a path, which
+// cover every URL of the host and of its subdomains, and the hosts that the
+// redirector heuristics flagged. Hosts are dropped once they have not been
+// found for rpzExpiry.
+type rpzZone struct {
+	origin string
+	expiry time.Duration
+
+	mu     sync.Mutex
+	hosts  map[string]rpzEntry
+	serial uint32 // Increases with every change of hosts
+}
+
+func newRPZZone(origin string, expiry time.Duration) *rpzZone {
+	return &rpzZone{
+		origin: strings.TrimSuffix(origin, "."),
+		expiry: expiry,
+		hosts:  make(map[string]rpzEntry),
+		serial: uint32(time.Now().Unix()),
+	}
+}
+
+// addThreats lists the hosts of the threats whose expression has no path.
+func (z *rpzZone) addThreats(threats []webrisk.URLThreat) {
+	if z == nil {
+		return
+	}
+	hosts := make(map[string][]string)
+	var order []string
+	for _, t := range threats {
+		host, path, ok := strings.Cut(t.Pattern, "/")
+		if !ok || path != "" {
+			continue
+		}
+		if _, ok := hosts[host]; !ok {
+			order = append(order, host)
+		}
+		hosts[host] = append(hosts[host], t.ThreatType.String())
+	}
+	for _, host := range order {
+		z.add(host, strings.Join(hosts[host], ","), true)
+	}
+}
+
+// addHeuristic lists the host that a heuristic flagged for reason.
+func (z *rpzZone) addHeuristic(host, reason string) {
+	if z == nil {
+		return
+	}
+	z.add(host, reason, false)
+}
+
+// add lists host, and its subdomains if wildcard is set, for reason. Hosts
+// that are IP addresses or not valid DNS names are ignored.
+func (z *rpzZone) add(host, reason string, wildcard bool) {
+	name, ok := rpzName(host)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	e, listed := z.hosts[name]
+	if !listed {
+		z.prune(now)
+		if len(z.hosts) >= rpzMaxEntries {
+			return
+		}
+	}
+	changed := !listed || e.reason != reason || !e.wildcard && wildcard
+	e.reason, e.wildcard, e.expires = reason, e.wildcard || wildcard, now.Add(z.expiry)
+	z.hosts[name] = e
+	if changed {
+		z.bump(now)
+	}
+}
+
+// prune drops the hosts that expired by now. The lock must be held.
+func (z *rpzZone) prune(now time.Time) {
+	pruned := false
+	for name, e := range z.hosts {
+		if !now.Before(e.expires) {
+			delete(z.hosts, name)
+			pruned = true
+		}
+	}
+	if pruned {
+		z.bump(now)
+	}
+}
+
+// bump increases the serial number of the zone, which is the time of the
+// last change in seconds, unless it changed more than once a second. The lock
+// must be held.
+func (z *rpzZone) bump(now time.Time) {
+	serial := uint32(now.Unix())
+	if serial <= z.serial {
+		serial = z.serial + 1
+	}
+	z.serial = serial
+}
+
+// rpzName returns host as the lowercase ASCII name that it is listed by, or
+// false if host is an IP address or not a valid DNS name.
+func rpzName(host string) (string, bool) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" || net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return "", false
+	}
+	name, err := idna.Lookup.ToASCII(host)
+	if err != nil || name == "" {
+		return "", false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return "", false
+		}
+		for _, c := range label {
+			if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+				return "", false
+			}
+		}
+	}
+	return name, true
+}
+
+// write writes the zone to w in the master file format of RFC 1035. Every
+// host is listed with a CNAME record to the root, which is the NXDOMAIN
+// action of RPZ, and a comment with the reason it is listed.
+func (z *rpzZone) write(w io.Writer) error {
+	z.mu.Lock()
+	z.prune(time.Now())
+	names := make([]string, 0, len(z.hosts))
+	for name := range z.hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	fmt.Fprintf(&b, "$ORIGIN %s.\n$TTL %d\n", z.origin, rpzTTL)
+	fmt.Fprintf(&b, "@ SOA localhost. hostmaster.localhost. %d %d %d %d %d\n", z.serial, rpzTTL, rpzTTL, 7*24*3600, rpzTTL)
+	b.WriteString("@ NS localhost.\n")
+	for _, name := range names {
+		e := z.hosts[name]
+		fmt.Fprintf(&b, "%s CNAME . ; %s\n", name, e.reason)
+		if e.wildcard {
+			fmt.Fprintf(&b, "*.%s CNAME . ; %s\n", name, e.reason)
+		}
+	}
+	z.mu.Unlock()
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// serve sends the zone, so that resolvers can download it over HTTP.
+func (z *rpzZone) serve(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	resp.Header().Set("Content-Type", "text/dns")
+	z.write(resp)
+}
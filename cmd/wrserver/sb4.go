@@ -0,0 +1,440 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/webrisk"
+)
+
+// Endpoints of the Safe Browsing v4 API that wrserver serves, so that
+// clients of that API can use it as their backend. Only the JSON form of
+// the messages is supported.
+const (
+	sb4FullHashesPath  = "/v4/fullHashes:find"
+	sb4ListUpdatesPath = "/v4/threatListUpdates:fetch"
+	sb4ThreatListsPath = "/v4/threatLists"
+)
+
+// sb4Versions is the number of versions of each threat list, including the
+// current one, that Safe Browsing v4 clients can get partial updates from.
+// Clients at older versions get a full update.
+const sb4Versions = 3
+
+// sb4CacheDuration is how long Safe Browsing v4 clients are told to cache
+// full hashes, and their absence, for. wrserver caches the results of the
+// Web Risk API as long as it tells, so clients need not cache them long.
+const sb4CacheDuration = "300s"
+
+// sb4AnyPlatform is the platform type of the matches when the client asks
+// for none, since the Web Risk threat lists apply to every platform.
+const sb4AnyPlatform = "ANY_PLATFORM"
+
+// sb4ThreatEntry is a ThreatEntry of the Safe Browsing v4 API. Hashes are
+// encoded in standard base64.
+type sb4ThreatEntry struct {
+	Hash []byte `json:"hash,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+// sb4ThreatInfo is a ThreatInfo of the Safe Browsing v4 API.
+type sb4ThreatInfo struct {
+	ThreatTypes      []string         `json:"threatTypes,omitempty"`
+	PlatformTypes    []string         `json:"platformTypes,omitempty"`
+	ThreatEntryTypes []string         `json:"threatEntryTypes,omitempty"`
+	ThreatEntries    []sb4ThreatEntry `json:"threatEntries,omitempty"`
+}
+
+// threatTypes returns the threat types that ti asks for, or nil for all of
+// them if it asks for none. The types that Web Risk does not have, such as
+// POTENTIALLY_HARMFUL_APPLICATION, are ignored.
+func (ti sb4ThreatInfo) threatTypes() map[webrisk.ThreatType]bool {
+	if len(ti.ThreatTypes) == 0 {
+		return nil
+	}
+	tts := make(map[webrisk.ThreatType]bool)
+	for _, name := range ti.ThreatTypes {
+		if tt, err := webrisk.ParseThreatType(name); err == nil {
+			tts[tt] = true
+		}
+	}
+	return tts
+}
+
+// platformTypes returns the platform types to report matches for.
+func (ti sb4ThreatInfo) platformTypes() []string {
+	if len(ti.PlatformTypes) == 0 {
+		return []string{sb4AnyPlatform}
+	}
+	return ti.PlatformTypes
+}
+
+// sb4ThreatMatch is a ThreatMatch of the Safe Browsing v4 API.
+type sb4ThreatMatch struct {
+	ThreatType      string         `json:"threatType"`
+	PlatformType    string         `json:"platformType"`
+	ThreatEntryType string         `json:"threatEntryType"`
+	Threat          sb4ThreatEntry `json:"threat"`
+	CacheDuration   string         `json:"cacheDuration"`
+}
+
+// sb4FindFullHashesRequest is a FindFullHashesRequest of the Safe Browsing v4
+// API. The client and its states are ignored.
+type sb4FindFullHashesRequest struct {
+	ThreatInfo sb4ThreatInfo `json:"threatInfo"`
+}
+
+// sb4FindFullHashesResponse is a FindFullHashesResponse of the Safe Browsing
+// v4 API.
+type sb4FindFullHashesResponse struct {
+	Matches               []sb4ThreatMatch `json:"matches,omitempty"`
+	NegativeCacheDuration string           `json:"negativeCacheDuration"`
+}
+
+// newSB4FullHashesResponse returns the response to a request for the full
+// hashes of the hash prefixes of ti, given the threats that they were looked
+// up to have. Hash prefixes only match the full hashes of the threat lists
+// that ti asks for, once per platform type.
+func newSB4FullHashesResponse(ti sb4ThreatInfo, threats [][]webrisk.URLThreat) sb4FindFullHashesResponse {
+	resp := sb4FindFullHashesResponse{NegativeCacheDuration: sb4CacheDuration}
+	tts := ti.threatTypes()
+	seen := make(map[webrisk.URLThreat]bool)
+	for _, uts := range threats {
+		for _, ut := range uts {
+			if (tts != nil && !tts[ut.ThreatType]) || seen[ut] {
+				continue
+			}
+			seen[ut] = true
+			// Read-only clients report the matches of the database as
+			// is, which are not full hashes.
+			hash, err := hex.DecodeString(ut.Pattern)
+			if err != nil || len(hash) != webrisk.FullHashLength {
+				continue
+			}
+			for _, platform := range ti.platformTypes() {
+				resp.Matches = append(resp.Matches, sb4ThreatMatch{
+					ThreatType:      ut.ThreatType.String(),
+					PlatformType:    platform,
+					ThreatEntryType: "URL",
+					Threat:          sb4ThreatEntry{Hash: hash},
+					CacheDuration:   sb4CacheDuration,
+				})
+			}
+		}
+	}
+	return resp
+}
+
+// decodeSB4 decodes the JSON request of a Safe Browsing v4 endpoint into v.
+func decodeSB4(req *http.Request, v interface{}) error {
+	if req.Method != "POST" {
+		return errors.New("invalid method")
+	}
+	if alt := req.URL.Query().Get("alt"); alt != "" && alt != "json" {
+		return errors.New("only the JSON interchange format is supported")
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, mimeJSON) {
+		return errors.New("only the JSON interchange format is supported")
+	}
+	return json.NewDecoder(req.Body).Decode(v)
+}
+
+// writeSB4 writes v as the JSON response of a Safe Browsing v4 endpoint.
+func writeSB4(resp http.ResponseWriter, v interface{}) {
+	resp.Header().Set("Content-Type", mimeJSON)
+	if err := json.NewEncoder(resp).Encode(v); err != nil {
+		problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
+	}
+}
+
+// serveSB4FullHashes serves the fullHashes:find endpoint of the Safe
+// Browsing v4 API, which returns the full hashes of the threats that start
+// with the hash prefixes that clients found in their threat lists. They are
+// looked up like other hashes, in the database, the cache, and the Web Risk
+// API.
+func serveSB4FullHashes(resp http.ResponseWriter, req *http.Request, wr *webrisk.UpdateClient, lat *latencies) {
+	timer, ctx := lat.start("fullHashes", req)
+	defer timer.done()
+	var sbReq sb4FindFullHashesRequest
+	if err := decodeSB4(req, &sbReq); err != nil {
+		problemError(resp, err.Error(), http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	var hashes [][]byte
+	for _, e := range sbReq.ThreatInfo.ThreatEntries {
+		if len(e.Hash) < webrisk.MinHashPrefixLength || len(e.Hash) > webrisk.FullHashLength {
+			problemError(resp, fmt.Sprintf("invalid hash prefix length: %d", len(e.Hash)), http.StatusBadRequest, codeInvalidFormat)
+			return
+		}
+		hashes = append(hashes, e.Hash)
+	}
+
+	threats, sources, err := wr.LookupHashes(ctx, hashes)
+	if err != nil {
+		timer.source = "error"
+		backendError(resp, err)
+		return
+	}
+	source := webrisk.SourceDatabase
+	for i, s := range sources {
+		if s > source {
+			source = s
+		}
+		timer.threats = append(timer.threats, threats[i]...)
+	}
+	timer.source = source.String()
+	writeSB4(resp, newSB4FullHashesResponse(sbReq.ThreatInfo, threats))
+}
+
+// sb4ListDescriptor is a ThreatListDescriptor of the Safe Browsing v4 API.
+type sb4ListDescriptor struct {
+	ThreatType      string `json:"threatType"`
+	PlatformType    string `json:"platformType"`
+	ThreatEntryType string `json:"threatEntryType"`
+}
+
+// sb4ListThreatListsResponse is a ListThreatListsResponse of the Safe
+// Browsing v4 API.
+type sb4ListThreatListsResponse struct {
+	ThreatLists []sb4ListDescriptor `json:"threatLists"`
+}
+
+// sb4ListUpdateRequest is a ListUpdateRequest of the Safe Browsing v4 API.
+// The constraints are ignored: updates are raw and hold every change.
+type sb4ListUpdateRequest struct {
+	sb4ListDescriptor
+	State []byte `json:"state,omitempty"`
+}
+
+// sb4FetchRequest is a FetchThreatListUpdatesRequest of the Safe Browsing v4
+// API. The client is ignored.
+type sb4FetchRequest struct {
+	ListUpdateRequests []sb4ListUpdateRequest `json:"listUpdateRequests"`
+}
+
+// sb4RawHashes is a RawHashes of the Safe Browsing v4 API.
+type sb4RawHashes struct {
+	PrefixSize int    `json:"prefixSize"`
+	RawHashes  []byte `json:"rawHashes"`
+}
+
+// sb4RawIndices is a RawIndices of the Safe Browsing v4 API.
+type sb4RawIndices struct {
+	Indices []int32 `json:"indices"`
+}
+
+// sb4ThreatEntrySet is a ThreatEntrySet of the Safe Browsing v4 API.
+type sb4ThreatEntrySet struct {
+	CompressionType string         `json:"compressionType"`
+	RawHashes       *sb4RawHashes  `json:"rawHashes,omitempty"`
+	RawIndices      *sb4RawIndices `json:"rawIndices,omitempty"`
+}
+
+// sb4Checksum is a Checksum of the Safe Browsing v4 API.
+type sb4Checksum struct {
+	SHA256 []byte `json:"sha256"`
+}
+
+// sb4ListUpdateResponse is a ListUpdateResponse of the Safe Browsing v4 API.
+type sb4ListUpdateResponse struct {
+	sb4ListDescriptor
+	ResponseType   string              `json:"responseType"`
+	Additions      []sb4ThreatEntrySet `json:"additions,omitempty"`
+	Removals       []sb4ThreatEntrySet `json:"removals,omitempty"`
+	NewClientState []byte              `json:"newClientState"`
+	Checksum       sb4Checksum         `json:"checksum"`
+}
+
+// sb4FetchResponse is a FetchThreatListUpdatesResponse of the Safe Browsing
+// v4 API.
+type sb4FetchResponse struct {
+	ListUpdateResponses []sb4ListUpdateResponse `json:"listUpdateResponses"`
+}
+
+// sb4Lists serves the threat lists of the database of a client to Safe
+// Browsing v4 clients. The state of a client is the SHA-256 checksum of its
+// list, and sb4Lists keeps the last sb4Versions versions of every list, to
+// send clients at one of them only what changed since.
+type sb4Lists struct {
+	wr *webrisk.UpdateClient
+
+	mu       sync.Mutex
+	versions map[webrisk.ThreatType][]*webrisk.ListSnapshot // Oldest first
+}
+
+func newSB4Lists(wr *webrisk.UpdateClient) *sb4Lists {
+	return &sb4Lists{wr: wr, versions: make(map[webrisk.ThreatType][]*webrisk.ListSnapshot)}
+}
+
+// snapshot returns the current threat lists, and records their versions.
+func (l *sb4Lists) snapshot() (*webrisk.DatabaseSnapshot, error) {
+	snap, err := l.wr.SnapshotDatabase()
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for tt, ls := range snap.Lists {
+		vs := l.versions[tt]
+		if len(vs) > 0 && bytes.Equal(vs[len(vs)-1].SHA256, ls.SHA256) {
+			continue
+		}
+		if vs = append(vs, ls); len(vs) > sb4Versions {
+			vs = vs[len(vs)-sb4Versions:]
+		}
+		l.versions[tt] = vs
+	}
+	return snap, nil
+}
+
+// version returns the version of the list of tt whose checksum is state,
+// or nil if it is not kept.
+func (l *sb4Lists) version(tt webrisk.ThreatType, state []byte) *webrisk.ListSnapshot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, ls := range l.versions[tt] {
+		if bytes.Equal(ls.SHA256, state) {
+			return ls
+		}
+	}
+	return nil
+}
+
+// update returns the update of a client at state to the list cur of tt: a
+// partial update if state is a version that is kept, or a full one.
+func (l *sb4Lists) update(req sb4ListUpdateRequest, tt webrisk.ThreatType, cur *webrisk.ListSnapshot) sb4ListUpdateResponse {
+	resp := sb4ListUpdateResponse{
+		sb4ListDescriptor: req.sb4ListDescriptor,
+		ResponseType:      "FULL_UPDATE",
+		NewClientState:    cur.SHA256,
+		Checksum:          sb4Checksum{SHA256: cur.SHA256},
+	}
+	if resp.PlatformType == "" {
+		resp.PlatformType = sb4AnyPlatform
+	}
+	if resp.ThreatEntryType == "" {
+		resp.ThreatEntryType = "URL"
+	}
+	additions := cur.Prefixes
+	if old := l.version(tt, req.State); len(req.State) > 0 && old != nil {
+		var removals []int32
+		removals, additions = sb4Diff(old.Prefixes, cur.Prefixes)
+		resp.ResponseType = "PARTIAL_UPDATE"
+		if len(removals) > 0 {
+			resp.Removals = []sb4ThreatEntrySet{{CompressionType: "RAW", RawIndices: &sb4RawIndices{Indices: removals}}}
+		}
+	}
+	resp.Additions = sb4RawAdditions(additions)
+	return resp
+}
+
+// sb4Diff returns the indices of the hash prefixes of old that are not in
+// cur, and the prefixes of cur that are not in old. Both must be sorted.
+func sb4Diff(old, cur []string) (removals []int32, additions []string) {
+	i, j := 0, 0
+	for i < len(old) || j < len(cur) {
+		switch {
+		case j == len(cur) || (i < len(old) && old[i] < cur[j]):
+			removals = append(removals, int32(i))
+			i++
+		case i == len(old) || cur[j] < old[i]:
+			additions = append(additions, cur[j])
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	return removals, additions
+}
+
+// sb4RawAdditions returns the hash prefixes as raw hashes, one set per
+// prefix size.
+func sb4RawAdditions(prefixes []string) []sb4ThreatEntrySet {
+	bySize := make(map[int][]byte)
+	for _, p := range prefixes {
+		bySize[len(p)] = append(bySize[len(p)], p...)
+	}
+	sizes := make([]int, 0, len(bySize))
+	for size := range bySize {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+	var sets []sb4ThreatEntrySet
+	for _, size := range sizes {
+		sets = append(sets, sb4ThreatEntrySet{
+			CompressionType: "RAW",
+			RawHashes:       &sb4RawHashes{PrefixSize: size, RawHashes: bySize[size]},
+		})
+	}
+	return sets
+}
+
+// serveListUpdates serves the threatListUpdates:fetch endpoint of the Safe
+// Browsing v4 API, which sends clients the changes to their threat lists
+// since their state, from the local database.
+func (l *sb4Lists) serveListUpdates(resp http.ResponseWriter, req *http.Request) {
+	var sbReq sb4FetchRequest
+	if err := decodeSB4(req, &sbReq); err != nil {
+		problemError(resp, err.Error(), http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	snap, err := l.snapshot()
+	if err != nil {
+		backendError(resp, err)
+		return
+	}
+	sbResp := sb4FetchResponse{ListUpdateResponses: []sb4ListUpdateResponse{}}
+	for _, r := range sbReq.ListUpdateRequests {
+		tt, err := webrisk.ParseThreatType(r.ThreatType)
+		if err != nil || snap.Lists[tt] == nil {
+			problemError(resp, fmt.Sprintf("unknown threat list: %s", r.ThreatType), http.StatusBadRequest, codeInvalidFormat)
+			return
+		}
+		sbResp.ListUpdateResponses = append(sbResp.ListUpdateResponses, l.update(r, tt, snap.Lists[tt]))
+	}
+	writeSB4(resp, sbResp)
+}
+
+// serveThreatLists serves the threatLists endpoint of the Safe Browsing v4
+// API, which lists the threat lists of the database.
+func (l *sb4Lists) serveThreatLists(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	snap, err := l.wr.SnapshotDatabase()
+	if err != nil {
+		backendError(resp, err)
+		return
+	}
+	sbResp := sb4ListThreatListsResponse{ThreatLists: []sb4ListDescriptor{}}
+	for _, tt := range snap.ThreatTypes() {
+		sbResp.ThreatLists = append(sbResp.ThreatLists, sb4ListDescriptor{
+			ThreatType:      tt.String(),
+			PlatformType:    sb4AnyPlatform,
+			ThreatEntryType: "URL",
+		})
+	}
+	writeSB4(resp, sbResp)
+}
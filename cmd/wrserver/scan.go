@@ -0,0 +1,419 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+const (
+	scanPath = "/v1/scan"
+
+	// scanMaxURLs is the number of URLs that a scan job holds at most.
+	scanMaxURLs = 100000
+
+	// scanMaxBodySize is the maximum size of a file of URLs.
+	scanMaxBodySize = 32 << 20
+
+	// scanMaxJobs is the number of jobs, running or finished, that are kept
+	// at most, after which new ones are turned away.
+	scanMaxJobs = 100
+
+	// scanWorkers is the number of URLs that a job looks up at once.
+	scanWorkers = 8
+
+	// scanRetention is how long the results of finished jobs are kept.
+	scanRetention = 24 * time.Hour
+
+	mimeCSV = "text/csv"
+)
+
+// Statuses of scan jobs.
+const (
+	scanRunning  = "running"
+	scanDone     = "done"
+	scanCanceled = "canceled"
+)
+
+// scanResult is the verdict of a URL of a scan job.
+type scanResult struct {
+	threatTypes []webrisk.ThreatType
+	source      string
+	err         string
+}
+
+// scanJob looks up a list of URLs in the background.
+type scanJob struct {
+	id      string
+	urls    []string
+	created time.Time
+	cancel  context.CancelFunc
+
+	mu       sync.Mutex
+	status   string
+	results  []scanResult // Per URL, valid up to done
+	done     int
+	unsafe   int
+	errors   int
+	finished time.Time
+}
+
+// scanProgress is the JSON form of the progress of a scan job.
+type scanProgress struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"`
+	Total    int        `json:"total"`
+	Done     int        `json:"done"`
+	Unsafe   int        `json:"unsafe"`
+	Errors   int        `json:"errors"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Results  string     `json:"results"` // Path of the report
+}
+
+// scanJobs runs the scan jobs of a client, so that security teams can check
+// exported lists of links without writing client code. Jobs are kept in
+// memory, for scanRetention after they finish, and canceled when the server
+// shuts down.
+type scanJobs struct {
+	prefix string // Path prefix of the client, for the report links
+	wr     *webrisk.UpdateClient
+	events *eventHub
+
+	mu   sync.Mutex
+	jobs map[string]*scanJob
+}
+
+func newScanJobs(prefix string, wr *webrisk.UpdateClient, events *eventHub) *scanJobs {
+	return &scanJobs{prefix: prefix, wr: wr, events: events, jobs: make(map[string]*scanJob)}
+}
+
+// serve starts jobs with POST requests to scanPath, and serves their progress
+// at scanPath/<id> and their report at scanPath/<id>/results. DELETE requests
+// to scanPath/<id> cancel a job and drop its results.
+func (s *scanJobs) serve(resp http.ResponseWriter, req *http.Request) {
+	s.prune()
+	rest := strings.Trim(strings.TrimPrefix(req.URL.Path, scanPath), "/")
+	if rest == "" {
+		if req.Method != "POST" {
+			problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+			return
+		}
+		s.start(resp, req)
+		return
+	}
+	id, report := rest, false
+	if strings.HasSuffix(rest, "/results") {
+		id, report = strings.TrimSuffix(rest, "/results"), true
+	}
+	s.mu.Lock()
+	job := s.jobs[id]
+	s.mu.Unlock()
+	if job == nil {
+		problemError(resp, "not found", http.StatusNotFound, codeNotFound)
+		return
+	}
+	switch {
+	case report && req.Method == "GET":
+		job.writeReport(resp)
+	case !report && req.Method == "GET":
+		writeScanProgress(resp, http.StatusOK, s.progress(job))
+	case !report && req.Method == "DELETE":
+		job.cancel()
+		s.mu.Lock()
+		delete(s.jobs, id)
+		s.mu.Unlock()
+		resp.WriteHeader(http.StatusNoContent)
+	default:
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+	}
+}
+
+// start starts a job for the URLs of the request, and answers with its
+// progress.
+func (s *scanJobs) start(resp http.ResponseWriter, req *http.Request) {
+	urls, err := readScanURLs(resp, req)
+	if err != nil {
+		problemError(resp, err.Error(), http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	if len(urls) == 0 {
+		problemError(resp, "no URLs to scan", http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	if len(urls) > scanMaxURLs {
+		problemError(resp, fmt.Sprintf("too many URLs: %d, at most %d", len(urls), scanMaxURLs), http.StatusRequestEntityTooLarge, codeTooLarge)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &scanJob{
+		id:      uuid4(),
+		urls:    urls,
+		created: time.Now().UTC(),
+		cancel:  cancel,
+		status:  scanRunning,
+		results: make([]scanResult, len(urls)),
+	}
+	s.mu.Lock()
+	if len(s.jobs) >= scanMaxJobs {
+		s.mu.Unlock()
+		cancel()
+		resp.Header().Set("Retry-After", "60")
+		problemError(resp, "too many scan jobs", http.StatusServiceUnavailable, codeOverloaded)
+		return
+	}
+	s.jobs[job.id] = job
+	s.mu.Unlock()
+	go func() {
+		select {
+		case <-s.events.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	go s.run(ctx, job, clientAddr(req))
+	resp.Header().Set("Location", s.prefix+scanPath+"/"+job.id)
+	writeScanProgress(resp, http.StatusAccepted, s.progress(job))
+}
+
+// run looks up the URLs of job for the client at addr.
+func (s *scanJobs) run(ctx context.Context, job *scanJob, addr string) {
+	defer job.cancel()
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < scanWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				job.record(i, s.lookup(ctx, job.urls[i], addr))
+			}
+		}()
+	}
+feed:
+	for i := range job.urls {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.status = scanDone
+	if ctx.Err() != nil {
+		job.status = scanCanceled
+	}
+	job.finished = time.Now().UTC()
+}
+
+// lookup looks up rawURL for the client at addr.
+func (s *scanJobs) lookup(ctx context.Context, rawURL, addr string) scanResult {
+	threats, sources, err := s.wr.LookupURLsSources(ctx, []string{rawURL})
+	if err != nil {
+		return scanResult{err: err.Error()}
+	}
+	r := scanResult{source: sources[0].String()}
+	if d, ok := newDetection("scan", addr, rawURL, threats[0], sources[0]); ok {
+		s.events.publish(d)
+		r.threatTypes = d.ThreatTypes
+	}
+	s.events.zone.addThreats(threats[0])
+	return r
+}
+
+// record records the result of the i-th URL.
+func (job *scanJob) record(i int, r scanResult) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.results[i] = r
+	job.done++
+	if r.err != "" {
+		job.errors++
+	} else if len(r.threatTypes) > 0 {
+		job.unsafe++
+	}
+}
+
+// progress returns the progress of job.
+func (s *scanJobs) progress(job *scanJob) scanProgress {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	p := scanProgress{
+		ID:      job.id,
+		Status:  job.status,
+		Total:   len(job.urls),
+		Done:    job.done,
+		Unsafe:  job.unsafe,
+		Errors:  job.errors,
+		Created: job.created,
+		Results: s.prefix + scanPath + "/" + job.id + "/results",
+	}
+	if !job.finished.IsZero() {
+		finished := job.finished
+		p.Finished = &finished
+	}
+	return p
+}
+
+// writeReport writes the results of job as CSV, with the url, threatTypes,
+// source and error columns, in the order of the URLs. The URLs that are not
+// looked up yet have no source and no error.
+func (job *scanJob) writeReport(resp http.ResponseWriter) {
+	job.mu.Lock()
+	results := append([]scanResult(nil), job.results...)
+	job.mu.Unlock()
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"url", "threatTypes", "source", "error"})
+	for i, r := range results {
+		w.Write([]string{job.urls[i], joinThreatTypes(r.threatTypes), r.source, r.err})
+	}
+	w.Flush()
+	resp.Header().Set("Content-Type", mimeCSV)
+	resp.Header().Set("Content-Disposition", `attachment; filename="scan-`+job.id+`.csv"`)
+	resp.Write(buf.Bytes())
+}
+
+// prune drops the jobs that finished more than scanRetention ago.
+func (s *scanJobs) prune() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, job := range s.jobs {
+		job.mu.Lock()
+		expired := !job.finished.IsZero() && time.Since(job.finished) > scanRetention
+		job.mu.Unlock()
+		if expired {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+func writeScanProgress(resp http.ResponseWriter, code int, p scanProgress) {
+	buf, err := json.Marshal(p)
+	if err != nil {
+		problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
+		return
+	}
+	resp.Header().Set("Content-Type", mimeJSON)
+	resp.WriteHeader(code)
+	resp.Write(buf)
+}
+
+// readScanURLs reads the URLs to scan from the request: from its "file"
+// part if it is a multipart form, from the gs:// or s3:// object given by
+// the source field if it is a JSON object, or from its body otherwise.
+func readScanURLs(resp http.ResponseWriter, req *http.Request) ([]string, error) {
+	body := http.MaxBytesReader(resp, req.Body, scanMaxBodySize)
+	name := ""
+	mt, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch mt {
+	case "multipart/form-data":
+		req.Body = body
+		f, h, err := req.FormFile("file")
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		name, mt = h.Filename, h.Header.Get("Content-Type")
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return nil, err
+		}
+		return parseScanURLs(data, isCSV(name, mt), req.URL.Query().Get("column"))
+	case mimeJSON:
+		var src struct {
+			Source string `json:"source"`
+		}
+		if err := json.NewDecoder(body).Decode(&src); err != nil {
+			return nil, err
+		}
+		store, err := webrisk.OpenStore(src.Source)
+		if err != nil {
+			return nil, err
+		}
+		data, err := store.Load(req.Context())
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %v", src.Source, err)
+		}
+		if len(data) > scanMaxBodySize {
+			return nil, errors.New("file of URLs too large")
+		}
+		return parseScanURLs(data, isCSV(src.Source, ""), req.URL.Query().Get("column"))
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return parseScanURLs(data, mt == mimeCSV, req.URL.Query().Get("column"))
+}
+
+// isCSV returns whether a file with the given name or media type is CSV.
+func isCSV(name, mt string) bool {
+	mt, _, _ = mime.ParseMediaType(mt)
+	return mt == mimeCSV || strings.EqualFold(path.Ext(name), ".csv")
+}
+
+// parseScanURLs parses a list of URLs, one per line like readURLList, or the
+// URLs of the given column, 0 by default, of CSV data. A first row whose
+// value has no dot, such as "url", is taken as the header of the CSV data.
+func parseScanURLs(data []byte, isCSV bool, column string) ([]string, error) {
+	if !isCSV {
+		return readURLList(bytes.NewReader(data))
+	}
+	col := 0
+	if column != "" {
+		var err error
+		if col, err = strconv.Atoi(column); err != nil || col < 0 {
+			return nil, fmt.Errorf("invalid column: %q", column)
+		}
+	}
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	var urls []string
+	for row := 0; ; row++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return urls, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if col >= len(rec) {
+			continue
+		}
+		v := strings.TrimSpace(rec[col])
+		if v == "" || (row == 0 && !strings.Contains(v, ".")) {
+			continue
+		}
+		urls = append(urls, v)
+	}
+}
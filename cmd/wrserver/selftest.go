@@ -0,0 +1,120 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+// selfTestTimeout bounds how long -selftest waits for the initial sync of
+// the databases and for its lookups.
+const selfTestTimeout = 5 * time.Minute
+
+// selfTestURLs are the test URLs of the Web Risk API, which are always on
+// the threat list of their type.
+var selfTestURLs = map[webrisk.ThreatType]string{
+	webrisk.ThreatTypeMalware:                   "http://testsafebrowsing.appspot.com/s/malware.html",
+	webrisk.ThreatTypeSocialEngineering:         "http://testsafebrowsing.appspot.com/s/phishing.html",
+	webrisk.ThreatTypeUnwantedSoftware:          "http://testsafebrowsing.appspot.com/s/unwanted.html",
+	webrisk.ThreatTypeSocialEngineeringExtended: "http://testsafebrowsing.appspot.com/s/social_engineering_extended_coverage.html",
+}
+
+// selfTest checks that the test URL of every threat list that wr subscribes
+// to is found unsafe, writing a line per URL to w. It returns an error if
+// any of them is not.
+func selfTest(ctx context.Context, wr *webrisk.UpdateClient, urls map[webrisk.ThreatType]string, w io.Writer) error {
+	if err := wr.WaitUntilReady(ctx); err != nil {
+		return err
+	}
+	stats, _ := wr.Status()
+	var tts []webrisk.ThreatType
+	for tt := range stats.Lists {
+		if _, ok := urls[tt]; ok {
+			tts = append(tts, tt)
+		}
+	}
+	if len(tts) == 0 {
+		return fmt.Errorf("no test URLs for the threat lists")
+	}
+	sort.Slice(tts, func(i, j int) bool { return tts[i] < tts[j] })
+	lookups := make([]string, len(tts))
+	for i, tt := range tts {
+		lookups[i] = urls[tt]
+	}
+	threats, sources, err := wr.LookupURLsSources(ctx, lookups)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for i, u := range lookups {
+		var found []string
+		seen := make(map[webrisk.ThreatType]bool)
+		for _, t := range threats[i] {
+			if !seen[t.ThreatType] {
+				seen[t.ThreatType] = true
+				found = append(found, t.ThreatType.String())
+			}
+		}
+		if len(found) == 0 {
+			failed++
+			fmt.Fprintf(w, "FAIL  %s  %s  not found unsafe (source %s)\n", tts[i], u, sources[i])
+			continue
+		}
+		fmt.Fprintf(w, "ok    %s  %s  found %s (source %s)\n", tts[i], u, strings.Join(found, ","), sources[i])
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d test URLs not found unsafe", failed, len(lookups))
+	}
+	return nil
+}
+
+// runSelfTest runs selfTest with every client, printing the results to
+// STDOUT, and closes them. It returns the exit code of -selftest.
+func runSelfTest(clients map[string]*webrisk.UpdateClient) int {
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	defer cancel()
+	var names []string
+	for name, c := range clients {
+		if c != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	code := 0
+	for _, name := range names {
+		c := clients[name]
+		what := "Self-test"
+		if name != "" {
+			what += " of tenant " + name
+			fmt.Printf("Tenant %s:\n", name)
+		}
+		if err := selfTest(ctx, c, selfTestURLs, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%s failed: %v\n", what, err)
+			code = 1
+		}
+		c.Close()
+	}
+	if code == 0 {
+		fmt.Println("Self-test passed")
+	}
+	return code
+}
@@ -36,6 +36,6 @@ import (
 
 
 func init() {
-	data := "PK\x03\x04\x14\x00\x08\x00\x08\x00\x0b\x8dmN\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x10\x00	\x00interstitial.cssUT\x05\x00\x01\x87@\x89\\\xbcXm\x8f\xdb\xb8\x11\xfe\xee_1@p\xcd&\xb0\xbc\x92\xfc\xb2^-p\xe85wh\x0b\\\x83\xe2\xae\xed\xd7\x82\x96\xc66\x1b\x8a\x14(\xcak\xe7\xb0\xff\xbd\xa0(\xc9\xa4D\xd9JQ\xdc\x97dM\x91\xc3yyf\x9e\x19>~\x84O\xa2\xb8Hz8*\x88\xc3h\x05\xff8\"|:J\x91\xd3*\x87\x1f*u\x14\xb2\\\xc0\x0f\x8cA\xbd\xa9\x04\x89%\xca\x13f\x8b\x19\x00\xfc\xb3D\x10{PGZB)*\x99\"\xa4\"C\xa0%\x1c\xc4	%\xc7\x0cv\x17 \xf0\xa7_\x7f\x0cJua\x08\x8c\xa6\xc8K\x04u$\nR\xc2a\x87Z\xd2^T<\x03\xcaA\x1d\x11~\xfe\xeb\xa7\x9f>\xff\xfa\x13\xec)\xc3\x05||\x9c\xcd\x08\xfc6\x03H\x05\x132\x01y\xd8=\xac\x9e\xe7\xb0^\xcda\xbd\xf9\xf02{\x9b\xcdv\"\xbb\xd4{v$\xfdr\x90ZZ\xd0l\x7f=R\x85/7\x8e\xeb\xdb\xb9\n\xf6$\xa7\xec\x92\xc0\xfb\xbf ;\xa1\xa2)\x81\xcfX\xe1\xfb9\xbc\xff\xb9JiF\xe0\xcf\x92\xf0L/\x94\x84\x97A\x89\x92\xee\xbb\xd3%\xfd\x8a	<\xad\xbf3\xeaTJ	>\xa2\x90<\xec\xc8\xc3\xf2i\x0eO\x9b9<Gs\x88j%vBf(\x13\x08\xaf?\x02I2Z\x95	\xc4\xc5\xd9\xac\x9e\xf5E\x94\x1f\x92v\xc7N\x9c-\xdb\xde\xed\xf7\xb5Ji%K\xfd\xbb\x10\x94+\x94\xb5\x96L\x10\x95\x980\xf6\xb4^l\x9f\xd6\x98\xeb\xc5\x9c\xc8\x03\xe5\x8d\x0e\x05\xc9\xb2\xfa\xaa(,\xce\x10\xaf\x8c\x0eJ\x12^RE\x05O\x8c>G\x92\x89W\x88\xc30/!\xadv4\x0dv\xf8\x95\xa2|\x08\x17\xab9\x84s\x08\x17qkcU\xa2\x0cJd\x98\xaa\x04\xb8\xe0u\\\x82\\|\x0dF\xbe\x94#\x1f^q\xf7\x85*\xdf\xc7\xce\xfb	I\x15=\xe1\xb7\x05AT\x8aQ\x8e\xb5\x07\xae\x92\x8e\x1a\xccFPgq\x02\xa1\x0e\x0b,\x8b\xb3\x11\x16\x1ac\xe7\xb0X\x1aL\xbe\xcbP\x11\xca\xca\x9b\xd8\xbd\xfa|\xb5.\xce\x10\xc2:\xd4~\xb6\x8f\x17	\x17\xea!\xd9SY\xaa@\xec\x03u)\xf0C-\xd4\x1c\x0d\x94(\x12\x88\xfb\xe7\x02/\x08\x13\xa0\xfc\x88\x92\xaa\x01\xe4n\xa4\x87\x81N\xeb\xfc\x1b \xa9\x97\x14\x9eU\x90a*$10\xa9x\x86R\xbb\xb5\xfbZ\x83h/d\x9e@U\x14(SR\xa2O\xf9\x11\xcf[&\xdc\xba\xecm6;F}\xef\xc7\xe1j\x0e\xcb\xcd\x1c\xa2\xf0C/\x0f\xa2\xc5\xc6\xa4A\xbd\xf6\x8a:U\xb4\xd92'L/k\xa9\xc1\xb1Y\x8e\x16\xb1\x934\xc1N(%\xf2\x04\xa2M\x13\x88c\xfcMw\xc77\xee~\x9b\xcd\x16G\x9aeh\xc2\x99\xd1\xb2`\xe4bA\xfe\xa8rV\x7f\xb2E\xc6\xba\x1a5.\xd2K\x01\xc9\xfeS\x95Z\xf70\xfc\xaeM<\xff\xd76\xfbn|mRpl\x87V\x99\xa6\xc3\"Hsr\xc0\x04*\xc9\x1e\x1e\x8bj\xc7h\xfa\xf8J$\xa7\xfc\xf0o%)\xe1\x07\x86\x8b\xf2t0%\xf1zLb\x81\x06\x84\xcd\x9f\xbd\xef\x8d\xc9\x8drm\x90\x9e\x9a\xc2\xd9A\x16BX\x85f\xed\x95f\xea\xd8n\xa9\xb5\xd5\xa5\xb2TTQ\xc2\x82WI40\xaf\xd0\xf3\x96]\xdb\xdb&|=\x8cl\xdc\xba\x1a\x85:KH\xa5\x84I\x95\x9c\x9c\x83F\x8fM\xe8\xea\xd5y\xf1\x1d\x13\x07Q\xeb\xd1\x8a]mm\xab:\xe0-\xcd\xea	\xa5&/\x16\x10F\x0f<\x81\x9cf\x19C[\xf2\xd3\xba-\x15Zt\xb0c\"\xfd\xd2\x87j\xb4Y\xce\xa1\xfd\xa7\x0f\xd6\xc5\xb31\xabI~S\x80\xd6\x8d\xfe5 \x9a\xbbS4\xf4\xa3\xef\xca	\xe5A\x8eeI\x0e\x08\xdfC\xe1\"\x99\xf2.g\x17\x9c\x9c\x1c\xff\xdben\x1d\xb5\xe1\xb2v%	\xd9\xabfs\xca\x90H\x1d'u4U\x8d+\xe4*\x81\xf7\xef_\xec\xfb\x14\xd9\xb9N\xe9@[\xe6\x84\xb1\x80Q>\xf0\x89\xafkp\x19\xf4m6\xfbc\x8e\x19%\xf0`\x85\xf6I\x87\xd6T\xecq\x90Y\xa54\x84\xc8\xc0\xf8mD\xe0*\xee\x04\x9a:9\xd7\xa2{\x8a\x0f\xeavO\xe3\xb6|\xf5J\xce*\x0c\xcd\xaaS\xe6o\xd6m\xfd\xd9q\xa3\xd6|\x06\xe0\x10\xe0U`\\3E\xf3\xdfp\xf38\xdd\xb9H\x88\x1a\xbc9\xa7m\xd2\xb3\xa2]C\xfce \"nD\xf8A;f\xd6\xc4\x18\xae-G\xf4\xf2lP\xc1[\xc9}\xe4\xbb\xea.;\x8b\xdff\xb3\xc7\x8f\x1fg\xf0\x11\xfe&v\x94!\x94\x05\xa6tOS\xd0]6\xe5\x879\xec\x85\xd4\xa9_\x87;\x832\x95\x88\xbc\x84?\x00#\xf2\x80r\xa1\xcf~&'z\xa8i\xb3\x81Q	D\"\x10\x9e\x1e\x85\xc4\x0c\x94\xa8\xdbqS_L\xa3\x8f\x8d\xa4\xfa\xfc\x8fM\xc4\xda\xa4\x96X0\x92bY\xefS\xa2h\x93O\xf7\xf5T\x95 ^\xb9>.\x18\xd3\xc9\xa7\xef\"Z\xce\xe3-\x8c\x13\x9e\x99\xe5\xae\xa4/7\xdd\xba\x90\x14\xb9j\x88\xbf\x10RIB\x95\xc1\x8a\x1f\x10N\xc3sE\xe4\xb6\x06\xe4\x15\x8b\x8b\x12S\xc13\"/\xee\xf1\x96\xf3\x9a\xa0\xa0n\xa7\xc2!\xacL\x07\xd0O_\xca[\xd3\xe2\x95k\xda\xc0\xe2Z\"\x809\xd4U\xfd0\xfa&\x87t\xfe\x98;\xe2\xba\xcb\"\x9f\x0e\xb60\xfb\xf2\xab\xcaWa\x96\x0ev\xb0l\x1d\x18\xe1Y\x99\x926\x81\xeb)\xcd\x03r\xbb=\xed\xc65\x80\x96\xd8\xc2\xf6\xa7\xdd{\x07\xb1\xee\xbe\xb5Z\xf6\x11\x86{5\x08o\xf7\xd35\xf3\xc5\xce\xd8\xc0\x9cl\xa7\x9c\xeb\xbal\x1d\xd0}\x10\xed\xf8\xb3\xa7g\xcc\xcc\xe2\xd7\x80\xf2\x0c\xcf	D\x93\xca\x84O\x15\xa7\x8e\xe9\x98\x19\xc6ls\xc8\xa9/\x1d\xe7\xafB\x1f\xd4\xfe\x1fY4w\x02\xed\x83\xc7\x84\xf8\x83\x0f\x00.\x1b\x84u;\xd49\xe0\xcag\xe6/\x0f\xab\xd9\xc3\xfa/b'\x94\x08~\xc1C\xc5\x88\x9cw\xa3\xfb\x90\xef\x9e\x97K/\xdfm\xfa|\xb7ii\xe9\x0e\xe7\x8dU\xec\xf1nq\x18\xbem\x0b\xaa	\xf4yKn\x1b\x87\xd6\x95\x03\xec\x8b\x82\xa4T]\x1a|\xbaS|\xf3\x0d\xe2\xf5\x94\x11\xdeQ\xad\x19J\x06h\xb5\x87\x15?\x0d\xb7\x1a\xf7\xf5k\x7f\x9fP\xee\x99\xcet#i\xa8\xb5C\x9c\xfej\xdf\xf7v4H\xfa^\xb9\xd6=vd\xc3\xac\x9d\xcd\xbaV\xa9\xd7ro\xad\x93\xd7ig8\x12\xc6\xce\xbe\xf1\xbap+\xc6]\xfb\xb4jG\x88V\xac\xd3v\xf8\n\x95DF\x14=\xe1\x1d\x1d\x8a\xa1\xe9\x8b\xe7\xce\xf4\xfed\x13\xf5|R\xfb\xd2v\x88\xaf~\xf5\x9bJ\x0f\x0c\xa3I/I\x0dO\xf7\x8b\x83C\xf0\xcd\x9e\xeap\xc0RaV\x97x\xf8\x1e\xdei\x95\xa4`\x0dX\xca\xb9\xbb\xcb\xbc\x86\x0e\xb7\x8d5\xd5\x8eI\x03\xca\xbf\xb2\xb7\xbfB\xba]\xcbm\xcep1\xb5Z\x8ft\x19\x1dg??O\xb9rX\xc1\xc6\xf2\xe6\x86q\xeb;,S\xcb\xed\x91\xc9\x18i\xf4]2\x9aV-\xd4\xef\xa6\x95\x8d\xd0\xcd\xea\x9e1\xdb\xc9\xdd\xd9\xffJ\xa8\xd6m\xcb\xe5\xf6.\xa3z	\xb8\x93v\x8bo';\xef>\x08\xe2{~[\x87~\xbfE\xab)\xfd\xfa\xd4\xf0=o\x9c\x19\x08\xfe~\xd4\xb3\x84\x02S5\xf50\xe1i\xb8\x97OkoW\xbbY\xddk\xa9\xbd\xb8\x9df\xd1\xcd\xc9|\xf0n\xe4\xc9\xba~uo\xd8\xca\xe6\x12\xddO\xd5\xe3\xf2B\xec\xf7\xba@\x7fpB\xde\xda\xb3\x0d{}\xc6\xf6\xfe\xec\xec\xcc\xc9\xdb\xbb\x0c\xf9d\xcc\xb8\xc7\x84\xf1vb\xcav\x8c6\xe1\x84\x87\xb7\xa2E\xb8\xb65\x1a\xc5\xb7}\x81\x7fZ\x9b\x9e\x89\xfe\x81\xc9\x85V\xfc\xbc\xbd\x95\xc0\x9e~yB|\xd7\xfd\xf8\xae\xc3\x91\xc6\xc4\x81R8)\x12\xfe\xc9\xf6\x8eSM\x1f\x02\xbf\xabk=\x15\xfa\x86ko\xa1\xfe\x1b]\xd3\x82\xfb)\xbc\xed\x1d\xdb\x93\xd6\xc3\x17\x9e\x15\xf2\x0c\xeb7n!\x95\x0e\x8f(T@}\x99\x18\xfaj_g\xd9H\xf9\xdblG\x1cf\n\xd9\xb4\xf2k5\x9e\xf7m4H\xb1\x1e\xba\x07\xf3\xa9/\x98#L\xe6N\xa2>\x9a\xbeK\xb8v\xf1~\xf6\xfab\x19/[_LM\xbaM?\xe96\x13\xa7\x81\x1e\x85\xfd\x0b\xe5\x05j\x8a\x80\xbcy\xcd3\xafu\x0b\xf8,\xcc#\x03prZ8\xd1\xb5=1\xd2Z\x8ey\xa2s\xfd\xd2\xdf\x1f\x8e\xba\xfe\x9e\x0bM\xbfy\x0fN\xdd4\xb8g\xd88P\xff\x15dTbj\x14H\x05\xab\xf2f\xe6\xeb9\xcf\xf3`\xfc['#\x81\x08\"k\x08\x1e\x0c\x03\xbe\x91d\xd2A\x0f\xd2\xcd\xb9\xd09\xe7\x19\x85:a\xcd\xe4\xed\xbe7\x85\xee\xa2t\x86b\xff\x00\xe7y,\xf0\xbf\xfd\x8c\xc6w\xf2\x00pWG}\xf5\x7f\x03\x00\x00\xff\xffPK\x07\x08{\xdc\x9d\xdbg\x08\x00\x00E\"\x00\x00PK\x03\x04\x14\x00\x08\x00\x08\x00J\x94mN\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x11\x00	\x00interstitial.htmlUT\x05\x00\x01,M\x89\\t\x93\xc1\x92\xdb \x0c\x86\xefy\nU\xe7z=\xb9\xf5\x00>t\xfa\x06}\x02\x02J\xac.`\x06\x94d<;\xfb\xee\x1dC\xe28\x99]_,K\xbf>	Y\xa8\x1fn\xb22'\x82Q\x82\x1fvjy\x81\xe3\xac\xd1KF\xf0&\x9e4R\xc4%D\xc6\x0d;\x00\x15H\x0c\xd8\xd1\xe4B\xa2\xf1,\xc7\xee\x17>\x02\xd1\x04\xd2xa\xba\xa6)\x0b\xee\xa0>v\x8aBQ4rda\xe3\xbbb\x8d'\xbd\xff	\x81#\x87sx8\xae\xecd\xd4\x8e.l\xa9\xab\x1f\x8d.,\x9e\x86\xbfd\xcf\x99e\x06\xcay\xca\xaao\xde%\xee9\xbeC&\xaf\xb1\xc8\xec\xa9\x8cD\x820f:j\xec\xd3\xf9\xe0\xd9\xf6\x1c\x85r\x91\xda\xc2\x9b-\xa5\x91\x8b\xcd\x9c\x04J\xb6\xdf(\xff\x15\x1cT\xdfd\xc3N\xf5m\x14\xea0\xb9\x19\xd8i\\\x8c\x86r|\x01\xebM)\xcbI\x1f\x84\xee\x9aMJ\x94\xab\xe8&[\x12\x83\xe1\xd8\xddfs\x8b\xbd@\xec\x14\xb1J\xab5\xa8\xde\xf1\xe5I\xb8b\x02\x95bN\xb4b\x00\xd4\xb8\x1f>>\x84B\xf2F\x08pi\x9b\xe3	\xe1\xed\xf3S\xf5\xe3~\xa3LO\xc2;\xaa	\xd3Z\xefQ|kn\xda\x8d\xe6\xf2rT\x00u8\x8bL\xb16\x9a2\x07\x93\xe7\xae\xb9p\xf8m\xec;\xc8\x04\xc5\x1cIf\xd57\xffW\x99\x8e\xc4\xb0/\xf7\xcc{\xc1\x12\x8c\xf7\xdd\xf2\xebq\xf8\xd3$\xcf\x94\xd7F7\xac\x152\xb2su\xc7\xbf\x1c\xc6\xaa\xde\x0ec\xc5\xde\x0c\xd5/;Pw\xa3^\xa4\xff\x01\x00\x00\xff\xffPK\x07\x08\xe9\x02\xe6\xcb\x89\x01\x00\x00Y\x03\x00\x00PK\x03\x04\x14\x00\x08\x00\x08\x00\x0b\x8dmN\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x0f\x00	\x00interstitial.jsUT\x05\x00\x01\x87@\x89\\\x94UmO#G\x13\xfc\xbe\xbf\xa2\x8e\xe7\xa4\xb5y`\xcd\x11\x94HXV\xc2\xdb	$\xe0\x94\xc0)\x1f\xa3\xf1N\xdb;bvf3\xd3k\xf0q\xfe\xef\xd1\xcc\xdaf\xfdF.\xdf`\xdc]\xdd]\xd5]\xdb\xeb\xe1\xc2VS\xa7\xc6\x05\xe3\xf8\xe8\xd3	\x1e\x0b\xc2E\xe1l\xa9\xea\x12g5\x17\xd6\xf9\x0cgZ#\x06y8\xf2\xe4&$\xb3\xa4\xd7\xc3WO\xb0#p\xa1<\xbc\xad]N\xc8\xad$(\x8f\xb1\x9d\x903$1\x9cB\xe0\xfc\xe1\xf2\xd0\xf3T\x13\xb4\xca\xc9x\x02\x17\x82\x91\x0b\x83!\x05\xa4\x91\xad\x8d\x842\xe0\x82p{squ\xffp\x85\x91\xd2\x94%Io\x7f?\xc1>\xce\xb4\x12\x1e#\xeb m^\x97d8\x1b\x13_i\n\x7f\x9eOod\x86\xcf\x11\x85\x9a'\x8f\xb2\xf6\x8c!\xe1\xfa\xf1\xeev\x1e\xe7\xb3\x00\xf5[%\x9c(\xf1\xea\xd9)3\x9eA\xc98\xf8\xcde3\x0d- \xc0\x16#ed\x93\xe4\x88kg\xf0\xda\x82\x9b\xc5\xbcQ\xbb,\xac\x83\xa9\xb5\x86\x1a\xc1Xn~\x0c\x00\xbddT\x9b\x9c\x955\xf8\xd8Q\xb2\x8b\xd7\x04\x98\x08\x07\xd2\x18\xec\x9a)\x04\xf6\x13`^\x9bt?\x99EFp/J\xf2\x95\xc8)\x0b\xd0\x01G\x19&\xe7Y\xb1\x12\x01q\xe5\xdf\xef\xdf\xf1:\xeb'K2\xff,\x88\x0brq\xd8J\x8c\xa3dy\xed\x1c\x19\xd6S\x0cI\x991&\x8a\x9eIB0\x04\xf6J;T\x9a\xf6\xe0sGd\xe0\xd57jX\xe1iEx\x1dZ\xabI\x98Y\xc3\xaeS\x13\xc1\x14gnw\x915 \xf7b\xf2\x17\x06\x18	\xed\xa9\xd5\xd2\x031\xea\n4	$\x16\xc2HM\xae\xd1\xfb\xeb\xcdR\xd3l\x13\xd4\x13\xd7\xd5\xd5$*>\xc0\x82\xe3N\xc3o\xaf\x17\x15\xda\x1b\x8a\xfc)\xa8\xe9\xc5\x88x\xba\x87a\xcdlM\x96\x00\x1f;i\xe5T)\xdc\xf4\xb0yL\xbb\x99\x902\x02\xde*\xcfd\xc8u\xd2\\\xab\xfc)=X\x87\x07\x9e\x95\x91\xf69+\x94g\xeb\xa6Y\xa8\xd3\x89\x8a\xcd\xba\xfd\xa4\xd5\xc0%\xb1P\xda\xafU\x96\xcd\xeb\x7f\xaa\x1c	Z\x94\x0f\xba\x17JJ2\xf3\x02\x18\xb4p\xd3n\x96k\xe1}@\xcb\xd8\x8e\xc7\x9a:i\x13\x9e6\xed!\xacig\x87H\xdd\x18\x10\x1b-\x852\x87\xb95L\x86\xdfC=\xc0\x87\x95v\"\x15\x00iO?\x00\xe6\xa8\xb4\x93\xcd\x16\xb7\x11\xa5\x8c!\xf7H/\x8c\xc1\x1a\x01\xbf\"\x9d\xff\x99\xe2\x14\xe9\xb5\x92\x84\x05\x1f\xab\xc2\\\xc7%\x0b\x96\xa6\xbeQ\xb3xa\xc1\x16\x9anj\xd1D\xa6\x07+\xb7\x95Y\xf3G|\x8f\xb3n\xfd%lD\xfb\xfa>[\x07_\n\xad\x17\xe7\xd4p~\x10\xcf\xd1\x88\x89\x1a\x8b\xe8\x13\xcd\xb4\x1e\xc2\x11\x023\x12C\xd2\xf69\x86	9\x11&'\x19\xf0\x98^x\xcbe,\xcao\x9eE\\\x1b\xd2\xd5\x97\x9a\xc9\x9d\xdb\x97\xb6\x01\xfd]\x93\x9b>\x90\xa6\x9c\xad\xeb\xa4\xff[\xeeR\x7f\x9e\x18\xe4\xbbh\xd4{/oU\xe5e2I%~\x0f\xa1\x18 \xed\x94\xca\x1c>+\xc9\xc5)\x8eO\x8e\xaa\x97.\x84\x91\xe8\x94\xe2e\xf1|r\xbc|N\xf1\xff\xf9\x12\xa51\xa2\xa0\xf0M:\xc5/?\xfd\xfc\x96\xa9\xcc\xf2\xfd\xe4\xe8\xd3\xb6T\xeb\x14\x19\x8e\x0c\x9f\xa2\xb2\x8e\x9dP\xdc=X\xa9\xda\x82\xdcU\xb5\xd5\xd8J\xd5\xd6\x1c+\xa9o\x93\x9e\x1c/\xfbZmF\x0b#}.*\xea\xa6qG\x03as\xfa\xaf\xe3\x8e\x87eo\xc9\xd6:\x9d@\xb4P\xc6\xaf\x1dO\xf8\xc2\x17\x94?E\x1f\xcd\x0ba\x82\xd5\x9b\xb0c\xf0,\xb8\x8e\xfb\xfe\x8e\x05\xe0\xc3`q\x0e\xa5\xe0\xbc\xb8\x0b\xf2u\xdeD\xec6\xcf\xe4\x17\x86\xb4\xdb\xef?\xec\xf8\xe9\x07|h\x8e\x8d\xf6\xea\xbd\xebA+\xa4\xcd=\x08\xbb\xa8\xdbH\xdf\x96=\x8b\x1e\x16\xbb\\C\xff\x97\xe66=\xed\xddf\xb6\x87\xcf\x82o5\x16\xb2\xbc\xb7M{\xba\xfcr7\xaf\x7fk\x85$\xb9nT\xad/e\xb7\x9f\xfc\x13\x00\x00\xff\xffPK\x07\x08fg\xf4\x1a\xe6\x03\x00\x00\xfd	\x00\x00PK\x03\x04\x14\x00\x08\x00\x08\x00\x8b\x8emN\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x0c\x00	\x00malware.tmplUT\x05\x00\x01WC\x89\\d\x91Ao\xd5@\x0c\x84\xef\xfc\n+\\@z$w\xd4W\xc4	\xce@\xc5\xd9\xddu\xb2\xab\xee\xda+\xdbQ\xfa\x14\xed\x7fGi(\x1cz\xb5\xec\xf1\xcc7\xfb\x1ei\xceL0$\xc2\x98y\x19z\xff\x95\x08,;\x01\x1e3\x08\xc2\x8e\x99\x0d*\x96\x0d\x95\xf6\x9d8\xf6\xfe\xee\xffi%3\\h\xe8\xfd\xab;\x86'R\x83\xb0\xaa\x12{\xb9\x810\xec\xfb\xf8\xa0e\xfc.\xe6\xbdC\xcdKr@w\xaa\xcd\xc1\x052\x9bc)\x10\x91\x17RY\x0d\x9a\xca\xa2X\xed8\xbe\xc9\xaa\x10\xa4\xb6\xd5I\xc1\x13:\x98\x13\x16\x10\x85H\x85\x9c\xce\x95\xcc\xb3hE\xcf\xc2\xf0a\x16\x05z\xc6\xda\n]\xa0%q\xb1\x0b44\xdbD\xa3]\xe0\xafe\xbb\x00r\x84\xa0\x14\xb3C@\x8d\xf6q|\x1b0\x92c.6\xf4\xfeMd)\x04\xbf\xe9\x11~d{\x02\xa5p\xa6\xbcCHJ\xf3uH\xee\xcd>O\xd3\xb6m\xe3\xf2\xb2=\x06\xa9\x93+\xb25T\xe2pSj\xa2>\x19\xce\xf4\xa8\xb2Y\xe6e\x8a\x19\x17\x16\xf3\x1c\xa6\xcc\x91\x9e\xc7\xe4\xb5|I\xe5J\xfc\xe9\xe1\xe7\xfbU\xcb\xf5\xa4\xd8\xfbp\x1f\xc9)8\xc5\xd7J\xee&\xbc\x7f\xc3y<\\\x1e=\xda	\x0d\x95\x80\x0fB\xa5\xdc\xe0\xf8\xfd21\xa9\xe4\xb9\x92\x1d\xfcN\xd1-{zU\xfe\x07\xe3O\x00\x00\x00\xff\xffPK\x07\x08\xe5\xab\x14\xc9X\x01\x00\x00)\x02\x00\x00PK\x03\x04\x14\x00\x08\x00\x08\x00\x8d\x8emN\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x17\x00	\x00social_engineering.tmplUT\x05\x00\x01ZC\x89\\d\x91A\x8b\xd4L\x10\x86\xef\xdf\xaf(r\xfa\x841\xb9\xcb\xee\x8a \xe8Q\x94\xc5sM\xfaM\xd2L\xa7\xaa\xa9\xaa\xd98\x84\xfe\xef\x92\x11W\xd0[\xf3\xf6\xc3[<U\xfb\x9e0e\x01u\x0b8e\x99\xbb\xd6>bD\x8d\xfc\x02\xf2\x1c >~\xf6\x1d\x92Z\xfb\xef\x0f\xbf\xc2\x9dgt\xad}\x88\xe0\xf1\x02sR\xa1}\xef\x9f\xad\xf4\x9f\xd5\xa35Z\xf9Fay\xbc\xd0M\xaf\x94%\x94\x92f\x99\xc9uE,\xc7+\xb1\xcc0\xbd:\x95|\x01e\xf1\xe0R~1Sll 52\xbc\x80\xef\xe9M\xafF\x15\xe6*\\(\xcb\xa4\xb6rd\x15\xfa\x7fR#\xfc\xe0\xb5\x16\x9c\xa8\xb2\xfb\xa6\x96\xfcDuQ\x01\xc9u=\xc3\xfct\xd4\x8d\x86\x94\x83F\xb6\xe4o\xfa\x7f\xe5\x12\x82s\xf1\xae\xb5O\xaas\x01}\xc7\x99\xbef\xbf\x90a\x84D\xb9QB`\x0c$\xaaK\xf6\xbb\xc9\xdf\xf6==0-\x86\xe9\xb1[\"\xaa\xbf\x1b\x86m\xdb\xfa\xf9\xde\xd8\x8f\xba\x0ea,^\xd9 \xe3\xcdP\xd5bp\x9ep6\xdd<\xcb<\xbc_\xca#\xe4\xed\xf3\xb7\xee\xe9\xcb\xef)\xc7M\xfca\xe0'\xaa\x86\x80$\n\xa53Hc\x81\xd1\x86\xf3\x1d8\xc2\xd7\xc5\xbf\n\xfe\x0c\x00\x00\xff\xffPK\x07\x08\xa5\xae\x1a9>\x01\x00\x00\xee\x01\x00\x00PK\x03\x04\x14\x00\x08\x00\x08\x00\x8d\x8emN\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x0d\x00	\x00unwanted.tmplUT\x05\x00\x01[C\x89\\d\x91\xc1\x8e\xd40\x0c\x86\xef<\x85U. As_\xed.\xe2\x04g`\xc5\xd9\x93\xbaI4\x89]\xd9\x1e:\xa3*\xef\x8e\xda\x15\xcba\xae\xd1g\xfb\xff\xfel\xdbDsa\x82!\x13N\x85\xd3\xd0\xfb\xafL`\xc5	p\x7f\x83(\xecX\xd8 \xa3\xb6\xf9RaQI\x8a\xcd\xb6\x8dx\xea\xfd\xdd\xff\x1d\x8d\xcc0\xd1\xd0\xfbWw\x8cgR\x03a\xd8\xb6\xf1E\xeb\xf8]\xcc{\x87VRv@wj\x8b\x83\x0b\xb8\x96x\x86\x9b\\\xa0\xb0\x0b\x146\xc7Z\x0b\xa7\xb7K\xe0\x19\xfd\xb8\xbfc\n'\x95\xd5v\x80\xae\x0bi!\x8e\x04\x1ffQ\xa0+\xb6\xa5\xd2'8\xdd f\xe4\xb4C\xc7H\x96F\x0b&\x02Q\xb0,\xeb\xeb\xb4+\x02NG\xc8\xdd\xd8\x8e\x14\x7f\x8a\x15\xff8\xde\xebM\xe4X\xaa\x0d\xbd\x7f\x13I\x95\xe07\x9d\xe0G\xb13(Eb\xaf7xD\xc8J\xf3\xd3\x90\xdd\x17{\x08a]\xd71\x1d\xf4\x18\xa5\x05Wd[P\x89\xe3Mi\x11\xf5`8\xd3?\xa10\x15L,\xe6%\x86\xc2\x13]\xc7\xec\xad~\xc9\xf5\x89\xf8\xf3\xcb\xcf\xf7\x17\xadO\xfb\xe6\x87\x10^K\xed}x\x9e\xe5\xc2\xd3\xdd\xef<\x06|\xbe+\xffM\xeao\x00\x00\x00\xff\xffPK\x07\x08\xcar\xc9\xae:\x01\x00\x00\xf8\x01\x00\x00PK\x03\x04\x14\x00\x08\x00\x08\x00\x0b\x8dmN\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x14\x00	\x00warning_triangle.svgUT\x05\x00\x01\x87@\x89\\\\\xce=j\xc40\x10\xc5\xf1~O1Lj\xaf\xc6\xcf\x03\x81`m\xb1\xa4H\x91\x1c\"\xb0\x8eF\xe0/b!\x19\x9d>8\xe0f\xcb\x1f\xfcy\xbc~\xcb\x81\xf6i\x9c7\xcf\x96\xd2\xfa\xe6\\)\xe5Z\xba\xeb\xf2\x1b\x1cD\xc4m90\x95\xf8H\xe6\x19\xba\xeeL6\xc4`\xe9T\x8eC\xb9/\xbbg!!(A\x99~\xe28z~y\xbf\xabv\xaf|\xbb\x10\x11\xf5\xebw2zx\xfe\x12\x12\x83f\xe8\x87\xd4\xb3\x9d\x97y`\xf7\\\xb6\x84\xd6\x80\xcf\x16\x04:P\xa7\x16Mg\x0dr\x03CF\x9d\xa4\xd1\x7f\xaa!k=&\xfa\xe3\xf2\xed\xf2\x17\x00\x00\xff\xffPK\x07\x08\xde\xf7\xb8\xd5\xa7\x00\x00\x00\xdb\x00\x00\x00PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00\x0b\x8dmN{\xdc\x9d\xdbg\x08\x00\x00E\"\x00\x00\x10\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xe8\x81\x00\x00\x00\x00interstitial.cssUT\x05\x00\x01\x87@\x89\\PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00J\x94mN\xe9\x02\xe6\xcb\x89\x01\x00\x00Y\x03\x00\x00\x11\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xa0\x81\xae\x08\x00\x00interstitial.htmlUT\x05\x00\x01,M\x89\\PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00\x0b\x8dmNfg\xf4\x1a\xe6\x03\x00\x00\xfd	\x00\x00\x0f\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xe8\x81\x7f\n\x00\x00interstitial.jsUT\x05\x00\x01\x87@\x89\\PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00\x8b\x8emN\xe5\xab\x14\xc9X\x01\x00\x00)\x02\x00\x00\x0c\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xa0\x81\xab\x0e\x00\x00malware.tmplUT\x05\x00\x01WC\x89\\PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00\x8d\x8emN\xa5\xae\x1a9>\x01\x00\x00\xee\x01\x00\x00\x17\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xa0\x81F\x10\x00\x00social_engineering.tmplUT\x05\x00\x01ZC\x89\\PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00\x8d\x8emN\xcar\xc9\xae:\x01\x00\x00\xf8\x01\x00\x00\x0d\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xa0\x81\xd2\x11\x00\x00unwanted.tmplUT\x05\x00\x01[C\x89\\PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00\x0b\x8dmN\xde\xf7\xb8\xd5\xa7\x00\x00\x00\xdb\x00\x00\x00\x14\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xa0\x81P\x13\x00\x00warning_triangle.svgUT\x05\x00\x01\x87@\x89\\PK\x05\x06\x00\x00\x00\x00\x07\x00\x07\x00\xf5\x01\x00\x00B\x14\x00\x00\x00\x00"
+	data := "PK\x03\x04\x14\x00\x08\x00\x08\x00\x00\x00!(\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x10\x00	\x00interstitial.cssUT\x05\x00\x01\x80Cm8\xbcXmo\xe3\xb8\xf1\x7f\xafO1@\xb0\xffM\x02K\x91\xe4\x87\xd8\np\xf8_\xf7\x0em\x81\xeb\xa2\xb8k\xfb\xb6\xa0\xa5\x91\xcd.E\n\x14\x95\xd8\xbb\xc8w/(Q\x12)\xcb\x0fyS`\xb1\x88)r8\x0f\xbf\xf9\xcd\x0c\x9f\x1e\xe1\x8b(\x8f\x92\xee\xf6\n\xe20Z\xc0?\xf6\x08_\xf6R\x14\xb4.\xe0\xe7Z\xed\x85\xac\x02\xf8\x991h6U \xb1B\xf9\x8aY\xe0\x01\xc0?+\x04\x91\x83\xda\xd3\n*Q\xcb\x14!\x15\x19\x02\xad`'^Qr\xcc`{\x04\x02\x7f\xfa\xe3\x17\xbfRG\x86\xc0h\x8a\xbcBP{\xa2 %\x1c\xb6\xe8\x01@.j\x9e\x01\xe5\xa0\xf6\x08\xbf\xfd\xf5\xcb\xaf_\xff\xf8\x15r\xca0\x80\xc7'\xcf#\xf0\xc3\x03H\x05\x132\x01\xb9\xdb\xde/63X.f\xb0\\=\xbcx\xef\x9e\xb7\x15\xd9\xb1\xd9\xb3%\xe9\xb7\x9d\xd4\xd2|\xb3\xfdmO\x15\xbe\\8\x0e\x90\x0b\xae\xfc\x9c\x14\x94\x1d\x13\xf8\xfc\x17d\xaf\xa8hJ\xe0+\xd6\xf8y\x06\x9f\x7f\xabS\x9a\x11\xf8\xb3$<\xd3\x0b\x15\xe1\x95_\xa1\xa4y\x7f\xba\xa2\xdf1\x81\xe7\xe5\xa7V\x9dZ)\xc1\xcf($w[r?\x7f\x9e\xc1\xf3j\x06\x9bh\x06Q\xa3\xc4V\xc8\x0ce\x02\xe1\xf0\xc3\x97$\xa3u\x95@\\\x1e\xda\xd5\x83\xbe\x88\xf2]b\xb6\xfb[q\xb0l\xbb\xcb\xf3F\xa5\xb4\x96\x95\xb6\xb5\x14\x94+\x94z)g\x82\xa8\xa4\x0d\xe3H\xeb`\xfd\xbc\xc4B/\x16D\xee(7:\x94$\xcb\x9a\xab\xa2\xb0<@\xbchuP\x92\xf0\x8a**\xb8\xd6\xe1\xe0W{\x92\x897\x88\xc3\xb0\xa8 \xad\xb74\xf5\xb7\xf8\x9d\xa2\xbc\x0f\x83\xc5\x0c\xc2\x19\x84A\xdc\xd9XW(\xfd\n\x19\xa6*\x01.x\x13\x17\xbf\x10\xdf\xfd3_\xaa3\x1f\xdep\xfb\x8d\xaa\xa9\x8f\xbd\xf7\x13\x92*\xfa\x8a\x1f\x0b\x82\xa8\x15\xa3\x1c\x1b\x0f\x0c\x92\xf6\x1a\xcc\xad\xa0\xde\xe2\x04B\x1d\x16\x98\x97\x876\xa2\xda\xd2\xe6_0\x7fh\x0e\xdfIT\xf2\xe8[Xh\xdd\xeb71H`\xad\x1d\xfa\xeeyw\x19*BYu\x11\xe3Cl\x16\xcb\xf2\x00!,\xc3\xf1\xf12\xe1B\xdd'9\x95\x95\xf2E\xee\xabc\x89\x0f\xf6\xbdJ\x94	\xc4\xe3s\xb6\x82\x83\x9f\x12\xa0|\x8f\x92\xaa\x01\x8d\x06\x16\xe754\x10\xeb\x82t\x01L\x0d\xc6\x15\x1e\x94\x9fa*$i\xe1T\xf3\x0c\xa5v\x7f\xff\xb5\x01[.d\x91@]\x96(SR\xe1\x94\xf2g\"d\x99p\xe9\xb2w\xcf\xdbGc\xef\xc7\xe1b\x06\xf3\xd5\x0c\xa2\xf0a\x94/Q\xb0j\xd3\xa5\xe1\x8d7l\xc3\xc9\x85,\x08\xd3[\xb5	\xfe\xde,GA\xec$\x97\xbf\x15J\x89\"\x81he\x02\xb1\x8f?tw|\xe1\xeew\xcf\x0b\xf64\xcb\xb0\x0dgF\xab\x92\x91c\x17\x12m\xa7*\x18\xfc\x18\x89\x8c\x97\x9fz\x8fk\x0b}\x92\xfd\xa7\xae\x94N\xfc\xf0S\x97\xa0\xd3_\xbb,\xbd\xf0\xd5\xa4\xea\xb9\x1dZe\x9a\x9e\x92%-\xc8\x0e\x13\xa8%\xbb\x7f*\xeb-\xa3\xe9\xd3\x1b\x91\x9c\xf2\xdd\xbf\x95\xa4\x84\xef\x18\x06\xd5\xeb\xee\xe1\xc5\x81\xad/\xb1\xc4\x16\x84\xe6\xcf\xd1wc\xb2Q\xae\x0b\xd2\xb3!\xd8\x1e\xb2\x10\xc2\xa2I\x14\x807\x9a\xa9}\xb7\xa5\xd1VSj\xa5\xa8\xa2\x84\xf9o\x92\x94\xa5M\x0e\x93\xf4l\x07\x10\x8b	\x8c\x18@u\xf7G\xa1\xa6\\R+\xd1\xa6JA\x0e\xbe\xd1c\x15\xbaz\xb5\xa6h\x1aab'\xe0\x87e\xd5b]\x1e&\x807oW_Q\xea\"\xc7|\xc2\xe8\x8e'P\xd0,cM\xea\x99\x9b\xa2\xe7eG\x15Z\xb4\xbfe\"\xfd6\x86j\xb4\x9a\xcf\xa0\xfbo\x9c(\xc1\xa65\xcbT\x92\x96\x80\x96F\xff\x06\x10\xe6\xee\x14\xb5O\x1b\xf7\xde\x15\x84r\xbf\xc0\xaa\";\x84\x9f\xa0t\x91Ly\x9f\xb3\x01'\xaf\x8e\xffm\x9a[F\xe5\xe1dW\x92\x90\\\x19&O\x19\x12\xa9K\x98\xdakmR\xc1\x15r\x95\xc0\xe7\xcf/\xf6}\x8al\x19N\xb9;\xa8\n\xc2\x98\xcf(?\xf1\xc9Tw\xe1V\xdaw\xcf\xfb\xff\x023J\xe0\xde\n\xed\xb3\x0em\xcb\xd8\xe7AfQi\x08Q\x0b\xe3\xf73\x02\x17q/\xb0%\xf9\x99\x16=R\xfc\x84\xb7\x1dr\x08\xd6\x1d}\x8d(g\x116\xc8\xec\xbcn\x8a\xc3E\xde\x06\x18\xb0e\x12\xf0\xdd\xf3\x00:2\x87\x1f\x8e@\xad\xbc.\xb2}\xc1p7\x9f/w.\x12\"\x837\xe7\xb4]\xf4\xach7\x10\xb7\xcd\xb2k&\xc04h\xcf\x99uc\x0c\x97\xc3\x89q\x9eY\x9auE\xb5\xb1\xe2\x04\xf9\xae\xc5\xf3\xde\xe2w\xcf{z|\xf4\xe0\x11\xfe&\xb6\x94!T%\xa64\xa7)\xe8n\x9c\xf2\xdd\x0cr!u\xea7\xe1\xce\xa0J%\"\xaf\xe0\xff\x80\x11\xb9C\x19\xe8\xb3_\xc9+\xdd55\xda\xc0\xa8\x02\"\x11\x08O\xf7Bb\x06J4m{\xcb/\xed@\x80FRs\xfe\x17\x13\xde.\xa9%\x96\x8c\xa4X5\xfb\x94(\xbb\xe4\xd3\xfd?U\x15\x887\xae\x8f\x0b\xc6t\xf2\xe9\xbb\x88\x96\xf3t	\xe3\x84gm.\xf5\x94>_\xf5\xebBR\xe4\xcat\x19\xa5\x90J\x12\xaa\xda\xbc\x98\x06\x84\xd3\x8bw\xcem:6\x08-,\x06\x15\xa6\x82g\xc4\xed\xf2\x86\xf6\xd4\x04\x05y\xd6\xa7\x87\x1d\xa7he\xc5\xa97\x8d\xf2\x8e\xea\xe3\x85k\x9a\x9b\xd5\x84g\x0d\xf4\x00\xdaC\x9d\xe1\x8b0rN]sH\xef\x8f\x99#\xae\xbf\xcc\x95f\x96ma\xf6\xe5\x83\xca\x830+(\x86\x90Nt`\x84gUJ\xba~u+\xb2\xe3\x14\xc8\xed\xf6\xb4\x1f\xeb\xc0\x00\xafw\xb1\xdb\xa3\xfb\xb1\xee\xd2\xb5Z\xf6\x11\x86\xb9\x1a\xc5\xc4\xfa90\xf2\xdc\x84\xa8\xcfX\xbf=\x19/\xc6\xeb\xa6\xa9\xb7>\x88nL\xca\xe9\x01\xb3V\xcaw\x9f\xf2\x0c\x0f	D7\xd1\xc4\x94*\x0e\x8f\xe9\x98\xb5\x15\xb3\xcb!\x87_\xfaf\xb3kf\xaeT\x8a\x9bA\x03S\xa8\x19);!\xee\\\xfca\n\x00n5\x08\x9bv\xa8\xf7\xdaP\xcf\xda\xbf&\xaa\x9a=\xd4\xff.\xb6B	\xffw\xdc\xd5\x8c\xc8Y?\xe2[\x85\xcdT\xe8\xcd|>Y\xefV\xe3z\xb7*\x0f7\xd5\xbcs\x8c}\xbe[<\x0d\x9fi\xe4\xa6\xebL\x87\x85\x1b\xe4vq\xe8\\9P\x9b\xb1D\x94$\xa5\xeah\xf0\xe9N\xfb\xe6\x1b\xc4\xcb[F}G53\x94\x9c\xa0\xd5\x1eV\xa6\xcbp\xa7\xf1X\xbf\xee\xf7+\xca\x9c\x89\xb7\x04ZI&\x1e\xd6\x1b\x85S8;}\\\xba\x1e{\xbb\xe3\xe5!\x0e#\xba\xf6\x00\xcc\xc0\xe8\xf4JQ\xd0\xb7J\x86\xe6G\xf1k|2L;'\xdb\xa2\xd8\xd9w\x9e\x17.a\xa7\x8b\xa8f\xa2&\xd0\xbd\xd8\xde\x1e\x9dMSD%\x91\x11\xfdfrE\x87\xf2\xd4\xf4`\xd3\x9b>\x9a~Wf\xe0\xe9\x8dm|i;d\x8a\xbfF\xb0\x9c\x82at\xd3\x8bS\xeb\xca\x13rp\n\xbc\xd9S\xefvX)\xcc\x1a\x8a\x87\x9f\xe0N\xab$\x053`\xa9f\xee\xae\x86\xf0'\xb6u\xceq\x1fC\x86\xb8\x84\xa7<l\x15\xd0E8\xcd\x90=\xdf^\x1d\x0f\xc6\x98\xd2\xefE\x17\xaf\x8c7\x9b[\xae<-\xc7]v\x8c\xf3\xe6\x82q\xcb+U\xc6\xe9\x1a&j\xd3%\x97\x9cM\xabx\xe1\xa4\xdfU\xd75\x08]-\xae\x19\xb3\x1e\"e\x95\xbe\xa1\xc4Mv@\x1f*\xa8\xd6m\xf3\xf9\xda\xb9\xad\xd7\"\x9e\xd4b\xb8\xa6\x97v\xa9\xde\xde\xec\xbc\xeb \x88\xaf\xf9m\x19N\xfb-Z\xf4\xcb\x8e\xaa\x1fF~\x13\xbe\x8d\xe1\xf0&|O\x8f\xf0\xf7\xbd\x9e%\x14\xb4\xef3\xf0\xf8\xe4\xe8ft\x98?[\xf8\xb4\x9c\xbfZ\\k\xa9\x87\x98}\xd8\xa2\x8b\x93\xf9\xc9\xbb\xd1\xa9\xc3\xa3x\xbaZ\x99\xe5\x06\xf4\xba\x9fj\xc6\xe5@\xe4\xb9&\xe8\x07'\xe4\x9d=\xebp\xd4gt\x0b\x17\x8a\xa7\x9d3\xf1\xfaj\x85|\xc6\xe2\x96J\xe8H\xba\x14sc\xf3M'&\xeaV\x14\x84\xa6p]\xc1\xb7}\xc1\x88\x10\x8c\xafn\xcf\xc4\xe9\x81\xc9\x85V\xbcY\x8f\xa1eg\xc5D\xbf|C|\x97\xe1\xa8\x8f\\\x86\x8e\x9b\x87\x13\x0e\x94\xc2\x9b\"a\x03\xc1i\x95.95l\xf8\x02\xfe\xa7\xae\x1d\x02u\x8bk/\xa1\xfe\x83\xae\xe9(\xf29\xbc\xec\x1d\xdb\x93\xc3\x05wxP\xc83\xcc\xf4\xc3\xb6\x90Jg\xba(\x95O]\x9d\x9cc.\xf7\xf5\xa09C\x7f\xab\xf5\x19\x87\xb5\xd4|[\xe3a5\x9e\xd7ml{\x06\xeb\xa1\xfbd>=}X\x18\x91\xe9\x90	\xd6\xfb\x85\xad\xfc\xfa\xcc\xc1\x9e\x85\xdds\x06[\x8bhh\x8alq\xf3x\xde?\xa9\xdeF\xaa\xcb\xd5hx[\xae\x1cnK\xc5(\x80\x1dL\x9cv\xea\xe9\x11\xfe\x85\xf2\x08M'\x0b\x85y\xcdk_\xeb\x02\xf8*\xdaG\x06\xe0\xe45p\xa2K\x0eW[\xcbs\x9e\xe8]?\xdf|\xcc\xf5\xd7\\\xb8\xd9\xdc\x02\xa7~\x1a\xcc\x19\x1a\x07\xea\xbf\xfc\x8cJL\xdb\xd8\xa7\x82\xd5\x05\x7f\x99r\x9e\x957&\x81\x8dX-#\x81\x08\"k\x08>\x19\x06\xa6F\x92\x9b\x0eNds{.t.\x9c\x18\x85za\xd1\x8b\xc3\xc0\xceK\xd5\xe8\xb1)\x1c\xbf4\xd9\x03\xdc\xc4c\xc1(\xb7\xcc\xf7\xb3\xf1\xedq\x01?\xa6M\xbbY\xc7w\xef\xdd\xfb\xef\x00PK\x07\x08*;aN\xe7\x08\x00\x00m\"\x00\x00PK\x03\x04\x14\x00\x08\x00\x08\x00\x00\x00!(\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x11\x00	\x00interstitial.htmlUT\x05\x00\x01\x80Cm8tS=\x8f\xdb0\x0c\xdd\xf3+X\xcd\xe7\x18\xb7u\x905\x14]\xba\x15\xfd\xf8\x01:\x89\x89\xd9\x93d\x83\xa2\x13\x18\x86\xff{a\xcb\xc99A\x9b%\x14\xf5\xf8\xc8\xf7L\xe9O\xbes2\xf6\x08\xad\xc4`\x0ez\xf9\x03O\xdc\xa8 \xac \xd8tn\x14&\xb5\\\xa1\xf5\xe6\x00\xa0#\x8a\x05\xd7Z\xce(\x8d\x1a\xe4T}V\x1f\x17\xc9Fl\xd4\x85\xf0\xdaw,\xea\x00\xeb\xcfuI0I\xa3(\x91\x90\x0dUv6`\xf3\xfa\x02\x91\x12\xc5!~$\xae\xe4\xa5m<^\xc8a\xb5\x1e\n\xbb\x90\x044?\xd1\x0dL2\x022w\xac\xeb\x92]\xba\x07J\xef\xc0\x18\x1a\x95e\x0c\x98[DQ\xd02\x9e\x1aU\xf7\xc3[ WS\x12\xe4,\xeb\x08G\x97sa\xce\x8e\xa9\x17\xc8\xec\xfe\x83\xfc\x93\x95\xd1u\x81\x99\x83\xae\x8b\x15\xfa\xad\xf3#\x90o\xd4\x12\x14*O\x17p\xc1\xe6\xdc\xa8}\xaf\xea\xca\xb6\xef\x91W\xd0\x06[\n\xa3\xa5Tm\xdelwO$\xaeKj\x85\xae\x91\xd1\xb5\xa7\xcb\x03\xf0N\x131g{\xc6;\x0d\x80n_\xcd4	\xc6>XAP\xcb\xd8\x94\xce\n\x8e\xf3\xac\xeb\xf6u\x87\xec\x1f\x807\xaa\x02\xeco\xb8]\xf3}\xb8\xd3\x9c\xec\xe5I*\x80~\x1bD\xba\xb4\x0e\xda3E\xcbcUR\xca|\xb1\xee\x1d\xa4\x83lO(\xa3\xaeK\xfe\xd6o\x9a\xe8\x04\xc7\x1f(<\xce\xf3\x9e\x86Qv$\xbfx\x04{\xb6\x94\xee\xf5\xd3\x84\xc9\xcf\xf3F\xb3\xaf\xf4(\x96B\xbe\xd5\xde\xbeU\x8e6\x84j\xd9 e\xbe\x16\xc8\xe30\xcfzw\\w\x92\x96\xbc_\x9f\xca?=\xdd:?{Z4~\xe7\xce!\xfay\xd6\xbd\xf9v\x82\xb1\x1b`H~Y\x1f\x9b<H\x8b\xc0\x94\xdf\xf3b\xd5\xd8\x0d\x0cy{\x05/\xcb\x11\xa2\x1dA\xdb\xcd\xe0\x95\xa8H\xd9\x96\x7f\x9a\x8e\xbf9\xcc\xb32\x17\xca$ -e\x18\xd2\xe29d\x12\xd4\xb55G]\xf7\x0f\xb6\xdd\x05o\x81\xae\x97%7\x07]\xb7\x12\x839\xfc\x1d\x00PK\x07\x08\xa3G\x8c\xb5\xfa\x01\x00\x00:\x04\x00\x00PK\x03\x04\x14\x00\x08\x00\x08\x00\x00\x00!(\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x0f\x00	\x00interstitial.jsUT\x05\x00\x01\x80Cm8\x94Vao\xdb6\x10\xfd\xae_\xf1\xea\x15\x90\xed9r\x96\x06\x1b\x90\xc0X\xd3$]\x8c\xa5\x0e\x16\xa7+\xfai\xa0\xc5\xb3E\x84\"U\x92\xb2\xe3\xa5\xfe\xef\x03))\x95\xe3$k\xbf\xd9\xe4\xdd\xbb\xbbw\xef\x8e\x1a\xf6\xfb\x11Nu\xb16b\x919\x1c\xec\x1f\xbc\xc1\x1fZ/$\xe1\xf2\xf24\x8a\xd0\xc7\xa5HIY\xe2(\x15'\x03\x97\x11N\n\x96f\xd4\xdc\x0c\xf07\x19+\xb4\xc2A\xb2\x8f\xae7\xe8\xd4W\x9d\xde\xb1\x87X\xeb\x129[Ci\x87\xd2\x12\\&,\xe6B\x12\xe8.\xa5\xc2A(\xa4:/\xa4`*%\xac\x84\xcbB\x9c\x1a%\xf1\x18\x9fk\x0c=sL(0\xa4\xbaXC\xcf\xdb\x86`.\x8a\x00\xa0\x8f\xcc\xb9\xc2\x1e\x0d\x87\xab\xd5*a!\xddD\x9b\xc5PV\x86vx9>=\x9fL\xcf\xf7\x0e\x92\xfd\xc6\xe5\xa3\x92d-\x0c})\x85!\x8e\xd9\x1a\xac(\xa4H\xd9L\x12$[A\x1b\xb0\x85!\xe2p\xda\xe7\xbc2\xc2	\xb5\x18\xc0\xea\xb9[1C5\x12\x17\xd6\x191+\xdd\x16kM\x92\xc2n\x19h\x05\xa6\xd09\x99b<\xed\xe0\xdd\xc9t<\x1d\xd48\x9f\xc67\x17W\x1fo\xf0\xe9\xe4\xfa\xfadr3>\x9f\xe2\xea\x1a\xa7W\x93\xb3\xf1\xcd\xf8j2\xc5\xd5{\x9cL>\xe3\xcf\xf1\xe4l\x00\x12.#\x03\xba+\x8c\xafC\x1b\x08O)\xf1\xa4\x86\x9b\x12m%2\xd7U;mA\xa9\x98\x8b\x14\x92\xa9E\xc9\x16\x84\x85^\x92QB-P\x90\xc9\x85\xf5\xdd\xb5`\x8a\xd7HR\xe4\xc21\x17N\xbf\xc9\xa2\xc6M\xa2\xfe0\x8a\x82\xb2\xfa8\x91\x82\xd9\x10\x89\xeb\xb4\xccI\xb9dA\xee\\\x92\xff\xf9n=\xe6	\xde\xebRqPud\x91\x97\xd6aF\xb8\xb8\xf9pY\xdb\xd9\xa0\x80\xb7\x053,\xc7\xbd\xa7N-6\x10\x1c7\x19a|\xd6\xa8\xa0\x86\xf0\xcd\x99\x0b\xe5\xcb\xee\xe3\xad!W\x1a\x85\xfb\x16\xdc&\xf8\xcd\xdba=[\xaa\x94\x12b\x1eT\x1a.=\xc00\x9a\x97*\xf5\x95\xe2uW\xf0\x1e\xee#`\xc9\x0cHb\xf4\\M\xde\xf08\x02\xea\xd8$\x8f\xa3M`\x04\x13\x96\x93-XJ	\xfa\xc3\xc8\xe3\x08\xe5\xc8X'\x9c`\x1eq\xeb\xef\xd7\xaf\xb8\xdf\x1cG\x0fd~\xca(\xb4\xd8\x93]\xf8>	\x8b\xb44\x86\x94\x93k\xcc\xc87l)hE\x1c\xcc\x81\xa1\x93\xeb\x99\x90\xd4\x81M\x0d\x91\x82\x15\xffV\xc3\xf4\xd6\xad\x0b\xc2\xfdLkILm*v\x8dX2G\xa1\xe6v\x16I\x052a\xcb\x7f0\xc2\x9cIK\xad\x94\xa6\xe4P\x16\xa0\xa5'1c\x8aK2U\xbf?\x8e\x1fz\x9a\xec\x82Zreq\xee\xbd\xacG\xad9\xeeV\xfc\x0e\x87\xa1C\x9d\x19Ko}7-\x9b\x93[w0+\x9d\xd3\xca\xcb\xf9u7.\x8c\xc8\x99Y\xefU\x87q/a\x9c\x07\xc0Ka\x1d)2\xdd8\x95\"\xbd\x8d\x07\x8f\xe1\x81\x95P\\\xaf\x92LX\xa7\xcd:\xf1q\xba\xa1c\x9b\xdeq\xd4J\xc0\x995\xd8\x82	\xd5\xc4\x1e\xc0fz\xa5\xb0\xcaH\x856H\xa1n\x91\xeaR\xf2\xa0\x9b\x19aIF\xcc\xeb\xa9\x13st_wcC\xae\x95h\x93\xc4\xce\xc5\x8fT\xf0P\x83\xd4i\x18\xc4\xc4\x90\xd4\x8cWeT\x85\x00\x9bv1g\xe4\x98\x90\xf6\x11\x8d\xbc:\xfd\xa1$B\xb7\x9b2\xbc\x883\xc19\xa9:\x00F-\xdc\xb8\x97\xa4\x92Y\xeb\xd1\x12\xa7\x17\x0bI\xdd\xb82\x8f+\xae+\x96\x9eQ\\/\x18\x04\xaer&\xd4^\xaa\x95#\xe5^B\x1d\xe0\xd5V:5!$-}\x07\x98\xa1\\/wS|\x8a(\xa1\x14\x99\x1b\xbas\x18=\"\xe0w\xc45\x171\x8e\x10_\x08N\xa8y\x8e\xb7Uv\x11&\x06\x86\xfchVS\xe4\xa7\xa5i\xee\xae *\xcbx\xb0\xb5(\x12\xad\xae\xc3y\xa8\xf5\xc9\x1b\xaf\x8b\xf6*y\xaf\x0dl\xce\xa4lvC5\xe5\x83 j\xc5\x96b\x11TUk\xc5\x82\x19\x82g\x86cFR\xaf\x82\x19\xe3K\xff^s\xbf>\x1c\xdd\xb9'\xc6\xbcIlw\xc6\x83lH\x16W\xa5#\xf3N\xdf\xb5\xb7\xe9\x97\x92\xcczJ\x92R\xa7M7\xfe\xa9\xe1.\x94\xe7\x1d\xbd\x16N+)\xbc\xe4\xb7-\x99\x07g\xe2\x82\xfd\xe5C`\x84\xb8\x9b\x0b\xb5\xb7\x12\xdceG88\xdc/\xeez\xfe\x9dC7gw\xcd\xf1\xe1\xc1\xc3q\x8c\x9fk\x11\xc5\xc1\"#\xff\xddt\x84\xdf\xde\xfc\xda\x98\x04\xc0\xe6\xfcp\xff\x97\xa7\\\xb5\x11\xa4\xaa\x07\xf4\x08\x856\xce0\xe1z\x83\xad\xa8-\xc8\xe7\xa2\xb6\x12\xdb\x8a\xda\xaac\xcb\xf5[\xa5\x87\x07\x0fym'#\x99\xe26e\x05\xf5\xe2\xa0Q\xcfvM\xffE\x98*\x8c\xb6\xda\xd6\x9aC?NL(\xfbhx\x86C\x9cf\x94\xde\x86G!\xcd\x98\xf2\xef\x96\xf2\x1a\x83u\xcc\x95A\xef/\xac\x00\xbc\x1a5\xe3\x903\x97f\x1f|\xfb\xba\xdf\x9a\xd8\xab\x8e\xc96\x0b\xe99\x9c\x11^=s\xf5\x1d{\xa8\xc6F[z/\xee\xa0-\xd2\xea\x1d\x84\xe7\xa8\xdbq\x7f\xca{\x13vX\xc8\xf2\x11\xfa\xff$\xb7\xbb\xd3^L\xe6i\xf3\x8d\xdf[\xd5\ny\xf8\xea\xd9]OgW\x1fjr.5\xe3\xc4\x1f/\xaa\xd6\xb3\xdf;\x8e\xfe\x1b\x00PK\x07\x08YX\xd4\xdb\x14\x05\x00\x00~\x0c\x00\x00PK\x03\x04\x14\x00\x08\x00\x08\x00\x00\x00!(\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x0c\x00	\x00malware.tmplUT\x05\x00\x01\x80Cm8d\x91Ao\xd5@\x0c\x84\xef\xfd\x15V\xb8\x80\xf4H\xee\xa8\xaf\x88\x13\x9c\x81\x8a\xb3\xbb\xebdW\xdd\xb5W\xb6\xa3\xf4)\xda\xff\x8e\xd2Pq\xe8\xd5\xb2\xc73\xdf\xec{\xa493\xc1\x90\x08c\xe6e\xe8\xfdw\"\xb0\xec\x04x\xcc \x08;f6\xa8X6T\xdaw\xe2\xd8\xfb\xdd\xff\xd3Jf\xb8\xd0\xd0\xfb7w\x0c\xcf\xa4\x06aU%\xf6r\x03a\xd8\xf7\xf1Q\xcb\xf8C\xcc{\x87\x9a\x97\xe4\x80\xeeT\x9b\x83\x0bd6\xc7R \"/\xa4\xb2\x1a4\x95E\xb1\xdaq|\x93U!Hm\xab\x93\x82't0', \n\x91\n9\x9d+\x99g\xd1\x8a\x9e\x85\xe1\xe3,\n\xf4\x82\xb5\x15\xba@K\xe2b\x17hh\xb6\x89F\xbb\xc0?\xcbv\x01\xe4\x08A)f\x87\x80\x1a\xed\xd3\xf8>`$\xc7\\l\xe8\xfd\xbb\xc8R\x08\xfe\xd0\x13\xfc\xcc\xf6\x0cJ\xe1Ly\x8f\x90\x94\xe6\xeb\x90\xdc\x9b}\x99\xa6m\xdb\xc6\xe5u{\x0cR'Wdk\xa8\xc4\xe1\xa6\xd4D}2\x9c\xe9Ie\xb3\xcc\xcb\x143.,\xe69L\x99#\xbd\x8c\xc9k\xf9\x9a\xca\x95\xf8\xf3\xe3\xaf\x0f\xab\x96\xebI\xb1\xf7\xe1!\x92Sp\x8ao\x95\xdcO\xf8\xf0\x8e\xf3x\xb8<z\xb4\x13\x1a*\x01\x1f\x84J\xb9\xc1\xf1\xfbubR\xc9s%;\xf8\x9d\xa2[\xf6\xf4\xa6<\xee;q\xec\xfd\xee\xef\x00PK\x07\x08\xe5\xab\x14\xc9X\x01\x00\x00)\x02\x00\x00PK\x03\x04\x14\x00\x08\x00\x08\x00\x00\x00!(\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x17\x00	\x00social_engineering.tmplUT\x05\x00\x01\x80Cm8d\x91A\x8b\xd5P\x0c\x85\xf7\xf3+BW\n\xcfv/3#\x82\xa0KQ\x06\xd7y\xbd\xa7\xed\xe5\xdd&\x97$o\xea\xa3\xdc\xff.}\xa0\x82\xee\xc29\x87$_\xb2\xef	S\x16P\xb7\x80S\x96\xb9k\xed\x13F\xd4\xc8\xaf \xcf\x01\xe2\xc3\xd9wHj\xed\xe1o~\x85;\xcf\xe8Z\xfb\x18\xc1\xe3\x05\xe6\xa4B\xfb\xde\xbfX\xe9\xbf\xa8Gk\xb4\xf2\x8d\xc2\xf2x\xa1\x9b^)K(%\xcd2\x93\xeb\x8aX\x8e*\xb1\xcc0\xbd:\x95|\x01e\xf1\xe0R\x0e\xc7u\x8a\x8d\x0d\xa4F\x86W\xf0]\xbd\xe9\xd5\xa8\xc2\\\x85\x0be\x99\xd4V\x8e\xacBo&5\xc2O^k\xc1\x89*\xbboj\xc9OT\x17\x15\x90\\\xd73\xccO\xa4F\xa3!\xe5\xa0\x91-\xf9\xdb\xfe\x7f\xb8\x84\xe0\\\xbck\xed\xb3\xea\\@?p\xa6o\xd9/d\x18!Qn\x94\x10\x18\x03\x89\xea\x92}96\xfb\x97\xbe\xa7G\xa6\xc50=uKD\xf5\xf7\xc3\xb0m[?\xdf;\xf6\xa3\xaeC\x18\x8bW6\xc8x3T\xb5\x18\x9c'\x9cM7\xcf2\x0f\x1f\x96\xf2\x04y\xf7\xf2\xbd{\xfe\xfa{\xca\xf1\x13\x7f\x1c\xf8\x99\xaa! \x89B\xe9\x0c\xd2X`\xb4\xe1|\x0f\x1c\xe2\x9f\xc3\xf7\xfb\x0eI\xad=\xfc\x1a\x00PK\x07\x08\xa5\xae\x1a9?\x01\x00\x00\xee\x01\x00\x00PK\x03\x04\x14\x00\x08\x00\x08\x00\x00\x00!(\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x0f\x00	\x00suspicious.tmplUT\x05\x00\x01\x80Cm8d\x90AK\x03A\x0c\x85\xef\xfd\x15\x8f9\xcb\xfa\x07j\xc5\x93z-\x88\xe7t\xe7\xb53\xd8fd\x93U\xcb\x90\xff.]\x15Ao\x81\x97\xe4\xfbx\xbdg\xee\xab\x12\xa9Pr\xd5C\x8a\xb8\x9b\x88s\x9ba\xf3\xf7\xf0.\xea\xf0\x86\xb7j\xd5\xe1\xa5\x1a\xac:o{\xa7\xe6\x88\xd5\xef\x93\x13\xcd\xe4\xc0\x14\xd1\xfb\xb0\xa5X\xd3\x08\xdc\xb7v8\x12\xcf\xdca[\xed\x05E\x0c\xda\x1c\x99\xce\xd1\x99!z\x86\x97\x89\xe2\x86\xa6\xe8}x\x9a\x8e\xc3C3\x8f\xb8\xc2nvT\xc7I\xce\xcb\xd5\x8e\xf0\xc2\xc5\x00^\xc4\x17W~\xbcr\xf4\xe1\xbfQ\xa6K=Z\x8ax\xdc/\x9b>\xcd\xe6\x7f\x11\x97`\x14\xc5ZP&\xeeo\xd2W\x1e\x916cS\xaf:\xf3R\xc0\x0fw}-\x9b\xa1wj\x8eX}\x0e\x00PK\x07\x08\xf3p\xf7I\xcf\x00\x00\x00C\x01\x00\x00PK\x03\x04\x14\x00\x08\x00\x08\x00\x00\x00!(\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x0f\x00	\x00unverified.tmplUT\x05\x00\x01\x80Cm8d\x90\xc1n\xd4@\x0c\x86\xef}\x8a_\xb9pY\x85\x17(\x958\x01W(\xe2\xecd\x9c\x8c\xb5\x931\x1a;T\xab\x91\xdf\x1de\xb7\xb4@Os\xf0\xf8\xf7\xf7\xfd\xbd'^\xa42\x86\xcc\x94\xa4\xaeC\xc4c\x16C\x91z\xc6\xac{I\xf5\x9dcb\xfc\xe2&\x8bp\xea\x9dk\x8a\xb8{\xdd\xdc\xd8\x8cV\x1e\">\xa9\xae\x85\xf1\x83'|\x15{\xdeGU\xc7\x9cy>\xe3)\xb3gn\xe8}\xfc\xde\xca\xf8Y\xcd# \x06\xa3\x85\xd1d\xcd\x8e\xaaO#\x1e\xdb\x05\xb4\x92TH\x05a\xd3\x8d\xab\x9f\xa0\x0d\xabb\xa2\xf9\x0cYp\xd1\x1d\xd4\xf8\x9ao{c\xd0\xa4\xbb\xc3\xff\xe0\x8foQ\x13;I\xb1!\xa2wY0~s*|\x183<7&G\x11s\x83g\xfa+\xe8 \xbc\np\xbaQ\x99_\x0f\x1f\xd7tA\"\xe7\xde\xb9\x18G|\xc4O5\x93\xa9\xbc\x04j\xfdO\xf7\xb5\x94\x891k]\xa4m/\xb5\x9e`\nqlt9l\x8f\xe7\xf9\xe7Q\xd1\x88/7mo\xbb\xf9\xbf\xb9\xa7\xeb`\xa6\x8a{Bn\xbc|\x18n\xf3\x88\xe1a\xd6\xeaRw\x86+<3L\x9c\xef\xdf\xd3\xc3\xd8;\xd7\x14q\xf7{\x00PK\x07\x08	\x182\x9f-\x01\x00\x00\x08\x02\x00\x00PK\x03\x04\x14\x00\x08\x00\x08\x00\x00\x00!(\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x0d\x00	\x00unwanted.tmplUT\x05\x00\x01\x80Cm8d\x91A\x8f\x131\x0c\x85\xef\xfb+\xac\xe1\x02\x12\xcc\xdcW\xdbE\x9c\xe0\x0cT\x9c\xdd\xc4\x93DM\xec\x91\xed2\xadF\xf9\xefh\x8a`\x0f\xbd&\x9f\xdf\xf3{\xde\xb6Hsa\x82!\x13\xc6\xc2i\xe8\xfdg&\xb0\xe2\x04\xb8\xbfA\x10v,l\x90Q\xdb|\xa9\xb0\xa8$\xc5f\xdbF\x1c{\x7fz\xd3hd\x86\x89\x86\xde\xbf\xb8c8\x93\x1a\x08\xc3\xb6\x8dG\xad\xe371\xef\x1dZI\xd9\x01\xdd\xa9-\x0e.\xe0Z\xc2\x19nr\x81\xc2.P\xd8\x1ck-\x9c\xfe;\x81g\xf4\xbb\xff\x8e)\x9cTV\xdb\x01\xba.\xa4\x858\x10\xbc\x9fE\x81\xae\xd8\x96J\x1f\xe1t\x83\x90\x91\xd3\x0e\xddG\xb24Z0\x11\x88\x82eY\xf7\x0f\xba\xba\"`\xbc/\xb9'\xb6\x9d\x85\xdf\xc5\x8a\x7f\x18\x1f\xe3Er,\xd5\x86\xde\xbf\x8a\xa4J\xf0\x8bN\xf0\xbd\xd8\x19\x94\x02\xb1\xd7\x1b\xbc d\xa5\xf90d\xf7\xc5\x9e\xa7i]\xd71\xdd\xe91H\x9b\\\x91mA%\x0e7\xa5E\xd4'\xc3\x99\xfe\x05\x9ab\xc1\xc4b^\xc2T8\xd2u\xcc\xde\xea\xe7\\\x0f\xc4\x9f\x8e?\xde]\xb4\x1ev\xe5\xe7i\xfa[j\xef\xc3\xeb,\x17\x8e\x0f\xd7y\x99\xf0\xf5\xa1\xfcq\xdb\x88c\xefO\x7f\x06\x00PK\x07\x08\xcar\xc9\xae<\x01\x00\x00\xf8\x01\x00\x00PK\x03\x04\x14\x00\x08\x00\x08\x00\x00\x00!(\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x14\x00	\x00warning_triangle.svgUT\x05\x00\x01\x80Cm8\\\xca\xbdJ\xc5@\x10\x06\xd0>O1\x8cu\xeeN\xbe\x0c\x08\x92Mq\xb1\xb0\xd0\x87\x10n\xdc	\xe4\x0f\xb3\xcc\x86<\xbd(\xa4\xb1<p\xba\xdd\x13\x1d\xf3\xb4\xec\x91-\xe7\xed%\x84R\xca\xad\xb4\xb7\xf5;\x05\x88H\xd8=1\x95\xf1\x91-2t;\x98l\x18\x93\xe5K>\x0e\xe5\xbe\x1e\x91\x85\x84\xa0\x04e\xfa\x1a\xa7)\xf2\xd3\xeb]\xb5}\xe6\xbe\"\"\xea\xb6\xcfl\xf4\x88\xfc!$\x06u\xe8\x9b\x9c\xd7]\xd6e\xe0\xf0\x7f6\x84\xc6\x80\xf7\x06\x04\xfa\xc597\xa8[\xab\xe15\x0c\x8es\x96Z\xff\xa8\x06\xd7\x93C_ua\xf7\xd4W?\x03\x00PK\x07\x08\xde\xf7\xb8\xd5\xa3\x00\x00\x00\xdb\x00\x00\x00PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00\x00\x00!(*;aN\xe7\x08\x00\x00m\"\x00\x00\x10\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xb4\x81\x00\x00\x00\x00interstitial.cssUT\x05\x00\x01\x80Cm8PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00\x00\x00!(\xa3G\x8c\xb5\xfa\x01\x00\x00:\x04\x00\x00\x11\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xb4\x81.	\x00\x00interstitial.htmlUT\x05\x00\x01\x80Cm8PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00\x00\x00!(YX\xd4\xdb\x14\x05\x00\x00~\x0c\x00\x00\x0f\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xb4\x81p\x0b\x00\x00interstitial.jsUT\x05\x00\x01\x80Cm8PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00\x00\x00!(\xe5\xab\x14\xc9X\x01\x00\x00)\x02\x00\x00\x0c\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xb4\x81\xca\x10\x00\x00malware.tmplUT\x05\x00\x01\x80Cm8PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00\x00\x00!(\xa5\xae\x1a9?\x01\x00\x00\xee\x01\x00\x00\x17\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xb4\x81e\x12\x00\x00social_engineering.tmplUT\x05\x00\x01\x80Cm8PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00\x00\x00!(\xf3p\xf7I\xcf\x00\x00\x00C\x01\x00\x00\x0f\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xa4\x81\xf2\x13\x00\x00suspicious.tmplUT\x05\x00\x01\x80Cm8PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00\x00\x00!(	\x182\x9f-\x01\x00\x00\x08\x02\x00\x00\x0f\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xa4\x81\x07\x15\x00\x00unverified.tmplUT\x05\x00\x01\x80Cm8PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00\x00\x00!(\xcar\xc9\xae<\x01\x00\x00\xf8\x01\x00\x00\x0d\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xb4\x81z\x16\x00\x00unwanted.tmplUT\x05\x00\x01\x80Cm8PK\x01\x02\x14\x03\x14\x00\x08\x00\x08\x00\x00\x00!(\xde\xf7\xb8\xd5\xa3\x00\x00\x00\xdb\x00\x00\x00\x14\x00	\x00\x00\x00\x00\x00\x00\x00\x00\x00\xb4\x81\xfa\x17\x00\x00warning_triangle.svgUT\x05\x00\x01\x80Cm8PK\x05\x06\x00\x00\x00\x00	\x00	\x00\x81\x02\x00\x00\xe8\x18\x00\x00\x00\x00"
 	fs.Register(data)
 }
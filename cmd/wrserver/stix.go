@@ -0,0 +1,359 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+const (
+	adminSTIXPath = "/admin/stix"
+	taxiiPath     = "/taxii2/"
+
+	mimeSTIX  = "application/stix+json;version=2.1"
+	mimeTAXII = "application/taxii+json;version=2.1"
+
+	// stixMaxIndicators is the number of unsafe URLs that are kept as STIX
+	// indicators at most. The least recently found are dropped to make room
+	// for new ones.
+	stixMaxIndicators = 10000
+
+	// taxiiCollectionID is the ID of the TAXII collection of the indicators.
+	taxiiCollectionID = "5c1f3f0e-2b8c-4c8e-9d4f-77a6e0c2a1b3"
+
+	// stixTimeFormat is the format of STIX timestamps, in UTC.
+	stixTimeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+// stixNamespace is the namespace of the UUIDv5 of the STIX identifiers
+// derived from names, as defined by STIX 2.1 for cyber-observable objects.
+var stixNamespace = [16]byte{0x00, 0xab, 0xed, 0xb4, 0xaa, 0x42, 0x46, 0x6c, 0x9c, 0x01, 0xfe, 0xd2, 0x33, 0x15, 0xa9, 0xb7}
+
+// stixIndicator is an unsafe URL that was found by the server.
+type stixIndicator struct {
+	url         string
+	threatTypes []webrisk.ThreatType
+	created     time.Time // When the URL was first found
+	modified    time.Time // When a threat type was last added
+	lastSeen    time.Time
+}
+
+// indicatorStore keeps the unsafe URLs found for a client, to export them as
+// STIX 2.1 indicators for threat intelligence platforms such as MISP and
+// OpenCTI.
+type indicatorStore struct {
+	tenant  string
+	created time.Time
+
+	mu         sync.Mutex
+	indicators map[string]*stixIndicator
+}
+
+// newIndicatorStore returns the store of the unsafe URLs found for the named
+// tenant, or for the default client if tenant is empty.
+func newIndicatorStore(tenant string) *indicatorStore {
+	return &indicatorStore{tenant: tenant, created: time.Now().Truncate(time.Millisecond), indicators: make(map[string]*stixIndicator)}
+}
+
+// record keeps the URL of d, with its threat types.
+func (s *indicatorStore) record(d detection) {
+	if s == nil {
+		return
+	}
+	// Times are kept to the precision of STIX timestamps, so that objects
+	// are not served again after the added_after time that they were served
+	// with.
+	now := d.Time.Truncate(time.Millisecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ind, ok := s.indicators[d.URL]
+	if !ok {
+		if len(s.indicators) >= stixMaxIndicators {
+			var oldest *stixIndicator
+			for _, i := range s.indicators {
+				if oldest == nil || i.lastSeen.Before(oldest.lastSeen) {
+					oldest = i
+				}
+			}
+			delete(s.indicators, oldest.url)
+		}
+		ind = &stixIndicator{url: d.URL, created: now, modified: now}
+		s.indicators[d.URL] = ind
+	}
+	ind.lastSeen = now
+	for _, tt := range d.ThreatTypes {
+		known := false
+		for _, k := range ind.threatTypes {
+			known = known || k == tt
+		}
+		if !known {
+			ind.threatTypes = append(ind.threatTypes, tt)
+			ind.modified = now
+		}
+	}
+}
+
+// stixObject is a STIX 2.1 identity or indicator.
+type stixObject struct {
+	Type           string   `json:"type"`
+	SpecVersion    string   `json:"spec_version"`
+	ID             string   `json:"id"`
+	CreatedByRef   string   `json:"created_by_ref,omitempty"`
+	Created        string   `json:"created"`
+	Modified       string   `json:"modified"`
+	Name           string   `json:"name"`
+	Description    string   `json:"description,omitempty"`
+	IdentityClass  string   `json:"identity_class,omitempty"`
+	IndicatorTypes []string `json:"indicator_types,omitempty"`
+	Pattern        string   `json:"pattern,omitempty"`
+	PatternType    string   `json:"pattern_type,omitempty"`
+	ValidFrom      string   `json:"valid_from,omitempty"`
+	Labels         []string `json:"labels,omitempty"`
+
+	added time.Time // When the object was last modified, for TAXII
+}
+
+// objects returns the identity of the server and the indicators modified
+// after the given time, by the time they were modified.
+func (s *indicatorStore) objects(after time.Time) []stixObject {
+	name := "wrserver"
+	if s.tenant != "" {
+		name += " (" + s.tenant + ")"
+	}
+	identity := stixObject{
+		Type:          "identity",
+		SpecVersion:   "2.1",
+		ID:            "identity--" + uuid5(stixNamespace, name),
+		Created:       stixTime(s.created),
+		Modified:      stixTime(s.created),
+		Name:          name,
+		IdentityClass: "system",
+		added:         s.created,
+	}
+	var objs []stixObject
+	if s.created.After(after) {
+		objs = append(objs, identity)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var inds []*stixIndicator
+	for _, ind := range s.indicators {
+		if ind.modified.After(after) {
+			inds = append(inds, ind)
+		}
+	}
+	sort.Slice(inds, func(i, j int) bool {
+		if !inds[i].modified.Equal(inds[j].modified) {
+			return inds[i].modified.Before(inds[j].modified)
+		}
+		return inds[i].url < inds[j].url
+	})
+	for _, ind := range inds {
+		labels := make([]string, len(ind.threatTypes))
+		for i, tt := range ind.threatTypes {
+			labels[i] = tt.String()
+		}
+		objs = append(objs, stixObject{
+			Type:           "indicator",
+			SpecVersion:    "2.1",
+			ID:             "indicator--" + uuid5(stixNamespace, identity.ID+" "+ind.url),
+			CreatedByRef:   identity.ID,
+			Created:        stixTime(ind.created),
+			Modified:       stixTime(ind.modified),
+			Name:           ind.url,
+			Description:    "URL found unsafe by the Web Risk API: " + strings.Join(labels, ", "),
+			IndicatorTypes: []string{"malicious-activity"},
+			Pattern:        "[url:value = '" + stixEscape(ind.url) + "']",
+			PatternType:    "stix",
+			ValidFrom:      stixTime(ind.created),
+			Labels:         labels,
+			added:          ind.modified,
+		})
+	}
+	return objs
+}
+
+// stixTime formats t as a STIX timestamp.
+func stixTime(t time.Time) string {
+	return t.UTC().Format(stixTimeFormat)
+}
+
+// stixEscape escapes s for a string literal of a STIX pattern.
+func stixEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
+
+// uuid5 returns the name-based UUID of name in the namespace ns.
+func uuid5(ns [16]byte, name string) string {
+	h := sha1.New()
+	h.Write(ns[:])
+	h.Write([]byte(name))
+	var u [16]byte
+	copy(u[:], h.Sum(nil))
+	u[6] = u[6]&0x0f | 0x50
+	u[8] = u[8]&0x3f | 0x80
+	return formatUUID(u)
+}
+
+// uuid4 returns a random UUID.
+func uuid4() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return formatUUID(u)
+}
+
+func formatUUID(u [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
+
+// parseAddedAfter parses the added_after query parameter of req, if any.
+func parseAddedAfter(req *http.Request) (time.Time, error) {
+	v := req.URL.Query().Get("added_after")
+	if v == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, v)
+}
+
+// writeSTIXJSON writes v to resp as JSON of the given media type.
+func writeSTIXJSON(resp http.ResponseWriter, mime string, v interface{}) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		problemError(resp, err.Error(), http.StatusInternalServerError, codeInternal)
+		return
+	}
+	resp.Header().Set("Content-Type", mime)
+	resp.Write(buf)
+}
+
+// serveSTIX serves the identity of the server and the indicators as a STIX
+// 2.1 bundle, only with the indicators modified after the added_after
+// parameter, if any.
+func serveSTIX(resp http.ResponseWriter, req *http.Request, s *indicatorStore) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	after, err := parseAddedAfter(req)
+	if err != nil {
+		problemError(resp, "invalid added_after", http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	writeSTIXJSON(resp, mimeSTIX, struct {
+		Type    string       `json:"type"`
+		ID      string       `json:"id"`
+		Objects []stixObject `json:"objects"`
+	}{"bundle", "bundle--" + uuid4(), s.objects(after)})
+}
+
+// taxiiServer serves the indicators as the only collection of a read-only
+// TAXII 2.1 server, whose discovery endpoint is at prefix+taxiiPath.
+type taxiiServer struct {
+	prefix string
+	store  *indicatorStore
+}
+
+// serve serves the discovery, API root, collections and objects endpoints.
+func (t *taxiiServer) serve(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		problemError(resp, "invalid method", http.StatusBadRequest, codeInvalidMethod)
+		return
+	}
+	root := t.prefix + taxiiPath + "api/"
+	collection := map[string]interface{}{
+		"id":          taxiiCollectionID,
+		"title":       "wrserver indicators",
+		"description": "URLs found unsafe by the Web Risk API",
+		"can_read":    true,
+		"can_write":   false,
+		"media_types": []string{mimeSTIX},
+	}
+	switch strings.TrimPrefix(req.URL.Path, taxiiPath) {
+	case "":
+		writeSTIXJSON(resp, mimeTAXII, map[string]interface{}{
+			"title":     "wrserver",
+			"default":   root,
+			"api_roots": []string{root},
+		})
+	case "api/":
+		writeSTIXJSON(resp, mimeTAXII, map[string]interface{}{
+			"title":              "wrserver",
+			"versions":           []string{mimeTAXII},
+			"max_content_length": 0,
+		})
+	case "api/collections/":
+		writeSTIXJSON(resp, mimeTAXII, map[string]interface{}{
+			"collections": []interface{}{collection},
+		})
+	case "api/collections/" + taxiiCollectionID + "/":
+		writeSTIXJSON(resp, mimeTAXII, collection)
+	case "api/collections/" + taxiiCollectionID + "/objects/":
+		t.serveObjects(resp, req)
+	default:
+		problemError(resp, "not found", http.StatusNotFound, codeNotFound)
+	}
+}
+
+// serveObjects serves the objects of the collection added after the
+// added_after parameter, if any, and of the types of the match[type]
+// parameter, if any.
+func (t *taxiiServer) serveObjects(resp http.ResponseWriter, req *http.Request) {
+	after, err := parseAddedAfter(req)
+	if err != nil {
+		problemError(resp, "invalid added_after", http.StatusBadRequest, codeInvalidFormat)
+		return
+	}
+	var types map[string]bool
+	if match := req.URL.Query().Get("match[type]"); match != "" {
+		types = make(map[string]bool)
+		for _, typ := range strings.Split(match, ",") {
+			types[typ] = true
+		}
+	}
+	objs := []stixObject{}
+	for _, obj := range t.store.objects(after) {
+		if types == nil || types[obj.Type] {
+			objs = append(objs, obj)
+		}
+	}
+	if len(objs) > 0 {
+		first, last := objs[0].added, objs[0].added
+		for _, obj := range objs {
+			if obj.added.Before(first) {
+				first = obj.added
+			}
+			if obj.added.After(last) {
+				last = obj.added
+			}
+		}
+		resp.Header().Set("X-TAXII-Date-Added-First", stixTime(first))
+		resp.Header().Set("X-TAXII-Date-Added-Last", stixTime(last))
+	}
+	writeSTIXJSON(resp, mimeTAXII, struct {
+		More    bool         `json:"more"`
+		Objects []stixObject `json:"objects"`
+	}{false, objs})
+}
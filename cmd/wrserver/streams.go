@@ -0,0 +1,473 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+const (
+	// streamBuffer is the number of events buffered for each event stream.
+	// Events are dropped, and the drops logged, for streams that fall
+	// further behind.
+	streamBuffer = 1024
+
+	// pubSubMetadataTokenURL is where access tokens for Pub/Sub are
+	// obtained on Google Cloud.
+	pubSubMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+	mimeKafkaJSON = "application/vnd.kafka.json.v2+json"
+)
+
+// Kinds of the events published to event streams.
+const (
+	streamDetection = "detection"
+	streamUpdate    = "update"
+)
+
+// streamEvent is an event published to the event streams: a detection, or
+// the update of a threat list of the database.
+type streamEvent struct {
+	Kind      string               `json:"kind"`
+	Host      string               `json:"host,omitempty"`
+	Tenant    string               `json:"tenant,omitempty"`
+	Detection *detection           `json:"detection,omitempty"`
+	Update    *webrisk.AuditRecord `json:"update,omitempty"`
+}
+
+// streamPublisher publishes events to a message broker.
+type streamPublisher interface {
+	publish(e streamEvent) error
+	close() error
+}
+
+// eventStreams publishes detections and database updates to message brokers,
+// such as for enrichment pipelines and real-time dashboards. Each broker is
+// published to in the background, so that a slow one holds up neither
+// lookups nor updates nor the others.
+type eventStreams struct {
+	host string
+
+	mu     sync.RWMutex
+	closed bool
+	queues []*streamQueue
+	wg     sync.WaitGroup
+}
+
+// streamQueue holds the events yet to be published to a broker.
+type streamQueue struct {
+	name    string // Name of the broker, for logging
+	pub     streamPublisher
+	ch      chan streamEvent
+	dropped int64 // Accessed atomically
+}
+
+// streams are the event streams of the server, or nil if there are none.
+var streams *eventStreams
+
+// newEventStreams returns the event streams to the brokers in dests,
+// separated by commas, or nil if there are none. A broker is either a
+// nats://[user:password@]host[:port]/subject URL of a NATS server, a
+// pubsub://project/topic URL of a Google Cloud Pub/Sub topic, or a
+// kafka+http(s)://host[:port]/topic URL of a Kafka topic behind a Confluent
+// REST Proxy.
+func newEventStreams(dests string) (*eventStreams, error) {
+	s := new(eventStreams)
+	for _, dest := range strings.Split(dests, ",") {
+		if dest = strings.TrimSpace(dest); dest == "" {
+			continue
+		}
+		name, pub, err := openStreamPublisher(dest)
+		if err != nil {
+			for _, q := range s.queues {
+				q.pub.close()
+			}
+			return nil, err
+		}
+		s.queues = append(s.queues, &streamQueue{name: name, pub: pub, ch: make(chan streamEvent, streamBuffer)})
+	}
+	if len(s.queues) == 0 {
+		return nil, nil
+	}
+	s.host, _ = os.Hostname()
+	for _, q := range s.queues {
+		s.wg.Add(1)
+		go func(q *streamQueue) {
+			defer s.wg.Done()
+			for e := range q.ch {
+				if err := q.pub.publish(e); err != nil {
+					log.Printf("Event stream %s: %v", q.name, err)
+				}
+			}
+		}(q)
+	}
+	return s, nil
+}
+
+// openStreamPublisher returns the name and the publisher of the broker at
+// dest.
+func openStreamPublisher(dest string) (string, streamPublisher, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return "", nil, err
+	}
+	name := u.Scheme + "://" + u.Host + u.Path
+	topic := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "nats":
+		if u.Hostname() == "" || topic == "" || strings.ContainsAny(topic, "/ *>") {
+			return "", nil, fmt.Errorf("invalid NATS subject: %q", dest)
+		}
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "4222")
+		}
+		return name, &natsPublisher{addr: addr, subject: topic, user: u.User}, nil
+	case "pubsub":
+		if u.Host == "" || topic == "" || strings.Contains(topic, "/") {
+			return "", nil, fmt.Errorf("invalid Pub/Sub topic: %q", dest)
+		}
+		return name, newPubSubPublisher(u.Host, topic), nil
+	case "kafka+http", "kafka+https":
+		if u.Host == "" || topic == "" {
+			return "", nil, fmt.Errorf("invalid Kafka topic: %q", dest)
+		}
+		base, topic := path.Split(u.Path)
+		proxy := &url.URL{Scheme: strings.TrimPrefix(u.Scheme, "kafka+"), User: u.User, Host: u.Host, Path: base + "topics/" + topic}
+		return name, &kafkaPublisher{url: proxy.String(), client: &http.Client{Timeout: sinkTimeout}}, nil
+	}
+	return "", nil, fmt.Errorf("invalid event stream: %q", dest)
+}
+
+// detection publishes d. It never blocks: d is dropped for the brokers that
+// are too far behind.
+func (s *eventStreams) detection(d detection) {
+	if s == nil {
+		return
+	}
+	s.send(streamEvent{Kind: streamDetection, Tenant: d.Tenant, Detection: &d})
+}
+
+// audit returns the function that publishes the threat list updates of the
+// named tenant, or of the default client if tenant is empty, and passes them
+// on to next, if not nil, for Config.Audit. It returns next if s is nil.
+func (s *eventStreams) audit(tenant string, next func(webrisk.AuditRecord) error) func(webrisk.AuditRecord) error {
+	if s == nil {
+		return next
+	}
+	return func(r webrisk.AuditRecord) error {
+		s.send(streamEvent{Kind: streamUpdate, Tenant: tenant, Update: &r})
+		if next == nil {
+			return nil
+		}
+		return next(r)
+	}
+}
+
+// send queues e for every broker.
+func (s *eventStreams) send(e streamEvent) {
+	e.Host = s.host
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	for _, q := range s.queues {
+		select {
+		case q.ch <- e:
+		default:
+			if n := atomic.AddInt64(&q.dropped, 1); n == 1 || n%1000 == 0 {
+				log.Printf("Event stream %s is falling behind: %d events dropped", q.name, n)
+			}
+		}
+	}
+}
+
+// close publishes the queued events and closes the connections to the
+// brokers.
+func (s *eventStreams) close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	for _, q := range s.queues {
+		close(q.ch)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	for _, q := range s.queues {
+		if err := q.pub.close(); err != nil {
+			log.Printf("Event stream %s: %v", q.name, err)
+		}
+	}
+}
+
+// natsPublisher publishes events to the subject of a NATS server, followed
+// by their kind, such as webrisk.detection, with the core NATS protocol.
+type natsPublisher struct {
+	addr, subject string
+	user          *url.Userinfo // Nil without authentication
+
+	mu   sync.Mutex // Guards writes to conn
+	conn net.Conn   // Nil until the first event, or after an error
+}
+
+func (p *natsPublisher) publish(e streamEvent) error {
+	msg, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err = p.send(e.Kind, msg); err != nil {
+		// Connect again once, such as after the server restarted.
+		p.close()
+		if err = p.send(e.Kind, msg); err != nil {
+			p.close()
+		}
+	}
+	return err
+}
+
+// send publishes msg to the subject of kind, connecting first if needed.
+func (p *natsPublisher) send(kind string, msg []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		if err := p.connect(); err != nil {
+			return err
+		}
+	}
+	p.conn.SetWriteDeadline(time.Now().Add(sinkTimeout))
+	_, err := fmt.Fprintf(p.conn, "PUB %s.%s %d\r\n%s\r\n", p.subject, kind, len(msg), msg)
+	return err
+}
+
+// connect connects to the server, and answers its pings in the background
+// so that it does not drop the connection while no events are published.
+// The lock must be held.
+func (p *natsPublisher) connect() error {
+	conn, err := net.DialTimeout("tcp", p.addr, sinkTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(sinkTimeout))
+	r := bufio.NewReader(conn)
+	info, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(info, "INFO ") {
+		conn.Close()
+		if err == nil {
+			err = fmt.Errorf("unexpected greeting: %q", strings.TrimSpace(info))
+		}
+		return err
+	}
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": "wrserver", "lang": "go", "version": webrisk.Version()}
+	if p.user != nil {
+		if pass, ok := p.user.Password(); ok {
+			opts["user"], opts["pass"] = p.user.Username(), pass
+		} else {
+			opts["auth_token"] = p.user.Username()
+		}
+	}
+	connect, err := json.Marshal(opts)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", connect); err != nil {
+		conn.Close()
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	p.conn = conn
+	go p.readLoop(conn, r)
+	return nil
+}
+
+// readLoop answers the pings of the server on conn, and logs its errors,
+// until conn is closed.
+func (p *natsPublisher) readLoop(conn net.Conn, r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch line = strings.TrimSpace(line); {
+		case line == "PING":
+			p.mu.Lock()
+			if p.conn == conn {
+				conn.SetWriteDeadline(time.Now().Add(sinkTimeout))
+				io.WriteString(conn, "PONG\r\n")
+			}
+			p.mu.Unlock()
+		case strings.HasPrefix(line, "-ERR"):
+			log.Printf("NATS server %s: %s", p.addr, line)
+		}
+	}
+}
+
+func (p *natsPublisher) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn = nil
+	return err
+}
+
+// pubSubPublisher publishes events to a Google Cloud Pub/Sub topic, with
+// their kind as the "kind" attribute. It authenticates with the default
+// service account of the metadata server, or not at all with the emulator
+// given by $PUBSUB_EMULATOR_HOST.
+type pubSubPublisher struct {
+	url    string
+	client *http.Client
+	token  func(ctx context.Context) (string, error) // Nil for the emulator
+}
+
+func newPubSubPublisher(project, topic string) *pubSubPublisher {
+	p := &pubSubPublisher{client: &http.Client{Timeout: sinkTimeout}}
+	base := "https://pubsub.googleapis.com"
+	if emulator := os.Getenv("PUBSUB_EMULATOR_HOST"); emulator != "" {
+		base = "http://" + emulator
+	} else {
+		p.token = pubSubMetadataToken
+	}
+	p.url = fmt.Sprintf("%s/v1/projects/%s/topics/%s:publish", base, url.PathEscape(project), url.PathEscape(topic))
+	return p
+}
+
+func (p *pubSubPublisher) publish(e streamEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	type message struct {
+		Data       []byte            `json:"data"`
+		Attributes map[string]string `json:"attributes"`
+	}
+	body, err := json.Marshal(struct {
+		Messages []message `json:"messages"`
+	}{[]message{{Data: data, Attributes: map[string]string{"kind": e.Kind}}}})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sinkTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mimeJSON)
+	if p.token != nil {
+		tok, err := p.token(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+	return doPublish(p.client, req)
+}
+
+func (p *pubSubPublisher) close() error { return nil }
+
+// pubSubMetadataToken obtains an access token for the default service
+// account from the metadata server.
+func pubSubMetadataToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", pubSubMetadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server: %s", resp.Status)
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", err
+	}
+	return tok.AccessToken, nil
+}
+
+// kafkaPublisher publishes events to a Kafka topic through the v2 API of a
+// Confluent REST Proxy, keyed by their kind.
+type kafkaPublisher struct {
+	url    string
+	client *http.Client
+}
+
+func (p *kafkaPublisher) publish(e streamEvent) error {
+	type record struct {
+		Key   string      `json:"key"`
+		Value streamEvent `json:"value"`
+	}
+	body, err := json.Marshal(struct {
+		Records []record `json:"records"`
+	}{[]record{{Key: e.Kind, Value: e}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mimeKafkaJSON)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	return doPublish(p.client, req)
+}
+
+func (p *kafkaPublisher) close() error { return nil }
+
+// doPublish sends req, and returns an error unless it succeeded.
+func doPublish(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
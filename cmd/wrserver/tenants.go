@@ -0,0 +1,195 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+// tenantPrefix is the path prefix under which the endpoints of each tenant
+// are served. For example, the lookup endpoint of tenant "acme" is served at
+// /t/acme/v1/uris:search.
+const tenantPrefix = "/t/"
+
+var validTenantName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// tenantConfig is the configuration of a single tenant, as read from the
+// file specified by -tenants. Each tenant has its own database, cache,
+// cache TTL policy, update schedule, and stats. Settings that are not
+// specified are taken from the corresponding command line flags, except for
+// the audit log, which is only kept for tenants that specify one. The cache
+// and counters of each tenant are saved next to the -cachePath and
+// -statsPath files, with the tenant name as a suffix, and the cache is
+// stored under its own key prefix in a shared -redis or -memcached cache, so
+// that clearing it does not affect other tenants.
+//
+// Example tenants file:
+//
+//	[
+//	    {"name": "acme", "apiKey": "...", "db": "/var/lib/wrserver/acme.db"},
+//	    {"name": "globex", "apiKey": "...", "threatTypes": "MALWARE", "updatePeriod": "1h", "pminTTL": "1h"}
+//	]
+type tenantConfig struct {
+	Name         string `json:"name"`
+	APIKey       string `json:"apiKey"`
+	DB           string `json:"db"`
+	ThreatTypes  string `json:"threatTypes"`
+	UpdatePeriod string `json:"updatePeriod"`
+	MemoryLimit  string `json:"memoryLimit"`
+	AuditLog     string `json:"auditLog"`
+	PMinTTL      string `json:"pminTTL"`
+	NMinTTL      string `json:"nminTTL"`
+	PMinTTLs     string `json:"pminTTLs"`
+	NMinTTLs     string `json:"nminTTLs"`
+}
+
+// parseTenants parses and validates a tenants file.
+func parseTenants(r io.Reader) ([]tenantConfig, error) {
+	var tcs []tenantConfig
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&tcs); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, tc := range tcs {
+		switch {
+		case !validTenantName.MatchString(tc.Name):
+			return nil, fmt.Errorf("invalid tenant name: %q", tc.Name)
+		case seen[tc.Name]:
+			return nil, fmt.Errorf("duplicate tenant name: %q", tc.Name)
+		case tc.APIKey == "":
+			return nil, fmt.Errorf("tenant %q: missing apiKey", tc.Name)
+		}
+		seen[tc.Name] = true
+	}
+	return tcs, nil
+}
+
+// loadTenants reads the tenants file at path.
+func loadTenants(path string) ([]tenantConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseTenants(bytes.NewReader(b))
+}
+
+// config returns the client configuration of the tenant, filling in unset
+// fields from base, and the audit log of the tenant, if any, to close along
+// with its client.
+func (tc tenantConfig) config(base webrisk.Config) (webrisk.Config, *webrisk.AuditLog, error) {
+	conf := base
+	conf.APIKey = tc.APIKey
+	conf.Store = nil
+	if tc.DB != "" {
+		store, err := openStore(tc.DB, base.WritePolicy)
+		if err != nil {
+			return conf, nil, err
+		}
+		conf.Store = store
+	}
+	if tc.ThreatTypes != "" {
+		conf.ThreatListArg = tc.ThreatTypes
+	}
+	if tc.UpdatePeriod != "" {
+		d, err := time.ParseDuration(tc.UpdatePeriod)
+		if err != nil {
+			return conf, nil, errors.New("invalid updatePeriod")
+		}
+		conf.UpdatePeriod = d
+	}
+	if tc.MemoryLimit != "" {
+		n, err := parseByteSize(tc.MemoryLimit)
+		if err != nil {
+			return conf, nil, errors.New("invalid memoryLimit")
+		}
+		conf.MemoryLimit = n
+	}
+	for _, ttl := range []struct {
+		name, value string
+		d           *time.Duration
+	}{
+		{"pminTTL", tc.PMinTTL, &conf.PMinTTL},
+		{"nminTTL", tc.NMinTTL, &conf.NMinTTL},
+	} {
+		if ttl.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(ttl.value)
+		if err != nil {
+			return conf, nil, errors.New("invalid " + ttl.name)
+		}
+		*ttl.d = d
+	}
+	for _, ttls := range []struct {
+		name, value string
+		m           *map[webrisk.ThreatType]time.Duration
+	}{
+		{"pminTTLs", tc.PMinTTLs, &conf.PMinTTLs},
+		{"nminTTLs", tc.NMinTTLs, &conf.NMinTTLs},
+	} {
+		if ttls.value == "" {
+			continue
+		}
+		m, err := parseThreatTTLs(ttls.value)
+		if err != nil {
+			return conf, nil, fmt.Errorf("invalid %s: %v", ttls.name, err)
+		}
+		*ttls.m = m
+	}
+	if conf.CachePath != "" {
+		conf.CachePath += "." + tc.Name
+	}
+	if conf.StatsPath != "" {
+		conf.StatsPath += "." + tc.Name
+	}
+	conf.Audit = nil
+	var al *webrisk.AuditLog
+	if tc.AuditLog != "" {
+		var err error
+		if al, err = webrisk.OpenAuditLog(tc.AuditLog, 0, 0); err != nil {
+			return conf, nil, err
+		}
+		conf.Audit = al.Record
+	}
+	conf.Audit = streams.audit(tc.Name, conf.Audit)
+	if conf.Logger != nil {
+		conf.Logger = &prefixWriter{w: conf.Logger, prefix: []byte("[" + tc.Name + "] ")}
+	}
+	return conf, al, nil
+}
+
+// prefixWriter prepends a prefix to every write, which for a log.Logger is
+// every log line.
+type prefixWriter struct {
+	w      io.Writer
+	prefix []byte
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	if _, err := pw.w.Write(append(pw.prefix[:len(pw.prefix):len(pw.prefix)], p...)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+// unshortenTimeout bounds the time spent following the redirects of a URL.
+const unshortenTimeout = 5 * time.Second
+
+var (
+	// unshortenHops is the number of HTTP redirects that are followed from
+	// the URLs that /r is asked to redirect to, or 0 if they are not.
+	unshortenHops int
+
+	// unshortenLookups is whether the lookup endpoints also follow the
+	// redirects of the URLs that they look up.
+	unshortenLookups bool
+
+	// unshortenClient is the client that redirects are followed with.
+	unshortenClient = newPublicClient(false)
+)
+
+// errPrivateAddress is returned for the connections to addresses that are
+// not publicly routable, so that clients cannot use wrserver to probe the
+// network that it runs in.
+var errPrivateAddress = errors.New("connection to a private address refused")
+
+// newPublicClient returns a client for the URLs given by clients, which
+// does not follow redirects, and does not connect to private addresses
+// unless allowPrivate is set.
+func newPublicClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: unshortenTimeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast() {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: unshortenTimeout,
+			MaxIdleConnsPerHost: 4,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// unshorten returns the URLs that rawURL redirects to, in order, following
+// up to hops HTTP redirects between http and https URLs. It stops at the
+// first URL that does not redirect, or that cannot be fetched.
+func unshorten(ctx context.Context, rawURL string, hops int) []string {
+	ctx, cancel := context.WithTimeout(ctx, unshortenTimeout)
+	defer cancel()
+	var chain []string
+	seen := map[string]bool{rawURL: true}
+	for len(chain) < hops {
+		next, ok := redirectTarget(ctx, rawURL)
+		if !ok || seen[next] {
+			break
+		}
+		seen[next] = true
+		chain = append(chain, next)
+		rawURL = next
+	}
+	return chain
+}
+
+// redirectTarget returns the URL that rawURL redirects to, if any. It asks
+// with a HEAD request, or a GET request for servers that do not support
+// HEAD, without reading the body.
+func redirectTarget(ctx context.Context, rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", false
+	}
+	for _, method := range []string{"HEAD", "GET"} {
+		req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+		if err != nil {
+			return "", false
+		}
+		resp, err := unshortenClient.Do(req)
+		if err != nil {
+			return "", false
+		}
+		resp.Body.Close()
+		if method == "HEAD" && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+			continue
+		}
+		switch resp.StatusCode {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return "", false
+		}
+		loc, err := resp.Location()
+		if err != nil || (loc.Scheme != "http" && loc.Scheme != "https") {
+			return "", false
+		}
+		return loc.String(), true
+	}
+	return "", false
+}
+
+// followRedirects returns rawURL, with its threats and their source, unless
+// it is safe and one of the URLs that it redirects to is not, in which case
+// it returns the first of them instead. Redirects are only followed if
+// unshortenHops is set. Lookup errors of the redirects are ignored.
+func followRedirects(ctx context.Context, wr *webrisk.UpdateClient, rawURL string, threats []webrisk.URLThreat, source webrisk.LookupSource) (string, []webrisk.URLThreat, webrisk.LookupSource) {
+	if len(threats) > 0 || unshortenHops <= 0 {
+		return rawURL, threats, source
+	}
+	chain := unshorten(ctx, rawURL, unshortenHops)
+	if len(chain) == 0 {
+		return rawURL, threats, source
+	}
+	hopThreats, hopSources, err := wr.LookupURLsSources(ctx, chain)
+	if err != nil {
+		return rawURL, threats, source
+	}
+	for i, hop := range chain {
+		if len(hopThreats[i]) > 0 {
+			return hop, hopThreats[i], hopSources[i]
+		}
+	}
+	return rawURL, threats, source
+}
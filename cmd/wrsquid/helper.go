@@ -0,0 +1,193 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// maxRequestSize is the length of the longest request line that is read.
+const maxRequestSize = 1 << 20
+
+// quoter escapes the values of the key=value pairs of answers.
+var quoter = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", " ", "\r", " ")
+
+// helper answers the requests of Squid with the verdicts of a wrserver.
+type helper struct {
+	acl          bool   // Whether to answer as an external ACL, rather than a URL rewriter
+	search       string // URL of the search endpoint of the wrserver
+	interstitial string // Base URL of the wrserver for browsers
+	timeout      time.Duration
+	client       *http.Client
+}
+
+// newHelper returns a helper that looks up URLs with the wrserver at base,
+// and redirects unsafe ones to the one at interstitial, or answers as an
+// external ACL if acl is set.
+func newHelper(acl bool, base, interstitial string) *helper {
+	return &helper{
+		acl:          acl,
+		search:       strings.TrimSuffix(base, "/") + "/v1/uris:search",
+		interstitial: strings.TrimSuffix(interstitial, "/"),
+		client:       http.DefaultClient,
+	}
+}
+
+// checkBaseURL returns an error if base is not an http or https URL.
+func checkBaseURL(base string) error {
+	u, err := url.Parse(base)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("not an http or https URL: %q", base)
+	}
+	return nil
+}
+
+// serve answers the requests read from r on w until r ends or ctx is done.
+// Requests with a channel ID are looked up concurrently, up to concurrency
+// at a time, and the others one after the other.
+func (h *helper) serve(ctx context.Context, r io.Reader, w io.Writer, concurrency int) error {
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, concurrency)
+	)
+	answer := func(id, uri string) {
+		line := h.answer(ctx, id, uri)
+		mu.Lock()
+		defer mu.Unlock()
+		io.WriteString(w, line+"\n")
+	}
+	defer wg.Wait()
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxRequestSize)
+	for sc.Scan() && ctx.Err() == nil {
+		id, uri := parseRequest(sc.Text())
+		if id == "" {
+			answer(id, uri)
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			answer(id, uri)
+		}()
+	}
+	return sc.Err()
+}
+
+// parseRequest returns the channel ID, if any, and the URL of a request.
+func parseRequest(line string) (id, uri string) {
+	fields := strings.Fields(line)
+	if len(fields) > 1 && isChannelID(fields[0]) {
+		id, fields = fields[0], fields[1:]
+	}
+	if len(fields) > 0 {
+		uri = fields[0]
+	}
+	return id, uri
+}
+
+func isChannelID(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// answer returns the answer line to the request for uri on channel id.
+func (h *helper) answer(ctx context.Context, id, uri string) string {
+	var line string
+	if threats, err := h.lookup(ctx, uri); err != nil {
+		line = fmt.Sprintf(`BH message="%s"`, quoter.Replace(err.Error()))
+	} else if len(threats) == 0 {
+		line = "ERR"
+	} else if h.acl {
+		line = fmt.Sprintf(`OK message="%s"`, strings.Join(threats, ","))
+	} else {
+		line = fmt.Sprintf(`OK status=302 url="%s/r?url=%s"`, h.interstitial, url.QueryEscape(lookupURL(uri)))
+	}
+	if id != "" {
+		line = id + " " + line
+	}
+	return line
+}
+
+// lookupURL returns the URL that uri is looked up by. The host:port of a
+// CONNECT request is looked up as an https URL.
+func lookupURL(uri string) string {
+	if !strings.Contains(uri, "://") {
+		return "https://" + uri + "/"
+	}
+	return uri
+}
+
+// lookup returns the threat types of uri, according to the wrserver.
+func (h *helper) lookup(ctx context.Context, uri string) ([]string, error) {
+	if uri == "" {
+		return nil, fmt.Errorf("no URL in the request")
+	}
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	body, err := protojson.Marshal(&pb.SearchUrisRequest{Uri: lookupURL(uri)})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", h.search, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("wrserver: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	pbResp := new(pb.SearchUrisResponse)
+	if err := protojson.Unmarshal(body, pbResp); err != nil {
+		return nil, err
+	}
+	var threats []string
+	for _, tt := range pbResp.GetThreat().GetThreatTypes() {
+		threats = append(threats, tt.String())
+	}
+	return threats, nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Command wrsquid is a Squid helper that checks the URLs requested through
+// the proxy against a running wrserver.
+//
+// Squid starts the helper and writes a request per line to its STDIN: an
+// optional channel ID, when the helper is configured with concurrency, then
+// the URL, then any other fields, which are ignored. The helper answers each
+// request on STDOUT with the same channel ID, as soon as its lookup is done,
+// so several requests of a channel-ID-aware Squid are looked up at a time.
+// Without channel IDs, requests are answered in order.
+//
+// With -mode=rewrite, the default, the helper is a url_rewrite_program. It
+// answers ERR to leave safe URLs as they are, and redirects unsafe ones to
+// the interstitial of the wrserver at the -interstitial base URL, which
+// browsers must be able to reach:
+//
+//	OK status=302 url="http://wrserver.example.com/r?url=http%3A%2F%2Fevil.com%2F"
+//
+// With -mode=acl, the helper is an external_acl_type instead. It answers OK
+// for unsafe URLs, with their threat types as the message, and ERR for safe
+// ones, so that an http_access deny rule can refuse them.
+//
+// If a URL cannot be looked up, the helper answers BH, with the error as the
+// message, and Squid applies its own failure handling.
+//
+// To build the tool:
+//
+//	$ go build -o wrsquid ./cmd/wrsquid
+//
+// Example squid.conf:
+//
+//	url_rewrite_program /usr/local/bin/wrsquid -wrserver=http://localhost:8080 -interstitial=http://wrserver.example.com
+//	url_rewrite_children 5 concurrency=50
+//
+//	external_acl_type webrisk concurrency=50 %URI /usr/local/bin/wrsquid -mode=acl -wrserver=http://localhost:8080
+//	acl unsafe external webrisk
+//	http_access deny unsafe
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var (
+	wrserverFlag     = flag.String("wrserver", "http://localhost:8080", "base URL of the wrserver to look up URLs with, such as http://localhost:8080 or http://localhost:8080/t/acme for a tenant")
+	modeFlag         = flag.String("mode", "rewrite", "helper protocol to speak: rewrite for url_rewrite_program, or acl for external_acl_type")
+	interstitialFlag = flag.String("interstitial", "", "with -mode=rewrite, base URL of the wrserver as browsers reach it, whose /r interstitial unsafe URLs are redirected to (default -wrserver)")
+	concurrencyFlag  = flag.Int("concurrency", 64, "number of requests with channel IDs to look up at a time")
+	timeoutFlag      = flag.Duration("timeout", 0, "how long a lookup may take before the helper answers BH, if positive (e.g. 5s)")
+)
+
+const usage = `wrsquid: Squid helper that checks URLs with a wrserver.
+
+The helper reads Squid helper requests from STDIN, one per line, looks up
+their URLs with the -wrserver, and writes the answers to STDOUT. It exits when
+STDIN is closed.
+
+Usage: %s -wrserver=http://localhost:8080 -interstitial=http://wrserver.example.com
+       %s -mode=acl -wrserver=http://localhost:8080
+
+`
+
+func main() {
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, usage, os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	if err := flag.CommandLine.Parse(os.Args[1:]); err == flag.ErrHelp {
+		os.Exit(0)
+	} else if err != nil {
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// run answers the requests read from stdin until it is closed or ctx is
+// done, and returns the exit code.
+func run(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer) int {
+	var acl bool
+	switch *modeFlag {
+	case "rewrite":
+	case "acl":
+		acl = true
+	default:
+		fmt.Fprintln(stderr, "Invalid -mode: ", *modeFlag)
+		return 2
+	}
+	if err := checkBaseURL(*wrserverFlag); err != nil {
+		fmt.Fprintln(stderr, "Invalid -wrserver: ", err)
+		return 2
+	}
+	interstitial := *interstitialFlag
+	if interstitial == "" {
+		interstitial = *wrserverFlag
+	} else if err := checkBaseURL(interstitial); err != nil {
+		fmt.Fprintln(stderr, "Invalid -interstitial: ", err)
+		return 2
+	}
+	if *concurrencyFlag < 1 {
+		fmt.Fprintln(stderr, "Invalid -concurrency")
+		return 2
+	}
+	h := newHelper(acl, *wrserverFlag, interstitial)
+	h.timeout = *timeoutFlag
+	if err := h.serve(ctx, stdin, stdout, *concurrencyFlag); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
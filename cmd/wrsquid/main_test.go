@@ -0,0 +1,138 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// newFakeServer returns a wrserver that reports URLs containing "evil" as
+// malware, and fails to look up those containing "fail".
+func newFakeServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/uris:search" || r.Method != "POST" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+		}
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case bytes.Contains(body, []byte("fail")):
+			http.Error(w, "lookup failed", http.StatusInternalServerError)
+		case bytes.Contains(body, []byte("evil")):
+			io.WriteString(w, `{"threat":{"threatTypes":["MALWARE","SOCIAL_ENGINEERING"]}}`)
+		default:
+			io.WriteString(w, `{}`)
+		}
+	}))
+}
+
+func TestParseRequest(t *testing.T) {
+	vectors := []struct {
+		line    string
+		id, uri string
+	}{
+		{line: "http://a.com/", uri: "http://a.com/"},
+		{line: "0 http://a.com/ 192.0.2.1/- - GET myip=192.0.2.2 myport=3128", id: "0", uri: "http://a.com/"},
+		{line: "12 a.com:443", id: "12", uri: "a.com:443"},
+		{line: "http://a.com/ 192.0.2.1/- - GET", uri: "http://a.com/"},
+		{line: "3", uri: "3"},
+		{line: "", uri: ""},
+	}
+	for i, v := range vectors {
+		if id, uri := parseRequest(v.line); id != v.id || uri != v.uri {
+			t.Errorf("test %d, parseRequest(%q) = (%q, %q), want (%q, %q)", i, v.line, id, uri, v.id, v.uri)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	srv := newFakeServer(t)
+	defer srv.Close()
+
+	vectors := []struct {
+		acl    bool
+		input  string
+		output []string
+	}{{
+		// Without channel IDs, answers are in order.
+		input: "http://safe.com/ 192.0.2.1/- - GET\nhttp://evil.com/a?b=c 192.0.2.1/- - GET\n",
+		output: []string{
+			"ERR",
+			`OK status=302 url="http://wrserver.example.com/r?url=http%3A%2F%2Fevil.com%2Fa%3Fb%3Dc"`,
+		},
+	}, {
+		input: "0 http://safe.com/\n1 evil.com:443\n2 http://fail.com/\n\n",
+		output: []string{
+			"0 ERR",
+			`1 OK status=302 url="http://wrserver.example.com/r?url=https%3A%2F%2Fevil.com%3A443%2F"`,
+			`2 BH message="wrserver: 500 Internal Server Error: lookup failed"`,
+			`BH message="no URL in the request"`,
+		},
+	}, {
+		acl:   true,
+		input: "0 http://safe.com/\n1 http://evil.com/\n",
+		output: []string{
+			"0 ERR",
+			`1 OK message="MALWARE,SOCIAL_ENGINEERING"`,
+		},
+	}}
+	for i, v := range vectors {
+		h := newHelper(v.acl, srv.URL+"/", "http://wrserver.example.com/")
+		var out bytes.Buffer
+		if err := h.serve(context.Background(), strings.NewReader(v.input), &out, 4); err != nil {
+			t.Fatalf("test %d, unexpected error: %v", i, err)
+		}
+		got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		if strings.HasPrefix(v.input, "0 ") {
+			sort.Strings(got)
+		}
+		if strings.Join(got, "\n") != strings.Join(v.output, "\n") {
+			t.Errorf("test %d, output:\n%s\nwant:\n%s", i, strings.Join(got, "\n"), strings.Join(v.output, "\n"))
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	srv := newFakeServer(t)
+	defer srv.Close()
+	defer func(wrserver, mode, interstitial string) {
+		*wrserverFlag, *modeFlag, *interstitialFlag = wrserver, mode, interstitial
+	}(*wrserverFlag, *modeFlag, *interstitialFlag)
+
+	vectors := []struct {
+		wrserver, mode, interstitial string
+		code                         int
+		output                       string
+	}{
+		{wrserver: srv.URL, mode: "rewrite", code: 0, output: `OK status=302 url="` + srv.URL + `/r?url=http%3A%2F%2Fevil.com%2F"` + "\n"},
+		{wrserver: srv.URL, mode: "acl", code: 0, output: `OK message="MALWARE,SOCIAL_ENGINEERING"` + "\n"},
+		{wrserver: srv.URL, mode: "deny", code: 2},
+		{wrserver: "localhost:8080", mode: "rewrite", code: 2},
+		{wrserver: srv.URL, mode: "rewrite", interstitial: "/r", code: 2},
+	}
+	for i, v := range vectors {
+		*wrserverFlag, *modeFlag, *interstitialFlag = v.wrserver, v.mode, v.interstitial
+		var stdout, stderr bytes.Buffer
+		code := run(context.Background(), strings.NewReader("http://evil.com/\n"), &stdout, &stderr)
+		if code != v.code || stdout.String() != v.output {
+			t.Errorf("test %d, run() = %d with output %q, want %d with %q (stderr %q)", i, code, stdout.String(), v.code, v.output, stderr.String())
+		}
+	}
+}
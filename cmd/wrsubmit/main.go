@@ -0,0 +1,285 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command wrsubmit is a tool for submitting suspected phishing URLs to the
+// Web Risk Submission API.
+//
+// The submit command submits the URLs given as arguments, or read one per
+// line from the -input file or STDIN, and prints the name of the operation
+// that tracks the review of each submission. The status command prints the
+// state of the named operations. With -wait, both poll the operations until
+// their review is done.
+//
+// The Submission API does not accept API keys. The tool authenticates with
+// an OAuth 2.0 access token instead, given by -token or the ACCESSTOKEN
+// environment variable, of an account that is allowed to submit URLs for the
+// Google Cloud project given by -project.
+//
+// To build the tool:
+//
+//	$ go build -o wrsubmit ./cmd/wrsubmit
+//
+// Example usage:
+//
+//	$ export ACCESSTOKEN=$(gcloud auth print-access-token)
+//	$ wrsubmit -project my-project submit http://phish.example.com/login
+//	http://phish.example.com/login projects/my-project/operations/123 RUNNING
+//
+//	$ wrsubmit -project my-project -wait status projects/my-project/operations/123
+//	projects/my-project/operations/123 SUCCEEDED
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/webrisk"
+)
+
+var (
+	projectFlag    = flag.String("project", os.Getenv("GOOGLE_CLOUD_PROJECT"), "ID of the Google Cloud project to submit URLs for")
+	tokenFlag      = flag.String("token", os.Getenv("ACCESSTOKEN"), "OAuth 2.0 access token, such as from gcloud auth print-access-token")
+	serverURLFlag  = flag.String("server", webrisk.DefaultServerURL, "Web Risk API server address.")
+	inputFlag      = flag.String("input", "", "path to a file of URLs to submit, one per line, if none are given as arguments")
+	abuseTypeFlag  = flag.String("abuseType", "SOCIAL_ENGINEERING", "type of abuse of the URLs: SOCIAL_ENGINEERING, MALWARE, or UNWANTED_SOFTWARE")
+	confidenceFlag = flag.String("confidence", "", "confidence that the URLs are abusive: LOW, MEDIUM, or HIGH")
+	commentFlag    = flag.String("comment", "", "comment to justify the submissions, such as a ticket number")
+	waitFlag       = flag.Bool("wait", false, "poll the operations until their review is done")
+	pollFlag       = flag.Duration("poll", time.Minute, "how often to poll the operations with -wait")
+	jsonFlag       = flag.Bool("json", false, "print a JSON object per line instead of text")
+)
+
+const usage = `wrsubmit: command-line tool to submit URLs to the Web Risk Submission API.
+
+Commands:
+  submit [URL...]       submit the URLs, or those read from -input or STDIN
+  status OPERATION...   print the state of the operations of submissions
+
+Exit codes:
+  0  if all URLs were submitted, and with -wait, accepted.
+  1  if a submission or a request failed, or with -wait, was not accepted.
+  2  if the flags or command were invalid.
+
+Usage: %s -project=$PROJECT -token=$(gcloud auth print-access-token) command [args]
+
+`
+
+const (
+	codeOK = iota
+	codeFailed
+	codeUsage
+)
+
+// abuseTypes and confidenceLevels are the values accepted by the API.
+var (
+	abuseTypes       = []string{"SOCIAL_ENGINEERING", "MALWARE", "UNWANTED_SOFTWARE"}
+	confidenceLevels = []string{"LOW", "MEDIUM", "HIGH"}
+)
+
+func main() {
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	if err := flag.CommandLine.Parse(os.Args[1:]); err == flag.ErrHelp {
+		os.Exit(codeOK)
+	} else if err != nil {
+		os.Exit(codeUsage)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, flag.Args(), os.Stdin, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+// result is the outcome of a submission, or of a status request.
+type result struct {
+	URL       string `json:"url,omitempty"`
+	Operation string `json:"operation,omitempty"`
+	State     string `json:"state,omitempty"`
+	Done      bool   `json:"done"`
+	Error     string `json:"error,omitempty"`
+}
+
+// run runs the command given by args, and returns the exit code.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "No command specified")
+		return codeUsage
+	}
+	if *projectFlag == "" {
+		fmt.Fprintln(stderr, "No -project specified")
+		return codeUsage
+	}
+	if *tokenFlag == "" {
+		fmt.Fprintln(stderr, "No -token specified")
+		return codeUsage
+	}
+	if *pollFlag <= 0 {
+		fmt.Fprintln(stderr, "Invalid -poll")
+		return codeUsage
+	}
+	c, err := newSubmitClient(*serverURLFlag, *projectFlag, *tokenFlag)
+	if err != nil {
+		fmt.Fprintln(stderr, "Invalid -server: ", err)
+		return codeUsage
+	}
+
+	// Each item is either a URL to submit or the name of an operation.
+	cmd, items := args[0], args[1:]
+	var fetch func(item string) (*operation, error)
+	switch cmd {
+	case "submit":
+		ti, err := newThreatInfo(*abuseTypeFlag, *confidenceFlag, *commentFlag)
+		if err != nil {
+			fmt.Fprintln(stderr, "Invalid flags:", err)
+			return codeUsage
+		}
+		if len(items) == 0 {
+			in := stdin
+			if *inputFlag != "" && *inputFlag != "-" {
+				f, err := os.Open(*inputFlag)
+				if err != nil {
+					fmt.Fprintln(stderr, "Invalid -input: ", err)
+					return codeUsage
+				}
+				defer f.Close()
+				in = f
+			}
+			if items, err = readURLs(in); err != nil {
+				fmt.Fprintln(stderr, "Unable to read input:", err)
+				return codeFailed
+			}
+		}
+		fetch = func(url string) (*operation, error) { return c.submit(ctx, url, ti) }
+	case "status":
+		if len(items) == 0 {
+			fmt.Fprintln(stderr, "No operations specified")
+			return codeUsage
+		}
+		fetch = func(name string) (*operation, error) { return c.get(ctx, name) }
+	default:
+		fmt.Fprintln(stderr, "Unknown command:", cmd)
+		return codeUsage
+	}
+
+	code := codeOK
+	enc := json.NewEncoder(stdout)
+	for _, item := range items {
+		if ctx.Err() != nil {
+			break
+		}
+		op, err := fetch(item)
+		if err == nil && *waitFlag {
+			op, err = c.wait(ctx, op, *pollFlag)
+		}
+		var r result
+		if cmd == "submit" {
+			r.URL = item
+		}
+		if op != nil {
+			r.Operation, r.State, r.Done = op.Name, op.state(), op.Done
+		}
+		if r.Operation == "" && cmd == "status" {
+			r.Operation = item
+		}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		if err != nil || (*waitFlag && !op.succeeded()) {
+			code = codeFailed
+		}
+		if *jsonFlag {
+			err = enc.Encode(r)
+		} else {
+			err = writeResult(stdout, stderr, r)
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, "Unable to write output:", err)
+			return codeFailed
+		}
+	}
+	return code
+}
+
+// newThreatInfo returns the threat info to submit URLs with, as given by the
+// flags.
+func newThreatInfo(abuseType, confidence, comment string) (*threatInfo, error) {
+	ti := &threatInfo{AbuseType: strings.ToUpper(abuseType)}
+	if !contains(abuseTypes, ti.AbuseType) {
+		return nil, fmt.Errorf("unknown abuse type %q", abuseType)
+	}
+	if confidence != "" {
+		level := strings.ToUpper(confidence)
+		if !contains(confidenceLevels, level) {
+			return nil, fmt.Errorf("unknown confidence level %q", confidence)
+		}
+		ti.ThreatConfidence = &threatConfidence{Level: level}
+	}
+	if comment != "" {
+		ti.ThreatJustification = &threatJustification{
+			Labels:   []string{"MANUAL_VERIFICATION"},
+			Comments: []string{comment},
+		}
+	}
+	return ti, nil
+}
+
+func contains(values []string, v string) bool {
+	for _, w := range values {
+		if w == v {
+			return true
+		}
+	}
+	return false
+}
+
+// readURLs reads a list of URLs, one per line. Blank lines and lines starting
+// with "#" are ignored.
+func readURLs(r io.Reader) ([]string, error) {
+	var urls []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, sc.Err()
+}
+
+// writeResult writes r as a line of text to w, or to errw if it failed.
+func writeResult(w, errw io.Writer, r result) error {
+	var fields []string
+	if r.URL != "" {
+		fields = append(fields, r.URL)
+	}
+	if r.Error != "" {
+		fmt.Fprintln(errw, strings.Join(append(fields, "error:", r.Error), " "))
+		return nil
+	}
+	_, err := fmt.Fprintln(w, strings.Join(append(fields, r.Operation, r.State), " "))
+	return err
+}
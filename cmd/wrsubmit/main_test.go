@@ -0,0 +1,153 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSubmissionAPI is a Submission API for the project p. The operation of a
+// URL submitted is done after it is polled once, and is accepted unless the
+// URL contains "safe".
+type fakeSubmissionAPI struct {
+	mu        sync.Mutex
+	submitted []submitURIRequest
+	urls      map[string]string // URL submitted, by operation name
+}
+
+func (f *fakeSubmissionAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"code":401,"message":"invalid credentials"}}`))
+		return
+	}
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/v1/projects/p/uris:submit":
+		var req submitURIRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.submitted = append(f.submitted, req)
+		name := "projects/p/operations/" + string(rune('0'+len(f.submitted)))
+		f.urls[name] = req.Submission.URI
+		json.NewEncoder(w).Encode(operation{Name: name, Metadata: operationMetadata{State: "RUNNING"}})
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/v1/projects/p/operations/"):
+		name := strings.TrimPrefix(r.URL.Path, "/v1/")
+		url, ok := f.urls[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":404,"message":"operation not found"}}`))
+			return
+		}
+		op := operation{Name: name, Done: true, Metadata: operationMetadata{State: "SUCCEEDED"}}
+		if strings.Contains(url, "safe") {
+			op.Metadata.State = "CLOSED"
+			op.Error = &operationError{Code: 9, Message: "not a threat"}
+		}
+		json.NewEncoder(w).Encode(op)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRun(t *testing.T) {
+	api := &fakeSubmissionAPI{urls: make(map[string]string)}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	vectors := []struct {
+		token      string
+		wait, json bool
+		confidence string
+		args       []string
+		stdin      string
+		code       int
+		output     string
+		err        string // Substring expected in STDERR
+	}{{
+		token:      "token",
+		confidence: "high",
+		args:       []string{"submit", "http://phish.com/"},
+		code:       codeOK,
+		output:     "http://phish.com/ projects/p/operations/1 RUNNING\n",
+	}, {
+		token:  "token",
+		wait:   true,
+		args:   []string{"submit"},
+		stdin:  "# From the abuse desk\nhttp://phish.com/a\n\nhttp://safe.com/\n",
+		code:   codeFailed,
+		output: "http://phish.com/a projects/p/operations/2 SUCCEEDED\nhttp://safe.com/ projects/p/operations/3 CLOSED: not a threat\n",
+	}, {
+		token:  "token",
+		json:   true,
+		args:   []string{"status", "projects/p/operations/1", "projects/p/operations/9"},
+		code:   codeFailed,
+		output: `{"operation":"projects/p/operations/1","state":"SUCCEEDED","done":true}` + "\n" + `{"operation":"projects/p/operations/9","done":false,"error":"webrisk: 404 Not Found: operation not found"}` + "\n",
+	}, {
+		token: "wrong",
+		args:  []string{"submit", "http://phish.com/"},
+		code:  codeFailed,
+		err:   "http://phish.com/ error: webrisk: 401 Unauthorized: invalid credentials",
+	}, {
+		token:      "token",
+		confidence: "certain",
+		args:       []string{"submit", "http://phish.com/"},
+		code:       codeUsage,
+		err:        "unknown confidence level",
+	}, {
+		token: "token",
+		args:  []string{"status", "../admin"},
+		code:  codeFailed,
+		err:   "invalid operation name",
+	}, {
+		args: []string{"submit", "http://phish.com/"},
+		code: codeUsage,
+		err:  "No -token",
+	}}
+	for i, v := range vectors {
+		*serverURLFlag, *projectFlag, *tokenFlag = srv.URL, "p", v.token
+		*waitFlag, *jsonFlag, *confidenceFlag, *pollFlag = v.wait, v.json, v.confidence, time.Millisecond
+		var stdout, stderr bytes.Buffer
+		code := run(context.Background(), v.args, strings.NewReader(v.stdin), &stdout, &stderr)
+		if code != v.code {
+			t.Errorf("test %d, exit code = %d, want %d (stderr %q)", i, code, v.code, stderr.String())
+		}
+		if stdout.String() != v.output {
+			t.Errorf("test %d, output = %q, want %q", i, stdout.String(), v.output)
+		}
+		if !strings.Contains(stderr.String(), v.err) {
+			t.Errorf("test %d, stderr = %q, want it to contain %q", i, stderr.String(), v.err)
+		}
+	}
+
+	want := threatInfo{AbuseType: "SOCIAL_ENGINEERING", ThreatConfidence: &threatConfidence{Level: "HIGH"}}
+	if got := api.submitted[0].ThreatInfo; got == nil || got.AbuseType != want.AbuseType ||
+		got.ThreatConfidence == nil || *got.ThreatConfidence != *want.ThreatConfidence {
+		t.Errorf("threat info = %+v, want %+v", got, want)
+	}
+	if len(api.submitted) != 3 {
+		t.Errorf("submitted %d URLs, want 3", len(api.submitted))
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Submission API request and response messages. Only the fields used by the
+// tool are declared.
+type (
+	submitURIRequest struct {
+		Submission submission  `json:"submission"`
+		ThreatInfo *threatInfo `json:"threatInfo,omitempty"`
+	}
+
+	submission struct {
+		URI string `json:"uri"`
+	}
+
+	threatInfo struct {
+		AbuseType           string               `json:"abuseType,omitempty"`
+		ThreatConfidence    *threatConfidence    `json:"threatConfidence,omitempty"`
+		ThreatJustification *threatJustification `json:"threatJustification,omitempty"`
+	}
+
+	threatConfidence struct {
+		Level string `json:"level"`
+	}
+
+	threatJustification struct {
+		Labels   []string `json:"labels,omitempty"`
+		Comments []string `json:"comments,omitempty"`
+	}
+
+	// operation is a long-running operation of the API, as returned for
+	// a submission.
+	operation struct {
+		Name     string            `json:"name"`
+		Done     bool              `json:"done"`
+		Metadata operationMetadata `json:"metadata"`
+		Error    *operationError   `json:"error,omitempty"`
+	}
+
+	operationMetadata struct {
+		State      string `json:"state"`
+		CreateTime string `json:"createTime,omitempty"`
+		UpdateTime string `json:"updateTime,omitempty"`
+	}
+
+	operationError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+)
+
+// succeeded reports whether the operation is done without failing.
+func (op *operation) succeeded() bool {
+	switch {
+	case !op.Done:
+		return false
+	case op.Error != nil:
+		return false
+	}
+	switch op.Metadata.State {
+	case "CANCELLED", "FAILED":
+		return false
+	}
+	return true
+}
+
+// state returns a short description of the state of the operation.
+func (op *operation) state() string {
+	s := op.Metadata.State
+	if s == "" {
+		s = "STATE_UNSPECIFIED"
+	}
+	if op.Error != nil {
+		s += ": " + op.Error.Message
+	}
+	return s
+}
+
+// submitClient calls the Submission API of a project.
+type submitClient struct {
+	base    string // Base URL of the API, such as https://webrisk.googleapis.com
+	project string
+	token   string // OAuth 2.0 access token
+	client  *http.Client
+}
+
+// newSubmitClient returns a submitClient for the project, which calls the API
+// at server with the access token. If the scheme of server is not specified,
+// HTTPS is used.
+func newSubmitClient(server, project, token string) (*submitClient, error) {
+	if !strings.Contains(server, "://") {
+		server = "https://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host: %q", server)
+	}
+	return &submitClient{
+		base:    strings.TrimSuffix(server, "/"),
+		project: project,
+		token:   token,
+		client:  &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+// submit submits uri as a suspected threat, and returns the operation that
+// tracks the review of the submission.
+func (c *submitClient) submit(ctx context.Context, uri string, ti *threatInfo) (*operation, error) {
+	body, err := json.Marshal(submitURIRequest{Submission: submission{URI: uri}, ThreatInfo: ti})
+	if err != nil {
+		return nil, err
+	}
+	op := new(operation)
+	path := "/v1/projects/" + url.PathEscape(c.project) + "/uris:submit"
+	if err := c.call(ctx, "POST", path, bytes.NewReader(body), op); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// get returns the current state of the operation with the given name, such
+// as projects/my-project/operations/123.
+func (c *submitClient) get(ctx context.Context, name string) (*operation, error) {
+	if !strings.HasPrefix(name, "projects/") || strings.Contains(name, "..") {
+		return nil, fmt.Errorf("invalid operation name: %q", name)
+	}
+	op := new(operation)
+	if err := c.call(ctx, "GET", "/v1/"+name, nil, op); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// wait polls the operation every interval until it is done, or until ctx is
+// done, and returns its last known state.
+func (c *submitClient) wait(ctx context.Context, op *operation, interval time.Duration) (*operation, error) {
+	for !op.Done {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return op, ctx.Err()
+		}
+		next, err := c.get(ctx, op.Name)
+		if err != nil {
+			return op, err
+		}
+		op = next
+	}
+	return op, nil
+}
+
+// call sends a request to the API at path, and decodes its JSON response
+// into v.
+func (c *submitClient) call(ctx context.Context, method, path string, body io.Reader, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Errors are reported as {"error": {"code": ..., "message": ...}}.
+		var e struct {
+			Error operationError `json:"error"`
+		}
+		if json.Unmarshal(b, &e) == nil && e.Error.Message != "" {
+			return fmt.Errorf("webrisk: %s: %s", resp.Status, e.Error.Message)
+		}
+		return fmt.Errorf("webrisk: %s", resp.Status)
+	}
+	return json.Unmarshal(b, v)
+}
@@ -19,10 +19,13 @@ import (
 	"context"
 	"encoding/gob"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"math/rand"
-	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	pb "github.com/google/webrisk/internal/webrisk_proto"
@@ -37,6 +40,21 @@ const (
 	jitter         = 30 * time.Second
 )
 
+// The API requires database size constraints to be a power of 2 within
+// these bounds.
+const (
+	minDatabaseEntries = 1 << 10
+	maxDatabaseEntries = 1 << 20
+)
+
+// checkpointPeriod is how often the progress of a sync is saved while it
+// goes on, on top of when it fails.
+const checkpointPeriod = time.Minute
+
+// databaseMemoryShare is the percentage of Config.MemoryLimit that the
+// database may use. The remainder is left for the cache.
+const databaseMemoryShare = 75
+
 // database tracks the state of the threat lists published by the Webrisk API.
 // Since the global blocklist is constantly changing, the contents of the
 // database needs to be periodically synced with the Webrisk servers in
@@ -45,22 +63,33 @@ const (
 // The process for updating the database is as follows:
 //   - At startup, if a database file is provided, then load it. If loaded
 //     properly (not corrupted and not stale), then set tfu as the contents.
-//     Otherwise, pull a new threat list from the Web Risk API.
+//     Otherwise, pull a new threat list from the Web Risk API, resuming from
+//     the version tokens of a stale database if there is one.
 //   - Periodically, synchronize the database with the Web Risk API.
 //     This uses the Version Token fields to update only parts of the threat list that have
-//     changed since the last sync.
-//   - Anytime tfu is updated, generate a new tfl.
+//     changed since the last sync. Progress is saved when a sync fails
+//     part way, and every checkpointPeriod during a long one, so that an
+//     interrupted sync resumes close to where it left off.
+//   - Anytime tfu is updated, generate a new lookupTable and atomically
+//     replace the current one.
 //
 // The process for querying the database is as follows:
-//   - Check if the requested full hash matches any partial hash in tfl.
+//   - Load the current lookupTable. This never blocks, so that concurrent
+//     lookups do not contend with each other or with updates.
+//   - Check if the leading bytes of the requested full hash are in the filter.
+//     If not, then no partial hash in the table can possibly match.
+//   - Check if the requested full hash matches any partial hash in the table.
 //     If a match is found, return a set of ThreatTypes with a partial match.
 type database struct {
-	ml sync.RWMutex // Protects tfl, err, and last
-	// threatsForLookup maps ThreatTypes to sets of partial hashes.
-	// This data structure is in a format that is easily queried.
-	tfl  threatsForLookup
-	err  error     // Last error encountered
-	last time.Time // Last time the threat list were synced
+	// table is the current immutable snapshot of the threat lists in a
+	// format that is easily queried. It is nil if the database is in an
+	// error state that cleared its contents.
+	table atomic.Pointer[lookupTable]
+
+	ml    sync.RWMutex             // Protects err, last, and lists
+	err   error                    // Last error encountered
+	last  time.Time                // Last time the threat list were synced
+	lists map[ThreatType]ListStats // Statistics for each threat list
 
 	config *Config
 	// threatsForUpdate maps ThreatTypes to lists of partial hashes.
@@ -71,6 +100,22 @@ type database struct {
 
 	readyCh         chan struct{} // Used for waiting until not in an error state.
 	updateAPIErrors uint          // Number of times we attempted to contact the api and failed
+	updateErr       error         // API error of the last Update, if the database was kept
+
+	// maxEntries is the maximum number of entries per threat list requested
+	// from the API. Zero means that no limit is requested.
+	// It is protected by mu.
+	maxEntries int32
+
+	// version identifies the database last read from a store that
+	// implements versionedStore, so that Reload can skip reading a database
+	// that has not changed. It is protected by mu.
+	version string
+
+	// snap is the last snapshot of the database, made of table snapTable.
+	// They are protected by mu.
+	snap      *DatabaseSnapshot
+	snapTable *lookupTable
 
 	log *log.Logger
 }
@@ -89,6 +134,57 @@ type partialHashes struct {
 
 type threatsForLookup map[ThreatType]hashSet
 
+// lookupTable is an immutable snapshot of the threat lists used to serve
+// lookups. A new lookupTable is built on every update and swapped in
+// atomically, so readers never need to acquire a lock.
+type lookupTable struct {
+	// tfl maps ThreatTypes to sets of partial hashes.
+	tfl threatsForLookup
+	// filter is a Bloom filter over all partial hashes in tfl, used to
+	// quickly rule out full hashes that cannot match any threat list.
+	filter *prefixFilter
+
+	n    int   // Total number of partial hashes in tfl
+	size int64 // Approximate memory usage in bytes
+}
+
+// newLookupTable builds a lookupTable over tfl. The caller must not modify
+// tfl afterwards.
+func newLookupTable(tfl threatsForLookup) *lookupTable {
+	t := &lookupTable{tfl: tfl, filter: newPrefixFilter(tfl)}
+	for _, hs := range tfl {
+		t.n += hs.Len()
+		t.size += hs.MemoryUsage()
+	}
+	t.size += t.filter.MemoryUsage()
+	return t
+}
+
+// MemoryUsage reports the approximate number of bytes used by the table.
+// It is safe to call on a nil lookupTable.
+func (t *lookupTable) MemoryUsage() int64 {
+	if t == nil {
+		return 0
+	}
+	return t.size
+}
+
+// Lookup looks up the full hash in the table and returns a partial hash and
+// a set of ThreatTypes that may match the full hash. It is safe to call on a
+// nil lookupTable.
+func (t *lookupTable) Lookup(hash hashPrefix) (h hashPrefix, tds []ThreatType) {
+	if t == nil || !t.filter.MayContain(hash) {
+		return h, tds
+	}
+	for td, hs := range t.tfl {
+		if n := hs.Lookup(hash); n > 0 {
+			h = hash[:n]
+			tds = append(tds, td)
+		}
+	}
+	return h, tds
+}
+
 // databaseFormat is a light struct used only for gob encoding and decoding.
 // As written to disk, the format of the database file is basically the gzip
 // compressed version of the gob encoding of databaseFormat.
@@ -97,7 +193,8 @@ type databaseFormat struct {
 	Time  time.Time
 }
 
-// Init initializes the database from the specified file in config.DBPath.
+// Init initializes the database from config.Store, or from the file
+// specified in config.DBPath if no Store is set.
 // It reports true if the database was successfully loaded. If it reports false
 // use Status for more details on the failure.
 func (db *database) Init(config *Config, logger *log.Logger) bool {
@@ -106,20 +203,36 @@ func (db *database) Init(config *Config, logger *log.Logger) bool {
 	db.setError(errors.New("not intialized"))
 	db.config = config
 	db.log = logger
-	if db.config.DBPath == "" {
+	store := db.config.store()
+	if store == nil {
 		db.log.Printf("no database file specified")
 		db.setError(errors.New("no database loaded"))
 		return false
 	}
-	dbf, err := loadDatabase(db.config.DBPath)
+	ctx, cancel := db.storeContext()
+	db.version = storeVersion(ctx, store)
+	dbf, err := restoreDatabase(ctx, store)
+	cancel()
 	if err != nil {
 		db.log.Printf("load failure: %v", err)
 		db.setError(err)
 		return false
 	}
 	// Validate that the database threat list stored on disk is not too stale.
-	if db.isStale(dbf.Time) {
-		db.log.Printf("database loaded is stale")
+	// A stale database cannot serve lookups, but the version tokens of its
+	// threat lists are kept so that the next Update only fetches what has
+	// changed since, instead of downloading every list in full.
+	// A read-only database, or one that is served while stale, is loaded
+	// as is, and reported as stale by Status.
+	if db.isStale(dbf.Time) && !db.config.ReadOnly && db.config.StalePolicy != StaleServe {
+		db.log.Printf("database loaded is stale, resuming from saved version tokens")
+		db.tfu = make(threatsForUpdate)
+		for _, td := range db.config.ThreatLists {
+			if row, ok := dbf.Table[td]; ok {
+				db.tfu[td] = row
+			}
+		}
+		db.dropUnsubscribed(dbf)
 		db.ml.Lock()
 		defer db.ml.Unlock()
 		db.setStale()
@@ -138,25 +251,94 @@ func (db *database) Init(config *Config, logger *log.Logger) bool {
 		}
 	}
 	db.tfu = tfuNew
+	db.dropUnsubscribed(dbf)
 	db.generateThreatsForLookups(dbf.Time)
 	return true
 }
 
+// Reload loads the database from the store if it was saved after the
+// current contents were synced, and reports whether it did. It is used in
+// read-only mode to pick up databases delivered out-of-band.
+//
+// If the store can report its version, the database is only read when the
+// version changed, which makes frequent polling cheap. The new contents
+// replace the old ones atomically, so lookups never see a partial reload.
+func (db *database) Reload() (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	store := db.config.store()
+	if store == nil {
+		return false, errors.New("webrisk: no database to reload")
+	}
+	ctx, cancel := db.storeContext()
+	defer cancel()
+	version := storeVersion(ctx, store)
+	if version != "" && version == db.version {
+		return false, nil
+	}
+	dbf, err := restoreDatabase(ctx, store)
+	if err != nil {
+		return false, err
+	}
+	db.version = version
+	db.ml.RLock()
+	last := db.last
+	db.ml.RUnlock()
+	if !dbf.Time.After(last) {
+		return false, nil
+	}
+	tfuNew := make(threatsForUpdate)
+	for _, td := range db.config.ThreatLists {
+		row, ok := dbf.Table[td]
+		if !ok {
+			return false, fmt.Errorf("webrisk: database configuration mismatch, missing %v", td)
+		}
+		tfuNew[td] = row
+	}
+	db.tfu = tfuNew
+	db.generateThreatsForLookups(dbf.Time)
+	return true, nil
+}
+
+// dropUnsubscribed removes threat lists that are no longer configured from
+// the stored database, so that their data is not carried along forever.
+// The loaded database is dbf, and db.tfu must only contain configured lists.
+//
+// This assumes that the db.mu lock is already held.
+func (db *database) dropUnsubscribed(dbf databaseFormat) {
+	var dropped []ThreatType
+	for td := range dbf.Table {
+		if _, ok := db.tfu[td]; !ok {
+			dropped = append(dropped, td)
+		}
+	}
+	if len(dropped) == 0 || db.config.ReadOnly {
+		return
+	}
+	sort.Slice(dropped, func(i, j int) bool { return dropped[i] < dropped[j] })
+	db.log.Printf("dropping unsubscribed threat lists: %v", dropped)
+	db.save(db.snapshot(dbf.Time))
+}
+
 // Status reports the health of the database. The database is considered faulted
 // if there was an error during update or if the last update has gone stale. If
 // in a faulted state, the db may repair itself on the next Update.
 func (db *database) Status() error {
 	db.ml.RLock()
-	defer db.ml.RUnlock()
-
-	if db.err != nil {
-		return db.err
+	err, stale := db.err, db.err == nil && db.isStale(db.last)
+	db.ml.RUnlock()
+	if !stale {
+		return err
 	}
-	if db.isStale(db.last) {
+
+	// Going stale changes the state of the database, which needs the write
+	// lock. It may have been updated, or found stale, in the meantime.
+	db.ml.Lock()
+	defer db.ml.Unlock()
+	if db.err == nil && db.isStale(db.last) {
 		db.setStale()
-		return db.err
 	}
-	return nil
+	return db.err
 }
 
 // UpdateLag reports the amount of time in between when we expected to run
@@ -177,6 +359,24 @@ func (db *database) SinceLastUpdate() time.Duration {
 	return db.config.now().Sub(db.last)
 }
 
+// LastUpdate returns when the threat lists were last synced.
+func (db *database) LastUpdate() time.Time {
+	db.ml.RLock()
+	defer db.ml.RUnlock()
+	return db.last
+}
+
+// Age returns the time since the threat lists were last synced, or zero if
+// they never were.
+func (db *database) Age() time.Duration {
+	db.ml.RLock()
+	defer db.ml.RUnlock()
+	if db.last.IsZero() {
+		return 0
+	}
+	return db.config.now().Sub(db.last)
+}
+
 // Ready returns a channel that's closed when the database is ready for queries.
 func (db *database) Ready() <-chan struct{} {
 	return db.readyCh
@@ -198,24 +398,34 @@ func (db *database) Update(ctx context.Context, api api) (time.Duration, bool) {
 		}
 
 		s = append(s, &pb.ComputeThreatListDiffRequest{
-			ThreatType: pb.ThreatType(td),
-			Constraints: &pb.ComputeThreatListDiffRequest_Constraints{
-				SupportedCompressions: db.config.compressionTypes},
+			ThreatType:   pb.ThreatType(td),
+			Constraints:  db.constraints(),
 			VersionToken: state,
 		})
 	}
 
-	var resps []*pb.ComputeThreatListDiffResponse
+	db.updateErr = nil
 
 	// add jitter to wait time to avoid all servers lining up
 	nextUpdateWait := db.config.UpdatePeriod + time.Duration(rand.Int31n(60)-30)*time.Second
 	last := db.config.now()
-	for _, req := range s {
+	checkpointed, pending := last, false
+	db.generateThreatsForUpdate()
+	for i, req := range s {
 		// Query the API for the threat list and update the database.
-		resp, err := api.ListUpdate(ctx, req.ThreatType, req.VersionToken, req.Constraints.SupportedCompressions)
+		start := db.config.now()
+		resp, err := api.ListUpdate(ctx, req.ThreatType, req.VersionToken, req.Constraints)
 		if err != nil {
 			db.log.Printf("ListUpdate failure (%d): %v", db.updateAPIErrors+1, err)
-			db.setError(err)
+			// The next attempt resumes from the lists updated so far.
+			if pending {
+				db.checkpoint()
+			}
+			if db.keepOnFailure() {
+				db.updateErr = err
+			} else {
+				db.setError(err)
+			}
 			// backoff strategy: MIN((2**N-1 * 15 minutes) * (RAND + 1), 24 hours)
 			n := 1 << db.updateAPIErrors
 			delay := time.Duration(float64(n) * (rand.Float64() + 1) * float64(baseRetryDelay))
@@ -225,53 +435,269 @@ func (db *database) Update(ctx context.Context, api api) (time.Duration, bool) {
 			db.updateAPIErrors++
 			return delay, false
 		}
-		resps = append(resps, resp)
 		if resp.RecommendedNextDiff != nil {
 			ndiff := resp.RecommendedNextDiff.AsTime()
-			serverMinWait := time.Duration(ndiff.Sub(time.Now()))
+			serverMinWait := time.Duration(ndiff.Sub(db.config.now()))
 			if serverMinWait > nextUpdateWait {
 				nextUpdateWait = serverMinWait
 				db.log.Printf("Server requested next update in %v", nextUpdateWait)
 			}
 		}
-	}
 
-	// If for some reason we missed a request or didn't get a response the
-	// rest of the logic may fail.
-	if len(s) != len(resps) {
-		db.setError(errors.New("mismatch between requests sent and responses received"))
-		return nextUpdateWait, false
-	}
-
-	db.updateAPIErrors = 0
-	// Update the threat database with the response.
-	db.generateThreatsForUpdate()
-	for i, resp := range resps {
-		// Assume a 1:1 correspondence between request and response
-		if err := db.tfu.update(resp, ThreatType(s[i].ThreatType)); err != nil {
+		// Update the threat database with the response.
+		td := ThreatType(req.ThreatType)
+		oldVersion := db.tfu[td].State
+		added, removed, err := db.tfu.update(resp, td)
+		db.recordListUpdate(td, resp, added, removed, db.config.now().Sub(start), err)
+		db.audit(td, oldVersion, resp, added, removed, err)
+		if err != nil {
 			db.setError(err)
 			db.log.Printf("update failure: %v", err)
 			db.tfu = nil
 			return nextUpdateWait, false
 		}
+
+		// Saving the whole database after every list would be costly, so
+		// the progress of a long sync is only saved from time to time.
+		pending = true
+		if now := db.config.now(); i < len(s)-1 && now.Sub(checkpointed) >= checkpointPeriod {
+			db.checkpoint()
+			checkpointed, pending = now, false
+		}
+	}
+	db.updateAPIErrors = 0
+
+	dbf := db.snapshot(last)
+	db.generateThreatsForLookups(last)
+	db.renegotiateConstraints()
+
+	// Regenerate the database and store it.
+	db.save(dbf)
+	return nextUpdateWait, true
+}
+
+// UpdateError returns the error that the last Update failed with, if it kept
+// the database instead of clearing it. See keepOnFailure.
+func (db *database) UpdateError() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.updateErr
+}
+
+// keepOnFailure reports whether an Update that fails to reach the API keeps
+// the database, so that lookups are served from it until it goes stale,
+// instead of failing right away. This is the case when the age that the
+// database may reach is configured, or when it is served even once stale.
+func (db *database) keepOnFailure() bool {
+	return db.config.MaxDatabaseAge > 0 || db.config.StalePolicy == StaleServe
+}
+
+// Export writes the current contents of the database to w in the stored
+// format. See ExportDatabase.
+func (db *database) Export(w io.Writer) error {
+	db.mu.Lock()
+	t := db.table.Load()
+	if t == nil {
+		db.mu.Unlock()
+		return errors.New("webrisk: no database to export")
+	}
+	db.ml.RLock()
+	dbf := databaseFormat{make(threatsForUpdate), db.last}
+	db.ml.RUnlock()
+	for td, hs := range t.tfl {
+		phs := db.tfu[td]
+		phs.Hashes = hs.Export()
+		dbf.Table[td] = phs
+	}
+	db.mu.Unlock()
+	return encodeDatabase(w, dbf)
+}
+
+// Snapshot returns a copy of the threat lists of the database. It is kept
+// until the database changes, so that it is only made once per update.
+func (db *database) Snapshot() (*DatabaseSnapshot, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t := db.table.Load()
+	if t == nil {
+		return nil, errors.New("webrisk: no database loaded")
+	}
+	if db.snap != nil && db.snapTable == t {
+		return db.snap, nil
+	}
+	db.ml.RLock()
+	snap := &DatabaseSnapshot{Time: db.last, Lists: make(map[ThreatType]*ListSnapshot)}
+	db.ml.RUnlock()
+	for td, hs := range t.tfl {
+		phs := db.tfu[td]
+		hashes := hs.Export()
+		ls := &ListSnapshot{
+			Prefixes:     make([]string, len(hashes)),
+			SHA256:       phs.SHA256,
+			VersionToken: phs.State,
+		}
+		for i, h := range hashes {
+			ls.Prefixes[i] = string(h)
+		}
+		snap.Lists[td] = ls
 	}
+	db.snap, db.snapTable = snap, t
+	return snap, nil
+}
+
+// constraints returns the constraints of the requests for the threat lists.
+//
+// This assumes that the db.mu lock is already held.
+func (db *database) constraints() *pb.ComputeThreatListDiffRequest_Constraints {
+	return &pb.ComputeThreatListDiffRequest_Constraints{
+		SupportedCompressions: db.config.compressionTypes,
+		MaxDatabaseEntries:    db.maxEntries,
+	}
+}
+
+// Verify checks every threat list in the database against the version that
+// the API currently expects. See VerifyDatabase.
+func (db *database) Verify(ctx context.Context, api api) ([]ListVerification, error) {
+	db.mu.Lock()
+	t := db.table.Load()
+	if t == nil {
+		db.mu.Unlock()
+		return nil, errors.New("webrisk: no database loaded")
+	}
+	lists := make(map[ThreatType]partialHashes)
+	for td, hs := range t.tfl {
+		phs := db.tfu[td]
+		phs.Hashes = hs.Export()
+		phs.Hashes.Sort()
+		lists[td] = phs
+	}
+	// The lists are verified under the constraints that they were updated
+	// under, or the API would reset lists limited to fewer entries.
+	constraints := db.constraints()
+	db.mu.Unlock()
 
+	tds := make([]ThreatType, 0, len(lists))
+	for td := range lists {
+		tds = append(tds, td)
+	}
+	sort.Slice(tds, func(i, j int) bool { return tds[i] < tds[j] })
+	var vs []ListVerification
+	for _, td := range tds {
+		vs = append(vs, verifyList(ctx, api, constraints, td, lists[td]))
+	}
+	return vs, nil
+}
+
+// recordListUpdate records the outcome of applying resp to the threat list td,
+// which took the given duration to request and apply.
+func (db *database) recordListUpdate(td ThreatType, resp *pb.ComputeThreatListDiffResponse, added, removed int, took time.Duration, err error) {
+	db.ml.Lock()
+	defer db.ml.Unlock()
+	if db.lists == nil {
+		db.lists = make(map[ThreatType]ListStats)
+	}
+	ls := db.lists[td]
+	switch {
+	case errors.Is(err, errChecksumMismatch):
+		ls.ChecksumOK = false
+		ls.ChecksumFailures++
+	case err != nil:
+		// The update was rejected before its checksum could be verified.
+	default:
+		ls.ChecksumOK = true
+		ls.LastUpdate = db.config.now()
+		ls.LastUpdateType = resp.ResponseType.String()
+		ls.LastAdded, ls.LastRemoved = added, removed
+		ls.LastUpdateDuration = took
+	}
+	db.lists[td] = ls
+}
+
+// audit passes the outcome of applying resp to the threat list td to the
+// configured Config.Audit function, if any.
+//
+// This assumes that the db.mu lock is already held.
+func (db *database) audit(td ThreatType, oldVersion []byte, resp *pb.ComputeThreatListDiffResponse, added, removed int, err error) {
+	if db.config.Audit == nil {
+		return
+	}
+	r := AuditRecord{
+		Time:         db.config.now(),
+		ThreatType:   td,
+		ResponseType: resp.ResponseType.String(),
+		OldVersion:   oldVersion,
+		NewVersion:   resp.NewVersionToken,
+		Added:        added,
+		Removed:      removed,
+		Checksum:     resp.GetChecksum().GetSha256(),
+		ChecksumOK:   !errors.Is(err, errChecksumMismatch),
+	}
+	if err != nil {
+		r.Error = err.Error()
+	} else {
+		r.Entries = len(db.tfu[td].Hashes)
+	}
+	if err := db.config.Audit(r); err != nil {
+		db.log.Printf("audit failure: %v", err)
+	}
+}
+
+// ListStats reports statistics for each threat list in the database.
+func (db *database) ListStats() map[ThreatType]ListStats {
+	db.ml.RLock()
+	defer db.ml.RUnlock()
+	lists := make(map[ThreatType]ListStats)
+	for td, ls := range db.lists {
+		lists[td] = ls
+	}
+	if t := db.table.Load(); t != nil {
+		for td, hs := range t.tfl {
+			ls := lists[td]
+			ls.Entries = hs.Len()
+			lists[td] = ls
+		}
+	}
+	return lists
+}
+
+// snapshot returns a copy of the threat lists in a format suitable for
+// storing, before generateThreatsForLookups clobbers the hashes.
+//
+// This assumes that the db.mu lock is already held.
+func (db *database) snapshot(last time.Time) databaseFormat {
 	dbf := databaseFormat{make(threatsForUpdate), last}
 	for td, phs := range db.tfu {
-		// Copy of partialHashes before generateThreatsForLookups clobbers it.
 		dbf.Table[td] = phs
 	}
+	return dbf
+}
 
-	db.generateThreatsForLookups(last)
+// checkpoint saves the lists updated so far, so that a restart resumes from
+// their version tokens. The sync time is not advanced until every list has
+// been updated.
+func (db *database) checkpoint() {
+	db.ml.RLock()
+	last := db.last
+	db.ml.RUnlock()
+	db.save(db.snapshot(last))
+}
 
-	// Regenerate the database and store it.
-	if db.config.DBPath != "" {
-		// Semantically, we ignore save errors, but we do log them.
-		if err := saveDatabase(db.config.DBPath, dbf); err != nil {
-			db.log.Printf("save failure: %v", err)
-		}
+// save stores dbf if the database is persistent. Semantically, we ignore
+// save errors, but we do log them.
+func (db *database) save(dbf databaseFormat) {
+	store := db.config.store()
+	if store == nil {
+		return
+	}
+	ctx, cancel := db.storeContext()
+	err := storeDatabase(ctx, store, dbf)
+	cancel()
+	switch {
+	case errors.Is(err, ErrStoreConflict):
+		db.log.Printf("save skipped: %v", err)
+	case err != nil:
+		db.log.Printf("save failure: %v", err)
 	}
-	return nextUpdateWait, true
 }
 
 // Lookup looks up the full hash in the threat list and returns a partial
@@ -280,16 +706,49 @@ func (db *database) Lookup(hash hashPrefix) (h hashPrefix, tds []ThreatType) {
 	if !hash.IsFull() {
 		panic("hash is not full")
 	}
+	return db.table.Load().Lookup(hash)
+}
 
-	db.ml.RLock()
-	for td, hs := range db.tfl {
-		if n := hs.Lookup(hash); n > 0 {
-			h = hash[:n]
-			tds = append(tds, td)
-		}
+// LookupPrefix is like Lookup, but for a hash prefix of at least
+// minHashPrefixLength bytes. It only matches the prefixes in the database
+// that are no longer than hash.
+func (db *database) LookupPrefix(hash hashPrefix) (h hashPrefix, tds []ThreatType) {
+	if len(hash) < minHashPrefixLength {
+		panic("hash prefix is too short")
 	}
-	db.ml.RUnlock()
-	return h, tds
+	return db.table.Load().Lookup(hash)
+}
+
+// MemoryUsage reports the approximate number of bytes used by the database.
+func (db *database) MemoryUsage() int64 {
+	return db.table.Load().MemoryUsage()
+}
+
+// renegotiateConstraints lowers the maximum number of entries requested per
+// threat list if the database has outgrown its share of Config.MemoryLimit.
+// The new constraint takes effect on the next update, where the API will
+// typically respond with a smaller full reset of the affected lists.
+//
+// This assumes that the db.mu lock is already held.
+func (db *database) renegotiateConstraints() {
+	limit := db.config.MemoryLimit * databaseMemoryShare / 100
+	t := db.table.Load()
+	if limit <= 0 || t == nil || t.n == 0 || len(t.tfl) == 0 || t.size <= limit {
+		return
+	}
+	perList := limit / (t.size / int64(t.n)) / int64(len(t.tfl))
+	max := int32(minDatabaseEntries)
+	for max < maxDatabaseEntries && int64(max)*2 <= perList {
+		max *= 2
+	}
+	if db.maxEntries != 0 && db.maxEntries <= max {
+		return
+	}
+	if int64(max) > perList {
+		db.log.Printf("memory limit of %d bytes is too small for the minimum database size", db.config.MemoryLimit)
+	}
+	db.log.Printf("database uses %d bytes, limiting threat lists to %d entries", t.size, max)
+	db.maxEntries = max
 }
 
 // setError clears the database state and sets the last error to be err.
@@ -298,19 +757,79 @@ func (db *database) Lookup(hash hashPrefix) (h hashPrefix, tds []ThreatType) {
 func (db *database) setError(err error) {
 	db.tfu = nil
 
+	db.table.Store(nil)
 	db.ml.Lock()
 	if db.err == nil {
 		db.readyCh = make(chan struct{})
 	}
-	db.tfl, db.err, db.last = nil, err, time.Time{}
+	db.err, db.last = err, time.Time{}
 	db.ml.Unlock()
 }
 
 // isStale checks whether the last successful update should be considered stale.
-// Staleness is defined as being older than two of the configured update periods
-// plus jitter.
+// Staleness is defined as being older than MaxDatabaseAge, if set, or two of
+// the configured update periods plus jitter otherwise.
+//
+// Without a MaxDatabaseAge, a read-only database is never considered stale,
+// since it is delivered out-of-band on a schedule that the client knows
+// nothing about.
 func (db *database) isStale(lastUpdate time.Time) bool {
-	return db.config.now().Sub(lastUpdate) > 2*(db.config.UpdatePeriod+jitter)
+	if db.config.ReadOnly && db.config.MaxDatabaseAge <= 0 {
+		return false
+	}
+	return db.config.now().Sub(lastUpdate) > db.maxAge()
+}
+
+// maxAge returns the age after which the database is stale.
+func (db *database) maxAge() time.Duration {
+	if db.config.MaxDatabaseAge > 0 {
+		return db.config.MaxDatabaseAge
+	}
+	return 2 * (db.config.UpdatePeriod + jitter)
+}
+
+// StalePolicy determines what lookups do once the database is stale, that
+// is, once it has not been updated for longer than Config.MaxDatabaseAge.
+// Either way, Status reports the database as stale, and Config.Events is
+// notified.
+type StalePolicy int
+
+const (
+	// StaleFail makes lookups fail until the database is updated, so that
+	// clients can tell that their URLs were not checked against current
+	// threat lists. This is the default.
+	StaleFail StalePolicy = iota
+
+	// StaleServe keeps serving lookups from the stale database, whose
+	// matches are still confirmed with the API, so that URLs listed since
+	// the last update are missed but lookups keep working.
+	StaleServe
+)
+
+var stalePolicyNames = map[StalePolicy]string{
+	StaleFail:  "fail",
+	StaleServe: "serve",
+}
+
+func (p StalePolicy) String() string {
+	if name, ok := stalePolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("StalePolicy(%d)", int(p))
+}
+
+// ParseStalePolicy parses the name of a StalePolicy: "fail" or "serve". An
+// empty string is parsed as StaleFail.
+func ParseStalePolicy(s string) (StalePolicy, error) {
+	if s == "" {
+		return StaleFail, nil
+	}
+	for p, name := range stalePolicyNames {
+		if s == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("webrisk: unknown stale policy: %q", s)
 }
 
 // setStale sets the error state to a stale message, without clearing
@@ -321,7 +840,7 @@ func (db *database) setStale() {
 	if db.err == nil {
 		db.readyCh = make(chan struct{})
 	}
-	db.err = errStale
+	db.err = ErrStale
 }
 
 // clearError clears the db error state, and unblocks any callers of
@@ -339,7 +858,7 @@ func (db *database) clearError() {
 }
 
 // generateThreatsForUpdate regenerates the threatsForUpdate hashes from
-// the threatsForLookup. We do this to avoid holding onto the hash lists for
+// the lookupTable. We do this to avoid holding onto the hash lists for
 // a long time, needlessly occupying lots of memory.
 //
 // This assumes that the db.mu lock is already held.
@@ -348,18 +867,18 @@ func (db *database) generateThreatsForUpdate() {
 		db.tfu = make(threatsForUpdate)
 	}
 
-	db.ml.RLock()
-	for td, hs := range db.tfl {
-		phs := db.tfu[td]
-		phs.Hashes = hs.Export()
-		db.tfu[td] = phs
+	if t := db.table.Load(); t != nil {
+		for td, hs := range t.tfl {
+			phs := db.tfu[td]
+			phs.Hashes = hs.Export()
+			db.tfu[td] = phs
+		}
 	}
-	db.ml.RUnlock()
 }
 
-// generateThreatsForLookups regenerates the threatsForLookup data structure
-// from the threatsForUpdate data structure and stores the last timestamp.
-// Since the hashes are effectively stored as a set inside the threatsForLookup,
+// generateThreatsForLookups regenerates the lookupTable from the
+// threatsForUpdate data structure and stores the last timestamp.
+// Since the hashes are effectively stored as a set inside the lookupTable,
 // we clear out the hashes slice in threatsForUpdate so that it can be GCed.
 //
 // This assumes that the db.mu lock is already held.
@@ -373,10 +892,11 @@ func (db *database) generateThreatsForLookups(last time.Time) {
 		phs.Hashes = nil // Clear hashes to keep memory usage low
 		db.tfu[td] = phs
 	}
+	db.table.Store(newLookupTable(tfl))
 
 	db.ml.Lock()
 	wasBad := db.err != nil
-	db.tfl, db.last = tfl, last
+	db.last = last
 	db.ml.Unlock()
 
 	if wasBad {
@@ -386,49 +906,44 @@ func (db *database) generateThreatsForLookups(last time.Time) {
 }
 
 // saveDatabase saves the database threat list to a file.
-func saveDatabase(path string, db databaseFormat) (err error) {
-	var file *os.File
-	file, err = os.Create(path)
-	if err != nil {
+func saveDatabase(path string, db databaseFormat) error {
+	return storeDatabase(context.Background(), &fileStore{path: path}, db)
+}
+
+// loadDatabase loads the database state from a file.
+func loadDatabase(path string) (databaseFormat, error) {
+	return restoreDatabase(context.Background(), &fileStore{path: path})
+}
+
+// storeDatabase encodes the database threat list and saves it to the store.
+func storeDatabase(ctx context.Context, store Store, db databaseFormat) error {
+	var buf bytes.Buffer
+	if err := encodeDatabase(&buf, db); err != nil {
 		return err
 	}
-	defer func() {
-		if cerr := file.Close(); err == nil {
-			err = cerr
-		}
-	}()
+	return store.Save(ctx, buf.Bytes())
+}
 
-	gz, err := gzip.NewWriterLevel(file, gzip.BestCompression)
+// encodeDatabase writes the database threat list to w in the stored format.
+func encodeDatabase(w io.Writer, db databaseFormat) error {
+	gz, err := gzip.NewWriterLevel(w, gzip.BestCompression)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if zerr := gz.Close(); err == nil {
-			err = zerr
-		}
-	}()
-
 	encoder := gob.NewEncoder(gz)
-	if err = encoder.Encode(db); err != nil {
+	if err := encoder.Encode(db); err != nil {
 		return err
 	}
-	return nil
+	return gz.Close()
 }
 
-// loadDatabase loads the database state from a file.
-func loadDatabase(path string) (db databaseFormat, err error) {
-	var file *os.File
-	file, err = os.Open(path)
+// restoreDatabase loads the database state from the store.
+func restoreDatabase(ctx context.Context, store Store) (db databaseFormat, err error) {
+	data, err := store.Load(ctx)
 	if err != nil {
 		return db, err
 	}
-	defer func() {
-		if cerr := file.Close(); err == nil {
-			err = cerr
-		}
-	}()
-
-	gz, err := gzip.NewReader(file)
+	gz, err := gzip.NewReader(bytes.NewReader(data))
 	if err != nil {
 		return db, err
 	}
@@ -444,14 +959,24 @@ func loadDatabase(path string) (db databaseFormat, err error) {
 	}
 	for _, dv := range db.Table {
 		if !bytes.Equal(dv.SHA256, dv.Hashes.SHA256()) {
-			return db, errors.New("webrisk: threat list SHA256 mismatch")
+			return db, errChecksumMismatch
 		}
 	}
 	return db, nil
 }
 
+// storeContext returns a context for a single store operation, bounded by
+// the configured request timeout.
+func (db *database) storeContext() (context.Context, context.CancelFunc) {
+	if db.config.RequestTimeout > 0 {
+		return context.WithTimeout(context.Background(), db.config.RequestTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
 // update updates the threat list according to the API response.
-func (tfu threatsForUpdate) update(resp *pb.ComputeThreatListDiffResponse, td ThreatType) error {
+// It reports the number of entries added and removed.
+func (tfu threatsForUpdate) update(resp *pb.ComputeThreatListDiffResponse, td ThreatType) (added, removed int, err error) {
 	phs, ok := tfu[td]
 
 	removalQuantity := 0
@@ -472,14 +997,14 @@ func (tfu threatsForUpdate) update(resp *pb.ComputeThreatListDiffResponse, td Th
 		switch resp.ResponseType {
 		case pb.ComputeThreatListDiffResponse_DIFF:
 			if !ok {
-				return errors.New("webrisk: partial update received for non-existent key")
+				return 0, 0, errors.New("webrisk: partial update received for non-existent key")
 			}
 		case pb.ComputeThreatListDiffResponse_RESET:
 			if removalQuantity > 0 {
-				return errors.New("webrisk: indices to be removed included in a full update")
+				return 0, 0, errors.New("webrisk: indices to be removed included in a full update")
 			}
 		default:
-			return errors.New("webrisk: unknown response type")
+			return 0, 0, errors.New("webrisk: unknown response type")
 		}
 
 		// Hashes must be sorted for removal logic to work properly.
@@ -487,12 +1012,13 @@ func (tfu threatsForUpdate) update(resp *pb.ComputeThreatListDiffResponse, td Th
 
 		idxs, err := decodeIndices(resp.Removals)
 		if err != nil {
-			return err
+			return 0, 0, err
 		}
 
+		removed = len(idxs)
 		for _, i := range idxs {
 			if i < 0 || i >= int32(len(phs.Hashes)) {
-				return errors.New("webrisk: invalid removal index")
+				return 0, 0, errors.New("webrisk: invalid removal index")
 			}
 			phs.Hashes[i] = ""
 		}
@@ -513,25 +1039,30 @@ func (tfu threatsForUpdate) update(resp *pb.ComputeThreatListDiffResponse, td Th
 
 		hashes, err := decodeHashes(resp.Additions)
 		if err != nil {
-			return err
+			return 0, 0, err
 		}
-		phs.Hashes = append(phs.Hashes, hashes...)
+		if len(phs.Hashes) == 0 {
+			phs.Hashes = hashes // Spare a copy on full syncs
+		} else {
+			phs.Hashes = append(phs.Hashes, hashes...)
+		}
+		added = len(hashes)
 	}
 
 	// Hashes must be sorted for SHA256 checksum to be correct.
 	phs.Hashes.Sort()
 	if err := phs.Hashes.Validate(); err != nil {
-		return err
+		return 0, 0, err
 	}
 
 	if cs := resp.GetChecksum(); cs != nil {
 		phs.SHA256 = cs.Sha256
 	}
 	if !bytes.Equal(phs.SHA256, phs.Hashes.SHA256()) {
-		return errors.New("webrisk: threat list SHA256 mismatch")
+		return added, removed, errChecksumMismatch
 	}
 
 	phs.State = resp.NewVersionToken
 	tfu[td] = phs
-	return nil
+	return added, removed, nil
 }
@@ -26,6 +26,7 @@ import (
 	"reflect"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -59,6 +60,21 @@ func newHashSet(phs hashPrefixes) (hs hashSet) {
 	return hs
 }
 
+// databaseState holds the parts of a database that tests compare against.
+type databaseState struct {
+	last time.Time
+	tfu  threatsForUpdate
+	tfl  threatsForLookup
+}
+
+func getDatabaseState(db *database) *databaseState {
+	ds := &databaseState{last: db.last, tfu: db.tfu}
+	if t := db.table.Load(); t != nil {
+		ds.tfl = t.tfl
+	}
+	return ds
+}
+
 func TestDatabaseInit(t *testing.T) {
 	path := mustGetTempFile(t)
 	defer os.Remove(path)
@@ -67,10 +83,10 @@ func TestDatabaseInit(t *testing.T) {
 	mockNow := func() time.Time { return now }
 
 	vectors := []struct {
-		config *Config   // Input configuration
-		oldDB  *database // The old database (before export)
-		newDB  *database // The expected new database (after import)
-		fail   bool      // Expected failure
+		config *Config        // Input configuration
+		oldDB  *database      // The old database (before export)
+		newDB  *databaseState // The expected new database (after import)
+		fail   bool           // Expected failure
 	}{{
 		// Load from a valid database file.
 		config: &Config{
@@ -95,7 +111,7 @@ func TestDatabaseInit(t *testing.T) {
 				},
 			},
 		},
-		newDB: &database{
+		newDB: &databaseState{
 			last: now.Add(-DefaultUpdatePeriod + time.Minute),
 			tfu: threatsForUpdate{
 				ThreatTypeUnspecified: partialHashes{
@@ -136,7 +152,7 @@ func TestDatabaseInit(t *testing.T) {
 				},
 			},
 		},
-		newDB: &database{
+		newDB: &databaseState{
 			last: now.Add(-DefaultUpdatePeriod + (30 * time.Minute)),
 			tfu: threatsForUpdate{
 				ThreatTypeUnspecified: partialHashes{
@@ -177,7 +193,7 @@ func TestDatabaseInit(t *testing.T) {
 				},
 			},
 		},
-		newDB: &database{
+		newDB: &databaseState{
 			last: now,
 			tfu: threatsForUpdate{
 				ThreatTypeUnspecified: partialHashes{
@@ -271,9 +287,21 @@ func TestDatabaseInit(t *testing.T) {
 			t.Errorf("test %d, mismatching status: got %v, want %v", i, fail, v.fail)
 		}
 
-		db2.config, db2.log, db2.readyCh = nil, nil, nil
-		if !v.fail && !reflect.DeepEqual(db2, v.newDB) {
-			t.Errorf("test %d, mismatching database contents:\ngot  %+v\nwant %+v", i, db2, v.newDB)
+		if v.fail {
+			continue
+		}
+		if db2.err != nil {
+			t.Errorf("test %d, unexpected database error: %v", i, db2.err)
+		}
+		for td, hs := range v.newDB.tfl {
+			for _, h := range hs.Export() {
+				if !db2.table.Load().filter.MayContain(h) {
+					t.Errorf("test %d, filter is missing hash %q for %v", i, h, td)
+				}
+			}
+		}
+		if got := getDatabaseState(db2); !reflect.DeepEqual(got, v.newDB) {
+			t.Errorf("test %d, mismatching database contents:\ngot  %+v\nwant %+v", i, got, v.newDB)
 		}
 	}
 }
@@ -329,13 +357,13 @@ func TestDatabaseUpdate(t *testing.T) {
 	var resp *pb.ComputeThreatListDiffResponse
 	var errResponse error
 	mockAPI := &mockAPI{
-		listUpdate: func(context.Context, pb.ThreatType, []byte, []pb.CompressionType) (*pb.ComputeThreatListDiffResponse, error) {
+		listUpdate: func(context.Context, pb.ThreatType, []byte, *pb.ComputeThreatListDiffRequest_Constraints) (*pb.ComputeThreatListDiffResponse, error) {
 			return resp, errResponse
 		},
 	}
 
 	// Setup the database under test.
-	var gotDB, wantDB *database
+	var gotDB, wantDB *databaseState
 	db := &database{config: config, log: logger}
 
 	// Update 0: partial update on empty database.
@@ -356,7 +384,7 @@ func TestDatabaseUpdate(t *testing.T) {
 	now = now.Add(time.Hour)
 	resp = newResp(ThreatTypeMalware, full, nil, []string{"aaaa", "0421e", "666666", "7777777", "88888888"},
 		"d1", "a3b93fac424834c2447e2dbe5db3ec8553519777523907ea310e207f556a7637")
-	ts := timepb.New(time.Now().Add(2000 * time.Second))
+	ts := timepb.New(now.Add(2000 * time.Second))
 	resp.RecommendedNextDiff = ts
 
 	delay, updated = db.Update(context.Background(), mockAPI)
@@ -370,8 +398,8 @@ func TestDatabaseUpdate(t *testing.T) {
 		t.Fatalf("update 1, expected delay %v got %v", expectedDelay, delay)
 	}
 
-	gotDB = &database{last: db.last, tfu: db.tfu, tfl: db.tfl}
-	wantDB = &database{
+	gotDB = getDatabaseState(db)
+	wantDB = &databaseState{
 		last: now,
 		tfu: threatsForUpdate{
 			ThreatTypeMalware: {SHA256: gotDB.tfu[ThreatTypeMalware].SHA256, State: []byte{0x64, 0x31}},
@@ -386,6 +414,18 @@ func TestDatabaseUpdate(t *testing.T) {
 	if !reflect.DeepEqual(gotDB.tfl, wantDB.tfl) {
 		t.Fatalf("update 1, threats for lookup mismatch:\ngot  %+v\nwant %+v", gotDB.tfl, wantDB.tfl)
 	}
+	wantStats := map[ThreatType]ListStats{
+		ThreatTypeMalware: {
+			Entries:        5,
+			LastUpdate:     now,
+			LastUpdateType: "RESET",
+			LastAdded:      5,
+			ChecksumOK:     true,
+		},
+	}
+	if got := db.ListStats(); !reflect.DeepEqual(got, wantStats) {
+		t.Errorf("update 1, list stats mismatch:\ngot  %+v\nwant %+v", got, wantStats)
+	}
 
 	// Update 2: partial update with no changes.
 	now = now.Add(time.Hour)
@@ -398,7 +438,7 @@ func TestDatabaseUpdate(t *testing.T) {
 	if math.Abs((config.UpdatePeriod - delay).Seconds()) > 31 {
 		t.Fatalf("update 2, delay jitter was more than 30 seconds")
 	}
-	gotDB = &database{last: db.last, tfu: db.tfu, tfl: db.tfl}
+	gotDB = getDatabaseState(db)
 	wantDB.last = now
 	if !reflect.DeepEqual(gotDB.tfu, wantDB.tfu) {
 		t.Errorf("update 2, threats for update mismatch:\ngot  %+v\nwant %+v", gotDB.tfu, wantDB.tfu)
@@ -418,8 +458,8 @@ func TestDatabaseUpdate(t *testing.T) {
 	if math.Abs((config.UpdatePeriod - delay).Seconds()) > 31 {
 		t.Fatalf("update 3, delay jitter was more than 30 seconds")
 	}
-	gotDB = &database{last: db.last, tfu: db.tfu, tfl: db.tfl}
-	wantDB = &database{
+	gotDB = getDatabaseState(db)
+	wantDB = &databaseState{
 		last: now,
 		tfu: threatsForUpdate{
 			ThreatTypeMalware: {SHA256: gotDB.tfu[ThreatTypeMalware].SHA256, State: []byte{0x64, 0x32}},
@@ -448,11 +488,22 @@ func TestDatabaseUpdate(t *testing.T) {
 	if math.Abs((config.UpdatePeriod - delay).Seconds()) > 31 {
 		t.Fatalf("update 4, delay jitter was more than 30 seconds")
 	}
-	gotDB = &database{last: db.last, tfu: db.tfu, tfl: db.tfl}
-	wantDB = &database{}
+	gotDB = getDatabaseState(db)
+	wantDB = &databaseState{}
 	if !reflect.DeepEqual(gotDB, wantDB) {
 		t.Fatalf("update 4, database state mismatch:\ngot  %+v\nwant %+v", gotDB, wantDB)
 	}
+	wantStats = map[ThreatType]ListStats{
+		ThreatTypeMalware: {
+			LastUpdate:       now.Add(-time.Hour),
+			LastUpdateType:   "RESET",
+			LastAdded:        2,
+			ChecksumFailures: 1,
+		},
+	}
+	if got := db.ListStats(); !reflect.DeepEqual(got, wantStats) {
+		t.Errorf("update 4, list stats mismatch:\ngot  %+v\nwant %+v", got, wantStats)
+	}
 
 	// Update 5: removal index is out-of-bounds.
 	now = now.Add(time.Hour)
@@ -465,8 +516,8 @@ func TestDatabaseUpdate(t *testing.T) {
 	if math.Abs((config.UpdatePeriod - delay).Seconds()) > 31 {
 		t.Fatalf("update 5, delay jitter was more than 30 seconds")
 	}
-	gotDB = &database{last: db.last, tfu: db.tfu, tfl: db.tfl}
-	wantDB = &database{}
+	gotDB = getDatabaseState(db)
+	wantDB = &databaseState{}
 	if !reflect.DeepEqual(gotDB, wantDB) {
 		t.Fatalf("update 5, database state mismatch:\ngot  %+v\nwant %+v", gotDB, wantDB)
 	}
@@ -506,6 +557,238 @@ func TestDatabaseUpdate(t *testing.T) {
 	}
 }
 
+func TestDatabaseResume(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	now := time.Unix(1451436338, 951473000)
+	cs := &countingStore{fileStore: &fileStore{path: path}}
+	config := &Config{
+		ThreatLists:  []ThreatType{ThreatTypeUnspecified, ThreatTypeMalware},
+		UpdatePeriod: DefaultUpdatePeriod,
+		Store:        cs,
+		now:          func() time.Time { return now },
+	}
+	logger := log.New(ioutil.Discard, "", 0)
+	checksums := map[pb.ThreatType]string{
+		pb.ThreatType(ThreatTypeUnspecified): "e5c1edb50ff8b4fcc3ead3a845ffbe1ad51c9dae5d44335a5c333b57ac8df062",
+		pb.ThreatType(ThreatTypeMalware):     "9a720c6ee500f5a0d4e5477fc9f3d8573226723d0b338b0c8f572d877bdfa224",
+	}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeUnspecified: partialHashes{
+				Hashes: []hashPrefix{"aaaa", "bbbb"},
+				SHA256: mustDecodeHex(t, checksums[pb.ThreatType(ThreatTypeUnspecified)]),
+				State:  []byte("state1"),
+			},
+			ThreatTypeMalware: partialHashes{
+				Hashes: []hashPrefix{"bbbb", "cccc"},
+				SHA256: mustDecodeHex(t, checksums[pb.ThreatType(ThreatTypeMalware)]),
+				State:  []byte("state2"),
+			},
+		},
+		Time: now.Add(-3 * DefaultUpdatePeriod),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+
+	var tokens []string
+	var fail pb.ThreatType
+	var delay time.Duration
+	mockAPI := &mockAPI{
+		listUpdate: func(_ context.Context, tt pb.ThreatType, token []byte, _ *pb.ComputeThreatListDiffRequest_Constraints) (*pb.ComputeThreatListDiffResponse, error) {
+			tokens = append(tokens, string(token))
+			now = now.Add(delay)
+			if tt == fail {
+				return nil, errors.New("connection reset")
+			}
+			return &pb.ComputeThreatListDiffResponse{
+				ResponseType:    pb.ComputeThreatListDiffResponse_DIFF,
+				NewVersionToken: append(token, '+'),
+				Checksum:        &pb.ComputeThreatListDiffResponse_Checksum{Sha256: mustDecodeHex(t, checksums[tt])},
+			}, nil
+		},
+	}
+
+	// A stale database is not usable, but its version tokens are resumed.
+	// The update is interrupted after the first threat list.
+	db := new(database)
+	if db.Init(config, logger) {
+		t.Fatalf("unexpected success loading stale database")
+	}
+	fail = pb.ThreatType(ThreatTypeMalware)
+	if _, ok := db.Update(context.Background(), mockAPI); ok {
+		t.Fatalf("unexpected update success")
+	}
+	if want := []string{"state1", "state2"}; !reflect.DeepEqual(tokens, want) {
+		t.Errorf("mismatching version tokens: got %q, want %q", tokens, want)
+	}
+	if n := atomic.LoadInt32(&cs.saves); n != 1 {
+		t.Errorf("interrupted update saved %d times, want 1", n)
+	}
+
+	// The progress on the first threat list must survive a restart.
+	tokens, fail = nil, -1
+	db = new(database)
+	if db.Init(config, logger) {
+		t.Fatalf("unexpected success loading stale database")
+	}
+	if _, ok := db.Update(context.Background(), mockAPI); !ok {
+		t.Fatalf("unexpected update failure: %v", db.err)
+	}
+	if want := []string{"state1+", "state2"}; !reflect.DeepEqual(tokens, want) {
+		t.Errorf("mismatching version tokens: got %q, want %q", tokens, want)
+	}
+	if n := atomic.LoadInt32(&cs.saves); n != 2 {
+		t.Errorf("update saved %d times, want once", n-1)
+	}
+	if err := db.Status(); err != nil {
+		t.Errorf("unexpected database error: %v", err)
+	}
+	if _, tds := db.Lookup(hashFromPattern("")); tds != nil {
+		t.Errorf("unexpected match: %v", tds)
+	}
+
+	dbf, err := loadDatabase(path)
+	if err != nil {
+		t.Fatalf("unexpected load error: %v", err)
+	}
+	if !dbf.Time.Equal(now) {
+		t.Errorf("mismatching database time: got %v, want %v", dbf.Time, now)
+	}
+
+	// The progress of a long update is saved as it goes.
+	delay = checkpointPeriod
+	if _, ok := db.Update(context.Background(), mockAPI); !ok {
+		t.Fatalf("unexpected update failure: %v", db.err)
+	}
+	if n := atomic.LoadInt32(&cs.saves); n != 4 {
+		t.Errorf("long update saved %d times, want twice", n-2)
+	}
+}
+
+func TestDatabaseDropUnsubscribed(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	now := time.Unix(1451436338, 951473000)
+	logger := log.New(ioutil.Discard, "", 0)
+	vectors := []struct {
+		last time.Time // Last update of the stored database
+		ok   bool      // Expected Init result
+	}{
+		{last: now.Add(-time.Minute), ok: true},
+		{last: now.Add(-3 * DefaultUpdatePeriod), ok: false},
+	}
+
+	for i, v := range vectors {
+		dbf := databaseFormat{
+			Table: threatsForUpdate{
+				ThreatTypeUnspecified: partialHashes{
+					Hashes: []hashPrefix{"aaaa", "bbbb"},
+					SHA256: mustDecodeHex(t, "e5c1edb50ff8b4fcc3ead3a845ffbe1ad51c9dae5d44335a5c333b57ac8df062"),
+					State:  []byte("state1"),
+				},
+				ThreatTypeMalware: partialHashes{
+					Hashes: []hashPrefix{"bbbb", "cccc"},
+					SHA256: mustDecodeHex(t, "9a720c6ee500f5a0d4e5477fc9f3d8573226723d0b338b0c8f572d877bdfa224"),
+					State:  []byte("state2"),
+				},
+			},
+			Time: v.last,
+		}
+		if err := saveDatabase(path, dbf); err != nil {
+			t.Fatalf("test %d, unexpected save error: %v", i, err)
+		}
+
+		config := &Config{
+			ThreatLists:  []ThreatType{ThreatTypeMalware},
+			UpdatePeriod: DefaultUpdatePeriod,
+			DBPath:       path,
+			now:          func() time.Time { return now },
+		}
+		if ok := new(database).Init(config, logger); ok != v.ok {
+			t.Errorf("test %d, mismatching status: got %v, want %v", i, ok, v.ok)
+		}
+
+		got, err := loadDatabase(path)
+		if err != nil {
+			t.Fatalf("test %d, unexpected load error: %v", i, err)
+		}
+		want := databaseFormat{
+			Table: threatsForUpdate{ThreatTypeMalware: dbf.Table[ThreatTypeMalware]},
+			Time:  v.last,
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("test %d, mismatching database contents:\ngot  %+v\nwant %+v", i, got, want)
+		}
+	}
+}
+
+func TestDatabaseVerify(t *testing.T) {
+	db := &database{config: &Config{}}
+	if _, err := db.Verify(context.Background(), &mockAPI{}); err == nil {
+		t.Errorf("unexpected success verifying an empty database")
+	}
+
+	hashes := hashPrefixes{"aaaa", "bbbb"}
+	db.tfu = threatsForUpdate{
+		ThreatTypeMalware: {Hashes: hashes, SHA256: hashes.SHA256(), State: []byte("state1")},
+	}
+	db.generateThreatsForLookups(time.Now())
+	api := &mockAPI{
+		listUpdate: func(_ context.Context, tt pb.ThreatType, token []byte, _ *pb.ComputeThreatListDiffRequest_Constraints) (*pb.ComputeThreatListDiffResponse, error) {
+			if ThreatType(tt) != ThreatTypeMalware || string(token) != "state1" {
+				return nil, fmt.Errorf("unexpected request for %v at %q", tt, token)
+			}
+			return &pb.ComputeThreatListDiffResponse{
+				ResponseType:    pb.ComputeThreatListDiffResponse_DIFF,
+				Removals:        &pb.ThreatEntryRemovals{RawIndices: &pb.RawIndices{Indices: []int32{0}}},
+				NewVersionToken: []byte("state2"),
+				Checksum:        &pb.ComputeThreatListDiffResponse_Checksum{Sha256: hashPrefixes{"bbbb"}.SHA256()},
+			}, nil
+		},
+	}
+	vs, err := db.Verify(context.Background(), api)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []ListVerification{{ThreatType: ThreatTypeMalware, InSync: true, Removed: 1}}
+	if !reflect.DeepEqual(vs, want) {
+		t.Errorf("Verify mismatch:\ngot  %+v\nwant %+v", vs, want)
+	}
+	if _, tds := db.Lookup(hashPrefix("aaaa" + strings.Repeat("x", 28))); len(tds) != 1 {
+		t.Errorf("database was modified by Verify")
+	}
+
+	// A database limited to fewer entries is verified under the same limit,
+	// or the API would reset its lists.
+	db.maxEntries = 2048
+	api.listUpdate = func(_ context.Context, _ pb.ThreatType, _ []byte, c *pb.ComputeThreatListDiffRequest_Constraints) (*pb.ComputeThreatListDiffResponse, error) {
+		if c.GetMaxDatabaseEntries() != 2048 {
+			return &pb.ComputeThreatListDiffResponse{
+				ResponseType:    pb.ComputeThreatListDiffResponse_RESET,
+				Additions:       &pb.ThreatEntryAdditions{RawHashes: []*pb.RawHashes{{PrefixSize: 4, RawHashes: []byte("aaaabbbbcccc")}}},
+				NewVersionToken: []byte("state2"),
+				Checksum:        &pb.ComputeThreatListDiffResponse_Checksum{Sha256: hashPrefixes{"aaaa", "bbbb", "cccc"}.SHA256()},
+			}, nil
+		}
+		return &pb.ComputeThreatListDiffResponse{
+			ResponseType:    pb.ComputeThreatListDiffResponse_DIFF,
+			NewVersionToken: []byte("state1"),
+			Checksum:        &pb.ComputeThreatListDiffResponse_Checksum{Sha256: hashes.SHA256()},
+		}, nil
+	}
+	if vs, err = db.Verify(context.Background(), api); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want = []ListVerification{{ThreatType: ThreatTypeMalware, InSync: true}}
+	if !reflect.DeepEqual(vs, want) {
+		t.Errorf("Verify of a limited database mismatch:\ngot  %+v\nwant %+v", vs, want)
+	}
+}
+
 func TestDatabaseLookup(t *testing.T) {
 	threatsEqual := func(a, b []ThreatType) bool {
 		ma := make(map[ThreatType]struct{})
@@ -519,7 +802,8 @@ func TestDatabaseLookup(t *testing.T) {
 		return reflect.DeepEqual(ma, mb)
 	}
 
-	db := &database{tfl: threatsForLookup{
+	db := new(database)
+	db.table.Store(newLookupTable(threatsForLookup{
 		ThreatTypeUnspecified: newHashSet([]hashPrefix{
 			"26e307", "524d", "5c6655d4"}),
 		ThreatTypeMalware: newHashSet([]hashPrefix{
@@ -528,7 +812,7 @@ func TestDatabaseLookup(t *testing.T) {
 			"1e25395a9b1b8", "cad78c628", "cad78c68"}),
 		ThreatTypeUnwantedSoftware: newHashSet([]hashPrefix{
 			"524d", "59b8", "5c6655d3", "cad78c1c"}),
-	}}
+	}))
 
 	vectors := []struct {
 		input   hashPrefix // Input full hash
@@ -750,3 +1034,167 @@ func TestIsStale(t *testing.T) {
 		}
 	}
 }
+
+func BenchmarkDatabaseLookupParallel(b *testing.B) {
+	var benchmarkHashes = getBenchmarkHashes(b)
+
+	tfl := make(threatsForLookup)
+	for i, hs := range benchmarkHashes {
+		tfl[ThreatType(i)] = newHashSet(hs)
+	}
+	db := new(database)
+	db.table.Store(newLookupTable(tfl))
+
+	var queries []hashPrefix
+	for _, h := range benchmarkHashes[1] {
+		queries = append(queries, hashFromPattern(string(h)))
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			db.Lookup(queries[i%len(queries)])
+		}
+	})
+}
+
+// BenchmarkThreatListReset measures the full sync of a threat list of a
+// million hash prefixes, sent as raw hashes.
+func BenchmarkThreatListReset(b *testing.B) {
+	var benchmarkHashes = getBenchmarkHashes(b)
+	hashes := append(hashPrefixes(nil), benchmarkHashes[1]...)
+	hashes.Sort()
+	bySize := make(map[int][]byte)
+	for _, h := range hashes {
+		bySize[len(h)] = append(bySize[len(h)], h...)
+	}
+	newResponse := func() *pb.ComputeThreatListDiffResponse {
+		resp := &pb.ComputeThreatListDiffResponse{
+			ResponseType:    pb.ComputeThreatListDiffResponse_RESET,
+			Additions:       &pb.ThreatEntryAdditions{},
+			NewVersionToken: []byte("state"),
+			Checksum:        &pb.ComputeThreatListDiffResponse_Checksum{Sha256: hashes.SHA256()},
+		}
+		for size, raw := range bySize {
+			resp.Additions.RawHashes = append(resp.Additions.RawHashes, &pb.RawHashes{PrefixSize: int32(size), RawHashes: raw})
+		}
+		return resp
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		resp := newResponse()
+		tfu := make(threatsForUpdate)
+		b.StartTimer()
+		if _, _, err := tfu.update(resp, ThreatTypeMalware); err != nil {
+			b.Fatal(err)
+		}
+		var hs hashSet
+		hs.Import(tfu[ThreatTypeMalware].Hashes)
+	}
+}
+
+// BenchmarkThreatListDiff measures a partial update of a threat list of a
+// million hash prefixes, which removes and adds 1% of them, sent
+// Rice-encoded.
+func BenchmarkThreatListDiff(b *testing.B) {
+	var benchmarkHashes = getBenchmarkHashes(b)
+	hashes := append(hashPrefixes(nil), benchmarkHashes[1]...)
+	hashes.Sort()
+	existing := make(map[hashPrefix]bool, len(hashes))
+	for _, h := range hashes {
+		existing[h] = true
+	}
+
+	n := len(hashes) / 100
+	var indices []int32
+	removed := make(map[hashPrefix]bool)
+	for i := 0; i < n; i++ {
+		indices = append(indices, int32(i*100))
+		removed[hashes[i*100]] = true
+	}
+	var added []hashPrefix
+	for i := 0; len(added) < n; i++ {
+		h := hashFromPattern(fmt.Sprintf("added.example/%d", i))[:4]
+		if !existing[h] {
+			existing[h] = true
+			added = append(added, h)
+		}
+	}
+	var want hashPrefixes
+	for _, h := range hashes {
+		if !removed[h] {
+			want = append(want, h)
+		}
+	}
+	want = append(want, added...)
+	want.Sort()
+
+	removalDeltas := make([]uint32, len(indices)-1)
+	for i := range removalDeltas {
+		removalDeltas[i] = uint32(indices[i+1] - indices[i])
+	}
+	resp := &pb.ComputeThreatListDiffResponse{
+		ResponseType: pb.ComputeThreatListDiffResponse_DIFF,
+		Removals: &pb.ThreatEntryRemovals{RiceIndices: &pb.RiceDeltaEncoding{
+			FirstValue:    int64(indices[0]),
+			RiceParameter: 6,
+			EntryCount:    int32(len(removalDeltas)),
+			EncodedData:   riceEncode(removalDeltas, 6),
+		}},
+		Additions:       &pb.ThreatEntryAdditions{RiceHashes: riceEncodePrefixes(added)},
+		NewVersionToken: []byte("state2"),
+		Checksum:        &pb.ComputeThreatListDiffResponse_Checksum{Sha256: want.SHA256()},
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		tfu := threatsForUpdate{ThreatTypeMalware: partialHashes{
+			Hashes: append(hashPrefixes(nil), hashes...),
+			State:  []byte("state1"),
+		}}
+		b.StartTimer()
+		if _, _, err := tfu.update(resp, ThreatTypeMalware); err != nil {
+			b.Fatal(err)
+		}
+		var hs hashSet
+		hs.Import(tfu[ThreatTypeMalware].Hashes)
+	}
+}
+
+func TestDatabaseRenegotiateConstraints(t *testing.T) {
+	logger := log.New(ioutil.Discard, "", 0)
+	var hashes hashPrefixes
+	for i := 0; i < 10000; i++ {
+		hashes = append(hashes, hashFromPattern(fmt.Sprint(i))[:4])
+	}
+	tfl := threatsForLookup{
+		ThreatTypeMalware:           newHashSet(hashes),
+		ThreatTypeSocialEngineering: newHashSet(hashes),
+	}
+	table := newLookupTable(tfl)
+
+	vectors := []struct {
+		limit      int64
+		maxEntries int32 // Current constraint
+		want       int32 // Constraint after renegotiation
+	}{
+		{limit: 0, want: 0},
+		{limit: 4 * table.size, want: 0},
+		{limit: table.size, want: 4096},
+		{limit: table.size, maxEntries: 2048, want: 2048},
+		{limit: table.size / 2, maxEntries: 4096, want: 2048},
+		{limit: 100, want: minDatabaseEntries},
+	}
+	for i, v := range vectors {
+		db := &database{config: &Config{MemoryLimit: v.limit}, log: logger, maxEntries: v.maxEntries}
+		db.table.Store(table)
+		db.renegotiateConstraints()
+		if db.maxEntries != v.want {
+			t.Errorf("test %d, maxEntries = %d, want %d", i, db.maxEntries, v.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+)
+
+// errLookupDeadline is returned while waiting for a hash search once the
+// Config.LookupDeadline of the lookup has passed.
+var errLookupDeadline = errors.New("webrisk: lookup deadline exceeded")
+
+// lookupDeadline is the Config.LookupDeadline of a lookup. Once it has
+// passed, the lookup only uses the hash searches that are already done.
+type lookupDeadline struct {
+	timer  <-chan time.Time
+	passed bool
+}
+
+// newLookupDeadline returns a deadline that passes after d on clock.
+func newLookupDeadline(clock Clock, d time.Duration) *lookupDeadline {
+	if d <= 0 {
+		return &lookupDeadline{passed: true}
+	}
+	return &lookupDeadline{timer: clock.After(d)}
+}
+
+// wait waits for done to be closed. It returns errLookupDeadline if the
+// deadline passes first, or the error of ctx if it is done first. A nil
+// deadline never passes.
+func (d *lookupDeadline) wait(ctx context.Context, done <-chan struct{}) error {
+	if d != nil && d.passed {
+		select {
+		case <-done:
+			return nil
+		default:
+			return errLookupDeadline
+		}
+	}
+	var timer <-chan time.Time
+	if d != nil {
+		timer = d.timer
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer:
+		d.passed = true
+		return errLookupDeadline
+	}
+}
+
+// backgroundSearch is a hash search that a lookup with a deadline makes in
+// the background, so that it can give up on it. Its results are valid once
+// done is closed.
+type backgroundSearch struct {
+	done chan struct{}
+	resp *pb.SearchHashesResponse
+	err  error
+}
+
+// searchInBackground starts searching for req, for a lookup made with ctx.
+// The search is not canceled with ctx, so that its result is cached even if
+// the lookup gives up on it, but it is made with the trace context and the
+// request ID of ctx, and times out after Config.RequestTimeout.
+func (wr *UpdateClient) searchInBackground(ctx context.Context, req *pb.SearchHashesRequest) *backgroundSearch {
+	s := &backgroundSearch{done: make(chan struct{})}
+	trace, _ := ctx.Value(traceContextKey{}).(TraceContext)
+	sctx := WithRequestID(WithTraceContext(context.Background(), trace), RequestIDFromContext(ctx))
+	go func() {
+		defer close(s.done)
+		sctx, cancel := context.WithTimeout(sctx, wr.config.RequestTimeout)
+		defer cancel()
+		s.resp, s.err = wr.searchHashes(sctx, req)
+	}()
+	return s
+}
+
+// wait returns the results of the search, unless the deadline d passes or
+// ctx is done first.
+func (s *backgroundSearch) wait(ctx context.Context, d *lookupDeadline) (*pb.SearchHashesResponse, error) {
+	if err := d.wait(ctx, s.done); err != nil {
+		return nil, err
+	}
+	return s.resp, s.err
+}
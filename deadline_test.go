@@ -0,0 +1,145 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+	"github.com/google/webrisk/webrisktest"
+	timepb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestLookupDeadline(t *testing.T) {
+	start := time.Date(2023, 5, 24, 10, 0, 0, 0, time.UTC)
+	clock := webrisktest.NewClock(start)
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	evil := hashFromPattern("evil.com/")
+	phs := hashPrefixes{evil[:minHashPrefixLength], hashFromPattern("b.com/")[:minHashPrefixLength]}
+	phs.Sort()
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: start,
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	release := make(chan struct{})
+	wr, err := NewUpdateClient(Config{
+		DBPath:         path,
+		ThreatLists:    []ThreatType{ThreatTypeMalware},
+		UpdatePeriod:   30 * time.Minute,
+		LookupDeadline: 100 * time.Millisecond,
+		Clock:          clock,
+		api: &mockAPI{
+			hashLookup: func(context.Context, []byte, []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+				<-release
+				return &pb.SearchHashesResponse{
+					Threats: []*pb.SearchHashesResponse_ThreatHash{{
+						ThreatTypes: []pb.ThreatType{pb.ThreatType_MALWARE},
+						Hash:        []byte(evil),
+						ExpireTime:  timepb.New(start.Add(10 * time.Minute)),
+					}},
+					NegativeExpireTime: timepb.New(start.Add(5 * time.Minute)),
+				}, nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := clock.BlockUntil(ctx, 1); err != nil {
+		t.Fatalf("update not scheduled: %v", err)
+	}
+
+	// While the API does not answer, the match of the database is reported
+	// unconfirmed once the deadline passes.
+	type result struct {
+		threats [][]URLThreat
+		sources []LookupSource
+		err     error
+	}
+	var trace LookupTrace
+	done := make(chan result)
+	go func() {
+		var r result
+		r.threats, r.sources, r.err = wr.LookupURLsSources(WithLookupTrace(ctx, &trace), []string{"http://evil.com/"})
+		done <- r
+	}()
+	if err := clock.BlockUntil(ctx, 2); err != nil {
+		t.Fatalf("deadline not set: %v", err)
+	}
+	clock.Advance(100 * time.Millisecond)
+	r := <-done
+	want := []URLThreat{{Pattern: "evil.com/", ThreatType: ThreatTypeMalware}}
+	if r.err != nil || len(r.threats[0]) != 1 || r.threats[0][0] != want[0] || r.sources[0] != SourceUnconfirmed {
+		t.Errorf("lookup = (%v, %v, %v), want (%v, %v, nil)", r.threats, r.sources, r.err, want, SourceUnconfirmed)
+	}
+	if trace.Unconfirmed != 1 {
+		t.Errorf("trace.Unconfirmed = %d, want 1", trace.Unconfirmed)
+	}
+	if stats, _ := wr.Status(); stats.QueriesUnconfirmed != 1 || stats.QueriesByAPI != 0 {
+		t.Errorf("QueriesUnconfirmed = %d, QueriesByAPI = %d, want 1, 0", stats.QueriesUnconfirmed, stats.QueriesByAPI)
+	}
+
+	// The search finishes in the background, and its result is cached.
+	close(release)
+	for {
+		cached, err := wr.InspectCache("http://evil.com/")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(cached) > 0 && len(cached[0].Threats) > 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("result of the background search not cached")
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	vectors := []struct {
+		url     string
+		threats []ThreatType
+		source  LookupSource
+	}{
+		{url: "http://evil.com/", threats: []ThreatType{ThreatTypeMalware}, source: SourceCache},
+		{url: "http://b.com/", source: SourceAPI},
+		{url: "http://safe.com/", source: SourceDatabase},
+	}
+	for i, v := range vectors {
+		threats, sources, err := wr.LookupURLsSources(ctx, []string{v.url})
+		if err != nil {
+			t.Fatalf("test %d, unexpected error: %v", i, err)
+		}
+		var got []ThreatType
+		for _, td := range threats[0] {
+			got = append(got, td.ThreatType)
+		}
+		if len(got) != len(v.threats) || (len(got) > 0 && got[0] != v.threats[0]) || sources[0] != v.source {
+			t.Errorf("test %d, lookup of %s = (%v, %v), want (%v, %v)", i, v.url, got, sources[0], v.threats, v.source)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrQuotaExhausted is returned by lookups and updates when the Web Risk API
+// refuses requests because the quota of the project is exhausted.
+var ErrQuotaExhausted = errors.New("webrisk: API quota exhausted")
+
+// EventType is the kind of an operational Event.
+type EventType int
+
+const (
+	// EventUpdateFailed means that the database could not be updated, or
+	// reloaded in read-only mode. Updates are retried with a backoff.
+	EventUpdateFailed EventType = iota + 1
+
+	// EventUpdateRecovered means that the database was updated again
+	// after one or more failures.
+	EventUpdateRecovered
+
+	// EventStale means that the database has not been updated for so long
+	// that lookups fail until it is.
+	EventStale
+
+	// EventCircuitOpen means that the circuit breaker stopped calling the
+	// API after too many consecutive failures. See
+	// Config.CircuitBreakerThreshold.
+	EventCircuitOpen
+
+	// EventQuotaExhausted means that the API started refusing requests
+	// because the quota of the project is exhausted.
+	EventQuotaExhausted
+)
+
+var eventTypeNames = map[EventType]string{
+	EventUpdateFailed:    "update_failed",
+	EventUpdateRecovered: "update_recovered",
+	EventStale:           "database_stale",
+	EventCircuitOpen:     "circuit_open",
+	EventQuotaExhausted:  "quota_exhausted",
+}
+
+func (t EventType) String() string {
+	if name, ok := eventTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// MarshalText encodes the EventType as its name, such as "update_failed".
+func (t EventType) MarshalText() ([]byte, error) { return []byte(t.String()), nil }
+
+// Event is a change in the health of an UpdateClient that an operator may
+// have to act on. See Config.Events.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    EventType `json:"type"`
+	Message string    `json:"message"`
+}
+
+// notify passes an event of type t to Config.Events, if set, and logs it.
+func (wr *UpdateClient) notify(t EventType, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	wr.log.Printf("%v: %s", t, msg)
+	if wr.config.Events != nil {
+		wr.config.Events(Event{Time: wr.config.now(), Type: t, Message: msg})
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+	"github.com/google/webrisk/webrisktest"
+)
+
+func TestEventMarshal(t *testing.T) {
+	e := Event{Time: time.Unix(1451436338, 0).UTC(), Type: EventCircuitOpen, Message: "API calls suspended"}
+	got, err := json.Marshal(e)
+	want := `{"time":"2015-12-30T00:45:38Z","type":"circuit_open","message":"API calls suspended"}`
+	if err != nil || string(got) != want {
+		t.Errorf("json.Marshal(%v) = (%s, %v), want %s", e, got, err, want)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	clock := webrisktest.NewClock(time.Unix(1451436338, 951473000))
+	evil := hashFromPattern("evil.com/")
+	phs := hashPrefixes{evil[:minHashPrefixLength]}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: clock.Now(),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+
+	// The API is called from the goroutines of the client.
+	var (
+		mu        sync.Mutex
+		events    []EventType
+		lookupErr = ErrQuotaExhausted
+		calls     int32
+	)
+	wr, err := NewUpdateClient(Config{
+		DBPath:                  path,
+		ThreatLists:             []ThreatType{ThreatTypeMalware},
+		CircuitBreakerThreshold: 2,
+		Events: func(e Event) {
+			mu.Lock()
+			events = append(events, e.Type)
+			mu.Unlock()
+		},
+		api: &mockAPI{
+			listUpdate: func(context.Context, pb.ThreatType, []byte, *pb.ComputeThreatListDiffRequest_Constraints) (*pb.ComputeThreatListDiffResponse, error) {
+				return nil, errors.New("unavailable")
+			},
+			hashLookup: func(context.Context, []byte, []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+				atomic.AddInt32(&calls, 1)
+				mu.Lock()
+				defer mu.Unlock()
+				if lookupErr != nil {
+					return nil, lookupErr
+				}
+				return &pb.SearchHashesResponse{}, nil
+			},
+		},
+		Clock: clock,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+
+	lookup := func() error {
+		_, err := wr.LookupURLs([]string{"http://evil.com/"})
+		return err
+	}
+	if err := lookup(); !errors.Is(err, ErrQuotaExhausted) {
+		t.Errorf("first lookup error = %v, want %v", err, ErrQuotaExhausted)
+	}
+	if err := lookup(); !errors.Is(err, ErrQuotaExhausted) {
+		t.Errorf("second lookup error = %v, want %v", err, ErrQuotaExhausted)
+	}
+	// The breaker is open, so the API is not called.
+	if err := lookup(); !errors.Is(err, ErrCircuitOpen) || atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("third lookup error = %v after %d calls, want %v after 2", err, atomic.LoadInt32(&calls), ErrCircuitOpen)
+	}
+	// After the cooldown, the API is called again.
+	clock.Advance(DefaultCircuitBreakerCooldown)
+	mu.Lock()
+	lookupErr = nil
+	mu.Unlock()
+	if err := lookup(); err != nil || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("lookup after cooldown error = %v after %d calls, want nil after 3", err, atomic.LoadInt32(&calls))
+	}
+
+	if err := wr.UpdateDatabase(context.Background()); err == nil {
+		t.Errorf("unexpected update success")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []EventType{EventQuotaExhausted, EventCircuitOpen, EventUpdateFailed}
+	if !cmp.Equal(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	// filterBitsPerKey is the number of filter bits allocated per hash prefix.
+	// With filterProbes probes per key, this gives a false positive rate of
+	// roughly 1% for a blocked filter.
+	filterBitsPerKey = 10
+	filterProbes     = 6
+
+	// filterBlockBits is the size of a single filter block. A block is the
+	// size of a typical CPU cache line, so that every query touches exactly
+	// one cache line.
+	filterBlockBits  = 512
+	filterBlockWords = filterBlockBits / 64
+)
+
+// prefixFilter is a blocked Bloom filter over the leading 4 bytes of every
+// hash prefix in the database.
+//
+// Since every hash prefix is at least minHashPrefixLength bytes long, a full
+// hash can only match a prefix in the database if its leading 4 bytes are
+// present in the filter. The vast majority of lookups are for clean URLs, and
+// the filter allows those to be answered with a single cache line probe
+// instead of a map lookup for every threat list.
+//
+// A prefixFilter is immutable once built and is safe for concurrent use.
+type prefixFilter struct {
+	blocks [][filterBlockWords]uint64
+}
+
+// newPrefixFilter builds a filter containing every hash in each of the
+// provided hash sets.
+func newPrefixFilter(tfl threatsForLookup) *prefixFilter {
+	var n int
+	for _, hs := range tfl {
+		n += hs.Len()
+	}
+	nblocks := (n*filterBitsPerKey + filterBlockBits - 1) / filterBlockBits
+	if nblocks == 0 {
+		nblocks = 1
+	}
+	f := &prefixFilter{blocks: make([][filterBlockWords]uint64, nblocks)}
+	for _, hs := range tfl {
+		for _, k := range hs.h4 {
+			f.add(k)
+		}
+		for _, k := range hs.hx4 {
+			f.add(k)
+		}
+	}
+	return f
+}
+
+// filterHash mixes the 4-byte key into a 64-bit value. Although hash prefixes
+// are already uniformly distributed, mixing allows the filter to be used for
+// adversarial inputs without degrading into a single hot block.
+func filterHash(key uint32) uint64 {
+	h := uint64(key) * 0x9e3779b97f4a7c15
+	h ^= h >> 32
+	h *= 0xd6e8feb86659fd93
+	h ^= h >> 32
+	return h
+}
+
+// locate returns the block that key maps to and the hash to derive the bit
+// positions within the block from. The block is chosen by the high bits of
+// the hash, while the bit positions are taken from the low bits.
+func (f *prefixFilter) locate(key uint32) (*[filterBlockWords]uint64, uint64) {
+	h := filterHash(key)
+	i, _ := bits.Mul64(h, uint64(len(f.blocks)))
+	return &f.blocks[i], h
+}
+
+func (f *prefixFilter) add(key uint32) {
+	b, h := f.locate(key)
+	for i := 0; i < filterProbes; i++ {
+		bit := h % filterBlockBits
+		b[bit/64] |= 1 << (bit % 64)
+		h >>= 9
+	}
+}
+
+// MemoryUsage reports the number of bytes used by the filter.
+func (f *prefixFilter) MemoryUsage() int64 {
+	return int64(len(f.blocks)) * filterBlockBits / 8
+}
+
+// MayContain reports whether any hash prefix in the filter may be a prefix
+// of the given hash. A false result means that there is definitely no match.
+func (f *prefixFilter) MayContain(h hashPrefix) bool {
+	if f == nil {
+		return true
+	}
+	b, x := f.locate(binary.BigEndian.Uint32([]byte(h[:minHashPrefixLength])))
+	for i := 0; i < filterProbes; i++ {
+		bit := x % filterBlockBits
+		if b[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+		x >>= 9
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestPrefixFilter(t *testing.T) {
+	var testHashes = getTestHashes(t)
+
+	tfl := make(threatsForLookup)
+	for i, hs := range testHashes {
+		tfl[ThreatType(i)] = newHashSet(hs)
+	}
+	f := newPrefixFilter(tfl)
+
+	// There must never be any false negatives.
+	var n int
+	for _, hs := range testHashes {
+		for _, h := range hs {
+			n++
+			if !f.MayContain(h + "footer") {
+				t.Fatalf("MayContain(%q) = false, want true", h)
+			}
+		}
+	}
+
+	// The false positive rate should be close to the designed rate.
+	r := rand.New(rand.NewSource(0))
+	var fp int
+	const trials = 100000
+	for i := 0; i < trials; i++ {
+		var b [maxHashPrefixLength]byte
+		r.Read(b[:])
+		h := hashPrefix(b[:])
+		if !f.MayContain(h) {
+			continue
+		}
+		var match bool
+		for _, hs := range tfl {
+			match = match || hs.Lookup(h) > 0
+		}
+		if !match {
+			fp++
+		}
+	}
+	if rate := float64(fp) / trials; rate > 0.03 {
+		t.Errorf("false positive rate of %d hashes: got %.4f, want <= 0.03", n, rate)
+	}
+
+	// A nil or empty filter must not rule anything out incorrectly.
+	var nilFilter *prefixFilter
+	if !nilFilter.MayContain("aaaabbbb") {
+		t.Errorf("nil filter MayContain() = false, want true")
+	}
+	if newPrefixFilter(nil).MayContain("aaaabbbb") {
+		t.Errorf("empty filter MayContain() = true, want false")
+	}
+}
+
+func BenchmarkPrefixFilter(b *testing.B) {
+	var benchmarkHashes = getBenchmarkHashes(b)
+
+	var queries []hashPrefix
+	for _, h := range benchmarkHashes[1] {
+		queries = append(queries, "header"+h)
+		queries = append(queries, h+"footer")
+	}
+
+	f := newPrefixFilter(threatsForLookup{0: newHashSet(benchmarkHashes[1])})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, h := range queries {
+			f.MayContain(h)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+)
+
+// flightGroup coalesces identical hash searches that are in flight at the
+// same time, so that a burst of lookups for the same uncached hash prefix
+// results in a single API call.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is a hash search in flight. Its results are valid once done is
+// closed.
+type flightCall struct {
+	done chan struct{}
+	resp *pb.SearchHashesResponse
+	err  error
+}
+
+// flightKey identifies a hash search by its hash prefix and threat types.
+func flightKey(req *pb.SearchHashesRequest) string {
+	tts := make([]string, 0, len(req.ThreatTypes))
+	for _, tt := range req.ThreatTypes {
+		tts = append(tts, strconv.Itoa(int(tt)))
+	}
+	sort.Strings(tts)
+	return string(req.HashPrefix) + "/" + strings.Join(tts, ",")
+}
+
+// join returns the call in flight for key, or starts a new one. It reports
+// whether the call is new, in which case the caller must finish it.
+func (g *flightGroup) join(key string) (*flightCall, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if c, ok := g.calls[key]; ok {
+		return c, false
+	}
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+	return c, true
+}
+
+// finish records the results of a call started by join and wakes up the
+// callers waiting for it.
+func (g *flightGroup) finish(key string, c *flightCall, resp *pb.SearchHashesResponse, err error) {
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	c.resp, c.err = resp, err
+	close(c.done)
+}
+
+// do calls fn, unless a call for key is already in flight, in which case it
+// waits for that call to finish and returns its results instead. Waiting
+// stops early if ctx is done.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (*pb.SearchHashesResponse, error)) (*pb.SearchHashesResponse, error) {
+	c, ok := g.join(key)
+	if ok {
+		resp, err := fn()
+		g.finish(key, c, resp, err)
+		return resp, err
+	}
+	select {
+	case <-c.done:
+		return c.resp, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+)
+
+func TestFlightKey(t *testing.T) {
+	vectors := []struct {
+		req1, req2 *pb.SearchHashesRequest
+		same       bool
+	}{{
+		req1: &pb.SearchHashesRequest{HashPrefix: []byte("aaaa"), ThreatTypes: []pb.ThreatType{1, 2}},
+		req2: &pb.SearchHashesRequest{HashPrefix: []byte("aaaa"), ThreatTypes: []pb.ThreatType{2, 1}},
+		same: true,
+	}, {
+		req1: &pb.SearchHashesRequest{HashPrefix: []byte("aaaa"), ThreatTypes: []pb.ThreatType{1}},
+		req2: &pb.SearchHashesRequest{HashPrefix: []byte("aaaa"), ThreatTypes: []pb.ThreatType{1, 2}},
+		same: false,
+	}, {
+		req1: &pb.SearchHashesRequest{HashPrefix: []byte("aaaa"), ThreatTypes: []pb.ThreatType{1}},
+		req2: &pb.SearchHashesRequest{HashPrefix: []byte("aaab"), ThreatTypes: []pb.ThreatType{1}},
+		same: false,
+	}}
+	for i, v := range vectors {
+		if same := flightKey(v.req1) == flightKey(v.req2); same != v.same {
+			t.Errorf("test %d, same key = %v, want %v", i, same, v.same)
+		}
+	}
+}
+
+func TestFlightGroup(t *testing.T) {
+	var g flightGroup
+
+	c1, ok := g.join("k")
+	if !ok {
+		t.Fatalf("first join did not start a call")
+	}
+	c2, ok := g.join("k")
+	if ok || c2 != c1 {
+		t.Fatalf("second join started a new call")
+	}
+
+	// Callers that joined the call in flight get its results.
+	want := &pb.SearchHashesResponse{}
+	g.finish("k", c1, want, nil)
+	select {
+	case <-c2.done:
+		if c2.resp != want || c2.err != nil {
+			t.Errorf("call results = (%v, %v), want (%v, nil)", c2.resp, c2.err, want)
+		}
+	default:
+		t.Errorf("call not done after finish")
+	}
+
+	// Once finished, the next call is made again.
+	calls := 0
+	if _, err := g.do(context.Background(), "k", func() (*pb.SearchHashesResponse, error) {
+		calls++
+		return want, nil
+	}); err != nil || calls != 1 {
+		t.Errorf("do = %v with %d calls, want nil with 1 call", err, calls)
+	}
+
+	// Waiting stops when the context is done.
+	g.join("k")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.do(ctx, "k", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("do = %v, want %v", err, context.Canceled)
+	}
+}
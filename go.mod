@@ -6,7 +6,6 @@ require (
   github.com/google/go-cmp v0.5.5
 	github.com/rakyll/statik v0.1.7
 	golang.org/x/net v0.8.0
+	golang.org/x/text v0.8.0
 	google.golang.org/protobuf v1.29.0
 )
-
-require golang.org/x/text v0.8.0 // indirect
\ No newline at end of file
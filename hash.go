@@ -14,10 +14,15 @@
 package webrisk
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
+	"math/bits"
 	"sort"
 	"strings"
 
@@ -36,9 +41,64 @@ type hashPrefix string
 
 // hashFromPattern returns a full hash for the given URL pattern.
 func hashFromPattern(pattern string) hashPrefix {
-	hash := sha256.New()
-	hash.Write([]byte(pattern))
-	return hashPrefix(hash.Sum(nil))
+	sum := sha256.Sum256([]byte(pattern))
+	return hashPrefix(sum[:])
+}
+
+// Lengths of the hashes exchanged with the Web Risk API. A hash prefix is
+// between MinHashPrefixLength and FullHashLength bytes long.
+const (
+	MinHashPrefixLength = minHashPrefixLength
+	FullHashLength      = maxHashPrefixLength
+)
+
+// HashExpression returns the full SHA256 hash of a URL expression, such as
+// "a.b.c/1/", which must already be canonical. It produces the same hashes
+// that UpdateClient looks URLs up by, so that systems which exchange hashes
+// with it can compute them independently.
+func HashExpression(expr string) []byte {
+	return []byte(hashFromPattern(expr))
+}
+
+// HashPrefix returns the first n bytes of hash, which is the form in which
+// threat lists hold it. It reports an error if n is not a valid prefix
+// length or hash is shorter than n.
+func HashPrefix(hash []byte, n int) ([]byte, error) {
+	if n < MinHashPrefixLength || n > FullHashLength {
+		return nil, fmt.Errorf("webrisk: invalid hash prefix length: %d", n)
+	}
+	if len(hash) < n {
+		return nil, errors.New("webrisk: hash is shorter than prefix")
+	}
+	return append([]byte(nil), hash[:n]...), nil
+}
+
+// HashHasPrefix reports whether prefix is a valid hash prefix of hash.
+func HashHasPrefix(hash, prefix []byte) bool {
+	return hashPrefix(prefix).IsValid() && bytes.HasPrefix(hash, prefix)
+}
+
+// FormatHash returns hash, or a prefix of it, in lowercase hex, which is how
+// wrserver reports hashes.
+func FormatHash(hash []byte) string {
+	return hex.EncodeToString(hash)
+}
+
+// ParseHash parses a hash or hash prefix in hex, as formatted by FormatHash,
+// or in standard base64, as it appears in the JSON form of the Web Risk API.
+// Strings that are valid in both are parsed as hex. It reports an error if
+// the result is not of a valid length.
+func ParseHash(s string) ([]byte, error) {
+	h, err := hex.DecodeString(s)
+	if err != nil {
+		if h, err = base64.StdEncoding.DecodeString(s); err != nil {
+			return nil, errors.New("webrisk: hash is neither hex nor base64")
+		}
+	}
+	if !hashPrefix(h).IsValid() {
+		return nil, fmt.Errorf("webrisk: invalid hash length: %d", len(h))
+	}
+	return h, nil
 }
 
 // HasPrefix reports whether other is a prefix of h.
@@ -61,7 +121,12 @@ type hashPrefixes []hashPrefix
 func (p hashPrefixes) Len() int           { return len(p) }
 func (p hashPrefixes) Less(i, j int) bool { return p[i] < p[j] }
 func (p hashPrefixes) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
-func (p hashPrefixes) Sort()              { sort.Sort(p) }
+func (p hashPrefixes) Sort() {
+	// Lists from the API and from the database file are usually sorted.
+	if !sort.IsSorted(p) {
+		sort.Sort(p)
+	}
+}
 
 // Validate checks that the list of hash prefixes is valid. It checks the
 // following parameters:
@@ -87,66 +152,146 @@ func (p hashPrefixes) Validate() error {
 
 func (p hashPrefixes) SHA256() []byte {
 	hash := sha256.New()
+	// Hash the prefixes in chunks, rather than converting each of them.
+	buf := make([]byte, 0, 32<<10)
 	for _, b := range p {
-		hash.Write([]byte(b))
+		if len(buf)+len(b) > cap(buf) {
+			hash.Write(buf)
+			buf = buf[:0]
+		}
+		buf = append(buf, b...)
 	}
+	hash.Write(buf)
 	return hash.Sum(nil)
 }
 
+// Approximate memory costs of a single entry of a hashSet.
+const (
+	h4EntryBytes = 4
+	hxEntryBytes = 20 // String header and index key, excluding the bytes of the prefix itself
+)
+
 // hashSet is a set of hash prefixes optimized for the fact that most hashes
-// are only 4 bytes in length.
+// are only 4 bytes in length. These are kept in a sorted slice of integers,
+// and the longer ones in a sorted slice indexed by their first 4 bytes, so
+// that lookups are binary searches over contiguous memory.
 type hashSet struct {
-	h4 map[[minHashPrefixLength]byte]uint8 // Value is maximum length prefix
-	hx map[hashPrefix]struct{}
-	n  int
+	h4  []uint32     // Sorted 4-byte prefixes, as big-endian integers
+	hx  hashPrefixes // Sorted prefixes longer than 4 bytes
+	hx4 []uint32     // First 4 bytes of each prefix of hx
+	n   int
 }
 
-func byte4(h hashPrefix) (b [4]byte) {
-	b[0], b[1], b[2], b[3] = h[0], h[1], h[2], h[3]
-	return b
+// key4 returns the first 4 bytes of h as a big-endian integer, which sorts
+// like h.
+func key4(h hashPrefix) uint32 {
+	return uint32(h[0])<<24 | uint32(h[1])<<16 | uint32(h[2])<<8 | uint32(h[3])
+}
+
+// searchUint32s returns the index of the first element of the sorted a that
+// is at least k, or len(a) if there is none.
+func searchUint32s(a []uint32, k uint32) int {
+	lo, hi := 0, len(a)
+	for lo < hi {
+		m := int(uint(lo+hi) >> 1)
+		if a[m] < k {
+			lo = m + 1
+		} else {
+			hi = m
+		}
+	}
+	return lo
 }
 
 func (hs *hashSet) Len() int { return hs.n }
 
+// MemoryUsage reports the approximate number of bytes used by the hashSet.
+func (hs *hashSet) MemoryUsage() int64 {
+	n := int64(len(hs.h4)) * h4EntryBytes
+	for _, h := range hs.hx {
+		n += hxEntryBytes + int64(len(h))
+	}
+	return n
+}
+
 func (hs *hashSet) Import(phs hashPrefixes) {
-	hs.h4 = make(map[[minHashPrefixLength]byte]uint8, len(phs))
-	hs.hx = make(map[hashPrefix]struct{})
-	hs.n = len(phs)
+	var n4 int
 	for _, h := range phs {
-		n := hs.h4[byte4(h)]
-		if len(h) > int(n) {
-			hs.h4[byte4(h)] = uint8(len(h))
+		if len(h) == minHashPrefixLength {
+			n4++
 		}
-		if len(h) > 4 {
-			hs.hx[h] = struct{}{}
+	}
+	hs.h4 = make([]uint32, 0, n4)
+	hs.hx = make(hashPrefixes, 0, len(phs)-n4)
+	hs.n = len(phs)
+	for _, h := range phs {
+		if len(h) == minHashPrefixLength {
+			hs.h4 = append(hs.h4, key4(h))
+		} else {
+			key4(h) // Reject prefixes that are too short
+			hs.hx = append(hs.hx, h)
 		}
 	}
+	// The lists of the API are sorted already.
+	if !sort.SliceIsSorted(hs.h4, func(i, j int) bool { return hs.h4[i] < hs.h4[j] }) {
+		sort.Slice(hs.h4, func(i, j int) bool { return hs.h4[i] < hs.h4[j] })
+	}
+	if !sort.IsSorted(hs.hx) {
+		hs.hx.Sort()
+	}
+	hs.hx4 = make([]uint32, len(hs.hx))
+	for i, h := range hs.hx {
+		hs.hx4[i] = key4(h)
+	}
+	// Copy the longer prefixes to a slab of their own, so that they do not
+	// keep the prefixes they were decoded with from being collected.
+	var size int
+	for _, h := range hs.hx {
+		size += len(h)
+	}
+	var b strings.Builder
+	b.Grow(size)
+	for _, h := range hs.hx {
+		b.WriteString(string(h))
+	}
+	slab, off := b.String(), 0
+	for i, h := range hs.hx {
+		hs.hx[i] = hashPrefix(slab[off : off+len(h)])
+		off += len(h)
+	}
 }
 
+// Export returns the hash prefixes of the set, sorted.
 func (hs *hashSet) Export() hashPrefixes {
+	slab := make([]byte, len(hs.h4)*minHashPrefixLength)
+	for i, k := range hs.h4 {
+		binary.BigEndian.PutUint32(slab[i*minHashPrefixLength:], k)
+	}
+	h4 := appendPrefixes(make([]hashPrefix, 0, len(hs.h4)), slab, minHashPrefixLength)
 	phs := make(hashPrefixes, 0, hs.n)
-	for h, n := range hs.h4 {
-		if n == minHashPrefixLength {
-			phs = append(phs, hashPrefix(h[:]))
+	i, j := 0, 0
+	for i < len(h4) || j < len(hs.hx) {
+		if i < len(h4) && (j == len(hs.hx) || h4[i] < hs.hx[j]) {
+			phs = append(phs, h4[i])
+			i++
+			continue
 		}
-	}
-	for h := range hs.hx {
-		phs = append(phs, h)
+		phs = append(phs, hs.hx[j])
+		j++
 	}
 	return phs
 }
 
+// Lookup returns the length of the prefix of h that is in the set, or 0 if
+// there is none.
 func (hs *hashSet) Lookup(h hashPrefix) int {
-	n := int(hs.h4[byte4(h)])
-	if n <= minHashPrefixLength {
-		return n
-	}
-	if n > len(h) {
-		n = len(h)
+	k := key4(h)
+	if i := searchUint32s(hs.h4, k); i < len(hs.h4) && hs.h4[i] == k {
+		return minHashPrefixLength
 	}
-	for i := minHashPrefixLength; i <= n; i++ {
-		if _, ok := hs.hx[h[:i]]; ok {
-			return i
+	for i := searchUint32s(hs.hx4, k); i < len(hs.hx4) && hs.hx4[i] == k; i++ {
+		if p := hs.hx[i]; len(p) <= len(h) && h[:len(p)] == p {
+			return len(p)
 		}
 	}
 	return 0
@@ -155,7 +300,22 @@ func (hs *hashSet) Lookup(h hashPrefix) int {
 // decodeHashes takes a ThreatEntrySet and returns a list of hashes that should
 // be added to the local database.
 func decodeHashes(input *pb.ThreatEntryAdditions) ([]hashPrefix, error) {
-	var output []hashPrefix
+	// Size the output once, rather than growing it by copies.
+	var n int
+	for _, raw := range input.GetRawHashes() {
+		if raw != nil && raw.PrefixSize > 0 {
+			n += len(raw.RawHashes) / int(raw.PrefixSize)
+		}
+	}
+	var values []uint32
+	if input.RiceHashes != nil {
+		var err error
+		if values, err = decodeRiceIntegers(input.GetRiceHashes()); err != nil {
+			return nil, err
+		}
+		n += len(values)
+	}
+	output := make([]hashPrefix, 0, n)
 	if input.RawHashes != nil {
 		for _, raw := range input.GetRawHashes() {
 			if raw == nil {
@@ -167,32 +327,33 @@ func decodeHashes(input *pb.ThreatEntryAdditions) ([]hashPrefix, error) {
 			if len(raw.RawHashes)%int(raw.PrefixSize) != 0 {
 				return nil, errors.New("webrisk: invalid raw hashes")
 			}
-			hashes := make([]hashPrefix, len(raw.RawHashes)/int(raw.PrefixSize))
-			for i := range hashes {
-				hashes[i] = hashPrefix(raw.RawHashes[:raw.PrefixSize])
-				raw.RawHashes = raw.RawHashes[raw.PrefixSize:]
-			}
-			output = append(output, hashes...)
+			output = appendPrefixes(output, raw.RawHashes, int(raw.PrefixSize))
 		}
 	}
 
 	if input.RiceHashes != nil {
-		values, err := decodeRiceIntegers(input.GetRiceHashes())
-		if err != nil {
-			return nil, err
-		}
-		hashes := make([]hashPrefix, 0, len(values))
-		var buf [4]byte
-		for _, h := range values {
-			binary.LittleEndian.PutUint32(buf[:], h)
-			hashes = append(hashes, hashPrefix(buf[:]))
+		slab := make([]byte, len(values)*minHashPrefixLength)
+		for i, h := range values {
+			binary.LittleEndian.PutUint32(slab[i*minHashPrefixLength:], h)
 		}
-		output = append(output, hashes...)
+		output = appendPrefixes(output, slab, minHashPrefixLength)
 	}
 
 	return output, nil
 }
 
+// appendPrefixes appends to hashes the hash prefixes of size bytes that b is
+// the concatenation of. They are substrings of a single copy of b, so that a
+// full sync of millions of prefixes costs a couple of allocations instead
+// of one per prefix.
+func appendPrefixes(hashes []hashPrefix, b []byte, size int) []hashPrefix {
+	slab := string(b)
+	for off := 0; off+size <= len(slab); off += size {
+		hashes = append(hashes, hashPrefix(slab[off:off+size]))
+	}
+	return hashes
+}
+
 // decodeIndices takes a ThreatEntrySet for removals returned by the server and
 // returns a list of indices that the client should remove from its database.
 func decodeIndices(input *pb.ThreatEntryRemovals) ([]int32, error) {
@@ -227,7 +388,18 @@ func decodeRiceIntegers(rice *pb.RiceDeltaEncoding) ([]uint32, error) {
 		return nil, errors.New("webrisk: invalid k parameter")
 	}
 
-	values := []uint32{uint32(rice.FirstValue)}
+	if rice.EntryCount < 0 {
+		return nil, errors.New("webrisk: invalid entry count")
+	}
+
+	// Every value takes at least one bit, which bounds the allocation for
+	// a bogus entry count.
+	n := int(rice.EntryCount)
+	if max := 8 * len(rice.EncodedData); n > max {
+		n = max
+	}
+	values := make([]uint32, 1, n+1)
+	values[0] = uint32(rice.FirstValue)
 	br := newBitReader(rice.EncodedData)
 	rd := newRiceDecoder(br, uint32(rice.RiceParameter))
 	for i := 0; i < int(rice.EntryCount); i++ {
@@ -270,16 +442,9 @@ func newRiceDecoder(br *bitReader, k uint32) *riceDecoder {
 }
 
 func (rd *riceDecoder) ReadValue() (uint32, error) {
-	var q uint32
-	for {
-		bit, err := rd.br.ReadBits(1)
-		if err != nil {
-			return 0, err
-		}
-		q += bit
-		if bit == 0 {
-			break
-		}
+	q, err := rd.br.ReadUnary()
+	if err != nil {
+		return 0, err
 	}
 
 	r, err := rd.br.ReadBits(int(rd.k))
@@ -297,41 +462,80 @@ func (rd *riceDecoder) ReadValue() (uint32, error) {
 // bits come before the most-significant bits in the bit stream.
 //
 // This is the same bit stream format as DEFLATE (RFC 1951).
+//
+// Bits are buffered in a 64-bit accumulator that is refilled a whole word at
+// a time where possible. Bits in acc above position n may be set, but only
+// ever to the value of the bits that follow in the stream, so that refilling
+// can simply OR the next bytes in.
 type bitReader struct {
-	buf  []byte
-	mask byte
+	buf []byte // Bytes not yet loaded into acc
+	acc uint64 // Buffered bits, with the next bit in the least-significant bit
+	n   uint   // Number of valid bits in acc
 }
 
 func newBitReader(buf []byte) *bitReader {
-	return &bitReader{buf, 0x01}
+	return &bitReader{buf: buf}
+}
+
+// refill loads as many bytes from buf into acc as fit.
+func (br *bitReader) refill() {
+	if len(br.buf) >= 8 {
+		br.acc |= binary.LittleEndian.Uint64(br.buf) << br.n
+		k := (63 - br.n) / 8
+		br.buf = br.buf[k:]
+		br.n += 8 * k
+		return
+	}
+	for br.n <= 56 && len(br.buf) > 0 {
+		br.acc |= uint64(br.buf[0]) << br.n
+		br.buf = br.buf[1:]
+		br.n += 8
+	}
 }
 
 func (br *bitReader) ReadBits(n int) (uint32, error) {
 	if n < 0 || n > 32 {
 		panic("invalid number of bits")
 	}
-
-	var v uint32
-	for i := 0; i < n; i++ {
-		if len(br.buf) == 0 {
-			return v, io.ErrUnexpectedEOF
+	if n == 0 {
+		return 0, nil
+	}
+	if br.n < uint(n) {
+		br.refill()
+		if br.n < uint(n) {
+			return 0, io.ErrUnexpectedEOF
 		}
-		if br.buf[0]&br.mask > 0 {
-			v |= 1 << uint(i)
+	}
+	v := uint32(br.acc & (1<<uint(n) - 1))
+	br.acc >>= uint(n)
+	br.n -= uint(n)
+	return v, nil
+}
+
+// ReadUnary reads a unary coded value, which is the number of 1 bits
+// before the next 0 bit. The terminating 0 bit is consumed.
+func (br *bitReader) ReadUnary() (uint32, error) {
+	var q uint32
+	for {
+		if br.n == 0 {
+			br.refill()
+			if br.n == 0 {
+				return q, io.ErrUnexpectedEOF
+			}
 		}
-		br.mask <<= 1
-		if br.mask == 0 {
-			br.buf, br.mask = br.buf[1:], 0x01
+		if t := uint(bits.TrailingZeros64(^br.acc)); t < br.n {
+			q += uint32(t)
+			br.acc >>= t + 1
+			br.n -= t + 1
+			return q, nil
 		}
+		q += uint32(br.n)
+		br.acc >>= br.n
+		br.n = 0
 	}
-	return v, nil
 }
 
 // BitsRemaining reports the number of bits left to read.
 func (br *bitReader) BitsRemaining() int {
-	n := 8 * len(br.buf)
-	for m := br.mask | 1; m != 1; m >>= 1 {
-		n--
-	}
-	return n
+	return int(br.n) + 8*len(br.buf)
 }
@@ -15,11 +15,14 @@ package webrisk
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
 	"encoding/hex"
+	"math/rand"
 	"os"
 	"reflect"
 	"runtime"
+	"sort"
 	"sync"
 	"testing"
 
@@ -121,6 +124,47 @@ func TestHashFromPattern(t *testing.T) {
 	}
 }
 
+func TestHashExpression(t *testing.T) {
+	h := HashExpression("a.b.c/1/")
+	if want := hashFromPattern("a.b.c/1/"); string(h) != string(want) || len(h) != FullHashLength {
+		t.Fatalf("HashExpression = %x, want %x", h, want)
+	}
+
+	p, err := HashPrefix(h, MinHashPrefixLength)
+	if err != nil || !bytes.Equal(p, h[:4]) {
+		t.Errorf("HashPrefix = (%x, %v), want %x", p, err, h[:4])
+	}
+	if !HashHasPrefix(h, p) || HashHasPrefix(h, h[:3]) || HashHasPrefix(h, HashExpression("a.b.c/")[:4]) {
+		t.Errorf("HashHasPrefix mismatch")
+	}
+	for _, n := range []int{3, 33} {
+		if _, err := HashPrefix(h, n); err == nil {
+			t.Errorf("HashPrefix(h, %d): unexpected success", n)
+		}
+	}
+	if _, err := HashPrefix(h[:4], 8); err == nil {
+		t.Errorf("HashPrefix of a short hash: unexpected success")
+	}
+
+	vectors := []struct {
+		input  string
+		output []byte
+		fail   bool
+	}{
+		{input: FormatHash(h), output: h},
+		{input: FormatHash(p), output: p},
+		{input: "YWFhYQ==", output: []byte("aaaa")},
+		{input: "616161", fail: true},
+		{input: "not a hash", fail: true},
+	}
+	for i, v := range vectors {
+		got, err := ParseHash(v.input)
+		if (err != nil) != v.fail || !bytes.Equal(got, v.output) {
+			t.Errorf("test %d, ParseHash(%q) = (%x, %v), want %x", i, v.input, got, err, v.output)
+		}
+	}
+}
+
 func TestHashSet(t *testing.T) {
 	var testHashes = getTestHashes(t)
 
@@ -143,6 +187,9 @@ func TestHashSet(t *testing.T) {
 	}, {
 		hashes:  hashPrefixes{"abcdefgh", "abcdefgi", "abcdefgj"},
 		queries: []hashQuery{{"abcd", 0}, {"abcde", 0}, {"abcdef", 0}, {"abcdefg", 0}, {"abcdefgh", 8}, {"abcdefgz", 0}},
+	}, {
+		hashes:  hashPrefixes{"aaaa", "aaab1", "aaab2", "bbbb", "bbbc123"},
+		queries: []hashQuery{{"aaaaz", 4}, {"aaab", 0}, {"aaab2xyz", 5}, {"aaac", 0}, {"bbbb1", 4}, {"bbbc12", 0}, {"bbbc1234", 7}},
 	}}
 
 	// Add hashes based on actual test data.
@@ -225,9 +272,14 @@ func BenchmarkHashSetMemory(b *testing.B) {
 		hs.Import(benchmarkHashes[1])
 	}
 
+	b.StopTimer()
 	runtime.GC()
 	runtime.ReadMemStats(&ms2)
-	b.Logf("mem_alloc: %dB, hashes: %dx", ms2.Alloc-ms1.Alloc, len(benchmarkHashes[1]))
+	runtime.KeepAlive(hs)
+	// Only the last hashSet is still reachable.
+	retained := int64(ms2.HeapAlloc) - int64(ms1.HeapAlloc)
+	b.ReportMetric(float64(retained)/float64(len(benchmarkHashes[1])), "B/hash")
+	b.ReportMetric(float64(hs.MemoryUsage())/float64(len(benchmarkHashes[1])), "estimatedB/hash")
 }
 
 func TestDecodeHashes(t *testing.T) {
@@ -282,6 +334,10 @@ loop:
 				t.Errorf("test %d, unexpected decodeHashes error: %v", i, err)
 				continue loop
 			}
+			// Decoding leaves the input as is.
+			if again, err := decodeHashes(set); err != nil || !reflect.DeepEqual(again, hashes) {
+				t.Errorf("test %d, decodeHashes() again = %x, %v, want %x", i, again, err, hashes)
+			}
 			got = append(got, hashes...)
 		}
 
@@ -428,6 +484,132 @@ loop:
 	}
 }
 
+// riceEncode is the inverse of riceDecoder, used to generate test inputs.
+func riceEncode(values []uint32, k uint32) []byte {
+	var buf []byte
+	var nbits uint
+	put := func(bit uint32) {
+		if nbits%8 == 0 {
+			buf = append(buf, 0)
+		}
+		buf[len(buf)-1] |= byte(bit) << (nbits % 8)
+		nbits++
+	}
+	for _, v := range values {
+		for q := uint64(v) >> k; q > 0; q-- {
+			put(1)
+		}
+		put(0)
+		for i := uint32(0); i < k; i++ {
+			put(v >> i & 1)
+		}
+	}
+	return buf
+}
+
+// riceEncodePrefixes encodes the 4-byte prefixes of hashes like the API
+// does, as Rice-coded deltas between their sorted little-endian values.
+func riceEncodePrefixes(hashes []hashPrefix) *pb.RiceDeltaEncoding {
+	seen := make(map[uint32]bool)
+	var values []uint32
+	for _, h := range hashes {
+		v := binary.LittleEndian.Uint32([]byte(h[:4]))
+		if !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	deltas := make([]uint32, len(values)-1)
+	for i := range deltas {
+		deltas[i] = values[i+1] - values[i]
+	}
+	// Pick k close to log2 of the mean delta, as the API does.
+	var k uint32
+	for mean := (values[len(values)-1] - values[0]) / uint32(len(values)); mean > 1; mean >>= 1 {
+		k++
+	}
+	return &pb.RiceDeltaEncoding{
+		FirstValue:    int64(values[0]),
+		RiceParameter: int32(k),
+		EntryCount:    int32(len(deltas)),
+		EncodedData:   riceEncode(deltas, k),
+	}
+}
+
+func TestRiceDecoderRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	for _, k := range []uint32{0, 1, 2, 5, 13, 27, 28, 31} {
+		values := make([]uint32, 1000)
+		for i := range values {
+			// Keep the quotients small enough to encode in reasonable time,
+			// but large enough to span multiple words of unary bits.
+			values[i] = uint32(rng.Int63n(int64(200) << k))
+		}
+		rd := newRiceDecoder(newBitReader(riceEncode(values, k)), k)
+		for i, want := range values {
+			got, err := rd.ReadValue()
+			if err != nil {
+				t.Fatalf("k=%d, value %d, unexpected error: %v", k, i, err)
+			}
+			if got != want {
+				t.Fatalf("k=%d, value %d, ReadValue() = %d, want %d", k, i, got, want)
+			}
+		}
+		if rem := rd.br.BitsRemaining(); rem >= 8 {
+			t.Errorf("k=%d, BitsRemaining() = %d, want < 8", k, rem)
+		}
+	}
+}
+
+func BenchmarkRiceDecoder(b *testing.B) {
+	const k = 28
+	rng := rand.New(rand.NewSource(0))
+	values := make([]uint32, 100000)
+	for i := range values {
+		values[i] = uint32(rng.Int63n(1 << (k + 2)))
+	}
+	data := riceEncode(values, k)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rd := newRiceDecoder(newBitReader(data), k)
+		for range values {
+			if _, err := rd.ReadValue(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkDecodeRiceHashes measures the decoding of a full sync of a
+// million 4-byte hash prefixes, sent Rice-encoded.
+func BenchmarkDecodeRiceHashes(b *testing.B) {
+	input := &pb.ThreatEntryAdditions{RiceHashes: riceEncodePrefixes(getBenchmarkHashes(b)[1])}
+	b.SetBytes(int64(len(input.RiceHashes.EncodedData)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := decodeHashes(input); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkHashFromPattern measures the hashing of the patterns of a URL.
+func BenchmarkHashFromPattern(b *testing.B) {
+	patterns := []string{
+		"a.b.c/1/2.html?param=1",
+		"b.c/1/2.html",
+		"a.b.c/",
+		"www.example.com/some/deep/path/to/a/resource/index.html",
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		hashFromPattern(patterns[i%len(patterns)])
+	}
+}
+
 func TestBitReader(t *testing.T) {
 	vectors := []struct {
 		cnt int    // Number of bits to read
@@ -469,3 +651,20 @@ func TestBitReader(t *testing.T) {
 		t.Errorf("unexpected ReadBits success")
 	}
 }
+
+func FuzzDecodeRiceIntegers(f *testing.F) {
+	f.Add(int64(5), int32(2), int32(3), []byte{0xf7, 0x02})
+	f.Add(int64(0), int32(28), int32(2), []byte{0xbf, 0xa8, 0x3f, 0xfb, 0xfc, 0xfb, 0x5e, 0x27, 0xe6, 0xc3, 0x1d, 0xc6, 0x38})
+	f.Add(int64(-1), int32(32), int32(1), []byte{})
+	f.Fuzz(func(t *testing.T, first int64, k, n int32, data []byte) {
+		values, err := decodeRiceIntegers(&pb.RiceDeltaEncoding{
+			FirstValue:    first,
+			RiceParameter: k,
+			EntryCount:    n,
+			EncodedData:   data,
+		})
+		if err == nil && len(values) != int(n)+1 {
+			t.Errorf("decodeRiceIntegers returned %d values, want %d", len(values), int(n)+1)
+		}
+	})
+}
@@ -0,0 +1,49 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"time"
+)
+
+// LookupTrace records what a lookup did, to explain why it was slow. Pass it
+// to a lookup method with WithLookupTrace.
+type LookupTrace struct {
+	Hashes          int           // Number of hashes looked up
+	DatabaseMatches int           // Hashes that a prefix of the database matched
+	CacheHits       int           // Database matches that the cache had a result for
+	CacheMisses     int           // Database matches that the cache had no result for
+	APICalls        int           // Hash searches that were waited for, including those shared with other lookups
+	APITime         time.Duration // Time spent waiting for the hash searches
+	Unconfirmed     int           // Hash searches given up on at the Config.LookupDeadline
+}
+
+type lookupTraceKey struct{}
+
+// WithLookupTrace returns a copy of ctx that makes the lookup methods of
+// UpdateClient record what they did in trace. A trace must not be used by
+// several lookups at once.
+func WithLookupTrace(ctx context.Context, trace *LookupTrace) context.Context {
+	return context.WithValue(ctx, lookupTraceKey{}, trace)
+}
+
+// lookupTraceFrom returns the trace of ctx, or a trace that is discarded if
+// it has none.
+func lookupTraceFrom(ctx context.Context) *LookupTrace {
+	if trace, ok := ctx.Value(lookupTraceKey{}).(*LookupTrace); ok && trace != nil {
+		return trace
+	}
+	return new(LookupTrace)
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+	timepb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestLookupTrace(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	evil := hashFromPattern("evil.com/")
+	phs := hashPrefixes{evil[:minHashPrefixLength]}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: time.Now(),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	expire := timepb.New(time.Now().Add(time.Hour))
+	wr, err := NewUpdateClient(Config{
+		DBPath:      path,
+		ThreatLists: []ThreatType{ThreatTypeMalware},
+		api: &mockAPI{
+			hashLookup: func(context.Context, []byte, []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+				return &pb.SearchHashesResponse{
+					Threats: []*pb.SearchHashesResponse_ThreatHash{{
+						ThreatTypes: []pb.ThreatType{pb.ThreatType_MALWARE},
+						Hash:        []byte(evil),
+						ExpireTime:  expire,
+					}},
+					NegativeExpireTime: expire,
+				}, nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+
+	vectors := []struct {
+		url  string
+		want LookupTrace
+	}{
+		{"http://safe.com/", LookupTrace{Hashes: 1}},
+		{"http://evil.com/", LookupTrace{Hashes: 1, DatabaseMatches: 1, CacheMisses: 1, APICalls: 1}},
+		{"http://evil.com/", LookupTrace{Hashes: 1, DatabaseMatches: 1, CacheHits: 1}},
+	}
+	for i, v := range vectors {
+		var trace LookupTrace
+		if _, err := wr.LookupURLsContext(WithLookupTrace(context.Background(), &trace), []string{v.url}); err != nil {
+			t.Fatalf("test %d, unexpected lookup error: %v", i, err)
+		}
+		trace.APITime = 0 // Too short to compare.
+		if trace != v.want {
+			t.Errorf("test %d, trace = %+v, want %+v", i, trace, v.want)
+		}
+	}
+}
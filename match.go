@@ -0,0 +1,156 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// parallelFor calls f for each of 0 to n-1 from up to GOMAXPROCS goroutines,
+// until f returns false, after which no more calls are started.
+func parallelFor(n int, f func(i int) bool) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > n {
+		workers = n
+	}
+	var next int64 = -1
+	var stop uint32
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadUint32(&stop) == 0 {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= n {
+					return
+				}
+				if !f(i) {
+					atomic.StoreUint32(&stop, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// probe is what the database and the cache know about a hash that a lookup
+// is made by.
+type probe struct {
+	hash    hashPrefix
+	pattern string
+
+	partial hashPrefix   // Prefix of hash in the database, if full
+	unsure  []ThreatType // Threat lists of the database that match hash
+
+	// cached and cr are the result of the cache for a full hash that the
+	// database matches.
+	cached map[ThreatType]bool
+	cr     cacheResult
+}
+
+// probe looks up hash in the database and, if it matches a full hash there,
+// in the cache, refreshing the cached result if it is about to expire.
+func (wr *UpdateClient) probe(hash hashPrefix, pattern string) probe {
+	p := probe{hash: hash, pattern: pattern, cr: cacheMiss}
+	if !hash.IsFull() {
+		_, p.unsure = wr.db.LookupPrefix(hash)
+		return p
+	}
+	p.partial, p.unsure = wr.db.Lookup(hash)
+	if len(p.unsure) == 0 {
+		return p
+	}
+	var expire time.Time
+	p.cached, p.cr, expire = wr.c.lookup(hash, p.partial)
+	if p.cr == positiveCacheHit || p.cr == negativeCacheHit {
+		wr.refreshIfExpiring(p.partial, p.unsure, expire)
+	}
+	return p
+}
+
+// knownThreats returns the threat types that p is known to be without asking
+// the API.
+func (wr *UpdateClient) knownThreats(p probe) []ThreatType {
+	if len(p.unsure) == 0 {
+		return nil
+	}
+	if wr.config.ReadOnly {
+		return p.unsure
+	}
+	if p.cr != positiveCacheHit {
+		return nil
+	}
+	var tds []ThreatType
+	for _, td := range p.unsure {
+		if p.cached[td] {
+			tds = append(tds, td)
+		}
+	}
+	return tds
+}
+
+// probeAll probes the hashes that a lookup of one input is made by. If there
+// are at least Config.ParallelMatchThreshold of them, they are probed in
+// parallel, until the input is known to be a threat of every type of
+// Config.ThreatLists, in which case it reports true and only some hashes may
+// have been probed. The other hashes could then only add patterns, not threat
+// types, to the result.
+func (wr *UpdateClient) probeAll(hashes map[hashPrefix]string) (probes []probe, threat bool) {
+	if wr.config.ParallelMatchThreshold <= 0 || len(hashes) < wr.config.ParallelMatchThreshold {
+		for hash, pattern := range hashes {
+			probes = append(probes, wr.probe(hash, pattern))
+		}
+		return probes, false
+	}
+	all := make([]probe, 0, len(hashes))
+	for hash, pattern := range hashes {
+		all = append(all, probe{hash: hash, pattern: pattern})
+	}
+	done := make([]bool, len(all))
+	var mu sync.Mutex
+	var known map[ThreatType]bool
+	parallelFor(len(all), func(i int) bool {
+		all[i] = wr.probe(all[i].hash, all[i].pattern)
+		done[i] = true
+		tds := wr.knownThreats(all[i])
+		if len(tds) == 0 {
+			return true
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if known == nil {
+			known = make(map[ThreatType]bool)
+		}
+		for _, td := range tds {
+			known[td] = true
+		}
+		for _, td := range wr.config.ThreatLists {
+			if !known[td] {
+				return true
+			}
+		}
+		threat = true
+		return false
+	})
+	for i, p := range all {
+		if done[i] {
+			probes = append(probes, p)
+		}
+	}
+	return probes, threat
+}
@@ -0,0 +1,200 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	timepb "google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+)
+
+func TestParallelFor(t *testing.T) {
+	for _, n := range []int{0, 1, 7, 100} {
+		calls := make([]int32, n)
+		parallelFor(n, func(i int) bool {
+			atomic.AddInt32(&calls[i], 1)
+			return true
+		})
+		for i, c := range calls {
+			if c != 1 {
+				t.Errorf("parallelFor(%d) called f(%d) %d times, want 1", n, i, c)
+			}
+		}
+	}
+
+	var calls int32
+	parallelFor(1000, func(i int) bool {
+		atomic.AddInt32(&calls, 1)
+		return false
+	})
+	if calls == 0 || calls >= 1000 {
+		t.Errorf("parallelFor called f %d times after it returned false", calls)
+	}
+}
+
+func TestParallelMatch(t *testing.T) {
+	evil := hashFromPattern("evil.com/")
+	deep := hashFromPattern("evil.com/a/b/c/")
+	phs := hashPrefixes{evil[:minHashPrefixLength], deep[:minHashPrefixLength]}
+	phs.Sort()
+
+	vectors := []struct {
+		threshold int
+		lists     []ThreatType
+		threats   []URLThreat
+		source    LookupSource
+		apiCalls  int64
+	}{{
+		// Every expression is matched, and the API is asked about the deep one.
+		threshold: 0,
+		threats: []URLThreat{
+			{Pattern: "evil.com/", ThreatType: ThreatTypeMalware},
+			{Pattern: "evil.com/a/b/c/", ThreatType: ThreatTypeMalware},
+		},
+		source:   SourceAPI,
+		apiCalls: 1,
+	}, {
+		// Expressions are matched in parallel, and the cached threat is
+		// enough.
+		threshold: 2,
+		threats:   []URLThreat{{Pattern: "evil.com/", ThreatType: ThreatTypeMalware}},
+		source:    SourceCache,
+	}, {
+		// The cached threat leaves social engineering unsettled, so every
+		// expression is matched like one at a time.
+		threshold: 2,
+		lists:     []ThreatType{ThreatTypeMalware, ThreatTypeSocialEngineering},
+		threats: []URLThreat{
+			{Pattern: "evil.com/", ThreatType: ThreatTypeMalware},
+			{Pattern: "evil.com/a/b/c/", ThreatType: ThreatTypeMalware},
+		},
+		source:   SourceAPI,
+		apiCalls: 1,
+	}, {
+		// The URL has fewer expressions than the threshold.
+		threshold: 100,
+		threats: []URLThreat{
+			{Pattern: "evil.com/", ThreatType: ThreatTypeMalware},
+			{Pattern: "evil.com/a/b/c/", ThreatType: ThreatTypeMalware},
+		},
+		source:   SourceAPI,
+		apiCalls: 1,
+	}}
+	for i, v := range vectors {
+		path := mustGetTempFile(t)
+		defer os.Remove(path)
+		dbf := databaseFormat{
+			Table: threatsForUpdate{
+				ThreatTypeMalware:           partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+				ThreatTypeSocialEngineering: partialHashes{SHA256: hashPrefixes{}.SHA256(), State: []byte("state")},
+			},
+			Time: time.Now(),
+		}
+		if err := saveDatabase(path, dbf); err != nil {
+			t.Fatalf("unexpected save error: %v", err)
+		}
+		if v.lists == nil {
+			v.lists = []ThreatType{ThreatTypeMalware}
+		}
+		expire := timepb.New(time.Now().Add(time.Hour))
+		var apiCalls int64
+		wr, err := NewUpdateClient(Config{
+			DBPath:                 path,
+			ThreatLists:            v.lists,
+			ParallelMatchThreshold: v.threshold,
+			api: &mockAPI{
+				hashLookup: func(_ context.Context, prefix []byte, _ []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+					atomic.AddInt64(&apiCalls, 1)
+					resp := &pb.SearchHashesResponse{NegativeExpireTime: expire}
+					for _, h := range []hashPrefix{evil, deep} {
+						if bytes.HasPrefix([]byte(h), prefix) {
+							resp.Threats = append(resp.Threats, &pb.SearchHashesResponse_ThreatHash{
+								ThreatTypes: []pb.ThreatType{pb.ThreatType_MALWARE},
+								Hash:        []byte(h),
+								ExpireTime:  expire,
+							})
+						}
+					}
+					return resp, nil
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("test %d, unexpected error: %v", i, err)
+		}
+
+		// Cache the threat of the host.
+		if _, err := wr.LookupURLs([]string{"http://evil.com/"}); err != nil {
+			t.Fatalf("test %d, unexpected lookup error: %v", i, err)
+		}
+		atomic.StoreInt64(&apiCalls, 0)
+
+		threats, sources, err := wr.LookupURLsSources(context.Background(), []string{"http://evil.com/a/b/c/d.html"})
+		if err != nil {
+			t.Fatalf("test %d, unexpected lookup error: %v", i, err)
+		}
+		got := threats[0]
+		sort.Slice(got, func(i, j int) bool { return got[i].Pattern < got[j].Pattern })
+		if !cmp.Equal(got, v.threats) {
+			t.Errorf("test %d, threats = %v, want %v", i, got, v.threats)
+		}
+		if sources[0] != v.source {
+			t.Errorf("test %d, source = %v, want %v", i, sources[0], v.source)
+		}
+		if n := atomic.LoadInt64(&apiCalls); n != v.apiCalls {
+			t.Errorf("test %d, %d API calls, want %d", i, n, v.apiCalls)
+		}
+		wr.Close()
+	}
+}
+
+// BenchmarkProbeAll compares probing the expressions of a deep URL one at a
+// time and in parallel, against a database of a million hash prefixes.
+// Run it with -cpu to see how the parallel probing scales.
+func BenchmarkProbeAll(b *testing.B) {
+	tfl := make(threatsForLookup)
+	for i, hs := range getBenchmarkHashes(b) {
+		tfl[ThreatType(i)] = newHashSet(hs)
+	}
+	hashes, err := urlParser{}.generateHashes("http://a.b.c.d.e.f.g/1/2/3/4/5/6/7/8.html?q=1")
+	if err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+	for _, v := range []struct {
+		name      string
+		threshold int
+	}{
+		{"Serial", 0},
+		{"Parallel", 2},
+	} {
+		b.Run(v.name, func(b *testing.B) {
+			wr := &UpdateClient{config: Config{ParallelMatchThreshold: v.threshold}}
+			wr.db.table.Store(newLookupTable(tfl))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				wr.probeAll(hashes)
+			}
+		})
+	}
+}
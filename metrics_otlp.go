@@ -0,0 +1,276 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MetricsRecorder records the statistics of an UpdateClient, such as by
+// pushing them to a monitoring system. See Config.MetricsRecorder.
+type MetricsRecorder interface {
+	// RecordStats records stats, whose counters are cumulative since the
+	// UpdateClient was created.
+	RecordStats(ctx context.Context, stats Stats) error
+}
+
+// DefaultOTLPServiceName is the default service.name resource attribute of
+// the metrics pushed by an OTLPRecorder.
+const DefaultOTLPServiceName = "webrisk"
+
+// OTLPRecorder is a MetricsRecorder that pushes the statistics to an
+// OpenTelemetry collector, or any other receiver of the OTLP/HTTP protocol,
+// with the JSON encoding. The metrics are those that wrserver exposes to
+// Prometheus, named according to the OpenTelemetry conventions, such as
+// webrisk.queries for webrisk_queries_total.
+type OTLPRecorder struct {
+	// Endpoint is the URL that metrics are posted to, such as
+	// http://localhost:4318/v1/metrics.
+	Endpoint string
+
+	// Headers are added to every request, such as to authenticate.
+	Headers map[string]string
+
+	// Attributes are the attributes of the resource that the metrics are
+	// about, such as service.instance.id. The service.name attribute
+	// defaults to DefaultOTLPServiceName.
+	Attributes map[string]string
+
+	// Client is used to make requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	mu    sync.Mutex
+	start time.Time // Start of the cumulative counters
+	now   func() time.Time
+}
+
+// NewOTLPRecorder returns an OTLPRecorder that posts metrics to endpoint.
+// If endpoint has no path, the standard /v1/metrics path is used, so that
+// the base URL of a collector, such as http://localhost:4318, is enough.
+func NewOTLPRecorder(endpoint string) (*OTLPRecorder, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("webrisk: invalid OTLP endpoint: %q", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/metrics"
+	}
+	return &OTLPRecorder{Endpoint: u.String(), start: time.Now()}, nil
+}
+
+// RecordStats posts stats to r.Endpoint.
+func (r *OTLPRecorder) RecordStats(ctx context.Context, stats Stats) error {
+	body, err := json.Marshal(r.request(stats))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", r.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webrisk: OTLP export failed: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// The messages of the OTLP/HTTP JSON encoding that are needed to export
+// metrics. Integers of 64 bits are encoded as strings, as in the JSON
+// mapping of protocol buffers.
+type (
+	otlpRequest struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+
+	otlpResourceMetrics struct {
+		Resource     otlpResource       `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+
+	otlpScopeMetrics struct {
+		Scope   otlpScope    `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+
+	otlpMetric struct {
+		Name        string     `json:"name"`
+		Description string     `json:"description"`
+		Unit        string     `json:"unit"`
+		Sum         *otlpSum   `json:"sum,omitempty"`
+		Gauge       *otlpGauge `json:"gauge,omitempty"`
+	}
+
+	otlpSum struct {
+		DataPoints             []otlpDataPoint `json:"dataPoints"`
+		AggregationTemporality int             `json:"aggregationTemporality"`
+		IsMonotonic            bool            `json:"isMonotonic"`
+	}
+
+	otlpGauge struct {
+		DataPoints []otlpDataPoint `json:"dataPoints"`
+	}
+
+	otlpDataPoint struct {
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+		TimeUnixNano      string          `json:"timeUnixNano"`
+		AsInt             string          `json:"asInt,omitempty"`
+		AsDouble          *float64        `json:"asDouble,omitempty"`
+	}
+
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+)
+
+// otlpCumulative is the AGGREGATION_TEMPORALITY_CUMULATIVE value of OTLP.
+const otlpCumulative = 2
+
+// request returns the OTLP request that exports stats.
+func (r *OTLPRecorder) request(stats Stats) *otlpRequest {
+	r.mu.Lock()
+	now := time.Now()
+	if r.now != nil {
+		now = r.now()
+	}
+	if r.start.IsZero() {
+		r.start = now
+	}
+	start := r.start
+	r.mu.Unlock()
+
+	unixNano := func(t time.Time) string { return strconv.FormatInt(t.UnixNano(), 10) }
+	point := func(key, value string, v int64) otlpDataPoint {
+		p := otlpDataPoint{TimeUnixNano: unixNano(now), AsInt: strconv.FormatInt(v, 10)}
+		if key != "" {
+			p.Attributes = []otlpAttribute{{key, otlpValue{value}}}
+		}
+		return p
+	}
+	sum := func(name, desc, unit string, points ...otlpDataPoint) otlpMetric {
+		for i := range points {
+			points[i].StartTimeUnixNano = unixNano(start)
+		}
+		return otlpMetric{Name: name, Description: desc, Unit: unit, Sum: &otlpSum{
+			DataPoints:             points,
+			AggregationTemporality: otlpCumulative,
+			IsMonotonic:            true,
+		}}
+	}
+	gauge := func(name, desc, unit string, points ...otlpDataPoint) otlpMetric {
+		return otlpMetric{Name: name, Description: desc, Unit: unit, Gauge: &otlpGauge{DataPoints: points}}
+	}
+
+	lag := stats.DatabaseUpdateLag.Seconds()
+	lagPoint := otlpDataPoint{TimeUnixNano: unixNano(now), AsDouble: &lag}
+	age := stats.DatabaseAge.Seconds()
+	agePoint := otlpDataPoint{TimeUnixNano: unixNano(now), AsDouble: &age}
+	metrics := []otlpMetric{
+		sum("webrisk.queries", "Number of URL hash lookups, by what satisfied them.", "{query}",
+			point("source", "database", stats.QueriesByDatabase),
+			point("source", "cache", stats.QueriesByCache),
+			point("source", "api", stats.QueriesByAPI),
+			point("source", "fail", stats.QueriesFail),
+			point("source", "unconfirmed", stats.QueriesUnconfirmed)),
+		sum("webrisk.cache.lookups", "Number of cache lookups, by result.", "{lookup}",
+			point("result", "positive_hit", stats.CachePositiveHits),
+			point("result", "negative_hit", stats.CacheNegativeHits),
+			point("result", "miss", stats.CacheMisses)),
+		sum("webrisk.cache.expired", "Number of cache misses due to an expired entry.", "{lookup}",
+			point("", "", stats.CacheExpired)),
+		sum("webrisk.cache.evictions", "Number of cache entries evicted before they expired.", "{entry}",
+			point("", "", stats.CacheEvictions)),
+		sum("webrisk.cache.refreshes", "Number of cached results refreshed before they expired.", "{entry}",
+			point("", "", stats.CacheRefreshes)),
+		gauge("webrisk.cache.entries", "Number of entries in the in-memory cache, by kind.", "{entry}",
+			point("kind", "positive", int64(stats.CachePositiveEntries)),
+			point("kind", "negative", int64(stats.CacheNegativeEntries))),
+		gauge("webrisk.cache.memory", "Approximate number of bytes used by the cache.", "By",
+			point("", "", stats.CacheMemory)),
+		gauge("webrisk.database.memory", "Approximate number of bytes used by the database.", "By",
+			point("", "", stats.DatabaseMemory)),
+		gauge("webrisk.database.update_lag", "Time since the last missed database update.", "s", lagPoint),
+		gauge("webrisk.database.age", "Time since the last successful database update.", "s", agePoint),
+	}
+	if len(stats.Lists) > 0 {
+		var points []otlpDataPoint
+		for td, ls := range stats.Lists {
+			points = append(points, point("threat_type", td.String(), int64(ls.Entries)))
+		}
+		sort.Slice(points, func(i, j int) bool {
+			return points[i].Attributes[0].Value.StringValue < points[j].Attributes[0].Value.StringValue
+		})
+		metrics = append(metrics, gauge("webrisk.list.entries", "Number of hash prefixes in each threat list.", "{entry}", points...))
+	}
+
+	attrs := map[string]string{"service.name": DefaultOTLPServiceName}
+	for k, v := range r.Attributes {
+		attrs[k] = v
+	}
+	var resource otlpResource
+	for k, v := range attrs {
+		resource.Attributes = append(resource.Attributes, otlpAttribute{k, otlpValue{v}})
+	}
+	sort.Slice(resource.Attributes, func(i, j int) bool {
+		return resource.Attributes[i].Key < resource.Attributes[j].Key
+	})
+	return &otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: resource,
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: modulePath, Version: Version()},
+			Metrics: metrics,
+		}},
+	}}}
+}
@@ -0,0 +1,196 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNewOTLPRecorder(t *testing.T) {
+	vectors := []struct {
+		endpoint string
+		want     string // Empty if invalid
+	}{
+		{"http://localhost:4318", "http://localhost:4318/v1/metrics"},
+		{"https://collector.example/", "https://collector.example/v1/metrics"},
+		{"http://localhost:4318/otlp/v1/metrics", "http://localhost:4318/otlp/v1/metrics"},
+		{"localhost:4318", ""},
+		{"ftp://localhost/", ""},
+	}
+	for i, v := range vectors {
+		r, err := NewOTLPRecorder(v.endpoint)
+		if v.want == "" {
+			if err == nil {
+				t.Errorf("test %d, unexpected success", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d, unexpected error: %v", i, err)
+			continue
+		}
+		if r.Endpoint != v.want {
+			t.Errorf("test %d, Endpoint = %q, want %q", i, r.Endpoint, v.want)
+		}
+	}
+}
+
+func TestOTLPRecorder(t *testing.T) {
+	var got otlpRequest
+	var auth string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	r, err := NewOTLPRecorder(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r.Headers = map[string]string{"Authorization": "Bearer token"}
+	r.Attributes = map[string]string{"service.name": "wrserver", "webrisk.tenant": "acme"}
+	r.start = time.Unix(100, 0)
+	r.now = func() time.Time { return time.Unix(160, 0) }
+
+	stats := Stats{
+		QueriesByDatabase: 132,
+		QueriesByAPI:      6,
+		DatabaseUpdateLag: 90 * time.Second,
+		Lists: map[ThreatType]ListStats{
+			ThreatTypeSocialEngineering: {Entries: 20},
+			ThreatTypeMalware:           {Entries: 10},
+		},
+	}
+	if err := r.RecordStats(context.Background(), stats); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if auth != "Bearer token" {
+		t.Errorf("Authorization = %q, want the configured header", auth)
+	}
+	if len(got.ResourceMetrics) != 1 || len(got.ResourceMetrics[0].ScopeMetrics) != 1 {
+		t.Fatalf("unexpected request: %+v", got)
+	}
+	attrs := []otlpAttribute{{"service.name", otlpValue{"wrserver"}}, {"webrisk.tenant", otlpValue{"acme"}}}
+	if !cmp.Equal(got.ResourceMetrics[0].Resource.Attributes, attrs) {
+		t.Errorf("resource attributes = %v, want %v", got.ResourceMetrics[0].Resource.Attributes, attrs)
+	}
+	metrics := make(map[string]otlpMetric)
+	for _, m := range got.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+
+	queries := metrics["webrisk.queries"].Sum
+	if queries == nil || queries.AggregationTemporality != otlpCumulative || !queries.IsMonotonic || len(queries.DataPoints) != 5 {
+		t.Fatalf("webrisk.queries = %+v, want a cumulative sum of 5 points", queries)
+	}
+	want := otlpDataPoint{
+		Attributes:        []otlpAttribute{{"source", otlpValue{"database"}}},
+		StartTimeUnixNano: "100000000000",
+		TimeUnixNano:      "160000000000",
+		AsInt:             "132",
+	}
+	if !cmp.Equal(queries.DataPoints[0], want) {
+		t.Errorf("webrisk.queries point = %+v, want %+v", queries.DataPoints[0], want)
+	}
+	if lag := metrics["webrisk.database.update_lag"].Gauge; lag == nil || lag.DataPoints[0].AsDouble == nil || *lag.DataPoints[0].AsDouble != 90 {
+		t.Errorf("webrisk.database.update_lag = %+v, want 90s", lag)
+	}
+	var lists []string
+	for _, p := range metrics["webrisk.list.entries"].Gauge.DataPoints {
+		lists = append(lists, p.Attributes[0].Value.StringValue+"="+p.AsInt)
+	}
+	if want := []string{"MALWARE=10", "SOCIAL_ENGINEERING=20"}; !cmp.Equal(lists, want) {
+		t.Errorf("webrisk.list.entries = %v, want %v", lists, want)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := r.RecordStats(context.Background(), stats); err == nil {
+		t.Errorf("unexpected success when the collector fails")
+	}
+}
+
+// statsRecorder is a MetricsRecorder that keeps the stats it is given.
+type statsRecorder struct {
+	mu    sync.Mutex
+	stats []Stats
+}
+
+func (sr *statsRecorder) RecordStats(ctx context.Context, stats Stats) error {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	sr.stats = append(sr.stats, stats)
+	return nil
+}
+
+func (sr *statsRecorder) len() int {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	return len(sr.stats)
+}
+
+func TestMetricsRecorder(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+	phs := hashPrefixes{hashFromPattern("evil.com/")[:minHashPrefixLength]}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: time.Now(),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	sr := &statsRecorder{}
+	wr, err := NewUpdateClient(Config{
+		DBPath:          path,
+		ThreatLists:     []ThreatType{ThreatTypeMalware},
+		ReadOnly:        true,
+		MetricsRecorder: sr,
+		MetricsPeriod:   time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for start := time.Now(); sr.len() == 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("stats were never recorded")
+		}
+	}
+	// The last stats are recorded when the client is closed.
+	n := sr.len()
+	wr.Close()
+	if sr.len() <= n {
+		t.Errorf("stats were not recorded when the client was closed")
+	}
+}
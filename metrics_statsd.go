@@ -0,0 +1,234 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultStatsDPrefix is the default prefix of the names of the metrics that
+// a StatsDRecorder sends.
+const DefaultStatsDPrefix = "webrisk."
+
+// statsdMaxPacket is the maximum size of the datagrams sent to a StatsD
+// server, which keeps them within the MTU of most networks.
+const statsdMaxPacket = 1432
+
+// StatsDRecorder is a MetricsRecorder that sends the statistics to a StatsD
+// server, or to a DogStatsD agent, over UDP. Counters are sent as the
+// increments since the previous recording, along with the ratio of cache
+// lookups that hit during that time, and the duration of each threat list
+// update is sent as a timing once the update is done.
+//
+// The dimensions of a metric, such as the source of queries or the threat
+// list of an update, are appended to its name for StatsD, such as
+// webrisk.queries.cache, and sent as tags for DogStatsD, such as
+// webrisk.queries with the source:cache tag.
+type StatsDRecorder struct {
+	// Addr is the host:port UDP address of the server.
+	Addr string
+
+	// DogStatsD makes dimensions be sent as tags.
+	DogStatsD bool
+
+	// Prefix is prepended to the name of every metric. If empty,
+	// DefaultStatsDPrefix is used.
+	Prefix string
+
+	// Tags, such as "env:prod", are added to every metric sent to
+	// DogStatsD. They are ignored by plain StatsD.
+	Tags []string
+
+	mu      sync.Mutex
+	conn    net.Conn
+	counts  map[string]int64         // Counters as of the previous recording
+	updates map[ThreatType]time.Time // Threat list updates already timed
+}
+
+// NewStatsDRecorder returns a StatsDRecorder for a URL of the form
+// "statsd://host[:port]", or "dogstatsd://host[:port]" for a DogStatsD
+// agent. The port defaults to 8125.
+func NewStatsDRecorder(rawurl string) (*StatsDRecorder, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	sr := &StatsDRecorder{Addr: u.Host}
+	switch u.Scheme {
+	case "statsd":
+	case "dogstatsd":
+		sr.DogStatsD = true
+	default:
+		return nil, errors.New("webrisk: invalid StatsD URL scheme: " + u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("webrisk: missing StatsD host: " + rawurl)
+	}
+	if u.Port() == "" {
+		sr.Addr = net.JoinHostPort(u.Hostname(), "8125")
+	}
+	return sr, nil
+}
+
+// statsdMetric is a single value to send, with an optional dimension.
+type statsdMetric struct {
+	name, key, value string
+	kind             string // c, g, or ms
+}
+
+// RecordStats sends the metrics for stats that changed since the previous
+// recording.
+func (sr *StatsDRecorder) RecordStats(ctx context.Context, stats Stats) error {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.counts == nil {
+		sr.counts = make(map[string]int64)
+		sr.updates = make(map[ThreatType]time.Time)
+	}
+
+	var ms []statsdMetric
+	count := func(name, key string, v int64) int64 {
+		id := name + "." + key
+		d := v - sr.counts[id]
+		if d < 0 {
+			d = v // The counter was reset.
+		}
+		sr.counts[id] = v
+		if d > 0 {
+			ms = append(ms, statsdMetric{name, key, fmt.Sprint(d), "c"})
+		}
+		return d
+	}
+	gauge := func(name, key string, v interface{}) {
+		ms = append(ms, statsdMetric{name, key, fmt.Sprint(v), "g"})
+	}
+
+	count("queries", "source:database", stats.QueriesByDatabase)
+	count("queries", "source:cache", stats.QueriesByCache)
+	count("queries", "source:api", stats.QueriesByAPI)
+	count("queries", "source:fail", stats.QueriesFail)
+	count("queries", "source:unconfirmed", stats.QueriesUnconfirmed)
+	hits := count("cache.lookups", "result:positive_hit", stats.CachePositiveHits) +
+		count("cache.lookups", "result:negative_hit", stats.CacheNegativeHits)
+	misses := count("cache.lookups", "result:miss", stats.CacheMisses)
+	if hits+misses > 0 {
+		gauge("cache.hit_ratio", "", float64(hits)/float64(hits+misses))
+	}
+	count("cache.expired", "", stats.CacheExpired)
+	count("cache.evictions", "", stats.CacheEvictions)
+	count("cache.refreshes", "", stats.CacheRefreshes)
+	gauge("cache.entries", "kind:positive", stats.CachePositiveEntries)
+	gauge("cache.entries", "kind:negative", stats.CacheNegativeEntries)
+	gauge("cache.memory_bytes", "", stats.CacheMemory)
+	gauge("database.memory_bytes", "", stats.DatabaseMemory)
+	gauge("database.update_lag_seconds", "", stats.DatabaseUpdateLag.Seconds())
+	gauge("database.age_seconds", "", stats.DatabaseAge.Seconds())
+
+	tds := make([]ThreatType, 0, len(stats.Lists))
+	for td := range stats.Lists {
+		tds = append(tds, td)
+	}
+	sort.Slice(tds, func(i, j int) bool { return tds[i] < tds[j] })
+	for _, td := range tds {
+		ls := stats.Lists[td]
+		key := "threat_type:" + td.String()
+		gauge("list.entries", key, ls.Entries)
+		count("list.checksum_failures", key, ls.ChecksumFailures)
+		if !ls.LastUpdate.IsZero() && !ls.LastUpdate.Equal(sr.updates[td]) {
+			sr.updates[td] = ls.LastUpdate
+			ms = append(ms, statsdMetric{"list.update_duration", key, fmt.Sprint(ls.LastUpdateDuration.Milliseconds()), "ms"})
+		}
+	}
+	return sr.send(ctx, ms)
+}
+
+// send sends ms in as few datagrams as possible.
+//
+// This assumes that the sr.mu lock is already held.
+func (sr *StatsDRecorder) send(ctx context.Context, ms []statsdMetric) error {
+	if sr.conn == nil {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "udp", sr.Addr)
+		if err != nil {
+			return err
+		}
+		sr.conn = conn
+	}
+	prefix := sr.Prefix
+	if prefix == "" {
+		prefix = DefaultStatsDPrefix
+	}
+	var packet []byte
+	flush := func() error {
+		if len(packet) == 0 {
+			return nil
+		}
+		_, err := sr.conn.Write(packet)
+		packet = packet[:0]
+		return err
+	}
+	for _, m := range ms {
+		line := sr.format(prefix, m)
+		if len(packet) > 0 && len(packet)+1+len(line) > statsdMaxPacket {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, line...)
+	}
+	return flush()
+}
+
+// format returns the line of the StatsD protocol for m.
+func (sr *StatsDRecorder) format(prefix string, m statsdMetric) string {
+	if !sr.DogStatsD {
+		name := prefix + m.name
+		if _, v, ok := strings.Cut(m.key, ":"); ok {
+			name += "." + v
+		}
+		return fmt.Sprintf("%s:%s|%s", name, m.value, m.kind)
+	}
+	tags := sr.Tags
+	if m.key != "" {
+		tags = append([]string{m.key}, sr.Tags...)
+	}
+	line := fmt.Sprintf("%s%s:%s|%s", prefix, m.name, m.value, m.kind)
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+	return line
+}
+
+// Close closes the connection to the server, if any.
+func (sr *StatsDRecorder) Close() error {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.conn == nil {
+		return nil
+	}
+	err := sr.conn.Close()
+	sr.conn = nil
+	return err
+}
@@ -0,0 +1,158 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewStatsDRecorder(t *testing.T) {
+	vectors := []struct {
+		url  string
+		addr string // Empty if invalid
+		dog  bool
+	}{
+		{"statsd://localhost", "localhost:8125", false},
+		{"dogstatsd://10.0.0.1:9125", "10.0.0.1:9125", true},
+		{"udp://localhost:8125", "", false},
+		{"statsd://", "", false},
+	}
+	for i, v := range vectors {
+		sr, err := NewStatsDRecorder(v.url)
+		if v.addr == "" {
+			if err == nil {
+				t.Errorf("test %d, unexpected success", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d, unexpected error: %v", i, err)
+			continue
+		}
+		if sr.Addr != v.addr || sr.DogStatsD != v.dog {
+			t.Errorf("test %d, recorder = %+v, want address %s and DogStatsD %v", i, sr, v.addr, v.dog)
+		}
+	}
+}
+
+func TestStatsDRecorder(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer pc.Close()
+	// receive returns the lines of the datagrams sent by a recording.
+	receive := func() []string {
+		var lines []string
+		buf := make([]byte, 2*statsdMaxPacket)
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n > statsdMaxPacket {
+				t.Errorf("datagram of %d bytes, want at most %d", n, statsdMaxPacket)
+			}
+			lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+			// The metrics of the threat lists are sent last.
+			if strings.Contains(string(buf[:n]), "list.entries") {
+				return lines
+			}
+		}
+	}
+	contains := func(lines []string, want string) bool {
+		for _, l := range lines {
+			if l == want {
+				return true
+			}
+		}
+		return false
+	}
+
+	update := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	stats := Stats{
+		QueriesByDatabase: 10,
+		CachePositiveHits: 1,
+		CacheNegativeHits: 2,
+		CacheMisses:       1,
+		Lists: map[ThreatType]ListStats{
+			ThreatTypeMalware: {Entries: 42, LastUpdate: update, LastUpdateDuration: 1500 * time.Millisecond},
+		},
+	}
+	vectors := []struct {
+		dog       bool
+		tags      []string
+		want      []string
+		wantN     []string // Lines expected not to be sent
+		increment string   // Line expected for 5 more queries
+	}{{
+		want: []string{
+			"webrisk.queries.database:10|c",
+			"webrisk.cache.lookups.positive_hit:1|c",
+			"webrisk.cache.hit_ratio:0.75|g",
+			"webrisk.list.entries.MALWARE:42|g",
+			"webrisk.list.update_duration.MALWARE:1500|ms",
+		},
+		wantN:     []string{"webrisk.queries.api:0|c"},
+		increment: "webrisk.queries.database:5|c",
+	}, {
+		dog:  true,
+		tags: []string{"env:prod"},
+		want: []string{
+			"webrisk.queries:10|c|#source:database,env:prod",
+			"webrisk.cache.memory_bytes:0|g|#env:prod",
+			"webrisk.list.update_duration:1500|ms|#threat_type:MALWARE,env:prod",
+		},
+		increment: "webrisk.queries:5|c|#source:database,env:prod",
+	}}
+	for i, v := range vectors {
+		sr := &StatsDRecorder{Addr: pc.LocalAddr().String(), DogStatsD: v.dog, Tags: v.tags}
+		if err := sr.RecordStats(context.Background(), stats); err != nil {
+			t.Fatalf("test %d, unexpected error: %v", i, err)
+		}
+		lines := receive()
+		for _, want := range v.want {
+			if !contains(lines, want) {
+				t.Errorf("test %d, missing %q in %q", i, want, lines)
+			}
+		}
+		for _, want := range v.wantN {
+			if contains(lines, want) {
+				t.Errorf("test %d, unexpected %q", i, want)
+			}
+		}
+
+		// Only what changed since is sent again.
+		stats2 := stats
+		stats2.QueriesByDatabase = 15
+		if err := sr.RecordStats(context.Background(), stats2); err != nil {
+			t.Fatalf("test %d, unexpected error: %v", i, err)
+		}
+		lines = receive()
+		if !contains(lines, v.increment) {
+			t.Errorf("test %d, missing %q in %q", i, v.increment, lines)
+		}
+		for _, l := range lines {
+			if strings.Contains(l, "update_duration") || strings.Contains(l, "hit_ratio") {
+				t.Errorf("test %d, unexpected %q without new updates or lookups", i, l)
+			}
+		}
+		sr.Close()
+	}
+}
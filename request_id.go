@@ -0,0 +1,74 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"net/http"
+)
+
+// RequestIDHeader is the header that request IDs are sent in.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the length of the longest valid request ID.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx that makes the lookup methods of
+// UpdateClient send id in the X-Request-ID header of their calls to the API,
+// and prefix their log messages with it, so that a failed lookup can be
+// traced to the request of the caller that made it. Like the trace context,
+// a shared or batched hash search is sent with the request ID of the first
+// lookup that made it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID of ctx, or "" if it has none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ValidRequestID reports whether id can be used as a request ID: it must be
+// made of up to 128 printable ASCII characters, other than spaces, so that
+// it can be logged and sent as is.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// setRequestIDHeader sets the request ID of ctx, if any, in h.
+func setRequestIDHeader(ctx context.Context, h http.Header) {
+	if id := RequestIDFromContext(ctx); id != "" {
+		h.Set(RequestIDHeader, id)
+	}
+}
+
+// logPrefix returns the prefix of the log messages about the lookup of ctx,
+// which names its request ID, if any.
+func logPrefix(ctx context.Context) string {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return "request " + id + ": "
+	}
+	return ""
+}
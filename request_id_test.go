@@ -0,0 +1,74 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidRequestID(t *testing.T) {
+	vectors := []struct {
+		id string
+		ok bool
+	}{
+		{"", false},
+		{"f81d4fae-7dec-11d0-a765-00a0c91e6bf6", true},
+		{"req:42/retry=1", true},
+		{"two words", false},
+		{"line\nbreak", false},
+		{"café", false},
+		{strings.Repeat("a", 128), true},
+		{strings.Repeat("a", 129), false},
+	}
+	for i, v := range vectors {
+		if ok := ValidRequestID(v.id); ok != v.ok {
+			t.Errorf("test %d, ValidRequestID(%q) = %v, want %v", i, v.id, ok, v.ok)
+		}
+	}
+}
+
+func TestNetAPIRequestID(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(RequestIDHeader)
+		w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+	api, err := newNetAPI(ts.URL, "fizzbuzz", nil)
+	if err != nil {
+		t.Fatalf("unexpected newNetAPI error: %v", err)
+	}
+
+	if _, err := api.HashLookup(context.Background(), []byte("abcd"), nil); err != nil {
+		t.Fatalf("unexpected HashLookup error: %v", err)
+	}
+	if got != "" {
+		t.Errorf("unexpected request ID without one: %q", got)
+	}
+
+	ctx := WithRequestID(context.Background(), "f81d4fae-7dec-11d0-a765-00a0c91e6bf6")
+	if _, err := api.HashLookup(ctx, []byte("abcd"), nil); err != nil {
+		t.Fatalf("unexpected HashLookup error: %v", err)
+	}
+	if want := RequestIDFromContext(ctx); got != want {
+		t.Errorf("request ID = %q, want %q", got, want)
+	}
+	if p := logPrefix(ctx); p != "request f81d4fae-7dec-11d0-a765-00a0c91e6bf6: " {
+		t.Errorf("logPrefix() = %q", p)
+	}
+}
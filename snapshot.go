@@ -0,0 +1,153 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+)
+
+// DatabaseSnapshot is a read-only copy of a saved database. It allows tools
+// to inspect a database without running an UpdateClient.
+type DatabaseSnapshot struct {
+	Time  time.Time                    // Last time the threat lists were synced
+	Lists map[ThreatType]*ListSnapshot // Contents of each threat list
+}
+
+// ListSnapshot is a read-only copy of a single threat list.
+type ListSnapshot struct {
+	Prefixes     []string // Sorted raw hash prefixes, each 4 to 32 bytes long
+	SHA256       []byte   // The SHA256 over the concatenated Prefixes
+	VersionToken []byte   // Opaque token of the version the list is at
+}
+
+// ReadDatabase reads a database saved by an UpdateClient from store.
+// The checksum of every threat list is validated.
+func ReadDatabase(ctx context.Context, store Store) (*DatabaseSnapshot, error) {
+	dbf, err := restoreDatabase(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	snap := &DatabaseSnapshot{Time: dbf.Time, Lists: make(map[ThreatType]*ListSnapshot)}
+	for td, phs := range dbf.Table {
+		phs.Hashes.Sort()
+		ls := &ListSnapshot{
+			Prefixes:     make([]string, len(phs.Hashes)),
+			SHA256:       phs.SHA256,
+			VersionToken: phs.State,
+		}
+		for i, h := range phs.Hashes {
+			ls.Prefixes[i] = string(h)
+		}
+		snap.Lists[td] = ls
+	}
+	return snap, nil
+}
+
+// ThreatTypes returns the threat lists in the snapshot in ascending order.
+func (s *DatabaseSnapshot) ThreatTypes() []ThreatType {
+	tds := make([]ThreatType, 0, len(s.Lists))
+	for td := range s.Lists {
+		tds = append(tds, td)
+	}
+	sort.Slice(tds, func(i, j int) bool { return tds[i] < tds[j] })
+	return tds
+}
+
+// partialHashes converts the list back into its stored form.
+func (ls *ListSnapshot) partialHashes() partialHashes {
+	phs := partialHashes{
+		Hashes: make(hashPrefixes, len(ls.Prefixes)),
+		SHA256: ls.SHA256,
+		State:  ls.VersionToken,
+	}
+	for i, p := range ls.Prefixes {
+		phs.Hashes[i] = hashPrefix(p)
+	}
+	return phs
+}
+
+// ListVerification is the result of verifying a threat list against the
+// version that the Web Risk API currently expects.
+type ListVerification struct {
+	ThreatType ThreatType
+
+	// InSync reports whether applying the API's diff to the local list
+	// produces exactly the list that the API expects, as determined by the
+	// API's checksum. A list that is out of sync will fail its next update
+	// and be downloaded in full.
+	InSync bool
+
+	// Reset reports whether the API did not recognize the version of the
+	// local list and responded with the full list instead of a diff. The
+	// local list could then not be verified.
+	Reset bool
+
+	Added   int // Number of entries the API would add to the local list
+	Removed int // Number of entries the API would remove from the local list
+
+	Err error // Error contacting the API or applying the diff, if any
+}
+
+// VerifyDatabase asks the Web Risk API for the changes since the version of
+// every threat list in snap, and checks that applying them results in the
+// list the API expects. The API key and server are taken from conf.
+// The database in snap is not modified. The lists are requested without a
+// limit on their entries, so those of a database that a Config.MemoryLimit
+// limited are reported as Reset; UpdateClient.VerifyDatabase knows the limit.
+func VerifyDatabase(ctx context.Context, conf Config, snap *DatabaseSnapshot) ([]ListVerification, error) {
+	conf = conf.copy()
+	conf.setDefaults()
+	if conf.api == nil {
+		var err error
+		conf.api, err = newAPI(&conf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var vs []ListVerification
+	for _, td := range snap.ThreatTypes() {
+		constraints := &pb.ComputeThreatListDiffRequest_Constraints{SupportedCompressions: conf.compressionTypes}
+		vs = append(vs, verifyList(ctx, conf.api, constraints, td, snap.Lists[td].partialHashes()))
+	}
+	return vs, nil
+}
+
+// verifyList applies the API's diff for the list td, requested under
+// constraints, to a copy of phs and checks the result against the API's
+// checksum.
+func verifyList(ctx context.Context, api api, constraints *pb.ComputeThreatListDiffRequest_Constraints, td ThreatType, phs partialHashes) ListVerification {
+	v := ListVerification{ThreatType: td}
+	resp, err := api.ListUpdate(ctx, pb.ThreatType(td), phs.State, constraints)
+	if err != nil {
+		v.Err = err
+		return v
+	}
+	v.Reset = resp.ResponseType == pb.ComputeThreatListDiffResponse_RESET
+	phs.Hashes = append(hashPrefixes(nil), phs.Hashes...)
+	tfu := threatsForUpdate{td: phs}
+	v.Added, v.Removed, err = tfu.update(resp, td)
+	switch {
+	case errors.Is(err, errChecksumMismatch):
+	case err != nil:
+		v.Err = err
+	default:
+		v.InSync = !v.Reset
+	}
+	return v
+}
@@ -0,0 +1,129 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+)
+
+func TestReadDatabase(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	last := time.Unix(1451436338, 0)
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{
+				Hashes: []hashPrefix{"bbbb", "cccc"},
+				SHA256: mustDecodeHex(t, "9a720c6ee500f5a0d4e5477fc9f3d8573226723d0b338b0c8f572d877bdfa224"),
+				State:  []byte("state2"),
+			},
+		},
+		Time: last,
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+
+	snap, err := ReadDatabase(context.Background(), &fileStore{path: path})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &DatabaseSnapshot{
+		Time: last,
+		Lists: map[ThreatType]*ListSnapshot{
+			ThreatTypeMalware: {
+				Prefixes:     []string{"bbbb", "cccc"},
+				SHA256:       dbf.Table[ThreatTypeMalware].SHA256,
+				VersionToken: []byte("state2"),
+			},
+		},
+	}
+	if !reflect.DeepEqual(snap, want) {
+		t.Errorf("ReadDatabase mismatch:\ngot  %+v\nwant %+v", snap, want)
+	}
+}
+
+func TestVerifyDatabase(t *testing.T) {
+	// The list is "aaaa", "bbbb" and the API wants to add "cccc".
+	const bogus = "0000000000000000000000000000000000000000000000000000000000000000"
+	sum := func(phs hashPrefixes) string { return hex.EncodeToString(phs.SHA256()) }
+	newResp := func(rtype pb.ComputeThreatListDiffResponse_ResponseType, chksum string) *pb.ComputeThreatListDiffResponse {
+		return &pb.ComputeThreatListDiffResponse{
+			ResponseType: rtype,
+			Additions: &pb.ThreatEntryAdditions{
+				RawHashes: []*pb.RawHashes{{PrefixSize: 4, RawHashes: []byte("cccc")}},
+			},
+			NewVersionToken: []byte("new"),
+			Checksum:        &pb.ComputeThreatListDiffResponse_Checksum{Sha256: mustDecodeHex(t, chksum)},
+		}
+	}
+	vectors := []struct {
+		resp *pb.ComputeThreatListDiffResponse
+		err  error
+		want ListVerification
+	}{{
+		resp: newResp(pb.ComputeThreatListDiffResponse_DIFF, sum(hashPrefixes{"aaaa", "bbbb", "cccc"})),
+		want: ListVerification{ThreatType: ThreatTypeMalware, InSync: true, Added: 1},
+	}, {
+		resp: newResp(pb.ComputeThreatListDiffResponse_DIFF, bogus),
+		want: ListVerification{ThreatType: ThreatTypeMalware, Added: 1},
+	}, {
+		resp: newResp(pb.ComputeThreatListDiffResponse_RESET, sum(hashPrefixes{"cccc"})),
+		want: ListVerification{ThreatType: ThreatTypeMalware, Reset: true, Added: 1},
+	}, {
+		err:  errors.New("unavailable"),
+		want: ListVerification{ThreatType: ThreatTypeMalware, Err: errors.New("unavailable")},
+	}}
+
+	snap := &DatabaseSnapshot{
+		Lists: map[ThreatType]*ListSnapshot{
+			ThreatTypeMalware: {
+				Prefixes:     []string{"aaaa", "bbbb"},
+				SHA256:       hashPrefixes{"aaaa", "bbbb"}.SHA256(),
+				VersionToken: []byte("old"),
+			},
+		},
+	}
+	for i, v := range vectors {
+		var gotToken []byte
+		conf := Config{api: &mockAPI{
+			listUpdate: func(_ context.Context, _ pb.ThreatType, token []byte, _ *pb.ComputeThreatListDiffRequest_Constraints) (*pb.ComputeThreatListDiffResponse, error) {
+				gotToken = token
+				return v.resp, v.err
+			},
+		}}
+		vs, err := VerifyDatabase(context.Background(), conf, snap)
+		if err != nil {
+			t.Fatalf("test %d, unexpected error: %v", i, err)
+		}
+		if string(gotToken) != "old" {
+			t.Errorf("test %d, version token = %q, want %q", i, gotToken, "old")
+		}
+		if !reflect.DeepEqual(vs, []ListVerification{v.want}) {
+			t.Errorf("test %d, VerifyDatabase mismatch:\ngot  %+v\nwant %+v", i, vs, v.want)
+		}
+	}
+	if got := snap.Lists[ThreatTypeMalware].Prefixes; !reflect.DeepEqual(got, []string{"aaaa", "bbbb"}) {
+		t.Errorf("snapshot was modified: %q", got)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync/atomic"
+)
+
+// statsCounters are the cumulative counters of Stats, as saved to
+// Config.StatsPath. The other fields of Stats describe the current state of
+// the client, and are not saved.
+type statsCounters struct {
+	QueriesByDatabase  int64 `json:"queriesByDatabase"`
+	QueriesByCache     int64 `json:"queriesByCache"`
+	QueriesByAPI       int64 `json:"queriesByApi"`
+	QueriesFail        int64 `json:"queriesFail"`
+	QueriesUnconfirmed int64 `json:"queriesUnconfirmed"`
+	CacheEvictions     int64 `json:"cacheEvictions"`
+	CacheRefreshes     int64 `json:"cacheRefreshes"`
+	CachePositiveHits  int64 `json:"cachePositiveHits"`
+	CacheNegativeHits  int64 `json:"cacheNegativeHits"`
+	CacheMisses        int64 `json:"cacheMisses"`
+	CacheExpired       int64 `json:"cacheExpired"`
+
+	ChecksumFailures map[ThreatType]int64 `json:"checksumFailures,omitempty"`
+}
+
+// countersOf returns the cumulative counters of s. If negate is set, they
+// are negated, so that adding them to s zeroes its counters.
+func countersOf(s Stats, negate bool) statsCounters {
+	sign := int64(1)
+	if negate {
+		sign = -1
+	}
+	c := statsCounters{
+		QueriesByDatabase:  sign * s.QueriesByDatabase,
+		QueriesByCache:     sign * s.QueriesByCache,
+		QueriesByAPI:       sign * s.QueriesByAPI,
+		QueriesFail:        sign * s.QueriesFail,
+		QueriesUnconfirmed: sign * s.QueriesUnconfirmed,
+		CacheEvictions:     sign * s.CacheEvictions,
+		CacheRefreshes:     sign * s.CacheRefreshes,
+		CachePositiveHits:  sign * s.CachePositiveHits,
+		CacheNegativeHits:  sign * s.CacheNegativeHits,
+		CacheMisses:        sign * s.CacheMisses,
+		CacheExpired:       sign * s.CacheExpired,
+	}
+	for tt, ls := range s.Lists {
+		if ls.ChecksumFailures != 0 {
+			if c.ChecksumFailures == nil {
+				c.ChecksumFailures = make(map[ThreatType]int64)
+			}
+			c.ChecksumFailures[tt] = sign * ls.ChecksumFailures
+		}
+	}
+	return c
+}
+
+// addTo adds the counters to those of s. Checksum failures of lists that s
+// has no statistics for are dropped.
+func (c statsCounters) addTo(s *Stats) {
+	s.QueriesByDatabase += c.QueriesByDatabase
+	s.QueriesByCache += c.QueriesByCache
+	s.QueriesByAPI += c.QueriesByAPI
+	s.QueriesFail += c.QueriesFail
+	s.QueriesUnconfirmed += c.QueriesUnconfirmed
+	s.CacheEvictions += c.CacheEvictions
+	s.CacheRefreshes += c.CacheRefreshes
+	s.CachePositiveHits += c.CachePositiveHits
+	s.CacheNegativeHits += c.CacheNegativeHits
+	s.CacheMisses += c.CacheMisses
+	s.CacheExpired += c.CacheExpired
+	for tt, n := range c.ChecksumFailures {
+		if ls, ok := s.Lists[tt]; ok {
+			ls.ChecksumFailures += n
+			s.Lists[tt] = ls
+		}
+	}
+}
+
+// ResetStats zeroes the cumulative counters reported by Status, such as the
+// number of queries and cache hits, so that a load test or a dashboard can
+// start from a clean slate. The other statistics, such as the size of the
+// database, are not affected. The reset counters are saved to
+// Config.StatsPath right away, if any.
+func (wr *UpdateClient) ResetStats() error {
+	if atomic.LoadUint32(&wr.closed) == 1 {
+		return errClosed
+	}
+	wr.statsMu.Lock()
+	wr.statsBase = countersOf(wr.rawStats(), true)
+	wr.statsMu.Unlock()
+	return wr.saveStats()
+}
+
+// loadStats restores the counters saved to Config.StatsPath, if any.
+func (wr *UpdateClient) loadStats() {
+	if wr.config.StatsPath == "" {
+		return
+	}
+	wr.statsStore = NewFileStore(wr.config.StatsPath, wr.config.WritePolicy)
+	ctx, cancel := context.WithTimeout(context.Background(), wr.config.RequestTimeout)
+	defer cancel()
+	data, err := wr.statsStore.Load(ctx)
+	var c statsCounters
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		wr.log.Printf("stats load failure: %v", err)
+	default:
+		wr.statsMu.Lock()
+		wr.statsBase = c
+		wr.statsMu.Unlock()
+		wr.log.Printf("restored statistics of %d queries", c.QueriesByDatabase+c.QueriesByCache+c.QueriesByAPI+c.QueriesFail)
+	}
+}
+
+// saveStats saves the counters to Config.StatsPath, if any.
+func (wr *UpdateClient) saveStats() error {
+	if wr.statsStore == nil {
+		return nil
+	}
+	stats, _ := wr.Status()
+	data, err := json.Marshal(countersOf(stats, false))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), wr.config.RequestTimeout)
+	defer cancel()
+	if err := wr.statsStore.Save(ctx, data); err != nil {
+		wr.log.Printf("stats save failure: %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+	timepb "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestStatsPath(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+	statsPath := filepath.Join(t.TempDir(), "stats.json")
+
+	phs := hashPrefixes{hashFromPattern("evil.com/")[:minHashPrefixLength]}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: time.Now(),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	newClient := func() *UpdateClient {
+		wr, err := NewUpdateClient(Config{
+			DBPath:      path,
+			StatsPath:   statsPath,
+			ThreatLists: []ThreatType{ThreatTypeMalware},
+			api: &mockAPI{
+				hashLookup: func(context.Context, []byte, []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+					return &pb.SearchHashesResponse{NegativeExpireTime: timepb.New(time.Now().Add(time.Hour))}, nil
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return wr
+	}
+	lookup := func(wr *UpdateClient, urls ...string) {
+		if _, err := wr.LookupURLs(urls); err != nil {
+			t.Fatalf("unexpected lookup error: %v", err)
+		}
+	}
+	check := func(wr *UpdateClient, what string, db, api int64) {
+		t.Helper()
+		stats, _ := wr.Status()
+		if stats.QueriesByDatabase != db || stats.QueriesByAPI != api {
+			t.Errorf("%s: queries by database and API = %d, %d, want %d, %d", what, stats.QueriesByDatabase, stats.QueriesByAPI, db, api)
+		}
+	}
+
+	wr := newClient()
+	lookup(wr, "http://safe.com/", "http://safe.com/a", "http://evil.com/")
+	check(wr, "first run", 3, 1)
+	wr.Close()
+
+	wr = newClient()
+	check(wr, "restored", 3, 1)
+	lookup(wr, "http://safe.com/")
+	check(wr, "second run", 4, 1)
+	if err := wr.ResetStats(); err != nil {
+		t.Fatalf("unexpected reset error: %v", err)
+	}
+	check(wr, "reset", 0, 0)
+	lookup(wr, "http://safe.com/")
+	check(wr, "after reset", 1, 0)
+	wr.Close()
+
+	wr = newClient()
+	check(wr, "restored after reset", 1, 0)
+	wr.Close()
+
+	if err := os.WriteFile(statsPath, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wr = newClient()
+	defer wr.Close()
+	check(wr, "corrupt file", 0, 0)
+}
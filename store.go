@@ -0,0 +1,281 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrStoreConflict is returned by Store.Save when the saved database was
+// modified by someone else since it was last loaded or saved by this Store.
+var ErrStoreConflict = errors.New("webrisk: database was modified concurrently")
+
+// A Store persists the database between runs of UpdateClient.
+//
+// The contents are opaque to the Store. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Load returns the most recently saved database. If no database has been
+	// saved yet, the error must satisfy errors.Is(err, os.ErrNotExist).
+	Load(ctx context.Context) ([]byte, error)
+
+	// Save replaces the saved database with data. Implementations that can
+	// detect concurrent writers should return ErrStoreConflict instead of
+	// overwriting a database they have not seen.
+	Save(ctx context.Context, data []byte) error
+}
+
+// ErrStoreLocked is returned when the database is already being updated by
+// another process.
+var ErrStoreLocked = errors.New("webrisk: database is locked by another process")
+
+// lockingStore is implemented by stores that can prevent several processes
+// from updating the same database.
+type lockingStore interface {
+	// Lock takes an exclusive lock on the database, or returns
+	// ErrStoreLocked if another process holds it. The lock is held until
+	// Unlock is called or the process exits.
+	Lock() error
+	Unlock() error
+}
+
+// versionedStore is implemented by stores that can cheaply tell whether the
+// saved database changed, without loading it.
+type versionedStore interface {
+	// Version returns an opaque string that changes whenever the saved
+	// database does.
+	Version(ctx context.Context) (string, error)
+}
+
+// storeVersion returns the version of the database in store, or "" if the
+// store cannot tell.
+func storeVersion(ctx context.Context, store Store) string {
+	vs, ok := store.(versionedStore)
+	if !ok {
+		return ""
+	}
+	v, err := vs.Version(ctx)
+	if err != nil {
+		return ""
+	}
+	return v
+}
+
+// OpenStore returns a Store for the given location. Locations of the form
+// "gs://bucket/object" and "s3://bucket/key" are stored in Google Cloud
+// Storage and Amazon S3 respectively, using credentials from the environment.
+// Any other location is treated as a local file path.
+func OpenStore(location string) (Store, error) {
+	for scheme, open := range map[string]func(bucket, object string) Store{
+		"gs://": func(b, o string) Store { return NewGCSStore(b, o) },
+		"s3://": func(b, o string) Store { return NewS3Store(b, o) },
+	} {
+		if !strings.HasPrefix(location, scheme) {
+			continue
+		}
+		bucket, object, _ := strings.Cut(strings.TrimPrefix(location, scheme), "/")
+		if bucket == "" || object == "" {
+			return nil, errors.New("webrisk: invalid store location: " + location)
+		}
+		return open(bucket, object), nil
+	}
+	if location == "" {
+		return nil, errors.New("webrisk: empty store location")
+	}
+	return &fileStore{path: location}, nil
+}
+
+// WritePolicy determines how a database file is written, trading off
+// durability against the number of writes to the underlying device.
+type WritePolicy int
+
+const (
+	// WriteSync writes the database to a temporary file, flushes it to
+	// stable storage, and renames it over the original. A crash or power
+	// loss leaves either the old or the new database in place.
+	// This is the default.
+	WriteSync WritePolicy = iota
+
+	// WriteRename is like WriteSync but does not flush the temporary file.
+	// A crash leaves the old or the new database in place, but after a
+	// power loss the file may be empty or torn on some file systems. This
+	// avoids a costly flush on network file systems.
+	WriteRename
+
+	// WriteInPlace overwrites the database file directly without flushing
+	// it, which avoids creating a new file on every update. This minimizes
+	// wear on flash storage. A crash in the middle of a write leaves a
+	// torn file, which is detected and discarded on the next load, causing
+	// the threat lists to be downloaded again.
+	WriteInPlace
+)
+
+var writePolicyNames = map[WritePolicy]string{
+	WriteSync:    "sync",
+	WriteRename:  "rename",
+	WriteInPlace: "inplace",
+}
+
+func (p WritePolicy) String() string {
+	if name, ok := writePolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("WritePolicy(%d)", int(p))
+}
+
+// ParseWritePolicy parses the name of a WritePolicy: "sync", "rename", or
+// "inplace". An empty string is parsed as WriteSync.
+func ParseWritePolicy(s string) (WritePolicy, error) {
+	if s == "" {
+		return WriteSync, nil
+	}
+	for p, name := range writePolicyNames {
+		if s == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("webrisk: unknown write policy: %q", s)
+}
+
+// NewFileStore returns a Store that keeps the database in the local file
+// at path, written according to policy.
+func NewFileStore(path string, policy WritePolicy) Store {
+	return &fileStore{path: path, policy: policy}
+}
+
+// fileStore is a Store backed by a file on the local file system.
+type fileStore struct {
+	path   string
+	policy WritePolicy
+
+	mu         sync.Mutex  // Protects stat, generation, and lock
+	stat       os.FileInfo // File last seen by Version
+	generation int         // Incremented whenever the file is replaced
+	lock       *os.File    // Lock file, if the lock is held
+}
+
+func (fs *fileStore) Load(ctx context.Context) ([]byte, error) {
+	return os.ReadFile(fs.path)
+}
+
+// Version identifies the file by its identity, size, and modification time.
+// Symbolic links are followed, so that files swapped in by retargeting a
+// link, as is done for mounted Kubernetes volumes, are noticed as well.
+// Since modification times can be coarse, a file that is replaced by
+// renaming another over it is recognized as changed even if its size and
+// modification time are the same.
+func (fs *fileStore) Version(ctx context.Context) (string, error) {
+	fi, err := os.Stat(fs.path)
+	if err != nil {
+		return "", err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.stat == nil || !os.SameFile(fs.stat, fi) {
+		fs.generation++
+	}
+	fs.stat = fi
+	return fmt.Sprintf("%d-%d-%d", fs.generation, fi.Size(), fi.ModTime().UnixNano()), nil
+}
+
+// Lock takes an advisory lock on a file next to the database, named after
+// it with a ".lock" suffix. The database file itself cannot be locked since
+// it is replaced on every save. On platforms without advisory locks, Lock
+// always succeeds.
+func (fs *fileStore) Lock() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.lock != nil {
+		return nil
+	}
+	f, err := os.OpenFile(fs.path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if errors.Is(err, ErrStoreLocked) {
+			return fmt.Errorf("%w: %s", err, fs.path)
+		}
+		return err
+	}
+	fs.lock = f
+	return nil
+}
+
+// Unlock releases the lock taken by Lock. The lock file is left in place,
+// since removing it would race with other processes opening it.
+func (fs *fileStore) Unlock() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.lock == nil {
+		return nil
+	}
+	err := fs.lock.Close()
+	fs.lock = nil
+	return err
+}
+
+// Save replaces the file according to the write policy of the store.
+//
+// Unless the policy is WriteInPlace, the data is written to a temporary file
+// in the same directory which is then renamed over the original, so that a
+// crash at any point leaves either the old or the new database in place,
+// never a torn one.
+func (fs *fileStore) Save(ctx context.Context, data []byte) (err error) {
+	if fs.policy == WriteInPlace {
+		return os.WriteFile(fs.path, data, 0644)
+	}
+
+	// Respect a database file that was explicitly made read-only, which a
+	// rename would otherwise silently replace.
+	if f, err := os.OpenFile(fs.path, os.O_WRONLY, 0); err == nil {
+		f.Close()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	var file *os.File
+	file, err = os.CreateTemp(filepath.Dir(fs.path), filepath.Base(fs.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+	if _, err = file.Write(data); err != nil {
+		return err
+	}
+	if fs.policy == WriteSync {
+		if err = file.Sync(); err != nil {
+			return err
+		}
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(file.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), fs.path)
+}
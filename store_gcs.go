@@ -0,0 +1,229 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const (
+	// DefaultGCSEndpoint is the default endpoint of the Cloud Storage JSON API.
+	DefaultGCSEndpoint = "https://storage.googleapis.com"
+
+	gcsMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// GCSStore is a Store that persists the database as a Google Cloud Storage
+// object. Writes are conditional on the object generation that was last
+// observed, so that two instances sharing an object never silently overwrite
+// each other.
+type GCSStore struct {
+	Bucket string
+	Object string
+
+	// Endpoint is the root URL of the Cloud Storage JSON API.
+	// If empty, it defaults to DefaultGCSEndpoint.
+	Endpoint string
+
+	// Token returns an OAuth2 access token for each request. If nil, tokens
+	// are obtained from the GCE metadata server, which is available on
+	// Compute Engine, GKE, Cloud Run, and Cloud Functions.
+	Token func(ctx context.Context) (string, error)
+
+	// Client is the HTTP client to use. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	mu         sync.Mutex
+	generation int64 // Last observed object generation, 0 if none
+}
+
+// NewGCSStore returns a GCSStore for the given bucket and object name.
+func NewGCSStore(bucket, object string) *GCSStore {
+	return &GCSStore{Bucket: bucket, Object: object}
+}
+
+func (s *GCSStore) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
+}
+
+func (s *GCSStore) endpoint() string {
+	if s.Endpoint != "" {
+		return s.Endpoint
+	}
+	return DefaultGCSEndpoint
+}
+
+// do issues an authenticated request.
+func (s *GCSStore) do(ctx context.Context, method, u string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	token := s.Token
+	if token == nil {
+		token = gcsMetadataToken
+	}
+	tok, err := token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	return s.client().Do(req)
+}
+
+// Load downloads the object and records its generation.
+func (s *GCSStore) Load(ctx context.Context) ([]byte, error) {
+	u := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", s.endpoint(),
+		url.PathEscape(s.Bucket), url.PathEscape(s.Object))
+	resp, err := s.do(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("webrisk: gs://%s/%s: %w", s.Bucket, s.Object, os.ErrNotExist)
+	default:
+		return nil, fmt.Errorf("webrisk: unexpected Cloud Storage response code: %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	gen, err := strconv.ParseInt(resp.Header.Get("X-Goog-Generation"), 10, 64)
+	if err != nil {
+		return nil, errors.New("webrisk: missing Cloud Storage object generation")
+	}
+	s.mu.Lock()
+	s.generation = gen
+	s.mu.Unlock()
+	return data, nil
+}
+
+// Save uploads data, provided that the object has not been modified since
+// it was last loaded or saved by s. On conflict, the current generation is
+// recorded so that a subsequent Save can succeed.
+func (s *GCSStore) Save(ctx context.Context, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := url.Values{}
+	q.Set("uploadType", "media")
+	q.Set("name", s.Object)
+	q.Set("ifGenerationMatch", strconv.FormatInt(s.generation, 10))
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", s.endpoint(), url.PathEscape(s.Bucket), q.Encode())
+	resp, err := s.do(ctx, http.MethodPost, u, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusPreconditionFailed:
+		if gen, err := s.currentGeneration(ctx); err == nil {
+			s.generation = gen
+		}
+		return ErrStoreConflict
+	default:
+		return fmt.Errorf("webrisk: unexpected Cloud Storage response code: %d", resp.StatusCode)
+	}
+	var obj struct {
+		Generation string `json:"generation"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+		return err
+	}
+	gen, err := strconv.ParseInt(obj.Generation, 10, 64)
+	if err != nil {
+		return errors.New("webrisk: missing Cloud Storage object generation")
+	}
+	s.generation = gen
+	return nil
+}
+
+// Version returns the generation of the object, which changes on every
+// write.
+func (s *GCSStore) Version(ctx context.Context) (string, error) {
+	gen, err := s.currentGeneration(ctx)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(gen, 10), nil
+}
+
+// currentGeneration fetches the generation of the object as currently stored.
+func (s *GCSStore) currentGeneration(ctx context.Context) (int64, error) {
+	u := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?fields=generation", s.endpoint(),
+		url.PathEscape(s.Bucket), url.PathEscape(s.Object))
+	resp, err := s.do(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("webrisk: unexpected Cloud Storage response code: %d", resp.StatusCode)
+	}
+	var obj struct {
+		Generation string `json:"generation"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(obj.Generation, 10, 64)
+}
+
+// gcsMetadataToken obtains an access token for the default service account
+// from the GCE metadata server.
+func gcsMetadataToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcsMetadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("webrisk: unexpected metadata server response code: %d", resp.StatusCode)
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", err
+	}
+	return tok.AccessToken, nil
+}
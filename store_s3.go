@@ -0,0 +1,265 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3Store is a Store that persists the database as an Amazon S3 object, or
+// an object in any S3 compatible service. Writes are conditional on the ETag
+// that was last observed, so that two instances sharing an object never
+// silently overwrite each other.
+type S3Store struct {
+	Bucket string
+	Key    string
+
+	// Region is the AWS region of the bucket.
+	Region string
+
+	// Endpoint is the root URL of an S3 compatible service. If set, objects
+	// are addressed in path style. If empty, the virtual hosted style AWS
+	// endpoint for Region is used.
+	Endpoint string
+
+	// Credentials used to sign requests.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Client is the HTTP client to use. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	mu   sync.Mutex
+	etag string // Last observed ETag, empty if none
+
+	now func() time.Time
+}
+
+// NewS3Store returns an S3Store for the given bucket and key. The region,
+// endpoint, and credentials are taken from the standard AWS environment
+// variables.
+func NewS3Store(bucket, key string) *S3Store {
+	s := &S3Store{
+		Bucket:          bucket,
+		Key:             key,
+		Region:          os.Getenv("AWS_REGION"),
+		Endpoint:        os.Getenv("AWS_ENDPOINT_URL_S3"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if s.Region == "" {
+		s.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	if s.Endpoint == "" {
+		s.Endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	return s
+}
+
+func (s *S3Store) client() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
+}
+
+func (s *S3Store) objectURL() string {
+	key := (&url.URL{Path: s.Key}).EscapedPath()
+	if s.Endpoint != "" {
+		return strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket + "/" + key
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.Bucket, s.Region, key)
+}
+
+// do issues a signed request for the object.
+func (s *S3Store) do(ctx context.Context, method string, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	s.sign(req, body, now())
+	return s.client().Do(req)
+}
+
+// Load downloads the object and records its ETag.
+func (s *S3Store) Load(ctx context.Context) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("webrisk: s3://%s/%s: %w", s.Bucket, s.Key, os.ErrNotExist)
+	default:
+		return nil, fmt.Errorf("webrisk: unexpected S3 response code: %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.etag = resp.Header.Get("ETag")
+	s.mu.Unlock()
+	return data, nil
+}
+
+// Save uploads data, provided that the object has not been modified since
+// it was last loaded or saved by s. On conflict, the current ETag is recorded
+// so that a subsequent Save can succeed.
+func (s *S3Store) Save(ctx context.Context, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	header := http.Header{}
+	if s.etag != "" {
+		header.Set("If-Match", s.etag)
+	} else {
+		header.Set("If-None-Match", "*")
+	}
+	resp, err := s.do(ctx, http.MethodPut, data, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		if head, err := s.do(ctx, http.MethodHead, nil, nil); err == nil {
+			head.Body.Close()
+			if head.StatusCode == http.StatusOK {
+				s.etag = head.Header.Get("ETag")
+			} else if head.StatusCode == http.StatusNotFound {
+				s.etag = ""
+			}
+		}
+		return ErrStoreConflict
+	default:
+		return fmt.Errorf("webrisk: unexpected S3 response code: %d", resp.StatusCode)
+	}
+	s.etag = resp.Header.Get("ETag")
+	return nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req.
+// All headers set on req at this point are signed.
+func (s *S3Store) sign(req *http.Request, body []byte, t time.Time) {
+	if s.AccessKeyID == "" {
+		return // Anonymous access
+	}
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	// Canonical headers, including the host.
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	canonHash := sha256.Sum256([]byte(canonRequest))
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, sig))
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsEscape percent-encodes s as required by Signature Version 4.
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
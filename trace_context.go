@@ -0,0 +1,94 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// TraceContext is the W3C Trace Context (https://www.w3.org/TR/trace-context/)
+// of the caller of a lookup. Passed to a lookup with WithTraceContext, it is
+// sent with the calls that the lookup makes to the Web Risk API, so that
+// they are part of the trace of the caller.
+type TraceContext struct {
+	Parent string // Value of the traceparent header
+	State  string // Value of the tracestate header, if any
+}
+
+// TraceContextFromHeader returns the trace context of the traceparent and
+// tracestate headers of h. It reports false if there is no traceparent
+// header, or if it is not valid, in which case tracestate is ignored too.
+func TraceContextFromHeader(h http.Header) (TraceContext, bool) {
+	parents := h.Values("traceparent")
+	if len(parents) != 1 || !validTraceParent(parents[0]) {
+		return TraceContext{}, false
+	}
+	return TraceContext{
+		Parent: strings.TrimSpace(parents[0]),
+		State:  strings.Join(h.Values("tracestate"), ","),
+	}, true
+}
+
+// validTraceParent reports whether p is a traceparent of the form
+// version-traceid-parentid-flags, in lowercase hexadecimal. Versions after 00
+// may add fields.
+func validTraceParent(p string) bool {
+	fields := strings.Split(strings.TrimSpace(p), "-")
+	if len(fields) < 4 {
+		return false
+	}
+	version, traceID, parentID, flags := fields[0], fields[1], fields[2], fields[3]
+	if !isHexString(version, 2) || version == "ff" || (version == "00" && len(fields) != 4) {
+		return false
+	}
+	return isHexString(traceID, 32) && strings.Trim(traceID, "0") != "" &&
+		isHexString(parentID, 16) && strings.Trim(parentID, "0") != "" && isHexString(flags, 2)
+}
+
+// isHexString reports whether s is made of n lowercase hexadecimal digits.
+func isHexString(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+type traceContextKey struct{}
+
+// WithTraceContext returns a copy of ctx that makes the lookup methods of
+// UpdateClient send tc with their calls to the API. A hash search shared by
+// concurrent lookups, or batched with Config.SearchBatchWindow, is sent with
+// the trace context of the first of them.
+func WithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// setTraceHeaders sets the trace context headers of ctx, if any, in h.
+func setTraceHeaders(ctx context.Context, h http.Header) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	if !ok || tc.Parent == "" {
+		return
+	}
+	h.Set("traceparent", tc.Parent)
+	if tc.State != "" {
+		h.Set("tracestate", tc.State)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTraceContextFromHeader(t *testing.T) {
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	vectors := []struct {
+		parents []string
+		states  []string
+		tc      TraceContext
+		ok      bool
+	}{
+		{parents: nil},
+		{parents: []string{parent}, tc: TraceContext{Parent: parent}, ok: true},
+		{parents: []string{" " + parent}, tc: TraceContext{Parent: parent}, ok: true},
+		{parents: []string{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01"}},
+		{parents: []string{parent}, states: []string{"rojo=00f067aa0ba902b7", "congo=t61rcWkgMzE"},
+			tc: TraceContext{Parent: parent, State: "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE"}, ok: true},
+		{parents: []string{"cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future"},
+			tc: TraceContext{Parent: "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future"}, ok: true},
+		{parents: []string{parent + "-extra"}},
+		{parents: []string{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}},
+		{parents: []string{"00-00000000000000000000000000000000-00f067aa0ba902b7-01"}},
+		{parents: []string{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"}},
+		{parents: []string{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01"}},
+		{parents: []string{"00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01"}},
+		{parents: []string{parent, parent}},
+		{parents: []string{"garbage"}, states: []string{"rojo=00f067aa0ba902b7"}},
+	}
+	for i, v := range vectors {
+		h := make(http.Header)
+		for _, p := range v.parents {
+			h.Add("traceparent", p)
+		}
+		for _, s := range v.states {
+			h.Add("tracestate", s)
+		}
+		tc, ok := TraceContextFromHeader(h)
+		if ok != v.ok || tc != v.tc {
+			t.Errorf("test %d, TraceContextFromHeader() = %+v, %v, want %+v, %v", i, tc, ok, v.tc, v.ok)
+		}
+	}
+}
+
+func TestNetAPITraceContext(t *testing.T) {
+	var gotParent, gotState string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotParent, gotState = r.Header.Get("traceparent"), r.Header.Get("tracestate")
+		w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+	api, err := newNetAPI(ts.URL, "fizzbuzz", nil)
+	if err != nil {
+		t.Fatalf("unexpected newNetAPI error: %v", err)
+	}
+
+	if _, err := api.HashLookup(context.Background(), []byte("abcd"), nil); err != nil {
+		t.Fatalf("unexpected HashLookup error: %v", err)
+	}
+	if gotParent != "" || gotState != "" {
+		t.Errorf("unexpected trace context without one: %q, %q", gotParent, gotState)
+	}
+
+	tc := TraceContext{Parent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", State: "rojo=00f067aa0ba902b7"}
+	if _, err := api.HashLookup(WithTraceContext(context.Background(), tc), []byte("abcd"), nil); err != nil {
+		t.Fatalf("unexpected HashLookup error: %v", err)
+	}
+	if gotParent != tc.Parent || gotState != tc.State {
+		t.Errorf("trace context = %q, %q, want %q, %q", gotParent, gotState, tc.Parent, tc.State)
+	}
+}
@@ -41,13 +41,18 @@ import (
 	"net"
 
 	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
 
 	"net/url"
 	"path"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"unicode"
+	"unicode/utf8"
 )
 
 var (
@@ -57,6 +62,158 @@ var (
 	trailingSpaceRegexp = regexp.MustCompile(`^(\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}) `)
 )
 
+// IDNPolicy determines how internationalized hostnames are canonicalized
+// before they are hashed.
+type IDNPolicy int
+
+const (
+	// IDNLenient maps hostnames according to UTS #46, as browsers do, so
+	// that variants such as uppercase or full-width characters hash to the
+	// same expressions, and then converts them to punycode. Hostnames that
+	// are not valid under UTS #46 are still converted as well as possible.
+	// This is the default.
+	IDNLenient IDNPolicy = iota
+
+	// IDNStrict maps hostnames like IDNLenient, but rejects the URLs whose
+	// hostnames are not valid under UTS #46, including those with punycode
+	// labels that do not decode to valid ones.
+	IDNStrict
+
+	// IDNPunycode converts hostnames to punycode without mapping them.
+	// This was the behavior of earlier versions, which misses lookalike
+	// hostnames that only differ from a listed one by case or width.
+	IDNPunycode
+)
+
+var idnPolicyNames = map[IDNPolicy]string{
+	IDNLenient:  "lenient",
+	IDNStrict:   "strict",
+	IDNPunycode: "punycode",
+}
+
+func (p IDNPolicy) String() string {
+	if name, ok := idnPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("IDNPolicy(%d)", int(p))
+}
+
+// ParseIDNPolicy parses the name of an IDNPolicy: "lenient", "strict", or
+// "punycode". An empty string is parsed as IDNLenient.
+func ParseIDNPolicy(s string) (IDNPolicy, error) {
+	if s == "" {
+		return IDNLenient, nil
+	}
+	for p, name := range idnPolicyNames {
+		if s == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("webrisk: unknown IDN policy: %q", s)
+}
+
+// idnaLenient is the UTS #46 profile used by IDNLenient. Unlike idna.Lookup,
+// it allows characters such as '_' that are common in hostnames, although
+// they are not valid in domain names.
+var idnaLenient = idna.New(
+	idna.MapForLookup(),
+	idna.Transitional(false),
+	idna.StrictDomainName(false),
+	idna.CheckHyphens(false),
+)
+
+// SchemeAction determines what is done with the URLs of a given scheme,
+// such as "ftp" or a custom application scheme, when they are looked up.
+type SchemeAction int
+
+const (
+	// SchemeLookup looks URLs up like http and https ones. This is the
+	// default.
+	SchemeLookup SchemeAction = iota
+
+	// SchemeReject fails lookups of URLs with ErrSchemeRejected.
+	SchemeReject
+
+	// SchemePass reports no threats for URLs without looking them up.
+	SchemePass
+)
+
+var schemeActionNames = map[SchemeAction]string{
+	SchemeLookup: "lookup",
+	SchemeReject: "reject",
+	SchemePass:   "pass",
+}
+
+func (a SchemeAction) String() string {
+	if name, ok := schemeActionNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("SchemeAction(%d)", int(a))
+}
+
+// ParseSchemeAction parses the name of a SchemeAction: "lookup", "reject",
+// or "pass".
+func ParseSchemeAction(s string) (SchemeAction, error) {
+	for a, name := range schemeActionNames {
+		if s == name {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("webrisk: unknown scheme action: %q", s)
+}
+
+// ErrSchemeRejected is returned when looking up a URL whose scheme is
+// rejected by Config.Schemes.
+var ErrSchemeRejected = errors.New("webrisk: URL scheme is rejected")
+
+const (
+	// DefaultMaxHostComponents is the default number of trailing components
+	// of a hostname, such as "b.c.d.e.f.g.com", from which shorter suffixes
+	// are looked up.
+	DefaultMaxHostComponents = 7
+
+	// DefaultMaxPathComponents is the default number of path prefixes, such
+	// as "/a/b/c/", that are looked up, including "/" itself.
+	DefaultMaxPathComponents = 4
+)
+
+// urlParser canonicalizes URLs and generates their patterns. The zero value
+// uses the default options.
+type urlParser struct {
+	idn     IDNPolicy
+	schemes map[string]SchemeAction
+
+	// publicSuffixes makes host suffixes be generated according to the
+	// public suffix list.
+	publicSuffixes bool
+
+	// strict rejects URLs that cannot be canonicalized unambiguously.
+	strict bool
+
+	// maxHostComponents and maxPathComponents bound the number of host
+	// suffixes and path prefixes that are generated. If zero, the defaults
+	// are used.
+	maxHostComponents int
+	maxPathComponents int
+
+	// parallelThreshold is the number of patterns from which they are
+	// hashed in parallel, if positive.
+	parallelThreshold int
+}
+
+// schemeAction returns the action for the scheme of url. URLs without a
+// scheme are treated as http ones.
+func (up urlParser) schemeAction(url string) SchemeAction {
+	scheme, _ := getScheme(strings.TrimSpace(url))
+	if scheme == "" {
+		scheme = "http"
+	}
+	if a, ok := up.schemes[strings.ToLower(scheme)]; ok {
+		return a
+	}
+	return up.schemes["*"]
+}
+
 // ValidURL parses the given string and returns true if it is a Web Risk
 // compatible URL.
 //
@@ -65,32 +222,107 @@ var (
 // URLs, as the first parse failure will cause LookupURLs to stop processing
 // the request and return an error.
 func ValidURL(url string) bool {
-	parsed, err := parseURL(url)
+	parsed, err := urlParser{}.parseURL(url)
 	return parsed != nil && err == nil
 }
 
+// Canonicalize returns the canonical form of url, as scheme://host/path, in
+// which the host and path are those that LookupURLs generates expressions
+// from, using the default options. The query and fragment are removed.
+func Canonicalize(url string) (string, error) {
+	return urlParser{}.canonicalize(url)
+}
+
+// CanonicalizeAll canonicalizes urls in parallel like Canonicalize. The
+// results are in the same order as urls. If urls[i] cannot be canonicalized,
+// errs[i] reports why, and canonical[i] is empty.
+func CanonicalizeAll(urls []string) (canonical []string, errs []error) {
+	return urlParser{}.canonicalizeAll(urls)
+}
+
+// canonicalizeBatch is the number of URLs that a worker of canonicalizeAll
+// takes at a time.
+const canonicalizeBatch = 256
+
+func (up urlParser) canonicalize(url string) (string, error) {
+	if up.schemeAction(url) == SchemeReject {
+		return "", ErrSchemeRejected
+	}
+	return up.canonicalURL(url)
+}
+
+func (up urlParser) canonicalizeAll(urls []string) ([]string, []error) {
+	canonical := make([]string, len(urls))
+	errs := make([]error, len(urls))
+	workers := (len(urls) + canonicalizeBatch - 1) / canonicalizeBatch
+	if n := runtime.GOMAXPROCS(0); workers > n {
+		workers = n
+	}
+	var next int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				end := int(atomic.AddInt64(&next, canonicalizeBatch))
+				start := end - canonicalizeBatch
+				if start >= len(urls) {
+					return
+				}
+				if end > len(urls) {
+					end = len(urls)
+				}
+				for i := start; i < end; i++ {
+					canonical[i], errs[i] = up.canonicalize(urls[i])
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return canonical, errs
+}
+
 // generateHashes returns a set of full hashes for all patterns in the URL.
-func generateHashes(url string) (map[hashPrefix]string, error) {
-	patterns, err := generatePatterns(url)
+// URLs whose scheme is passed through have no hashes.
+func (up urlParser) generateHashes(url string) (map[hashPrefix]string, error) {
+	switch up.schemeAction(url) {
+	case SchemePass:
+		return nil, nil
+	case SchemeReject:
+		return nil, ErrSchemeRejected
+	}
+	patterns, err := up.generatePatterns(url)
 	if err != nil {
 		return nil, err
 	}
 
-	hashes := make(map[hashPrefix]string)
-	for _, p := range patterns {
-		hashes[hashFromPattern(p)] = p
+	hashes := make(map[hashPrefix]string, len(patterns))
+	if up.parallelThreshold <= 0 || len(patterns) < up.parallelThreshold {
+		for _, p := range patterns {
+			hashes[hashFromPattern(p)] = p
+		}
+		return hashes, nil
+	}
+	full := make([]hashPrefix, len(patterns))
+	parallelFor(len(patterns), func(i int) bool {
+		full[i] = hashFromPattern(patterns[i])
+		return true
+	})
+	for i, p := range patterns {
+		hashes[full[i]] = p
 	}
 	return hashes, nil
 }
 
 // generatePatterns returns all possible host-suffix and path-prefix patterns
 // for the input URL.
-func generatePatterns(url string) ([]string, error) {
-	hosts, err := generateLookupHosts(url)
+func (up urlParser) generatePatterns(url string) ([]string, error) {
+	hosts, err := up.generateLookupHosts(url)
 	if err != nil {
 		return nil, err
 	}
-	paths, err := generateLookupPaths(url)
+	paths, err := up.generateLookupPaths(url)
 	if err != nil {
 		return nil, err
 	}
@@ -233,9 +465,74 @@ func getScheme(url string) (scheme, path string) {
 	return "", url
 }
 
+// hasPunycode reports whether any label of host is in punycode.
+func hasPunycode(host string) bool {
+	for _, label := range strings.Split(host, ".") {
+		if len(label) >= 4 && strings.EqualFold(label[:4], "xn--") {
+			return true
+		}
+	}
+	return false
+}
+
+// toASCII converts host to its ASCII form according to the IDN policy. The
+// host is returned unchanged if it has no internationalized labels.
+func (up urlParser) toASCII(host string) (string, error) {
+	u := unescape(host)
+	switch up.idn {
+	case IDNPunycode:
+		if !isUnicode(u) {
+			return host, nil
+		}
+		return idna.ToASCII(u)
+	case IDNStrict:
+		if !isUnicode(u) && !hasPunycode(u) {
+			return host, nil
+		}
+		if !utf8.ValidString(u) {
+			return "", errors.New("webrisk: invalid UTF-8 in hostname")
+		}
+		a, err := idna.Lookup.ToASCII(u)
+		if err != nil {
+			return "", fmt.Errorf("webrisk: invalid internationalized hostname: %v", err)
+		}
+		return a, nil
+	}
+	if !isUnicode(u) {
+		return host, nil
+	}
+	if a, err := idnaLenient.ToASCII(u); err == nil {
+		return a, nil
+	}
+	return idna.ToASCII(u)
+}
+
+// checkHost reports an error for a host, stripped of its port, that strict
+// mode cannot canonicalize unambiguously.
+func checkHost(host string) error {
+	if strings.HasPrefix(host, "[") {
+		ip := strings.TrimPrefix(host, "[")
+		if !strings.HasSuffix(ip, "]") {
+			return errors.New("webrisk: missing ']' in host")
+		}
+		ip = strings.TrimSuffix(ip, "]")
+		if i := strings.Index(ip, "%25"); i >= 0 {
+			ip = ip[:i] // Remove the zone.
+		}
+		if net.ParseIP(ip) == nil || !strings.Contains(ip, ":") {
+			return errors.New("webrisk: invalid IPv6 address in host")
+		}
+		return nil
+	}
+	if strings.Contains(host, ":") {
+		return errors.New("webrisk: invalid port in host")
+	}
+	return nil
+}
+
 // parseHost parses a string to get host by the stripping the
 // username, password, and port.
-func parseHost(hostish string) (host string, err error) {
+func (up urlParser) parseHost(hostish string) (host string, err error) {
 	i := strings.LastIndex(hostish, "@")
 	if i < 0 {
 		host = hostish
@@ -252,16 +549,24 @@ func parseHost(hostish string) (host string, err error) {
 	}
 	// Remove the port if it is there.
 	host = portRegexp.ReplaceAllString(host, "")
-
-	// Convert internationalized hostnames to IDNA.
-	u := unescape(host)
-	if isUnicode(u) {
-		host, err = idna.ToASCII(u)
-		if err != nil {
+	if up.strict {
+		if err := checkHost(host); err != nil {
 			return "", err
 		}
 	}
 
+	// Convert internationalized hostnames to IDNA.
+	a, err := up.toASCII(host)
+	if err != nil {
+		return "", err
+	}
+	// Mapping may produce delimiters, such as '/' from a full-width solidus,
+	// which would become part of the path when canonicalized again.
+	if up.strict && a != host && strings.ContainsAny(a, "/\\?#@:[]% ") {
+		return "", errors.New("webrisk: invalid character in hostname")
+	}
+	host = a
+
 	// Remove any superfluous '.' characters in the hostname.
 	host = dotsRegexp.ReplaceAllString(host, ".")
 	host = strings.Trim(host, ".")
@@ -293,7 +598,7 @@ func parseHost(hostish string) (host string, err error) {
 }
 
 // parseURL parses urlStr as a url.URL and reports an error if not possible.
-func parseURL(urlStr string) (parsedURL *url.URL, err error) {
+func (up urlParser) parseURL(urlStr string) (parsedURL *url.URL, err error) {
 	// For legacy reasons, this is a simplified version of the net/url logic.
 	//
 	// Few cases where net/url was not helpful:
@@ -321,6 +626,7 @@ func parseURL(urlStr string) (parsedURL *url.URL, err error) {
 		return nil, err
 	}
 	parsedURL.Scheme, rest = getScheme(rest)
+	parsedURL.Scheme = strings.ToLower(parsedURL.Scheme)
 	rest, parsedURL.RawQuery = split(rest, "?", true)
 
 	// Add HTTP as scheme if none.
@@ -338,10 +644,13 @@ func parseURL(urlStr string) (parsedURL *url.URL, err error) {
 		return nil, errors.New("webrisk: missing hostname")
 	}
 
-	parsedURL.Host, err = parseHost(hostish)
+	parsedURL.Host, err = up.parseHost(hostish)
 	if err != nil {
 		return nil, err
 	}
+	if parsedURL.Host == "" {
+		return nil, errors.New("webrisk: missing hostname")
+	}
 	// Format the path.
 	p := path.Clean(rest)
 	if p == "." {
@@ -412,10 +721,34 @@ func canonicalNum(s string, n int) string {
 	return strings.Join(ss, ".")
 }
 
+// CanonicalIP reports whether host, as it appears in a URL, is an IP address,
+// and returns it the way LookupURLs canonicalizes it. IPv4 addresses written
+// in hex or octal, as a single number, or with fewer than four parts, such as
+// "0x7f.1" or "3279880203", become four decimal parts. IPv6 literals are kept
+// in brackets and lowercased, but are otherwise left as written, since the
+// threat lists are built from them that way. Any user info and port are
+// removed.
+func CanonicalIP(host string) (string, bool) {
+	host, err := urlParser{}.parseHost(host)
+	if err != nil || !isIPHost(host) {
+		return "", false
+	}
+	return host, true
+}
+
+// isIPHost reports whether the canonical host is an IP address.
+func isIPHost(host string) bool {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		ip, _, _ := strings.Cut(host[1:len(host)-1], "%")
+		return strings.Contains(ip, ":") && net.ParseIP(ip) != nil
+	}
+	return net.ParseIP(host).To4() != nil
+}
+
 // canonicalURL parses a URL string and returns it as scheme://hostname/path.
 // It strips off fragments and queries.
-func canonicalURL(u string) (string, error) {
-	parsedURL, err := parseURL(u)
+func (up urlParser) canonicalURL(u string) (string, error) {
+	parsedURL, err := up.parseURL(u)
 	if err != nil {
 		return "", err
 	}
@@ -428,8 +761,8 @@ func canonicalURL(u string) (string, error) {
 	return u, nil
 }
 
-func canonicalHost(urlStr string) (string, error) {
-	parsedURL, err := parseURL(urlStr)
+func (up urlParser) canonicalHost(urlStr string) (string, error) {
+	parsedURL, err := up.parseURL(urlStr)
 	if err != nil {
 		return "", err
 	}
@@ -438,7 +771,7 @@ func canonicalHost(urlStr string) (string, error) {
 }
 
 // generateLookupHosts returns a list of host-suffixes for the input URL.
-func generateLookupHosts(urlStr string) ([]string, error) {
+func (up urlParser) generateLookupHosts(urlStr string) ([]string, error) {
 	// Web Risk policy asks to generate lookup hosts for the URL.
 	// Those are formed by the domain and also up to 4 hostnames suffixes.
 	// The last component or sometimes the pair isn't examined alone,
@@ -448,10 +781,15 @@ func generateLookupHosts(urlStr string) ([]string, error) {
 	// Note that we do not need to be clever about stopping at the "real" TLD.
 	// We just check a few extra components regardless. It's not significantly
 	// slower on the server side to check some extra hashes. Also the client
-	// does not need to keep a database of TLDs.
-	const maxHostComponents = 7
+	// does not need to keep a database of TLDs. However, if publicSuffixes is
+	// set, the public suffix list is used to count a multi-label public
+	// suffix such as "co.uk" as a single component, and to skip it.
+	maxHostComponents := up.maxHostComponents
+	if maxHostComponents <= 0 {
+		maxHostComponents = DefaultMaxHostComponents
+	}
 
-	host, err := canonicalHost(urlStr)
+	host, err := up.canonicalHost(urlStr)
 	if err != nil {
 		return nil, err
 	}
@@ -461,23 +799,31 @@ func generateLookupHosts(urlStr string) ([]string, error) {
 		return []string{host}, nil
 	}
 	hostComponents := strings.Split(host, ".")
+	suffixComponents := 1
+	if up.publicSuffixes {
+		// Private suffixes, such as "blogspot.com", are not skipped, since
+		// their subdomains belong to the same operator.
+		if ps, icann := publicsuffix.PublicSuffix(host); icann {
+			suffixComponents = strings.Count(ps, ".") + 1
+		}
+	}
 
-	numComponents := len(hostComponents) - maxHostComponents
+	numComponents := len(hostComponents) - maxHostComponents - (suffixComponents - 1)
 	if numComponents < 1 {
 		numComponents = 1
 	}
 
 	hosts := []string{host}
-	for i := numComponents; i < len(hostComponents)-1; i++ {
+	for i := numComponents; i < len(hostComponents)-suffixComponents; i++ {
 		hosts = append(hosts, strings.Join(hostComponents[i:], "."))
 	}
 	return hosts, nil
 }
 
-func canonicalPath(urlStr string) (string, error) {
+func (up urlParser) canonicalPath(urlStr string) (string, error) {
 	// Note that this function is not used, but remains to ensure that the
 	// parsedURL.Path output matches C++ implementation.
-	parsedURL, err := parseURL(urlStr)
+	parsedURL, err := up.parseURL(urlStr)
 	if err != nil {
 		return "", err
 	}
@@ -485,10 +831,13 @@ func canonicalPath(urlStr string) (string, error) {
 }
 
 // generateLookupPaths returns a list path-prefixes for the input URL.
-func generateLookupPaths(urlStr string) ([]string, error) {
-	const maxPathComponents = 4
+func (up urlParser) generateLookupPaths(urlStr string) ([]string, error) {
+	maxPathComponents := up.maxPathComponents
+	if maxPathComponents <= 0 {
+		maxPathComponents = DefaultMaxPathComponents
+	}
 
-	parsedURL, err := parseURL(urlStr)
+	parsedURL, err := up.parseURL(urlStr)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,26 @@
+#!/bin/bash
+# This is a utility script for evaluating performance changes to the library.
+# It runs the benchmarks of the webrisk package several times, writing the
+# results in a format that benchstat can compare, along with CPU and memory
+# profiles of the last run.
+
+# Usage info and args.
+if [ $# -lt 1 ] || [ $# -gt 3 ]; then
+  echo "Usage: $0 <output_dir> [benchmark_regexp] [count]"
+  exit 1
+fi
+
+out=$1
+bench=${2:-.}
+count=${3:-6}
+
+mkdir -p "$out" && out=$(cd "$out" && pwd) || exit 1
+cd "$(dirname "$0")/.." || exit 1
+
+go test -run='^$' -bench="$bench" -benchmem -count="$count" . | tee "$out/bench.txt" || exit 1
+go test -run='^$' -bench="$bench" -benchmem -count=1 \
+  -cpuprofile="$out/cpu.prof" -memprofile="$out/mem.prof" -o "$out/webrisk.test" . >/dev/null || exit 1
+
+echo "Results written to $out. To inspect the profiles, run:"
+echo "  go tool pprof $out/webrisk.test $out/cpu.prof"
+echo "  go tool pprof -sample_index=alloc_space $out/webrisk.test $out/mem.prof"
@@ -0,0 +1,129 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// modulePath is the path of this module, as listed in the build information
+// of binaries that use it.
+const modulePath = "github.com/google/webrisk"
+
+// The version, commit and build date of this build, which are set at link
+// time, such as with:
+//
+//	go build -ldflags "\
+//	    -X github.com/google/webrisk.version=v1.2.3 \
+//	    -X github.com/google/webrisk.commit=$(git rev-parse HEAD) \
+//	    -X github.com/google/webrisk.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
+//	    ./cmd/wrserver
+//
+// Those that are not set are taken from the build information that the go
+// command embeds in binaries, when it is available.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+// BuildInfo identifies the build of this package that is running.
+type BuildInfo struct {
+	// Version is the version of this module, such as v1.2.3, or "(devel)"
+	// if it was built from a working copy. It is empty if unknown.
+	Version string `json:"version"`
+
+	// Commit is the revision of the source code that was built, if known.
+	Commit string `json:"commit,omitempty"`
+
+	// BuildDate is when the binary was built, or when the commit was made
+	// if it was not set at link time, in RFC 3339 format.
+	BuildDate string `json:"buildDate,omitempty"`
+
+	// GoVersion is the version of Go that the binary was built with.
+	GoVersion string `json:"goVersion"`
+}
+
+// String returns a single line description of b, for logs and the -version
+// flag of the commands.
+func (b BuildInfo) String() string {
+	s := b.Version
+	if s == "" {
+		s = "unknown"
+	}
+	if b.Commit != "" {
+		s += " commit " + b.Commit
+	}
+	if b.BuildDate != "" {
+		s += " built " + b.BuildDate
+	}
+	return fmt.Sprintf("%s %s", s, b.GoVersion)
+}
+
+// Version returns the version of this package, or "" if it is unknown, so
+// that bug reports and dashboards can tell which build is running.
+func Version() string {
+	return ReadBuildInfo().Version
+}
+
+// ReadBuildInfo returns the version, commit and build date of this package,
+// as set at link time, or as recorded by the go command otherwise.
+func ReadBuildInfo() BuildInfo {
+	b := BuildInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	if b.Version == "" {
+		// This package is either the main module, when a command of this
+		// repository is built, or a dependency of it.
+		if info.Main.Path == modulePath {
+			b.Version = info.Main.Version
+		}
+		for _, dep := range info.Deps {
+			if dep.Path == modulePath {
+				b.Version = dep.Version
+				if dep.Replace != nil {
+					b.Version = dep.Replace.Version
+				}
+			}
+		}
+	}
+	// The revision is only recorded for the main module.
+	if info.Main.Path != modulePath {
+		return b
+	}
+	var revision, modified string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		case "vcs.time":
+			if b.BuildDate == "" {
+				b.BuildDate = s.Value
+			}
+		}
+	}
+	if b.Commit == "" && revision != "" {
+		b.Commit = revision
+		if modified == "true" {
+			b.Commit += "-dirty"
+		}
+	}
+	return b
+}
@@ -0,0 +1,50 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisk
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadBuildInfo(t *testing.T) {
+	defer func(v, c, d string) {
+		version, commit, buildDate = v, c, d
+	}(version, commit, buildDate)
+	version, commit, buildDate = "v1.2.3", "abc123", "2023-06-01T00:00:00Z"
+
+	want := BuildInfo{Version: "v1.2.3", Commit: "abc123", BuildDate: "2023-06-01T00:00:00Z", GoVersion: runtime.Version()}
+	if got := ReadBuildInfo(); !cmp.Equal(got, want) {
+		t.Errorf("ReadBuildInfo() = %+v, want %+v", got, want)
+	}
+	if got := Version(); got != "v1.2.3" {
+		t.Errorf("Version() = %q, want v1.2.3", got)
+	}
+
+	vectors := []struct {
+		info BuildInfo
+		want string
+	}{
+		{want, "v1.2.3 commit abc123 built 2023-06-01T00:00:00Z " + runtime.Version()},
+		{BuildInfo{GoVersion: "go1.19"}, "unknown go1.19"},
+		{BuildInfo{Version: "(devel)", Commit: "abc123-dirty", GoVersion: "go1.19"}, "(devel) commit abc123-dirty go1.19"},
+	}
+	for i, v := range vectors {
+		if got := v.info.String(); got != v.want {
+			t.Errorf("test %d, String() = %q, want %q", i, got, v.want)
+		}
+	}
+}
@@ -62,12 +62,18 @@
 package webrisk
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"os"
+	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -90,21 +96,123 @@ const (
 	// DefaultRequestTimeout is the default amount of time a single
 	// api request can take.
 	DefaultRequestTimeout = time.Minute
+
+	// DefaultCacheSavePeriod is the default period for how often
+	// UpdateClient saves its cache to Config.CachePath.
+	DefaultCacheSavePeriod = 10 * time.Minute
+
+	// DefaultMetricsPeriod is the default period for how often
+	// UpdateClient records its statistics with Config.MetricsRecorder.
+	DefaultMetricsPeriod = time.Minute
+
+	// DefaultStatsSavePeriod is the default period for how often
+	// UpdateClient saves its statistics to Config.StatsPath.
+	DefaultStatsSavePeriod = time.Minute
+
+	// DefaultMaxIdleConns is the default number of idle connections to the
+	// Web Risk API that are kept open for reuse.
+	DefaultMaxIdleConns = 100
+
+	// DefaultIdleConnTimeout is the default amount of time an idle
+	// connection to the Web Risk API is kept open.
+	DefaultIdleConnTimeout = 90 * time.Second
+
+	// DefaultKeepAlive is the default period of the TCP keep-alive probes
+	// of the connections to the Web Risk API.
+	DefaultKeepAlive = 30 * time.Second
+
+	// DefaultCircuitBreakerCooldown is the default amount of time the
+	// circuit breaker stays open. See Config.CircuitBreakerThreshold.
+	DefaultCircuitBreakerCooldown = 30 * time.Second
+
+	// staleCheckPeriod is how often the updater checks whether the
+	// database went stale, to notify Config.Events.
+	staleCheckPeriod = time.Minute
 )
 
 // Errors specific to this package.
 var (
-	errClosed = errors.New("webrisk: handler is closed")
-	errStale  = errors.New("webrisk: threat list is stale")
+	errClosed   = errors.New("webrisk: handler is closed")
+	errReleased = errors.New("webrisk: database was released to another process")
+
+	errChecksumMismatch = errors.New("webrisk: threat list SHA256 mismatch")
 )
 
+// ErrStale is returned by lookups while the database is stale, unless
+// Config.StalePolicy serves them anyway, and reported by Status.
+var ErrStale = errors.New("webrisk: threat list is stale")
+
 // ThreatType is an enumeration type for threats classes. Examples of threat
 // classes are malware, social engineering, etc.
 type ThreatType uint16
 
-func (tt ThreatType) String() string { return pb.ThreatType(tt).String() }
+// threatTypePrefix is the prefix that some configurations give the names of
+// the threat types, as THREAT_TYPE_UNSPECIFIED has in the Web Risk API.
+const threatTypePrefix = "THREAT_TYPE_"
+
+// String returns the name of the threat type, such as "MALWARE", as used
+// by the Web Risk API, which ParseThreatType parses back.
+func (tt ThreatType) String() string {
+	if name, ok := pb.ThreatType_name[int32(tt)]; ok {
+		return name
+	}
+	return fmt.Sprintf("ThreatType(%d)", int(tt))
+}
+
+// ParseThreatType returns the threat type of name, such as "MALWARE". Names
+// are case-insensitive, and may have the THREAT_TYPE_ prefix of the enum
+// values of the API, such as "THREAT_TYPE_MALWARE". Unlike the other threat
+// types, THREAT_TYPE_UNSPECIFIED is not valid.
+func ParseThreatType(name string) (ThreatType, error) {
+	v := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), threatTypePrefix)
+	if tt, ok := pb.ThreatType_value[v]; ok && ThreatType(tt) != ThreatTypeUnspecified {
+		return ThreatType(tt), nil
+	}
+	return ThreatTypeUnspecified, errors.New("webrisk: unknown threat type: " + name)
+}
+
+// ParseThreatTypes parses a comma-separated list of threat type names, as
+// taken by ParseThreatType, such as "MALWARE,SOCIAL_ENGINEERING". An empty
+// list, or one that includes "ALL", stands for DefaultThreatLists.
+func ParseThreatTypes(s string) ([]ThreatType, error) {
+	if strings.TrimSpace(s) == "" {
+		return append([]ThreatType(nil), DefaultThreatLists...), nil
+	}
+	r := []ThreatType{}
+	for _, v := range strings.Split(s, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), "ALL") {
+			return append([]ThreatType(nil), DefaultThreatLists...), nil
+		}
+		tt, err := ParseThreatType(v)
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, tt)
+	}
+	return r, nil
+}
+
+// MarshalText encodes the ThreatType as its name, such as "MALWARE".
+func (tt ThreatType) MarshalText() ([]byte, error) { return []byte(tt.String()), nil }
+
+// UnmarshalText decodes a ThreatType from a name taken by ParseThreatType.
+func (tt *ThreatType) UnmarshalText(b []byte) error {
+	v, err := ParseThreatType(string(b))
+	if err != nil {
+		return err
+	}
+	*tt = v
+	return nil
+}
 
 // List of ThreatType constants.
+//
+// ThreatTypeSocialEngineering is the social engineering list that browsers
+// are shipped with. ThreatTypeSocialEngineeringExtended, its extended
+// coverage, is a separate list of a greater range of risky sites, which
+// catches more of them at the cost of more false positives. The two lists are
+// subscribed to and reported separately, unless Config.ThreatTypeAliases
+// reports the extended coverage as SOCIAL_ENGINEERING.
 const (
 	ThreatTypeUnspecified               = ThreatType(pb.ThreatType_THREAT_TYPE_UNSPECIFIED)
 	ThreatTypeMalware                   = ThreatType(pb.ThreatType_MALWARE)
@@ -139,6 +247,29 @@ type Config struct {
 	// If empty, the underlying library uses $HTTP_PROXY environment variable.
 	ProxyURL string
 
+	// MaxIdleConns is the number of idle connections to the Web Risk API
+	// that are kept open, so that updates and lookups reuse them instead of
+	// making new connections and TLS handshakes. If zero, it defaults to
+	// DefaultMaxIdleConns.
+	MaxIdleConns int
+
+	// IdleConnTimeout is how long an idle connection to the API is kept
+	// open. Proxies that close idle connections sooner cause failed calls
+	// unless it is lower. If zero, it defaults to DefaultIdleConnTimeout.
+	IdleConnTimeout time.Duration
+
+	// KeepAlive is the period of the TCP keep-alive probes of the
+	// connections to the API, which keep them from being dropped by
+	// firewalls and NATs while idle. If zero, it defaults to
+	// DefaultKeepAlive. If negative, keep-alive probes are disabled.
+	KeepAlive time.Duration
+
+	// DisableHTTP2 makes the connections to the API use HTTP/1.1, for
+	// proxies that mishandle HTTP/2. By default, HTTP/2 is used if the
+	// server or proxy supports it, which lets concurrent calls share a
+	// single connection.
+	DisableHTTP2 bool
+
 	// APIKey is the key used to authenticate with the Web Risk API
 	// service. This field is required.
 	APIKey string
@@ -156,23 +287,56 @@ type Config struct {
 	// of the UpdateClient object.
 	DBPath string
 
+	// WritePolicy determines how the database file at DBPath is written.
+	// Use NewFileStore to apply a policy to a Store.
+	// If zero value, it defaults to WriteSync.
+	WritePolicy WritePolicy
+
+	// Store persists the database in place of DBPath. It allows the database
+	// to be kept outside the local file system, such as in a bucket shared by
+	// several instances. See OpenStore.
+	Store Store
+
 	// UpdatePeriod determines how often we update the internal list database.
 	// If zero value, it defaults to DefaultUpdatePeriod.
 	UpdatePeriod time.Duration
 
 	// ThreatListArg is an optional string that will be parsed into ThreatLists.
-	// It is expected that names will be an exact match and comma-separated.
-	// For Example: 'MALWARE,SOCIAL_ENGINEERING'.
+	// It is expected that names will be comma-separated, as taken by
+	// ParseThreatTypes. For Example: 'MALWARE,SOCIAL_ENGINEERING'.
 	// Will also accept 'ALL' and load all threat types.
+	// A name may be followed by '=' and another name, to report the threats
+	// of that list as the other threat type, which is added to
+	// ThreatTypeAliases. For example,
+	// 'MALWARE,SOCIAL_ENGINEERING_EXTENDED_COVERAGE=SOCIAL_ENGINEERING'
+	// subscribes to the extended coverage of social engineering instead of
+	// the standard list, but reports its threats as SOCIAL_ENGINEERING.
 	// If empty, ThreatLists will be loaded instead.
 	ThreatListArg string
 
+	// IgnoreUnknownThreatTypes makes NewUpdateClient log and drop the
+	// entries of ThreatListArg with a threat type that it does not know,
+	// such as one added in a newer version, instead of failing, so that
+	// older binaries can be given newer configurations. It still fails if
+	// none of the threat types is known.
+	IgnoreUnknownThreatTypes bool
+
 	// ThreatLists determines which threat lists that UpdateClient should
 	// subscribe to. The threats reported by LookupURLs will only be ones that
 	// are specified by this list.
 	// If empty, it defaults to DefaultThreatLists.
 	ThreatLists []ThreatType
 
+	// ThreatTypeAliases reports the threats found in the threat list of each
+	// key as the threat type of its value instead, for clients that only know
+	// some threat types, such as to report the threats of
+	// ThreatTypeSocialEngineeringExtended as ThreatTypeSocialEngineering.
+	// A URL found in several lists that are reported as the same threat type
+	// is reported once for it. Threat lists without an alias are reported as
+	// their own threat type, so that, for example, the extended coverage of
+	// social engineering can be told apart from the standard list.
+	ThreatTypeAliases map[ThreatType]ThreatType
+
 	// RequestTimeout determines the timeout value for the http client.
 	RequestTimeout time.Duration
 
@@ -181,13 +345,250 @@ type Config struct {
 	// If empty, no logs will be written.
 	Logger io.Writer
 
-	// The minimum TTLs to enforce for cached responses
+	// MetricsRecorder, if set, is given the statistics reported by Status
+	// every MetricsPeriod, and when UpdateClient is closed, such as to push
+	// them to an OpenTelemetry collector with an OTLPRecorder.
+	MetricsRecorder MetricsRecorder
+
+	// MetricsPeriod determines how often the statistics are recorded.
+	// If zero value, it defaults to DefaultMetricsPeriod.
+	MetricsPeriod time.Duration
+
+	// StatsPath is an optional file that the cumulative counters of Stats,
+	// such as the number of queries and cache hits, are restored from when
+	// UpdateClient is created, and saved to every StatsSavePeriod and when
+	// it is closed, so that they are not zeroed by every restart. It is
+	// written according to WritePolicy, and must not be shared by several
+	// processes. See UpdateClient.ResetStats.
+	StatsPath string
+
+	// StatsSavePeriod determines how often the statistics are saved to
+	// StatsPath. If zero value, it defaults to DefaultStatsSavePeriod.
+	StatsSavePeriod time.Duration
+
+	// Cache stores the results of hash searches made to the API, such as in
+	// a cache shared by several instances. If nil, results are cached in
+	// memory, subject to MemoryLimit, CacheMaxEntries, and CacheMaxBytes.
+	Cache Cache
+
+	// DisableCache turns off caching of the results of hash searches, so
+	// that no full hashes of looked up URLs are kept, at the cost of an API
+	// call for every lookup that matches the database. Cache and CachePath
+	// are ignored.
+	DisableCache bool
+
+	// CacheMaxEntries and CacheMaxBytes limit the number of entries and the
+	// approximate number of bytes of the in-memory cache. When either is
+	// exceeded, the least recently used entries are evicted. The byte limit
+	// applies in addition to MemoryLimit. If zero, the cache is only
+	// limited by MemoryLimit.
+	CacheMaxEntries int
+	CacheMaxBytes   int64
+
+	// CachePath is an optional file that the in-memory cache is restored
+	// from when UpdateClient is created, and saved to every CacheSavePeriod
+	// and when it is closed. This way, a restart does not send every recent
+	// lookup to the API again. It is written according to WritePolicy, but
+	// never by a ReadOnly client, which cannot add anything to the cache.
+	CachePath string
+
+	// CacheSavePeriod determines how often the cache is saved to CachePath.
+	// If zero value, it defaults to DefaultCacheSavePeriod.
+	CacheSavePeriod time.Duration
+
+	// CacheRefreshWindow makes cached results that are used within this
+	// duration of their expiration be refreshed in the background, so that
+	// popular entries are renewed before they expire instead of sending a
+	// burst of lookups to the API at once. If zero, cached results are only
+	// requested again after they have expired.
+	CacheRefreshWindow time.Duration
+
+	// The minimum TTLs to enforce for cached responses, subject to TTLPolicy
 	PMinTTL time.Duration
 	NMinTTL time.Duration
 
+	// PMinTTLs and NMinTTLs override PMinTTL and NMinTTL for individual
+	// threat types, such as to cache MALWARE matches longer than
+	// SOCIAL_ENGINEERING ones. A negative response covers all the threat
+	// types that were searched for, so the shortest of their NMinTTLs
+	// applies to it.
+	PMinTTLs map[ThreatType]time.Duration
+	NMinTTLs map[ThreatType]time.Duration
+
+	// TTLPolicy determines whether the minimum TTLs above extend, shorten,
+	// or replace the cache durations given by the API. If zero value, they
+	// extend them; see TTLPolicy for details.
+	TTLPolicy TTLPolicy
+
+	// CacheJitter makes every cached result expire earlier by a random
+	// duration of up to CacheJitter, but by no more than half of its
+	// lifetime, so that results cached in the same burst, such as after a
+	// restart or by WarmCache, do not all expire and get requested from the
+	// API at the same time. If zero, results expire exactly when the API
+	// and the minimum TTLs say.
+	CacheJitter time.Duration
+
+	// MemoryLimit is the approximate number of bytes that the database and
+	// cache may use together. If the database outgrows its share of the
+	// limit, smaller threat lists are requested from the API on the next
+	// update. The cache evicts entries to stay within the remainder.
+	// If zero, memory usage is not limited.
+	MemoryLimit int64
+
+	// ReadOnly prevents UpdateClient from ever contacting the Web Risk API.
+	// Lookups are served purely from the database in Store or DBPath, which
+	// must be delivered out-of-band, such as in an air-gapped environment.
+	// The database is checked every ReloadPeriod and replaced if a newer one
+	// was saved. Since partial hash matches cannot be confirmed with the API,
+	// they are reported as threats, so a small number of false positives is
+	// to be expected.
+	ReadOnly bool
+
+	// ReadOnlyIfLocked makes UpdateClient fall back to ReadOnly mode when
+	// the database is locked because another process is already updating
+	// it. Otherwise, NewUpdateClient fails with ErrStoreLocked. Locking is
+	// only supported for local files on Unix-like systems.
+	ReadOnlyIfLocked bool
+
+	// ReloadPeriod determines how often a ReadOnly UpdateClient checks
+	// whether the database in Store or DBPath has changed. This allows a
+	// single updater to distribute the database to many readers, such as by
+	// copying the file or mounting it from a shared volume. Local files are
+	// only re-read once their size or modification time changes, so short
+	// periods are cheap.
+	// If zero value, it defaults to UpdatePeriod.
+	ReloadPeriod time.Duration
+
+	// Audit, if set, is called with a record of every update received for a
+	// threat list, whether or not it could be applied. It is called
+	// synchronously while the database is being updated. Errors are logged.
+	// See AuditLog for a rotating file implementation.
+	Audit func(AuditRecord) error
+
+	// MaxDatabaseAge is the time since the last successful update after
+	// which the database is stale: Status reports it, Config.Events is
+	// notified, and lookups are served according to StalePolicy. Until
+	// then, updates that fail to reach the API keep the database, instead
+	// of making lookups fail right away. It also applies to ReadOnly
+	// databases, according to when they were saved. If zero, the database
+	// is stale after two UpdatePeriods, or never in ReadOnly mode.
+	MaxDatabaseAge time.Duration
+
+	// StalePolicy determines whether lookups fail or keep being served
+	// once the database is stale. If zero value, they fail; see
+	// StalePolicy for details.
+	StalePolicy StalePolicy
+
+	// Events, if set, is called with every operational event, such as a
+	// failed database update, the database going stale, the circuit
+	// breaker opening, or the API quota being exhausted, so that an
+	// operator can be paged. It is called synchronously, possibly from
+	// lookups, so it must not block. Events are logged either way.
+	Events func(Event)
+
+	// CircuitBreakerThreshold is the number of consecutive failed hash
+	// searches after which the API is no longer called for
+	// CircuitBreakerCooldown. Meanwhile, lookups that need the API fail
+	// with ErrCircuitOpen right away, instead of each waiting for the API
+	// to time out. If zero, the API is always called.
+	CircuitBreakerThreshold int
+
+	// CircuitBreakerCooldown is how long the circuit breaker stays open
+	// before the API is tried again. If zero value, it defaults to
+	// DefaultCircuitBreakerCooldown.
+	CircuitBreakerCooldown time.Duration
+
+	// SearchBatchWindow, if positive, holds back each hash search that a
+	// lookup makes to the API for this long, so that the searches of
+	// concurrent lookups for the same hash prefix, made within the window,
+	// are made as a single API call, for all the threat types that they are
+	// about. This cuts the number of API calls under bursty load, at the
+	// cost of adding the window to the latency of lookups that need the
+	// API. A few milliseconds is typically enough.
+	SearchBatchWindow time.Duration
+
+	// LookupDeadline, if positive, is how long a lookup waits for the API
+	// before it returns the best answer it has, for latency-critical uses
+	// such as mail delivery or redirects. The matches of the database that
+	// the API has not confirmed or dismissed by then are reported as
+	// threats, as in read-only mode, with SourceUnconfirmed as the source of
+	// the verdict. The hash searches keep running in the background, up to
+	// RequestTimeout, so that their results are cached for later lookups.
+	LookupDeadline time.Duration
+
+	// IDNPolicy determines how internationalized hostnames in looked up
+	// URLs are canonicalized. If zero value, they are mapped according to
+	// UTS #46 as browsers do; see IDNPolicy for details.
+	IDNPolicy IDNPolicy
+
+	// Schemes determines what LookupURLs does with the URLs of each scheme,
+	// keyed by scheme, such as to pass "ftp" URLs through without
+	// looking them up, or to reject custom application schemes. The "*" key
+	// applies to the schemes that are not listed. URLs without a scheme are
+	// treated as http ones. If nil, the URLs of every scheme are looked up.
+	Schemes map[string]SchemeAction
+
+	// PublicSuffixes makes the host suffixes that URLs are looked up by be
+	// chosen according to the public suffix list, instead of by counting
+	// labels. A multi-label public suffix such as "co.uk" is then counted
+	// as a single label, so that deeply nested hostnames are looked up by
+	// the same number of registrable domains and subdomains regardless of
+	// their suffix, and is not looked up on its own.
+	PublicSuffixes bool
+
+	// StrictURLs makes lookups fail for URLs that cannot be canonicalized
+	// unambiguously, such as those with a malformed port or IPv6 address,
+	// or with a hostname whose internationalized characters map to URL
+	// delimiters. Otherwise, such URLs are canonicalized as well as
+	// possible, which may not match the expressions that the threat lists
+	// were built from.
+	StrictURLs bool
+
+	// IPHostThreat, if set, is reported by LookupURLs for every URL whose
+	// host is an IP address instead of a name, such as http://3279880203/
+	// or http://[::1]/, in addition to any threats that are found for it.
+	// Legitimate sites are rarely linked to this way, so such URLs can be
+	// treated as higher risk. The Pattern of the threat is the canonical
+	// host followed by "/", as returned by CanonicalIP.
+	IPHostThreat ThreatType
+
+	// MaxHostComponents and MaxPathComponents bound the number of
+	// expressions that each URL is looked up by, which is at most
+	// MaxHostComponents * (MaxPathComponents + 2). MaxHostComponents is
+	// the number of trailing hostname components that shorter suffixes are
+	// looked up from, and MaxPathComponents is the number of path prefixes
+	// that are looked up. If zero, they default to DefaultMaxHostComponents
+	// and DefaultMaxPathComponents, which are what the threat lists are
+	// built for: lower values keep very deep URLs cheap to look up, at the
+	// cost of missing threats listed by the expressions left out.
+	MaxHostComponents int
+	MaxPathComponents int
+
+	// ParallelMatchThreshold makes the URLs that are looked up by at least
+	// this many expressions be hashed and matched against the database and
+	// the cache by up to GOMAXPROCS goroutines, which lowers the latency of
+	// lookups of deep URLs on multiple cores. The matching of such a URL
+	// stops once the cache, or a read-only database, knows it to be a threat
+	// of every type of ThreatLists, and the API is then not asked about the
+	// other expressions. The same threat types are reported as when matching
+	// one expression at a time, but not every pattern of them. Expressions
+	// that match the database are looked up in the in-memory cache under an
+	// exclusive lock, so only the others are matched fully in parallel. With
+	// a single core, matching in parallel only adds overhead. If zero,
+	// expressions are matched one at a time.
+	ParallelMatchThreshold int
+
 	// True if we should log URLs that require a server query
 	ShouldLogQueriesByAPI bool
 
+	// Clock replaces the system clock, such as with the fake clock of the
+	// webrisktest package, so that tests can move time forward to expire
+	// cached results, run scheduled updates, or make the database stale,
+	// without sleeping. If nil, the system clock is used. Timeouts of the
+	// API calls and the latencies reported by LookupTrace always follow the
+	// system clock.
+	Clock Clock
+
 	// compressionTypes indicates how the threat entry sets can be compressed.
 	compressionTypes []pb.CompressionType
 
@@ -207,42 +608,136 @@ func (c *Config) setDefaults() bool {
 	if c.UpdatePeriod <= 0 {
 		c.UpdatePeriod = DefaultUpdatePeriod
 	}
+	if c.Store == nil && c.DBPath != "" {
+		// Keep a single fileStore, which remembers the file it last saw.
+		c.Store = NewFileStore(c.DBPath, c.WritePolicy)
+	}
+	if c.ReloadPeriod <= 0 {
+		c.ReloadPeriod = c.UpdatePeriod
+	}
+	if c.DisableCache {
+		c.Cache = nil
+	}
+	if c.CacheSavePeriod <= 0 {
+		c.CacheSavePeriod = DefaultCacheSavePeriod
+	}
+	if c.MetricsPeriod <= 0 {
+		c.MetricsPeriod = DefaultMetricsPeriod
+	}
+	if c.StatsSavePeriod <= 0 {
+		c.StatsSavePeriod = DefaultStatsSavePeriod
+	}
+	if c.MaxIdleConns <= 0 {
+		c.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if c.IdleConnTimeout <= 0 {
+		c.IdleConnTimeout = DefaultIdleConnTimeout
+	}
+	if c.KeepAlive == 0 {
+		c.KeepAlive = DefaultKeepAlive
+	}
+	if c.CircuitBreakerCooldown <= 0 {
+		c.CircuitBreakerCooldown = DefaultCircuitBreakerCooldown
+	}
 	if c.RequestTimeout <= 0 {
 		c.RequestTimeout = DefaultRequestTimeout
 	}
+	if c.MaxHostComponents <= 0 {
+		c.MaxHostComponents = DefaultMaxHostComponents
+	}
+	if c.MaxPathComponents <= 0 {
+		c.MaxPathComponents = DefaultMaxPathComponents
+	}
 	if c.compressionTypes == nil {
 		c.compressionTypes = []pb.CompressionType{pb.CompressionType_RAW, pb.CompressionType_RICE}
 	}
 	return true
 }
 
-// parseThreatTypes accepts a string of named ThreatTypes and parses it into
-// an array of valid types. It is used to load command line arguments.
-func parseThreatTypes(args string) ([]ThreatType, error) {
-	if args == "" || args == "ALL" {
-		return DefaultThreatLists, nil
-	}
-	r := []ThreatType{}
-	for _, v := range strings.Split(args, ",") {
-		if v == "ALL" {
-			return DefaultThreatLists, nil
+// parseThreatListArg parses a Config.ThreatListArg into the threat lists it
+// subscribes to, and the aliases of those that are reported as another
+// threat type. If ignoreUnknown is set, the entries with an unknown threat
+// type are dropped, and returned in unknown, unless none is left.
+func parseThreatListArg(arg string, ignoreUnknown bool) (lists []ThreatType, aliases map[ThreatType]ThreatType, unknown []string, err error) {
+	var names []string
+	for _, v := range strings.Split(arg, ",") {
+		name, alias, ok := strings.Cut(v, "=")
+		if !ok {
+			if _, err := ParseThreatType(name); err != nil && ignoreUnknown && !strings.EqualFold(strings.TrimSpace(name), "ALL") {
+				unknown = append(unknown, v)
+				continue
+			}
+			names = append(names, name)
+			continue
 		}
-		tt := ThreatType(pb.ThreatType_value[v])
-		if tt == ThreatTypeUnspecified {
-			return nil, errors.New("webrisk: unknown threat type: " + v)
+		tt, err := ParseThreatType(name)
+		if err == nil {
+			var at ThreatType
+			if at, err = ParseThreatType(alias); err == nil {
+				if aliases == nil {
+					aliases = make(map[ThreatType]ThreatType)
+				}
+				aliases[tt] = at
+				names = append(names, name)
+				continue
+			}
 		}
-		r = append(r, tt)
+		if !ignoreUnknown {
+			return nil, nil, nil, errors.New("webrisk: invalid threat type alias: " + v)
+		}
+		unknown = append(unknown, v)
 	}
-	return r, nil
+	if len(names) == 0 {
+		return nil, nil, unknown, errors.New("webrisk: no known threat type in " + arg)
+	}
+	lists, err = ParseThreatTypes(strings.Join(names, ","))
+	return lists, aliases, unknown, err
+}
+
+// store returns the Store that the database is persisted in, or nil if the
+// database is not persistent.
+func (c *Config) store() Store {
+	if c.Store != nil {
+		return c.Store
+	}
+	if c.DBPath != "" {
+		return NewFileStore(c.DBPath, c.WritePolicy)
+	}
+	return nil
 }
 
 func (c Config) copy() Config {
 	c2 := c
 	c2.ThreatLists = append([]ThreatType(nil), c.ThreatLists...)
 	c2.compressionTypes = append([]pb.CompressionType(nil), c.compressionTypes...)
+	if c.ThreatTypeAliases != nil {
+		c2.ThreatTypeAliases = make(map[ThreatType]ThreatType, len(c.ThreatTypeAliases))
+		for tt, alias := range c.ThreatTypeAliases {
+			c2.ThreatTypeAliases[tt] = alias
+		}
+	}
+	c2.PMinTTLs = copyTTLs(c.PMinTTLs)
+	c2.NMinTTLs = copyTTLs(c.NMinTTLs)
+	if c.Schemes != nil {
+		c2.Schemes = make(map[string]SchemeAction, len(c.Schemes))
+		for scheme, a := range c.Schemes {
+			c2.Schemes[strings.ToLower(scheme)] = a
+		}
+	}
 	return c2
 }
 
+func copyTTLs(ttls map[ThreatType]time.Duration) map[ThreatType]time.Duration {
+	if ttls == nil {
+		return nil
+	}
+	c := make(map[ThreatType]time.Duration, len(ttls))
+	for tt, d := range ttls {
+		c[tt] = d
+	}
+	return c
+}
+
 // UpdateClient is a client implementation of API v4.
 //
 // It provides a set of lookup methods that allows the user to query whether
@@ -255,22 +750,70 @@ type UpdateClient struct {
 	api    api
 	db     database
 	c      cache
+	urls   urlParser
+
+	flights flightGroup    // Hash searches in flight
+	batcher *searchBatcher // Nil unless SearchBatchWindow is positive
+	breaker breaker        // Circuit breaker of the hash searches
+
+	quotaExhausted uint32       // Whether the last API call was refused for quota
+	updateFailed   bool         // Whether the last update failed, used by the updater
+	nextUpdate     atomic.Int64 // Unix nanoseconds of the next scheduled update, if any
+	stale          bool         // Whether the database was stale, used by the updater
+
+	cacheStore Store // Where the cache is persisted, nil if it is not
+	statsStore Store // Where the statistics are persisted, nil if they are not
+
+	statsMu   sync.Mutex
+	statsBase statsCounters // Added to the counters since the client was created
 
 	lists map[ThreatType]bool
 
 	log *log.Logger
 
-	closed uint32
-	done   chan bool // Signals that the updater routine should stop
+	closed   uint32
+	released uint32             // Whether Release was called
+	done     chan bool          // Signals that the updater routine should stop
+	stopped  chan struct{}      // Closed once the updater routine has stopped
+	updates  chan chan error    // Requests to the updater to update right away
+	releases chan chan struct{} // Requests to the updater to release the database
+	resumes  chan chan error    // Requests to the updater to take the database back
 }
 
 // Stats records statistics regarding UpdateClient's operation.
 type Stats struct {
-	QueriesByDatabase int64         // Number of queries satisfied by the database alone
-	QueriesByCache    int64         // Number of queries satisfied by the cache alone
-	QueriesByAPI      int64         // Number of queries satisfied by an API call
-	QueriesFail       int64         // Number of queries that could not be satisfied
-	DatabaseUpdateLag time.Duration // Duration since last *missed* update. 0 if next update is in the future.
+	QueriesByDatabase  int64         // Number of queries satisfied by the database alone
+	QueriesByCache     int64         // Number of queries satisfied by the cache alone
+	QueriesByAPI       int64         // Number of queries satisfied by an API call
+	QueriesFail        int64         // Number of queries that could not be satisfied
+	QueriesUnconfirmed int64         // Number of queries answered unconfirmed after the LookupDeadline
+	DatabaseUpdateLag  time.Duration // Duration since last *missed* update. 0 if next update is in the future.
+	DatabaseAge        time.Duration // Duration since the last successful update, 0 if there was none. See Config.MaxDatabaseAge.
+	DatabaseMemory     int64         // Approximate number of bytes used by the database
+	CacheMemory        int64         // Approximate number of bytes used by the cache
+	CacheEvictions     int64         // Number of cache entries evicted before they expired
+	CacheRefreshes     int64         // Number of cached results refreshed before they expired
+
+	CachePositiveHits    int64 // Number of cache lookups that found a threat
+	CacheNegativeHits    int64 // Number of cache lookups that found no threat
+	CacheMisses          int64 // Number of cache lookups that found nothing valid
+	CacheExpired         int64 // Number of cache misses due to an expired entry
+	CachePositiveEntries int   // Number of full hashes in the in-memory cache
+	CacheNegativeEntries int   // Number of hash prefixes in the in-memory cache
+
+	Lists map[ThreatType]ListStats // Statistics for each subscribed threat list
+}
+
+// ListStats records statistics regarding a single threat list.
+type ListStats struct {
+	Entries            int           // Number of hash prefixes in the list
+	LastUpdate         time.Time     // Last time the list was successfully updated
+	LastUpdateType     string        // Response type of the last update, either "RESET" or "DIFF"
+	LastUpdateDuration time.Duration // Time taken to request and apply the last update
+	LastAdded          int           // Number of entries added by the last update
+	LastRemoved        int           // Number of entries removed by the last update
+	ChecksumOK         bool          // Whether the last update received matched its checksum
+	ChecksumFailures   int64         // Number of updates rejected due to a checksum mismatch
 }
 
 // NewUpdateClient creates a new UpdateClient.
@@ -284,31 +827,88 @@ func NewUpdateClient(conf Config) (*UpdateClient, error) {
 	}
 
 	// Parse threat types if args are passed.
+	var unknownThreatTypes []string
 	if conf.ThreatListArg != "" {
 		var err error
 		var tl []ThreatType
-		tl, err = parseThreatTypes(conf.ThreatListArg)
+		var aliases map[ThreatType]ThreatType
+		tl, aliases, unknownThreatTypes, err = parseThreatListArg(conf.ThreatListArg, conf.IgnoreUnknownThreatTypes)
 		if err != nil || len(tl) == 0 {
 			return nil, err
 		}
 		conf.ThreatLists = tl
+		for tt, alias := range aliases {
+			if conf.ThreatTypeAliases == nil {
+				conf.ThreatTypeAliases = make(map[ThreatType]ThreatType)
+			}
+			conf.ThreatTypeAliases[tt] = alias
+		}
+	}
+	for tt, alias := range conf.ThreatTypeAliases {
+		if tt == ThreatTypeUnspecified || alias == ThreatTypeUnspecified {
+			return nil, errors.New("webrisk: invalid threat type alias")
+		}
+	}
+
+	if conf.ReadOnly && conf.store() == nil {
+		return nil, errors.New("webrisk: read-only mode requires a database")
 	}
 
 	// Create the SafeBrowsing object.
 	if conf.api == nil {
 		var err error
-		conf.api, err = newNetAPI(conf.ServerURL, conf.APIKey, conf.ProxyURL)
+		conf.api, err = newAPI(&conf)
 		if err != nil {
 			return nil, err
 		}
 	}
+	if conf.Clock == nil {
+		conf.Clock = systemClock{}
+	}
 	if conf.now == nil {
-		conf.now = time.Now
+		conf.now = conf.Clock.Now
 	}
 	wr := &UpdateClient{
 		config: conf,
 		api:    conf.api,
-		c:      cache{pminTTL: conf.PMinTTL, nminTTL: conf.NMinTTL, now: conf.now},
+		c: cache{
+			external:   conf.Cache,
+			pminTTL:    conf.PMinTTL,
+			nminTTL:    conf.NMinTTL,
+			pminTTLs:   conf.PMinTTLs,
+			nminTTLs:   conf.NMinTTLs,
+			ttlPolicy:  conf.TTLPolicy,
+			jitter:     conf.CacheJitter,
+			maxEntries: conf.CacheMaxEntries,
+			disabled:   conf.DisableCache,
+			now:        conf.now,
+		},
+		urls: urlParser{
+			idn:               conf.IDNPolicy,
+			schemes:           conf.Schemes,
+			publicSuffixes:    conf.PublicSuffixes,
+			strict:            conf.StrictURLs,
+			maxHostComponents: conf.MaxHostComponents,
+			maxPathComponents: conf.MaxPathComponents,
+			parallelThreshold: conf.ParallelMatchThreshold,
+		},
+		breaker: breaker{
+			threshold: conf.CircuitBreakerThreshold,
+			cooldown:  conf.CircuitBreakerCooldown,
+			now:       conf.now,
+		},
+	}
+
+	if conf.SearchBatchWindow > 0 {
+		wr.batcher = newSearchBatcher(conf.SearchBatchWindow, func(ctx context.Context, req *pb.SearchHashesRequest) (*pb.SearchHashesResponse, error) {
+			ctx, cancel := context.WithTimeout(ctx, conf.RequestTimeout)
+			defer cancel()
+			resp, err := wr.hashLookup(ctx, req)
+			if err == nil {
+				wr.c.Update(req, resp)
+			}
+			return resp, err
+		})
 	}
 
 	// TODO: Verify that config.ThreatLists is a subset of the list obtained
@@ -326,21 +926,41 @@ func NewUpdateClient(conf Config) (*UpdateClient, error) {
 		w = ioutil.Discard
 	}
 	wr.log = log.New(w, "webrisk: ", log.Ldate|log.Ltime|log.Lshortfile)
+	if len(unknownThreatTypes) > 0 {
+		wr.log.Printf("ignoring unknown threat types: %s", strings.Join(unknownThreatTypes, ","))
+	}
+
+	if err := wr.lockStore(); err != nil {
+		return nil, err
+	}
 
 	delay := time.Duration(0)
 	// If database file is provided, use that to initialize.
 	if !wr.db.Init(&wr.config, wr.log) {
+		if wr.config.ReadOnly {
+			wr.unlockStore()
+			return nil, fmt.Errorf("webrisk: unable to load read-only database: %v", wr.db.Status())
+		}
 		ctx, cancel := context.WithTimeout(context.Background(), wr.config.RequestTimeout)
 		delay, _ = wr.db.Update(ctx, wr.api)
 		cancel()
+	} else if wr.config.ReadOnly {
+		delay = wr.config.ReloadPeriod
 	} else {
 		if age := wr.db.SinceLastUpdate(); age < wr.config.UpdatePeriod {
 			delay = wr.config.UpdatePeriod - age
 		}
 	}
+	wr.limitCacheMemory()
+	wr.loadCache()
+	wr.loadStats()
 
 	// Start the background list updater.
 	wr.done = make(chan bool)
+	wr.stopped = make(chan struct{})
+	wr.updates = make(chan chan error)
+	wr.releases = make(chan chan struct{})
+	wr.resumes = make(chan chan error)
 	go wr.updater(delay)
 	return wr, nil
 }
@@ -350,16 +970,41 @@ func NewUpdateClient(conf Config) (*UpdateClient, error) {
 // internal state. Most errors are transient and will recover themselves
 // after some period.
 func (wr *UpdateClient) Status() (Stats, error) {
-	stats := Stats{
-		QueriesByDatabase: atomic.LoadInt64(&wr.stats.QueriesByDatabase),
-		QueriesByCache:    atomic.LoadInt64(&wr.stats.QueriesByCache),
-		QueriesByAPI:      atomic.LoadInt64(&wr.stats.QueriesByAPI),
-		QueriesFail:       atomic.LoadInt64(&wr.stats.QueriesFail),
-		DatabaseUpdateLag: wr.db.UpdateLag(),
-	}
+	stats := wr.rawStats()
+	wr.statsMu.Lock()
+	wr.statsBase.addTo(&stats)
+	wr.statsMu.Unlock()
 	return stats, wr.db.Status()
 }
 
+// rawStats returns the statistics of the client since it was created,
+// without the counters restored from Config.StatsPath or reset.
+func (wr *UpdateClient) rawStats() Stats {
+	cs := wr.c.Stats()
+	return Stats{
+		QueriesByDatabase:  atomic.LoadInt64(&wr.stats.QueriesByDatabase),
+		QueriesByCache:     atomic.LoadInt64(&wr.stats.QueriesByCache),
+		QueriesByAPI:       atomic.LoadInt64(&wr.stats.QueriesByAPI),
+		QueriesFail:        atomic.LoadInt64(&wr.stats.QueriesFail),
+		QueriesUnconfirmed: atomic.LoadInt64(&wr.stats.QueriesUnconfirmed),
+		DatabaseUpdateLag:  wr.db.UpdateLag(),
+		DatabaseAge:        wr.db.Age(),
+		DatabaseMemory:     wr.db.MemoryUsage(),
+		CacheMemory:        wr.c.MemoryUsage(),
+		CacheEvictions:     wr.c.Evictions(),
+		CacheRefreshes:     atomic.LoadInt64(&wr.stats.CacheRefreshes),
+
+		CachePositiveHits:    cs.PositiveHits,
+		CacheNegativeHits:    cs.NegativeHits,
+		CacheMisses:          cs.Misses,
+		CacheExpired:         cs.Expired,
+		CachePositiveEntries: cs.PositiveEntries,
+		CacheNegativeEntries: cs.NegativeEntries,
+
+		Lists: wr.db.ListStats(),
+	}
+}
+
 // WaitUntilReady blocks until the database is not in an error state.
 // Returns nil when the database is ready. Returns an error if the provided
 // context is canceled or if the UpdateClient instance is Closed.
@@ -402,52 +1047,256 @@ func (wr *UpdateClient) LookupURLs(urls []string) (threats [][]URLThreat, err er
 //
 // See LookupURLs for details on the returned results.
 func (wr *UpdateClient) LookupURLsContext(ctx context.Context, urls []string) (threats [][]URLThreat, err error) {
+	return wr.lookupURLs(ctx, urls, nil)
+}
+
+// LookupSource is the component of UpdateClient that gave the verdict for a
+// URL.
+type LookupSource int
+
+const (
+	// SourceDatabase means that the local database alone gave the verdict,
+	// either because none of the expressions of the URL are listed, or
+	// because the client is read-only.
+	SourceDatabase LookupSource = iota
+
+	// SourceCache means that the cache confirmed or dismissed some matches
+	// of the database, without an API call.
+	SourceCache
+
+	// SourceAPI means that the API was asked about some matches of the
+	// database.
+	SourceAPI
+
+	// SourceUnconfirmed means that the API did not answer about some matches
+	// of the database within Config.LookupDeadline, so they are reported as
+	// threats without confirmation.
+	SourceUnconfirmed
+
+	// SourceUnverified means that the lookup failed before the verdict was
+	// known, such as when the API could not be asked about some matches of
+	// the database. The threats found, if any, are reported, but a URL
+	// without threats is not known to be safe, unlike one that matched no
+	// list, whose source is SourceDatabase even if the lookup of other URLs
+	// failed.
+	SourceUnverified
+)
+
+var lookupSourceNames = map[LookupSource]string{
+	SourceDatabase:    "database",
+	SourceCache:       "cache",
+	SourceAPI:         "api",
+	SourceUnconfirmed: "unconfirmed",
+	SourceUnverified:  "unverified",
+}
+
+func (s LookupSource) String() string {
+	if name, ok := lookupSourceNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("LookupSource(%d)", int(s))
+}
+
+// LookupURLsSources is like LookupURLsContext, but also reports the source
+// of the verdict for each URL, in the same order as urls.
+//
+// If an error occurs, the URLs whose verdict is not known, such as those
+// that matched the database when the API could not be reached, have
+// SourceUnverified, while the others, such as those that matched no threat
+// list, keep the source of their verdict, so that callers can apply
+// different policies to the two.
+func (wr *UpdateClient) LookupURLsSources(ctx context.Context, urls []string) (threats [][]URLThreat, sources []LookupSource, err error) {
+	sources = make([]LookupSource, len(urls))
+	threats, err = wr.lookupURLs(ctx, urls, sources)
+	return threats, sources, err
+}
+
+// lookupURLs looks up urls and, if sources is not nil, records the source of
+// each verdict in it.
+func (wr *UpdateClient) lookupURLs(ctx context.Context, urls []string, sources []LookupSource) (threats [][]URLThreat, err error) {
+	return wr.lookup(ctx, urls, func(i int) (map[hashPrefix]string, []URLThreat, error) {
+		urlhashes, err := wr.urls.generateHashes(urls[i])
+		if err != nil {
+			wr.log.Printf("%serror generating urlhashes: %v", logPrefix(ctx), err)
+			return nil, nil, err
+		}
+		var threats []URLThreat
+		if wr.config.IPHostThreat != ThreatTypeUnspecified && urlhashes != nil {
+			if host, err := wr.urls.canonicalHost(urls[i]); err == nil && isIPHost(host) {
+				threats = append(threats, URLThreat{
+					Pattern:    host + "/",
+					ThreatType: wr.config.IPHostThreat,
+				})
+			}
+		}
+		return urlhashes, threats, nil
+	}, sources)
+}
+
+// LookupHashes looks up hashes of URL expressions rather than URLs, such as
+// hashes extracted from the logs of other security tools. Each hash is either
+// a full SHA256 hash of 32 bytes, or a prefix of one of at least 4 bytes,
+// which is only matched by the database if it is at least as long as the
+// prefixes listed there. It returns the threats and the source of the verdict
+// for each hash, in the same order as hashes. The Pattern of each threat is
+// the hex encoding of the full hash that matched, and a hash prefix may
+// match several of them.
+//
+// As for URLs, the database and the cache are checked first, and the API is
+// only asked about the remaining matches, unless the client is read-only: a
+// match of the database is then reported as is, with the hex encoding of the
+// hash looked up as its Pattern.
+func (wr *UpdateClient) LookupHashes(ctx context.Context, hashes [][]byte) (threats [][]URLThreat, sources []LookupSource, err error) {
+	names := make([]string, len(hashes))
+	for i, h := range hashes {
+		names[i] = hex.EncodeToString(h)
+	}
+	sources = make([]LookupSource, len(hashes))
+	threats, err = wr.lookup(ctx, names, func(i int) (map[hashPrefix]string, []URLThreat, error) {
+		h := hashPrefix(hashes[i])
+		if len(h) < minHashPrefixLength || len(h) > maxHashPrefixLength {
+			return nil, nil, fmt.Errorf("webrisk: invalid hash length %d: %s", len(h), names[i])
+		}
+		return map[hashPrefix]string{h: names[i]}, nil, nil
+	}, sources)
+	return threats, sources, err
+}
+
+// lookup looks up the hashes that expressions returns for each of the named
+// inputs, along with the threats already known for it, and, if sources is
+// not nil, records the source of each verdict in it. The hashes are full
+// hashes, mapped to the URL patterns they are the hash of, or hash prefixes
+// looked up on their own.
+func (wr *UpdateClient) lookup(ctx context.Context, names []string, expressions func(i int) (map[hashPrefix]string, []URLThreat, error), sources []LookupSource) (threats [][]URLThreat, err error) {
+	// setSource records that the verdict for names[i] needed s.
+	setSource := func(i int, s LookupSource) {
+		if sources != nil && sources[i] < s {
+			sources[i] = s
+		}
+	}
+	// unverified records that none of the verdicts is known, because the
+	// lookup failed before it could tell.
+	unverified := func() {
+		for i := range names {
+			setSource(i, SourceUnverified)
+		}
+	}
+
+	start := wr.config.now()
 	ctx, cancel := context.WithTimeout(ctx, wr.config.RequestTimeout)
 	defer cancel()
 
-	threats = make([][]URLThreat, len(urls))
+	threats = make([][]URLThreat, len(names))
+	if len(wr.config.ThreatTypeAliases) > 0 {
+		defer func() {
+			for i := range threats {
+				threats[i] = aliasThreats(threats[i], wr.config.ThreatTypeAliases)
+			}
+		}()
+	}
 
 	if atomic.LoadUint32(&wr.closed) != 0 {
+		unverified()
 		return threats, errClosed
 	}
-	if err := wr.db.Status(); err != nil {
-		wr.log.Printf("inconsistent database: %v", err)
-		atomic.AddInt64(&wr.stats.QueriesFail, int64(len(urls)))
+	if err := wr.db.Status(); err != nil && !(errors.Is(err, ErrStale) && wr.config.StalePolicy == StaleServe) {
+		wr.log.Printf("%sinconsistent database: %v", logPrefix(ctx), err)
+		atomic.AddInt64(&wr.stats.QueriesFail, int64(len(names)))
+		unverified()
 		return threats, err
 	}
 
 	hashes := make(map[hashPrefix]string)
 	hash2idxs := make(map[hashPrefix][]int)
+	// partials holds the partial hash that the API is asked about for each
+	// full hash, which only the response to that request applies to.
+	partials := make(map[hashPrefix]hashPrefix)
+	// prefix2idxs holds the hash prefixes that are looked up on their own,
+	// which match any full hash they are a prefix of.
+	prefix2idxs := make(map[hashPrefix][]int)
 
 	// Construct the follow-up request being made to the server.
 	// In the request, we only ask for partial hashes for privacy reasons.
 	var reqs []*pb.SearchHashesRequest
+	// reqProbes holds the probe that each request is made for, whose
+	// database match is reported unconfirmed if the request is not answered
+	// by the LookupDeadline.
+	var reqProbes []probe
 	ttm := make(map[pb.ThreatType]bool)
+	trace := lookupTraceFrom(ctx)
 
-	for i, url := range urls {
-		urlhashes, err := generateHashes(url)
+	for i, name := range names {
+		urlhashes, known, err := expressions(i)
 		if err != nil {
-			wr.log.Printf("error generating urlhashes: %v", err)
-			atomic.AddInt64(&wr.stats.QueriesFail, int64(len(urls)-i))
+			atomic.AddInt64(&wr.stats.QueriesFail, int64(len(names)-i))
+			unverified()
 			return threats, err
 		}
+		threats[i] = append(threats[i], known...)
 
-		for fullHash, pattern := range urlhashes {
-			hash2idxs[fullHash] = append(hash2idxs[fullHash], i)
+		// Once the hashes are known to be a threat of every type, the API
+		// is not asked about the others.
+		probes, skipAPI := wr.probeAll(urlhashes)
+		for _, p := range probes {
+			fullHash, pattern, unsureThreats := p.hash, p.pattern, p.unsure
 			_, alreadyRequested := hashes[fullHash]
 			hashes[fullHash] = pattern
+			trace.Hashes++
 
-			// Lookup in database according to threat list.
-			partialHash, unsureThreats := wr.db.Lookup(fullHash)
+			if !fullHash.IsFull() {
+				// A hash prefix is not in the cache, which only knows
+				// about full hashes, so only the database and the API
+				// can tell whether it matches a threat.
+				if len(unsureThreats) == 0 {
+					atomic.AddInt64(&wr.stats.QueriesByDatabase, 1)
+					continue
+				}
+				trace.DatabaseMatches++
+				if wr.config.ReadOnly {
+					for _, td := range unsureThreats {
+						threats[i] = append(threats[i], URLThreat{
+							Pattern:    pattern,
+							ThreatType: td,
+						})
+					}
+					atomic.AddInt64(&wr.stats.QueriesByDatabase, 1)
+					continue
+				}
+				if skipAPI {
+					continue
+				}
+				setSource(i, SourceAPI)
+				prefix2idxs[fullHash] = append(prefix2idxs[fullHash], i)
+				if alreadyRequested {
+					continue
+				}
+				reqs = append(reqs, newSearchHashesRequest(fullHash, unsureThreats))
+				reqProbes = append(reqProbes, p)
+				if wr.config.ShouldLogQueriesByAPI {
+					wr.log.Printf("%squerying api for %v", logPrefix(ctx), name)
+				}
+				continue
+			}
+			hash2idxs[fullHash] = append(hash2idxs[fullHash], i)
+
+			// Result of the database according to threat list.
+			partialHash := p.partial
 			if len(unsureThreats) == 0 {
 				atomic.AddInt64(&wr.stats.QueriesByDatabase, 1)
 				continue // There are definitely no threats for this full hash
 			}
-
-			// Lookup in cache according to recently seen values.
-			cachedThreats, cr := wr.c.Lookup(fullHash)
+			trace.DatabaseMatches++
+
+			// Result of the cache according to recently seen values.
+			cachedThreats, cr := p.cached, p.cr
+			if cr == positiveCacheHit || cr == negativeCacheHit {
+				trace.CacheHits++
+			} else {
+				trace.CacheMisses++
+			}
 			switch cr {
 			case positiveCacheHit:
+				setSource(i, SourceCache)
 				// The cache remembers this full hash as a threat.
 				// The threats we return to the client is the set intersection
 				// of unsureThreats and cachedThreats.
@@ -462,11 +1311,28 @@ func (wr *UpdateClient) LookupURLsContext(ctx context.Context, urls []string) (t
 				atomic.AddInt64(&wr.stats.QueriesByCache, 1)
 			case negativeCacheHit:
 				// This is cached as a non-threat.
+				setSource(i, SourceCache)
 				atomic.AddInt64(&wr.stats.QueriesByCache, 1)
 				continue
 			default:
+				// In read-only mode, the API cannot be asked to confirm the
+				// match, so the partial hash match is reported as is.
+				if wr.config.ReadOnly {
+					for _, td := range unsureThreats {
+						threats[i] = append(threats[i], URLThreat{
+							Pattern:    pattern,
+							ThreatType: td,
+						})
+					}
+					atomic.AddInt64(&wr.stats.QueriesByDatabase, 1)
+					continue
+				}
+				if skipAPI {
+					continue
+				}
 				// The cache knows nothing about this full hash, so we must make
 				// a request for it.
+				setSource(i, SourceAPI)
 				if alreadyRequested {
 					continue
 				}
@@ -474,60 +1340,547 @@ func (wr *UpdateClient) LookupURLsContext(ctx context.Context, urls []string) (t
 					ttm[pb.ThreatType(td)] = true
 				}
 
-				tts := []pb.ThreatType{}
-				for _, tt := range unsureThreats {
-					tts = append(tts, pb.ThreatType(tt))
-				}
-
-				reqs = append(reqs, &pb.SearchHashesRequest{
-					HashPrefix:  []byte(partialHash),
-					ThreatTypes: tts,
-				})
+				partials[fullHash] = partialHash
+				reqs = append(reqs, newSearchHashesRequest(partialHash, unsureThreats))
+				reqProbes = append(reqProbes, p)
 
 				if wr.config.ShouldLogQueriesByAPI {
-					wr.log.Printf("querying api for %v", url)
+					wr.log.Printf("%squerying api for %v", logPrefix(ctx), name)
 				}
 			}
 		}
 	}
 
-	for _, req := range reqs {
+	// Batched searches are all added before waiting for any, so that they
+	// are made within the same window.
+	var batches []*searchBatch
+	if wr.batcher != nil {
+		for _, req := range reqs {
+			batches = append(batches, wr.batcher.add(ctx, req))
+		}
+	}
+	// With a LookupDeadline, the searches are made in the background, so
+	// that the lookup can give up on them.
+	var deadline *lookupDeadline
+	var searches []*backgroundSearch
+	if wr.config.LookupDeadline > 0 && len(reqs) > 0 {
+		deadline = newLookupDeadline(wr.config.Clock, wr.config.LookupDeadline-wr.config.now().Sub(start))
+		if batches == nil {
+			for _, req := range reqs {
+				searches = append(searches, wr.searchInBackground(ctx, req))
+			}
+		}
+	}
+	// reqIdxs returns the indexes of the names that the request for p is
+	// about.
+	reqIdxs := func(p probe) []int {
+		if !p.hash.IsFull() {
+			idxs := prefix2idxs[p.hash]
+			delete(prefix2idxs, p.hash)
+			return idxs
+		}
+		return hash2idxs[p.hash]
+	}
+	// A failed request only leaves the names that it is about unverified,
+	// and the others are still looked up.
+	var lookupErr error
+	for i, req := range reqs {
 		// Actually query the Web Risk API for exact full hash matches.
-		resp, err := wr.api.HashLookup(ctx, req.HashPrefix, req.ThreatTypes)
+		start := time.Now()
+		var resp *pb.SearchHashesResponse
+		switch {
+		case searches != nil:
+			resp, err = searches[i].wait(ctx, deadline)
+		case batches != nil:
+			if err = deadline.wait(ctx, batches[i].done); err == nil {
+				resp, err = batches[i].wait(ctx, req.ThreatTypes)
+			}
+		default:
+			resp, err = wr.searchHashes(ctx, req)
+		}
+		trace.APICalls++
+		trace.APITime += time.Since(start)
+		if errors.Is(err, errLookupDeadline) {
+			// The match of the database is reported as is, as in
+			// read-only mode.
+			p := reqProbes[i]
+			for _, idx := range reqIdxs(p) {
+				for _, td := range p.unsure {
+					threats[idx] = append(threats[idx], URLThreat{Pattern: p.pattern, ThreatType: td})
+				}
+				setSource(idx, SourceUnconfirmed)
+			}
+			trace.Unconfirmed++
+			atomic.AddInt64(&wr.stats.QueriesUnconfirmed, 1)
+			continue
+		}
 		if err != nil {
-			wr.log.Printf("HashLookup failure: %v", err)
+			wr.log.Printf("%sHashLookup failure: %v", logPrefix(ctx), err)
 			atomic.AddInt64(&wr.stats.QueriesFail, 1)
-			return threats, err
+			for _, idx := range reqIdxs(reqProbes[i]) {
+				setSource(idx, SourceUnverified)
+			}
+			if lookupErr == nil {
+				lookupErr = err
+			}
+			continue
 		}
 
-		// Update the cache.
-		wr.c.Update(req, resp)
-
 		// Pull the information the client cares about out of the response.
+		// A hash prefix looked up on its own matches any full hash of the
+		// response to its request, which is only applied once.
+		prefixIdxs := prefix2idxs[hashPrefix(req.HashPrefix)]
+		delete(prefix2idxs, hashPrefix(req.HashPrefix))
 		for _, threat := range resp.GetThreats() {
 			fullHash := hashPrefix(threat.Hash)
 			if !fullHash.IsFull() {
 				continue
 			}
+			if bytes.HasPrefix(threat.Hash, req.HashPrefix) {
+				for _, idx := range prefixIdxs {
+					threats[idx] = appendThreats(threats[idx], hex.EncodeToString(threat.Hash), threat.ThreatTypes, wr.lists)
+				}
+			}
 			pattern, ok := hashes[fullHash]
 			idxs, findidx := hash2idxs[fullHash]
-			if findidx && ok {
-				for _, td := range threat.ThreatTypes {
-					if !wr.lists[ThreatType(td)] {
-						continue
-					}
-					for _, idx := range idxs {
-						threats[idx] = append(threats[idx], URLThreat{
-							Pattern:    pattern,
-							ThreatType: ThreatType(td),
-						})
-					}
-				}
+			if !findidx || !ok || partials[fullHash] != hashPrefix(req.HashPrefix) {
+				continue
+			}
+			for _, idx := range idxs {
+				threats[idx] = appendThreats(threats[idx], pattern, threat.ThreatTypes, wr.lists)
 			}
 		}
 		atomic.AddInt64(&wr.stats.QueriesByAPI, 1)
 	}
-	return threats, nil
+	return threats, lookupErr
+}
+
+// appendThreats appends to threats one URLThreat with the pattern for each of
+// the threat types tds that is in lists.
+func appendThreats(threats []URLThreat, pattern string, tds []pb.ThreatType, lists map[ThreatType]bool) []URLThreat {
+	for _, td := range tds {
+		if lists[ThreatType(td)] {
+			threats = append(threats, URLThreat{Pattern: pattern, ThreatType: ThreatType(td)})
+		}
+	}
+	return threats
+}
+
+// aliasThreats replaces the threat types of threats by their aliases, and
+// drops the threats that this makes duplicates.
+func aliasThreats(threats []URLThreat, aliases map[ThreatType]ThreatType) []URLThreat {
+	out := threats[:0]
+	for _, t := range threats {
+		if alias, ok := aliases[t.ThreatType]; ok {
+			t.ThreatType = alias
+		}
+		dup := false
+		for _, o := range out {
+			if o == t {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func newSearchHashesRequest(partialHash hashPrefix, threatTypes []ThreatType) *pb.SearchHashesRequest {
+	tts := []pb.ThreatType{}
+	for _, tt := range threatTypes {
+		tts = append(tts, pb.ThreatType(tt))
+	}
+	return &pb.SearchHashesRequest{
+		HashPrefix:  []byte(partialHash),
+		ThreatTypes: tts,
+	}
+}
+
+// searchHashes asks the API for the full hashes matching req and updates the
+// cache with the response. Concurrent searches for the same hash prefix share
+// a single API call.
+func (wr *UpdateClient) searchHashes(ctx context.Context, req *pb.SearchHashesRequest) (*pb.SearchHashesResponse, error) {
+	return wr.flights.do(ctx, flightKey(req), func() (*pb.SearchHashesResponse, error) {
+		resp, err := wr.hashLookup(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		wr.c.Update(req, resp)
+		return resp, nil
+	})
+}
+
+// hashLookup makes the API call for req, unless the circuit breaker is open,
+// and notifies Config.Events of the breaker opening or of the quota being
+// exhausted.
+func (wr *UpdateClient) hashLookup(ctx context.Context, req *pb.SearchHashesRequest) (*pb.SearchHashesResponse, error) {
+	if !wr.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+	resp, err := wr.api.HashLookup(ctx, req.HashPrefix, req.ThreatTypes)
+	wr.recordQuota(err)
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		// The caller gave up, which says nothing about the API.
+		return resp, err
+	}
+	if wr.breaker.record(err) {
+		wr.notify(EventCircuitOpen, "API calls suspended for %v after %d consecutive failures: %v",
+			wr.config.CircuitBreakerCooldown, wr.config.CircuitBreakerThreshold, err)
+	}
+	return resp, err
+}
+
+// recordQuota notifies Config.Events when the API starts refusing calls
+// because the quota is exhausted, given the outcome err of a call.
+func (wr *UpdateClient) recordQuota(err error) {
+	if errors.Is(err, ErrQuotaExhausted) {
+		if atomic.CompareAndSwapUint32(&wr.quotaExhausted, 0, 1) {
+			wr.notify(EventQuotaExhausted, "the Web Risk API refuses calls: %v", err)
+		}
+	} else if err == nil {
+		atomic.StoreUint32(&wr.quotaExhausted, 0)
+	}
+}
+
+// refreshIfExpiring starts refreshing a cached result in the background if
+// it expires within the CacheRefreshWindow. At most one refresh of a hash
+// prefix runs at a time, and lookups that miss the cache in the meantime
+// share its API call.
+func (wr *UpdateClient) refreshIfExpiring(partialHash hashPrefix, threatTypes []ThreatType, expire time.Time) {
+	if wr.config.CacheRefreshWindow <= 0 || wr.config.ReadOnly {
+		return
+	}
+	if expire.Sub(wr.config.now()) > wr.config.CacheRefreshWindow {
+		return
+	}
+	if atomic.LoadUint32(&wr.closed) != 0 {
+		return
+	}
+	req := newSearchHashesRequest(partialHash, threatTypes)
+	key := flightKey(req)
+	c, ok := wr.flights.join(key)
+	if !ok {
+		return // Already being refreshed
+	}
+	atomic.AddInt64(&wr.stats.CacheRefreshes, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), wr.config.RequestTimeout)
+		defer cancel()
+		resp, err := wr.hashLookup(ctx, req)
+		if err != nil {
+			wr.log.Printf("cache refresh failure: %v", err)
+		} else {
+			wr.c.Update(req, resp)
+		}
+		wr.flights.finish(key, c, resp, err)
+	}()
+}
+
+// warmCacheBatchSize is the number of URLs that WarmCache looks up at once.
+const warmCacheBatchSize = 100
+
+// WarmCache looks up urls ahead of time, so that the results of the hash
+// searches they need are cached when they are looked up for real. This is
+// meant to be used with a list of frequently visited URLs right after
+// startup, to avoid a burst of API calls and slow lookups. URLs that cannot
+// be parsed are skipped. It returns the number of URLs looked up.
+func (wr *UpdateClient) WarmCache(ctx context.Context, urls []string) (int, error) {
+	if atomic.LoadUint32(&wr.closed) != 0 {
+		return 0, errClosed
+	}
+	if wr.config.ReadOnly {
+		return 0, errors.New("webrisk: cannot warm the cache of a read-only client")
+	}
+	if wr.config.DisableCache {
+		return 0, errors.New("webrisk: the cache is disabled")
+	}
+	var batch []string
+	n := 0
+	for i, url := range urls {
+		if _, err := wr.urls.generateHashes(url); err == nil {
+			batch = append(batch, url)
+		}
+		if len(batch) < warmCacheBatchSize && i < len(urls)-1 {
+			continue
+		}
+		if len(batch) > 0 {
+			if _, err := wr.LookupURLsContext(ctx, batch); err != nil {
+				return n, err
+			}
+		}
+		n += len(batch)
+		batch = batch[:0]
+	}
+	return n, nil
+}
+
+// ClearCache removes all entries from the cache, so that the results of hash
+// searches are requested from the API again. This is useful after the
+// minimum TTLs changed, or to stop reporting a URL that was just removed from
+// a threat list. A shared cache, such as RedisCache, is only cleared of the
+// entries stored under the key prefix of this client. An error is returned if
+// the cache does not support clearing, as is the case for MemcachedCache.
+func (wr *UpdateClient) ClearCache() error {
+	if atomic.LoadUint32(&wr.closed) != 0 {
+		return errClosed
+	}
+	return wr.c.Clear()
+}
+
+// CachedHash describes what is known locally about one of the full hashes
+// that a URL is looked up by.
+type CachedHash struct {
+	Pattern string // URL expression that was hashed, such as "evil.com/"
+	Hash    []byte // Full hash of Pattern
+
+	// DatabaseThreats are the threat types whose lists hold a prefix of
+	// Hash. If there are none, the URL is known to be safe without
+	// consulting the cache.
+	DatabaseThreats []ThreatType
+
+	// Threats are the threat types cached for Hash, and when each of them
+	// expires. Expired entries that have not been purged yet are included.
+	Threats map[ThreatType]time.Time
+
+	// NegativePrefix is a prefix of Hash that is cached as having no
+	// threats until NegativeExpire. It is empty if there is none.
+	NegativePrefix []byte
+	NegativeExpire time.Time
+
+	// TTLPolicy is the policy that the expiration times above were
+	// computed with from those given by the API.
+	TTLPolicy TTLPolicy
+}
+
+// InspectCache reports what the database and cache hold for each of the full
+// hashes that url is looked up by, in order to explain the verdict that
+// LookupURLs would give for it. It does not contact the API, nor change the
+// contents or statistics of the cache.
+func (wr *UpdateClient) InspectCache(url string) ([]CachedHash, error) {
+	if atomic.LoadUint32(&wr.closed) != 0 {
+		return nil, errClosed
+	}
+	hashes, err := wr.urls.generateHashes(url)
+	if err != nil {
+		return nil, err
+	}
+	var chs []CachedHash
+	for fullHash, pattern := range hashes {
+		ch := CachedHash{Pattern: pattern, Hash: []byte(fullHash), TTLPolicy: wr.config.TTLPolicy}
+		if wr.db.Status() == nil {
+			_, ch.DatabaseThreats = wr.db.Lookup(fullHash)
+		}
+		var negPrefix hashPrefix
+		ch.Threats, negPrefix, ch.NegativeExpire = wr.c.inspect(fullHash)
+		if negPrefix != "" {
+			ch.NegativePrefix = []byte(negPrefix)
+		}
+		chs = append(chs, ch)
+	}
+	sort.Slice(chs, func(i, j int) bool { return chs[i].Pattern < chs[j].Pattern })
+	return chs, nil
+}
+
+// CanonicalizeAll canonicalizes urls in parallel according to the options of
+// Config, such as IDNPolicy and StrictURLs, the way LookupURLs does before
+// looking them up. This allows URLs to be deduplicated before they are
+// looked up. See the CanonicalizeAll function for details on the results.
+func (wr *UpdateClient) CanonicalizeAll(urls []string) (canonical []string, errs []error) {
+	return wr.urls.canonicalizeAll(urls)
+}
+
+// Expression is one of the host-suffix/path-prefix expressions that a URL is
+// looked up by, as reported by ExplainURL.
+type Expression struct {
+	Pattern    string // URL expression that was hashed, such as "b.c/1/"
+	Hash       []byte // Full hash of Pattern
+	HashPrefix []byte // Shortest prefix of Hash that threat lists may hold
+
+	// DatabaseThreats are the threat types whose lists hold a prefix of
+	// Hash, and DatabasePrefix is that prefix. If there are none, the
+	// expression is known to be safe without consulting the cache or API.
+	DatabasePrefix  []byte
+	DatabaseThreats []ThreatType
+
+	// Threats are the threat types that LookupURLs reports for the URL
+	// because of this expression. The expression matched if there are any.
+	Threats []ThreatType
+}
+
+// URLExplanation explains the verdict that LookupURLs gives for a URL.
+type URLExplanation struct {
+	URL          string       // URL as given to ExplainURL
+	CanonicalURL string       // URL after canonicalization
+	Expressions  []Expression // In the order they are generated
+
+	// Scheme is the action taken for the scheme of URL. Passed through
+	// URLs have no expressions.
+	Scheme SchemeAction
+}
+
+// ExplainURL looks up url and reports every expression that it generates,
+// along with their hashes and which of them matched, so that the verdict
+// can be traced back to the entries of the threat lists. Like LookupURLs, it
+// may contact the API.
+func (wr *UpdateClient) ExplainURL(ctx context.Context, url string) (*URLExplanation, error) {
+	if atomic.LoadUint32(&wr.closed) != 0 {
+		return nil, errClosed
+	}
+	ex := &URLExplanation{URL: url, Scheme: wr.urls.schemeAction(url)}
+	switch ex.Scheme {
+	case SchemeReject:
+		return nil, ErrSchemeRejected
+	case SchemePass:
+		return ex, nil
+	}
+	var err error
+	if ex.CanonicalURL, err = wr.urls.canonicalURL(url); err != nil {
+		return nil, err
+	}
+	patterns, err := wr.urls.generatePatterns(url)
+	if err != nil {
+		return nil, err
+	}
+	threats, err := wr.LookupURLsContext(ctx, []string{url})
+	if err != nil {
+		return nil, err
+	}
+	matches := make(map[string][]ThreatType)
+	for _, t := range threats[0] {
+		matches[t.Pattern] = append(matches[t.Pattern], t.ThreatType)
+	}
+	for _, pattern := range patterns {
+		fullHash := hashFromPattern(pattern)
+		e := Expression{
+			Pattern:    pattern,
+			Hash:       []byte(fullHash),
+			HashPrefix: []byte(fullHash[:minHashPrefixLength]),
+			Threats:    matches[pattern],
+		}
+		var partialHash hashPrefix
+		if partialHash, e.DatabaseThreats = wr.db.Lookup(fullHash); len(e.DatabaseThreats) > 0 {
+			e.DatabasePrefix = []byte(partialHash)
+		}
+		ex.Expressions = append(ex.Expressions, e)
+	}
+	return ex, nil
+}
+
+// VerifyDatabase immediately checks every threat list in the local database
+// against the version that the Web Risk API currently expects, instead of
+// waiting for the next scheduled update to notice a list that is out of sync.
+// The local database is not modified. See ListVerification for details on
+// the results.
+func (wr *UpdateClient) VerifyDatabase(ctx context.Context) ([]ListVerification, error) {
+	if atomic.LoadUint32(&wr.closed) != 0 {
+		return nil, errClosed
+	}
+	if wr.config.ReadOnly {
+		return nil, errors.New("webrisk: cannot verify a read-only database")
+	}
+	ctx, cancel := context.WithTimeout(ctx, wr.config.RequestTimeout)
+	defer cancel()
+	return wr.db.Verify(ctx, wr.api)
+}
+
+// UpdateDatabase updates the local database from the Web Risk API right
+// away, instead of waiting for the next scheduled update, and schedules the
+// next update from now. A read-only client reloads the database from its
+// store instead. It returns the error state of the database afterwards.
+func (wr *UpdateClient) UpdateDatabase(ctx context.Context) error {
+	if atomic.LoadUint32(&wr.closed) != 0 {
+		return errClosed
+	}
+	result := make(chan error, 1)
+	select {
+	case wr.updates <- result:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-wr.done:
+		return errClosed
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release hands the database over to another process, such as a new
+// version of a server during a restart without downtime: it saves the cache
+// to Config.CachePath, stops updating the database, and unlocks its store,
+// so that the other process can load both and take over the updates.
+// Lookups are still served from the database in memory, the cache and the
+// API until wr is closed, which it still must be. UpdateDatabase fails
+// afterwards, until the database is taken back with Resume.
+func (wr *UpdateClient) Release(ctx context.Context) error {
+	if atomic.LoadUint32(&wr.closed) != 0 {
+		return errClosed
+	}
+	released := make(chan struct{})
+	select {
+	case wr.releases <- released:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-wr.done:
+		return errClosed
+	}
+	select {
+	case <-released:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Resume takes the database back after Release, such as when the process
+// that it was released to failed to start: it locks the store again, loads
+// the database saved there in the meantime if it is newer, and updates it
+// again. It fails with ErrStoreLocked if another process holds the lock.
+// Resume does nothing if the database was not released.
+func (wr *UpdateClient) Resume(ctx context.Context) error {
+	if atomic.LoadUint32(&wr.closed) != 0 {
+		return errClosed
+	}
+	result := make(chan error, 1)
+	select {
+	case wr.resumes <- result:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-wr.done:
+		return errClosed
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ExportDatabase writes the current contents of the local database to w, in
+// the same format as the file at Config.DBPath. The result can be read with
+// ReadDatabase, or used as the database of another client.
+func (wr *UpdateClient) ExportDatabase(w io.Writer) error {
+	if atomic.LoadUint32(&wr.closed) != 0 {
+		return errClosed
+	}
+	return wr.db.Export(w)
+}
+
+// SnapshotDatabase returns a copy of the current contents of the local
+// database, such as to serve the threat lists to other clients. The same
+// snapshot is returned until the database is updated, so it must not be
+// modified.
+func (wr *UpdateClient) SnapshotDatabase() (*DatabaseSnapshot, error) {
+	if atomic.LoadUint32(&wr.closed) != 0 {
+		return nil, errClosed
+	}
+	return wr.db.Snapshot()
 }
 
 // TODO: Add other types of lookup when available.
@@ -538,17 +1891,88 @@ func (wr *UpdateClient) LookupURLsContext(ctx context.Context, urls []string) (t
 // This should be run as a separate goroutine and will be automatically stopped
 // when wr.Close is called.
 func (wr *UpdateClient) updater(delay time.Duration) {
+	defer close(wr.stopped)
+	clock := wr.config.Clock
+	var saveCache <-chan time.Time
+	if wr.cacheStore != nil {
+		saveCache = clock.After(wr.config.CacheSavePeriod)
+	}
+	var saveStats <-chan time.Time
+	if wr.statsStore != nil {
+		saveStats = clock.After(wr.config.StatsSavePeriod)
+	}
+	var recordMetrics <-chan time.Time
+	if wr.config.MetricsRecorder != nil {
+		recordMetrics = clock.After(wr.config.MetricsPeriod)
+	}
+	var checkStale <-chan time.Time
+	if wr.config.Events != nil {
+		checkStale = clock.After(staleCheckPeriod)
+	}
+	// The update is only rescheduled after one is made, so that the other
+	// periodic work does not keep postponing it.
+	schedule := func(delay time.Duration) <-chan time.Time {
+		// Read-only clients poll for changes frequently, so only log
+		// actual updates.
+		if !wr.config.ReadOnly && atomic.LoadUint32(&wr.released) == 0 {
+			wr.log.Printf("Next update in %v", delay)
+		}
+		// The Clock is safe for concurrent use, unlike the now hook of
+		// tests.
+		wr.nextUpdate.Store(clock.Now().Add(delay).UnixNano())
+		return clock.After(delay)
+	}
+	next := schedule(delay)
 	for {
-		wr.log.Printf("Next update in %v", delay)
 		select {
-		case <-time.After(delay):
-			var ok bool
-			ctx, cancel := context.WithTimeout(context.Background(), wr.config.RequestTimeout)
-			if delay, ok = wr.db.Update(ctx, wr.api); ok {
-				wr.log.Printf("background threat list updated")
-				wr.c.Purge()
+		case <-next:
+			delay, _ = wr.update()
+			next = schedule(delay)
+
+		case result := <-wr.updates:
+			var err error
+			delay, err = wr.update()
+			if err == nil {
+				err = wr.db.Status()
+			}
+			next = schedule(delay)
+			result <- err
+
+		case <-saveCache:
+			wr.saveCache()
+			saveCache = clock.After(wr.config.CacheSavePeriod)
+
+		case <-saveStats:
+			wr.saveStats()
+			saveStats = clock.After(wr.config.StatsSavePeriod)
+
+		case <-recordMetrics:
+			wr.recordMetrics()
+			recordMetrics = clock.After(wr.config.MetricsPeriod)
+
+		case <-checkStale:
+			wr.checkStale()
+			checkStale = clock.After(staleCheckPeriod)
+
+		case released := <-wr.releases:
+			if atomic.LoadUint32(&wr.released) == 0 {
+				wr.saveCache()
+				atomic.StoreUint32(&wr.released, 1)
+				wr.unlockStore()
+				wr.log.Printf("database released to another process")
 			}
-			cancel()
+			close(released)
+
+		case result := <-wr.resumes:
+			var err error
+			if atomic.LoadUint32(&wr.released) != 0 {
+				if err = wr.relockStore(); err == nil {
+					atomic.StoreUint32(&wr.released, 0)
+					wr.log.Printf("database taken back")
+					next = schedule(0)
+				}
+			}
+			result <- err
 
 		case <-wr.done:
 			return
@@ -556,12 +1980,190 @@ func (wr *UpdateClient) updater(delay time.Duration) {
 	}
 }
 
+// update updates the database, or reloads it if the client is read-only.
+// It returns the delay until the next update, and the error that prevented
+// the database from being updated, if any.
+func (wr *UpdateClient) update() (time.Duration, error) {
+	if atomic.LoadUint32(&wr.released) != 0 {
+		return wr.config.UpdatePeriod, errReleased
+	}
+	if wr.config.ReadOnly {
+		ok, err := wr.db.Reload()
+		if err != nil {
+			wr.log.Printf("reload failure: %v", err)
+		} else if ok {
+			wr.log.Printf("database reloaded")
+			wr.limitCacheMemory()
+		}
+		wr.recordUpdate(err, wr.config.ReloadPeriod)
+		return wr.config.ReloadPeriod, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), wr.config.RequestTimeout)
+	defer cancel()
+	delay, ok := wr.db.Update(ctx, wr.api)
+	var err error
+	if ok {
+		wr.log.Printf("background threat list updated")
+		wr.c.Purge()
+		wr.limitCacheMemory()
+	} else if err = wr.db.UpdateError(); err == nil {
+		err = wr.db.Status()
+	}
+	wr.recordQuota(err)
+	wr.recordUpdate(err, delay)
+	return delay, err
+}
+
+// recordUpdate notifies Config.Events of the outcome err of an update, after
+// which the next one is due in delay. A failure is notified every time,
+// since updates are retried with a backoff, and a success only after a
+// failure.
+func (wr *UpdateClient) recordUpdate(err error, delay time.Duration) {
+	switch {
+	case err != nil:
+		wr.updateFailed = true
+		wr.notify(EventUpdateFailed, "database update failed, retrying in %v: %v", delay, err)
+	case wr.updateFailed:
+		wr.updateFailed = false
+		wr.notify(EventUpdateRecovered, "database updated after failures")
+	}
+	wr.checkStale()
+}
+
+// checkStale notifies Config.Events when the database goes stale.
+func (wr *UpdateClient) checkStale() {
+	stale := errors.Is(wr.db.Status(), ErrStale)
+	if stale && !wr.stale {
+		wr.notify(EventStale, "threat lists not updated for over %v", wr.db.maxAge())
+	}
+	wr.stale = stale
+}
+
+// loadCache restores the cache saved to Config.CachePath, if any.
+func (wr *UpdateClient) loadCache() {
+	if wr.config.CachePath == "" || wr.config.Cache != nil || wr.config.DisableCache {
+		return
+	}
+	wr.cacheStore = NewFileStore(wr.config.CachePath, wr.config.WritePolicy)
+	ctx, cancel := context.WithTimeout(context.Background(), wr.config.RequestTimeout)
+	defer cancel()
+	n, err := wr.c.Load(ctx, wr.cacheStore)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		wr.log.Printf("cache load failure: %v", err)
+	default:
+		wr.log.Printf("restored %d cache entries", n)
+	}
+}
+
+// saveCache saves the cache to Config.CachePath, if any.
+func (wr *UpdateClient) saveCache() {
+	if wr.cacheStore == nil || wr.config.ReadOnly || atomic.LoadUint32(&wr.released) != 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), wr.config.RequestTimeout)
+	defer cancel()
+	if err := wr.c.Save(ctx, wr.cacheStore); err != nil {
+		wr.log.Printf("cache save failure: %v", err)
+	}
+}
+
+// recordMetrics records the statistics with Config.MetricsRecorder, if any.
+func (wr *UpdateClient) recordMetrics() {
+	if wr.config.MetricsRecorder == nil {
+		return
+	}
+	stats, _ := wr.Status()
+	ctx, cancel := context.WithTimeout(context.Background(), wr.config.RequestTimeout)
+	defer cancel()
+	if err := wr.config.MetricsRecorder.RecordStats(ctx, stats); err != nil {
+		wr.log.Printf("metrics recording failure: %v", err)
+	}
+}
+
+// limitCacheMemory gives the cache whatever part of Config.MemoryLimit
+// is not used by the database, but no more than Config.CacheMaxBytes.
+func (wr *UpdateClient) limitCacheMemory() {
+	n := wr.config.CacheMaxBytes
+	if wr.config.MemoryLimit > 0 {
+		m := wr.config.MemoryLimit - wr.db.MemoryUsage()
+		if min := wr.config.MemoryLimit * (100 - databaseMemoryShare) / 100; m < min {
+			m = min
+		}
+		if n <= 0 || m < n {
+			n = m
+		}
+	}
+	wr.c.SetMemoryLimit(n)
+}
+
 // Close cleans up all resources.
 // This method must not be called concurrently with other lookup methods.
 func (wr *UpdateClient) Close() error {
 	if atomic.LoadUint32(&wr.closed) == 0 {
 		atomic.StoreUint32(&wr.closed, 1)
 		close(wr.done)
+		// The store stays locked until an update in progress is done, so
+		// that another process cannot start updating it at the same time.
+		<-wr.stopped
+		wr.saveCache()
+		wr.saveStats()
+		wr.recordMetrics()
+		wr.unlockStore()
 	}
 	return nil
 }
+
+// lockStore locks the database so that no other process updates it at the
+// same time. If it is already locked, the client either fails or continues
+// in read-only mode, as configured.
+func (wr *UpdateClient) lockStore() error {
+	ls, ok := wr.config.store().(lockingStore)
+	if !ok || wr.config.ReadOnly {
+		return nil
+	}
+	switch err := ls.Lock(); {
+	case err == nil:
+	case errors.Is(err, ErrStoreLocked) && wr.config.ReadOnlyIfLocked:
+		wr.log.Printf("database is locked by another process, continuing in read-only mode")
+		wr.config.ReadOnly = true
+	case errors.Is(err, ErrStoreLocked):
+		return err
+	default:
+		// Failing to lock is no worse than before locking was supported.
+		wr.log.Printf("unable to lock database: %v", err)
+	}
+	return nil
+}
+
+// relockStore locks the database again after it was released, and loads
+// the database that was saved in the meantime, if it is newer.
+func (wr *UpdateClient) relockStore() error {
+	store := wr.config.store()
+	if ls, ok := store.(lockingStore); ok && !wr.config.ReadOnly {
+		switch err := ls.Lock(); {
+		case errors.Is(err, ErrStoreLocked):
+			return err
+		case err != nil:
+			wr.log.Printf("unable to lock database: %v", err)
+		}
+	}
+	if store == nil {
+		return nil
+	}
+	if ok, err := wr.db.Reload(); err != nil {
+		wr.log.Printf("reload failure: %v", err)
+	} else if ok {
+		wr.log.Printf("database reloaded")
+		wr.limitCacheMemory()
+	}
+	return nil
+}
+
+// unlockStore releases the lock taken by lockStore, if any.
+func (wr *UpdateClient) unlockStore() {
+	if ls, ok := wr.config.store().(lockingStore); ok {
+		ls.Unlock()
+	}
+}
@@ -33,7 +33,7 @@ func TestNetworkAPIUpdate(t *testing.T) {
 		t.Skip()
 	}
 
-	nm, err := newNetAPI(DefaultServerURL, apiKey, "")
+	nm, err := newNetAPI(DefaultServerURL, apiKey, nil)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
@@ -43,7 +43,7 @@ func TestNetworkAPIUpdate(t *testing.T) {
 	}
 
 	dat, err := nm.ListUpdate(context.Background(), req.ThreatType,
-		req.VersionToken, []pb.CompressionType{})
+		req.VersionToken, &pb.ComputeThreatListDiffRequest_Constraints{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -74,7 +74,7 @@ func TestNetworkAPILookup(t *testing.T) {
 		t.Skip()
 	}
 
-	nm, err := newNetAPI(DefaultServerURL, apiKey, "")
+	nm, err := newNetAPI(DefaultServerURL, apiKey, nil)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
@@ -139,7 +139,7 @@ func TestWebriskClient(t *testing.T) {
 	}
 	cancel()
 
-	for _, hs := range sb.db.tfl {
+	for _, hs := range sb.db.table.Load().tfl {
 		if hs.Len() == 0 {
 			t.Errorf("Database length: got %d,, want >0", hs.Len())
 		}
@@ -14,9 +14,21 @@
 package webrisk
 
 import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	pb "github.com/google/webrisk/internal/webrisk_proto"
+	timepb "google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 func TestParseThreatTypes(t *testing.T) {
@@ -48,10 +60,22 @@ func TestParseThreatTypes(t *testing.T) {
 	}, {
 		args: "MALWARE,FAIL_TEST",
 		fail: true,
+	}, {
+		args:   "THREAT_TYPE_UNWANTED_SOFTWARE, malware",
+		output: []ThreatType{ThreatTypeUnwantedSoftware, ThreatTypeMalware},
+	}, {
+		args:   "malware,all",
+		output: []ThreatType{ThreatTypeMalware, ThreatTypeSocialEngineering, ThreatTypeUnwantedSoftware, ThreatTypeSocialEngineeringExtended},
+	}, {
+		args: "THREAT_TYPE_UNSPECIFIED",
+		fail: true,
+	}, {
+		args: "UNSPECIFIED",
+		fail: true,
 	}}
 
 	for i, v := range vectors {
-		threatTypes, err := parseThreatTypes(v.args)
+		threatTypes, err := ParseThreatTypes(v.args)
 		if err != nil != v.fail {
 			if err != nil {
 				t.Errorf("test %d, unexpected error: %v", i, err)
@@ -61,7 +85,1239 @@ func TestParseThreatTypes(t *testing.T) {
 			continue
 		}
 		if !cmp.Equal(threatTypes, v.output) {
-			t.Errorf("test %d, parseThreatTypes(%v), want %v", i, threatTypes, v.output)
+			t.Errorf("test %d, ParseThreatTypes(%v), want %v", i, threatTypes, v.output)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	now := time.Unix(1451436338, 951473000)
+	save := func(last time.Time, patterns ...string) {
+		var phs hashPrefixes
+		for _, p := range patterns {
+			phs = append(phs, hashFromPattern(p)[:minHashPrefixLength])
+		}
+		phs.Sort()
+		dbf := databaseFormat{
+			Table: threatsForUpdate{
+				ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+			},
+			Time: last,
+		}
+		if err := saveDatabase(path, dbf); err != nil {
+			t.Fatalf("unexpected save error: %v", err)
+		}
+	}
+	failAPI := &mockAPI{
+		listUpdate: func(context.Context, pb.ThreatType, []byte, *pb.ComputeThreatListDiffRequest_Constraints) (*pb.ComputeThreatListDiffResponse, error) {
+			t.Errorf("unexpected ListUpdate call in read-only mode")
+			return nil, errors.New("unexpected call")
+		},
+		hashLookup: func(context.Context, []byte, []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+			t.Errorf("unexpected HashLookup call in read-only mode")
+			return nil, errors.New("unexpected call")
+		},
+	}
+
+	if _, err := NewUpdateClient(Config{ReadOnly: true, api: failAPI}); err == nil {
+		t.Errorf("unexpected success without a database")
+	}
+
+	// The database is far older than the update period, but still usable.
+	save(now.Add(-24*time.Hour), "evil.com/")
+	wr, err := NewUpdateClient(Config{
+		DBPath:      path,
+		ReadOnly:    true,
+		ThreatLists: []ThreatType{ThreatTypeMalware},
+		api:         failAPI,
+		now:         func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+
+	threats, err := wr.LookupURLs([]string{"http://evil.com/", "http://good.com/"})
+	if err != nil {
+		t.Fatalf("unexpected lookup error: %v", err)
+	}
+	want := [][]URLThreat{{{Pattern: "evil.com/", ThreatType: ThreatTypeMalware}}, nil}
+	if !cmp.Equal(threats, want) {
+		t.Errorf("LookupURLs = %v, want %v", threats, want)
+	}
+
+	// An older database is not reloaded, a newer one is.
+	save(now.Add(-48*time.Hour), "good.com/")
+	if ok, err := wr.db.Reload(); ok || err != nil {
+		t.Errorf("Reload = (%v, %v), want (false, nil)", ok, err)
+	}
+	save(now, "good.com/")
+	if ok, err := wr.db.Reload(); !ok || err != nil {
+		t.Errorf("Reload = (%v, %v), want (true, nil)", ok, err)
+	}
+	threats, err = wr.LookupURLs([]string{"http://evil.com/", "http://good.com/"})
+	if err != nil {
+		t.Fatalf("unexpected lookup error: %v", err)
+	}
+	want = [][]URLThreat{nil, {{Pattern: "good.com/", ThreatType: ThreatTypeMalware}}}
+	if !cmp.Equal(threats, want) {
+		t.Errorf("LookupURLs = %v, want %v", threats, want)
+	}
+}
+
+// countingStore counts how often the database is loaded and saved.
+type countingStore struct {
+	*fileStore
+	loads int32
+	saves int32
+}
+
+func (cs *countingStore) Load(ctx context.Context) ([]byte, error) {
+	atomic.AddInt32(&cs.loads, 1)
+	return cs.fileStore.Load(ctx)
+}
+
+func (cs *countingStore) Save(ctx context.Context, data []byte) error {
+	atomic.AddInt32(&cs.saves, 1)
+	return cs.fileStore.Save(ctx, data)
+}
+
+func TestReadOnlyReloadPeriod(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	save := func(last time.Time, pattern string) {
+		phs := hashPrefixes{hashFromPattern(pattern)[:minHashPrefixLength]}
+		dbf := databaseFormat{
+			Table: threatsForUpdate{
+				ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+			},
+			Time: last,
+		}
+		if err := saveDatabase(path, dbf); err != nil {
+			t.Fatalf("unexpected save error: %v", err)
+		}
+	}
+	now := time.Now()
+	save(now.Add(-time.Hour), "evil.com/")
+	cs := &countingStore{fileStore: &fileStore{path: path}}
+	wr, err := NewUpdateClient(Config{
+		Store:        cs,
+		ReadOnly:     true,
+		ReloadPeriod: 10 * time.Millisecond,
+		ThreatLists:  []ThreatType{ThreatTypeMalware},
+		api:          &mockAPI{},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+	lookup := func(url string) bool {
+		threats, err := wr.LookupURLs([]string{url})
+		if err != nil {
+			t.Fatalf("unexpected lookup error: %v", err)
+		}
+		return len(threats[0]) > 0
+	}
+
+	// An unchanged file is not read again.
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&cs.loads); n != 1 {
+		t.Errorf("database loaded %d times, want 1", n)
+	}
+
+	// A replaced file is picked up without further action.
+	save(now, "good.com/")
+	deadline := time.Now().Add(5 * time.Second)
+	for !lookup("http://good.com/") {
+		if time.Now().After(deadline) {
+			t.Fatalf("database was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if lookup("http://evil.com/") {
+		t.Errorf("old database still in use after reload")
+	}
+}
+
+func TestCacheRefresh(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	start := time.Now()
+	phs := hashPrefixes{hashFromPattern("evil.com/")[:minHashPrefixLength]}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: start,
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+
+	var elapsed int64
+	now := func() time.Time { return start.Add(time.Duration(atomic.LoadInt64(&elapsed))) }
+	var calls int32
+	api := &mockAPI{
+		hashLookup: func(context.Context, []byte, []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+			atomic.AddInt32(&calls, 1)
+			expire := timepb.New(now().Add(10 * time.Minute))
+			return &pb.SearchHashesResponse{
+				Threats: []*pb.SearchHashesResponse_ThreatHash{{
+					ThreatTypes: []pb.ThreatType{pb.ThreatType_MALWARE},
+					Hash:        []byte(hashFromPattern("evil.com/")),
+					ExpireTime:  expire,
+				}},
+				NegativeExpireTime: expire,
+			}, nil
+		},
+	}
+	wr, err := NewUpdateClient(Config{
+		DBPath:             path,
+		ThreatLists:        []ThreatType{ThreatTypeMalware},
+		CacheRefreshWindow: time.Minute,
+		api:                api,
+		now:                now,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+	lookup := func() {
+		threats, err := wr.LookupURLs([]string{"http://evil.com/"})
+		if err != nil {
+			t.Fatalf("unexpected lookup error: %v", err)
+		}
+		want := [][]URLThreat{{{Pattern: "evil.com/", ThreatType: ThreatTypeMalware}}}
+		if !cmp.Equal(threats, want) {
+			t.Errorf("LookupURLs = %v, want %v", threats, want)
+		}
+	}
+	refreshing := func() bool {
+		wr.flights.mu.Lock()
+		defer wr.flights.mu.Unlock()
+		return len(wr.flights.calls) > 0
+	}
+
+	vectors := []struct {
+		elapsed   time.Duration
+		calls     int32
+		refreshes int64
+	}{
+		{0, 1, 0},                              // Cache miss
+		{5 * time.Minute, 1, 0},                // Cache hit
+		{9*time.Minute + 30*time.Second, 2, 1}, // Cache hit, refreshed in the background
+		{10 * time.Minute, 2, 1},               // Cache hit on the refreshed entry
+	}
+	for i, v := range vectors {
+		atomic.StoreInt64(&elapsed, int64(v.elapsed))
+		lookup()
+		deadline := time.Now().Add(5 * time.Second)
+		for refreshing() {
+			if time.Now().After(deadline) {
+				t.Fatalf("test %d, refresh did not finish", i)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if n := atomic.LoadInt32(&calls); n != v.calls {
+			t.Errorf("test %d, HashLookup called %d times, want %d", i, n, v.calls)
+		}
+		stats, _ := wr.Status()
+		if stats.CacheRefreshes != v.refreshes {
+			t.Errorf("test %d, CacheRefreshes = %d, want %d", i, stats.CacheRefreshes, v.refreshes)
+		}
+	}
+}
+
+func TestWarmCache(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	var phs hashPrefixes
+	for _, p := range []string{"evil.com/", "bad.com/"} {
+		phs = append(phs, hashFromPattern(p)[:minHashPrefixLength])
+	}
+	phs.Sort()
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: time.Now(),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	var calls int32
+	wr, err := NewUpdateClient(Config{
+		DBPath:      path,
+		ThreatLists: []ThreatType{ThreatTypeMalware},
+		api: &mockAPI{
+			hashLookup: func(context.Context, []byte, []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+				atomic.AddInt32(&calls, 1)
+				return &pb.SearchHashesResponse{NegativeExpireTime: timepb.New(time.Now().Add(time.Hour))}, nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+
+	n, err := wr.WarmCache(context.Background(), []string{"http://evil.com/", "/invalid", "http://good.com/", "http://bad.com/"})
+	if n != 3 || err != nil {
+		t.Errorf("WarmCache = (%d, %v), want (3, nil)", n, err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("HashLookup called %d times while warming, want 2", n)
+	}
+
+	// The warmed URLs are answered by the cache.
+	if _, err := wr.LookupURLs([]string{"http://evil.com/", "http://bad.com/"}); err != nil {
+		t.Fatalf("unexpected lookup error: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("HashLookup called %d times after warming, want 2", n)
+	}
+}
+
+func TestInspectCache(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	now := time.Unix(1451436338, 951473000)
+	evil := hashFromPattern("evil.com/")
+	phs := hashPrefixes{evil[:minHashPrefixLength]}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: now,
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	expire := timepb.New(now.Add(time.Hour))
+	wr, err := NewUpdateClient(Config{
+		DBPath:      path,
+		ThreatLists: []ThreatType{ThreatTypeMalware},
+		api: &mockAPI{
+			hashLookup: func(context.Context, []byte, []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+				return &pb.SearchHashesResponse{
+					Threats: []*pb.SearchHashesResponse_ThreatHash{{
+						ThreatTypes: []pb.ThreatType{pb.ThreatType_MALWARE},
+						Hash:        []byte(evil),
+						ExpireTime:  expire,
+					}},
+					NegativeExpireTime: expire,
+				}, nil
+			},
+		},
+		now: func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+
+	want := []CachedHash{{
+		Pattern:         "evil.com/",
+		Hash:            []byte(evil),
+		DatabaseThreats: []ThreatType{ThreatTypeMalware},
+	}}
+	got, err := wr.InspectCache("http://evil.com/")
+	if err != nil || !cmp.Equal(got, want) {
+		t.Errorf("InspectCache before lookup = (%v, %v), want (%v, nil)", got, err, want)
+	}
+
+	if _, err := wr.LookupURLs([]string{"http://evil.com/"}); err != nil {
+		t.Fatalf("unexpected lookup error: %v", err)
+	}
+	stats, _ := wr.Status()
+	want[0].Threats = map[ThreatType]time.Time{ThreatTypeMalware: expire.AsTime()}
+	want[0].NegativePrefix = []byte(evil[:minHashPrefixLength])
+	want[0].NegativeExpire = expire.AsTime()
+	got, err = wr.InspectCache("http://evil.com/")
+	if err != nil || !cmp.Equal(got, want) {
+		t.Errorf("InspectCache after lookup = (%v, %v), want (%v, nil)", got, err, want)
+	}
+	if after, _ := wr.Status(); after.CachePositiveHits != stats.CachePositiveHits || after.CacheMisses != stats.CacheMisses {
+		t.Errorf("InspectCache changed the cache statistics")
+	}
+}
+
+func TestExplainURL(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	evil := hashFromPattern("evil.com/")
+	phs := hashPrefixes{evil[:minHashPrefixLength]}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: time.Now(),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	expire := timepb.New(time.Now().Add(time.Hour))
+	wr, err := NewUpdateClient(Config{
+		DBPath:      path,
+		ThreatLists: []ThreatType{ThreatTypeMalware},
+		Schemes:     map[string]SchemeAction{"ftp": SchemePass, "gopher": SchemeReject},
+		api: &mockAPI{
+			hashLookup: func(context.Context, []byte, []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+				return &pb.SearchHashesResponse{
+					Threats: []*pb.SearchHashesResponse_ThreatHash{{
+						ThreatTypes: []pb.ThreatType{pb.ThreatType_MALWARE},
+						Hash:        []byte(evil),
+						ExpireTime:  expire,
+					}},
+					NegativeExpireTime: expire,
+				}, nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+
+	page := hashFromPattern("evil.com/Page")
+	want := &URLExplanation{
+		URL:          "http://EVIL.com/Page#top",
+		CanonicalURL: "http://evil.com/Page",
+		Expressions: []Expression{{
+			Pattern:    "evil.com/",
+			Hash:       []byte(evil),
+			HashPrefix: []byte(evil[:minHashPrefixLength]),
+
+			DatabasePrefix:  []byte(evil[:minHashPrefixLength]),
+			DatabaseThreats: []ThreatType{ThreatTypeMalware},
+			Threats:         []ThreatType{ThreatTypeMalware},
+		}, {
+			Pattern:    "evil.com/Page",
+			Hash:       []byte(page),
+			HashPrefix: []byte(page[:minHashPrefixLength]),
+		}},
+	}
+	got, err := wr.ExplainURL(context.Background(), want.URL)
+	if err != nil || !cmp.Equal(got, want) {
+		t.Errorf("ExplainURL = (%+v, %v), want (%+v, nil)", got, err, want)
+	}
+
+	want = &URLExplanation{URL: "ftp://evil.com/", Scheme: SchemePass}
+	if got, err := wr.ExplainURL(context.Background(), want.URL); err != nil || !cmp.Equal(got, want) {
+		t.Errorf("ExplainURL = (%+v, %v), want (%+v, nil)", got, err, want)
+	}
+	if _, err := wr.ExplainURL(context.Background(), "gopher://evil.com/"); err != ErrSchemeRejected {
+		t.Errorf("ExplainURL error = %v, want %v", err, ErrSchemeRejected)
+	}
+}
+
+func TestDisableCache(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	phs := hashPrefixes{hashFromPattern("evil.com/")[:minHashPrefixLength]}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: time.Now(),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	var calls int32
+	wr, err := NewUpdateClient(Config{
+		DBPath:       path,
+		ThreatLists:  []ThreatType{ThreatTypeMalware},
+		DisableCache: true,
+		Cache:        &mapCache{},
+		api: &mockAPI{
+			hashLookup: func(context.Context, []byte, []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+				atomic.AddInt32(&calls, 1)
+				return &pb.SearchHashesResponse{NegativeExpireTime: timepb.New(time.Now().Add(time.Hour))}, nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, err := wr.LookupURLs([]string{"http://evil.com/"}); err != nil {
+			t.Fatalf("unexpected lookup error: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("HashLookup called %d times, want 2", n)
+	}
+	if stats, _ := wr.Status(); stats.QueriesByCache != 0 || stats.CacheMemory != 0 || stats.CacheNegativeEntries != 0 {
+		t.Errorf("disabled cache was used: %+v", stats)
+	}
+	if _, err := wr.WarmCache(context.Background(), []string{"http://evil.com/"}); err == nil {
+		t.Errorf("unexpected success warming a disabled cache")
+	}
+}
+
+func TestIPHostThreat(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	phs := hashPrefixes{hashFromPattern("195.127.0.11/")[:minHashPrefixLength]}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: time.Now(),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	wr, err := NewUpdateClient(Config{
+		DBPath:       path,
+		ThreatLists:  []ThreatType{ThreatTypeMalware},
+		ReadOnly:     true,
+		IPHostThreat: ThreatTypeSocialEngineering,
+		Schemes:      map[string]SchemeAction{"ftp": SchemePass},
+		api:          &mockAPI{},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+
+	vectors := []struct {
+		url     string
+		threats []URLThreat
+	}{
+		{"http://3279880203/a", []URLThreat{
+			{"195.127.0.11/", ThreatTypeSocialEngineering},
+			{"195.127.0.11/", ThreatTypeMalware},
+		}},
+		{"http://[FEDC::1]:80/", []URLThreat{{"[fedc::1]/", ThreatTypeSocialEngineering}}},
+		{"http://example.com/", nil},
+		{"http://1.2.3.4.5/", nil},
+		{"ftp://1.2.3.4/", nil},
+	}
+	for i, v := range vectors {
+		threats, err := wr.LookupURLs([]string{v.url})
+		if err != nil {
+			t.Errorf("test %d, unexpected error: %v", i, err)
+			continue
+		}
+		if !cmp.Equal(threats[0], v.threats) {
+			t.Errorf("test %d, LookupURLs(%q) = %v, want %v", i, v.url, threats[0], v.threats)
+		}
+	}
+}
+
+func TestParseThreatListArg(t *testing.T) {
+	vectors := []struct {
+		arg           string
+		ignoreUnknown bool
+		lists         []ThreatType
+		aliases       map[ThreatType]ThreatType
+		unknown       []string
+		fail          bool
+	}{
+		{arg: "MALWARE,SOCIAL_ENGINEERING", lists: []ThreatType{ThreatTypeMalware, ThreatTypeSocialEngineering}},
+		{arg: "MALWARE,SOCIAL_ENGINEERING_EXTENDED_COVERAGE=SOCIAL_ENGINEERING",
+			lists:   []ThreatType{ThreatTypeMalware, ThreatTypeSocialEngineeringExtended},
+			aliases: map[ThreatType]ThreatType{ThreatTypeSocialEngineeringExtended: ThreatTypeSocialEngineering}},
+		{arg: "ALL=MALWARE", fail: true},
+		{arg: "MALWARE=PHISHING", fail: true},
+		{arg: "MALWARE=", fail: true},
+		{arg: "MALWARE,PHISHING", fail: true},
+		{arg: "MALWARE,PHISHING,CRYPTOJACKING=MALWARE,UNWANTED_SOFTWARE=PUA", ignoreUnknown: true,
+			lists: []ThreatType{ThreatTypeMalware}, unknown: []string{"PHISHING", "CRYPTOJACKING=MALWARE", "UNWANTED_SOFTWARE=PUA"}},
+		{arg: "all,PHISHING", ignoreUnknown: true, lists: DefaultThreatLists, unknown: []string{"PHISHING"}},
+		{arg: "PHISHING", ignoreUnknown: true, fail: true},
+	}
+	for i, v := range vectors {
+		lists, aliases, unknown, err := parseThreatListArg(v.arg, v.ignoreUnknown)
+		if err != nil != v.fail {
+			t.Errorf("test %d, parseThreatListArg(%q) error = %v, want failure %v", i, v.arg, err, v.fail)
+			continue
+		}
+		if !cmp.Equal(lists, v.lists) || !cmp.Equal(aliases, v.aliases) || (!v.fail && !cmp.Equal(unknown, v.unknown)) {
+			t.Errorf("test %d, parseThreatListArg(%q) = %v, %v, %v, want %v, %v, %v", i, v.arg, lists, aliases, unknown, v.lists, v.aliases, v.unknown)
+		}
+	}
+}
+
+func TestThreatTypeAliases(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	se := hashPrefixes{hashFromPattern("evil.com/")[:minHashPrefixLength]}
+	seec := hashPrefixes{hashFromPattern("evil.com/")[:minHashPrefixLength], hashFromPattern("shady.com/")[:minHashPrefixLength]}
+	seec.Sort()
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeSocialEngineering:         partialHashes{Hashes: se, SHA256: se.SHA256(), State: []byte("state")},
+			ThreatTypeSocialEngineeringExtended: partialHashes{Hashes: seec, SHA256: seec.SHA256(), State: []byte("state")},
+		},
+		Time: time.Now(),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+
+	vectors := []struct {
+		arg     string
+		threats [][]URLThreat
+	}{
+		{"SOCIAL_ENGINEERING,SOCIAL_ENGINEERING_EXTENDED_COVERAGE", [][]URLThreat{
+			{{"evil.com/", ThreatTypeSocialEngineering}, {"evil.com/", ThreatTypeSocialEngineeringExtended}},
+			{{"shady.com/", ThreatTypeSocialEngineeringExtended}},
+		}},
+		{"SOCIAL_ENGINEERING,SOCIAL_ENGINEERING_EXTENDED_COVERAGE=SOCIAL_ENGINEERING", [][]URLThreat{
+			{{"evil.com/", ThreatTypeSocialEngineering}},
+			{{"shady.com/", ThreatTypeSocialEngineering}},
+		}},
+	}
+	for i, v := range vectors {
+		wr, err := NewUpdateClient(Config{
+			DBPath:        path,
+			ThreatListArg: v.arg,
+			ReadOnly:      true,
+			api:           &mockAPI{},
+		})
+		if err != nil {
+			t.Fatalf("test %d, unexpected error: %v", i, err)
+		}
+		threats, err := wr.LookupURLs([]string{"http://evil.com/", "http://shady.com/"})
+		wr.Close()
+		if err != nil {
+			t.Errorf("test %d, unexpected error: %v", i, err)
+			continue
+		}
+		sortThreats := cmpopts.SortSlices(func(a, b URLThreat) bool { return a.ThreatType < b.ThreatType })
+		if !cmp.Equal(threats, v.threats, sortThreats) {
+			t.Errorf("test %d, LookupURLs() = %v, want %v", i, threats, v.threats)
+		}
+	}
+}
+
+func TestLookupURLsSources(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	phs := hashPrefixes{hashFromPattern("evil.com/")[:minHashPrefixLength]}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: time.Now(),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	wr, err := NewUpdateClient(Config{
+		DBPath:      path,
+		ThreatLists: []ThreatType{ThreatTypeMalware},
+		api: &mockAPI{
+			hashLookup: func(context.Context, []byte, []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+				return &pb.SearchHashesResponse{NegativeExpireTime: timepb.New(time.Now().Add(time.Hour))}, nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+
+	urls := []string{"http://safe.com/", "http://evil.com/", "http://a.evil.com/"}
+	for _, want := range [][]LookupSource{
+		{SourceDatabase, SourceAPI, SourceAPI},
+		{SourceDatabase, SourceCache, SourceCache},
+	} {
+		_, sources, err := wr.LookupURLsSources(context.Background(), urls)
+		if err != nil {
+			t.Fatalf("unexpected lookup error: %v", err)
+		}
+		if !cmp.Equal(sources, want) {
+			t.Errorf("sources = %v, want %v", sources, want)
+		}
+	}
+}
+
+func TestLookupUnverified(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	evil, bad := hashFromPattern("evil.com/"), hashFromPattern("bad.com/")
+	phs := hashPrefixes{evil[:minHashPrefixLength], bad[:minHashPrefixLength]}
+	phs.Sort()
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: time.Now(),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	errAPI := errors.New("api unavailable")
+	wr, err := NewUpdateClient(Config{
+		DBPath:      path,
+		ThreatLists: []ThreatType{ThreatTypeMalware},
+		api: &mockAPI{
+			hashLookup: func(_ context.Context, prefix []byte, _ []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+				if bytes.Equal(prefix, []byte(evil[:minHashPrefixLength])) {
+					return nil, errAPI
+				}
+				return &pb.SearchHashesResponse{
+					Threats: []*pb.SearchHashesResponse_ThreatHash{{
+						ThreatTypes: []pb.ThreatType{pb.ThreatType_MALWARE},
+						Hash:        []byte(bad),
+						ExpireTime:  timepb.New(time.Now().Add(time.Hour)),
+					}},
+					NegativeExpireTime: timepb.New(time.Now().Add(time.Hour)),
+				}, nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The failure of the API only leaves the URL that needed it unverified.
+	urls := []string{"http://safe.com/", "http://evil.com/", "http://bad.com/"}
+	threats, sources, err := wr.LookupURLsSources(context.Background(), urls)
+	if !errors.Is(err, errAPI) {
+		t.Errorf("lookup error = %v, want %v", err, errAPI)
+	}
+	if want := []LookupSource{SourceDatabase, SourceUnverified, SourceAPI}; !cmp.Equal(sources, want) {
+		t.Errorf("sources = %v, want %v", sources, want)
+	}
+	if want := [][]URLThreat{nil, nil, {{Pattern: "bad.com/", ThreatType: ThreatTypeMalware}}}; !cmp.Equal(threats, want) {
+		t.Errorf("threats = %v, want %v", threats, want)
+	}
+
+	// Nothing is known once the client is closed.
+	wr.Close()
+	_, sources, err = wr.LookupURLsSources(context.Background(), urls)
+	if err == nil {
+		t.Errorf("lookup of a closed client succeeded")
+	}
+	if want := []LookupSource{SourceUnverified, SourceUnverified, SourceUnverified}; !cmp.Equal(sources, want) {
+		t.Errorf("sources of a closed client = %v, want %v", sources, want)
+	}
+}
+
+func TestLookupHashes(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+
+	evil := hashFromPattern("evil.com/")
+	phs := hashPrefixes{evil[:minHashPrefixLength]}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: time.Now(),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	var prefixes []string
+	api := &mockAPI{
+		hashLookup: func(_ context.Context, prefix []byte, _ []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+			prefixes = append(prefixes, string(prefix))
+			return &pb.SearchHashesResponse{
+				Threats: []*pb.SearchHashesResponse_ThreatHash{{
+					ThreatTypes: []pb.ThreatType{pb.ThreatType_MALWARE},
+					Hash:        []byte(evil),
+					ExpireTime:  timepb.New(time.Now().Add(time.Hour)),
+				}},
+				NegativeExpireTime: timepb.New(time.Now().Add(time.Hour)),
+			}, nil
+		},
+	}
+	safe := hashFromPattern("safe.com/")
+	evilHex := hex.EncodeToString([]byte(evil))
+	hashes := [][]byte{[]byte(safe), []byte(evil), []byte(evil[:6]), []byte(evil[:4] + "xx")}
+
+	vectors := []struct {
+		readOnly bool
+		threats  [][]URLThreat
+		sources  []LookupSource
+	}{{
+		threats: [][]URLThreat{
+			nil,
+			{{Pattern: evilHex, ThreatType: ThreatTypeMalware}},
+			{{Pattern: evilHex, ThreatType: ThreatTypeMalware}},
+			nil,
+		},
+		sources: []LookupSource{SourceDatabase, SourceAPI, SourceAPI, SourceAPI},
+	}, {
+		readOnly: true,
+		threats: [][]URLThreat{
+			nil,
+			{{Pattern: evilHex, ThreatType: ThreatTypeMalware}},
+			{{Pattern: evilHex[:12], ThreatType: ThreatTypeMalware}},
+			{{Pattern: evilHex[:8] + "7878", ThreatType: ThreatTypeMalware}},
+		},
+		sources: []LookupSource{SourceDatabase, SourceDatabase, SourceDatabase, SourceDatabase},
+	}}
+	for i, v := range vectors {
+		prefixes = nil
+		wr, err := NewUpdateClient(Config{
+			DBPath:      path,
+			ThreatLists: []ThreatType{ThreatTypeMalware},
+			ReadOnly:    v.readOnly,
+			api:         api,
+		})
+		if err != nil {
+			t.Fatalf("test %d, unexpected error: %v", i, err)
+		}
+		threats, sources, err := wr.LookupHashes(context.Background(), hashes)
+		wr.Close()
+		if err != nil {
+			t.Fatalf("test %d, unexpected lookup error: %v", i, err)
+		}
+		if !cmp.Equal(threats, v.threats) {
+			t.Errorf("test %d, threats = %v, want %v", i, threats, v.threats)
+		}
+		if !cmp.Equal(sources, v.sources) {
+			t.Errorf("test %d, sources = %v, want %v", i, sources, v.sources)
+		}
+		// Prefixes are searched for as given, rather than as listed.
+		if want := 3; !v.readOnly && len(prefixes) != want {
+			t.Errorf("test %d, %d hash searches, want %d", i, len(prefixes), want)
+		}
+	}
+
+	wr, err := NewUpdateClient(Config{DBPath: path, ThreatLists: []ThreatType{ThreatTypeMalware}, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+	if _, _, err := wr.LookupHashes(context.Background(), [][]byte{[]byte("abc")}); err == nil {
+		t.Errorf("unexpected success with a short hash prefix")
+	}
+}
+
+func TestUpdateAndExportDatabase(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+	exportPath := mustGetTempFile(t)
+	defer os.Remove(exportPath)
+
+	phs := hashPrefixes{hashFromPattern("evil.com/")[:minHashPrefixLength]}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: time.Now(),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	var tokens []string
+	wr, err := NewUpdateClient(Config{
+		DBPath:      path,
+		ThreatLists: []ThreatType{ThreatTypeMalware},
+		api: &mockAPI{
+			listUpdate: func(_ context.Context, _ pb.ThreatType, token []byte, _ *pb.ComputeThreatListDiffRequest_Constraints) (*pb.ComputeThreatListDiffResponse, error) {
+				tokens = append(tokens, string(token))
+				return &pb.ComputeThreatListDiffResponse{
+					ResponseType:    pb.ComputeThreatListDiffResponse_DIFF,
+					NewVersionToken: append(token, '+'),
+					Checksum:        &pb.ComputeThreatListDiffResponse_Checksum{Sha256: phs.SHA256()},
+				}, nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr.Close()
+
+	// The database is fresh, so it is only updated when asked to.
+	if err := wr.UpdateDatabase(context.Background()); err != nil {
+		t.Fatalf("unexpected update error: %v", err)
+	}
+	if want := []string{"state"}; !cmp.Equal(tokens, want) {
+		t.Errorf("version tokens = %q, want %q", tokens, want)
+	}
+
+	f, err := os.Create(exportPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := wr.ExportDatabase(f); err != nil {
+		t.Fatalf("unexpected export error: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	snap, err := ReadDatabase(context.Background(), &fileStore{path: exportPath})
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	want := map[ThreatType]*ListSnapshot{
+		ThreatTypeMalware: {
+			Prefixes:     []string{string(phs[0])},
+			SHA256:       phs.SHA256(),
+			VersionToken: []byte("state+"),
+		},
+	}
+	if !cmp.Equal(snap.Lists, want) {
+		t.Errorf("exported lists = %v, want %v", snap.Lists, want)
+	}
+
+	// The snapshot of the database in memory has the same lists, and is
+	// made once per update.
+	mem, err := wr.SnapshotDatabase()
+	if err != nil {
+		t.Fatalf("unexpected snapshot error: %v", err)
+	}
+	if !cmp.Equal(mem.Lists, want) {
+		t.Errorf("snapshot lists = %v, want %v", mem.Lists, want)
+	}
+	if again, _ := wr.SnapshotDatabase(); again != mem {
+		t.Errorf("SnapshotDatabase made a new snapshot of the same database")
+	}
+	if err := wr.UpdateDatabase(context.Background()); err != nil {
+		t.Fatalf("unexpected update error: %v", err)
+	}
+	if again, _ := wr.SnapshotDatabase(); again == mem || string(again.Lists[ThreatTypeMalware].VersionToken) != "state++" {
+		t.Errorf("SnapshotDatabase after an update = %v, want version state++", again.Lists[ThreatTypeMalware])
+	}
+
+	wr.Close()
+	if err := wr.UpdateDatabase(context.Background()); err != errClosed {
+		t.Errorf("update after close = %v, want %v", err, errClosed)
+	}
+}
+
+func TestReadOnlyIfLocked(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+	defer os.Remove(path + ".lock")
+
+	phs := hashPrefixes{hashFromPattern("evil.com/")[:minHashPrefixLength]}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: time.Now(),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	newClient := func(readOnlyIfLocked bool) (*UpdateClient, error) {
+		return NewUpdateClient(Config{
+			DBPath:           path,
+			ReadOnlyIfLocked: readOnlyIfLocked,
+			ThreatLists:      []ThreatType{ThreatTypeMalware},
+			api:              &mockAPI{},
+		})
+	}
+
+	wr1, err := newClient(false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := newClient(false); err == nil {
+		wr1.Close()
+		t.Skip("advisory locks are not supported")
+	} else if !errors.Is(err, ErrStoreLocked) {
+		t.Errorf("NewUpdateClient = %v, want ErrStoreLocked", err)
+	}
+
+	wr2, err := newClient(true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !wr2.config.ReadOnly {
+		t.Errorf("second client is not read-only")
+	}
+	wr2.Close()
+
+	// Once the first client is closed, the database can be updated again.
+	wr1.Close()
+	wr3, err := newClient(false)
+	if err != nil {
+		t.Fatalf("unexpected error after close: %v", err)
+	}
+	if wr3.config.ReadOnly {
+		t.Errorf("third client is read-only")
+	}
+	wr3.Close()
+}
+
+func TestRelease(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+	defer os.Remove(path + ".lock")
+
+	phs := hashPrefixes{hashFromPattern("evil.com/")[:minHashPrefixLength]}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: time.Now(),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	newClient := func() (*UpdateClient, error) {
+		return NewUpdateClient(Config{
+			DBPath:      path,
+			ThreatLists: []ThreatType{ThreatTypeMalware},
+			api: &mockAPI{
+				listUpdate: func(context.Context, pb.ThreatType, []byte, *pb.ComputeThreatListDiffRequest_Constraints) (*pb.ComputeThreatListDiffResponse, error) {
+					return nil, errors.New("unavailable")
+				},
+			},
+		})
+	}
+
+	wr1, err := newClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer wr1.Close()
+	if err := wr1.Release(context.Background()); err != nil {
+		t.Fatalf("Release = %v", err)
+	}
+	if err := wr1.Release(context.Background()); err != nil {
+		t.Errorf("second Release = %v", err)
+	}
+
+	// Once released, the database can be taken over by another client, while
+	// the first one keeps serving lookups without updating it.
+	wr2, err := newClient()
+	if err != nil {
+		t.Fatalf("unexpected error after release: %v", err)
+	}
+	defer wr2.Close()
+	if wr2.config.ReadOnly {
+		t.Errorf("second client is read-only")
+	}
+	if err := wr1.UpdateDatabase(context.Background()); err != errReleased {
+		t.Errorf("update after release = %v, want %v", err, errReleased)
+	}
+	threats, err := wr1.LookupURLs([]string{"good.com/"})
+	if err != nil || len(threats[0]) != 0 {
+		t.Errorf("lookup after release = (%v, %v), want no threats", threats, err)
+	}
+
+	// The database can only be taken back once the other client is done
+	// with it.
+	if err := wr1.Resume(context.Background()); !errors.Is(err, ErrStoreLocked) {
+		t.Errorf("Resume while another client holds the database = %v, want ErrStoreLocked", err)
+	}
+	wr2.Close()
+	if err := wr1.Resume(context.Background()); err != nil {
+		t.Errorf("Resume = %v", err)
+	}
+	if err := wr1.UpdateDatabase(context.Background()); err == nil || err == errReleased {
+		t.Errorf("update after resume = %v, want the API error", err)
+	}
+	if _, err := newClient(); !errors.Is(err, ErrStoreLocked) {
+		t.Errorf("NewUpdateClient after resume = %v, want ErrStoreLocked", err)
+	}
+
+	wr1.Close()
+	if err := wr1.Release(context.Background()); err != errClosed {
+		t.Errorf("Release after close = %v, want %v", err, errClosed)
+	}
+	if err := wr1.Resume(context.Background()); err != errClosed {
+		t.Errorf("Resume after close = %v, want %v", err, errClosed)
+	}
+}
+
+func TestCloseWaitsForUpdate(t *testing.T) {
+	path := mustGetTempFile(t)
+	defer os.Remove(path)
+	defer os.Remove(path + ".lock")
+
+	phs := hashPrefixes{hashFromPattern("evil.com/")[:minHashPrefixLength]}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: time.Now(),
+	}
+	if err := saveDatabase(path, dbf); err != nil {
+		t.Fatalf("unexpected save error: %v", err)
+	}
+	started, unblock := make(chan bool), make(chan bool)
+	newClient := func() (*UpdateClient, error) {
+		return NewUpdateClient(Config{
+			DBPath:      path,
+			ThreatLists: []ThreatType{ThreatTypeMalware},
+			api: &mockAPI{
+				listUpdate: func(context.Context, pb.ThreatType, []byte, *pb.ComputeThreatListDiffRequest_Constraints) (*pb.ComputeThreatListDiffResponse, error) {
+					close(started)
+					<-unblock
+					return nil, errors.New("unavailable")
+				},
+			},
+		})
+	}
+
+	wr, err := newClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	go wr.UpdateDatabase(context.Background())
+	<-started
+	closed := make(chan bool)
+	go func() {
+		wr.Close()
+		close(closed)
+	}()
+
+	// The store stays locked by the update in progress.
+	select {
+	case <-closed:
+		t.Fatalf("Close returned during an update")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := newClient(); err == nil {
+		t.Skip("advisory locks are not supported")
+	} else if !errors.Is(err, ErrStoreLocked) {
+		t.Errorf("NewUpdateClient during an update = %v, want ErrStoreLocked", err)
+	}
+
+	close(unblock)
+	<-closed
+	wr2, err := newClient()
+	if err != nil {
+		t.Fatalf("unexpected error after close: %v", err)
+	}
+	wr2.Close()
+}
+
+func TestThreatTypeText(t *testing.T) {
+	b, err := json.Marshal(map[ThreatType]ThreatType{ThreatTypeMalware: ThreatTypeUnwantedSoftware})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := string(b), `{"MALWARE":"UNWANTED_SOFTWARE"}`; got != want {
+		t.Errorf("json.Marshal = %s, want %s", got, want)
+	}
+	var m map[ThreatType]ThreatType
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m[ThreatTypeMalware] != ThreatTypeUnwantedSoftware {
+		t.Errorf("json.Unmarshal = %v", m)
+	}
+	if err := json.Unmarshal([]byte(`"BOGUS"`), new(ThreatType)); err == nil {
+		t.Errorf("unexpected success decoding an unknown threat type")
+	}
+
+	// Every threat type round-trips through its name.
+	for _, tt := range DefaultThreatLists {
+		got, err := ParseThreatType(tt.String())
+		if err != nil || got != tt {
+			t.Errorf("ParseThreatType(%q) = (%v, %v), want %v", tt.String(), got, err, tt)
+		}
+	}
+	if got, want := ThreatType(42).String(), "ThreatType(42)"; got != want {
+		t.Errorf("String of an unknown threat type = %q, want %q", got, want)
+	}
+}
+
+func TestMaxDatabaseAge(t *testing.T) {
+	evil := hashFromPattern("evil.com/")
+	phs := hashPrefixes{evil[:minHashPrefixLength]}
+	dbf := databaseFormat{
+		Table: threatsForUpdate{
+			ThreatTypeMalware: partialHashes{Hashes: phs, SHA256: phs.SHA256(), State: []byte("state")},
+		},
+		Time: time.Now().Add(-2 * time.Hour),
+	}
+	errAPI := errors.New("unavailable")
+	api := &mockAPI{
+		listUpdate: func(context.Context, pb.ThreatType, []byte, *pb.ComputeThreatListDiffRequest_Constraints) (*pb.ComputeThreatListDiffResponse, error) {
+			return nil, errAPI
+		},
+		hashLookup: func(context.Context, []byte, []pb.ThreatType) (*pb.SearchHashesResponse, error) {
+			return &pb.SearchHashesResponse{
+				Threats: []*pb.SearchHashesResponse_ThreatHash{{
+					ThreatTypes: []pb.ThreatType{pb.ThreatType_MALWARE},
+					Hash:        []byte(evil),
+					ExpireTime:  timepb.New(time.Now().Add(time.Hour)),
+				}},
+			}, nil
+		},
+	}
+
+	vectors := []struct {
+		maxAge    time.Duration
+		policy    StalePolicy
+		statusErr error // Also the lookup error, unless served
+		served    bool
+	}{
+		// The database is kept despite the failed update, and still young
+		// enough.
+		{maxAge: 3 * time.Hour, policy: StaleFail, served: true},
+		{maxAge: time.Hour, policy: StaleFail, statusErr: ErrStale},
+		{maxAge: time.Hour, policy: StaleServe, statusErr: ErrStale, served: true},
+		// By default, the database is stale after two update periods, and
+		// cleared by the failed update.
+		{maxAge: 0, policy: StaleFail, statusErr: errAPI},
+	}
+	for i, v := range vectors {
+		path := mustGetTempFile(t)
+		defer os.Remove(path)
+		if err := saveDatabase(path, dbf); err != nil {
+			t.Fatalf("unexpected save error: %v", err)
+		}
+		wr, err := NewUpdateClient(Config{
+			DBPath:         path,
+			ThreatLists:    []ThreatType{ThreatTypeMalware},
+			MaxDatabaseAge: v.maxAge,
+			StalePolicy:    v.policy,
+			api:            api,
+		})
+		if err != nil {
+			t.Fatalf("test %d, unexpected error: %v", i, err)
+		}
+		if _, err := wr.Status(); !errors.Is(err, v.statusErr) {
+			t.Errorf("test %d, Status error = %v, want %v", i, err, v.statusErr)
+		}
+		threats, err := wr.LookupURLs([]string{"http://evil.com/"})
+		switch {
+		case v.served && (err != nil || len(threats[0]) != 1):
+			t.Errorf("test %d, LookupURLs = (%v, %v), want a threat", i, threats, err)
+		case !v.served && !errors.Is(err, v.statusErr):
+			t.Errorf("test %d, LookupURLs error = %v, want %v", i, err, v.statusErr)
+		}
+		wr.Close()
+	}
+}
+
+func TestParseStalePolicy(t *testing.T) {
+	vectors := []struct {
+		in   string
+		want StalePolicy
+		fail bool
+	}{
+		{in: "", want: StaleFail},
+		{in: "fail", want: StaleFail},
+		{in: "serve", want: StaleServe},
+		{in: "open", fail: true},
+	}
+	for i, v := range vectors {
+		got, err := ParseStalePolicy(v.in)
+		if (err != nil) != v.fail || got != v.want {
+			t.Errorf("test %d, ParseStalePolicy(%q) = (%v, %v), want %v", i, v.in, got, err, v.want)
 		}
 	}
 }
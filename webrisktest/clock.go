@@ -0,0 +1,129 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package webrisktest provides helpers for testing programs that use the
+// webrisk package.
+package webrisktest
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock is a fake webrisk.Clock, whose time only moves when Advance or Set
+// is called. Given as webrisk.Config.Clock, it lets tests expire cached
+// results, run scheduled updates, or make the database stale, without
+// sleeping:
+//
+//	clock := webrisktest.NewClock(time.Now())
+//	wr, err := webrisk.NewUpdateClient(webrisk.Config{..., Clock: clock})
+//	...
+//	clock.Advance(time.Hour) // Cached results of the last hour expire
+//
+// Since the UpdateClient schedules its work in the background, tests should
+// call BlockUntil before Advance, to make sure that the timers they expect
+// to fire have been set.
+type Clock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []timer
+	changed chan struct{} // Closed when a timer is added
+}
+
+// timer is a channel returned by After, waiting for the time at.
+type timer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// NewClock returns a Clock set to t.
+func NewClock(t time.Time) *Clock {
+	return &Clock{now: t, changed: make(chan struct{})}
+}
+
+// Now returns the time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the time of the clock once it has
+// been moved forward by d.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, timer{at: c.now.Add(d), ch: ch})
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return ch
+}
+
+// Advance moves the clock forward by d, firing the timers that are due.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	t := c.now.Add(d)
+	c.mu.Unlock()
+	c.Set(t)
+}
+
+// Set sets the clock to t, firing the timers that are due. The clock may be
+// set back, in which case no timer fires.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+	pending := c.timers[:0]
+	for _, tm := range c.timers {
+		if tm.at.After(t) {
+			pending = append(pending, tm)
+			continue
+		}
+		tm.ch <- t
+	}
+	for i := len(pending); i < len(c.timers); i++ {
+		c.timers[i] = timer{}
+	}
+	c.timers = pending
+}
+
+// Timers returns the number of timers that have not fired yet.
+func (c *Clock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil waits until at least n timers have not fired yet, or until ctx
+// is done.
+func (c *Clock) BlockUntil(ctx context.Context, n int) error {
+	for {
+		c.mu.Lock()
+		pending, changed := len(c.timers), c.changed
+		c.mu.Unlock()
+		if pending >= n {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2023 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package webrisktest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/webrisk"
+	"github.com/google/webrisk/webrisktest"
+)
+
+var _ webrisk.Clock = (*webrisktest.Clock)(nil)
+
+func TestClock(t *testing.T) {
+	start := time.Date(2023, 5, 24, 10, 0, 0, 0, time.UTC)
+	c := webrisktest.NewClock(start)
+
+	fired := func(ch <-chan time.Time) (time.Time, bool) {
+		select {
+		case t := <-ch:
+			return t, true
+		default:
+			return time.Time{}, false
+		}
+	}
+	if got, ok := fired(c.After(0)); !ok || !got.Equal(start) {
+		t.Errorf("After(0) = %v, %v, want %v, true", got, ok, start)
+	}
+	minute, hour := c.After(time.Minute), c.After(time.Hour)
+	if n := c.Timers(); n != 2 {
+		t.Errorf("Timers() = %d, want 2", n)
+	}
+
+	c.Advance(30 * time.Second)
+	if _, ok := fired(minute); ok {
+		t.Errorf("timer fired early")
+	}
+	c.Advance(30 * time.Second)
+	if got, ok := fired(minute); !ok || !got.Equal(start.Add(time.Minute)) {
+		t.Errorf("timer fired = %v, %v, want %v, true", got, ok, start.Add(time.Minute))
+	}
+	c.Set(start)
+	if got := c.Now(); !got.Equal(start) {
+		t.Errorf("Now() = %v, want %v", got, start)
+	}
+	c.Advance(2 * time.Hour)
+	if _, ok := fired(hour); !ok {
+		t.Errorf("timer did not fire")
+	}
+	if n := c.Timers(); n != 0 {
+		t.Errorf("Timers() = %d, want 0", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.BlockUntil(ctx, 1); err != context.DeadlineExceeded {
+		t.Errorf("BlockUntil error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	go c.After(time.Second)
+	if err := c.BlockUntil(context.Background(), 1); err != nil {
+		t.Errorf("BlockUntil error = %v", err)
+	}
+}